@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/approval"
+)
+
+// approvalTokenEnv names the environment variable holding the bearer token of the approval API.
+const approvalTokenEnv = "SECURE_SHELL_APPROVAL_TOKEN"
+
+// approvalsUsage describes the approvals subcommand.
+const approvalsUsage = "Usage: secure-shell approvals [-url URL] list | approve <id> | reject [-reason TEXT] <id>\n"
+
+// runApprovalsCommand lists, approves, or rejects commands waiting for approval.
+// Usage: secure-shell approvals [-url URL] list | approve <id> | reject [-reason TEXT] <id>
+func runApprovalsCommand(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("approvals", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	baseURL := flags.String("url", "http://127.0.0.1:8081", "Address of the server's approval API")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprint(stderr, approvalsUsage)
+		return 1
+	}
+
+	client := &approval.Client{BaseURL: *baseURL, Token: os.Getenv(approvalTokenEnv)}
+	ctx := context.Background()
+	rest := flags.Args()[1:]
+
+	var err error
+	switch flags.Arg(0) {
+	case "list":
+		err = listApprovals(ctx, client, stdout)
+	case "approve":
+		if len(rest) != 1 {
+			fmt.Fprint(stderr, approvalsUsage)
+			return 1
+		}
+		err = client.Approve(ctx, approval.ID(rest[0]))
+	case "reject":
+		rejectFlags := flag.NewFlagSet("reject", flag.ContinueOnError)
+		rejectFlags.SetOutput(stderr)
+		reason := rejectFlags.String("reason", "", "Reason returned to the caller")
+		if err := rejectFlags.Parse(rest); err != nil {
+			return 1
+		}
+		if rejectFlags.NArg() != 1 {
+			fmt.Fprint(stderr, approvalsUsage)
+			return 1
+		}
+		err = client.Reject(ctx, approval.ID(rejectFlags.Arg(0)), *reason)
+	default:
+		fmt.Fprint(stderr, approvalsUsage)
+		return 1
+	}
+
+	if errors.Is(err, approval.ErrNotFound) {
+		fmt.Fprintf(stderr, "Error: no pending request with that id (it may have timed out)\n")
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// listApprovals prints the pending requests as a table.
+func listApprovals(ctx context.Context, client *approval.Client, w io.Writer) error {
+	requests, err := client.List(ctx)
+	if err != nil {
+		return err
+	}
+	if len(requests) == 0 {
+		fmt.Fprintln(w, "No pending approvals")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0) //nolint:mnd // column padding
+	fmt.Fprintln(tw, "ID\tEXPIRES IN\tCALLER\tDIRECTORY\tCOMMAND\tREASON")
+	for _, req := range requests {
+		command := strings.Join(append([]string{req.Command}, req.Args...), " ")
+		expires := time.Until(req.ExpiresAt).Round(time.Second)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", req.ID, expires, req.Caller, req.WorkDir, command, req.Reason)
+	}
+	return tw.Flush()
+}
+
+// isApprovalsCommand reports whether the command line invokes the approvals subcommand.
+func isApprovalsCommand() bool {
+	return len(os.Args) > 1 && os.Args[1] == "approvals"
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/opa"
+)
+
+// runConfigCommand dispatches the "config" subcommands.
+func runConfigCommand(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintf(stderr, "Usage: secure-shell config lint [-config] <path>... | diff <old> <new> | schema | presets [name]\n")
+		return 1
+	}
+
+	switch args[0] {
+	case "lint":
+		return runConfigLint(args[1:], stdout, stderr)
+	case "diff":
+		return runConfigDiff(args[1:], stdout, stderr)
+	case "schema":
+		// Print the JSON Schema of the configuration format
+		fmt.Fprintf(stdout, "%s\n", config.JSONSchema())
+		return 0
+	case "presets":
+		return runConfigPresets(args[1:], stdout, stderr)
+	default:
+		fmt.Fprintf(stderr, "Error: unknown config subcommand %q\n", args[0])
+		return 1
+	}
+}
+
+// runConfigLint loads a configuration, layered from several files if more than one is given,
+// and reports every issue found by config.Validate and any OPA policy that does not compile.
+// It exits non-zero only when errors are found; warnings are printed but do not fail.
+func runConfigLint(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("config lint", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	var configPaths config.FileList
+	flags.Var(&configPaths, "config", "Path to the configuration file; repeat to layer overrides on a base file")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+	configPaths = append(configPaths, flags.Args()...)
+	if len(configPaths) == 0 {
+		fmt.Fprintf(stderr, "Error: Configuration file must be specified\n")
+		return 1
+	}
+	name := configPaths.String()
+
+	cfg, err := config.LoadConfigFromFiles(configPaths...)
+	if err != nil {
+		fmt.Fprintf(stdout, "%s: error: %v\n", name, err)
+		return 1
+	}
+
+	issues := config.Validate(cfg)
+	for _, issue := range issues {
+		fmt.Fprintf(stdout, "%s: %s\n", name, issue)
+	}
+	if config.HasErrors(issues) {
+		return 1
+	}
+	// Rego policies are compiled here, since the runner only reports them as failing evaluations
+	if cfg.OPA.Embedded() {
+		if _, err := opa.NewEngine(context.Background(), cfg.OPA); err != nil {
+			fmt.Fprintf(stdout, "%s: error: opa: %v\n", name, err)
+			return 1
+		}
+	}
+	if len(issues) == 0 {
+		fmt.Fprintf(stdout, "%s: ok\n", name)
+	}
+	return 0
+}
+
+// runConfigDiff reports how the policy in the new configuration is looser than the old one,
+// for reviewing changes to a policy. Either configuration may be layered from
+// comma-separated files. Like diff, it exits 0 when nothing was loosened, 1 when something
+// was, and 2 when a configuration cannot be loaded.
+func runConfigDiff(args []string, stdout, stderr io.Writer) int {
+	if len(args) != 2 {
+		fmt.Fprintf(stderr, "Usage: secure-shell config diff <old> <new>\n")
+		return 2
+	}
+	var policies [2]*config.ShellCommandConfig
+	for i, arg := range args {
+		var paths config.FileList
+		_ = paths.Set(arg)
+		cfg, err := config.LoadConfigFromFiles(paths...)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: error: %v\n", arg, err)
+			return 2
+		}
+		policies[i] = cfg
+	}
+
+	diff := config.Diff(policies[0], policies[1])
+	if diff.Empty() {
+		fmt.Fprintf(stdout, "%s permits nothing that %s does not\n", args[1], args[0])
+		return 0
+	}
+	sections := []struct {
+		title   string
+		changes []config.PolicyChange
+	}{
+		{"Newly allowed commands", diff.AllowedCommands},
+		{"Removed denials", diff.RemovedDenials},
+		{"Widened directories", diff.WidenedDirectories},
+		{"Loosened limits", diff.LoosenedLimits},
+	}
+	for _, section := range sections {
+		if len(section.changes) == 0 {
+			continue
+		}
+		fmt.Fprintf(stdout, "%s:\n", section.title)
+		for _, change := range section.changes {
+			fmt.Fprintf(stdout, "  %s\n", change)
+		}
+	}
+	return 1
+}
+
+// runConfigPresets lists the embedded presets, or prints the one named in args as a
+// starting point for a configuration file.
+func runConfigPresets(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		for _, name := range config.Presets() {
+			fmt.Fprintf(stdout, "%s%s@%d\n", config.PresetPrefix, name, config.PresetVersion)
+		}
+		return 0
+	}
+	data, err := config.PresetData(args[0])
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "%s", data)
+	return 0
+}
+
+// isConfigCommand reports whether the command line invokes a config subcommand.
+func isConfigCommand() bool {
+	return len(os.Args) > 1 && os.Args[1] == "config"
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"runtime"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/runner"
+)
+
+// terminalConfirmation returns a ConfirmationFunc prompting on the controlling terminal, so
+// that answers are not mixed up with the script's input and output, and the function closing
+// the terminal.
+func terminalConfirmation() (runner.ConfirmationFunc, func(), error) {
+	if runtime.GOOS == "windows" {
+		in, err := os.Open("CONIN$")
+		if err != nil {
+			return nil, nil, err
+		}
+		out, err := os.OpenFile("CONOUT$", os.O_WRONLY, 0)
+		if err != nil {
+			_ = in.Close()
+			return nil, nil, err
+		}
+		return runner.PromptConfirmation(in, out), func() { _ = in.Close(); _ = out.Close() }, nil
+	}
+
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return runner.PromptConfirmation(tty, tty), func() { _ = tty.Close() }, nil
+}
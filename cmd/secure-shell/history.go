@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/history"
+)
+
+// historyCallerCLI is the caller recorded for scripts run with -script.
+const historyCallerCLI = "cli"
+
+// historyUsage describes the history subcommand.
+const historyUsage = "Usage: secure-shell history (-db PATH | -config FILE) [-command NAME] [-caller ID] " +
+	"[-decision allowed|denied|would-deny] [-since DURATION] [-limit N] [-- ARGS...]\n"
+
+// runHistoryCommand searches the execution history. Arguments after the flags are hashed
+// and matched against the recorded argument hashes.
+// Usage: secure-shell history (-db PATH | -config FILE) [filters] [-- ARGS...]
+func runHistoryCommand(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("history", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	dbPath := flags.String("db", "", "Path to the history database")
+	var configPaths config.FileList
+	flags.Var(&configPaths, "config", "Configuration file whose historyPath is used when -db is not given; repeat to layer files")
+	command := flags.String("command", "", "Only show this command")
+	caller := flags.String("caller", "", "Only show commands run by this caller")
+	decision := flags.String("decision", "", `Only show "allowed", "denied", or "would-deny" commands`)
+	since := flags.Duration("since", 0, "Only show commands from this long ago until now, e.g. 24h")
+	limit := flags.Int("limit", history.DefaultLimit, "Maximum number of entries to show")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	if *dbPath == "" && len(configPaths) > 0 {
+		cfg, err := config.LoadConfigFromFiles(configPaths...)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error loading configuration file: %v\n", err)
+			return 1
+		}
+		*dbPath = cfg.HistoryPath
+	}
+	if *dbPath == "" {
+		fmt.Fprint(stderr, historyUsage)
+		return 1
+	}
+	switch history.Decision(*decision) {
+	case "", history.DecisionAllowed, history.DecisionDenied, history.DecisionWouldDeny:
+	default:
+		fmt.Fprint(stderr, historyUsage)
+		return 1
+	}
+	// Opening creates the database, which is not wanted when a path is mistyped
+	if _, err := os.Stat(*dbPath); err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	h, err := history.Open(*dbPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer h.Close()
+
+	filter := history.Filter{
+		Command:  *command,
+		Caller:   *caller,
+		Decision: history.Decision(*decision),
+		Limit:    *limit,
+	}
+	if *since > 0 {
+		filter.Since = time.Now().Add(-*since)
+	}
+	if flags.NArg() > 0 {
+		filter.ArgsHash = history.HashArgs(flags.Args())
+	}
+
+	entries, err := h.Search(context.Background(), filter)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	printHistory(stdout, entries)
+	return 0
+}
+
+// printHistory prints entries as a table.
+func printHistory(w io.Writer, entries []history.Entry) {
+	if len(entries) == 0 {
+		fmt.Fprintln(w, "No matching commands")
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0) //nolint:mnd // column padding
+	fmt.Fprintln(tw, "TIME\tCALLER\tDECISION\tEXIT\tDURATION\tDIRECTORY\tCOMMAND\tMESSAGE")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\n",
+			e.Time.Format(time.RFC3339), e.Caller, e.Decision, e.ExitCode, e.Duration.Round(time.Millisecond), e.WorkDir, e.Command, e.Message)
+	}
+	_ = tw.Flush()
+}
+
+// isHistoryCommand reports whether the command line invokes the history subcommand.
+func isHistoryCommand() bool {
+	return len(os.Args) > 1 && os.Args[1] == "history"
+}
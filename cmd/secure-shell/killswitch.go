@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/admin"
+	"github.com/shimizu1995/secure-shell-server/pkg/runner"
+)
+
+// adminTokenEnv names the environment variable holding the bearer token of the admin API.
+const adminTokenEnv = "SECURE_SHELL_ADMIN_TOKEN"
+
+// killSwitchUsage describes the killswitch subcommand.
+const killSwitchUsage = "Usage: secure-shell killswitch [-url URL] status | disable [-reason TEXT] [-terminate] | enable\n"
+
+// runKillSwitchCommand shows, turns on, or turns off the kill switch of a running server.
+// Usage: secure-shell killswitch [-url URL] status | disable [-reason TEXT] [-terminate] | enable
+func runKillSwitchCommand(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("killswitch", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	baseURL := flags.String("url", "http://127.0.0.1:8082", "Address of the server's admin API")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprint(stderr, killSwitchUsage)
+		return 1
+	}
+
+	client := &admin.Client{BaseURL: *baseURL, Token: os.Getenv(adminTokenEnv)}
+	ctx := context.Background()
+	rest := flags.Args()[1:]
+
+	var status runner.KillSwitchStatus
+	var err error
+	switch flags.Arg(0) {
+	case "status":
+		status, err = client.Status(ctx)
+	case "disable":
+		disableFlags := flag.NewFlagSet("disable", flag.ContinueOnError)
+		disableFlags.SetOutput(stderr)
+		reason := disableFlags.String("reason", "", "Reason returned to rejected callers")
+		terminate := disableFlags.Bool("terminate", false, "Also stop the executions already running")
+		if err := disableFlags.Parse(rest); err != nil {
+			return 1
+		}
+		if disableFlags.NArg() != 0 {
+			fmt.Fprint(stderr, killSwitchUsage)
+			return 1
+		}
+		var resp admin.DisableResponse
+		resp, err = client.Disable(ctx, admin.DisableRequest{Reason: *reason, Terminate: *terminate})
+		status = resp.KillSwitchStatus
+		if err == nil && *terminate {
+			fmt.Fprintf(stdout, "Terminated %d running executions\n", resp.Terminated)
+		}
+	case "enable":
+		status, err = client.Enable(ctx)
+	default:
+		fmt.Fprint(stderr, killSwitchUsage)
+		return 1
+	}
+
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	printKillSwitchStatus(stdout, status)
+	return 0
+}
+
+// printKillSwitchStatus prints the state of the kill switch.
+func printKillSwitchStatus(w io.Writer, status runner.KillSwitchStatus) {
+	if !status.Disabled {
+		fmt.Fprintf(w, "Execution is enabled (%d running)\n", status.Running)
+		return
+	}
+	fmt.Fprintf(w, "Execution is disabled since %s (%d running)\n", status.Since.Format(time.RFC3339), status.Running)
+	if status.Reason != "" {
+		fmt.Fprintf(w, "Reason: %s\n", status.Reason)
+	}
+}
+
+// isKillSwitchCommand reports whether the command line invokes the killswitch subcommand.
+func isKillSwitchCommand() bool {
+	return len(os.Args) > 1 && os.Args[1] == "killswitch"
+}
@@ -2,14 +2,21 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"slices"
 	"time"
 
+	"mvdan.cc/sh/v3/interp"
+
 	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/history"
 	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/redact"
 	"github.com/shimizu1995/secure-shell-server/pkg/runner"
+	"github.com/shimizu1995/secure-shell-server/pkg/snapshot"
 	"github.com/shimizu1995/secure-shell-server/pkg/utils"
 	"github.com/shimizu1995/secure-shell-server/pkg/validator"
 )
@@ -20,15 +27,54 @@ func main() {
 }
 
 func run() int {
+	if isConfigCommand() {
+		return runConfigCommand(os.Args[2:], os.Stdout, os.Stderr)
+	}
+	if isServeCommand() {
+		return runServeCommand(os.Args[2:], os.Stdin, os.Stdout, os.Stderr)
+	}
+	if isReplayCommand() {
+		return runReplayCommand(os.Args[2:], os.Stdout, os.Stderr)
+	}
+	if isApprovalsCommand() {
+		return runApprovalsCommand(os.Args[2:], os.Stdout, os.Stderr)
+	}
+	if isKillSwitchCommand() {
+		return runKillSwitchCommand(os.Args[2:], os.Stdout, os.Stderr)
+	}
+	if isHistoryCommand() {
+		return runHistoryCommand(os.Args[2:], os.Stdout, os.Stderr)
+	}
+	if isPolicyCommand() {
+		return runPolicyCommand(os.Args[2:], os.Stdout, os.Stderr)
+	}
+	if isReplCommand() {
+		return runReplCommand(os.Args[2:], os.Stdin, os.Stdout, os.Stderr)
+	}
+
 	// Define command-line flags
 	scriptStr := flag.String("script", "", "Script string to execute")
 	maxTime := flag.Int("timeout", config.DefaultExecutionTimeout, "Maximum execution time in seconds")
 	workingDir := flag.String("dir", "", "Working directory for command execution")
 	logPath := flag.String("log", "", "Path to the log file (if empty, no logging occurs)")
-	configPath := flag.String("config", "", "Path to the configuration file (if empty, uses default configuration)")
+	dialect := flag.String("dialect", "", "Shell dialect the script is parsed in: bash, posix, or mksh (default: the configured dialect)")
+	commit := flag.Bool("commit", false, "With snapshot enabled, apply the changes of the script instead of listing and discarding them")
+	outputFormat := flag.String("output", outputText, "Output format: text passes the script's output through, json writes a result document to stdout")
+	outputDir := flag.String("output-dir", "", "With -output json, write stdout and stderr to files in this directory and reference them instead of embedding them")
+	var configPaths config.FileList
+	flag.Var(&configPaths, "config", "Path to the configuration file; repeat or separate with commas to layer overrides on a base file")
 
 	flag.Parse()
 
+	if *outputFormat != outputText && *outputFormat != outputJSON {
+		fmt.Fprintf(os.Stderr, "Error: invalid -output %q: must be %s or %s\n", *outputFormat, outputText, outputJSON)
+		return 1
+	}
+	if *outputDir != "" && *outputFormat != outputJSON {
+		fmt.Fprintf(os.Stderr, "Error: -output-dir requires -output %s\n", outputJSON)
+		return 1
+	}
+
 	// Ensure log directory exists if log path is specified
 	if *logPath != "" {
 		if err := utils.EnsureLogDirectory(*logPath); err != nil {
@@ -51,24 +97,76 @@ func run() int {
 	var cfg *config.ShellCommandConfig
 	var configErr error
 
-	if *configPath == "" {
+	if len(configPaths) == 0 {
 		fmt.Fprintf(os.Stderr, "Error: Configuration file must be specified with -config flag\n")
 		return 1
 	}
 
 	// Load configuration from file
-	cfg, configErr = config.LoadConfigFromFile(*configPath)
+	cfg, configErr = config.LoadConfigFromFiles(configPaths...)
 	if configErr != nil {
 		fmt.Fprintf(os.Stderr, "Error loading configuration file: %v\n", configErr)
 		return 1
 	}
 
+	// Mask secrets in log entries as well as command output
+	redactor, redactErr := redact.New(cfg.Redaction)
+	if redactErr != nil {
+		fmt.Fprintf(os.Stderr, "Error configuring redaction: %v\n", redactErr)
+		return 1
+	}
+	log.SetRedactor(redactor)
+
 	// Override config with command-line flags if specified
 	cfg.MaxExecutionTime = *maxTime
+	if *dialect != "" {
+		if _, err := config.ParseDialect(*dialect); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		cfg.Dialect = *dialect
+	}
 
 	// Create validator and runner
 	validatorObj := validator.New(cfg, log)
 	safeRunner := runner.New(cfg, validatorObj, log)
+	if cfg.HistoryPath != "" {
+		h, err := history.Open(cfg.HistoryPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening history: %v\n", err)
+			return 1
+		}
+		defer h.Close()
+		safeRunner.SetHistory(h, historyCallerCLI)
+	}
+
+	// Commands marked confirm are asked about on the terminal
+	if slices.ContainsFunc(cfg.AllowCommands, func(c config.AllowCommand) bool { return c.Confirm }) {
+		confirm, closeTerminal, err := terminalConfirmation()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: commands marked confirm will be denied: no terminal to ask on: %v\n", err)
+		} else {
+			defer closeTerminal()
+			safeRunner.SetConfirmation(confirm)
+		}
+	}
+
+	// With -output json, the output is captured for the result document. Unlike the text
+	// format, this applies maxOutputSize, so that the document reports truncation
+	var jsonOut *jsonOutput
+	if *outputFormat == outputJSON {
+		var err error
+		if jsonOut, err = newJSONOutput(*outputDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		defer jsonOut.close()
+		safeRunner.SetOutputs(jsonOut.writers())
+	}
+
+	// Ctrl-C and kill reach the script's commands, as they would in a shell
+	stopSignals := safeRunner.ForwardSignals()
+	defer stopSignals()
 
 	// Create a context with timeout for the entire execution
 	ctx := context.Background()
@@ -80,6 +178,7 @@ func run() int {
 
 	// Execute the requested operation
 	var result runner.RunResult
+	start := time.Now()
 
 	switch {
 	case *scriptStr != "":
@@ -92,10 +191,82 @@ func run() int {
 		return 1
 	}
 
+	if jsonOut != nil {
+		return finishJSON(safeRunner, jsonOut, result, time.Since(start), *commit)
+	}
+
+	for _, d := range result.WouldDeny {
+		fmt.Fprintf(os.Stderr, "Would deny (permissive mode): %s\n", d.Message)
+	}
+
+	if result.Snapshot != nil {
+		if err := finishSnapshot(result.Snapshot, *commit); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+
+	// Exit with the script's exit status, or a reserved code when it was denied or invalid.
+	// Like a shell, say nothing of a script stopped by a signal but exit with 128 plus its number
 	if err := result.Err; err != nil {
+		var sigErr *runner.SignalError
+		if _, ok := interp.IsExitStatus(err); !ok && !errors.As(err, &sigErr) {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+	}
+	return runner.ExitCode(result.Err)
+}
+
+// finishJSON finishes the run of a script with -output json: it commits or discards the
+// changes of the script's snapshot and writes the result document to stdout.
+func finishJSON(r *runner.SafeRunner, out *jsonOutput, result runner.RunResult, duration time.Duration, commit bool) int {
+	if err := out.close(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		return 1
 	}
+	doc := out.result(r, result, duration)
+	if result.Snapshot != nil {
+		changes, err := applySnapshot(result.Snapshot, commit)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		doc.Changes, doc.Committed = changes, commit
+	}
+	if err := writeJSONResult(os.Stdout, doc); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing result: %v\n", err)
+		return 1
+	}
+	return doc.ExitCode
+}
+
+// finishSnapshot lists the changes the script made in its snapshot on stderr and then
+// commits them to the working directory or discards them.
+func finishSnapshot(snap *snapshot.Snapshot, commit bool) error {
+	changes, err := applySnapshot(snap, commit)
+	if err != nil {
+		return err
+	}
+	if !commit {
+		fmt.Fprintln(os.Stderr, "Changes discarded (run with -commit to apply them):")
+		for _, c := range changes {
+			fmt.Fprintf(os.Stderr, "  %s %s\n", c.Kind, c.Path)
+		}
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "Committed %d changes to %s\n", len(changes), snap.Source)
+	return nil
+}
 
-	return 0
+// applySnapshot commits the changes of snap to the working directory, or discards them
+// unless commit is set, and returns them.
+func applySnapshot(snap *snapshot.Snapshot, commit bool) ([]snapshot.Change, error) {
+	if !commit {
+		changes, err := snap.Changes()
+		if err != nil {
+			return nil, err
+		}
+		return changes, snap.Discard()
+	}
+	return snap.Commit()
 }
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"mvdan.cc/sh/v3/interp"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/fileaudit"
+	"github.com/shimizu1995/secure-shell-server/pkg/runner"
+	"github.com/shimizu1995/secure-shell-server/pkg/snapshot"
+)
+
+// Formats of the -output flag.
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// Decisions reported in a jsonResult.
+const (
+	// decisionAllowed means the policy allowed the script; it ran, whatever its exit code.
+	decisionAllowed = "allowed"
+	// decisionDenied means the policy denied a command, directory, or script.
+	decisionDenied = "denied"
+	// decisionInvalid means the script did not parse or its options were rejected.
+	decisionInvalid = "invalid"
+)
+
+// jsonResult is the document written to stdout with -output json.
+type jsonResult struct {
+	Decision string `json:"decision"`
+	ExitCode int    `json:"exitCode"`
+	// Error describes a failure other than the nonzero exit status of a command.
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+	// Stdout and Stderr hold the output of the script, unless -output-dir is set, in which
+	// case they are empty and StdoutFile and StderrFile name the files holding it.
+	Stdout          string `json:"stdout"`
+	Stderr          string `json:"stderr"`
+	StdoutFile      string `json:"stdoutFile,omitempty"`
+	StderrFile      string `json:"stderrFile,omitempty"`
+	StdoutTruncated bool   `json:"stdoutTruncated"`
+	StderrTruncated bool   `json:"stderrTruncated"`
+	// WorkDir is set when the script changed directory with cd.
+	WorkDir string `json:"workDir,omitempty"`
+	// Changes lists the changes the script made in its snapshot, and Committed whether
+	// they were applied (-commit) or discarded.
+	Changes   []snapshot.Change `json:"changes,omitempty"`
+	Committed bool              `json:"committed,omitempty"`
+	// Files lists the files the script created, modified, or deleted when fileAudit is enabled.
+	Files           []fileaudit.Change `json:"files,omitempty"`
+	FilesIncomplete bool               `json:"filesIncomplete,omitempty"`
+	// WouldDeny lists the denials the policy would have made in permissive mode.
+	WouldDeny []runner.Denial `json:"wouldDeny,omitempty"`
+}
+
+// jsonOutput captures the output of a script run with -output json.
+type jsonOutput struct {
+	stdout, stderr bytes.Buffer
+	// files receive the output instead of the buffers when -output-dir is set
+	stdoutFile, stderrFile *os.File
+}
+
+// newJSONOutput returns the capture of a script's output, in files created in dir if
+// it is not empty and in memory otherwise.
+func newJSONOutput(dir string) (*jsonOutput, error) {
+	out := &jsonOutput{}
+	if dir == "" {
+		return out, nil
+	}
+	var err error
+	if out.stdoutFile, err = os.CreateTemp(dir, "stdout-*"); err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	if out.stderrFile, err = os.CreateTemp(dir, "stderr-*"); err != nil {
+		out.stdoutFile.Close()
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	return out, nil
+}
+
+// writers returns the writers to pass to SafeRunner.SetOutputs.
+func (o *jsonOutput) writers() (io.Writer, io.Writer) {
+	if o.stdoutFile != nil {
+		return o.stdoutFile, o.stderrFile
+	}
+	return &o.stdout, &o.stderr
+}
+
+// close closes the output files, if any.
+func (o *jsonOutput) close() error {
+	if o.stdoutFile == nil {
+		return nil
+	}
+	return errors.Join(o.stdoutFile.Close(), o.stderrFile.Close())
+}
+
+// result returns the document describing the run of a script by r.
+func (o *jsonOutput) result(r *runner.SafeRunner, result runner.RunResult, duration time.Duration) jsonResult {
+	doc := jsonResult{
+		Decision:        decisionAllowed,
+		ExitCode:        runner.ExitCode(result.Err),
+		DurationMs:      duration.Milliseconds(),
+		WorkDir:         result.NewWorkDir,
+		Files:           result.FileChanges,
+		FilesIncomplete: result.FileChangesIncomplete,
+		WouldDeny:       result.WouldDeny,
+	}
+	switch doc.ExitCode {
+	case runner.ExitDenied:
+		doc.Decision = decisionDenied
+	case runner.ExitInvalid:
+		doc.Decision = decisionInvalid
+	}
+	if _, ok := interp.IsExitStatus(result.Err); result.Err != nil && !ok {
+		doc.Error = result.Err.Error()
+	}
+	doc.StdoutTruncated, doc.StderrTruncated = r.GetTruncationStatus()
+	if o.stdoutFile != nil {
+		doc.StdoutFile, doc.StderrFile = o.stdoutFile.Name(), o.stderrFile.Name()
+	} else {
+		doc.Stdout, doc.Stderr = o.stdout.String(), o.stderr.String()
+	}
+	return doc
+}
+
+// writeJSONResult writes doc to w as a single line of JSON.
+func writeJSONResult(w io.Writer, doc jsonResult) error {
+	return json.NewEncoder(w).Encode(doc)
+}
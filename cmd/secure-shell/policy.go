@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/policytest"
+)
+
+// policyUsage describes the policy subcommand.
+const policyUsage = "Usage: secure-shell policy test [-config FILE] [-v] <policy_test.yaml>...\n"
+
+// runPolicyCommand dispatches the "policy" subcommands.
+func runPolicyCommand(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] != "test" {
+		fmt.Fprint(stderr, policyUsage)
+		return 1
+	}
+	return runPolicyTest(args[1:], stdout, stderr)
+}
+
+// runPolicyTest checks configurations against the cases in policy test files.
+// It exits non-zero if any case fails.
+func runPolicyTest(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("policy test", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	var configPaths config.FileList
+	flags.Var(&configPaths, "config", "Configuration file to test instead of the one named in each test file; repeat to layer files")
+	verbose := flags.Bool("v", false, "Print passing cases as well as failing ones")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprint(stderr, policyUsage)
+		return 1
+	}
+
+	var passed, failed int
+	for _, path := range flags.Args() {
+		suite, err := policytest.Load(path)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			return 1
+		}
+
+		paths := []string(suite.Config)
+		if len(configPaths) > 0 {
+			paths = configPaths
+		}
+		if len(paths) == 0 {
+			fmt.Fprintf(stderr, "Error: %s names no configuration; use -config\n", path)
+			return 1
+		}
+		cfg, err := config.LoadConfigFromFiles(paths...)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error loading configuration file: %v\n", err)
+			return 1
+		}
+
+		for _, result := range policytest.Run(cfg, suite.Tests) {
+			if result.Passed() {
+				passed++
+				if *verbose {
+					fmt.Fprintf(stdout, "PASS  %s: %s\n", path, result.Case.Title())
+				}
+				continue
+			}
+			failed++
+			fmt.Fprintf(stdout, "FAIL  %s: %s\n      %s\n", path, result.Case.Title(), result.Failure)
+		}
+	}
+
+	if failed > 0 {
+		fmt.Fprintf(stdout, "FAIL  %d of %d cases failed\n", failed, passed+failed)
+		return 1
+	}
+	fmt.Fprintf(stdout, "ok    %d cases passed\n", passed)
+	return 0
+}
+
+// isPolicyCommand reports whether the command line invokes the policy subcommand.
+func isPolicyCommand() bool {
+	return len(os.Args) > 1 && os.Args[1] == "policy"
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/term"
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/history"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/redact"
+	"github.com/shimizu1995/secure-shell-server/pkg/runner"
+	"github.com/shimizu1995/secure-shell-server/pkg/snapshot"
+	"github.com/shimizu1995/secure-shell-server/pkg/utils"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+// historyCallerREPL is the caller recorded for lines entered in the REPL.
+const historyCallerREPL = "repl"
+
+// replHelp describes the lines the REPL understands besides scripts.
+const replHelp = `Each line is validated and run as a script under the policy.
+  :set NAME=value   pass a variable to the commands of later lines
+  :unset NAME       stop passing a variable
+  :env              list the variables set with :set
+  :help             show this help
+  exit              leave (as do Ctrl-D and Ctrl-C at the prompt)
+`
+
+// replSession is the state the REPL keeps between lines.
+type replSession struct {
+	runner  *runner.SafeRunner
+	tty     *runner.Terminal
+	out     io.Writer
+	workDir string
+	// env holds the "NAME=value" pairs set with :set, in the order they were set
+	env []string
+}
+
+// runReplCommand reads scripts line by line, with line editing and history on a terminal,
+// and runs each under the policy, printing why a line was denied. The working directory and
+// the variables set with :set carry over from one line to the next.
+// Usage: secure-shell repl -config <path> [-dir <path>] [-log <path>]
+func runReplCommand(args []string, stdin *os.File, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("repl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	var configPaths config.FileList
+	flags.Var(&configPaths, "config", "Path to the configuration file; repeat to layer overrides on a base file")
+	workingDir := flags.String("dir", "", "Working directory of the first line (default: the first allowed directory)")
+	logPath := flags.String("log", "", "Path to the log file (if empty, no logging occurs)")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+	if len(configPaths) == 0 {
+		fmt.Fprintf(stderr, "Error: Configuration file must be specified with -config flag\n")
+		return 1
+	}
+
+	cfg, err := config.LoadConfigFromFiles(configPaths...)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error loading configuration file: %v\n", err)
+		return 1
+	}
+	if *logPath != "" {
+		if err := utils.EnsureLogDirectory(*logPath); err != nil {
+			fmt.Fprintf(stderr, "Error creating log directory: %v\n", err)
+			return 1
+		}
+	}
+	log, err := logger.NewWithPath(*logPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error creating logger: %v\n", err)
+		return 1
+	}
+	defer log.Close()
+	redactor, err := redact.New(cfg.Redaction)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error configuring redaction: %v\n", err)
+		return 1
+	}
+	log.SetRedactor(redactor)
+
+	sess := &replSession{runner: runner.New(cfg, validator.New(cfg, log), log), workDir: *workingDir}
+	if sess.workDir == "" {
+		sess.workDir = cfg.DefaultDirectory()
+	}
+	if cfg.HistoryPath != "" {
+		h, err := history.Open(cfg.HistoryPath)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error opening history: %v\n", err)
+			return 1
+		}
+		defer h.Close()
+		sess.runner.SetHistory(h, historyCallerREPL)
+	}
+	stopSignals := sess.runner.ForwardSignals()
+	defer stopSignals()
+
+	readLine, restore, err := sess.open(stdin, stdout, stderr, cfg)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer restore()
+
+	for {
+		line, err := readLine()
+		if err != nil {
+			return 0
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "":
+		case line == "exit":
+			return 0
+		case strings.HasPrefix(line, ":"):
+			sess.command(line)
+		default:
+			sess.execute(line)
+		}
+	}
+}
+
+// open sets up the input and output of the session. On a terminal, lines are edited in raw
+// mode and commands marked allowPty run in a pseudo-terminal; otherwise lines are read from
+// stdin as they are. It returns the function reading the next line and the function
+// restoring the terminal.
+func (s *replSession) open(stdin *os.File, stdout, stderr io.Writer, cfg *config.ShellCommandConfig) (func() (string, error), func(), error) {
+	fd := int(stdin.Fd()) //nolint:gosec // file descriptors fit in an int
+	if !term.IsTerminal(fd) {
+		s.out = stdout
+		s.runner.SetOutputs(stdout, stderr)
+		if slices.ContainsFunc(cfg.AllowCommands, func(c config.AllowCommand) bool { return c.Confirm }) {
+			if confirm, closeTerminal, err := terminalConfirmation(); err == nil {
+				s.runner.SetConfirmation(confirm)
+				return newReplLineReader(stdin), closeTerminal, nil
+			}
+		}
+		return newReplLineReader(stdin), func() {}, nil
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to put the terminal in raw mode: %w", err)
+	}
+	cols, rows, err := term.GetSize(fd)
+	if err != nil || cols == 0 || rows == 0 {
+		cols, rows = 80, 24
+	}
+	size := runner.WindowSize{Rows: uint16(rows), Cols: uint16(cols)} //nolint:gosec // terminal sizes are small
+	s.tty = runner.NewTerminal(stdin, size)
+	if name := os.Getenv("TERM"); name != "" {
+		s.tty.Term = name
+	}
+	editor := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{s.tty, stdout}, s.prompt())
+	_ = editor.SetSize(cols, rows)
+
+	// The editor turns line feeds into the line endings a terminal in raw mode needs
+	s.out = editor
+	s.runner.SetOutputs(editor, editor)
+	s.runner.SetConfirmation(editorConfirmation(editor, s))
+	readLine := func() (string, error) {
+		editor.SetPrompt(s.prompt())
+		return editor.ReadLine()
+	}
+	return readLine, func() {
+		s.tty.Close()
+		_ = term.Restore(fd, state)
+	}, nil
+}
+
+// prompt returns the prompt showing the working directory.
+func (s *replSession) prompt() string {
+	return s.workDir + " $ "
+}
+
+// execute runs a line and reports why it failed, if it did not just exit with a nonzero status.
+func (s *replSession) execute(line string) {
+	opts := []runner.ExecOption{runner.WithWorkdir(s.workDir)}
+	if len(s.env) > 0 {
+		opts = append(opts, runner.WithEnv(s.env...))
+	}
+	// Without a terminal, no command runs in a pseudo-terminal
+	result := s.runner.RunInteractive(context.Background(), line, s.tty, opts...)
+
+	for _, d := range result.WouldDeny {
+		fmt.Fprintf(s.out, "Would deny (permissive mode): %s\n", d.Message)
+	}
+	if result.Snapshot != nil {
+		s.discardSnapshot(result.Snapshot)
+	}
+	if result.NewWorkDir != "" {
+		s.workDir = result.NewWorkDir
+	}
+
+	var sigErr *runner.SignalError
+	switch err := result.Err; {
+	case err == nil, errors.As(err, &sigErr):
+	case runner.ExitCode(err) == runner.ExitDenied:
+		fmt.Fprintf(s.out, "Denied: %v\n", err)
+	default:
+		if _, ok := interp.IsExitStatus(err); !ok {
+			fmt.Fprintf(s.out, "Error: %v\n", err)
+		}
+	}
+}
+
+// discardSnapshot lists the changes a line made in its snapshot and discards them, as the
+// CLI does without -commit.
+func (s *replSession) discardSnapshot(snap *snapshot.Snapshot) {
+	changes, err := applySnapshot(snap, false)
+	if err != nil {
+		fmt.Fprintf(s.out, "Error: %v\n", err)
+		return
+	}
+	if len(changes) > 0 {
+		fmt.Fprintln(s.out, "Changes discarded:")
+	}
+	for _, c := range changes {
+		fmt.Fprintf(s.out, "  %s %s\n", c.Kind, c.Path)
+	}
+}
+
+// command runs a line starting with ":".
+func (s *replSession) command(line string) {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch name {
+	case ":set":
+		varName, _, ok := strings.Cut(arg, "=")
+		if !ok || !syntax.ValidName(varName) {
+			fmt.Fprintln(s.out, "Usage: :set NAME=value")
+			return
+		}
+		if err := runner.CheckEnv(arg); err != nil {
+			fmt.Fprintf(s.out, "Error: %v\n", err)
+			return
+		}
+		s.unset(varName)
+		s.env = append(s.env, arg)
+	case ":unset":
+		if !syntax.ValidName(arg) {
+			fmt.Fprintln(s.out, "Usage: :unset NAME")
+			return
+		}
+		s.unset(arg)
+	case ":env":
+		for _, kv := range s.env {
+			fmt.Fprintln(s.out, kv)
+		}
+	case ":help":
+		fmt.Fprint(s.out, replHelp)
+	default:
+		fmt.Fprintf(s.out, "Unknown command %s; type :help for help\n", name)
+	}
+}
+
+// unset removes the variable name from the session.
+func (s *replSession) unset(name string) {
+	s.env = slices.DeleteFunc(s.env, func(kv string) bool { return strings.HasPrefix(kv, name+"=") })
+}
+
+// editorConfirmation returns a ConfirmationFunc asking about commands marked confirm in the
+// line editor, whose terminal is in raw mode.
+func editorConfirmation(editor *term.Terminal, s *replSession) runner.ConfirmationFunc {
+	return func(req runner.ExecRequest) (bool, error) {
+		command := strings.Join(append([]string{req.Command}, req.Args...), " ")
+		editor.SetPrompt(fmt.Sprintf("Run %s in %s? [y/N] ", command, req.WorkDir))
+		defer editor.SetPrompt(s.prompt())
+		answer, err := editor.ReadLine()
+		if err != nil {
+			return false, fmt.Errorf("no answer: %w", err)
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return true, nil
+		default:
+			return false, nil
+		}
+	}
+}
+
+// newReplLineReader returns a function reading the lines of r.
+func newReplLineReader(r io.Reader) func() (string, error) {
+	scanner := bufio.NewScanner(r)
+	return func() (string, error) {
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return "", err
+			}
+			return "", io.EOF
+		}
+		return scanner.Text(), nil
+	}
+}
+
+// isReplCommand reports whether the command line invokes the repl subcommand.
+func isReplCommand() bool {
+	return len(os.Args) > 1 && os.Args[1] == "repl"
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/recording"
+)
+
+// runReplayCommand prints a session recording, optionally with its original timing.
+// Usage: secure-shell replay [-speed N] [-max-idle D] <file.cast>
+func runReplayCommand(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	speed := flags.Float64("speed", 0, "Playback speed multiplier (0 prints without delays)")
+	maxIdle := flags.Duration("max-idle", 2*time.Second, "Longest pause between events during timed playback")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintf(stderr, "Usage: secure-shell replay [-speed N] [-max-idle D] <file.cast>\n")
+		return 1
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+
+	opts := recording.ReplayOptions{Speed: *speed, MaxIdle: *maxIdle, Stderr: stderr}
+	if err := recording.Replay(f, stdout, opts); err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// isReplayCommand reports whether the command line invokes the replay subcommand.
+func isReplayCommand() bool {
+	return len(os.Args) > 1 && os.Args[1] == "replay"
+}
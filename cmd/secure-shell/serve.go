@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/history"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/redact"
+	"github.com/shimizu1995/secure-shell-server/pkg/rpcserver"
+	"github.com/shimizu1995/secure-shell-server/pkg/systemd"
+	"github.com/shimizu1995/secure-shell-server/pkg/utils"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+// defaultSocketMode is the permission of the socket created by -socket: only its owner may connect.
+const defaultSocketMode = "0600"
+
+// runServeCommand serves JSON-RPC requests until stdin is closed, or on a Unix domain
+// socket until the process is interrupted. With --systemd, the socket may be passed by
+// socket activation, readiness is reported with sd_notify, and SIGHUP reloads the policy.
+// Usage: secure-shell serve (--stdio | -socket <path> | --systemd) -config <path> [-uid-config UID=<path>] [-log <path>]
+func runServeCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	stdio := flags.Bool("stdio", false, "Speak line-delimited JSON-RPC on stdin/stdout")
+	socketPath := flags.String("socket", "", "Speak line-delimited JSON-RPC on a Unix domain socket at this path")
+	socketMode := flags.String("socket-mode", defaultSocketMode, "Octal permissions of the -socket file")
+	var configPaths config.FileList
+	flags.Var(&configPaths, "config", "Path to the configuration file; repeat to layer overrides on a base file")
+	uidConfigs := uidConfigFlag{}
+	flags.Var(uidConfigs, "uid-config", "UID=FILE[,FILE]: configuration for -socket connections from this user; repeatable")
+	logPath := flags.String("log", "", "Path to the log file (if empty, no logging occurs)")
+	systemdMode := flags.Bool("systemd", false, "Run as a systemd service: serve the socket passed by socket activation or -socket, notify readiness, and reload the configuration on SIGHUP")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	var activated *net.UnixListener
+	if *systemdMode {
+		var err error
+		if activated, err = activatedSocket(); err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+	switch {
+	case *systemdMode && *stdio:
+		fmt.Fprintf(stderr, "Error: --systemd serves a socket and cannot be used with --stdio\n")
+		return 1
+	case activated != nil && *socketPath != "":
+		activated.Close()
+		fmt.Fprintf(stderr, "Error: -socket cannot be used with a socket passed by systemd\n")
+		return 1
+	case activated == nil && *stdio == (*socketPath != ""):
+		if *systemdMode {
+			fmt.Fprintf(stderr, "Error: --systemd requires socket activation or -socket\n")
+		} else {
+			fmt.Fprintf(stderr, "Error: serve requires either --stdio or -socket\n")
+		}
+		return 1
+	}
+	if activated != nil {
+		defer activated.Close()
+	}
+	mode, err := strconv.ParseUint(*socketMode, 8, 32)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: invalid -socket-mode %q\n", *socketMode)
+		return 1
+	}
+	if len(configPaths) == 0 {
+		fmt.Fprintf(stderr, "Error: Configuration file must be specified with -config flag\n")
+		return 1
+	}
+
+	cfg, err := config.LoadConfigFromFiles(configPaths...)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error loading configuration file: %v\n", err)
+		return 1
+	}
+
+	if *logPath != "" {
+		if err := utils.EnsureLogDirectory(*logPath); err != nil {
+			fmt.Fprintf(stderr, "Error creating log directory: %v\n", err)
+			return 1
+		}
+	}
+	log, err := logger.NewWithPath(*logPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error creating logger: %v\n", err)
+		return 1
+	}
+	defer log.Close()
+
+	redactor, err := redact.New(cfg.Redaction)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error configuring redaction: %v\n", err)
+		return 1
+	}
+	log.SetRedactor(redactor)
+
+	v := validator.New(cfg, log)
+	server := rpcserver.New(cfg, v, log)
+	if cfg.HistoryPath != "" {
+		h, err := history.Open(cfg.HistoryPath)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error opening history: %v\n", err)
+			return 1
+		}
+		defer h.Close()
+		server.SetHistory(h)
+	}
+
+	if *socketPath != "" || activated != nil {
+		policies, err := uidConfigs.load(log)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error loading configuration file: %v\n", err)
+			return 1
+		}
+		ln := activated
+		if ln == nil {
+			if ln, err = listenSocket(*socketPath, os.FileMode(mode)); err != nil {
+				fmt.Fprintf(stderr, "Error: %v\n", err)
+				return 1
+			}
+		}
+		if !*systemdMode {
+			server.SetPolicyFunc(policies.policyFunc(cfg, v))
+			return serveSocket(server, ln, stderr)
+		}
+		policy := &reloadablePolicy{
+			configPaths: configPaths,
+			uidConfigs:  uidConfigs,
+			log:         log,
+			store:       config.NewStore(cfg),
+			validator:   v,
+			uids:        policies,
+		}
+		server.SetPolicyFunc(policy.policyFunc)
+		return serveSystemd(server, ln, policy, stderr)
+	}
+
+	if err := server.Serve(context.Background(), stdin, stdout); err != nil {
+		fmt.Fprintf(stderr, "Server error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// listenSocket listens on a Unix domain socket at path with the permissions mode.
+func listenSocket(path string, mode os.FileMode) (*net.UnixListener, error) {
+	// Replace a socket left behind by a previous run, but never another kind of file
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(path)
+	}
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on socket: %w", err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	return ln, nil
+}
+
+// serveSocket serves JSON-RPC on a Unix domain socket until the process is interrupted.
+func serveSocket(server *rpcserver.Server, ln *net.UnixListener, stderr io.Writer) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := server.ServeUnix(ctx, ln); err != nil {
+		fmt.Fprintf(stderr, "Server error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// activatedSocket returns the socket passed by systemd socket activation, or nil if the
+// process was not socket-activated.
+func activatedSocket() (*net.UnixListener, error) {
+	listeners, err := systemd.Listeners()
+	if err != nil {
+		return nil, err
+	}
+	if len(listeners) == 0 {
+		return nil, nil
+	}
+	ln, ok := listeners[0].(*net.UnixListener)
+	if len(listeners) > 1 || !ok {
+		for _, l := range listeners {
+			l.Close()
+		}
+		return nil, errors.New("systemd must pass a single Unix stream socket (ListenStream= with a path)")
+	}
+	return ln, nil
+}
+
+// serveSystemd serves JSON-RPC on a Unix domain socket as a systemd service: it notifies
+// systemd once it accepts connections and when it stops, and reloads the policy on SIGHUP.
+func serveSystemd(server *rpcserver.Server, ln *net.UnixListener, policy *reloadablePolicy, stderr io.Writer) int {
+	notifier := systemd.NewNotifier()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hangups:
+				policy.reload(notifier)
+			}
+		}
+	}()
+
+	// The listener is open, so connections are queued until ServeUnix accepts them
+	if err := notifier.Notify(systemd.Ready); err != nil {
+		policy.log.LogErrorf("Failed to notify systemd: %v", err)
+	}
+	err := server.ServeUnix(ctx, ln)
+	_ = notifier.Notify(systemd.Stopping)
+	if err != nil {
+		fmt.Fprintf(stderr, "Server error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// reloadablePolicy is the policy of socket connections under --systemd, replaced by reading
+// the configuration files again on SIGHUP. A connection keeps the policy it was accepted with.
+type reloadablePolicy struct {
+	configPaths config.FileList
+	uidConfigs  uidConfigFlag
+	log         *logger.Logger
+	// store holds the policy of connections without a -uid-config of their own
+	store *config.Store
+
+	mu        sync.RWMutex
+	validator *validator.CommandValidator
+	uids      uidPolicies
+}
+
+// reload reads the configuration files again and makes them the policy of new connections.
+// An invalid configuration is logged and the current policy is kept.
+func (p *reloadablePolicy) reload(notifier *systemd.Notifier) {
+	_ = notifier.Notify(systemd.Reloading())
+	defer func() { _ = notifier.Notify(systemd.Ready) }()
+
+	cfg, err := config.LoadConfigFromFiles(p.configPaths...)
+	if err != nil {
+		p.log.LogErrorf("Failed to reload configuration, keeping the current policy: %v", err)
+		return
+	}
+	uids, err := p.uidConfigs.load(p.log)
+	if err != nil {
+		p.log.LogErrorf("Failed to reload configuration, keeping the current policy: %v", err)
+		return
+	}
+	v := validator.New(cfg, p.log)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.store.Replace(cfg)
+	p.validator, p.uids = v, uids
+	p.log.LogInfof("Reloaded configuration from %s", strings.Join(p.configPaths, ", "))
+}
+
+// policyFunc selects the policy of a connecting peer from the current policies.
+func (p *reloadablePolicy) policyFunc(peer rpcserver.Peer) (*config.ShellCommandConfig, *validator.CommandValidator, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.uids.policyFunc(p.store.Load(), p.validator)(peer)
+}
+
+// uidConfigFlag collects -uid-config flags: configuration files by UID.
+type uidConfigFlag map[uint32]config.FileList
+
+func (f uidConfigFlag) String() string {
+	return ""
+}
+
+func (f uidConfigFlag) Set(value string) error {
+	uidText, files, ok := strings.Cut(value, "=")
+	if !ok {
+		return errors.New("expected UID=FILE")
+	}
+	uid, err := strconv.ParseUint(uidText, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid UID %q", uidText)
+	}
+	paths := f[uint32(uid)]
+	if err := paths.Set(files); err != nil {
+		return err
+	}
+	f[uint32(uid)] = paths
+	return nil
+}
+
+// uidPolicy is the configuration and validator of a UID.
+type uidPolicy struct {
+	config    *config.ShellCommandConfig
+	validator *validator.CommandValidator
+}
+
+// uidPolicies are the policies loaded from -uid-config flags.
+type uidPolicies map[uint32]uidPolicy
+
+// load reads the configuration of every UID, so that mistakes are reported at startup.
+func (f uidConfigFlag) load(log *logger.Logger) (uidPolicies, error) {
+	policies := make(uidPolicies, len(f))
+	for uid, paths := range f {
+		cfg, err := config.LoadConfigFromFiles(paths...)
+		if err != nil {
+			return nil, fmt.Errorf("uid %d: %w", uid, err)
+		}
+		policies[uid] = uidPolicy{config: cfg, validator: validator.New(cfg, log)}
+	}
+	return policies, nil
+}
+
+// policyFunc selects the policy of a connecting UID, falling back to the server's own policy.
+func (p uidPolicies) policyFunc(cfg *config.ShellCommandConfig, v *validator.CommandValidator) rpcserver.PolicyFunc {
+	return func(peer rpcserver.Peer) (*config.ShellCommandConfig, *validator.CommandValidator, error) {
+		if policy, ok := p[peer.UID]; ok {
+			return policy.config, policy.validator, nil
+		}
+		return cfg, v, nil
+	}
+}
+
+// isServeCommand reports whether the command line invokes the serve subcommand.
+func isServeCommand() bool {
+	return len(os.Args) > 1 && os.Args[1] == "serve"
+}
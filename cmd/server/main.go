@@ -2,12 +2,25 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/shimizu1995/secure-shell-server/pkg/admin"
+	"github.com/shimizu1995/secure-shell-server/pkg/approval"
 	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/history"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/redact"
+	"github.com/shimizu1995/secure-shell-server/pkg/sshserver"
+	"github.com/shimizu1995/secure-shell-server/pkg/tenant"
 	"github.com/shimizu1995/secure-shell-server/pkg/utils"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
 	"github.com/shimizu1995/secure-shell-server/service"
 )
 
@@ -31,24 +44,46 @@ func run() int {
 
 	// Define server-specific flags
 	port := flag.Int("port", defaultPort, "Port to listen on")
-	configFile := flag.String("config", "", "Path to configuration file")
+	var configFiles config.FileList
+	flag.Var(&configFiles, "config", "Path to configuration file; repeat or separate with commas to layer overrides on a base file")
+	configURL := flag.String("config-url", "", "URL to fetch the configuration from instead of -config")
+	configPublicKey := flag.String("config-public-key", "", "Path to the Ed25519 public key that must have signed the -config-url configuration")
+	configCache := flag.String("config-cache", "", "Path to cache the -config-url configuration, used when the URL is unreachable")
 	stdio := flag.Bool("stdio", true, "Use stdin/stdout for MCP communication")
 	logPath := flag.String("log", "", "Path to the log file (if empty, no logging occurs)")
+	sshAddr := flag.String("ssh", "", "Serve SSH on this address (e.g. :2222) instead of MCP")
+	sshHostKey := flag.String("ssh-host-key", "", "Path to the SSH host private key")
+	sshAuthorizedKeys := flag.String("ssh-authorized-keys", "", "Path to the authorized_keys file for SSH clients")
+	approvalAddr := flag.String("approval-addr", "", "Serve the approval API on this address (e.g. 127.0.0.1:8081)")
+	adminAddr := flag.String("admin-addr", "", "Serve the admin API with the kill switch on this address (e.g. 127.0.0.1:8082)")
+	tlsCert := flag.String("tls-cert", "", "Path to the PEM-encoded certificate to serve MCP over HTTPS with (requires -tls-key)")
+	tlsKey := flag.String("tls-key", "", "Path to the PEM-encoded private key of -tls-cert")
+	tenantsDir := flag.String("tenants-dir", "", "Serve MCP over HTTP on -port to the tenants configured in this directory instead of -config")
+	tenantsReload := flag.Duration("tenants-reload", defaultTenantsReload, "How often to reload -tenants-dir")
 
 	// Parse the flags
 	flag.Parse()
 
+	if *tenantsDir != "" {
+		if *adminAddr != "" {
+			serveAdmin(*adminAddr)
+		}
+		return runTenants(*tenantsDir, *tenantsReload, *port, *logPath)
+	}
+
 	// Get configuration
 	var cfg *config.ShellCommandConfig
 	var err error
 
-	if *configFile == "" {
+	switch {
+	case *configURL != "":
+		cfg, err = loadRemoteConfig(*configURL, *configPublicKey, *configCache)
+	case len(configFiles) > 0:
+		cfg, err = config.LoadConfigFromFiles(configFiles...)
+	default:
 		fmt.Fprintf(os.Stderr, "Error: Configuration file must be specified with -config flag\n")
 		return 1
 	}
-
-	// Load configuration from file
-	cfg, err = config.LoadConfigFromFile(*configFile)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
 		return 1
@@ -62,12 +97,27 @@ func run() int {
 		}
 	}
 
+	if *adminAddr != "" {
+		serveAdmin(*adminAddr)
+	}
+
+	if *sshAddr != "" {
+		return runSSH(cfg, *logPath, *approvalAddr, sshserver.Options{
+			Address:            *sshAddr,
+			HostKeyPath:        *sshHostKey,
+			AuthorizedKeysPath: *sshAuthorizedKeys,
+		})
+	}
+
 	// Create server with optional log path
 	mcpServer, err := service.NewServer(cfg, *port, *logPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error creating server: %v\n", err)
 		return 1
 	}
+	if *approvalAddr != "" {
+		serveApprovals(*approvalAddr, mcpServer.Approvals())
+	}
 
 	// Start the server using stdio or HTTP
 	if *stdio {
@@ -76,6 +126,16 @@ func run() int {
 			return 1
 		}
 	} else {
+		if (*tlsCert == "") != (*tlsKey == "") {
+			fmt.Fprintf(os.Stderr, "Error: -tls-cert and -tls-key must be given together\n")
+			return 1
+		}
+		if *tlsCert != "" {
+			mcpServer.SetTLS(*tlsCert, *tlsKey)
+		}
+		if !cfg.Auth.Enabled() {
+			fmt.Fprintf(os.Stderr, "Warning: auth is not configured; the MCP server on port %d is unauthenticated\n", *port)
+		}
 		fmt.Printf("Starting MCP server on port %d...\n", *port)
 		if err := mcpServer.Start(); err != nil {
 			fmt.Fprintf(os.Stderr, "Server error: %v\n", err)
@@ -85,3 +145,132 @@ func run() int {
 
 	return 0
 }
+
+// loadRemoteConfig fetches the configuration from url, verifying its signature if a public key is given.
+func loadRemoteConfig(url, publicKeyPath, cachePath string) (*config.ShellCommandConfig, error) {
+	opts := config.RemoteOptions{CachePath: cachePath}
+	if publicKeyPath != "" {
+		data, err := os.ReadFile(publicKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read public key: %w", err)
+		}
+		opts.PublicKey, err = config.ParsePublicKey(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return config.LoadConfigFromURL(url, opts)
+}
+
+// approvalTokenEnv names the environment variable holding the bearer token of the approval API.
+const approvalTokenEnv = "SECURE_SHELL_APPROVAL_TOKEN"
+
+// approvalReadHeaderTimeout bounds how long the approval and admin APIs wait for request headers.
+const approvalReadHeaderTimeout = 10 * time.Second
+
+// serveApprovals serves the approval API for q in the background.
+func serveApprovals(addr string, q *approval.Queue) {
+	token := os.Getenv(approvalTokenEnv)
+	if token == "" {
+		fmt.Fprintf(os.Stderr, "Warning: %s is not set; the approval API on %s is unauthenticated\n", approvalTokenEnv, addr)
+	}
+	srv := &http.Server{Addr: addr, Handler: q.Handler(token), ReadHeaderTimeout: approvalReadHeaderTimeout}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil {
+			fmt.Fprintf(os.Stderr, "Approval API error: %v\n", err)
+		}
+	}()
+}
+
+// adminTokenEnv names the environment variable holding the bearer token of the admin API.
+const adminTokenEnv = "SECURE_SHELL_ADMIN_TOKEN"
+
+// serveAdmin serves the admin API in the background.
+func serveAdmin(addr string) {
+	token := os.Getenv(adminTokenEnv)
+	if token == "" {
+		fmt.Fprintf(os.Stderr, "Warning: %s is not set; the admin API on %s is unauthenticated\n", adminTokenEnv, addr)
+	}
+	srv := &http.Server{Addr: addr, Handler: admin.Handler(token), ReadHeaderTimeout: approvalReadHeaderTimeout}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil {
+			fmt.Fprintf(os.Stderr, "Admin API error: %v\n", err)
+		}
+	}()
+}
+
+// defaultTenantsReload is how often -tenants-dir is reloaded by default.
+const defaultTenantsReload = 10 * time.Second
+
+// runTenants serves MCP over HTTP to the tenants in dir until the process is interrupted.
+func runTenants(dir string, reload time.Duration, port int, logPath string) int {
+	registry, err := tenant.Load(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading tenants: %v\n", err)
+		return 1
+	}
+	if logPath != "" {
+		if dirErr := utils.EnsureLogDirectory(logPath); dirErr != nil {
+			fmt.Fprintf(os.Stderr, "Error creating log directory: %v\n", dirErr)
+			return 1
+		}
+	}
+	tenantServer, err := service.NewTenantServer(registry, port, logPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating server: %v\n", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	fmt.Printf("Starting multi-tenant MCP server on port %d with %d tenants...\n", port, len(registry.Tenants()))
+	if err := tenantServer.Start(ctx, reload); err != nil {
+		fmt.Fprintf(os.Stderr, "Server error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runSSH serves the policy over SSH until the listener fails.
+func runSSH(cfg *config.ShellCommandConfig, logPath, approvalAddr string, opts sshserver.Options) int {
+	log, err := logger.NewWithPath(logPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating logger: %v\n", err)
+		return 1
+	}
+	defer log.Close()
+
+	redactor, err := redact.New(cfg.Redaction)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error configuring redaction: %v\n", err)
+		return 1
+	}
+	log.SetRedactor(redactor)
+
+	sshServer, err := sshserver.New(cfg, validator.New(cfg, log), log, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating SSH server: %v\n", err)
+		return 1
+	}
+	approvals := approval.New(time.Duration(cfg.ApprovalTimeout) * time.Second)
+	sshServer.SetApprovals(approvals)
+	if cfg.HistoryPath != "" {
+		h, err := history.Open(cfg.HistoryPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening history: %v\n", err)
+			return 1
+		}
+		defer h.Close()
+		sshServer.SetHistory(h)
+	}
+	if approvalAddr != "" {
+		serveApprovals(approvalAddr, approvals)
+	}
+
+	fmt.Printf("Starting SSH server on %s...\n", opts.Address)
+	if err := sshServer.ListenAndServe(); err != nil {
+		fmt.Fprintf(os.Stderr, "Server error: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,215 @@
+// Package admin serves an HTTP API for operators to stop command execution during an incident
+// and to inspect and reset the daily quotas of callers, and a client for it.
+package admin
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/runner"
+)
+
+// clientTimeout bounds a single request made by Client.
+const clientTimeout = 30 * time.Second
+
+// maxBodySize bounds request and response bodies.
+const maxBodySize = 1024 * 1024
+
+// DisableRequest is the body of a disable request.
+type DisableRequest struct {
+	// Reason is appended to the message of rejected executions.
+	Reason string `json:"reason,omitempty"`
+	// Terminate also stops the executions already running.
+	Terminate bool `json:"terminate,omitempty"`
+}
+
+// DisableResponse is the body of the response to a disable request.
+type DisableResponse struct {
+	runner.KillSwitchStatus
+	// Terminated is the number of running executions that were stopped.
+	Terminated int `json:"terminated"`
+}
+
+// ResetQuotaRequest is the body of a quota reset request.
+type ResetQuotaRequest struct {
+	// Caller is the key of the identity whose usage is cleared.
+	Caller string `json:"caller"`
+}
+
+// errorBody is the body of an error response.
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+// Handler returns an HTTP API for operators:
+//
+//	GET  /killswitch          returns the state of the kill switch
+//	POST /killswitch/disable  rejects new executions, with an optional {"reason": "...", "terminate": true} body
+//	POST /killswitch/enable   allows executions again
+//	GET  /quotas              returns today's usage of every caller towards its quotas
+//	POST /quotas/reset        clears today's usage of the caller in a {"caller": "..."} body
+//
+// If token is not empty, requests must carry it as "Authorization: Bearer <token>".
+func Handler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /killswitch", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, runner.KillSwitch())
+	})
+	mux.HandleFunc("POST /killswitch/disable", func(w http.ResponseWriter, r *http.Request) {
+		var body DisableRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxBodySize)).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+			writeJSON(w, http.StatusBadRequest, errorBody{Error: "invalid body: " + err.Error()})
+			return
+		}
+		runner.Disable(body.Reason)
+		var resp DisableResponse
+		if body.Terminate {
+			resp.Terminated = runner.TerminateRunning()
+		}
+		resp.KillSwitchStatus = runner.KillSwitch()
+		writeJSON(w, http.StatusOK, resp)
+	})
+	mux.HandleFunc("POST /killswitch/enable", func(w http.ResponseWriter, _ *http.Request) {
+		runner.Enable()
+		writeJSON(w, http.StatusOK, runner.KillSwitch())
+	})
+	mux.HandleFunc("GET /quotas", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, runner.Quotas())
+	})
+	mux.HandleFunc("POST /quotas/reset", func(w http.ResponseWriter, r *http.Request) {
+		var body ResetQuotaRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxBodySize)).Decode(&body); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorBody{Error: "invalid body: " + err.Error()})
+			return
+		}
+		if body.Caller == "" {
+			writeJSON(w, http.StatusBadRequest, errorBody{Error: "caller is required"})
+			return
+		}
+		runner.ResetQuota(body.Caller)
+		writeJSON(w, http.StatusOK, runner.QuotaUsageOf(body.Caller))
+	})
+
+	if token == "" {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, errorBody{Error: "unauthorized"})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// writeJSON writes v as the JSON response body.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// Client talks to the API served by Handler.
+type Client struct {
+	// BaseURL is the address of the API, e.g. "http://127.0.0.1:8082".
+	BaseURL string
+	// Token is sent as a bearer token when not empty.
+	Token string
+	// HTTPClient is used for requests. Defaults to a client with a 30 second timeout.
+	HTTPClient *http.Client
+}
+
+// Status returns the state of the kill switch.
+func (c *Client) Status(ctx context.Context) (runner.KillSwitchStatus, error) {
+	var status runner.KillSwitchStatus
+	err := c.do(ctx, http.MethodGet, "/killswitch", nil, &status)
+	return status, err
+}
+
+// Disable turns on the kill switch.
+func (c *Client) Disable(ctx context.Context, req DisableRequest) (DisableResponse, error) {
+	var resp DisableResponse
+	err := c.do(ctx, http.MethodPost, "/killswitch/disable", req, &resp)
+	return resp, err
+}
+
+// Enable turns off the kill switch.
+func (c *Client) Enable(ctx context.Context) (runner.KillSwitchStatus, error) {
+	var status runner.KillSwitchStatus
+	err := c.do(ctx, http.MethodPost, "/killswitch/enable", nil, &status)
+	return status, err
+}
+
+// Quotas returns today's usage of every caller towards its quotas.
+func (c *Client) Quotas(ctx context.Context) ([]runner.QuotaUsage, error) {
+	var usage []runner.QuotaUsage
+	err := c.do(ctx, http.MethodGet, "/quotas", nil, &usage)
+	return usage, err
+}
+
+// ResetQuota clears today's usage of caller and returns it.
+func (c *Client) ResetQuota(ctx context.Context, caller string) (runner.QuotaUsage, error) {
+	var usage runner.QuotaUsage
+	err := c.do(ctx, http.MethodPost, "/quotas/reset", ResetQuotaRequest{Caller: caller}, &usage)
+	return usage, err
+}
+
+// do sends a request and decodes the response into out, if out is not nil.
+func (c *Client) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.BaseURL, "/")+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: clientTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to contact admin API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		var e errorBody
+		if json.Unmarshal(data, &e) == nil && e.Error != "" {
+			return fmt.Errorf("admin API error: %s", e.Error)
+		}
+		return fmt.Errorf("admin API error: unexpected status %s", resp.Status)
+	}
+
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+	return nil
+}
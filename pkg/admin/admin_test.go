@@ -0,0 +1,86 @@
+package admin
+
+import (
+	"io"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/runner"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+func TestHandlerAndClient(t *testing.T) {
+	t.Cleanup(runner.Enable)
+	srv := httptest.NewServer(Handler("secret"))
+	defer srv.Close()
+	client := &Client{BaseURL: srv.URL, Token: "secret"}
+
+	status, err := client.Status(t.Context())
+	assert.NoError(t, err)
+	assert.False(t, status.Disabled)
+
+	resp, err := client.Disable(t.Context(), DisableRequest{Reason: "agent misbehaving", Terminate: true})
+	assert.NoError(t, err)
+	assert.True(t, resp.Disabled)
+	assert.Equal(t, "agent misbehaving", resp.Reason)
+	assert.Equal(t, 0, resp.Terminated)
+	assert.True(t, runner.KillSwitch().Disabled)
+
+	status, err = client.Status(t.Context())
+	assert.NoError(t, err)
+	assert.True(t, status.Disabled)
+	assert.Equal(t, "agent misbehaving", status.Reason)
+
+	status, err = client.Enable(t.Context())
+	assert.NoError(t, err)
+	assert.False(t, status.Disabled)
+	assert.False(t, runner.KillSwitch().Disabled)
+
+	// The token is required
+	unauthenticated := &Client{BaseURL: srv.URL}
+	_, err = unauthenticated.Disable(t.Context(), DisableRequest{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unauthorized")
+	assert.False(t, runner.KillSwitch().Disabled)
+}
+
+func TestQuotas(t *testing.T) {
+	srv := httptest.NewServer(Handler(""))
+	defer srv.Close()
+	client := &Client{BaseURL: srv.URL}
+
+	dir := t.TempDir()
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories: []string{dir},
+		AllowCommands:      []config.AllowCommand{{Command: "echo"}},
+		Quota:              config.QuotaConfig{MaxExecutions: 1},
+	}
+	log := logger.New()
+	r := runner.New(cfg, validator.New(cfg, log), log)
+	r.SetOutputs(io.Discard, io.Discard)
+	r.SetIdentity(identity.Identity{User: "admin-quota"})
+	assert.NoError(t, r.RunCommand(t.Context(), "echo hi", dir).Err)
+	assert.Error(t, r.RunCommand(t.Context(), "echo again", dir).Err)
+
+	usage, err := client.Quotas(t.Context())
+	assert.NoError(t, err)
+	i := slices.IndexFunc(usage, func(u runner.QuotaUsage) bool { return u.Caller == "admin-quota" })
+	assert.True(t, i >= 0)
+	assert.Equal(t, 1, usage[i].Executions)
+	assert.Equal(t, int64(3), usage[i].OutputBytes)
+
+	reset, err := client.ResetQuota(t.Context(), "admin-quota")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, reset.Executions)
+	assert.NoError(t, r.RunCommand(t.Context(), "echo again", dir).Err)
+
+	_, err = client.ResetQuota(t.Context(), "")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "caller is required")
+}
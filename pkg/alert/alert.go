@@ -0,0 +1,169 @@
+// Package alert raises high-severity notifications when a honeypot deny rule matches,
+// and tracks sessions frozen for review after such a match.
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+// webhookTimeout bounds how long delivering an alert to a webhook may take.
+const webhookTimeout = 5 * time.Second
+
+// Severity is the importance of an event.
+type Severity string
+
+// SeverityHigh is the severity of honeypot matches.
+const SeverityHigh Severity = "high"
+
+// Event describes a command that matched a deny rule marked alert.
+type Event struct {
+	Time     time.Time `json:"time"`
+	Severity Severity  `json:"severity"`
+	// Caller identifies the session, e.g. an SSH key fingerprint; it is empty when unknown.
+	Caller  string   `json:"caller,omitempty"`
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+	WorkDir string   `json:"workDir,omitempty"`
+	// Message is the denial message returned to the caller.
+	Message string `json:"message"`
+	// Frozen reports whether the session was frozen because of this event.
+	Frozen bool `json:"frozen"`
+}
+
+// Notifier delivers events.
+type Notifier interface {
+	Notify(ctx context.Context, e Event) error
+}
+
+// NotifierFunc adapts a function to a Notifier.
+type NotifierFunc func(ctx context.Context, e Event) error
+
+// Notify calls f.
+func (f NotifierFunc) Notify(ctx context.Context, e Event) error {
+	return f(ctx, e)
+}
+
+// Webhook posts events as JSON to a URL.
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+// Notify posts e to the webhook and fails unless it responds with a 2xx status.
+func (w *Webhook) Notify(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to deliver alert: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("failed to deliver alert: webhook responded %s", resp.Status)
+	}
+	return nil
+}
+
+// Alerter sends events to its notifiers and freezes the offending sessions when
+// configured to. It is safe for concurrent use and is shared by every session of a server.
+type Alerter struct {
+	freeze bool
+
+	mu        sync.Mutex
+	notifiers []Notifier
+	frozen    map[string]Event
+}
+
+// New returns an Alerter for cfg, posting to cfg.WebhookURL when it is set.
+func New(cfg config.AlertConfig) *Alerter {
+	a := &Alerter{freeze: cfg.FreezeSession, frozen: make(map[string]Event)}
+	if cfg.WebhookURL != "" {
+		a.notifiers = append(a.notifiers, &Webhook{URL: cfg.WebhookURL})
+	}
+	return a
+}
+
+// AddNotifier delivers future events to n as well.
+func (a *Alerter) AddNotifier(n Notifier) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.notifiers = append(a.notifiers, n)
+}
+
+// Alert freezes the caller's session if configured to and delivers e to every notifier.
+// Sessions without a caller are never frozen. It returns the delivery errors, if any.
+func (a *Alerter) Alert(ctx context.Context, e Event) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Severity = SeverityHigh
+
+	a.mu.Lock()
+	if a.freeze && e.Caller != "" {
+		e.Frozen = true
+		a.frozen[e.Caller] = e
+	}
+	notifiers := append([]Notifier(nil), a.notifiers...)
+	a.mu.Unlock()
+
+	var errs []error
+	for _, n := range notifiers {
+		if err := n.Notify(ctx, e); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Frozen returns the event that froze the caller's session, if it is frozen.
+func (a *Alerter) Frozen(caller string) (Event, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	e, ok := a.frozen[caller]
+	return e, ok
+}
+
+// FrozenSessions returns the events that froze each frozen session, by caller.
+func (a *Alerter) FrozenSessions() map[string]Event {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	sessions := make(map[string]Event, len(a.frozen))
+	for caller, e := range a.frozen {
+		sessions[caller] = e
+	}
+	return sessions
+}
+
+// Thaw lets a frozen session run commands again and reports whether it was frozen.
+func (a *Alerter) Thaw(caller string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, ok := a.frozen[caller]
+	delete(a.frozen, caller)
+	return ok
+}
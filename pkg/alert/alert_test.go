@@ -0,0 +1,65 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+func TestWebhook(t *testing.T) {
+	received := make(chan Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var e Event
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(&e))
+		received <- e
+	}))
+	t.Cleanup(server.Close)
+
+	a := New(config.AlertConfig{WebhookURL: server.URL})
+	assert.NoError(t, a.Alert(t.Context(), Event{Caller: "key", Command: "cat", Args: []string{"/etc/shadow"}}))
+	e := <-received
+	assert.Equal(t, SeverityHigh, e.Severity)
+	assert.Equal(t, "cat", e.Command)
+	assert.False(t, e.Time.IsZero())
+	assert.False(t, e.Frozen)
+
+	// A failing webhook is reported
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(failing.Close)
+	assert.Error(t, New(config.AlertConfig{WebhookURL: failing.URL}).Alert(t.Context(), Event{Command: "cat"}))
+}
+
+func TestFreezeSession(t *testing.T) {
+	a := New(config.AlertConfig{FreezeSession: true})
+	var events []Event
+	a.AddNotifier(NotifierFunc(func(_ context.Context, e Event) error {
+		events = append(events, e)
+		return nil
+	}))
+
+	assert.NoError(t, a.Alert(t.Context(), Event{Caller: "key", Command: "cat"}))
+	assert.Equal(t, 1, len(events))
+	assert.True(t, events[0].Frozen)
+	e, frozen := a.Frozen("key")
+	assert.True(t, frozen)
+	assert.Equal(t, "cat", e.Command)
+	assert.Equal(t, 1, len(a.FrozenSessions()))
+
+	// Sessions without a caller cannot be frozen
+	assert.NoError(t, a.Alert(t.Context(), Event{Command: "cat"}))
+	_, frozen = a.Frozen("")
+	assert.False(t, frozen)
+
+	assert.True(t, a.Thaw("key"))
+	_, frozen = a.Frozen("key")
+	assert.False(t, frozen)
+	assert.False(t, a.Thaw("key"))
+}
@@ -0,0 +1,167 @@
+// Package approval holds commands that require a human decision before they run.
+//
+// A runner calls Wait for each command matching an allow rule with approvalRequired.
+// The request is listed by Pending until an approver calls Approve or Reject, or
+// until the wait timeout expires, in which case the command is denied.
+package approval
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout is how long a command waits for a decision when no timeout is configured.
+const DefaultTimeout = 5 * time.Minute
+
+// idBytes is the number of random bytes in an ID.
+const idBytes = 8
+
+var (
+	// ErrNotFound is returned when an ID is unknown or the request has already been decided.
+	ErrNotFound = errors.New("approval request not found")
+	// ErrRejected is returned by Wait when an approver rejects the command.
+	ErrRejected = errors.New("command was rejected")
+	// ErrTimeout is returned by Wait when no decision is made in time.
+	ErrTimeout = errors.New("timed out waiting for approval")
+)
+
+// ID identifies a pending request.
+type ID string
+
+// Request describes a command waiting for approval.
+type Request struct {
+	ID      ID       `json:"id"`
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+	WorkDir string   `json:"workDir"`
+	// Caller identifies who requested the command, as formatted by identity.Identity.String.
+	Caller string `json:"caller,omitempty"`
+	// Reason explains why approval is needed when it is not an approvalRequired rule, e.g. a high risk score.
+	Reason string `json:"reason,omitempty"`
+	// RequestedAt is when the command started waiting; ExpiresAt is when it will be denied.
+	RequestedAt time.Time `json:"requestedAt"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// decision is an approver's answer to a request.
+type decision struct {
+	approved bool
+	reason   string
+}
+
+// pending is a request and the channel its decision is delivered on.
+type pending struct {
+	req      Request
+	decision chan decision
+}
+
+// Queue holds pending requests. It is safe for concurrent use.
+type Queue struct {
+	timeout time.Duration
+
+	mu      sync.Mutex
+	pending map[ID]*pending
+}
+
+// New creates a Queue whose requests are denied after timeout. A zero timeout uses DefaultTimeout.
+func New(timeout time.Duration) *Queue {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Queue{timeout: timeout, pending: make(map[ID]*pending)}
+}
+
+// Wait queues the command described by req and blocks until it is approved, rejected,
+// timed out, or ctx is done. It returns nil only if the command was approved.
+func (q *Queue) Wait(ctx context.Context, req Request) error {
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+	req.ID = id
+	req.RequestedAt = time.Now()
+	req.ExpiresAt = req.RequestedAt.Add(q.timeout)
+
+	p := &pending{req: req, decision: make(chan decision, 1)}
+	q.mu.Lock()
+	q.pending[id] = p
+	q.mu.Unlock()
+	defer q.remove(id)
+
+	timer := time.NewTimer(q.timeout)
+	defer timer.Stop()
+
+	select {
+	case d := <-p.decision:
+		if d.approved {
+			return nil
+		}
+		if d.reason != "" {
+			return fmt.Errorf("%w: %s", ErrRejected, d.reason)
+		}
+		return ErrRejected
+	case <-timer.C:
+		return ErrTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Pending returns the requests waiting for a decision, oldest first.
+func (q *Queue) Pending() []Request {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	requests := make([]Request, 0, len(q.pending))
+	for _, p := range q.pending {
+		requests = append(requests, p.req)
+	}
+	slices.SortFunc(requests, func(a, b Request) int { return a.RequestedAt.Compare(b.RequestedAt) })
+	return requests
+}
+
+// Approve lets the command with the given ID run.
+func (q *Queue) Approve(id ID) error {
+	return q.decide(id, decision{approved: true})
+}
+
+// Reject denies the command with the given ID. The reason is included in the error returned to the caller.
+func (q *Queue) Reject(id ID, reason string) error {
+	return q.decide(id, decision{reason: reason})
+}
+
+// decide delivers a decision and removes the request so that it is decided only once.
+func (q *Queue) decide(id ID, d decision) error {
+	q.mu.Lock()
+	p, ok := q.pending[id]
+	delete(q.pending, id)
+	q.mu.Unlock()
+
+	if !ok {
+		return ErrNotFound
+	}
+	p.decision <- d
+	return nil
+}
+
+// remove drops a request once its waiter returns.
+func (q *Queue) remove(id ID) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.pending, id)
+}
+
+// newID returns a random request ID.
+func newID() (ID, error) {
+	b := make([]byte, idBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate approval id: %w", err)
+	}
+	return ID(hex.EncodeToString(b)), nil
+}
@@ -0,0 +1,126 @@
+package approval
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+// waitForPending polls until a request is pending and returns it.
+func waitForPending(t *testing.T, q *Queue) Request {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if pending := q.Pending(); len(pending) > 0 {
+			return pending[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no request became pending")
+	return Request{}
+}
+
+// waitAsync calls q.Wait in the background and returns its result channel.
+func waitAsync(ctx context.Context, q *Queue, req Request) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- q.Wait(ctx, req) }()
+	return done
+}
+
+func TestQueue_Approve(t *testing.T) {
+	q := New(time.Minute)
+	done := waitAsync(t.Context(), q, Request{Command: "rm", Args: []string{"-r", "build"}, WorkDir: "/tmp"})
+
+	req := waitForPending(t, q)
+	assert.Equal(t, "rm", req.Command)
+	assert.Equal(t, []string{"-r", "build"}, req.Args)
+	assert.Equal(t, "/tmp", req.WorkDir)
+	assert.NotEqual(t, "", req.ID)
+	assert.Equal(t, time.Minute, req.ExpiresAt.Sub(req.RequestedAt))
+
+	assert.NoError(t, q.Approve(req.ID))
+	assert.NoError(t, <-done)
+	assert.Equal(t, 0, len(q.Pending()))
+
+	// A request is decided only once
+	assert.IsError(t, q.Approve(req.ID), ErrNotFound)
+}
+
+func TestQueue_Reject(t *testing.T) {
+	q := New(time.Minute)
+	done := waitAsync(t.Context(), q, Request{Command: "rm"})
+
+	req := waitForPending(t, q)
+	assert.NoError(t, q.Reject(req.ID, "not during the freeze"))
+	err := <-done
+	assert.IsError(t, err, ErrRejected)
+	assert.Contains(t, err.Error(), "not during the freeze")
+}
+
+func TestQueue_Timeout(t *testing.T) {
+	q := New(20 * time.Millisecond)
+	err := q.Wait(t.Context(), Request{Command: "rm"})
+	assert.IsError(t, err, ErrTimeout)
+	assert.Equal(t, 0, len(q.Pending()))
+}
+
+func TestQueue_ContextCanceled(t *testing.T) {
+	q := New(time.Minute)
+	ctx, cancel := context.WithCancel(t.Context())
+	done := waitAsync(ctx, q, Request{Command: "rm"})
+
+	waitForPending(t, q)
+	cancel()
+	assert.True(t, errors.Is(<-done, context.Canceled))
+	assert.Equal(t, 0, len(q.Pending()))
+}
+
+func TestQueue_UnknownID(t *testing.T) {
+	q := New(0)
+	assert.IsError(t, q.Approve("missing"), ErrNotFound)
+	assert.IsError(t, q.Reject("missing", ""), ErrNotFound)
+}
+
+func TestHandlerAndClient(t *testing.T) {
+	q := New(time.Minute)
+	srv := httptest.NewServer(q.Handler("secret"))
+	defer srv.Close()
+	client := &Client{BaseURL: srv.URL, Token: "secret"}
+
+	first := waitAsync(t.Context(), q, Request{Command: "rm", Args: []string{"a"}})
+	waitForPending(t, q)
+	second := waitAsync(t.Context(), q, Request{Command: "git", Args: []string{"push"}})
+
+	var requests []Request
+	for range 100 {
+		var err error
+		requests, err = client.List(t.Context())
+		assert.NoError(t, err)
+		if len(requests) == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 2, len(requests))
+	assert.Equal(t, "rm", requests[0].Command)
+	assert.Equal(t, "git", requests[1].Command)
+
+	assert.NoError(t, client.Approve(t.Context(), requests[0].ID))
+	assert.NoError(t, <-first)
+	assert.NoError(t, client.Reject(t.Context(), requests[1].ID, "use a pull request"))
+	err := <-second
+	assert.IsError(t, err, ErrRejected)
+	assert.Contains(t, err.Error(), "use a pull request")
+
+	assert.IsError(t, client.Approve(t.Context(), requests[0].ID), ErrNotFound)
+
+	// The token is required
+	unauthenticated := &Client{BaseURL: srv.URL}
+	_, err = unauthenticated.List(t.Context())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unauthorized")
+}
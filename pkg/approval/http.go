@@ -0,0 +1,171 @@
+package approval
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// clientTimeout bounds a single request made by Client.
+const clientTimeout = 30 * time.Second
+
+// maxBodySize bounds request and response bodies.
+const maxBodySize = 1024 * 1024
+
+// rejectBody is the optional body of a reject request.
+type rejectBody struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+// errorBody is the body of an error response.
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+// Handler returns an HTTP API for approvers:
+//
+//	GET  /approvals               lists pending requests
+//	POST /approvals/{id}/approve  approves a request
+//	POST /approvals/{id}/reject   rejects a request, with an optional {"reason": "..."} body
+//
+// If token is not empty, requests must carry it as "Authorization: Bearer <token>".
+func (q *Queue) Handler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /approvals", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, q.Pending())
+	})
+	mux.HandleFunc("POST /approvals/{id}/approve", func(w http.ResponseWriter, r *http.Request) {
+		writeDecision(w, q.Approve(ID(r.PathValue("id"))))
+	})
+	mux.HandleFunc("POST /approvals/{id}/reject", func(w http.ResponseWriter, r *http.Request) {
+		var body rejectBody
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxBodySize)).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+			writeJSON(w, http.StatusBadRequest, errorBody{Error: "invalid body: " + err.Error()})
+			return
+		}
+		writeDecision(w, q.Reject(ID(r.PathValue("id")), body.Reason))
+	})
+
+	if token == "" {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, errorBody{Error: "unauthorized"})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// writeDecision answers an approve or reject request.
+func writeDecision(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeJSON(w, http.StatusNotFound, errorBody{Error: err.Error()})
+	case err != nil:
+		writeJSON(w, http.StatusInternalServerError, errorBody{Error: err.Error()})
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// writeJSON writes v as the JSON response body.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// Client talks to the API served by Handler.
+type Client struct {
+	// BaseURL is the address of the API, e.g. "http://127.0.0.1:8081".
+	BaseURL string
+	// Token is sent as a bearer token when not empty.
+	Token string
+	// HTTPClient is used for requests. Defaults to a client with a 30 second timeout.
+	HTTPClient *http.Client
+}
+
+// List returns the pending requests.
+func (c *Client) List(ctx context.Context) ([]Request, error) {
+	var requests []Request
+	if err := c.do(ctx, http.MethodGet, "/approvals", nil, &requests); err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+// Approve approves the request with the given ID.
+func (c *Client) Approve(ctx context.Context, id ID) error {
+	return c.do(ctx, http.MethodPost, "/approvals/"+url.PathEscape(string(id))+"/approve", nil, nil)
+}
+
+// Reject rejects the request with the given ID.
+func (c *Client) Reject(ctx context.Context, id ID, reason string) error {
+	return c.do(ctx, http.MethodPost, "/approvals/"+url.PathEscape(string(id))+"/reject", rejectBody{Reason: reason}, nil)
+}
+
+// do sends a request and decodes the response into out, if out is not nil.
+func (c *Client) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.BaseURL, "/")+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: clientTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to contact approval API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case resp.StatusCode >= http.StatusBadRequest:
+		var e errorBody
+		if json.Unmarshal(data, &e) == nil && e.Error != "" {
+			return fmt.Errorf("approval API error: %s", e.Error)
+		}
+		return fmt.Errorf("approval API error: unexpected status %s", resp.Status)
+	}
+
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+	return nil
+}
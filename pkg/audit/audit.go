@@ -0,0 +1,114 @@
+// Package audit sends blocked-command and execution events to external audit sinks, such as
+// the local syslog daemon or a remote one speaking RFC 5424 or CEF, so that they flow into
+// existing SIEM pipelines without a custom shipper. It also defines the records of the block
+// log and reads them back for analysis.
+package audit
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
+)
+
+// Outcome is what happened to a command.
+type Outcome string
+
+const (
+	// OutcomeDenied is the outcome of a command blocked by the policy, a hook, or an approver.
+	OutcomeDenied Outcome = "denied"
+	// OutcomeExecuted is the outcome of a command that ran, whatever its exit status.
+	OutcomeExecuted Outcome = "executed"
+	// OutcomeWouldDeny is the outcome of a command the policy denies that ran in permissive
+	// mode; its execution is reported as well.
+	OutcomeWouldDeny Outcome = "would-deny"
+)
+
+// Event describes a command that was denied or executed.
+type Event struct {
+	Time    time.Time
+	Outcome Outcome
+	// Caller is the identity of the caller; its fields are empty when unknown.
+	Caller  identity.Identity
+	Command string
+	Args    []string
+	WorkDir string
+	// Message is the denial message, or the error of a command that could not be run.
+	Message string
+	// ExitCode is the exit status of an executed command, or -1 when it could not be run.
+	ExitCode int
+	Duration time.Duration
+}
+
+// Sink delivers events to one destination.
+type Sink interface {
+	Send(e Event) error
+	Close() error
+}
+
+// Auditor sends every event to its sinks. It is safe for concurrent use and is shared by
+// every session of a server. A nil Auditor discards events; the zero value has no sinks until
+// AddSink is called.
+type Auditor struct {
+	mu    sync.Mutex
+	sinks []Sink
+}
+
+// New returns an Auditor sending to the sinks configured in cfg, or nil when none are.
+// Sinks connect when the first event is sent, so an unreachable daemon does not stop a server
+// from starting.
+func New(cfg config.AuditConfig) *Auditor {
+	if len(cfg.Syslog) == 0 {
+		return nil
+	}
+	a := &Auditor{}
+	for _, c := range cfg.Syslog {
+		a.sinks = append(a.sinks, NewSyslog(c))
+	}
+	return a
+}
+
+// AddSink sends future events to s as well.
+func (a *Auditor) AddSink(s Sink) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sinks = append(a.sinks, s)
+}
+
+// Send delivers e to every sink and returns the delivery errors, if any.
+func (a *Auditor) Send(e Event) error {
+	if a == nil {
+		return nil
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	a.mu.Lock()
+	sinks := append([]Sink(nil), a.sinks...)
+	a.mu.Unlock()
+
+	var errs []error
+	for _, s := range sinks {
+		if err := s.Send(e); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes every sink.
+func (a *Auditor) Close() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var errs []error
+	for _, s := range a.sinks {
+		errs = append(errs, s.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,202 @@
+package audit
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
+	"github.com/shimizu1995/secure-shell-server/pkg/logrotate"
+)
+
+// maxRecordSize bounds the length of one line of the block log.
+const maxRecordSize = 1024 * 1024
+
+// BlockRecord is one line of the block log (blockLogPath), written as JSON for every command
+// the policy blocks.
+type BlockRecord struct {
+	Timestamp time.Time `json:"timestamp"`
+	// Identity is the user and agent of the caller; either may be empty.
+	Identity identity.Identity `json:"identity,omitzero"`
+	// Session is the session of the caller, when known.
+	Session string `json:"session,omitempty"`
+	// Command is the blocked command. It is empty for denials of a whole script, such as one
+	// exceeding scriptLimits.maxSize.
+	Command string `json:"command"`
+	// Argv holds the arguments of the command, without the command itself.
+	Argv []string `json:"argv"`
+	// Rule is the policy rule that blocked the command, such as "deny-command" or "path".
+	Rule string `json:"rule"`
+	// Cwd is the working directory the command was validated in, when known.
+	Cwd     string `json:"cwd,omitempty"`
+	Message string `json:"message"`
+}
+
+// NewBlockRecord returns the record of cmd with args blocked by rule for the caller id.
+func NewBlockRecord(id identity.Identity, rule, cmd string, args []string, cwd, message string) BlockRecord {
+	if args == nil {
+		args = []string{}
+	}
+	return BlockRecord{
+		Timestamp: time.Now().UTC(),
+		Identity:  identity.Identity{User: id.User, Agent: id.Agent},
+		Session:   id.Session,
+		Command:   cmd,
+		Argv:      args,
+		Rule:      rule,
+		Cwd:       cwd,
+		Message:   message,
+	}
+}
+
+// Caller returns the identity of the caller, session included.
+func (r BlockRecord) Caller() identity.Identity {
+	id := r.Identity
+	id.Session = r.Session
+	return id
+}
+
+// Filter selects block records. Its zero value selects every record; each field that is set
+// must match.
+type Filter struct {
+	// Since and Until bound the time of the records, Until excluded.
+	Since time.Time
+	Until time.Time
+	// Command, Rule, User, Agent, Session, and Cwd match the fields of a record exactly.
+	Command string
+	Rule    string
+	User    string
+	Agent   string
+	Session string
+	Cwd     string
+}
+
+// Match reports whether r is selected by f.
+func (f Filter) Match(r BlockRecord) bool {
+	switch {
+	case !f.Since.IsZero() && r.Timestamp.Before(f.Since),
+		!f.Until.IsZero() && !r.Timestamp.Before(f.Until):
+		return false
+	}
+	for _, field := range []struct{ want, got string }{
+		{f.Command, r.Command},
+		{f.Rule, r.Rule},
+		{f.User, r.Identity.User},
+		{f.Agent, r.Identity.Agent},
+		{f.Session, r.Session},
+		{f.Cwd, r.Cwd},
+	} {
+		if field.want != "" && field.want != field.got {
+			return false
+		}
+	}
+	return true
+}
+
+// Keys that CountBy groups records by.
+var (
+	ByRule     = func(r BlockRecord) string { return r.Rule }
+	ByCommand  = func(r BlockRecord) string { return r.Command }
+	ByIdentity = func(r BlockRecord) string { return r.Caller().Key() }
+	BySession  = func(r BlockRecord) string { return r.Session }
+	ByDay      = func(r BlockRecord) string { return r.Timestamp.UTC().Format(time.DateOnly) }
+)
+
+// Reader reads the block log written at a path, including its rotated and compressed backups,
+// so that blocked commands can be searched and aggregated.
+type Reader struct {
+	path string
+}
+
+// NewReader returns a Reader of the block log at path.
+func NewReader(path string) *Reader {
+	return &Reader{path: path}
+}
+
+// Each calls fn for every record selected by f, oldest first, and stops at the first error fn
+// returns. Lines that are not records, such as those of the free-text format of earlier
+// versions, are skipped. A log that does not exist yet has no records.
+func (r *Reader) Each(f Filter, fn func(BlockRecord) error) error {
+	files, err := logrotate.Files(r.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := readBlockFile(file, f, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Records returns the records selected by f, oldest first.
+func (r *Reader) Records(f Filter) ([]BlockRecord, error) {
+	var records []BlockRecord
+	err := r.Each(f, func(record BlockRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	return records, err
+}
+
+// CountBy counts the records selected by f by the value key returns for them, such as ByRule.
+func (r *Reader) CountBy(f Filter, key func(BlockRecord) string) (map[string]int, error) {
+	counts := make(map[string]int)
+	err := r.Each(f, func(record BlockRecord) error {
+		counts[key(record)]++
+		return nil
+	})
+	return counts, err
+}
+
+// readBlockFile calls fn for the records of one file of the block log selected by f.
+func readBlockFile(path string, f Filter, fn func(BlockRecord) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		// A backup may be removed by a rotation while the log is read
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to open block log: %w", err)
+	}
+	defer file.Close()
+
+	var in io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("failed to read block log %s: %w", path, err)
+		}
+		defer gz.Close()
+		in = gz
+	}
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, maxRecordSize)
+	for scanner.Scan() {
+		var record BlockRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil || record.Timestamp.IsZero() {
+			continue
+		}
+		if !f.Match(record) {
+			continue
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read block log %s: %w", path, err)
+	}
+	return nil
+}
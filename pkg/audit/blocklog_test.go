@@ -0,0 +1,120 @@
+package audit
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
+)
+
+// writeBlockLog writes records, one JSON line each, to path, gzipped when path ends in .gz.
+func writeBlockLog(t *testing.T, path string, lines ...any) {
+	t.Helper()
+	var sb strings.Builder
+	for _, line := range lines {
+		if s, ok := line.(string); ok {
+			sb.WriteString(s + "\n")
+			continue
+		}
+		data, err := json.Marshal(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sb.Write(append(data, '\n'))
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if !strings.HasSuffix(path, ".gz") {
+		_, err = f.WriteString(sb.String())
+	} else {
+		gz := gzip.NewWriter(f)
+		if _, err = gz.Write([]byte(sb.String())); err == nil {
+			err = gz.Close()
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReader(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "blocked.log")
+
+	alice := identity.Identity{User: "alice", Session: "s1"}
+	rm := NewBlockRecord(alice, "deny-command", "rm", []string{"-rf", "/"}, "/work", `command "rm" is denied`)
+	rm.Timestamp = testTime
+	curl := NewBlockRecord(identity.Identity{Agent: "claude"}, "not-allowed", "curl", nil, "/work", `command "curl" is not permitted`)
+	curl.Timestamp = testTime.Add(time.Hour)
+	rm2 := rm
+	rm2.Timestamp = testTime.Add(2 * time.Hour)
+
+	// Rotated backups are read before the current file, whatever their format
+	writeBlockLog(t, filepath.Join(dir, "blocked-2026-03-01T12-00-00.000.log.gz"),
+		"2026-03-01T12:00:00Z [BLOCKED] Command: rm [], Reason: denied", rm)
+	writeBlockLog(t, filepath.Join(dir, "blocked-2026-03-01T13-00-00.000.log"), curl)
+	writeBlockLog(t, path, rm2)
+
+	r := NewReader(path)
+	records, err := r.Records(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || !records[0].Timestamp.Equal(rm.Timestamp) || records[1].Command != "curl" || !records[2].Timestamp.Equal(rm2.Timestamp) {
+		t.Fatalf("Records() = %+v", records)
+	}
+	if records[0].Caller() != alice || records[1].Argv == nil {
+		t.Errorf("Records()[0] = %+v", records[0])
+	}
+
+	for _, tt := range []struct {
+		filter Filter
+		want   int
+	}{
+		{Filter{Command: "rm"}, 2},
+		{Filter{Rule: "not-allowed"}, 1},
+		{Filter{User: "alice", Session: "s1"}, 2},
+		{Filter{Agent: "claude", Cwd: "/work"}, 1},
+		{Filter{Since: testTime.Add(time.Hour)}, 2},
+		{Filter{Until: testTime.Add(time.Hour)}, 1},
+		{Filter{Session: "s2"}, 0},
+	} {
+		got, err := r.Records(tt.filter)
+		if err != nil || len(got) != tt.want {
+			t.Errorf("Records(%+v) = %d records, %v; want %d", tt.filter, len(got), err, tt.want)
+		}
+	}
+
+	counts, err := r.CountBy(Filter{}, ByRule)
+	if err != nil || counts["deny-command"] != 2 || counts["not-allowed"] != 1 {
+		t.Errorf("CountBy(ByRule) = %v, %v", counts, err)
+	}
+	counts, err = r.CountBy(Filter{}, ByIdentity)
+	if err != nil || counts["alice"] != 2 || counts["claude"] != 1 {
+		t.Errorf("CountBy(ByIdentity) = %v, %v", counts, err)
+	}
+
+	// Each stops at the first error of its function
+	stop := errors.New("stop")
+	calls := 0
+	err = r.Each(Filter{}, func(BlockRecord) error { calls++; return stop })
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("Each() = %v after %d calls", err, calls)
+	}
+
+	// A log that was never written has no records
+	records, err = NewReader(filepath.Join(dir, "missing", "blocked.log")).Records(Filter{})
+	if err != nil || len(records) != 0 {
+		t.Errorf("Records() of a missing log = %v, %v", records, err)
+	}
+}
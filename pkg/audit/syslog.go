@@ -0,0 +1,274 @@
+package audit
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+const (
+	// defaultTag is the application name of messages when SyslogConfig.Tag is empty.
+	defaultTag = "secure-shell-server"
+	// defaultFacility is used when SyslogConfig.Facility is empty.
+	defaultFacility = "auth"
+	// writeTimeout bounds how long sending a single event may take.
+	writeTimeout = 5 * time.Second
+	// sdID is the ID of the structured data element of RFC 5424 messages. 32473 is the
+	// private enterprise number reserved for documentation (RFC 5612).
+	sdID = "shell@32473"
+)
+
+// Syslog severities of events.
+const (
+	severityWarning = 4
+	severityInfo    = 6
+)
+
+// Identification of the product in CEF records.
+const (
+	cefVendor  = "shimizu1995"
+	cefProduct = "secure-shell-server"
+	cefVersion = "1.0.0"
+)
+
+// CEF severities (0-10) of events.
+const (
+	cefSeverityDenied   = 5
+	cefSeverityExecuted = 1
+)
+
+// Syslog sends events to a local or remote syslog daemon. It connects on the first event and
+// reconnects once when a write fails, e.g. because the daemon restarted.
+type Syslog struct {
+	cfg      config.SyslogConfig
+	facility int
+	tag      string
+	hostname string
+	pid      int
+
+	mu   sync.Mutex
+	conn net.Conn
+	// framing writes a message to conn in the framing its transport expects
+	framing func(conn net.Conn, msg string) error
+}
+
+// NewSyslog returns a sink for the daemon described by cfg, which must pass cfg.Check.
+func NewSyslog(cfg config.SyslogConfig) *Syslog {
+	facility, ok := config.SyslogFacilities[cfg.Facility]
+	if !ok {
+		facility = config.SyslogFacilities[defaultFacility]
+	}
+	tag := cfg.Tag
+	if tag == "" {
+		tag = defaultTag
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	return &Syslog{cfg: cfg, facility: facility, tag: tag, hostname: hostname, pid: os.Getpid()}
+}
+
+// Send formats e and writes it to the daemon. Executions are skipped when DeniedOnly is set.
+func (s *Syslog) Send(e Event) error {
+	if s.cfg.DeniedOnly && e.Outcome == OutcomeExecuted {
+		return nil
+	}
+	msg := s.format(e)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for attempt := 0; ; attempt++ {
+		if s.conn == nil {
+			if err := s.connect(); err != nil {
+				return fmt.Errorf("failed to connect to syslog: %w", err)
+			}
+		}
+		err := s.framing(s.conn, msg)
+		if err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+		if attempt > 0 {
+			return fmt.Errorf("failed to send audit event to syslog: %w", err)
+		}
+	}
+}
+
+// Close closes the connection to the daemon, if any.
+func (s *Syslog) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// connect opens the connection to the daemon and chooses its framing.
+func (s *Syslog) connect() error {
+	var err error
+	switch s.cfg.Network {
+	case "":
+		s.conn, err = dialLocal()
+	default:
+		s.conn, err = net.DialTimeout(s.cfg.Network, s.cfg.Address, writeTimeout)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch s.conn.LocalAddr().Network() {
+	case "tcp":
+		// Octet counting (RFC 6587), since messages may contain newlines
+		s.framing = func(conn net.Conn, msg string) error {
+			return writeDeadline(conn, strconv.Itoa(len(msg))+" "+msg)
+		}
+	case "unix":
+		s.framing = func(conn net.Conn, msg string) error {
+			return writeDeadline(conn, msg+"\n")
+		}
+	default:
+		// Datagrams carry a single message each
+		s.framing = writeDeadline
+	}
+	return nil
+}
+
+// writeDeadline writes msg to conn, failing after writeTimeout.
+func writeDeadline(conn net.Conn, msg string) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return err
+	}
+	_, err := conn.Write([]byte(msg))
+	return err
+}
+
+// format returns the message of e: an RFC 5424 message to a remote daemon, or a message with
+// the traditional header that local daemons expect, carrying structured data or a CEF record.
+func (s *Syslog) format(e Event) string {
+	severity := severityInfo
+	if e.Outcome != OutcomeExecuted {
+		severity = severityWarning
+	}
+	pri := s.facility*8 + severity
+
+	var body string
+	if s.cfg.Format == config.SyslogFormatCEF {
+		body = formatCEF(e)
+	} else {
+		body = structuredData(e) + " " + summary(e)
+	}
+
+	if s.cfg.Network == "" {
+		return fmt.Sprintf("<%d>%s %s[%d]: %s", pri, e.Time.Format(time.Stamp), s.tag, s.pid, body)
+	}
+	if s.cfg.Format == config.SyslogFormatCEF {
+		// The CEF record is the message; it carries no structured data
+		body = "- " + body
+	}
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s %s",
+		pri, e.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"), s.hostname, s.tag, s.pid, e.Outcome, body)
+}
+
+// summary describes e in a sentence, e.g. "denied: rm -rf /: Command not allowed".
+func summary(e Event) string {
+	command := strings.Join(append([]string{e.Command}, e.Args...), " ")
+	switch {
+	case e.Outcome == OutcomeDenied:
+		return fmt.Sprintf("denied: %s: %s", command, e.Message)
+	case e.Outcome == OutcomeWouldDeny:
+		return fmt.Sprintf("would deny: %s: %s", command, e.Message)
+	case e.Message != "":
+		return fmt.Sprintf("failed: %s: %s", command, e.Message)
+	default:
+		return fmt.Sprintf("executed: %s (exit status %d)", command, e.ExitCode)
+	}
+}
+
+// structuredData returns the RFC 5424 structured data element describing e.
+func structuredData(e Event) string {
+	var sb strings.Builder
+	sb.WriteString("[" + sdID)
+	param := func(name, value string) {
+		if value != "" {
+			sb.WriteString(" " + name + `="` + sdEscaper.Replace(value) + `"`)
+		}
+	}
+	param("outcome", string(e.Outcome))
+	param("command", e.Command)
+	param("args", strings.Join(e.Args, " "))
+	param("workDir", e.WorkDir)
+	param("user", e.Caller.User)
+	param("agent", e.Caller.Agent)
+	param("session", e.Caller.Session)
+	if e.Outcome == OutcomeExecuted {
+		param("exitCode", strconv.Itoa(e.ExitCode))
+		param("durationMs", strconv.FormatInt(e.Duration.Milliseconds(), 10))
+	}
+	param("message", e.Message)
+	sb.WriteString("]")
+	return sb.String()
+}
+
+// sdEscaper escapes the characters RFC 5424 does not permit unescaped in parameter values.
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// formatCEF returns e as an ArcSight Common Event Format record.
+func formatCEF(e Event) string {
+	signature, name, severity := "command-executed", "Command executed", cefSeverityExecuted
+	switch e.Outcome {
+	case OutcomeDenied:
+		signature, name, severity = "command-denied", "Command denied", cefSeverityDenied
+	case OutcomeWouldDeny:
+		signature, name, severity = "command-would-deny", "Command would be denied", cefSeverityDenied
+	}
+
+	var ext []string
+	add := func(key, value string) {
+		if value != "" {
+			ext = append(ext, key+"="+cefValueEscaper.Replace(value))
+		}
+	}
+	add("rt", strconv.FormatInt(e.Time.UnixMilli(), 10))
+	add("act", string(e.Outcome))
+	add("dproc", e.Command)
+	if len(e.Args) > 0 {
+		add("cs1Label", "args")
+		add("cs1", strings.Join(e.Args, " "))
+	}
+	if e.WorkDir != "" {
+		add("cs2Label", "workDir")
+		add("cs2", e.WorkDir)
+	}
+	add("suser", e.Caller.User)
+	add("requestClientApplication", e.Caller.Agent)
+	if e.Caller.Session != "" {
+		add("cs3Label", "session")
+		add("cs3", e.Caller.Session)
+	}
+	if e.Outcome == OutcomeExecuted {
+		add("cn1Label", "exitCode")
+		add("cn1", strconv.Itoa(e.ExitCode))
+	}
+	add("msg", e.Message)
+
+	return fmt.Sprintf("CEF:0|%s|%s|%s|%s|%s|%d|%s",
+		cefHeaderEscaper.Replace(cefVendor), cefHeaderEscaper.Replace(cefProduct), cefHeaderEscaper.Replace(cefVersion),
+		signature, name, severity, strings.Join(ext, " "))
+}
+
+// cefHeaderEscaper escapes the characters CEF does not permit unescaped in header fields.
+var cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`)
+
+// cefValueEscaper escapes the characters CEF does not permit unescaped in extension values.
+var cefValueEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
@@ -0,0 +1,153 @@
+package audit
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
+)
+
+var testTime = time.Date(2026, 3, 1, 12, 30, 45, 123456000, time.UTC)
+
+func deniedEvent() Event {
+	return Event{
+		Time:    testTime,
+		Outcome: OutcomeDenied,
+		Caller:  identity.Identity{User: "uid:1001", Session: "pid:42"},
+		Command: "rm",
+		Args:    []string{"-rf", "/srv"},
+		WorkDir: "/srv",
+		Message: `command "rm" is denied = [policy]`,
+	}
+}
+
+func TestSyslog_FormatRFC5424(t *testing.T) {
+	s := NewSyslog(config.SyslogConfig{Network: "udp", Address: "127.0.0.1:514"})
+	s.hostname, s.pid = "host", 7
+
+	want := `<36>1 2026-03-01T12:30:45.123456Z host secure-shell-server 7 denied ` +
+		`[shell@32473 outcome="denied" command="rm" args="-rf /srv" workDir="/srv" user="uid:1001" session="pid:42" message="command \"rm\" is denied = [policy\]"] ` +
+		`denied: rm -rf /srv: command "rm" is denied = [policy]`
+	if got := s.format(deniedEvent()); got != want {
+		t.Errorf("format() =\n%s\nwant\n%s", got, want)
+	}
+
+	executed := Event{Time: testTime, Outcome: OutcomeExecuted, Command: "ls", Duration: 1500 * time.Millisecond}
+	if got := s.format(executed); !strings.HasPrefix(got, "<38>1 ") ||
+		!strings.Contains(got, `[shell@32473 outcome="executed" command="ls" exitCode="0" durationMs="1500"] executed: ls (exit status 0)`) {
+		t.Errorf("format() = %s", got)
+	}
+
+	wouldDeny := deniedEvent()
+	wouldDeny.Outcome = OutcomeWouldDeny
+	if got := s.format(wouldDeny); !strings.HasPrefix(got, "<36>1 ") ||
+		!strings.HasSuffix(got, `would deny: rm -rf /srv: command "rm" is denied = [policy]`) {
+		t.Errorf("format() = %s", got)
+	}
+}
+
+func TestSyslog_FormatCEF(t *testing.T) {
+	s := NewSyslog(config.SyslogConfig{Network: "tcp", Address: "127.0.0.1:514", Format: config.SyslogFormatCEF, Facility: "local3"})
+	s.hostname, s.pid = "host", 7
+
+	want := `<156>1 2026-03-01T12:30:45.123456Z host secure-shell-server 7 denied - ` +
+		`CEF:0|shimizu1995|secure-shell-server|1.0.0|command-denied|Command denied|5|` +
+		`rt=1772368245123 act=denied dproc=rm cs1Label=args cs1=-rf /srv cs2Label=workDir cs2=/srv suser=uid:1001 ` +
+		`cs3Label=session cs3=pid:42 msg=command "rm" is denied \= [policy]`
+	if got := s.format(deniedEvent()); got != want {
+		t.Errorf("format() =\n%s\nwant\n%s", got, want)
+	}
+
+	// Local daemons receive the traditional header
+	s = NewSyslog(config.SyslogConfig{Format: config.SyslogFormatCEF, Tag: "shell"})
+	s.pid = 7
+	if got := s.format(deniedEvent()); !strings.HasPrefix(got, "<36>Mar  1 12:30:45 shell[7]: CEF:0|") {
+		t.Errorf("format() = %s", got)
+	}
+}
+
+func TestSyslog_SendUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s := NewSyslog(config.SyslogConfig{Network: "udp", Address: conn.LocalAddr().String(), DeniedOnly: true})
+	defer s.Close()
+	if err := s.Send(Event{Time: testTime, Outcome: OutcomeExecuted, Command: "ls"}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if err := s.Send(deniedEvent()); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	buf := make([]byte, 4096)
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("ReadFrom() error = %v", err)
+	}
+	// The execution was skipped, so the first datagram is the denial
+	if got := string(buf[:n]); !strings.Contains(got, " denied [shell@32473 ") {
+		t.Errorf("received %q", got)
+	}
+}
+
+func TestSyslog_SendTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		var messages []string
+		for range 2 {
+			length, err := r.ReadString(' ')
+			if err != nil {
+				break
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(length))
+			msg := make([]byte, n)
+			if _, err := io.ReadFull(r, msg); err != nil {
+				break
+			}
+			messages = append(messages, string(msg))
+		}
+		received <- messages
+	}()
+
+	s := NewSyslog(config.SyslogConfig{Network: "tcp", Address: ln.Addr().String()})
+	defer s.Close()
+	event := deniedEvent()
+	event.Message = "first line\nsecond line"
+	for range 2 {
+		if err := s.Send(event); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+
+	select {
+	case messages := <-received:
+		if len(messages) != 2 || !strings.HasSuffix(messages[0], "first line\nsecond line") {
+			t.Errorf("received %q, want two octet-counted messages", messages)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for messages")
+	}
+}
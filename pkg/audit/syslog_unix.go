@@ -0,0 +1,23 @@
+//go:build !windows
+
+package audit
+
+import (
+	"errors"
+	"net"
+)
+
+// localSockets are the paths at which syslog daemons listen on common systems.
+var localSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// dialLocal connects to the local syslog daemon, preferring datagrams as log/syslog does.
+func dialLocal() (net.Conn, error) {
+	for _, network := range []string{"unixgram", "unix"} {
+		for _, path := range localSockets {
+			if conn, err := net.Dial(network, path); err == nil {
+				return conn, nil
+			}
+		}
+	}
+	return nil, errors.New("no local syslog daemon found")
+}
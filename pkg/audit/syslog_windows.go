@@ -0,0 +1,13 @@
+//go:build windows
+
+package audit
+
+import (
+	"errors"
+	"net"
+)
+
+// dialLocal fails: Windows has no local syslog daemon, so a remote one must be configured.
+func dialLocal() (net.Conn, error) {
+	return nil, errors.New("local syslog is not supported on Windows; configure a remote daemon")
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
+)
+
+// APIKeys authenticates static API keys presented as "Authorization: Bearer <key>". Only
+// the SHA-256 hashes of the keys are held.
+type APIKeys struct {
+	users map[[sha256.Size]byte]string
+}
+
+// NewAPIKeys returns an Authenticator of keys.
+func NewAPIKeys(keys []config.APIKeyConfig) (*APIKeys, error) {
+	a := &APIKeys{users: make(map[[sha256.Size]byte]string, len(keys))}
+	for i, key := range keys {
+		var sum [sha256.Size]byte
+		if n, err := hex.Decode(sum[:], []byte(key.SHA256)); err != nil || n != sha256.Size {
+			return nil, fmt.Errorf("API key %d: sha256 must be a hex-encoded SHA-256 hash", i)
+		}
+		a.users[sum] = key.User
+	}
+	return a, nil
+}
+
+// Authenticate implements Authenticator.
+func (a *APIKeys) Authenticate(r *http.Request) (identity.Identity, error) {
+	token, err := bearerToken(r)
+	if err != nil {
+		return identity.Identity{}, err
+	}
+	// Keys are looked up by their hash, so the lookup does not reveal the keys it compares
+	user, ok := a.users[sha256.Sum256([]byte(token))]
+	if !ok {
+		return identity.Identity{}, fmt.Errorf("%w: unknown API key", ErrInvalidCredentials)
+	}
+	return identity.Identity{User: user}, nil
+}
@@ -0,0 +1,123 @@
+// Package auth authenticates the callers of the HTTP server. An Authenticator resolves the
+// identity of a request from its credentials: a static API key, a JSON Web Token verified
+// against a JSON Web Key Set, or a TLS client certificate. Middleware attaches the identity to
+// the request's context, so that the policy of the user applies to its commands and audit
+// records name it.
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+)
+
+var (
+	// ErrNoCredentials is returned by an Authenticator when a request carries no credentials
+	// of the kind it checks.
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidCredentials is returned by an Authenticator when the credentials of a request
+	// are not accepted.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Authenticator resolves the identity of the caller of a request.
+type Authenticator interface {
+	// Authenticate returns the identity of the caller of r. It fails with ErrNoCredentials
+	// when r carries none of the credentials it checks.
+	Authenticate(r *http.Request) (identity.Identity, error)
+}
+
+// Chain is an Authenticator trying each of its Authenticators in turn. A request is
+// authenticated by the first that accepts it.
+type Chain []Authenticator
+
+// Authenticate implements Authenticator. It fails with ErrNoCredentials only when every
+// Authenticator of the chain does.
+func (c Chain) Authenticate(r *http.Request) (identity.Identity, error) {
+	var errs []error
+	for _, a := range c {
+		id, err := a.Authenticate(r)
+		if err == nil {
+			return id, nil
+		}
+		if !errors.Is(err, ErrNoCredentials) {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return identity.Identity{}, ErrNoCredentials
+	}
+	return identity.Identity{}, errors.Join(errs...)
+}
+
+// New returns the Authenticator of the methods configured in cfg, or nil when none is.
+func New(cfg config.AuthConfig) (Authenticator, error) {
+	var chain Chain
+	if len(cfg.APIKeys) > 0 {
+		keys, err := NewAPIKeys(cfg.APIKeys)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, keys)
+	}
+	if cfg.JWT.JWKSURL != "" {
+		chain = append(chain, NewJWT(cfg.JWT, http.DefaultClient))
+	}
+	if cfg.MTLS.ClientCA != "" {
+		roots, err := LoadClientCAs(cfg.MTLS.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, NewClientCert(roots))
+	}
+	switch len(chain) {
+	case 0:
+		return nil, nil
+	case 1:
+		return chain[0], nil
+	default:
+		return chain, nil
+	}
+}
+
+// Middleware authenticates every request with a before passing it to next, with the
+// identity of its caller attached to its context. The agent of the identity defaults to the
+// request's User-Agent. Requests that fail authentication are answered with 401 Unauthorized
+// and logged to log.
+func Middleware(a Authenticator, log *logger.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := a.Authenticate(r)
+		if err != nil {
+			log.LogErrorf("HTTP authentication rejected from %s: %v", r.RemoteAddr, err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="secure-shell-server"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if id.Agent == "" {
+			id.Agent = r.UserAgent()
+		}
+		next.ServeHTTP(w, r.WithContext(identity.WithIdentity(r.Context(), id)))
+	})
+}
+
+// bearerToken returns the token of the request's "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", ErrNoCredentials
+	}
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", ErrNoCredentials
+	}
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", fmt.Errorf("%w: empty bearer token", ErrInvalidCredentials)
+	}
+	return token, nil
+}
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+)
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func requestWithToken(token string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+func TestAPIKeys(t *testing.T) {
+	keys, err := NewAPIKeys([]config.APIKeyConfig{
+		{User: "ci", SHA256: hashKey("ci-key")},
+		{User: "alice", SHA256: hashKey("alice-key")},
+	})
+	assert.NoError(t, err)
+
+	id, err := keys.Authenticate(requestWithToken("alice-key"))
+	assert.NoError(t, err)
+	assert.Equal(t, identity.Identity{User: "alice"}, id)
+
+	_, err = keys.Authenticate(requestWithToken("wrong-key"))
+	assert.IsError(t, err, ErrInvalidCredentials)
+	_, err = keys.Authenticate(requestWithToken(""))
+	assert.IsError(t, err, ErrNoCredentials)
+
+	r := requestWithToken("")
+	r.Header.Set("Authorization", "Basic YWxpY2U6a2V5")
+	_, err = keys.Authenticate(r)
+	assert.IsError(t, err, ErrNoCredentials)
+
+	_, err = NewAPIKeys([]config.APIKeyConfig{{User: "ci", SHA256: "abc"}})
+	assert.Error(t, err)
+}
+
+// staticAuthenticator returns the same identity and error for every request.
+type staticAuthenticator struct {
+	id  identity.Identity
+	err error
+}
+
+func (s staticAuthenticator) Authenticate(*http.Request) (identity.Identity, error) {
+	return s.id, s.err
+}
+
+func TestChain(t *testing.T) {
+	rejected := errors.New("rejected")
+	tests := []struct {
+		name    string
+		chain   Chain
+		want    identity.Identity
+		wantErr error
+	}{
+		{
+			name:  "first accepting authenticator wins",
+			chain: Chain{staticAuthenticator{err: ErrNoCredentials}, staticAuthenticator{id: identity.Identity{User: "bob"}}},
+			want:  identity.Identity{User: "bob"},
+		},
+		{
+			name:  "rejection does not stop the chain",
+			chain: Chain{staticAuthenticator{err: rejected}, staticAuthenticator{id: identity.Identity{User: "bob"}}},
+			want:  identity.Identity{User: "bob"},
+		},
+		{
+			name:    "no credentials at all",
+			chain:   Chain{staticAuthenticator{err: ErrNoCredentials}, staticAuthenticator{err: ErrNoCredentials}},
+			wantErr: ErrNoCredentials,
+		},
+		{
+			name:    "rejections are reported",
+			chain:   Chain{staticAuthenticator{err: ErrNoCredentials}, staticAuthenticator{err: rejected}},
+			wantErr: rejected,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := tt.chain.Authenticate(requestWithToken(""))
+			if tt.wantErr != nil {
+				assert.IsError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, id)
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	keys, err := NewAPIKeys([]config.APIKeyConfig{{User: "ci", SHA256: hashKey("ci-key")}})
+	assert.NoError(t, err)
+	var got identity.Identity
+	handler := Middleware(keys, logger.New(), http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		got, _ = identity.FromContext(r.Context())
+	}))
+
+	w := httptest.NewRecorder()
+	r := requestWithToken("ci-key")
+	r.Header.Set("User-Agent", "agent/1.0")
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, identity.Identity{User: "ci", Agent: "agent/1.0"}, got)
+
+	got = identity.Identity{}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, requestWithToken("other-key"))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.NotEqual(t, "", w.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, identity.Identity{}, got)
+}
+
+func TestNew(t *testing.T) {
+	a, err := New(config.AuthConfig{})
+	assert.NoError(t, err)
+	assert.Zero(t, a)
+
+	a, err = New(config.AuthConfig{APIKeys: []config.APIKeyConfig{{User: "ci", SHA256: hashKey("ci-key")}}})
+	assert.NoError(t, err)
+	_, ok := a.(*APIKeys)
+	assert.True(t, ok)
+
+	a, err = New(config.AuthConfig{
+		APIKeys: []config.APIKeyConfig{{User: "ci", SHA256: hashKey("ci-key")}},
+		JWT:     config.JWTConfig{JWKSURL: "https://issuer.example/jwks.json"},
+	})
+	assert.NoError(t, err)
+	chain, ok := a.(Chain)
+	assert.True(t, ok)
+	assert.Equal(t, 2, len(chain))
+
+	_, err = New(config.AuthConfig{MTLS: config.MTLSConfig{ClientCA: "/nonexistent/ca.pem"}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,394 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
+)
+
+const (
+	// defaultJWKSRefresh is how often the key set is fetched when JWTConfig.RefreshInterval is zero.
+	defaultJWKSRefresh = time.Hour
+	// jwksMinRefresh bounds how often tokens signed by unknown keys fetch the key set again, so
+	// that forged key IDs cannot flood the key server.
+	jwksMinRefresh = time.Minute
+	// jwksFetchTimeout bounds a fetch of the key set.
+	jwksFetchTimeout = 10 * time.Second
+	// maxJWKSSize bounds the key set read.
+	maxJWKSSize = 1024 * 1024
+	// clockSkew is the leeway of the expiry and not-before checks.
+	clockSkew = time.Minute
+)
+
+// JWT authenticates JSON Web Tokens presented as "Authorization: Bearer <token>". Tokens must
+// be signed with RS256, RS384, RS512, PS256, PS384, PS512, ES256, ES384, ES512, or EdDSA by a
+// key of the configured JSON Web Key Set, and must not have expired. The user is the
+// configured claim and the agent the "azp" or "client_id" claim.
+type JWT struct {
+	cfg     config.JWTConfig
+	client  *http.Client
+	refresh time.Duration
+	// now returns the current time; tests replace it
+	now func() time.Time
+
+	mu sync.Mutex
+	// keys holds the keys of the set by key ID
+	keys map[string]jwk
+	// fetched is when the key set was last fetched, successfully or not
+	fetched time.Time
+}
+
+// jwk is a verification key of the key set.
+type jwk struct {
+	key crypto.PublicKey
+	// alg, when set, is the only algorithm the key verifies
+	alg string
+}
+
+// NewJWT returns an Authenticator of the tokens described by cfg, fetching the key set with client.
+func NewJWT(cfg config.JWTConfig, client *http.Client) *JWT {
+	if cfg.UserClaim == "" {
+		cfg.UserClaim = config.DefaultJWTUserClaim
+	}
+	refresh := defaultJWKSRefresh
+	if cfg.RefreshInterval > 0 {
+		refresh = time.Duration(cfg.RefreshInterval) * time.Second
+	}
+	return &JWT{cfg: cfg, client: client, refresh: refresh, now: time.Now}
+}
+
+// jwtHeader is the header of a token.
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Authenticate implements Authenticator. Bearer tokens that are not JSON Web Tokens are
+// reported as ErrNoCredentials, so that they may be API keys.
+func (j *JWT) Authenticate(r *http.Request) (identity.Identity, error) {
+	token, err := bearerToken(r)
+	if err != nil {
+		return identity.Identity{}, err
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return identity.Identity{}, ErrNoCredentials
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return identity.Identity{}, fmt.Errorf("%w: malformed token header: %w", ErrInvalidCredentials, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return identity.Identity{}, fmt.Errorf("%w: malformed token signature", ErrInvalidCredentials)
+	}
+	key, err := j.key(r.Context(), header.Kid)
+	if err != nil {
+		return identity.Identity{}, err
+	}
+	if key.alg != "" && key.alg != header.Alg {
+		return identity.Identity{}, fmt.Errorf("%w: key %q does not verify %s", ErrInvalidCredentials, header.Kid, header.Alg)
+	}
+	if err := verifySignature(header.Alg, key.key, parts[0]+"."+parts[1], signature); err != nil {
+		return identity.Identity{}, fmt.Errorf("%w: %w", ErrInvalidCredentials, err)
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return identity.Identity{}, fmt.Errorf("%w: malformed token claims: %w", ErrInvalidCredentials, err)
+	}
+	if err := j.checkClaims(claims); err != nil {
+		return identity.Identity{}, fmt.Errorf("%w: %w", ErrInvalidCredentials, err)
+	}
+	user, _ := claims[j.cfg.UserClaim].(string)
+	if user == "" {
+		return identity.Identity{}, fmt.Errorf("%w: token has no %q claim", ErrInvalidCredentials, j.cfg.UserClaim)
+	}
+	id := identity.Identity{User: user}
+	for _, name := range []string{"azp", "client_id"} {
+		if agent, ok := claims[name].(string); ok && agent != "" {
+			id.Agent = agent
+			break
+		}
+	}
+	return id, nil
+}
+
+// checkClaims checks the expiry, not-before time, issuer, and audience of a token.
+func (j *JWT) checkClaims(claims map[string]any) error {
+	now := j.now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("token has no expiry")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return errors.New("token has expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(clockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("token is not valid yet")
+	}
+	if j.cfg.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != j.cfg.Issuer {
+			return fmt.Errorf("token issuer %q is not %q", iss, j.cfg.Issuer)
+		}
+	}
+	if j.cfg.Audience != "" {
+		var audiences []string
+		switch aud := claims["aud"].(type) {
+		case string:
+			audiences = []string{aud}
+		case []any:
+			for _, a := range aud {
+				if s, ok := a.(string); ok {
+					audiences = append(audiences, s)
+				}
+			}
+		}
+		if !slices.Contains(audiences, j.cfg.Audience) {
+			return fmt.Errorf("token is not issued for %q", j.cfg.Audience)
+		}
+	}
+	return nil
+}
+
+// key returns the key with ID kid, fetching the key set when it is due for a refresh or, at
+// most every jwksMinRefresh, when it has no such key. A token without a key ID is verified
+// with the only key of a set holding one.
+func (j *JWT) key(ctx context.Context, kid string) (jwk, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := j.now()
+	key, ok := j.lookup(kid)
+	due := j.keys == nil || now.Sub(j.fetched) >= j.refresh
+	if due || (!ok && now.Sub(j.fetched) >= jwksMinRefresh) {
+		j.fetched = now
+		keys, err := j.fetch(ctx)
+		if err != nil && !ok {
+			return jwk{}, fmt.Errorf("failed to fetch the JSON Web Key Set: %w", err)
+		}
+		// A key set that cannot be fetched leaves the known keys in use
+		if err == nil {
+			j.keys = keys
+			key, ok = j.lookup(kid)
+		}
+	}
+	if !ok {
+		return jwk{}, fmt.Errorf("%w: unknown signing key %q", ErrInvalidCredentials, kid)
+	}
+	return key, nil
+}
+
+// lookup returns the known key with ID kid.
+func (j *JWT) lookup(kid string) (jwk, bool) {
+	if kid == "" && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key, true
+		}
+	}
+	key, ok := j.keys[kid]
+	return key, ok
+}
+
+// jsonWebKey is a key of a JSON Web Key Set (RFC 7517).
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetch reads the key set. Keys of unsupported types, or not meant for signatures, are skipped.
+func (j *JWT) fetch(ctx context.Context) (map[string]jwk, error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), jwksFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.cfg.JWKSURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxJWKSSize)).Decode(&set); err != nil {
+		return nil, fmt.Errorf("malformed key set: %w", err)
+	}
+
+	keys := make(map[string]jwk, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+		if key != nil {
+			keys[k.Kid] = jwk{key: key, alg: k.Alg}
+		}
+	}
+	return keys, nil
+}
+
+// publicKey returns the key, or nil if its type is not supported.
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, errors.New("malformed modulus")
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("malformed exponent")
+		}
+		exponent := new(big.Int).SetBytes(e).Int64()
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent)}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, nil
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(k.X)
+		y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+		if errX != nil || errY != nil {
+			return nil, errors.New("malformed coordinates")
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		point := make([]byte, 1, 1+2*size)
+		point[0] = 4
+		point = append(point, leftPad(x, size)...)
+		point = append(point, leftPad(y, size)...)
+		return ecdsa.ParseUncompressedPublicKey(curve, point)
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, nil
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("malformed Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, nil
+	}
+}
+
+// leftPad pads b with leading zeros to size bytes.
+func leftPad(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
+
+// ecdsaCurveBits is the size of the curve of each ECDSA algorithm by its hash: P-256 for
+// ES256, P-384 for ES384, and P-521 for ES512.
+var ecdsaCurveBits = map[crypto.Hash]int{crypto.SHA256: 256, crypto.SHA384: 384, crypto.SHA512: 521}
+
+// verifySignature verifies the signature of input made with the algorithm alg.
+func verifySignature(alg string, key crypto.PublicKey, input string, signature []byte) error {
+	var hash crypto.Hash
+	switch alg[min(2, len(alg)):] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	}
+
+	switch {
+	case alg == "EdDSA":
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(pub, []byte(input), signature) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case hash == 0:
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(input))
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS", "PS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key does not verify %s", alg)
+		}
+		var err error
+		if alg[0] == 'R' {
+			err = rsa.VerifyPKCS1v15(pub, hash, digest, signature)
+		} else {
+			err = rsa.VerifyPSS(pub, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		if err != nil {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve.Params().BitSize != ecdsaCurveBits[hash] {
+			return fmt.Errorf("key does not verify %s", alg)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+}
+
+// decodeSegment decodes a base64url-encoded JSON segment of a token into v.
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
+)
+
+// testIssuer signs tokens and serves the JSON Web Key Set verifying them.
+type testIssuer struct {
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+	edKey  ed25519.PrivateKey
+	keys   []map[string]string
+	// fetches counts the requests for the key set
+	fetches atomic.Int32
+	server  *httptest.Server
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	ecPoint, err := ecKey.PublicKey.Bytes()
+	assert.NoError(t, err)
+	iss := &testIssuer{
+		rsaKey: rsaKey,
+		ecKey:  ecKey,
+		edKey:  edKey,
+		keys: []map[string]string{
+			{"kty": "RSA", "kid": "rsa", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecPoint[1:33]), "y": b64(ecPoint[33:])},
+			{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "alg": "EdDSA", "x": b64(edKey.Public().(ed25519.PublicKey))},
+			{"kty": "RSA", "kid": "enc", "use": "enc", "n": b64(rsaKey.N.Bytes()), "e": "AQAB"},
+		},
+	}
+	iss.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		iss.fetches.Add(1)
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": iss.keys})
+	}))
+	t.Cleanup(iss.server.Close)
+	return iss
+}
+
+// sign returns a token with claims signed with alg by the key kid.
+func (iss *testIssuer) sign(t *testing.T, alg, kid string, claims map[string]any) string {
+	t.Helper()
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	assert.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	assert.NoError(t, err)
+	input := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(input))
+
+	var sig []byte
+	switch alg {
+	case "RS256":
+		sig, err = rsa.SignPKCS1v15(rand.Reader, iss.rsaKey, crypto.SHA256, digest[:])
+	case "PS256":
+		sig, err = rsa.SignPSS(rand.Reader, iss.rsaKey, crypto.SHA256, digest[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	case "ES256":
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, iss.ecKey, digest[:])
+		sig = append(leftPad(r.Bytes(), 32), leftPad(s.Bytes(), 32)...)
+	case "EdDSA":
+		sig = ed25519.Sign(iss.edKey, []byte(input))
+	}
+	assert.NoError(t, err)
+	return input + "." + b64(sig)
+}
+
+func (iss *testIssuer) authenticator(cfg config.JWTConfig) *JWT {
+	cfg.JWKSURL = iss.server.URL
+	return NewJWT(cfg, iss.server.Client())
+}
+
+func validClaims() map[string]any {
+	return map[string]any{
+		"sub":   "alice",
+		"iss":   "https://issuer.example",
+		"aud":   []string{"secure-shell", "other"},
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"azp":   "deploy-bot",
+		"email": "alice@example.com",
+	}
+}
+
+func TestJWT_Algorithms(t *testing.T) {
+	iss := newTestIssuer(t)
+	j := iss.authenticator(config.JWTConfig{Issuer: "https://issuer.example", Audience: "secure-shell"})
+
+	for _, tc := range []struct{ alg, kid string }{{"RS256", "rsa"}, {"PS256", "rsa"}, {"ES256", "ec"}, {"EdDSA", "ed"}} {
+		t.Run(tc.alg, func(t *testing.T) {
+			id, err := j.Authenticate(requestWithToken(iss.sign(t, tc.alg, tc.kid, validClaims())))
+			assert.NoError(t, err)
+			assert.Equal(t, identity.Identity{User: "alice", Agent: "deploy-bot"}, id)
+		})
+	}
+	// The key set is fetched once and then cached
+	assert.Equal(t, int32(1), iss.fetches.Load())
+}
+
+func TestJWT_Rejected(t *testing.T) {
+	iss := newTestIssuer(t)
+	j := iss.authenticator(config.JWTConfig{Issuer: "https://issuer.example", Audience: "secure-shell"})
+
+	with := func(name string, value any) map[string]any {
+		claims := validClaims()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"expired", iss.sign(t, "RS256", "rsa", with("exp", time.Now().Add(-time.Hour).Unix()))},
+		{"no expiry", iss.sign(t, "RS256", "rsa", with("exp", nil))},
+		{"not valid yet", iss.sign(t, "RS256", "rsa", with("nbf", time.Now().Add(time.Hour).Unix()))},
+		{"wrong issuer", iss.sign(t, "RS256", "rsa", with("iss", "https://evil.example"))},
+		{"wrong audience", iss.sign(t, "RS256", "rsa", with("aud", "other"))},
+		{"no user", iss.sign(t, "RS256", "rsa", with("sub", nil))},
+		{"unknown key", iss.sign(t, "RS256", "missing", validClaims())},
+		{"encryption key", iss.sign(t, "RS256", "enc", validClaims())},
+		{"algorithm of another key type", iss.sign(t, "ES256", "rsa", validClaims())},
+		{"algorithm not allowed by the key", iss.sign(t, "ES256", "ed", validClaims())},
+		{"unsigned", iss.sign(t, "none", "rsa", validClaims())},
+		{"tampered", func() string {
+			token := iss.sign(t, "RS256", "rsa", validClaims())
+			claims := validClaims()
+			claims["sub"] = "root"
+			forged := iss.sign(t, "RS256", "rsa", claims)
+			return forged[:len(forged)-10] + token[len(token)-10:]
+		}()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := j.Authenticate(requestWithToken(tt.token))
+			assert.IsError(t, err, ErrInvalidCredentials)
+		})
+	}
+
+	// Other bearer tokens may be API keys
+	_, err := j.Authenticate(requestWithToken("not-a-jwt"))
+	assert.IsError(t, err, ErrNoCredentials)
+}
+
+func TestJWT_UserClaim(t *testing.T) {
+	iss := newTestIssuer(t)
+	j := iss.authenticator(config.JWTConfig{UserClaim: "email"})
+
+	id, err := j.Authenticate(requestWithToken(iss.sign(t, "RS256", "rsa", validClaims())))
+	assert.NoError(t, err)
+	assert.Equal(t, "alice@example.com", id.User)
+}
+
+func TestJWT_KeyRotation(t *testing.T) {
+	iss := newTestIssuer(t)
+	j := iss.authenticator(config.JWTConfig{})
+	now := time.Now()
+	j.now = func() time.Time { return now }
+	claims := validClaims()
+	claims["exp"] = now.Add(24 * time.Hour).Unix()
+
+	_, err := j.Authenticate(requestWithToken(iss.sign(t, "RS256", "rsa", claims)))
+	assert.NoError(t, err)
+
+	// A new key is picked up once the key set may be fetched again
+	iss.keys[0]["kid"] = "rsa-2"
+	_, err = j.Authenticate(requestWithToken(iss.sign(t, "RS256", "rsa-2", claims)))
+	assert.IsError(t, err, ErrInvalidCredentials)
+	assert.Equal(t, int32(1), iss.fetches.Load())
+
+	now = now.Add(jwksMinRefresh)
+	_, err = j.Authenticate(requestWithToken(iss.sign(t, "RS256", "rsa-2", claims)))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), iss.fetches.Load())
+
+	// Known keys stay in use when the key set cannot be fetched
+	iss.server.Close()
+	now = now.Add(defaultJWKSRefresh)
+	_, err = j.Authenticate(requestWithToken(iss.sign(t, "RS256", "rsa-2", claims)))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
+)
+
+// ClientCert authenticates TLS client certificates issued by a set of authorities. The user
+// is the common name of the certificate's subject.
+type ClientCert struct {
+	roots *x509.CertPool
+}
+
+// NewClientCert returns an Authenticator of the client certificates issued by roots.
+func NewClientCert(roots *x509.CertPool) *ClientCert {
+	return &ClientCert{roots: roots}
+}
+
+// LoadClientCAs reads the PEM-encoded certificates of the authorities in path.
+func LoadClientCAs(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CAs: %w", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return roots, nil
+}
+
+// Authenticate implements Authenticator. The certificate chain is verified here, so that the
+// TLS configuration of the server only has to request client certificates.
+func (c *ClientCert) Authenticate(r *http.Request) (identity.Identity, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return identity.Identity{}, ErrNoCredentials
+	}
+	cert := r.TLS.PeerCertificates[0]
+	intermediates := x509.NewCertPool()
+	for _, ic := range r.TLS.PeerCertificates[1:] {
+		intermediates.AddCert(ic)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         c.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return identity.Identity{}, fmt.Errorf("%w: %w", ErrInvalidCredentials, err)
+	}
+	if cert.Subject.CommonName == "" {
+		return identity.Identity{}, fmt.Errorf("%w: client certificate has no common name", ErrInvalidCredentials)
+	}
+	return identity.Identity{User: cert.Subject.CommonName}, nil
+}
+
+// TLSConfig returns the TLS configuration of a server authenticating callers by cfg: with
+// client certificate authentication configured, clients may present certificates issued by
+// its authorities.
+func TLSConfig(cfg config.AuthConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.MTLS.ClientCA == "" {
+		return tlsConfig, nil
+	}
+	roots, err := LoadClientCAs(cfg.MTLS.ClientCA)
+	if err != nil {
+		return nil, err
+	}
+	// Other methods may authenticate clients without a certificate
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	tlsConfig.ClientCAs = roots
+	return tlsConfig, nil
+}
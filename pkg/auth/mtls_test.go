@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
+)
+
+// testCA issues client certificates.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return &testCA{cert: cert, key: key}
+}
+
+// issue returns a client certificate for commonName.
+func (ca *testCA) issue(t *testing.T, commonName string, usage x509.ExtKeyUsage) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return cert
+}
+
+func requestWithCert(cert *x509.Certificate) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	return r
+}
+
+func TestClientCert(t *testing.T) {
+	ca := newTestCA(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	c := NewClientCert(roots)
+
+	id, err := c.Authenticate(requestWithCert(ca.issue(t, "build-agent", x509.ExtKeyUsageClientAuth)))
+	assert.NoError(t, err)
+	assert.Equal(t, identity.Identity{User: "build-agent"}, id)
+
+	_, err = c.Authenticate(requestWithCert(newTestCA(t).issue(t, "build-agent", x509.ExtKeyUsageClientAuth)))
+	assert.IsError(t, err, ErrInvalidCredentials)
+	_, err = c.Authenticate(requestWithCert(ca.issue(t, "build-agent", x509.ExtKeyUsageServerAuth)))
+	assert.IsError(t, err, ErrInvalidCredentials)
+	_, err = c.Authenticate(requestWithCert(ca.issue(t, "", x509.ExtKeyUsageClientAuth)))
+	assert.IsError(t, err, ErrInvalidCredentials)
+
+	_, err = c.Authenticate(httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.IsError(t, err, ErrNoCredentials)
+}
+
+func TestTLSConfig(t *testing.T) {
+	tlsConfig, err := TLSConfig(config.AuthConfig{})
+	assert.NoError(t, err)
+	assert.Equal(t, tls.NoClientCert, tlsConfig.ClientAuth)
+
+	ca := newTestCA(t)
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0o600))
+	tlsConfig, err = TLSConfig(config.AuthConfig{MTLS: config.MTLSConfig{ClientCA: path}})
+	assert.NoError(t, err)
+	assert.Equal(t, tls.VerifyClientCertIfGiven, tlsConfig.ClientAuth)
+	assert.NotZero(t, tlsConfig.ClientCAs)
+
+	assert.NoError(t, os.WriteFile(path, []byte("not a certificate"), 0o600))
+	_, err = TLSConfig(config.AuthConfig{MTLS: config.MTLSConfig{ClientCA: path}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,87 @@
+// Package category groups commands into built-in categories, such as network or
+// package-manager, so that a policy can allow or deny a whole group at once.
+//
+// The mapping is maintained in categories.json and embedded at build time.
+package category
+
+import (
+	_ "embed"
+	"encoding/json"
+	"slices"
+)
+
+// Category is a group of commands with a similar kind of effect.
+type Category string
+
+const (
+	// Network covers commands that transfer data over the network.
+	Network Category = "network"
+	// PackageManager covers commands that install or remove software.
+	PackageManager Category = "package-manager"
+	// VCS covers version control clients.
+	VCS Category = "vcs"
+	// Container covers container runtimes and orchestrators.
+	Container Category = "container"
+	// Privilege covers commands that change users, ownership, or privileges.
+	Privilege Category = "privilege"
+)
+
+//go:embed categories.json
+var categoriesJSON []byte
+
+// commands maps each category to its commands, and categories maps each command back to its categories.
+var (
+	commands   map[Category][]string
+	categories map[string][]Category
+)
+
+func init() {
+	if err := json.Unmarshal(categoriesJSON, &commands); err != nil {
+		panic("category: invalid categories.json: " + err.Error())
+	}
+	categories = make(map[string][]Category)
+	for c, cmds := range commands {
+		for _, cmd := range cmds {
+			categories[cmd] = append(categories[cmd], c)
+		}
+	}
+	for _, cs := range categories {
+		slices.Sort(cs)
+	}
+}
+
+// All returns every category, sorted by name.
+func All() []Category {
+	all := make([]Category, 0, len(commands))
+	for c := range commands {
+		all = append(all, c)
+	}
+	slices.Sort(all)
+	return all
+}
+
+// Known reports whether name is a built-in category.
+func Known(name string) bool {
+	_, ok := commands[Category(name)]
+	return ok
+}
+
+// Commands returns the commands in c, or nil if c is unknown.
+func Commands(c Category) []string {
+	return slices.Clone(commands[c])
+}
+
+// Of returns the categories cmd belongs to, sorted by name.
+func Of(cmd string) []Category {
+	return slices.Clone(categories[cmd])
+}
+
+// Match returns the first category of cmd that is listed in names.
+func Match(cmd string, names []string) (Category, bool) {
+	for _, c := range categories[cmd] {
+		if slices.Contains(names, string(c)) {
+			return c, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,35 @@
+package category
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestAll(t *testing.T) {
+	assert.Equal(t, []Category{Container, Network, PackageManager, Privilege, VCS}, All())
+}
+
+func TestOf(t *testing.T) {
+	assert.Equal(t, []Category{Network}, Of("curl"))
+	assert.Equal(t, []Category{VCS}, Of("git"))
+	assert.Equal(t, []Category{Privilege}, Of("sudo"))
+	assert.Equal(t, 0, len(Of("ls")))
+}
+
+func TestMatch(t *testing.T) {
+	c, ok := Match("scp", []string{"vcs", "network"})
+	assert.True(t, ok)
+	assert.Equal(t, Network, c)
+
+	_, ok = Match("scp", []string{"vcs"})
+	assert.False(t, ok)
+}
+
+func TestKnownAndCommands(t *testing.T) {
+	assert.True(t, Known("container"))
+	assert.False(t, Known("games"))
+	assert.True(t, slices.Contains(Commands(Container), "docker"))
+	assert.Equal(t, 0, len(Commands("games")))
+}
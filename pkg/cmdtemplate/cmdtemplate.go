@@ -0,0 +1,265 @@
+// Package cmdtemplate expands named command templates with typed parameters, so that
+// callers can run common operations without building scripts from untrusted strings.
+//
+// A template is a script containing placeholders of the form {{name}} or {{name:type}}:
+//
+//	systemctl restart {{service:regexp:[a-z-]+}}
+//	tail -n {{lines:int}} {{file}}
+//	git {{action:enum:status|diff|log}}
+//
+// The types are string (the default, any value), int, regexp:PATTERN (the whole value
+// must match PATTERN), and enum:A|B|C. Values are checked against their type and
+// shell-quoted before they are substituted, so a value is always a single word and
+// can never inject shell syntax. Placeholders must therefore not be inside quotes.
+package cmdtemplate
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+var (
+	// ErrMissingParameter is returned when a parameter of a template is not given.
+	ErrMissingParameter = errors.New("missing template parameter")
+	// ErrUnknownParameter is returned when a parameter is given that the template does not use.
+	ErrUnknownParameter = errors.New("unknown template parameter")
+	// ErrInvalidParameter is returned when a value does not match the type of its parameter.
+	ErrInvalidParameter = errors.New("invalid template parameter")
+)
+
+// Kind is the type of a parameter.
+type Kind string
+
+const (
+	// KindString accepts any value.
+	KindString Kind = "string"
+	// KindInt accepts a decimal integer.
+	KindInt Kind = "int"
+	// KindRegexp accepts a value matching a regular expression.
+	KindRegexp Kind = "regexp"
+	// KindEnum accepts one of a fixed set of values.
+	KindEnum Kind = "enum"
+)
+
+// paramName matches valid parameter names.
+var paramName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// Param is a parameter of a template.
+type Param struct {
+	Name string
+	Kind Kind
+	// Pattern is the anchored expression of a KindRegexp parameter.
+	Pattern *regexp.Regexp
+	// Values are the accepted values of a KindEnum parameter.
+	Values []string
+
+	// spec is the placeholder text after the name, to detect conflicting redefinitions
+	spec string
+}
+
+// Check reports whether value is valid for the parameter.
+func (p Param) Check(value string) error {
+	switch p.Kind {
+	case KindInt:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Errorf("%w: %s must be an integer: %q", ErrInvalidParameter, p.Name, value)
+		}
+	case KindRegexp:
+		if !p.Pattern.MatchString(value) {
+			return fmt.Errorf("%w: %s must match %s: %q", ErrInvalidParameter, p.Name, p.Pattern, value)
+		}
+	case KindEnum:
+		if !slices.Contains(p.Values, value) {
+			return fmt.Errorf("%w: %s must be one of %s: %q", ErrInvalidParameter, p.Name, strings.Join(p.Values, ", "), value)
+		}
+	case KindString:
+	}
+	return nil
+}
+
+// Template is a parsed command template.
+type Template struct {
+	Name string
+	Text string
+	// Params are the parameters in order of first use.
+	Params []Param
+
+	parts []part
+}
+
+// part is a literal piece of a template or a reference to a parameter.
+type part struct {
+	literal string
+	// param is the index into Params, or -1 for a literal
+	param int
+}
+
+// Parse parses the template text called name.
+func Parse(name, text string) (*Template, error) {
+	t := &Template{Name: name, Text: text}
+	var quote rune
+	rest := text
+	for {
+		start := strings.Index(rest, "{{")
+		literal := rest
+		if start >= 0 {
+			literal = rest[:start]
+		}
+		quote = scanQuotes(literal, quote)
+		if literal != "" {
+			t.parts = append(t.parts, part{literal: literal, param: -1})
+		}
+		if start < 0 {
+			break
+		}
+		if quote != 0 {
+			return nil, fmt.Errorf("template %s: placeholder inside quotes", name)
+		}
+
+		end := strings.Index(rest[start:], "}}")
+		if end < 0 {
+			return nil, fmt.Errorf("template %s: unterminated placeholder", name)
+		}
+		param, err := parseParam(rest[start+2 : start+end])
+		if err != nil {
+			return nil, fmt.Errorf("template %s: %w", name, err)
+		}
+		index, err := t.addParam(param)
+		if err != nil {
+			return nil, fmt.Errorf("template %s: %w", name, err)
+		}
+		t.parts = append(t.parts, part{param: index})
+		rest = rest[start+end+2:]
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("template %s: unterminated quote", name)
+	}
+
+	// Check that the template is a valid script with every placeholder filled in
+	sample := make(map[string]string, len(t.Params))
+	for _, p := range t.Params {
+		sample[p.Name] = "x"
+	}
+	script, err := t.expand(sample)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := syntax.NewParser().Parse(strings.NewReader(script), name); err != nil {
+		return nil, fmt.Errorf("template %s: %w", name, err)
+	}
+	return t, nil
+}
+
+// scanQuotes returns the quote that is open at the end of s, given the quote open at its start.
+func scanQuotes(s string, quote rune) rune {
+	escaped := false
+	for _, c := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+		case quote == 0 && (c == '\'' || c == '"'):
+			quote = c
+		case c == quote:
+			quote = 0
+		}
+	}
+	return quote
+}
+
+// parseParam parses the text of a placeholder: name, name:int, name:regexp:PATTERN, or name:enum:A|B.
+func parseParam(spec string) (Param, error) {
+	name, typ, _ := strings.Cut(spec, ":")
+	name = strings.TrimSpace(name)
+	if !paramName.MatchString(name) {
+		return Param{}, fmt.Errorf("invalid parameter name %q", name)
+	}
+	p := Param{Name: name, Kind: KindString, spec: typ}
+
+	kind, arg, _ := strings.Cut(typ, ":")
+	switch Kind(kind) {
+	case "", KindString:
+	case KindInt:
+		p.Kind = KindInt
+	case KindRegexp:
+		pattern, err := regexp.Compile(`^(?:` + arg + `)$`)
+		if err != nil {
+			return Param{}, fmt.Errorf("parameter %s: invalid regular expression: %w", name, err)
+		}
+		p.Kind, p.Pattern = KindRegexp, pattern
+	case KindEnum:
+		if arg == "" {
+			return Param{}, fmt.Errorf("parameter %s: enum has no values", name)
+		}
+		p.Kind, p.Values = KindEnum, strings.Split(arg, "|")
+	default:
+		return Param{}, fmt.Errorf("parameter %s: unknown type %q", name, kind)
+	}
+	return p, nil
+}
+
+// addParam adds p unless a parameter of that name exists, and returns its index.
+func (t *Template) addParam(p Param) (int, error) {
+	for i, existing := range t.Params {
+		if existing.Name != p.Name {
+			continue
+		}
+		if existing.spec != p.spec {
+			return 0, fmt.Errorf("parameter %s is used with different types", p.Name)
+		}
+		return i, nil
+	}
+	t.Params = append(t.Params, p)
+	return len(t.Params) - 1, nil
+}
+
+// Expand checks params against the template's parameters and returns the script with
+// every placeholder replaced by its quoted value.
+func (t *Template) Expand(params map[string]string) (string, error) {
+	var unknown []string
+	for name := range params {
+		if !slices.ContainsFunc(t.Params, func(p Param) bool { return p.Name == name }) {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return "", fmt.Errorf("%w: %s", ErrUnknownParameter, strings.Join(unknown, ", "))
+	}
+	for _, p := range t.Params {
+		value, ok := params[p.Name]
+		if !ok {
+			return "", fmt.Errorf("%w: %s", ErrMissingParameter, p.Name)
+		}
+		if err := p.Check(value); err != nil {
+			return "", err
+		}
+	}
+	return t.expand(params)
+}
+
+// expand substitutes the quoted values of params, which must all be present.
+func (t *Template) expand(params map[string]string) (string, error) {
+	var b strings.Builder
+	for _, part := range t.parts {
+		if part.param < 0 {
+			b.WriteString(part.literal)
+			continue
+		}
+		name := t.Params[part.param].Name
+		quoted, err := syntax.Quote(params[name], syntax.LangBash)
+		if err != nil {
+			return "", fmt.Errorf("%w: %s: %w", ErrInvalidParameter, name, err)
+		}
+		b.WriteString(quoted)
+	}
+	return b.String(), nil
+}
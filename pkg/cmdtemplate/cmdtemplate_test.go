@@ -0,0 +1,106 @@
+package cmdtemplate
+
+import (
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestExpand(t *testing.T) {
+	tmpl, err := Parse("restart", "systemctl restart {{service:regexp:[a-z-]+}} && tail -n {{lines:int}} {{file}} | grep {{level:enum:info|error}}")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(tmpl.Params))
+
+	tests := []struct {
+		name    string
+		params  map[string]string
+		want    string
+		wantErr error
+	}{
+		{
+			name:   "valid",
+			params: map[string]string{"service": "nginx", "lines": "10", "file": "/var/log/a b.log", "level": "error"},
+			want:   "systemctl restart nginx && tail -n 10 '/var/log/a b.log' | grep error",
+		},
+		{
+			name:   "injection is quoted",
+			params: map[string]string{"service": "nginx", "lines": "10", "file": "x; rm -rf /", "level": "info"},
+			want:   "systemctl restart nginx && tail -n 10 'x; rm -rf /' | grep info",
+		},
+		{
+			name:    "regexp must match the whole value",
+			params:  map[string]string{"service": "nginx; reboot", "lines": "10", "file": "f", "level": "info"},
+			wantErr: ErrInvalidParameter,
+		},
+		{
+			name:    "not an integer",
+			params:  map[string]string{"service": "nginx", "lines": "ten", "file": "f", "level": "info"},
+			wantErr: ErrInvalidParameter,
+		},
+		{
+			name:    "not in enum",
+			params:  map[string]string{"service": "nginx", "lines": "1", "file": "f", "level": "debug"},
+			wantErr: ErrInvalidParameter,
+		},
+		{
+			name:    "missing",
+			params:  map[string]string{"service": "nginx", "lines": "1", "level": "info"},
+			wantErr: ErrMissingParameter,
+		},
+		{
+			name:    "unknown",
+			params:  map[string]string{"service": "nginx", "lines": "1", "file": "f", "level": "info", "extra": "x"},
+			wantErr: ErrUnknownParameter,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tmpl.Expand(tt.params)
+			if tt.wantErr != nil {
+				assert.IsError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParse_RepeatedParameter(t *testing.T) {
+	tmpl, err := Parse("copy", "cp {{name}} {{name}}.bak")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(tmpl.Params))
+
+	got, err := tmpl.Expand(map[string]string{"name": "a b"})
+	assert.NoError(t, err)
+	assert.Equal(t, "cp 'a b' 'a b'.bak", got)
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{name: "unterminated placeholder", text: "echo {{name"},
+		{name: "invalid name", text: "echo {{1st}}"},
+		{name: "unknown type", text: "echo {{name:float}}"},
+		{name: "invalid regexp", text: "echo {{name:regexp:[}}"},
+		{name: "empty enum", text: "echo {{name:enum:}}"},
+		{name: "conflicting types", text: "echo {{n:int}} {{n}}"},
+		{name: "inside double quotes", text: `echo "value: {{name}}"`},
+		{name: "inside single quotes", text: `echo '{{name}}'`},
+		{name: "invalid script", text: "echo {{name}} |"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse("t", tt.text)
+			assert.Error(t, err)
+		})
+	}
+
+	// Quotes closed before a placeholder are fine
+	_, err := Parse("t", `echo "a" 'b' {{name}}`)
+	assert.NoError(t, err)
+}
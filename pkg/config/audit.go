@@ -0,0 +1,39 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// SyslogFacilities maps the names accepted in SyslogConfig.Facility to facility codes.
+var SyslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// Check reports an unknown network, format, or facility, or a remote daemon without an address.
+func (c SyslogConfig) Check() error {
+	switch c.Network {
+	case "":
+		if c.Address != "" {
+			return errors.New("address requires a network")
+		}
+	case "udp", "tcp":
+		if _, _, err := net.SplitHostPort(c.Address); err != nil {
+			return fmt.Errorf("invalid address %q: %w", c.Address, err)
+		}
+	default:
+		return fmt.Errorf("unknown network %q: must be \"udp\", \"tcp\", or empty for the local daemon", c.Network)
+	}
+	switch c.Format {
+	case "", SyslogFormatRFC5424, SyslogFormatCEF:
+	default:
+		return fmt.Errorf("unknown format %q: must be %q or %q", c.Format, SyslogFormatRFC5424, SyslogFormatCEF)
+	}
+	if _, ok := SyslogFacilities[c.Facility]; c.Facility != "" && !ok {
+		return fmt.Errorf("unknown facility %q", c.Facility)
+	}
+	return nil
+}
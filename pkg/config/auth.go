@@ -0,0 +1,84 @@
+package config
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// DefaultJWTUserClaim is the claim naming the user when JWTConfig.UserClaim is empty.
+const DefaultJWTUserClaim = "sub"
+
+// AuthConfig authenticates the callers of the HTTP server. A request is accepted when any of
+// the configured methods authenticates it, and its commands are attributed to the user the
+// method resolved. With no method configured, the HTTP server is unauthenticated.
+type AuthConfig struct {
+	// APIKeys accepts the keys presented as "Authorization: Bearer <key>"
+	APIKeys []APIKeyConfig `json:"apiKeys,omitempty"`
+	// JWT accepts JSON Web Tokens presented as "Authorization: Bearer <token>"
+	JWT JWTConfig `json:"jwt,omitempty"`
+	// MTLS accepts the TLS client certificates issued by a certificate authority
+	MTLS MTLSConfig `json:"mtls,omitempty"`
+}
+
+// APIKeyConfig is a static API key and the user it authenticates.
+type APIKeyConfig struct {
+	// User is the user the key authenticates, as matched by Users.
+	User string `json:"user"`
+	// SHA256 is the hex-encoded SHA-256 hash of the key, as printed by
+	// `printf %s "$KEY" | sha256sum`, so that the policy does not hold the key itself.
+	SHA256 string `json:"sha256"`
+}
+
+// JWTConfig authenticates JSON Web Tokens signed by a key of a JSON Web Key Set.
+type JWTConfig struct {
+	// JWKSURL is the URL of the key set tokens are verified with; setting it enables JWT
+	// authentication.
+	JWKSURL string `json:"jwksUrl,omitempty"`
+	// Issuer, when set, is the "iss" claim tokens must carry.
+	Issuer string `json:"issuer,omitempty"`
+	// Audience, when set, must be among the "aud" claim of tokens.
+	Audience string `json:"audience,omitempty"`
+	// UserClaim is the claim naming the user (default: DefaultJWTUserClaim).
+	UserClaim string `json:"userClaim,omitempty"`
+	// RefreshInterval is how often the key set is fetched again, in seconds (0 uses the default).
+	RefreshInterval int `json:"refreshInterval,omitempty"`
+}
+
+// MTLSConfig authenticates TLS client certificates. The user is the common name of the
+// certificate's subject. It requires the HTTP server to serve TLS.
+type MTLSConfig struct {
+	// ClientCA is the path of the PEM-encoded certificates of the authorities client
+	// certificates must be issued by; setting it enables client certificate authentication.
+	ClientCA string `json:"clientCa,omitempty"`
+}
+
+// Enabled reports whether any authentication method is configured.
+func (a AuthConfig) Enabled() bool {
+	return len(a.APIKeys) > 0 || a.JWT.JWKSURL != "" || a.MTLS.ClientCA != ""
+}
+
+// check rejects API keys without a user or a valid hash and JWT settings that cannot work.
+func (a AuthConfig) check() error {
+	for i, key := range a.APIKeys {
+		if key.User == "" {
+			return fmt.Errorf("apiKeys[%d]: user is required", i)
+		}
+		if sum, err := hex.DecodeString(key.SHA256); err != nil || len(sum) != 32 {
+			return fmt.Errorf("apiKeys[%d]: sha256 must be a hex-encoded SHA-256 hash", i)
+		}
+	}
+	if a.JWT.RefreshInterval < 0 {
+		return errors.New("jwt.refreshInterval must not be negative")
+	}
+	if a.JWT.JWKSURL != "" {
+		u, err := url.Parse(a.JWT.JWKSURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("jwt.jwksUrl must be an http or https URL: %q", a.JWT.JWKSURL)
+		}
+	} else if a.JWT != (JWTConfig{}) {
+		return errors.New("jwt requires jwksUrl")
+	}
+	return nil
+}
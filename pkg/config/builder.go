@@ -0,0 +1,144 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// AllowOption customizes an AllowCommand entry added through a Builder.
+type AllowOption func(*AllowCommand)
+
+// DenyOption customizes a DenyCommand entry added through a Builder.
+type DenyOption func(*DenyCommand)
+
+// WithSubCommands restricts an allowed command to the given subcommand names.
+func WithSubCommands(names ...string) AllowOption {
+	return func(c *AllowCommand) {
+		for _, name := range names {
+			c.SubCommands = append(c.SubCommands, SubCommandRule{Name: name})
+		}
+	}
+}
+
+// WithSubCommandRules restricts an allowed command to the given subcommand rules.
+func WithSubCommandRules(rules ...SubCommandRule) AllowOption {
+	return func(c *AllowCommand) {
+		c.SubCommands = append(c.SubCommands, rules...)
+	}
+}
+
+// WithDenySubCommands denies the given subcommands of an allowed command.
+func WithDenySubCommands(names ...string) AllowOption {
+	return func(c *AllowCommand) {
+		c.DenySubCommands = append(c.DenySubCommands, names...)
+	}
+}
+
+// WithDenyMessage sets the message returned when a denied command is used.
+func WithDenyMessage(message string) DenyOption {
+	return func(c *DenyCommand) {
+		c.Message = message
+	}
+}
+
+// Builder constructs a ShellCommandConfig in code.
+// Validation is deferred until Build is called.
+type Builder struct {
+	config ShellCommandConfig
+}
+
+// NewBuilder creates a Builder initialized with the same defaults used when loading a config file.
+func NewBuilder() *Builder {
+	return &Builder{
+		config: ShellCommandConfig{
+			DefaultErrorMessage: "Command not allowed by security policy",
+			MaxExecutionTime:    DefaultExecutionTimeout,
+			MaxOutputSize:       DefaultMaxOutputSize,
+			UseEnvPwd:           true,
+		},
+	}
+}
+
+// AllowCommand adds a command to the allowlist.
+func (b *Builder) AllowCommand(cmd string, opts ...AllowOption) *Builder {
+	allow := AllowCommand{Command: cmd}
+	for _, opt := range opts {
+		opt(&allow)
+	}
+	b.config.AllowCommands = append(b.config.AllowCommands, allow)
+	return b
+}
+
+// DenyCommand adds a command to the denylist.
+func (b *Builder) DenyCommand(cmd string, opts ...DenyOption) *Builder {
+	deny := DenyCommand{Command: cmd}
+	for _, opt := range opts {
+		opt(&deny)
+	}
+	b.config.DenyCommands = append(b.config.DenyCommands, deny)
+	return b
+}
+
+// AllowCategory allows every command in the given built-in categories.
+func (b *Builder) AllowCategory(names ...string) *Builder {
+	b.config.AllowCategories = append(b.config.AllowCategories, names...)
+	return b
+}
+
+// DenyCategory denies every command in the given built-in categories.
+func (b *Builder) DenyCategory(names ...string) *Builder {
+	b.config.DenyCategories = append(b.config.DenyCategories, names...)
+	return b
+}
+
+// AllowDir adds a directory in which commands may operate.
+func (b *Builder) AllowDir(dir string) *Builder {
+	b.config.AllowedDirectories = append(b.config.AllowedDirectories, dir)
+	return b
+}
+
+// DefaultErrorMessage sets the message used when no rule-specific message exists.
+func (b *Builder) DefaultErrorMessage(message string) *Builder {
+	b.config.DefaultErrorMessage = message
+	return b
+}
+
+// BlockLogPath sets the file to which blocked commands are logged.
+func (b *Builder) BlockLogPath(path string) *Builder {
+	b.config.BlockLogPath = path
+	return b
+}
+
+// MaxExecutionTime sets the maximum execution time in seconds (0 means unlimited).
+func (b *Builder) MaxExecutionTime(seconds int) *Builder {
+	b.config.MaxExecutionTime = seconds
+	return b
+}
+
+// MaxOutputSize sets the maximum output size in bytes (0 means unlimited).
+func (b *Builder) MaxOutputSize(bytes int) *Builder {
+	b.config.MaxOutputSize = bytes
+	return b
+}
+
+// UseEnvPwd sets whether the PWD environment variable is used as the default working directory.
+func (b *Builder) UseEnvPwd(enabled bool) *Builder {
+	b.config.UseEnvPwd = enabled
+	return b
+}
+
+// Build validates the accumulated settings and returns the resulting configuration.
+// All errors reported by Validate are returned together; warnings do not prevent building.
+func (b *Builder) Build() (*ShellCommandConfig, error) {
+	var errs []error
+	for _, issue := range Validate(&b.config) {
+		if issue.Severity == SeverityError {
+			errs = append(errs, errors.New(issue.Message))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+
+	return b.config.Clone(), nil
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuilderBuild(t *testing.T) {
+	cfg, err := NewBuilder().
+		AllowCommand("git", WithSubCommands("status", "diff"), WithDenySubCommands("push")).
+		AllowCommand("ls").
+		DenyCommand("rm", WithDenyMessage("use trash instead")).
+		AllowDir("/workspace").
+		Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	if len(cfg.AllowedDirectories) != 1 || cfg.AllowedDirectories[0] != "/workspace" {
+		t.Errorf("AllowedDirectories = %v, want [/workspace]", cfg.AllowedDirectories)
+	}
+	if !cfg.IsCommandAllowed("git") || !cfg.IsCommandAllowed("ls") {
+		t.Errorf("expected git and ls to be allowed, got %v", cfg.AllowCommands)
+	}
+
+	git := cfg.AllowCommands[0]
+	if len(git.SubCommands) != 2 || git.SubCommands[0].Name != "status" || git.SubCommands[1].Name != "diff" {
+		t.Errorf("git SubCommands = %v, want [status diff]", git.SubCommands)
+	}
+	if len(git.DenySubCommands) != 1 || git.DenySubCommands[0] != "push" {
+		t.Errorf("git DenySubCommands = %v, want [push]", git.DenySubCommands)
+	}
+
+	if len(cfg.DenyCommands) != 1 || cfg.DenyCommands[0].Message != "use trash instead" {
+		t.Errorf("DenyCommands = %v, want rm with custom message", cfg.DenyCommands)
+	}
+
+	// Defaults mirror those applied when loading from a file
+	if cfg.MaxExecutionTime != DefaultExecutionTimeout {
+		t.Errorf("MaxExecutionTime = %d, want %d", cfg.MaxExecutionTime, DefaultExecutionTimeout)
+	}
+	if cfg.MaxOutputSize != DefaultMaxOutputSize {
+		t.Errorf("MaxOutputSize = %d, want %d", cfg.MaxOutputSize, DefaultMaxOutputSize)
+	}
+	if !cfg.UseEnvPwd {
+		t.Error("UseEnvPwd = false, want true")
+	}
+}
+
+func TestBuilderBuildErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		builder *Builder
+		wantErr []string
+	}{
+		{
+			name:    "no allowed directories",
+			builder: NewBuilder().AllowCommand("ls"),
+			wantErr: []string{"at least one allowed directory is required"},
+		},
+		{
+			name:    "allowed and denied",
+			builder: NewBuilder().AllowDir("/tmp").AllowCommand("rm").DenyCommand("rm"),
+			wantErr: []string{`command "rm" is both allowed and denied`},
+		},
+		{
+			name:    "duplicate allow",
+			builder: NewBuilder().AllowDir("/tmp").AllowCommand("ls").AllowCommand("ls"),
+			wantErr: []string{`command "ls" is allowed more than once`},
+		},
+		{
+			name:    "empty names",
+			builder: NewBuilder().AllowDir("").AllowCommand("").DenyCommand(""),
+			wantErr: []string{
+				"allowed directory must not be empty",
+				"allowed command name must not be empty",
+				"denied command name must not be empty",
+			},
+		},
+		{
+			name:    "negative limits",
+			builder: NewBuilder().AllowDir("/tmp").MaxExecutionTime(-1).MaxOutputSize(-1),
+			wantErr: []string{"max execution time must not be negative", "max output size must not be negative"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := tt.builder.Build()
+			if err == nil {
+				t.Fatalf("Build() = %v, want error", cfg)
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Build() error = %q, want it to contain %q", err.Error(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestBuilderBuildIsIndependent(t *testing.T) {
+	b := NewBuilder().AllowDir("/tmp").AllowCommand("ls")
+	first, err := b.Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	b.AllowCommand("cat")
+	if first.IsCommandAllowed("cat") {
+		t.Error("modifying the builder after Build() changed the built config")
+	}
+}
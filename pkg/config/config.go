@@ -1,9 +1,26 @@
 package config
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+
+	"mvdan.cc/sh/v3/syntax"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/category"
+	"github.com/shimizu1995/secure-shell-server/pkg/cmdtemplate"
 )
 
 // Default execution timeout in seconds.
@@ -12,10 +29,60 @@ const DefaultExecutionTimeout = 120
 // Default max output size in bytes (50KB).
 const DefaultMaxOutputSize = 50 * 1024
 
+// DefaultMaxOutputFileSize is the size in bytes output files are limited to when
+// ShellCommandConfig.MaxOutputFileSize is not set (64MB).
+const DefaultMaxOutputFileSize = 64 * 1024 * 1024
+
+// DefaultWritableDevices are the device files redirections may write to when
+// ShellCommandConfig.WritableDevices is not set.
+var DefaultWritableDevices = []string{"/dev/null"}
+
 // DenyCommand represents a command that is explicitly denied.
 type DenyCommand struct {
 	Command string `json:"command"`
 	Message string `json:"message,omitempty"`
+	// Args limits the rule to invocations with an argument containing one of these strings.
+	// The rule applies to every invocation when empty.
+	Args []string `json:"args,omitempty"`
+	// Alert marks a honeypot rule: a match raises a high-severity alert (see AlertConfig).
+	Alert bool `json:"alert,omitempty"`
+	// ID is a stable name for the rule, quoted in denials instead of the derived one (see RuleID).
+	ID string `json:"id,omitempty"`
+	// DocsURL points to documentation of the rule, quoted in denials.
+	DocsURL string `json:"docsUrl,omitempty"`
+	// Source is the configuration file or URL the rule was loaded from, if any.
+	Source string `json:"-"`
+}
+
+// RuleID returns the rule's ID, or one derived from its command and arguments, which stays
+// the same as long as the rule does.
+func (d DenyCommand) RuleID() string {
+	if d.ID != "" {
+		return d.ID
+	}
+	if len(d.Args) == 0 {
+		return "deny:" + d.Command
+	}
+	sum := sha256.Sum256([]byte(strings.Join(d.Args, "\x00")))
+	return "deny:" + d.Command + ":" + hex.EncodeToString(sum[:4])
+}
+
+// Matches reports whether the rule applies to cmd invoked with args.
+func (d DenyCommand) Matches(cmd string, args []string) bool {
+	if d.Command != cmd {
+		return false
+	}
+	if len(d.Args) == 0 {
+		return true
+	}
+	for _, arg := range args {
+		for _, s := range d.Args {
+			if strings.Contains(arg, s) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // SubCommandRule represents a recursive subcommand rule node.
@@ -26,6 +93,8 @@ type SubCommandRule struct {
 	SubCommands     []SubCommandRule `json:"subCommands,omitempty"`
 	DenySubCommands []string         `json:"denySubCommands,omitempty"`
 	Message         string           `json:"message,omitempty"`
+	// Cacheable marks the subcommand as idempotent; see AllowCommand.Cacheable
+	Cacheable bool `json:"cacheable,omitempty"`
 }
 
 // UnmarshalJSON implements the json.Unmarshaler interface for SubCommandRule.
@@ -53,36 +122,1227 @@ type AllowCommand struct {
 	Command         string           `json:"command"`
 	SubCommands     []SubCommandRule `json:"subCommands,omitempty"`
 	DenySubCommands []string         `json:"denySubCommands,omitempty"`
+	// ReadOnly marks the command as not modifying the filesystem; only such commands run when ReadOnlyOnly is set
+	ReadOnly bool `json:"readOnly,omitempty"`
+	// ApprovalRequired holds the command until an approver approves or rejects it
+	ApprovalRequired bool `json:"approvalRequired,omitempty"`
+	// Confirm asks the embedding application, e.g. with a prompt on the terminal, before the command runs
+	Confirm bool `json:"confirm,omitempty"`
+	// LiteralArgs denies the command when an argument comes from an expansion, such as "rm $TARGET"
+	LiteralArgs bool `json:"literalArgs,omitempty"`
+	// AllowPty runs the command in a pseudo-terminal when the caller has one, so that interactive
+	// tools such as python or psql can prompt and read input
+	AllowPty bool `json:"allowPty,omitempty"`
+	// AllowNetwork lets the command reach the network when DisableNetwork is set
+	AllowNetwork bool `json:"allowNetwork,omitempty"`
+	// SeccompProfile selects the seccomp filter of the command: a name in Seccomp.Profiles,
+	// SeccompProfileDefault, or SeccompProfileUnconfined. Empty uses the default profile when
+	// Seccomp.Enabled is set.
+	SeccompProfile string `json:"seccompProfile,omitempty"`
+	// AllowSetuid runs the command even if it is a setuid or setgid program, and lets it gain
+	// privileges: it runs without no_new_privs and with the capabilities of the server
+	AllowSetuid bool `json:"allowSetuid,omitempty"`
+	// Cacheable marks the command as idempotent, so that its output is reused for ResultCache.TTL
+	// seconds when it is run again with the same arguments in the same directory
+	Cacheable bool `json:"cacheable,omitempty"`
+	// Hooks run before and after every execution of the command
+	Hooks RuleHooks `json:"hooks,omitzero"`
+	// Env sets variables in the environment of the command alone, such as GIT_TERMINAL_PROMPT=0
+	// for git, on top of ShellCommandConfig.Env. Like those, they take precedence over variables
+	// exported by the script.
+	Env map[string]EnvVar `json:"env,omitempty"`
+	// ID is a stable name for the rule, quoted when its subcommand or flag rules deny a command.
+	ID string `json:"id,omitempty"`
+	// DocsURL points to documentation of the rule, quoted in denials.
+	DocsURL string `json:"docsUrl,omitempty"`
+	// Source is the configuration file or URL the rule was loaded from, if any.
+	Source string `json:"-"`
+}
+
+// DefaultHookTimeout is the default RuleHook.Timeout in seconds.
+const DefaultHookTimeout = 30
+
+// RuleHooks are run by the runner around each execution of an allowed command, such as
+// to acquire a lock before "terraform apply" and release it afterwards.
+type RuleHooks struct {
+	// Pre hooks run in order before the command; if one fails, the command does not run and
+	// fails with the hook's exit status.
+	Pre []RuleHook `json:"pre,omitempty"`
+	// Post hooks run in order after the command, whether or not it succeeded, when every
+	// Pre hook succeeded. They run even if the command timed out.
+	Post []RuleHook `json:"post,omitempty"`
+}
+
+// RuleHook is a script or a registered Go function run as a hook. Exactly one of Command
+// and Callback is set.
+type RuleHook struct {
+	// Command is a script run in the command's directory. It is validated like any other
+	// script, but runs no hooks of its own.
+	Command string `json:"command,omitempty"`
+	// Callback is the name of a function registered with runner.RegisterRuleHook.
+	Callback string `json:"callback,omitempty"`
+	// Timeout limits the hook to this many seconds (default DefaultHookTimeout).
+	Timeout int `json:"timeout,omitempty"`
+}
+
+// check rejects hooks without exactly one of a command and a callback, and negative timeouts.
+func (h RuleHooks) check() error {
+	phases := []struct {
+		name  string
+		hooks []RuleHook
+	}{{"pre", h.Pre}, {"post", h.Post}}
+	for _, phase := range phases {
+		for i, hook := range phase.hooks {
+			if (hook.Command == "") == (hook.Callback == "") {
+				return fmt.Errorf("%s hook %d must set exactly one of command and callback", phase.name, i)
+			}
+			if hook.Timeout < 0 {
+				return fmt.Errorf("%s hook %d: timeout must not be negative: %d", phase.name, i, hook.Timeout)
+			}
+		}
+	}
+	return nil
+}
+
+// RuleID returns the rule's ID, or one derived from its command.
+func (a AllowCommand) RuleID() string {
+	if a.ID != "" {
+		return a.ID
+	}
+	return "allow:" + a.Command
+}
+
+// RedactionConfig configures masking of secrets in command output and logs.
+type RedactionConfig struct {
+	// Enabled turns on redaction using the built-in patterns plus Patterns.
+	Enabled bool `json:"enabled"`
+	// Patterns are additional regular expressions whose matches are masked.
+	// If a pattern has a group named "secret", only that group is masked.
+	Patterns []string `json:"patterns,omitempty"`
+	// Replacement is the text substituted for each match (default "[REDACTED]").
+	Replacement string `json:"replacement,omitempty"`
+}
+
+// BuiltinPolicy controls shell builtins that are interpreted in-process (cd, export, set, trap, source, ...).
+type BuiltinPolicy struct {
+	// Allow lists builtins permitted without an allowCommands entry.
+	Allow []string `json:"allow,omitempty"`
+	// Deny lists builtins that are always rejected, even if they appear in allowCommands.
+	Deny []string `json:"deny,omitempty"`
+	// Message is returned when a denied builtin is used.
+	Message string `json:"message,omitempty"`
+}
+
+// RateLimitConfig limits how often and how many commands each caller may run.
+// A zero value for any field disables that limit.
+type RateLimitConfig struct {
+	// CommandsPerMinute is the sustained rate at which each caller may start commands.
+	CommandsPerMinute int `json:"commandsPerMinute,omitempty"`
+	// Burst is the number of commands a caller may start at once (defaults to CommandsPerMinute).
+	Burst int `json:"burst,omitempty"`
+	// MaxConcurrent is the number of commands a caller may have running at the same time.
+	MaxConcurrent int `json:"maxConcurrent,omitempty"`
+}
+
+// QuotaConfig bounds the resources each caller identity may use per day (UTC). A zero value
+// for any field disables that quota.
+type QuotaConfig struct {
+	// MaxCPUSeconds is the user and system CPU time the external commands of a caller may use.
+	MaxCPUSeconds int `json:"maxCpuSeconds,omitempty"`
+	// MaxOutputBytes is the output, in bytes, the executions of a caller may write.
+	MaxOutputBytes int64 `json:"maxOutputBytes,omitempty"`
+	// MaxExecutions is the number of scripts a caller may run.
+	MaxExecutions int `json:"maxExecutions,omitempty"`
+}
+
+// Enabled reports whether any quota is set.
+func (q QuotaConfig) Enabled() bool {
+	return q.MaxCPUSeconds > 0 || q.MaxOutputBytes > 0 || q.MaxExecutions > 0
+}
+
+// check rejects negative quotas.
+func (q QuotaConfig) check() error {
+	if q.MaxCPUSeconds < 0 || q.MaxOutputBytes < 0 || q.MaxExecutions < 0 {
+		return errors.New("quota values must not be negative")
+	}
+	return nil
+}
+
+// MessagesConfig words denial messages in a locale, or as a deployment brands them. Templates
+// are text/template strings over the command, its arguments, the rule that denied it, and the
+// built-in message (see validator.MessageData).
+type MessagesConfig struct {
+	// Locale selects the catalogue denial messages are taken from; empty keeps the built-in
+	// English messages.
+	Locale string `json:"locale,omitempty"`
+	// Catalog maps locales to templates keyed by rule name, such as "not-allowed", or "default"
+	// for any rule without its own. Entries override the built-in catalogue of the locale.
+	Catalog map[string]map[string]string `json:"catalog,omitempty"`
+}
+
+// check parses every template of the catalogue.
+func (m MessagesConfig) check() error {
+	for locale, templates := range m.Catalog {
+		if locale == "" {
+			return errors.New("messages.catalog locales must not be empty")
+		}
+		for rule, text := range templates {
+			if rule == "" {
+				return fmt.Errorf("messages.catalog.%s rule names must not be empty", locale)
+			}
+			if _, err := template.New(rule).Option("missingkey=error").Parse(text); err != nil {
+				return fmt.Errorf("invalid template messages.catalog.%s.%s: %w", locale, rule, err)
+			}
+		}
+	}
+	return nil
+}
+
+// SessionLimitsConfig limits the sessions of each caller identity, such as SSH channels,
+// JSON-RPC connections, and MCP sessions. A zero value for any field disables that limit.
+type SessionLimitsConfig struct {
+	// MaxConcurrent is the number of sessions a caller may have open at the same time.
+	MaxConcurrent int `json:"maxConcurrent,omitempty"`
+	// MaxCommands is the number of commands a single session may run.
+	MaxCommands int `json:"maxCommands,omitempty"`
+	// MaxLifetime is how many seconds a session may last; commands still running then are stopped.
+	MaxLifetime int `json:"maxLifetime,omitempty"`
+}
+
+// DefaultGitSubCommands are the git subcommands the built-in git rules allow.
+var DefaultGitSubCommands = []string{"status", "diff", "log", "add", "commit"}
+
+// GitConfig enables the built-in rules for git, which understand its global options,
+// subcommands, and flags, in place of an allowCommands entry written by hand.
+type GitConfig struct {
+	// Enabled allows git with the subcommands in DefaultGitSubCommands and AllowSubCommands.
+	// Force pushes, cleaning without a dry run, credential helpers, and options that run
+	// other programs are always denied. An allowCommands entry for git, if any, still applies.
+	Enabled bool `json:"enabled"`
+	// AllowSubCommands are further subcommands to allow, such as "push" or "fetch"
+	AllowSubCommands []string `json:"allowSubCommands,omitempty"`
+	// AllowedRemotes are the patterns (see path.Match) that the URLs of the remotes git
+	// contacts or configures must match; empty allows any remote
+	AllowedRemotes []string `json:"allowedRemotes,omitempty"`
+}
+
+// check rejects empty or multi-word subcommands and malformed remote patterns.
+func (g GitConfig) check() error {
+	for _, sub := range g.AllowSubCommands {
+		if sub == "" || strings.ContainsAny(sub, " \t") {
+			return fmt.Errorf("git.allowSubCommands must be single words: %q", sub)
+		}
+	}
+	for _, pattern := range g.AllowedRemotes {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid git.allowedRemotes pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// BlockLogConfig configures rotation of the block log. A zero value for any field disables that limit.
+type BlockLogConfig struct {
+	// MaxSize is the size in megabytes at which the block log is rotated.
+	MaxSize int `json:"maxSize,omitempty"`
+	// MaxBackups is the number of rotated block logs to keep.
+	MaxBackups int `json:"maxBackups,omitempty"`
+	// MaxAge is the number of days to keep rotated block logs.
+	MaxAge int `json:"maxAge,omitempty"`
+	// Compress gzips rotated block logs.
+	Compress bool `json:"compress,omitempty"`
+}
+
+// ScratchConfig provisions a temporary workspace for each execution.
+type ScratchConfig struct {
+	// Enabled creates a fresh directory for every execution, exposed as $WORKSPACE,
+	// allowed like the allowed directories, and removed when the execution ends.
+	Enabled bool `json:"enabled"`
+	// Dir is the directory in which workspaces are created (default: the system temporary directory).
+	Dir string `json:"dir,omitempty"`
+	// MaxSize is the quota of a workspace in megabytes; an execution exceeding it is stopped. Zero means unlimited.
+	MaxSize int `json:"maxSize,omitempty"`
+	// Tmpfs mounts each workspace as a tmpfs limited to MaxSize (Linux only, requires CAP_SYS_ADMIN).
+	Tmpfs bool `json:"tmpfs,omitempty"`
+}
+
+// HomeIsolationConfig gives each execution a home directory of its own.
+type HomeIsolationConfig struct {
+	// Enabled points HOME and the XDG base directories (XDG_CONFIG_HOME, XDG_CACHE_HOME,
+	// XDG_DATA_HOME, XDG_STATE_HOME) of every execution at a fresh directory, allowed like the
+	// allowed directories and removed when the execution ends, so that commands can neither
+	// read the dotfiles of the server's user nor keep state between executions.
+	Enabled bool `json:"enabled"`
+	// Dir is the directory in which home directories are created (default: the system temporary directory).
+	Dir string `json:"dir,omitempty"`
+}
+
+// OutputSpoolConfig keeps the full output of commands whose output exceeds MaxOutputSize,
+// so that callers can page through it instead of receiving only the first part.
+type OutputSpoolConfig struct {
+	// Enabled writes each stream of an execution to a spool file alongside the truncated output.
+	Enabled bool `json:"enabled"`
+	// Dir is the directory spool files are kept in (default: the system temporary directory).
+	Dir string `json:"dir,omitempty"`
+	// MaxSize is the largest spool file in megabytes; output beyond it is dropped. Zero means unlimited.
+	MaxSize int `json:"maxSize,omitempty"`
+	// Retention is how many seconds a spooled output can be read before it is removed (default: 3600).
+	Retention int `json:"retention,omitempty"`
+}
+
+// OutputSafetyConfig makes command output safe to show in terminals and to pass to language
+// models, which can be attacked with escape sequences or flooded with binary data.
+type OutputSafetyConfig struct {
+	// Enabled strips terminal escape sequences and control characters from output, and handles
+	// binary output as Binary says.
+	Enabled bool `json:"enabled"`
+	// Binary is what happens to output that contains NUL bytes or is not valid UTF-8:
+	// BinaryPlaceholder (default), BinaryBase64, or BinaryRaw.
+	Binary string `json:"binary,omitempty"`
+	// KeepEscapes passes escape sequences and control characters through unchanged.
+	KeepEscapes bool `json:"keepEscapes,omitempty"`
+}
+
+// Values of OutputSafetyConfig.Binary.
+const (
+	// BinaryPlaceholder replaces binary output with a note of its size.
+	BinaryPlaceholder = "placeholder"
+	// BinaryBase64 returns binary output base64-encoded.
+	BinaryBase64 = "base64"
+	// BinaryRaw returns binary output unchanged.
+	BinaryRaw = "raw"
+)
+
+// DefaultSpoolRetention is the default OutputSpoolConfig.Retention in seconds.
+const DefaultSpoolRetention = 3600
+
+// ResultCacheConfig bounds the cache of results of commands marked cacheable.
+type ResultCacheConfig struct {
+	// TTL is how many seconds a result is reused (default: 30).
+	TTL int `json:"ttl,omitempty"`
+	// MaxEntries is the number of results kept; the least recently used is evicted (default: 256).
+	MaxEntries int `json:"maxEntries,omitempty"`
+	// MaxEntrySize is the largest output in kilobytes that is cached (default: 64).
+	MaxEntrySize int `json:"maxEntrySize,omitempty"`
+}
+
+// Defaults of ResultCacheConfig.
+const (
+	DefaultResultCacheTTL          = 30
+	DefaultResultCacheMaxEntries   = 256
+	DefaultResultCacheMaxEntrySize = 64
+)
+
+// SFTPConfig configures the SFTP subsystem of the SSH server.
+type SFTPConfig struct {
+	// Enabled serves the "sftp" subsystem. Every path is checked against AllowedDirectories.
+	Enabled bool `json:"enabled"`
+	// ReadOnly refuses every operation that modifies files; ReadOnlyOnly implies it.
+	ReadOnly bool `json:"readOnly,omitempty"`
+}
+
+// SnapshotConfig runs commands against a copy-on-write snapshot of their working directory,
+// whose changes the caller commits to the directory or discards afterwards.
+type SnapshotConfig struct {
+	// Enabled runs every execution in a snapshot, which is kept when the execution changed it.
+	Enabled bool `json:"enabled"`
+	// Dir is the directory snapshots are kept in (default: the system temporary directory).
+	Dir string `json:"dir,omitempty"`
+	// MaxSize is the largest working directory in megabytes that is copied when overlayfs is
+	// unavailable; larger directories cannot be snapshotted. Zero means unlimited.
+	MaxSize int `json:"maxSize,omitempty"`
+	// Retention is how many seconds an unused snapshot is kept before it is discarded (default: 3600).
+	Retention int `json:"retention,omitempty"`
+}
+
+// DefaultSnapshotRetention is the default SnapshotConfig.Retention in seconds.
+const DefaultSnapshotRetention = 3600
+
+// TrashConfig makes rm and unlink move files into a quarantine directory of the session
+// instead of deleting them, so that they can be restored until they expire.
+type TrashConfig struct {
+	// Enabled runs rm and unlink in-process as moves into the trash.
+	Enabled bool `json:"enabled"`
+	// Dir is the directory the trash is kept in (default: secure-shell-trash in the system
+	// temporary directory). It should be outside the allowed directories.
+	Dir string `json:"dir,omitempty"`
+	// TTL is how many seconds deleted files are kept before they are purged (default: 86400).
+	TTL int `json:"ttl,omitempty"`
+}
+
+// DefaultTrashTTL is the default TrashConfig.TTL in seconds.
+const DefaultTrashTTL = 86400
+
+// FileAuditConfig lists the files each execution created, modified, or deleted in the allowed
+// directories, found by comparing their modification times and sizes before and after it ran.
+type FileAuditConfig struct {
+	// Enabled attaches the changed files to the result of every execution.
+	Enabled bool `json:"enabled"`
+	// MaxFiles is the most files scanned per execution (default: 10000); changes to the others
+	// go unnoticed, and the result says so.
+	MaxFiles int `json:"maxFiles,omitempty"`
+	// Exclude lists glob patterns of the names of files and directories that are not scanned,
+	// e.g. ".git" or "node_modules".
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// DefaultFileAuditMaxFiles is the default FileAuditConfig.MaxFiles.
+const DefaultFileAuditMaxFiles = 10000
+
+// AlertConfig configures what happens when a deny rule marked alert matches.
+type AlertConfig struct {
+	// WebhookURL receives every alert as a JSON POST request.
+	WebhookURL string `json:"webhookUrl,omitempty"`
+	// FreezeSession denies all further commands of the offending session until it is thawed.
+	FreezeSession bool `json:"freezeSession,omitempty"`
+}
+
+// Syslog message formats of audit events.
+const (
+	// SyslogFormatRFC5424 sends each event as an RFC 5424 message with the details as structured data.
+	SyslogFormatRFC5424 = "rfc5424"
+	// SyslogFormatCEF sends each event as an ArcSight Common Event Format record.
+	SyslogFormatCEF = "cef"
+)
+
+// SyslogConfig sends audit events to a syslog daemon.
+type SyslogConfig struct {
+	// Network is "udp" or "tcp" for a remote daemon at Address, or empty for the local daemon.
+	Network string `json:"network,omitempty"`
+	// Address is the host:port of a remote daemon.
+	Address string `json:"address,omitempty"`
+	// Format is SyslogFormatRFC5424 (the default) or SyslogFormatCEF.
+	Format string `json:"format,omitempty"`
+	// Facility is the syslog facility name, such as "auth" (the default), "daemon", or "local0".
+	Facility string `json:"facility,omitempty"`
+	// Tag is the application name of the messages (default: "secure-shell-server").
+	Tag string `json:"tag,omitempty"`
+	// DeniedOnly sends only blocked commands, not executions.
+	DeniedOnly bool `json:"deniedOnly,omitempty"`
+}
+
+// AuditConfig sends blocked-command and execution events to external audit sinks.
+type AuditConfig struct {
+	// Syslog lists the syslog daemons every event is sent to.
+	Syslog []SyslogConfig `json:"syslog,omitempty"`
+}
+
+// DefaultOPADecision is the decision document queried when OPAConfig.Decision is empty.
+const DefaultOPADecision = "secure_shell/decision"
+
+// OPAConfig delegates command decisions to Open Policy Agent (see package opa): to Rego
+// policies evaluated in-process, or to an OPA server. OPA is disabled when none is set.
+type OPAConfig struct {
+	// Policies are Rego files, or directories of them, evaluated in-process.
+	Policies []string `json:"policies,omitempty"`
+	// Bundle is an OPA bundle, a directory or a .tar.gz file, evaluated in-process.
+	Bundle string `json:"bundle,omitempty"`
+	// URL is the base URL of an OPA server, e.g. "http://127.0.0.1:8181", queried instead of
+	// evaluating policies in-process.
+	URL string `json:"url,omitempty"`
+	// Decision is the path of the decision document (default: DefaultOPADecision).
+	Decision string `json:"decision,omitempty"`
+	// Timeout is how long an evaluation may take in seconds (default: 2).
+	Timeout int `json:"timeout,omitempty"`
+	// FailOpen runs commands the static policy allows when OPA cannot be reached or the decision
+	// is undefined, instead of denying them.
+	FailOpen bool `json:"failOpen,omitempty"`
+}
+
+// Enabled reports whether commands are evaluated with OPA.
+func (c OPAConfig) Enabled() bool {
+	return c.URL != "" || c.Embedded()
+}
+
+// Embedded reports whether policies are evaluated in-process rather than by an OPA server.
+func (c OPAConfig) Embedded() bool {
+	return len(c.Policies) > 0 || c.Bundle != ""
+}
+
+// DefaultSecretCacheTTL is how long a resolved secret is reused, in seconds, when
+// secrets.cacheTtl is not set.
+const DefaultSecretCacheTTL = 300
+
+// EnvVar is a variable set in the environment of every executed command: either a literal
+// Value or the secret that FromSecret refers to, such as "vault:kv/ci#token". In JSON, a
+// string is taken as the Value.
+type EnvVar struct {
+	Value string `json:"value,omitempty"`
+	// FromSecret is resolved by the secret provider its scheme names (see ParseSecretRef).
+	FromSecret string `json:"fromSecret,omitempty"`
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface for EnvVar.
+func (v *EnvVar) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		*v = EnvVar{Value: value}
+		return nil
+	}
+	type envVarAlias EnvVar
+	var raw envVarAlias
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&raw); err != nil {
+		return err
+	}
+	if raw.Value != "" && raw.FromSecret != "" {
+		return errors.New("value and fromSecret are mutually exclusive")
+	}
+	*v = EnvVar(raw)
+	return nil
+}
+
+// SecretRef is a parsed reference to a secret: "scheme:path" or "scheme:path#field".
+type SecretRef struct {
+	// Scheme names the provider, such as "vault", "aws", or "file".
+	Scheme string
+	// Path locates the secret within the provider.
+	Path string
+	// Field, when set, selects a field of a secret that is a JSON object.
+	Field string
+}
+
+// String returns the reference in its textual form.
+func (r SecretRef) String() string {
+	if r.Field == "" {
+		return r.Scheme + ":" + r.Path
+	}
+	return r.Scheme + ":" + r.Path + "#" + r.Field
+}
+
+// ParseSecretRef parses a reference such as "vault:kv/ci#token".
+func ParseSecretRef(ref string) (SecretRef, error) {
+	scheme, rest, ok := strings.Cut(ref, ":")
+	if !ok || scheme == "" || rest == "" {
+		return SecretRef{}, fmt.Errorf("invalid secret reference %q: must be scheme:path[#field]", ref)
+	}
+	path, field, _ := strings.Cut(rest, "#")
+	if path == "" {
+		return SecretRef{}, fmt.Errorf("invalid secret reference %q: no path", ref)
+	}
+	return SecretRef{Scheme: scheme, Path: path, Field: field}, nil
+}
+
+// SecretsConfig configures the providers that resolve the fromSecret references of env.
+type SecretsConfig struct {
+	// Vault configures the "vault" provider, which reads HashiCorp Vault KV version 2 secrets.
+	Vault VaultConfig `json:"vault,omitempty"`
+	// AWS configures the "aws" provider, which reads AWS Secrets Manager secrets.
+	AWS AWSSecretsConfig `json:"aws,omitempty"`
+	// CacheTTL is how long a resolved secret is reused, in seconds (default: DefaultSecretCacheTTL).
+	CacheTTL int `json:"cacheTtl,omitempty"`
+}
+
+// VaultConfig locates a Vault server. The token is never part of the configuration.
+type VaultConfig struct {
+	// Address is the server's URL (default: $VAULT_ADDR).
+	Address string `json:"address,omitempty"`
+	// TokenFile is a file holding the token (default: the token in $VAULT_TOKEN).
+	TokenFile string `json:"tokenFile,omitempty"`
+	// Namespace is the Vault Enterprise namespace, if any.
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// AWSSecretsConfig locates AWS Secrets Manager. Credentials are taken from $AWS_ACCESS_KEY_ID,
+// $AWS_SECRET_ACCESS_KEY, and $AWS_SESSION_TOKEN.
+type AWSSecretsConfig struct {
+	// Region is the region of the secrets (default: $AWS_REGION or $AWS_DEFAULT_REGION).
+	Region string `json:"region,omitempty"`
+	// Endpoint replaces the regional endpoint, e.g. for a VPC endpoint.
+	Endpoint string `json:"endpoint,omitempty"`
+}
+
+// Shell dialects in which scripts are parsed and validated.
+const (
+	// DialectBash accepts Bash syntax such as [[ ]], arrays, and process substitution; it is the default.
+	DialectBash = "bash"
+	// DialectPOSIX accepts only POSIX shell syntax.
+	DialectPOSIX = "posix"
+	// DialectMksh accepts the MirBSD Korn shell syntax.
+	DialectMksh = "mksh"
+)
+
+// ParseDialect returns the parser language of a dialect name; the empty name is DialectBash.
+func ParseDialect(name string) (syntax.LangVariant, error) {
+	switch name {
+	case "", DialectBash:
+		return syntax.LangBash, nil
+	case DialectPOSIX:
+		return syntax.LangPOSIX, nil
+	case DialectMksh:
+		return syntax.LangMirBSDKorn, nil
+	}
+	return 0, fmt.Errorf("unknown shell dialect %q: must be %q, %q, or %q", name, DialectBash, DialectPOSIX, DialectMksh)
+}
+
+// Lang returns the parser language of the configured dialect.
+func (c *ShellCommandConfig) Lang() syntax.LangVariant {
+	lang, err := ParseDialect(c.Dialect)
+	if err != nil {
+		// Unmarshal rejects unknown dialects; a config built in code falls back to the default
+		return syntax.LangBash
+	}
+	return lang
+}
+
+// BinPath returns the PATH built from AllowedBinDirs, or "" when they are not set.
+func (c *ShellCommandConfig) BinPath() string {
+	return strings.Join(c.AllowedBinDirs, string(os.PathListSeparator))
+}
+
+// OutputFileSize returns the size in bytes output files are limited to.
+func (c *ShellCommandConfig) OutputFileSize() int {
+	if c.MaxOutputFileSize > 0 {
+		return c.MaxOutputFileSize
+	}
+	return DefaultMaxOutputFileSize
+}
+
+// Hash returns the SHA-256 digest of the policy as "sha256:<hex>", so that deployments can tell
+// which policy a server has loaded. Policies that differ only in formatting have the same hash.
+func (c *ShellCommandConfig) Hash() string {
+	// Policies hold only strings, numbers, and collections of them, which always encode
+	data, _ := json.Marshal(c)
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// CachesResults reports whether the allowCommands entries of the policy, its roles, or its
+// users mark any command or subcommand cacheable.
+func (c *ShellCommandConfig) CachesResults() bool {
+	overlays := slices.Concat(slices.Collect(maps.Values(c.Roles)), slices.Collect(maps.Values(c.Users)))
+	lists := [][]AllowCommand{c.AllowCommands}
+	for _, o := range overlays {
+		lists = append(lists, o.AllowCommands)
+	}
+	for _, list := range lists {
+		for _, allowed := range list {
+			if allowed.Cacheable || anyCacheable(allowed.SubCommands) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// anyCacheable reports whether any of rules or the rules below them is marked cacheable.
+func anyCacheable(rules []SubCommandRule) bool {
+	return slices.ContainsFunc(rules, func(r SubCommandRule) bool {
+		return r.Cacheable || anyCacheable(r.SubCommands)
+	})
+}
+
+// Execution backends that run external commands.
+const (
+	// BackendLocal starts commands as processes on the host.
+	BackendLocal = "local"
+	// BackendDocker runs each command in a new container through the Docker Engine API.
+	BackendDocker = "docker"
+	// BackendKubernetes runs each command in an existing pod through the exec subresource of
+	// the Kubernetes API.
+	BackendKubernetes = "kubernetes"
+)
+
+// Enforcement modes of the policy.
+const (
+	// EnforcementEnforcing fails the commands the policy denies.
+	EnforcementEnforcing = "enforcing"
+	// EnforcementPermissive runs the commands the policy denies, recording each denial it
+	// would have made, so that a policy can be trialed against real traffic.
+	EnforcementPermissive = "permissive"
+)
+
+// Policies for programs that interpreters read from standard input.
+const (
+	// InterpreterInputScan validates such programs when they are given literally, and denies them otherwise.
+	InterpreterInputScan = "scan"
+	// InterpreterInputDeny denies interpreters that read a program from standard input.
+	InterpreterInputDeny = "deny"
+	// InterpreterInputAllow runs such programs without looking at them.
+	InterpreterInputAllow = "allow"
+)
+
+// Actions taken on obfuscated execution.
+const (
+	// ObfuscationActionDeny denies scripts that run obfuscated code.
+	ObfuscationActionDeny = "deny"
+	// ObfuscationActionFlag logs such scripts to the block log and lets them run.
+	ObfuscationActionFlag = "flag"
+)
+
+// Patterns of obfuscated execution.
+const (
+	// ObfuscationDecodeExec is decoded data run as code, e.g. "base64 -d | sh" or
+	// "eval "$(echo ... | base64 -d)"".
+	ObfuscationDecodeExec = "decode-exec"
+	// ObfuscationPipeExec is the output of a command piped into a shell or interpreter,
+	// e.g. "echo ... | bash" or "curl ... | python3".
+	ObfuscationPipeExec = "pipe-exec"
+	// ObfuscationInlineCode is a program given to an interpreter in its arguments, e.g.
+	// "python -c" or "perl -e".
+	ObfuscationInlineCode = "inline-code"
+)
+
+// ObfuscationPatterns are the patterns of obfuscated execution that can be detected.
+var ObfuscationPatterns = []string{ObfuscationDecodeExec, ObfuscationPipeExec, ObfuscationInlineCode}
+
+// ObfuscationConfig detects code that is decoded, generated, or given inline to an
+// interpreter before it runs, which no check of command arguments can see into.
+type ObfuscationConfig struct {
+	// Action is ObfuscationActionDeny or ObfuscationActionFlag. Empty disables detection.
+	Action string `json:"action,omitempty"`
+	// Patterns are the ObfuscationPatterns detected; all of them when empty.
+	Patterns []string `json:"patterns,omitempty"`
+	// AllowInline lists interpreters whose inline programs are not detected, e.g. "python3".
+	AllowInline []string `json:"allowInline,omitempty"`
+}
+
+// Detects reports whether pattern is detected.
+func (o ObfuscationConfig) Detects(pattern string) bool {
+	return o.Action != "" && (len(o.Patterns) == 0 || slices.Contains(o.Patterns, pattern))
+}
+
+// check validates the action and the patterns.
+func (o ObfuscationConfig) check() error {
+	switch o.Action {
+	case "", ObfuscationActionDeny, ObfuscationActionFlag:
+	default:
+		return fmt.Errorf("invalid obfuscation.action %q: must be %q or %q", o.Action, ObfuscationActionDeny, ObfuscationActionFlag)
+	}
+	for _, pattern := range o.Patterns {
+		if !slices.Contains(ObfuscationPatterns, pattern) {
+			return fmt.Errorf("invalid obfuscation pattern %q: must be one of %s", pattern, strings.Join(ObfuscationPatterns, ", "))
+		}
+	}
+	return nil
+}
+
+// DefaultDockerNetwork is the network mode of containers when DockerConfig.Network is empty.
+const DefaultDockerNetwork = "none"
+
+// DockerConfig configures the docker execution backend.
+type DockerConfig struct {
+	// Image is the image commands run in; required for the docker backend.
+	Image string `json:"image"`
+	// Host is the address of the Docker daemon (default: $DOCKER_HOST or unix:///var/run/docker.sock).
+	Host string `json:"host,omitempty"`
+	// Network is the container network mode (default: "none").
+	Network string `json:"network,omitempty"`
+	// User runs commands as this user (default: the UID and GID of the server).
+	User string `json:"user,omitempty"`
+}
+
+// KubernetesConfig configures the kubernetes execution backend.
+type KubernetesConfig struct {
+	// Pod is the pod commands run in; required for the kubernetes backend.
+	Pod string `json:"pod"`
+	// Container is the container of the pod commands run in (default: the pod's only container).
+	Container string `json:"container,omitempty"`
+	// Namespace is the namespace of the pod (default: the namespace of the kubeconfig context,
+	// of the service account when running in a cluster, or "default").
+	Namespace string `json:"namespace,omitempty"`
+	// Kubeconfig is the kubeconfig file to connect with (default: the service account when
+	// running in a cluster, otherwise $KUBECONFIG or ~/.kube/config).
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	// Context is the kubeconfig context to use (default: its current context).
+	Context string `json:"context,omitempty"`
+}
+
+// Actions taken when a script's risk score exceeds RiskConfig.Threshold.
+const (
+	RiskActionApprove = "approve"
+	RiskActionDeny    = "deny"
+)
+
+// RiskConfig holds back scripts whose risk score is too high.
+type RiskConfig struct {
+	// Threshold is the score (0-100) above which Action is taken. Zero disables the check.
+	Threshold int `json:"threshold,omitempty"`
+	// Action is RiskActionApprove (the default) to wait for an approver or RiskActionDeny to reject the script.
+	Action string `json:"action,omitempty"`
+}
+
+// Capabilities are the names of the Linux capabilities, indexed by number, accepted in
+// PrivilegesConfig.KeepCapabilities.
+var Capabilities = []string{
+	"CAP_CHOWN", "CAP_DAC_OVERRIDE", "CAP_DAC_READ_SEARCH", "CAP_FOWNER", "CAP_FSETID",
+	"CAP_KILL", "CAP_SETGID", "CAP_SETUID", "CAP_SETPCAP", "CAP_LINUX_IMMUTABLE",
+	"CAP_NET_BIND_SERVICE", "CAP_NET_BROADCAST", "CAP_NET_ADMIN", "CAP_NET_RAW", "CAP_IPC_LOCK",
+	"CAP_IPC_OWNER", "CAP_SYS_MODULE", "CAP_SYS_RAWIO", "CAP_SYS_CHROOT", "CAP_SYS_PTRACE",
+	"CAP_SYS_PACCT", "CAP_SYS_ADMIN", "CAP_SYS_BOOT", "CAP_SYS_NICE", "CAP_SYS_RESOURCE",
+	"CAP_SYS_TIME", "CAP_SYS_TTY_CONFIG", "CAP_MKNOD", "CAP_LEASE", "CAP_AUDIT_WRITE",
+	"CAP_AUDIT_CONTROL", "CAP_SETFCAP", "CAP_MAC_OVERRIDE", "CAP_MAC_ADMIN", "CAP_SYSLOG",
+	"CAP_WAKE_ALARM", "CAP_BLOCK_SUSPEND", "CAP_AUDIT_READ", "CAP_PERFMON", "CAP_BPF",
+	"CAP_CHECKPOINT_RESTORE",
+}
+
+// PrivilegesConfig bounds the privileges of executed commands on Linux. Every command runs
+// with no_new_privs set, so that setuid programs and file capabilities grant nothing, and
+// with every capability dropped except KeepCapabilities, unless its allowCommands entry is
+// marked AllowSetuid.
+type PrivilegesConfig struct {
+	// KeepCapabilities lists the capabilities commands keep when the server has them,
+	// e.g. "CAP_NET_BIND_SERVICE".
+	KeepCapabilities []string `json:"keepCapabilities,omitempty"`
+}
+
+// check rejects unknown capabilities.
+func (p PrivilegesConfig) check() error {
+	for _, name := range p.KeepCapabilities {
+		if !slices.Contains(Capabilities, name) {
+			return fmt.Errorf("unknown capability %q", name)
+		}
+	}
+	return nil
+}
+
+// DefaultMaxSourceDepth is how deeply sourced files may source further files when
+// ScriptLimitsConfig.MaxSourceDepth is zero.
+const DefaultMaxSourceDepth = 8
+
+// ScriptLimitsConfig bounds the size and complexity of scripts, so that pathological scripts
+// cannot exhaust the validator or the interpreter. Zero disables a limit, except for
+// MaxSourceDepth, which is always enforced.
+type ScriptLimitsConfig struct {
+	// MaxSize is the largest script accepted, in bytes. It is checked before parsing.
+	MaxSize int `json:"maxSize,omitempty"`
+	// MaxNodes bounds the nodes of the parsed syntax tree.
+	MaxNodes int `json:"maxNodes,omitempty"`
+	// MaxDepth bounds the nesting of compound commands, functions, and command and process
+	// substitutions.
+	MaxDepth int `json:"maxDepth,omitempty"`
+	// MaxLoops bounds the for, while, and until loops of a script.
+	MaxLoops int `json:"maxLoops,omitempty"`
+	// MaxCommands bounds the commands a script names, whether or not they run.
+	MaxCommands int `json:"maxCommands,omitempty"`
+	// MaxPipeline bounds the commands of a single pipeline, joined by | or |&.
+	MaxPipeline int `json:"maxPipeline,omitempty"`
+	// MaxChain bounds the commands of a single list run one after another, separated by
+	// &&, ||, ;, &, or newlines.
+	MaxChain int `json:"maxChain,omitempty"`
+	// MaxSourceDepth bounds how deeply files run with source or . may source further files
+	// (0 uses DefaultMaxSourceDepth).
+	MaxSourceDepth int `json:"maxSourceDepth,omitempty"`
+}
+
+// SourceDepth returns the include depth sourced files are limited to.
+func (l ScriptLimitsConfig) SourceDepth() int {
+	if l.MaxSourceDepth > 0 {
+		return l.MaxSourceDepth
+	}
+	return DefaultMaxSourceDepth
+}
+
+// check rejects negative limits.
+func (l ScriptLimitsConfig) check() error {
+	if l.MaxSize < 0 || l.MaxNodes < 0 || l.MaxDepth < 0 || l.MaxLoops < 0 || l.MaxCommands < 0 ||
+		l.MaxPipeline < 0 || l.MaxChain < 0 || l.MaxSourceDepth < 0 {
+		return errors.New("scriptLimits values must not be negative")
+	}
+	return nil
+}
+
+// LandlockConfig restricts executed commands with the Linux Landlock LSM.
+type LandlockConfig struct {
+	// Enabled confines every external command to the allowed directories at the kernel level.
+	Enabled bool `json:"enabled"`
+	// ReadOnlyPaths may be read and executed in addition to the allowed directories.
+	// Defaults to the system directories needed to run programs (/usr, /etc, /lib, ...).
+	ReadOnlyPaths []string `json:"readOnlyPaths,omitempty"`
+	// BestEffort runs commands without the sandbox when Landlock is unavailable instead of failing them.
+	BestEffort bool `json:"bestEffort,omitempty"`
+}
+
+// CgroupConfig runs every execution in a transient cgroup v2 on Linux, so that its limits
+// hold for all the processes a script starts, such as the jobs of "make -j", and so that
+// those left behind are killed with it.
+type CgroupConfig struct {
+	// Enabled creates a cgroup for each execution, removed when the execution ends.
+	Enabled bool `json:"enabled"`
+	// Parent is the cgroup in which executions' cgroups are created, as a path below
+	// /sys/fs/cgroup (default: the server's own cgroup, which must be delegated to it).
+	Parent string `json:"parent,omitempty"`
+	// MemoryMax is the memory limit of an execution in megabytes (memory.max). Zero means unlimited.
+	MemoryMax int `json:"memoryMax,omitempty"`
+	// CPUMax is the number of CPUs the processes of an execution may use together, e.g. 1.5
+	// (cpu.max). Zero means unlimited.
+	CPUMax float64 `json:"cpuMax,omitempty"`
+	// PidsMax is the number of processes and threads an execution may have (pids.max). Zero means unlimited.
+	PidsMax int `json:"pidsMax,omitempty"`
+	// BestEffort runs commands outside a cgroup when cgroup v2 is unavailable instead of failing them.
+	BestEffort bool `json:"bestEffort,omitempty"`
+}
+
+// check validates the limits and the parent cgroup.
+func (c CgroupConfig) check() error {
+	switch {
+	case c.MemoryMax < 0:
+		return errors.New("cgroup.memoryMax must not be negative")
+	case c.CPUMax < 0:
+		return errors.New("cgroup.cpuMax must not be negative")
+	case c.PidsMax < 0:
+		return errors.New("cgroup.pidsMax must not be negative")
+	case slices.Contains(strings.Split(filepath.ToSlash(c.Parent), "/"), ".."):
+		return fmt.Errorf("cgroup.parent must be below /sys/fs/cgroup: %q", c.Parent)
+	}
+	return nil
+}
+
+// EgressProxyConfig sends the traffic of commands marked allowNetwork through a proxy by
+// setting the proxy variables most tools honor, so that outbound connections take an audited path.
+type EgressProxyConfig struct {
+	// URL is the proxy, such as "http://proxy.internal:3128" or "socks5h://proxy.internal:1080",
+	// set as HTTP_PROXY, HTTPS_PROXY, and ALL_PROXY. Empty disables the proxy.
+	URL string `json:"url,omitempty"`
+	// NoProxy lists the hosts, domains, and networks reached without the proxy, set as NO_PROXY.
+	NoProxy []string `json:"noProxy,omitempty"`
+}
+
+// egressProxySchemes are the proxy schemes EgressProxyConfig.URL may use.
+var egressProxySchemes = []string{"http", "https", "socks5", "socks5h"}
+
+// check validates the proxy URL and the hosts of NoProxy.
+func (p EgressProxyConfig) check() error {
+	if p.URL == "" {
+		if len(p.NoProxy) > 0 {
+			return errors.New("egressProxy.noProxy requires egressProxy.url")
+		}
+		return nil
+	}
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return fmt.Errorf("invalid egressProxy.url: %w", err)
+	}
+	if !slices.Contains(egressProxySchemes, u.Scheme) || u.Host == "" {
+		return fmt.Errorf("egressProxy.url must be an %s URL with a host: %q", strings.Join(egressProxySchemes, ", "), p.URL)
+	}
+	for _, host := range p.NoProxy {
+		if host == "" || strings.ContainsAny(host, ", \t") {
+			return fmt.Errorf("egressProxy.noProxy entries must be single hosts: %q", host)
+		}
+	}
+	return nil
+}
+
+// SeccompConfig restricts the system calls of executed commands with a seccomp filter on Linux,
+// as a kernel-level backstop for the argument-based policy.
+type SeccompConfig struct {
+	// Enabled applies the default profile to every external command whose allowCommands entry
+	// selects no other profile.
+	Enabled bool `json:"enabled"`
+	// DenySyscalls are the system calls the default profile denies (default: DefaultSeccompDenySyscalls).
+	DenySyscalls []string `json:"denySyscalls,omitempty"`
+	// Profiles are named lists of denied system calls, selected by allowCommands entries with seccompProfile.
+	Profiles map[string][]string `json:"profiles,omitempty"`
+	// Action is SeccompActionErrno (the default) to fail denied calls with EPERM or
+	// SeccompActionKill to kill the process making them.
+	Action string `json:"action,omitempty"`
+	// BestEffort runs commands without the filter when seccomp is unavailable instead of failing them.
+	BestEffort bool `json:"bestEffort,omitempty"`
+}
+
+// Names of built-in seccomp profiles selectable by AllowCommand.SeccompProfile.
+const (
+	// SeccompProfileDefault is the profile denying SeccompConfig.DenySyscalls.
+	SeccompProfileDefault = "default"
+	// SeccompProfileUnconfined runs the command without a seccomp filter.
+	SeccompProfileUnconfined = "unconfined"
+)
+
+// Values of SeccompConfig.Action.
+const (
+	SeccompActionErrno = "errno"
+	SeccompActionKill  = "kill"
+)
+
+// DefaultSeccompDenySyscalls are denied by the default seccomp profile: debugging other
+// processes, mounting filesystems, loading kernel modules, rebooting, and the kernel keyring.
+var DefaultSeccompDenySyscalls = []string{
+	"ptrace", "mount", "umount2", "init_module", "finit_module", "delete_module",
+	"reboot", "kexec_load", "kexec_file_load", "keyctl", "add_key", "request_key",
+}
+
+// SeccompSyscalls are the system calls seccomp profiles can deny.
+var SeccompSyscalls = slices.Concat(DefaultSeccompDenySyscalls, []string{
+	"acct", "bpf", "chroot", "clock_settime", "open_by_handle_at", "perf_event_open", "personality",
+	"pivot_root", "process_vm_readv", "process_vm_writev", "setns", "settimeofday", "swapoff",
+	"swapon", "unshare", "userfaultfd",
+})
+
+// check reports an unknown action, system call, or profile name.
+func (s SeccompConfig) check() error {
+	switch s.Action {
+	case "", SeccompActionErrno, SeccompActionKill:
+	default:
+		return fmt.Errorf("invalid seccomp.action %q: must be %q or %q", s.Action, SeccompActionErrno, SeccompActionKill)
+	}
+	lists := map[string][]string{"seccomp.denySyscalls": s.DenySyscalls}
+	for name, syscalls := range s.Profiles {
+		if name == SeccompProfileDefault || name == SeccompProfileUnconfined {
+			return fmt.Errorf("seccomp profile %q is built in and cannot be redefined", name)
+		}
+		lists["seccomp.profiles."+name] = syscalls
+	}
+	for field, syscalls := range lists {
+		for _, name := range syscalls {
+			if !slices.Contains(SeccompSyscalls, name) {
+				return fmt.Errorf("invalid %s: unknown system call %q", field, name)
+			}
+		}
+	}
+	return nil
+}
+
+// DeniedSyscalls returns the system calls denied by the named profile, and false for a profile
+// that does not exist. The empty name is the default profile when Enabled is set, and no profile otherwise.
+func (s SeccompConfig) DeniedSyscalls(profile string) ([]string, bool) {
+	switch profile {
+	case "":
+		if !s.Enabled {
+			return nil, true
+		}
+	case SeccompProfileUnconfined:
+		return nil, true
+	case SeccompProfileDefault:
+	default:
+		syscalls, ok := s.Profiles[profile]
+		return syscalls, ok
+	}
+	if len(s.DenySyscalls) > 0 {
+		return s.DenySyscalls, true
+	}
+	return DefaultSeccompDenySyscalls, true
 }
 
 // ShellCommandConfig holds the configuration for shell command permissions.
 type ShellCommandConfig struct {
-	AllowedDirectories  []string       `json:"allowedDirectories"`
-	AllowCommands       []AllowCommand `json:"allowCommands"`
-	DenyCommands        []DenyCommand  `json:"denyCommands"`
-	DefaultErrorMessage string         `json:"defaultErrorMessage"`
-	BlockLogPath        string         `json:"blockLogPath,omitempty"`
+	AllowedDirectories []string       `json:"allowedDirectories"`
+	AllowCommands      []AllowCommand `json:"allowCommands"`
+	DenyCommands       []DenyCommand  `json:"denyCommands"`
+	// AllowedBinDirs, when set, are the only directories executables are run from: PATH is built
+	// from them alone, and commands invoked by a path must be in one of them
+	AllowedBinDirs []string `json:"allowedBinDirs,omitempty"`
+	// ReadOnlyDirectories may be read like the allowed directories but are never the target of a
+	// redirection, tee, or modifying SFTP request, even when they are inside an allowed directory
+	ReadOnlyDirectories []string `json:"readOnlyDirectories,omitempty"`
+	// WritableDevices are the device files redirections and tee may write to
+	// (default: DefaultWritableDevices); writes to other devices are denied
+	WritableDevices []string `json:"writableDevices,omitempty"`
+	// AllowCategories allows every command in the named built-in categories (see package category)
+	AllowCategories []string `json:"allowCategories,omitempty"`
+	// DenyCategories denies every command in the named built-in categories, even if it is in AllowCommands
+	DenyCategories []string `json:"denyCategories,omitempty"`
+	// Git enables the built-in rules for git
+	Git                 GitConfig `json:"git,omitzero"`
+	DefaultErrorMessage string    `json:"defaultErrorMessage"`
+	// DisabledMessage is returned for executions rejected while the kill switch is on (see runner.Disable)
+	DisabledMessage string `json:"disabledMessage,omitempty"`
+	BlockLogPath    string `json:"blockLogPath,omitempty"`
+	// BlockLog controls rotation of the file at BlockLogPath
+	BlockLog BlockLogConfig `json:"blockLog,omitempty"`
+	// Messages localizes or rewords denial messages
+	Messages MessagesConfig `json:"messages,omitempty"`
 	// MaxExecutionTime is the maximum execution time in seconds (0 means unlimited)
 	MaxExecutionTime int `json:"maxExecutionTime,omitempty"`
+	// IdleTimeout kills a command that produces no output for this many seconds (0 means unlimited)
+	IdleTimeout int `json:"idleTimeout,omitempty"`
 	// MaxOutputSize is the maximum size of command output in bytes (0 means unlimited)
 	MaxOutputSize int `json:"maxOutputSize,omitempty"`
+	// MaxOutputFileSize is the size in bytes of the files callers have output written to
+	// (0 uses DefaultMaxOutputFileSize)
+	MaxOutputFileSize int `json:"maxOutputFileSize,omitempty"`
 	// UseEnvPwd uses the PWD environment variable as the default working directory when true
 	UseEnvPwd bool `json:"useEnvPwd,omitempty"`
+	// Redaction masks secrets in captured output and logs
+	Redaction RedactionConfig `json:"redaction,omitempty"`
+	// Builtins controls shell builtins that never reach an external executable
+	Builtins BuiltinPolicy `json:"builtins,omitempty"`
+	// RateLimit throttles commands per caller identity
+	RateLimit RateLimitConfig `json:"rateLimit,omitempty"`
+	// Quota bounds the CPU time, output, and executions of each caller identity per day
+	Quota QuotaConfig `json:"quota,omitempty"`
+	// Sessions limits the sessions of each caller identity
+	Sessions SessionLimitsConfig `json:"sessions,omitempty"`
+	// EnforcementMode is EnforcementEnforcing (default) or EnforcementPermissive, which runs
+	// denied commands and only records that they would have been denied
+	EnforcementMode string `json:"enforcementMode,omitempty"`
+	// ReadOnlyOnly permits only commands marked readOnly and blocks redirections that write to disk
+	ReadOnlyOnly bool `json:"readOnlyOnly,omitempty"`
+	// DenyNestedCommands denies commands that run another command given in their arguments,
+	// such as "sh -c", "xargs", "find -exec", "env", and "ssh host cmd", instead of validating it
+	DenyNestedCommands bool `json:"denyNestedCommands,omitempty"`
+	// DenyDynamicCommands denies commands whose name comes from an expansion, such as "$CMD args",
+	// since the allowlist cannot reason about values only known when the script runs
+	DenyDynamicCommands bool `json:"denyDynamicCommands,omitempty"`
+	// InterpreterInput controls programs that interpreters such as python read from standard
+	// input, e.g. "python3 - <<EOF": InterpreterInputScan (default), InterpreterInputDeny, or
+	// InterpreterInputAllow
+	InterpreterInput string `json:"interpreterInput,omitempty"`
+	// Obfuscation detects decode-and-execute patterns and inline programs of interpreters
+	Obfuscation ObfuscationConfig `json:"obfuscation,omitempty"`
+	// AllowBackground permits background commands ("cmd &"), coprocesses, and the wait builtin.
+	// They are denied by default since background children outlive the timeout and output accounting
+	AllowBackground bool `json:"allowBackground,omitempty"`
+	// AllowProcessSubstitution permits process substitutions such as "diff <(ls a) <(ls b)",
+	// whose commands also run in the background
+	AllowProcessSubstitution bool `json:"allowProcessSubstitution,omitempty"`
+	// RecordingDir, when set, records every session as an asciicast file in this directory
+	RecordingDir string `json:"recordingDir,omitempty"`
+	// HistoryPath, when set, records every executed or denied command in this SQLite database
+	HistoryPath string `json:"historyPath,omitempty"`
+	// InProcessCommands runs cat, ls, head, tail, and wc in-process instead of starting executables
+	InProcessCommands bool `json:"inProcessCommands,omitempty"`
+	// ApprovalTimeout is how long a command marked approvalRequired waits for a decision, in seconds (0 uses the default)
+	ApprovalTimeout int `json:"approvalTimeout,omitempty"`
+	// Landlock sandboxes executed commands on Linux
+	Landlock LandlockConfig `json:"landlock,omitempty"`
+	// Seccomp restricts the system calls of executed commands on Linux
+	Seccomp SeccompConfig `json:"seccomp,omitempty"`
+	// DisableNetwork runs executed commands in a network namespace of their own with only
+	// loopback (Linux), unless their allowCommands entry is marked allowNetwork
+	DisableNetwork bool `json:"disableNetwork,omitempty"`
+	// EgressProxy sends the traffic of commands marked allowNetwork through a proxy
+	EgressProxy EgressProxyConfig `json:"egressProxy,omitzero"`
+	// Risk holds back scripts whose risk score exceeds a threshold
+	Risk RiskConfig `json:"risk,omitempty"`
+	// ScriptLimits rejects scripts that are too large or complex
+	ScriptLimits ScriptLimitsConfig `json:"scriptLimits,omitempty"`
+	// Privileges bounds the capabilities of executed commands on Linux
+	Privileges PrivilegesConfig `json:"privileges,omitempty"`
+	// Cgroup limits the resources of each execution with a cgroup v2 on Linux
+	Cgroup CgroupConfig `json:"cgroup,omitempty"`
+	// Scratch gives every execution a temporary workspace
+	Scratch ScratchConfig `json:"scratch,omitempty"`
+	// HomeIsolation gives every execution a throwaway home directory
+	HomeIsolation HomeIsolationConfig `json:"homeIsolation,omitempty"`
+	// OutputSpool keeps the full output of truncated commands for paging
+	OutputSpool OutputSpoolConfig `json:"outputSpool,omitempty"`
+	// OutputSafety strips escape sequences and replaces or encodes binary output
+	OutputSafety OutputSafetyConfig `json:"outputSafety,omitempty"`
+	// ResultCache bounds the cache of results of commands marked cacheable
+	ResultCache ResultCacheConfig `json:"resultCache,omitempty"`
+	// SFTP serves file transfer over SSH under the directory policy
+	SFTP SFTPConfig `json:"sftp,omitempty"`
+	// Snapshot runs commands against a snapshot of their working directory
+	Snapshot SnapshotConfig `json:"snapshot,omitempty"`
+	// Trash moves the files rm and unlink delete into a per-session trash
+	Trash TrashConfig `json:"trash,omitempty"`
+	// FileAudit lists the files each execution changed
+	FileAudit FileAuditConfig `json:"fileAudit,omitempty"`
+	// Alerts configures notifications for deny rules marked alert
+	Alerts AlertConfig `json:"alerts,omitempty"`
+	// Audit sends blocked-command and execution events to syslog
+	Audit AuditConfig `json:"audit,omitempty"`
+	// Auth authenticates the callers of the HTTP server
+	Auth AuthConfig `json:"auth,omitempty"`
+	// OPA also requires every command allowed by the static policy to be allowed by an OPA decision
+	OPA OPAConfig `json:"opa,omitempty"`
+	// Dialect is the shell language scripts are parsed in: DialectBash (default), DialectPOSIX, or DialectMksh
+	Dialect string `json:"dialect,omitempty"`
+	// ExecutionBackend selects how external commands run: BackendLocal (default), BackendDocker,
+	// or BackendKubernetes
+	ExecutionBackend string `json:"executionBackend,omitempty"`
+	// Docker configures the docker execution backend
+	Docker DockerConfig `json:"docker,omitempty"`
+	// Kubernetes configures the kubernetes execution backend
+	Kubernetes KubernetesConfig `json:"kubernetes,omitempty"`
+	// Templates maps names to command templates with typed parameters (see package cmdtemplate)
+	Templates map[string]string `json:"templates,omitempty"`
+	// Rewrites maps command names to the words that replace them once the command is allowed,
+	// e.g. "rm" to ["trash-put"]; the arguments of the command follow the replacement
+	Rewrites map[string][]string `json:"rewrites,omitempty"`
+	// Env sets variables in the environment of every executed command, resolving secrets
+	// when the command starts
+	Env map[string]EnvVar `json:"env,omitempty"`
+	// Secrets configures the providers that resolve the secrets of Env
+	Secrets SecretsConfig `json:"secrets,omitempty"`
+	// InterpolateEnv lists the environment variables that ${NAME} and ${NAME:-fallback} may
+	// refer to in the directory and path fields of the configuration; HOME may always be used
+	InterpolateEnv []string `json:"interpolateEnv,omitempty"`
+	// Users maps caller identities (see identity.Identity.Key) to overlays applied on top of this policy
+	Users map[string]PolicyOverlay `json:"users,omitempty"`
+	// Roles maps role names to overlays that users take on by listing them in their roles
+	Roles map[string]PolicyOverlay `json:"roles,omitempty"`
 }
 
 // UnmarshalJSON implements the json.Unmarshaler interface for ShellCommandConfig.
 func (c *ShellCommandConfig) UnmarshalJSON(data []byte) error {
 	var raw struct {
-		AllowedDirectories  []string        `json:"allowedDirectories"`
-		AllowCommands       json.RawMessage `json:"allowCommands"`
-		DenyCommands        json.RawMessage `json:"denyCommands"`
-		DefaultErrorMessage string          `json:"defaultErrorMessage"`
-		BlockLogPath        string          `json:"blockLogPath,omitempty"`
-		MaxExecutionTime    *int            `json:"maxExecutionTime"`
-		MaxOutputSize       *int            `json:"maxOutputSize"`
-		UseEnvPwd           *bool           `json:"useEnvPwd,omitempty"`
+		AllowedDirectories       []string                 `json:"allowedDirectories"`
+		AllowCommands            json.RawMessage          `json:"allowCommands"`
+		DenyCommands             json.RawMessage          `json:"denyCommands"`
+		AllowedBinDirs           []string                 `json:"allowedBinDirs,omitempty"`
+		ReadOnlyDirectories      []string                 `json:"readOnlyDirectories,omitempty"`
+		WritableDevices          []string                 `json:"writableDevices,omitempty"`
+		AllowCategories          []string                 `json:"allowCategories,omitempty"`
+		DenyCategories           []string                 `json:"denyCategories,omitempty"`
+		Git                      GitConfig                `json:"git,omitzero"`
+		DefaultErrorMessage      string                   `json:"defaultErrorMessage"`
+		DisabledMessage          string                   `json:"disabledMessage,omitempty"`
+		Messages                 MessagesConfig           `json:"messages,omitempty"`
+		BlockLogPath             string                   `json:"blockLogPath,omitempty"`
+		BlockLog                 BlockLogConfig           `json:"blockLog,omitempty"`
+		MaxExecutionTime         *int                     `json:"maxExecutionTime"`
+		IdleTimeout              int                      `json:"idleTimeout,omitempty"`
+		MaxOutputSize            *int                     `json:"maxOutputSize"`
+		MaxOutputFileSize        int                      `json:"maxOutputFileSize,omitempty"`
+		UseEnvPwd                *bool                    `json:"useEnvPwd,omitempty"`
+		Redaction                RedactionConfig          `json:"redaction,omitempty"`
+		Builtins                 BuiltinPolicy            `json:"builtins,omitempty"`
+		RateLimit                RateLimitConfig          `json:"rateLimit,omitempty"`
+		Quota                    QuotaConfig              `json:"quota,omitempty"`
+		Sessions                 SessionLimitsConfig      `json:"sessions,omitempty"`
+		EnforcementMode          string                   `json:"enforcementMode,omitempty"`
+		ReadOnlyOnly             bool                     `json:"readOnlyOnly,omitempty"`
+		DenyNestedCommands       bool                     `json:"denyNestedCommands,omitempty"`
+		DenyDynamicCommands      bool                     `json:"denyDynamicCommands,omitempty"`
+		InterpreterInput         string                   `json:"interpreterInput,omitempty"`
+		Obfuscation              ObfuscationConfig        `json:"obfuscation,omitempty"`
+		AllowBackground          bool                     `json:"allowBackground,omitempty"`
+		AllowProcessSubstitution bool                     `json:"allowProcessSubstitution,omitempty"`
+		RecordingDir             string                   `json:"recordingDir,omitempty"`
+		HistoryPath              string                   `json:"historyPath,omitempty"`
+		InProcessCommands        bool                     `json:"inProcessCommands,omitempty"`
+		ApprovalTimeout          int                      `json:"approvalTimeout,omitempty"`
+		Landlock                 LandlockConfig           `json:"landlock,omitempty"`
+		Seccomp                  SeccompConfig            `json:"seccomp,omitempty"`
+		DisableNetwork           bool                     `json:"disableNetwork,omitempty"`
+		EgressProxy              EgressProxyConfig        `json:"egressProxy,omitzero"`
+		Risk                     RiskConfig               `json:"risk,omitempty"`
+		ScriptLimits             ScriptLimitsConfig       `json:"scriptLimits,omitempty"`
+		Privileges               PrivilegesConfig         `json:"privileges,omitempty"`
+		Cgroup                   CgroupConfig             `json:"cgroup,omitempty"`
+		Scratch                  ScratchConfig            `json:"scratch,omitempty"`
+		HomeIsolation            HomeIsolationConfig      `json:"homeIsolation,omitempty"`
+		OutputSpool              OutputSpoolConfig        `json:"outputSpool,omitempty"`
+		OutputSafety             OutputSafetyConfig       `json:"outputSafety,omitempty"`
+		ResultCache              ResultCacheConfig        `json:"resultCache,omitempty"`
+		SFTP                     SFTPConfig               `json:"sftp,omitempty"`
+		Snapshot                 SnapshotConfig           `json:"snapshot,omitempty"`
+		Trash                    TrashConfig              `json:"trash,omitempty"`
+		FileAudit                FileAuditConfig          `json:"fileAudit,omitempty"`
+		Templates                map[string]string        `json:"templates,omitempty"`
+		Rewrites                 map[string][]string      `json:"rewrites,omitempty"`
+		Env                      map[string]EnvVar        `json:"env,omitempty"`
+		Secrets                  SecretsConfig            `json:"secrets,omitempty"`
+		InterpolateEnv           []string                 `json:"interpolateEnv,omitempty"`
+		Alerts                   AlertConfig              `json:"alerts,omitempty"`
+		Audit                    AuditConfig              `json:"audit,omitempty"`
+		Auth                     AuthConfig               `json:"auth,omitempty"`
+		OPA                      OPAConfig                `json:"opa,omitempty"`
+		Dialect                  string                   `json:"dialect,omitempty"`
+		ExecutionBackend         string                   `json:"executionBackend,omitempty"`
+		Docker                   DockerConfig             `json:"docker,omitempty"`
+		Kubernetes               KubernetesConfig         `json:"kubernetes,omitempty"`
+		Users                    map[string]PolicyOverlay `json:"users,omitempty"`
+		Roles                    map[string]PolicyOverlay `json:"roles,omitempty"`
 	}
 
+	data, err := interpolateFields(data)
+	if err != nil {
+		return fmt.Errorf("error interpolating environment variables: %w", err)
+	}
 	if err := json.Unmarshal(data, &raw); err != nil {
 		return err
 	}
@@ -99,18 +1359,70 @@ func (c *ShellCommandConfig) UnmarshalJSON(data []byte) error {
 		return fmt.Errorf("error unmarshaling deny commands: %w", err)
 	}
 
-	c.AllowedDirectories = raw.AllowedDirectories
+	if err := checkRuleReferences(allowCommands, denyCommands); err != nil {
+		return err
+	}
+
+	allowedDirectories, err := expandDirectories(raw.AllowedDirectories)
+	if err != nil {
+		return err
+	}
+	c.AllowedDirectories = allowedDirectories
 	c.AllowCommands = allowCommands
 	c.DenyCommands = denyCommands
 
+	// A relative directory in PATH would resolve commands against the working directory
+	for _, dir := range raw.AllowedBinDirs {
+		if !filepath.IsAbs(dir) {
+			return fmt.Errorf("allowedBinDirs must be absolute paths: %q", dir)
+		}
+	}
+	c.AllowedBinDirs = raw.AllowedBinDirs
+
+	readOnlyDirectories, err := expandDirectories(raw.ReadOnlyDirectories)
+	if err != nil {
+		return err
+	}
+	c.ReadOnlyDirectories = readOnlyDirectories
+	for _, device := range raw.WritableDevices {
+		if !filepath.IsAbs(device) {
+			return fmt.Errorf("writableDevices must be absolute paths: %q", device)
+		}
+	}
+	c.WritableDevices = raw.WritableDevices
+
+	for _, names := range [][]string{raw.AllowCategories, raw.DenyCategories} {
+		for _, name := range names {
+			if !category.Known(name) {
+				return fmt.Errorf("unknown command category %q", name)
+			}
+		}
+	}
+	c.AllowCategories = raw.AllowCategories
+	c.DenyCategories = raw.DenyCategories
+
+	if err := raw.Git.check(); err != nil {
+		return err
+	}
+	c.Git = raw.Git
+
 	// Use default values if not specified
 	if raw.DefaultErrorMessage != "" {
 		c.DefaultErrorMessage = raw.DefaultErrorMessage
 	} else {
 		c.DefaultErrorMessage = "Command not allowed by security policy"
 	}
+	c.DisabledMessage = raw.DisabledMessage
+	if err := raw.Messages.check(); err != nil {
+		return err
+	}
+	c.Messages = raw.Messages
 
 	c.BlockLogPath = raw.BlockLogPath
+	if raw.BlockLog.MaxSize < 0 || raw.BlockLog.MaxBackups < 0 || raw.BlockLog.MaxAge < 0 {
+		return errors.New("blockLog values must not be negative")
+	}
+	c.BlockLog = raw.BlockLog
 
 	// UseEnvPwd defaults to true unless explicitly set to false
 	if raw.UseEnvPwd != nil {
@@ -126,16 +1438,317 @@ func (c *ShellCommandConfig) UnmarshalJSON(data []byte) error {
 		c.MaxExecutionTime = DefaultExecutionTimeout
 	}
 
+	if raw.IdleTimeout < 0 {
+		return errors.New("idleTimeout must not be negative")
+	}
+	c.IdleTimeout = raw.IdleTimeout
+
 	// Use default output size if not specified; 0 means unlimited
 	if raw.MaxOutputSize != nil {
 		c.MaxOutputSize = *raw.MaxOutputSize
 	} else {
 		c.MaxOutputSize = DefaultMaxOutputSize
 	}
+	if raw.MaxOutputFileSize < 0 {
+		return errors.New("maxOutputFileSize must not be negative")
+	}
+	c.MaxOutputFileSize = raw.MaxOutputFileSize
+
+	// Reject invalid redaction patterns at load time rather than silently skipping them
+	for _, pattern := range raw.Redaction.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid redaction pattern %q: %w", pattern, err)
+		}
+	}
+	c.Redaction = raw.Redaction
+	c.Builtins = raw.Builtins
+
+	if raw.RateLimit.CommandsPerMinute < 0 || raw.RateLimit.Burst < 0 || raw.RateLimit.MaxConcurrent < 0 {
+		return errors.New("rateLimit values must not be negative")
+	}
+	c.RateLimit = raw.RateLimit
+
+	if err := raw.Quota.check(); err != nil {
+		return err
+	}
+	c.Quota = raw.Quota
+
+	if raw.Sessions.MaxConcurrent < 0 || raw.Sessions.MaxCommands < 0 || raw.Sessions.MaxLifetime < 0 {
+		return errors.New("sessions values must not be negative")
+	}
+	c.Sessions = raw.Sessions
+	switch raw.EnforcementMode {
+	case "", EnforcementEnforcing, EnforcementPermissive:
+	default:
+		return fmt.Errorf("invalid enforcementMode %q: must be %q or %q",
+			raw.EnforcementMode, EnforcementEnforcing, EnforcementPermissive)
+	}
+	c.EnforcementMode = raw.EnforcementMode
+	c.ReadOnlyOnly = raw.ReadOnlyOnly
+	c.DenyNestedCommands = raw.DenyNestedCommands
+	c.DenyDynamicCommands = raw.DenyDynamicCommands
+	switch raw.InterpreterInput {
+	case "", InterpreterInputScan, InterpreterInputDeny, InterpreterInputAllow:
+	default:
+		return fmt.Errorf("invalid interpreterInput %q: must be %q, %q, or %q",
+			raw.InterpreterInput, InterpreterInputScan, InterpreterInputDeny, InterpreterInputAllow)
+	}
+	c.InterpreterInput = raw.InterpreterInput
+	if err := raw.Obfuscation.check(); err != nil {
+		return err
+	}
+	c.Obfuscation = raw.Obfuscation
+	c.AllowBackground = raw.AllowBackground
+	c.AllowProcessSubstitution = raw.AllowProcessSubstitution
+	c.RecordingDir = raw.RecordingDir
+	c.HistoryPath = raw.HistoryPath
+	c.InProcessCommands = raw.InProcessCommands
+
+	if raw.ApprovalTimeout < 0 {
+		return errors.New("approvalTimeout must not be negative")
+	}
+	c.ApprovalTimeout = raw.ApprovalTimeout
+	c.Landlock = raw.Landlock
+
+	if err := raw.Seccomp.check(); err != nil {
+		return err
+	}
+	c.Seccomp = raw.Seccomp
+	c.DisableNetwork = raw.DisableNetwork
+
+	if err := raw.EgressProxy.check(); err != nil {
+		return err
+	}
+	c.EgressProxy = raw.EgressProxy
+
+	if raw.Risk.Threshold < 0 {
+		return errors.New("risk.threshold must not be negative")
+	}
+	switch raw.Risk.Action {
+	case "", RiskActionApprove, RiskActionDeny:
+	default:
+		return fmt.Errorf("invalid risk.action %q: must be %q or %q", raw.Risk.Action, RiskActionApprove, RiskActionDeny)
+	}
+	c.Risk = raw.Risk
+
+	if err := raw.ScriptLimits.check(); err != nil {
+		return err
+	}
+	c.ScriptLimits = raw.ScriptLimits
+
+	if err := raw.Privileges.check(); err != nil {
+		return fmt.Errorf("invalid privileges.keepCapabilities: %w", err)
+	}
+	c.Privileges = raw.Privileges
+
+	if err := raw.Cgroup.check(); err != nil {
+		return err
+	}
+	c.Cgroup = raw.Cgroup
+
+	if raw.Scratch.MaxSize < 0 {
+		return errors.New("scratch.maxSize must not be negative")
+	}
+	c.Scratch = raw.Scratch
+	c.HomeIsolation = raw.HomeIsolation
+
+	if raw.OutputSpool.MaxSize < 0 || raw.OutputSpool.Retention < 0 {
+		return errors.New("outputSpool values must not be negative")
+	}
+	c.OutputSpool = raw.OutputSpool
+
+	switch raw.OutputSafety.Binary {
+	case "", BinaryPlaceholder, BinaryBase64, BinaryRaw:
+	default:
+		return fmt.Errorf("invalid outputSafety.binary %q: must be %q, %q, or %q",
+			raw.OutputSafety.Binary, BinaryPlaceholder, BinaryBase64, BinaryRaw)
+	}
+	c.OutputSafety = raw.OutputSafety
+
+	if raw.ResultCache.TTL < 0 || raw.ResultCache.MaxEntries < 0 || raw.ResultCache.MaxEntrySize < 0 {
+		return errors.New("resultCache values must not be negative")
+	}
+	c.ResultCache = raw.ResultCache
+	c.SFTP = raw.SFTP
+
+	if raw.Snapshot.MaxSize < 0 || raw.Snapshot.Retention < 0 {
+		return errors.New("snapshot values must not be negative")
+	}
+	c.Snapshot = raw.Snapshot
+
+	if raw.Trash.TTL < 0 {
+		return errors.New("trash.ttl must not be negative")
+	}
+	c.Trash = raw.Trash
+
+	if raw.FileAudit.MaxFiles < 0 {
+		return errors.New("fileAudit.maxFiles must not be negative")
+	}
+	for _, pattern := range raw.FileAudit.Exclude {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid fileAudit.exclude pattern %q: %w", pattern, err)
+		}
+	}
+	c.FileAudit = raw.FileAudit
+
+	for _, name := range slices.Sorted(maps.Keys(raw.Templates)) {
+		if _, err := cmdtemplate.Parse(name, raw.Templates[name]); err != nil {
+			return fmt.Errorf("invalid command template: %w", err)
+		}
+	}
+	c.Templates = raw.Templates
+
+	for _, name := range slices.Sorted(maps.Keys(raw.Rewrites)) {
+		if err := checkRewrite(name, raw.Rewrites[name]); err != nil {
+			return err
+		}
+	}
+	c.Rewrites = raw.Rewrites
+
+	for _, name := range slices.Sorted(maps.Keys(raw.Env)) {
+		if err := checkEnvVar(name, raw.Env[name]); err != nil {
+			return err
+		}
+	}
+	c.Env = raw.Env
+
+	if raw.Secrets.CacheTTL < 0 {
+		return errors.New("secrets.cacheTtl must not be negative")
+	}
+	c.Secrets = raw.Secrets
+
+	if err := checkInterpolateEnv(raw.InterpolateEnv); err != nil {
+		return err
+	}
+	c.InterpolateEnv = raw.InterpolateEnv
+
+	if raw.Alerts.WebhookURL != "" {
+		if u, err := url.Parse(raw.Alerts.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("alerts.webhookUrl must be an http or https URL: %q", raw.Alerts.WebhookURL)
+		}
+	}
+	c.Alerts = raw.Alerts
 
+	for i, sink := range raw.Audit.Syslog {
+		if err := sink.Check(); err != nil {
+			return fmt.Errorf("audit.syslog[%d]: %w", i, err)
+		}
+	}
+	c.Audit = raw.Audit
+
+	if err := raw.Auth.check(); err != nil {
+		return fmt.Errorf("invalid auth: %w", err)
+	}
+	c.Auth = raw.Auth
+
+	if raw.OPA.URL != "" {
+		if u, err := url.Parse(raw.OPA.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("opa.url must be an http or https URL: %q", raw.OPA.URL)
+		}
+	}
+	if raw.OPA.URL != "" && raw.OPA.Embedded() {
+		return errors.New("opa.url cannot be combined with opa.policies or opa.bundle")
+	}
+	if raw.OPA.Timeout < 0 {
+		return errors.New("opa.timeout must not be negative")
+	}
+	c.OPA = raw.OPA
+
+	if _, err := ParseDialect(raw.Dialect); err != nil {
+		return err
+	}
+	c.Dialect = raw.Dialect
+
+	switch raw.ExecutionBackend {
+	case "", BackendLocal:
+	case BackendDocker:
+		if raw.Docker.Image == "" {
+			return errors.New("docker.image is required for the docker execution backend")
+		}
+	case BackendKubernetes:
+		if raw.Kubernetes.Pod == "" {
+			return errors.New("kubernetes.pod is required for the kubernetes execution backend")
+		}
+	default:
+		return fmt.Errorf("unknown execution backend %q", raw.ExecutionBackend)
+	}
+	c.ExecutionBackend = raw.ExecutionBackend
+	c.Docker = raw.Docker
+	c.Kubernetes = raw.Kubernetes
+
+	if err := checkOverlays(raw.Users, raw.Roles); err != nil {
+		return err
+	}
+	c.Users = raw.Users
+	c.Roles = raw.Roles
+
+	return nil
+}
+
+// checkRewrite checks that a rewrite replaces a command name with another command.
+func checkRewrite(name string, to []string) error {
+	if name == "" || strings.ContainsAny(name, "/ ") {
+		return fmt.Errorf("invalid rewrites command %q", name)
+	}
+	if len(to) == 0 || to[0] == "" {
+		return fmt.Errorf("rewrites.%s must name the command that replaces %q", name, name)
+	}
 	return nil
 }
 
+// checkEnvVar checks the name of an env entry and the secret reference it holds, if any.
+func checkEnvVar(name string, v EnvVar) error {
+	if !syntax.ValidName(name) {
+		return fmt.Errorf("invalid env variable name %q", name)
+	}
+	if v.FromSecret != "" {
+		if _, err := ParseSecretRef(v.FromSecret); err != nil {
+			return fmt.Errorf("env %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// checkRuleReferences checks that rule IDs are unique and documentation URLs are absolute.
+func checkRuleReferences(allowCommands []AllowCommand, denyCommands []DenyCommand) error {
+	ids := make(map[string]bool)
+	check := func(id, docsURL string) error {
+		if id != "" {
+			if ids[id] {
+				return fmt.Errorf("duplicate rule id %q", id)
+			}
+			ids[id] = true
+		}
+		if docsURL != "" {
+			if u, err := url.Parse(docsURL); err != nil || !u.IsAbs() {
+				return fmt.Errorf("docsUrl must be an absolute URL: %q", docsURL)
+			}
+		}
+		return nil
+	}
+	for _, rule := range denyCommands {
+		if err := check(rule.ID, rule.DocsURL); err != nil {
+			return err
+		}
+	}
+	for _, rule := range allowCommands {
+		if err := check(rule.ID, rule.DocsURL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetRuleSource records source as the origin of every allow and deny rule of the configuration.
+func (c *ShellCommandConfig) SetRuleSource(source string) {
+	for i := range c.AllowCommands {
+		c.AllowCommands[i].Source = source
+	}
+	for i := range c.DenyCommands {
+		c.DenyCommands[i].Source = source
+	}
+}
+
 // NewDefaultConfig returns a default configuration.
 func NewDefaultConfig() *ShellCommandConfig {
 	return &ShellCommandConfig{
@@ -160,12 +1773,13 @@ func LoadConfigFromFile(filePath string) (*ShellCommandConfig, error) {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	var config ShellCommandConfig
-	if err := json.Unmarshal(fileBytes, &config); err != nil {
+	config, err := Parse(fileBytes)
+	if err != nil {
 		return nil, fmt.Errorf("failed to decode config file: %w", err)
 	}
+	config.SetRuleSource(filePath)
 
-	return &config, nil
+	return config, nil
 }
 
 // UnmarshalDenyCommands processes the raw JSON for deny commands which can be either strings or objects.
@@ -220,6 +1834,14 @@ func UnmarshalAllowCommands(data []byte) ([]AllowCommand, error) {
 		if err := json.Unmarshal(raw, &cmdObj); err != nil {
 			return nil, err
 		}
+		if err := cmdObj.Hooks.check(); err != nil {
+			return nil, fmt.Errorf("hooks of %q: %w", cmdObj.Command, err)
+		}
+		for _, name := range slices.Sorted(maps.Keys(cmdObj.Env)) {
+			if err := checkEnvVar(name, cmdObj.Env[name]); err != nil {
+				return nil, fmt.Errorf("env of %q: %w", cmdObj.Command, err)
+			}
+		}
 		result = append(result, cmdObj)
 	}
 
@@ -236,9 +1858,11 @@ func (c *ShellCommandConfig) IsCommandAllowed(cmd string) bool {
 	return false
 }
 
-// AddAllowedCommand adds a new command to the allowed commands list.
+// AddAllowedCommand adds a new command to the allowed commands list. The list is copied rather
+// than appended to in place, so that slices of it held elsewhere are unaffected; a policy that
+// is already being read concurrently must be changed with Store.Update instead.
 func (c *ShellCommandConfig) AddAllowedCommand(cmd string) {
 	if !c.IsCommandAllowed(cmd) {
-		c.AllowCommands = append(c.AllowCommands, AllowCommand{Command: cmd})
+		c.AllowCommands = append(slices.Clip(c.AllowCommands), AllowCommand{Command: cmd})
 	}
 }
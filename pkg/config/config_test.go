@@ -2,7 +2,14 @@ package config
 
 import (
 	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
+
+	"mvdan.cc/sh/v3/syntax"
 )
 
 func TestNewDefaultConfig(t *testing.T) {
@@ -379,3 +386,1011 @@ func TestUnmarshalOmittedUsesDefaults(t *testing.T) {
 		t.Errorf("MaxOutputSize = %d, want %d", cfg.MaxOutputSize, DefaultMaxOutputSize)
 	}
 }
+
+func TestUnmarshalBlockLog(t *testing.T) {
+	data := `{
+		"allowedDirectories": ["/tmp"],
+		"allowCommands": [],
+		"denyCommands": [],
+		"blockLogPath": "/var/log/secure-shell/blocked.log",
+		"blockLog": {"maxSize": 10, "maxBackups": 3, "maxAge": 7, "compress": true}
+	}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	want := BlockLogConfig{MaxSize: 10, MaxBackups: 3, MaxAge: 7, Compress: true}
+	if cfg.BlockLog != want {
+		t.Errorf("BlockLog = %+v, want %+v", cfg.BlockLog, want)
+	}
+
+	if err := json.Unmarshal([]byte(`{"allowCommands": [], "denyCommands": [], "blockLog": {"maxSize": -1}}`), &cfg); err == nil {
+		t.Error("Unmarshal() with negative maxSize should fail")
+	}
+}
+
+func TestUnmarshalReadOnly(t *testing.T) {
+	data := `{
+		"allowedDirectories": ["/tmp"],
+		"allowCommands": ["rm", {"command": "ls", "readOnly": true}, {"command": "git", "approvalRequired": true, "literalArgs": true}],
+		"denyCommands": [],
+		"readOnlyOnly": true,
+		"denyNestedCommands": true,
+		"denyDynamicCommands": true,
+		"inProcessCommands": true,
+		"approvalTimeout": 90,
+		"idleTimeout": 15,
+		"historyPath": "/var/lib/secure-shell/history.db"
+	}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	if !cfg.ReadOnlyOnly {
+		t.Error("ReadOnlyOnly = false, want true")
+	}
+	if !cfg.DenyNestedCommands {
+		t.Error("DenyNestedCommands = false, want true")
+	}
+	if !cfg.DenyDynamicCommands {
+		t.Error("DenyDynamicCommands = false, want true")
+	}
+	if !cfg.InProcessCommands {
+		t.Error("InProcessCommands = false, want true")
+	}
+	if cfg.AllowCommands[0].ReadOnly {
+		t.Error("AllowCommands[0].ReadOnly = true, want false")
+	}
+	if !cfg.AllowCommands[1].ReadOnly {
+		t.Error("AllowCommands[1].ReadOnly = false, want true")
+	}
+	if !cfg.AllowCommands[2].ApprovalRequired {
+		t.Error("AllowCommands[2].ApprovalRequired = false, want true")
+	}
+	if !cfg.AllowCommands[2].LiteralArgs {
+		t.Error("AllowCommands[2].LiteralArgs = false, want true")
+	}
+	if cfg.ApprovalTimeout != 90 {
+		t.Errorf("ApprovalTimeout = %d, want 90", cfg.ApprovalTimeout)
+	}
+	if cfg.HistoryPath != "/var/lib/secure-shell/history.db" {
+		t.Errorf("HistoryPath = %q, want /var/lib/secure-shell/history.db", cfg.HistoryPath)
+	}
+	if cfg.IdleTimeout != 15 {
+		t.Errorf("IdleTimeout = %d, want 15", cfg.IdleTimeout)
+	}
+
+	if err := json.Unmarshal([]byte(`{"allowCommands": [], "denyCommands": [], "idleTimeout": -1}`), &cfg); err == nil {
+		t.Error("Unmarshal() with negative idleTimeout should fail")
+	}
+}
+
+func TestUnmarshalRisk(t *testing.T) {
+	data := `{"allowCommands": [], "denyCommands": [], "risk": {"threshold": 60, "action": "deny"}}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if cfg.Risk.Threshold != 60 || cfg.Risk.Action != RiskActionDeny {
+		t.Errorf("Risk = %+v, want threshold 60 and action deny", cfg.Risk)
+	}
+
+	for _, invalid := range []string{`{"threshold": -1}`, `{"threshold": 60, "action": "warn"}`} {
+		data := `{"allowCommands": [], "denyCommands": [], "risk": ` + invalid + `}`
+		if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+			t.Errorf("Unmarshal() with risk %s should fail", invalid)
+		}
+	}
+}
+
+func TestUnmarshalLandlock(t *testing.T) {
+	data := `{
+		"allowedDirectories": ["/tmp"],
+		"allowCommands": [],
+		"denyCommands": [],
+		"landlock": {"enabled": true, "readOnlyPaths": ["/usr", "/etc"], "bestEffort": true}
+	}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	if !cfg.Landlock.Enabled || !cfg.Landlock.BestEffort {
+		t.Errorf("Landlock = %+v, want enabled and best effort", cfg.Landlock)
+	}
+	if len(cfg.Landlock.ReadOnlyPaths) != 2 || cfg.Landlock.ReadOnlyPaths[1] != "/etc" {
+		t.Errorf("Landlock.ReadOnlyPaths = %v, want [/usr /etc]", cfg.Landlock.ReadOnlyPaths)
+	}
+}
+
+func TestUnmarshalDisableNetwork(t *testing.T) {
+	data := `{
+		"allowCommands": ["ls", {"command": "pip", "allowNetwork": true}],
+		"denyCommands": [],
+		"disableNetwork": true
+	}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	if !cfg.DisableNetwork {
+		t.Error("DisableNetwork = false, want true")
+	}
+	if cfg.AllowCommands[0].AllowNetwork || !cfg.AllowCommands[1].AllowNetwork {
+		t.Errorf("AllowCommands = %+v, want only pip to allow network", cfg.AllowCommands)
+	}
+}
+
+func TestUnmarshalEnv(t *testing.T) {
+	data := `{
+		"allowCommands": [],
+		"denyCommands": [],
+		"env": {"STAGE": "ci", "API_TOKEN": {"fromSecret": "vault:kv/ci#token"}},
+		"secrets": {"vault": {"address": "https://vault.example.com", "tokenFile": "/run/vault-token"}, "cacheTtl": 60}
+	}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if cfg.Env["STAGE"] != (EnvVar{Value: "ci"}) || cfg.Env["API_TOKEN"] != (EnvVar{FromSecret: "vault:kv/ci#token"}) {
+		t.Errorf("Env = %+v", cfg.Env)
+	}
+	if cfg.Secrets.Vault.Address != "https://vault.example.com" || cfg.Secrets.CacheTTL != 60 {
+		t.Errorf("Secrets = %+v", cfg.Secrets)
+	}
+
+	ref, err := ParseSecretRef("vault:kv/ci#token")
+	if err != nil || ref != (SecretRef{Scheme: "vault", Path: "kv/ci", Field: "token"}) {
+		t.Errorf("ParseSecretRef() = %+v, %v", ref, err)
+	}
+
+	for _, invalid := range []string{
+		`{"1TOKEN": "x"}`,
+		`{"API_TOKEN": {"fromSecret": "kv/ci"}}`,
+		`{"API_TOKEN": {"fromSecret": "vault:"}}`,
+		`{"API_TOKEN": {"value": "x", "fromSecret": "vault:kv/ci"}}`,
+		`{"API_TOKEN": {"secret": "vault:kv/ci"}}`,
+	} {
+		data := `{"allowCommands": [], "denyCommands": [], "env": ` + invalid + `}`
+		if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+			t.Errorf("Unmarshal() with env %s should fail", invalid)
+		}
+	}
+}
+
+func TestUnmarshalAllowCommandEnv(t *testing.T) {
+	data := `{
+		"allowCommands": [{"command": "git", "env": {"GIT_TERMINAL_PROMPT": "0", "GH_TOKEN": {"fromSecret": "file:/run/secrets/gh"}}}],
+		"denyCommands": []
+	}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	env := cfg.AllowCommands[0].Env
+	if env["GIT_TERMINAL_PROMPT"] != (EnvVar{Value: "0"}) || env["GH_TOKEN"] != (EnvVar{FromSecret: "file:/run/secrets/gh"}) {
+		t.Errorf("Env = %+v", env)
+	}
+
+	for _, invalid := range []string{`{"1TOKEN": "x"}`, `{"GH_TOKEN": {"fromSecret": "kv/ci"}}`} {
+		data := `{"allowCommands": [{"command": "git", "env": ` + invalid + `}], "denyCommands": []}`
+		if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+			t.Errorf("Unmarshal() with rule env %s should fail", invalid)
+		}
+	}
+}
+
+func TestUnmarshalEgressProxy(t *testing.T) {
+	data := `{"allowCommands": [], "denyCommands": [], "egressProxy": {"url": "http://proxy.internal:3128", "noProxy": ["localhost", ".internal"]}}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if cfg.EgressProxy.URL != "http://proxy.internal:3128" || len(cfg.EgressProxy.NoProxy) != 2 {
+		t.Errorf("EgressProxy = %+v", cfg.EgressProxy)
+	}
+
+	for _, invalid := range []string{
+		`{"url": "proxy.internal:3128"}`,
+		`{"url": "ftp://proxy.internal"}`,
+		`{"url": "http://proxy.internal:3128", "noProxy": ["a,b"]}`,
+		`{"noProxy": ["localhost"]}`,
+	} {
+		data := `{"allowCommands": [], "denyCommands": [], "egressProxy": ` + invalid + `}`
+		if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+			t.Errorf("Unmarshal() with egressProxy %s should fail", invalid)
+		}
+	}
+}
+
+func TestUnmarshalInterpolateEnv(t *testing.T) {
+	t.Setenv("PROJECT_ROOT", "/srv/project")
+	t.Setenv("CACHE_DIR", "")
+	data := `{"allowCommands": [], "denyCommands": [], "interpolateEnv": ["PROJECT_ROOT", "CACHE_DIR"],
+		"allowedDirectories": ["${PROJECT_ROOT}/src", "${CACHE_DIR:-/var/cache}/build"],
+		"historyPath": "${PROJECT_ROOT}/.history", "users": {"alice": {"allowedDirectories": ["${PROJECT_ROOT}/alice"]}},
+		"defaultErrorMessage": "denied in ${PROJECT_ROOT}"}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if want := []string{"/srv/project/src", "/var/cache/build"}; !slices.Equal(cfg.AllowedDirectories, want) {
+		t.Errorf("AllowedDirectories = %v, want %v", cfg.AllowedDirectories, want)
+	}
+	if cfg.HistoryPath != "/srv/project/.history" {
+		t.Errorf("HistoryPath = %q", cfg.HistoryPath)
+	}
+	if dirs := cfg.Users["alice"].AllowedDirectories; len(dirs) != 1 || dirs[0] != "/srv/project/alice" {
+		t.Errorf("users.alice.allowedDirectories = %v", dirs)
+	}
+	// Only the listed path fields are interpolated
+	if cfg.DefaultErrorMessage != "denied in ${PROJECT_ROOT}" {
+		t.Errorf("DefaultErrorMessage = %q", cfg.DefaultErrorMessage)
+	}
+
+	for _, tt := range []struct {
+		name string
+		data string
+	}{
+		{"NotListed", `{"allowedDirectories": ["${PROJECT_ROOT}"]}`},
+		{"UnsetWithoutFallback", `{"interpolateEnv": ["CACHE_DIR"], "allowedDirectories": ["${CACHE_DIR}/build"]}`},
+		{"Unterminated", `{"interpolateEnv": ["PROJECT_ROOT"], "allowedDirectories": ["${PROJECT_ROOT"]}`},
+		{"InvalidName", `{"interpolateEnv": ["PROJECT-ROOT"], "allowedDirectories": ["/srv"]}`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			data := `{"allowCommands": [], "denyCommands": [], ` + strings.TrimPrefix(tt.data, "{")
+			var cfg ShellCommandConfig
+			if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+				t.Errorf("Unmarshal() of %s should fail", tt.data)
+			}
+		})
+	}
+}
+
+func TestUnmarshalCategories(t *testing.T) {
+	data := `{"allowCommands": [], "denyCommands": [], "allowCategories": ["vcs"], "denyCategories": ["network", "container"]}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if len(cfg.AllowCategories) != 1 || cfg.AllowCategories[0] != "vcs" {
+		t.Errorf("AllowCategories = %v, want [vcs]", cfg.AllowCategories)
+	}
+	if len(cfg.DenyCategories) != 2 || cfg.DenyCategories[1] != "container" {
+		t.Errorf("DenyCategories = %v, want [network container]", cfg.DenyCategories)
+	}
+
+	data = `{"allowCommands": [], "denyCommands": [], "denyCategories": ["games"]}`
+	if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+		t.Error("Unmarshal() with an unknown category should fail")
+	}
+}
+
+func TestUnmarshalScratch(t *testing.T) {
+	data := `{"allowCommands": [], "denyCommands": [], "scratch": {"enabled": true, "dir": "/var/tmp", "maxSize": 64, "tmpfs": true}}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := ScratchConfig{Enabled: true, Dir: "/var/tmp", MaxSize: 64, Tmpfs: true}
+	if cfg.Scratch != want {
+		t.Errorf("Scratch = %+v, want %+v", cfg.Scratch, want)
+	}
+
+	data = `{"allowCommands": [], "denyCommands": [], "scratch": {"enabled": true, "maxSize": -1}}`
+	if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+		t.Error("Unmarshal() with a negative scratch.maxSize should fail")
+	}
+}
+
+func TestUnmarshalOutputSafety(t *testing.T) {
+	data := `{"allowCommands": [], "denyCommands": [], "outputSafety": {"enabled": true, "binary": "base64", "keepEscapes": true}}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := OutputSafetyConfig{Enabled: true, Binary: BinaryBase64, KeepEscapes: true}
+	if cfg.OutputSafety != want {
+		t.Errorf("OutputSafety = %+v, want %+v", cfg.OutputSafety, want)
+	}
+
+	data = `{"allowCommands": [], "denyCommands": [], "outputSafety": {"enabled": true, "binary": "hex"}}`
+	if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+		t.Error("Unmarshal() with an unknown outputSafety.binary should fail")
+	}
+}
+
+func TestUnmarshalResultCache(t *testing.T) {
+	data := `{"allowCommands": [{"command": "git", "subCommands": [{"name": "status", "cacheable": true}]}], "denyCommands": [],
+		"resultCache": {"ttl": 5, "maxEntries": 10, "maxEntrySize": 16}}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := ResultCacheConfig{TTL: 5, MaxEntries: 10, MaxEntrySize: 16}
+	if cfg.ResultCache != want {
+		t.Errorf("ResultCache = %+v, want %+v", cfg.ResultCache, want)
+	}
+	if !cfg.CachesResults() {
+		t.Error("CachesResults() = false with a cacheable subcommand")
+	}
+
+	data = `{"allowCommands": [], "denyCommands": [], "resultCache": {"ttl": -1}}`
+	if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+		t.Error("Unmarshal() with a negative resultCache.ttl should fail")
+	}
+}
+
+func TestUnmarshalScriptLimits(t *testing.T) {
+	data := `{"allowCommands": [], "denyCommands": [],
+		"scriptLimits": {"maxSize": 65536, "maxNodes": 10000, "maxDepth": 20, "maxLoops": 10, "maxCommands": 200, "maxPipeline": 5, "maxChain": 20, "maxSourceDepth": 3}}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := ScriptLimitsConfig{MaxSize: 65536, MaxNodes: 10000, MaxDepth: 20, MaxLoops: 10, MaxCommands: 200, MaxPipeline: 5, MaxChain: 20, MaxSourceDepth: 3}
+	if cfg.ScriptLimits != want {
+		t.Errorf("ScriptLimits = %+v, want %+v", cfg.ScriptLimits, want)
+	}
+	if got := cfg.ScriptLimits.SourceDepth(); got != 3 {
+		t.Errorf("SourceDepth() = %d, want 3", got)
+	}
+	if got := (ScriptLimitsConfig{}).SourceDepth(); got != DefaultMaxSourceDepth {
+		t.Errorf("SourceDepth() of zero limits = %d, want %d", got, DefaultMaxSourceDepth)
+	}
+
+	data = `{"allowCommands": [], "denyCommands": [], "scriptLimits": {"maxDepth": -1}}`
+	if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+		t.Error("Unmarshal() with a negative scriptLimits.maxDepth should fail")
+	}
+}
+
+func TestUnmarshalSFTP(t *testing.T) {
+	data := `{"allowCommands": [], "denyCommands": [], "sftp": {"enabled": true, "readOnly": true}}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := SFTPConfig{Enabled: true, ReadOnly: true}
+	if cfg.SFTP != want {
+		t.Errorf("SFTP = %+v, want %+v", cfg.SFTP, want)
+	}
+}
+
+func TestUnmarshalSeccomp(t *testing.T) {
+	data := `{"allowCommands": [{"command": "gdb", "seccompProfile": "unconfined"}], "denyCommands": [],
+		"seccomp": {"enabled": true, "action": "kill", "profiles": {"strict": ["ptrace", "bpf"]}}}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if cfg.Seccomp.Action != SeccompActionKill || cfg.AllowCommands[0].SeccompProfile != SeccompProfileUnconfined {
+		t.Errorf("Seccomp = %+v, AllowCommands = %+v", cfg.Seccomp, cfg.AllowCommands)
+	}
+	if got, ok := cfg.Seccomp.DeniedSyscalls(""); !ok || len(got) != len(DefaultSeccompDenySyscalls) {
+		t.Errorf("DeniedSyscalls(\"\") = %v, %v; want the default list", got, ok)
+	}
+	if got, ok := cfg.Seccomp.DeniedSyscalls("strict"); !ok || len(got) != 2 {
+		t.Errorf("DeniedSyscalls(\"strict\") = %v, %v", got, ok)
+	}
+	if _, ok := cfg.Seccomp.DeniedSyscalls("missing"); ok {
+		t.Error("DeniedSyscalls() found a profile that does not exist")
+	}
+
+	for _, seccomp := range []string{
+		`{"action": "trap"}`,
+		`{"denySyscalls": ["execve"]}`,
+		`{"profiles": {"default": ["ptrace"]}}`,
+	} {
+		data = `{"allowCommands": [], "denyCommands": [], "seccomp": ` + seccomp + `}`
+		if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+			t.Errorf("Unmarshal() with seccomp %s should fail", seccomp)
+		}
+	}
+}
+
+func TestUnmarshalSnapshot(t *testing.T) {
+	data := `{"allowCommands": [], "denyCommands": [], "snapshot": {"enabled": true, "dir": "/var/tmp", "maxSize": 512, "retention": 600}}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := SnapshotConfig{Enabled: true, Dir: "/var/tmp", MaxSize: 512, Retention: 600}
+	if cfg.Snapshot != want {
+		t.Errorf("Snapshot = %+v, want %+v", cfg.Snapshot, want)
+	}
+
+	data = `{"allowCommands": [], "denyCommands": [], "snapshot": {"enabled": true, "retention": -1}}`
+	if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+		t.Error("Unmarshal() with a negative snapshot.retention should fail")
+	}
+}
+
+func TestUnmarshalTrash(t *testing.T) {
+	data := `{"allowCommands": [], "denyCommands": [], "trash": {"enabled": true, "dir": "/var/trash", "ttl": 3600}}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := TrashConfig{Enabled: true, Dir: "/var/trash", TTL: 3600}
+	if cfg.Trash != want {
+		t.Errorf("Trash = %+v, want %+v", cfg.Trash, want)
+	}
+
+	data = `{"allowCommands": [], "denyCommands": [], "trash": {"enabled": true, "ttl": -1}}`
+	if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+		t.Error("Unmarshal() with a negative trash.ttl should fail")
+	}
+}
+
+func TestUnmarshalMessages(t *testing.T) {
+	data := `{"allowCommands": [], "denyCommands": [], "messages": {"locale": "ja", "catalog": {"ja": {"default": "{{.Command}} は拒否されました"}}}}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if cfg.Messages.Locale != "ja" || cfg.Messages.Catalog["ja"]["default"] != "{{.Command}} は拒否されました" {
+		t.Errorf("Messages = %+v", cfg.Messages)
+	}
+
+	data = `{"allowCommands": [], "denyCommands": [], "messages": {"locale": "ja", "catalog": {"ja": {"default": "{{.Command"}}}}`
+	if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+		t.Error("Unmarshal() with an invalid messages.catalog template should fail")
+	}
+}
+
+func TestUnmarshalObfuscation(t *testing.T) {
+	data := `{"allowCommands": [], "denyCommands": [], "obfuscation": {"action": "deny", "patterns": ["decode-exec", "inline-code"], "allowInline": ["python3"]}}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if cfg.Obfuscation.Action != ObfuscationActionDeny || len(cfg.Obfuscation.AllowInline) != 1 {
+		t.Errorf("Obfuscation = %+v", cfg.Obfuscation)
+	}
+	if !cfg.Obfuscation.Detects(ObfuscationDecodeExec) || cfg.Obfuscation.Detects(ObfuscationPipeExec) {
+		t.Errorf("Obfuscation.Detects() does not follow patterns %v", cfg.Obfuscation.Patterns)
+	}
+
+	for _, data := range []string{
+		`{"allowCommands": [], "denyCommands": [], "obfuscation": {"action": "block"}}`,
+		`{"allowCommands": [], "denyCommands": [], "obfuscation": {"action": "flag", "patterns": ["hex"]}}`,
+	} {
+		if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+			t.Errorf("Unmarshal(%s) should fail", data)
+		}
+	}
+}
+
+func TestUnmarshalCgroup(t *testing.T) {
+	data := `{"allowCommands": [], "denyCommands": [], "cgroup": {"enabled": true, "memoryMax": 512, "cpuMax": 1.5, "pidsMax": 64}}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !cfg.Cgroup.Enabled || cfg.Cgroup.MemoryMax != 512 || cfg.Cgroup.CPUMax != 1.5 || cfg.Cgroup.PidsMax != 64 {
+		t.Errorf("Cgroup = %+v", cfg.Cgroup)
+	}
+
+	for _, data := range []string{
+		`{"allowCommands": [], "denyCommands": [], "cgroup": {"enabled": true, "pidsMax": -1}}`,
+		`{"allowCommands": [], "denyCommands": [], "cgroup": {"enabled": true, "parent": "../escape"}}`,
+	} {
+		if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+			t.Errorf("Unmarshal(%s) should fail", data)
+		}
+	}
+}
+
+func TestUnmarshalFileAudit(t *testing.T) {
+	data := `{"allowCommands": [], "denyCommands": [], "fileAudit": {"enabled": true, "maxFiles": 500, "exclude": [".git", "*.log"]}}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !cfg.FileAudit.Enabled || cfg.FileAudit.MaxFiles != 500 || len(cfg.FileAudit.Exclude) != 2 {
+		t.Errorf("FileAudit = %+v", cfg.FileAudit)
+	}
+
+	data = `{"allowCommands": [], "denyCommands": [], "fileAudit": {"enabled": true, "exclude": ["[a-"]}}`
+	if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+		t.Error("Unmarshal() with an invalid fileAudit.exclude pattern should fail")
+	}
+}
+
+func TestUnmarshalGit(t *testing.T) {
+	data := `{"allowCommands": [], "denyCommands": [], "git": {"enabled": true, "allowSubCommands": ["push"], "allowedRemotes": ["https://github.com/acme/*"]}}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !cfg.Git.Enabled || len(cfg.Git.AllowSubCommands) != 1 || len(cfg.Git.AllowedRemotes) != 1 {
+		t.Errorf("Git = %+v", cfg.Git)
+	}
+
+	for _, data := range []string{
+		`{"allowCommands": [], "denyCommands": [], "git": {"enabled": true, "allowSubCommands": ["push --force"]}}`,
+		`{"allowCommands": [], "denyCommands": [], "git": {"enabled": true, "allowedRemotes": ["[a-"]}}`,
+	} {
+		if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+			t.Errorf("Unmarshal(%s) should fail", data)
+		}
+	}
+}
+
+func TestUnmarshalSessions(t *testing.T) {
+	data := `{"allowCommands": [], "denyCommands": [], "sessions": {"maxConcurrent": 2, "maxCommands": 100, "maxLifetime": 3600}}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := SessionLimitsConfig{MaxConcurrent: 2, MaxCommands: 100, MaxLifetime: 3600}
+	if cfg.Sessions != want {
+		t.Errorf("Sessions = %+v, want %+v", cfg.Sessions, want)
+	}
+
+	data = `{"allowCommands": [], "denyCommands": [], "sessions": {"maxCommands": -1}}`
+	if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+		t.Error("Unmarshal() with a negative sessions.maxCommands should fail")
+	}
+}
+
+func TestUnmarshalRuleReferences(t *testing.T) {
+	data := `{"allowCommands": [{"command": "git", "id": "vcs", "docsUrl": "https://wiki.example.com/git"}], "denyCommands": [{"command": "cat", "args": [".env"]}]}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if cfg.AllowCommands[0].RuleID() != "vcs" || cfg.AllowCommands[0].DocsURL != "https://wiki.example.com/git" {
+		t.Errorf("AllowCommands = %+v", cfg.AllowCommands)
+	}
+
+	// Derived IDs depend on the arguments a rule matches, and on nothing else
+	other := DenyCommand{Command: "cat", Args: []string{"id_rsa"}}
+	if cfg.DenyCommands[0].RuleID() == other.RuleID() {
+		t.Errorf("rules with different arguments share the ID %q", other.RuleID())
+	}
+	if cfg.DenyCommands[0].RuleID() != (DenyCommand{Command: "cat", Args: []string{".env"}, Message: "changed"}).RuleID() {
+		t.Error("the derived ID changed with the message")
+	}
+
+	for _, data := range []string{
+		`{"allowCommands": [{"command": "ls", "id": "x"}], "denyCommands": [{"command": "rm", "id": "x"}]}`,
+		`{"allowCommands": [], "denyCommands": [{"command": "rm", "docsUrl": "wiki/rm"}]}`,
+	} {
+		if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+			t.Errorf("Unmarshal(%s) should fail", data)
+		}
+	}
+}
+
+func TestUnmarshalTemplates(t *testing.T) {
+	data := `{"allowCommands": [], "denyCommands": [], "templates": {"restart": "systemctl restart {{service:regexp:[a-z-]+}}"}}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if cfg.Templates["restart"] != "systemctl restart {{service:regexp:[a-z-]+}}" {
+		t.Errorf("Templates = %v", cfg.Templates)
+	}
+
+	data = `{"allowCommands": [], "denyCommands": [], "templates": {"bad": "echo {{n:float}}"}}`
+	if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+		t.Error("Unmarshal() with an invalid template should fail")
+	}
+}
+
+func TestUnmarshalQuota(t *testing.T) {
+	data := `{"allowCommands": [], "denyCommands": [],
+		"quota": {"maxCpuSeconds": 3600, "maxOutputBytes": 104857600, "maxExecutions": 500}}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := QuotaConfig{MaxCPUSeconds: 3600, MaxOutputBytes: 104857600, MaxExecutions: 500}
+	if cfg.Quota != want || !cfg.Quota.Enabled() {
+		t.Errorf("Quota = %+v, want %+v", cfg.Quota, want)
+	}
+
+	data = `{"allowCommands": [], "denyCommands": [], "quota": {"maxOutputBytes": -1}}`
+	if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+		t.Error("Unmarshal() with a negative quota.maxOutputBytes should fail")
+	}
+}
+
+func TestUnmarshalRewrites(t *testing.T) {
+	data := `{"allowCommands": [], "denyCommands": [],
+		"rewrites": {"rm": ["trash-put"], "curl": ["curl", "--max-time", "10", "--proto", "=https"]}}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !slices.Equal(cfg.Rewrites["rm"], []string{"trash-put"}) || len(cfg.Rewrites["curl"]) != 5 {
+		t.Errorf("Rewrites = %v", cfg.Rewrites)
+	}
+
+	for _, invalid := range []string{`{"rm": []}`, `{"rm": [""]}`, `{"": ["true"]}`, `{"/bin/rm": ["trash-put"]}`} {
+		data = `{"allowCommands": [], "denyCommands": [], "rewrites": ` + invalid + `}`
+		if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+			t.Errorf("Unmarshal() with rewrites %s should fail", invalid)
+		}
+	}
+}
+
+func TestUnmarshalExecutionBackend(t *testing.T) {
+	data := `{"allowCommands": [], "denyCommands": [], "executionBackend": "docker", "docker": {"image": "alpine:3", "network": "bridge"}}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if cfg.ExecutionBackend != BackendDocker || cfg.Docker.Image != "alpine:3" || cfg.Docker.Network != "bridge" {
+		t.Errorf("ExecutionBackend = %q, Docker = %+v", cfg.ExecutionBackend, cfg.Docker)
+	}
+
+	data = `{"allowCommands": [], "denyCommands": [], "executionBackend": "kubernetes", "kubernetes": {"pod": "web", "namespace": "debug", "container": "app"}}`
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if want := (KubernetesConfig{Pod: "web", Namespace: "debug", Container: "app"}); cfg.ExecutionBackend != BackendKubernetes || cfg.Kubernetes != want {
+		t.Errorf("ExecutionBackend = %q, Kubernetes = %+v", cfg.ExecutionBackend, cfg.Kubernetes)
+	}
+
+	for _, data := range []string{
+		`{"allowCommands": [], "denyCommands": [], "executionBackend": "docker"}`,
+		`{"allowCommands": [], "denyCommands": [], "executionBackend": "kubernetes", "kubernetes": {"namespace": "debug"}}`,
+		`{"allowCommands": [], "denyCommands": [], "executionBackend": "vm"}`,
+	} {
+		if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+			t.Errorf("Unmarshal(%s) should fail", data)
+		}
+	}
+}
+
+func TestUnmarshalAlerts(t *testing.T) {
+	data := `{"allowCommands": [], "denyCommands": [{"command": "cat", "args": ["/etc/shadow"], "alert": true}],
+		"alerts": {"webhookUrl": "https://alerts.example.com/hook", "freezeSession": true}}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	rule := cfg.DenyCommands[0]
+	if !rule.Alert || len(rule.Args) != 1 || rule.Args[0] != "/etc/shadow" {
+		t.Errorf("DenyCommands[0] = %+v", rule)
+	}
+	if cfg.Alerts.WebhookURL != "https://alerts.example.com/hook" || !cfg.Alerts.FreezeSession {
+		t.Errorf("Alerts = %+v", cfg.Alerts)
+	}
+
+	if !rule.Matches("cat", []string{"-n", "/etc/shadow"}) {
+		t.Error("rule should match an argument naming the honeypot")
+	}
+	if rule.Matches("cat", []string{"/etc/hosts"}) || rule.Matches("less", []string{"/etc/shadow"}) {
+		t.Error("rule should only match its command with a listed argument")
+	}
+
+	data = `{"allowCommands": [], "denyCommands": [], "alerts": {"webhookUrl": "ftp://example.com"}}`
+	if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+		t.Errorf("Unmarshal(%s) should fail", data)
+	}
+}
+
+func TestUnmarshalDialect(t *testing.T) {
+	data := `{"allowCommands": [], "denyCommands": [], "dialect": "posix"}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if cfg.Dialect != DialectPOSIX || cfg.Lang() != syntax.LangPOSIX {
+		t.Errorf("Dialect = %q, Lang() = %v", cfg.Dialect, cfg.Lang())
+	}
+	if lang := (&ShellCommandConfig{}).Lang(); lang != syntax.LangBash {
+		t.Errorf("default Lang() = %v, want bash", lang)
+	}
+
+	data = `{"allowCommands": [], "denyCommands": [], "dialect": "zsh"}`
+	if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+		t.Errorf("Unmarshal(%s) should fail", data)
+	}
+}
+
+func TestUnmarshalAllowedBinDirs(t *testing.T) {
+	data := `{"allowCommands": [], "denyCommands": [], "allowedBinDirs": ["/usr/bin", "/bin"]}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if want := "/usr/bin" + string(os.PathListSeparator) + "/bin"; cfg.BinPath() != want {
+		t.Errorf("BinPath() = %q, want %q", cfg.BinPath(), want)
+	}
+	if path := (&ShellCommandConfig{}).BinPath(); path != "" {
+		t.Errorf("default BinPath() = %q, want empty", path)
+	}
+
+	data = `{"allowCommands": [], "denyCommands": [], "allowedBinDirs": ["bin"]}`
+	if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+		t.Errorf("Unmarshal(%s) should fail", data)
+	}
+}
+
+func TestUnmarshalAllowedDirectories(t *testing.T) {
+	t.Setenv("HOME", "/home/alice")
+	data := `{"allowCommands": [], "denyCommands": [],
+		"allowedDirectories": ["~", "~/projects/*", "$HOME/src", "${HOME}", "/data/tenants/*/workspace", "/srv/~cache"]}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := []string{"/home/alice", "/home/alice/projects/*", "/home/alice/src", "/home/alice", "/data/tenants/*/workspace", "/srv/~cache"}
+	if !slices.Equal(cfg.AllowedDirectories, want) {
+		t.Errorf("AllowedDirectories = %q, want %q", cfg.AllowedDirectories, want)
+	}
+
+	for _, dirs := range []string{`["~bob/projects"]`, `["/data/[tenants"]`} {
+		data = `{"allowCommands": [], "denyCommands": [], "allowedDirectories": ` + dirs + `}`
+		if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+			t.Errorf("Unmarshal(%s) should fail", data)
+		}
+	}
+}
+
+func TestDirectories(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	for _, dir := range []string{"a/workspace", "b/workspace", "c"} {
+		if err := os.MkdirAll(filepath.Join(root, "tenants", dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A match that is a symlink out of the tree is not allowed
+	if err := os.MkdirAll(filepath.Join(root, "tenants", "evil"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "tenants", "evil", "workspace")); err != nil {
+		t.Fatal(err)
+	}
+	// Symlinks before the pattern are resolved
+	link := filepath.Join(t.TempDir(), "link")
+	if err := os.Symlink(root, link); err != nil {
+		t.Fatal(err)
+	}
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &ShellCommandConfig{AllowedDirectories: []string{"/missing", filepath.Join(link, "tenants", "*", "workspace")}}
+	want := []string{
+		"/missing",
+		filepath.Join(resolvedRoot, "tenants", "a", "workspace"),
+		filepath.Join(resolvedRoot, "tenants", "b", "workspace"),
+	}
+	if got := cfg.Directories(); !slices.Equal(got, want) {
+		t.Errorf("Directories() = %q, want %q", got, want)
+	}
+
+	// Directories created later match without a reload
+	if err := os.MkdirAll(filepath.Join(root, "tenants", "c", "workspace"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Directories(); len(got) != 4 {
+		t.Errorf("Directories() = %q, want the new tenant's workspace", got)
+	}
+
+	cfg.AllowedDirectories = cfg.AllowedDirectories[1:]
+	if got := cfg.DefaultDirectory(); got != want[1] {
+		t.Errorf("DefaultDirectory() = %q, want %q", got, want[1])
+	}
+}
+
+func TestUnmarshalHooks(t *testing.T) {
+	data := `{"allowCommands": [{"command": "terraform", "hooks": {
+		"pre": [{"command": "mkdir .tf.lock", "timeout": 5}],
+		"post": [{"command": "rmdir .tf.lock"}, {"callback": "notify"}]
+	}}], "denyCommands": []}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := RuleHooks{
+		Pre:  []RuleHook{{Command: "mkdir .tf.lock", Timeout: 5}},
+		Post: []RuleHook{{Command: "rmdir .tf.lock"}, {Callback: "notify"}},
+	}
+	if got := cfg.AllowCommands[0].Hooks; !reflect.DeepEqual(got, want) {
+		t.Errorf("Hooks = %+v, want %+v", got, want)
+	}
+
+	for _, hook := range []string{`{}`, `{"command": "true", "callback": "notify"}`, `{"command": "true", "timeout": -1}`} {
+		data = `{"allowCommands": [{"command": "terraform", "hooks": {"pre": [` + hook + `]}}], "denyCommands": []}`
+		if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+			t.Errorf("Unmarshal(%s) should fail", data)
+		}
+	}
+}
+
+func TestUnmarshalAudit(t *testing.T) {
+	data := `{"allowCommands": [], "denyCommands": [], "audit": {"syslog": [
+		{},
+		{"network": "tcp", "address": "siem.example.com:6514", "format": "cef", "facility": "local4", "deniedOnly": true}
+	]}}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if len(cfg.Audit.Syslog) != 2 || cfg.Audit.Syslog[1].Format != SyslogFormatCEF || !cfg.Audit.Syslog[1].DeniedOnly {
+		t.Errorf("Audit = %+v", cfg.Audit)
+	}
+
+	for _, sink := range []string{
+		`{"network": "udp"}`,
+		`{"network": "http", "address": "siem:80"}`,
+		`{"address": "siem:514"}`,
+		`{"network": "udp", "address": "siem:514", "format": "leef"}`,
+		`{"facility": "local9"}`,
+	} {
+		data := `{"allowCommands": [], "denyCommands": [], "audit": {"syslog": [` + sink + `]}}`
+		if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+			t.Errorf("Unmarshal(%s) should fail", sink)
+		}
+	}
+}
+
+func TestUnmarshalOPA(t *testing.T) {
+	data := `{"allowCommands": [], "denyCommands": [], "opa": {"url": "http://127.0.0.1:8181", "decision": "shell/allow", "failOpen": true}}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if cfg.OPA.URL != "http://127.0.0.1:8181" || cfg.OPA.Decision != "shell/allow" || !cfg.OPA.FailOpen {
+		t.Errorf("OPA = %+v", cfg.OPA)
+	}
+	if !cfg.OPA.Enabled() || cfg.OPA.Embedded() {
+		t.Errorf("OPA server config: Enabled() = %v, Embedded() = %v", cfg.OPA.Enabled(), cfg.OPA.Embedded())
+	}
+
+	data = `{"allowCommands": [], "denyCommands": [], "opa": {"policies": ["/etc/secure-shell/policy"], "bundle": "/etc/secure-shell/bundle.tar.gz"}}`
+	cfg = ShellCommandConfig{}
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !cfg.OPA.Enabled() || !cfg.OPA.Embedded() || cfg.OPA.Bundle != "/etc/secure-shell/bundle.tar.gz" {
+		t.Errorf("OPA = %+v", cfg.OPA)
+	}
+
+	for _, data := range []string{
+		`{"allowCommands": [], "denyCommands": [], "opa": {"url": "127.0.0.1:8181"}}`,
+		`{"allowCommands": [], "denyCommands": [], "opa": {"url": "http://opa", "timeout": -1}}`,
+		`{"allowCommands": [], "denyCommands": [], "opa": {"url": "http://opa", "policies": ["policy.rego"]}}`,
+	} {
+		if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+			t.Errorf("Unmarshal(%s) should fail", data)
+		}
+	}
+}
+
+func TestUnmarshalPrivileges(t *testing.T) {
+	data := `{"allowCommands": [{"command": "sudo", "allowSetuid": true}], "denyCommands": [],
+		"privileges": {"keepCapabilities": ["CAP_NET_BIND_SERVICE"]}}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !cfg.AllowCommands[0].AllowSetuid {
+		t.Error("AllowCommands[0].AllowSetuid = false, want true")
+	}
+	if want := []string{"CAP_NET_BIND_SERVICE"}; !slices.Equal(cfg.Privileges.KeepCapabilities, want) {
+		t.Errorf("KeepCapabilities = %v, want %v", cfg.Privileges.KeepCapabilities, want)
+	}
+
+	data = `{"allowCommands": [], "denyCommands": [], "privileges": {"keepCapabilities": ["CAP_FOO"]}}`
+	if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+		t.Error("Unmarshal() with an unknown capability should fail")
+	}
+}
+
+func TestUnmarshalAuth(t *testing.T) {
+	data := `{"allowCommands": [], "denyCommands": [], "auth": {
+		"apiKeys": [{"user": "ci", "sha256": "` + strings.Repeat("ab", 32) + `"}],
+		"jwt": {"jwksUrl": "https://issuer.example/.well-known/jwks.json", "audience": "secure-shell"},
+		"mtls": {"clientCa": "/etc/secure-shell/clients.pem"}}}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !cfg.Auth.Enabled() || len(cfg.Auth.APIKeys) != 1 || cfg.Auth.APIKeys[0].User != "ci" {
+		t.Errorf("Auth.APIKeys = %+v", cfg.Auth.APIKeys)
+	}
+	if cfg.Auth.JWT.Audience != "secure-shell" || cfg.Auth.MTLS.ClientCA != "/etc/secure-shell/clients.pem" {
+		t.Errorf("Auth = %+v", cfg.Auth)
+	}
+
+	for _, auth := range []string{
+		`{"apiKeys": [{"user": "ci", "sha256": "not-a-hash"}]}`,
+		`{"apiKeys": [{"sha256": "` + strings.Repeat("ab", 32) + `"}]}`,
+		`{"jwt": {"jwksUrl": "file:///etc/jwks.json"}}`,
+		`{"jwt": {"audience": "secure-shell"}}`,
+	} {
+		data = `{"allowCommands": [], "denyCommands": [], "auth": ` + auth + `}`
+		if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+			t.Errorf("Unmarshal() with auth %s should fail", auth)
+		}
+	}
+}
+
+func TestUnmarshalEnforcementMode(t *testing.T) {
+	data := `{"allowCommands": [], "denyCommands": [], "enforcementMode": "permissive"}`
+
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if cfg.EnforcementMode != EnforcementPermissive {
+		t.Errorf("EnforcementMode = %q, want %q", cfg.EnforcementMode, EnforcementPermissive)
+	}
+
+	data = `{"allowCommands": [], "denyCommands": [], "enforcementMode": "audit"}`
+	if err := json.Unmarshal([]byte(data), &cfg); err == nil {
+		t.Error("Unmarshal() with an unknown enforcementMode should fail")
+	}
+}
+
+func TestUnmarshalMaxOutputFileSize(t *testing.T) {
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(`{"allowedDirectories": ["/tmp"], "allowCommands": [], "denyCommands": []}`), &cfg); err != nil {
+		t.Fatalf("Failed to unmarshal config: %v", err)
+	}
+	if got := cfg.OutputFileSize(); got != DefaultMaxOutputFileSize {
+		t.Errorf("OutputFileSize() = %d, want %d", got, DefaultMaxOutputFileSize)
+	}
+
+	if err := json.Unmarshal([]byte(`{"allowedDirectories": ["/tmp"], "allowCommands": [], "denyCommands": [], "maxOutputFileSize": 1024}`), &cfg); err != nil {
+		t.Fatalf("Failed to unmarshal config: %v", err)
+	}
+	if got := cfg.OutputFileSize(); got != 1024 {
+		t.Errorf("OutputFileSize() = %d, want 1024", got)
+	}
+
+	if err := json.Unmarshal([]byte(`{"allowedDirectories": ["/tmp"], "allowCommands": [], "denyCommands": [], "maxOutputFileSize": -1}`), &cfg); err == nil {
+		t.Error("expected an error for a negative maxOutputFileSize")
+	}
+}
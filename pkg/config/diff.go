@@ -0,0 +1,445 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// PolicyChange is a single way in which a policy permits more than the policy it replaces.
+type PolicyChange struct {
+	// Field is the JSON path of the setting in the policy it was found in: the new policy for
+	// additions and the old one for removals, e.g. "allowCommands[3].subCommands[0]".
+	Field   string
+	Message string
+}
+
+// String formats the change as "field: message".
+func (c PolicyChange) String() string {
+	return fmt.Sprintf("%s: %s", c.Field, c.Message)
+}
+
+// PolicyDiff lists how a new policy is looser than an old one, for reviewing changes to a
+// policy. Changes that make the policy stricter are not reported.
+type PolicyDiff struct {
+	// AllowedCommands are commands, subcommands, categories, builtins, and templates the new
+	// policy allows and the old one did not.
+	AllowedCommands []PolicyChange
+	// RemovedDenials are deny rules that were removed and safeguards that were turned off, such
+	// as approvals, denyNestedCommands, and enforcing mode.
+	RemovedDenials []PolicyChange
+	// WidenedDirectories are directories that became allowed, writable, or a source of
+	// executables.
+	WidenedDirectories []PolicyChange
+	// LoosenedLimits are limits that were raised or removed, and sandboxes that were turned off
+	// or that commands were exempted from.
+	LoosenedLimits []PolicyChange
+}
+
+// Empty reports whether the new policy permits nothing the old one did not.
+func (d PolicyDiff) Empty() bool {
+	return len(d.AllowedCommands) == 0 && len(d.RemovedDenials) == 0 &&
+		len(d.WidenedDirectories) == 0 && len(d.LoosenedLimits) == 0
+}
+
+// Diff reports how the policy newCfg is looser than oldCfg. The policies of the callers in the
+// users sections (see ShellCommandConfig.ForUser) are compared as well, and their changes are
+// reported under "users.<name>" unless the base policy already has them.
+func Diff(oldCfg, newCfg *ShellCommandConfig) PolicyDiff {
+	d := &policyDiffer{}
+	d.diffPolicy(oldCfg, newCfg)
+	base := d.diff
+
+	users := slices.Sorted(maps.Keys(newCfg.Users))
+	for _, user := range slices.Sorted(maps.Keys(oldCfg.Users)) {
+		if !slices.Contains(users, user) {
+			users = append(users, user)
+		}
+	}
+	for _, user := range users {
+		ud := &policyDiffer{}
+		ud.diffPolicy(oldCfg.ForUser(user), newCfg.ForUser(user))
+		prefix := "users." + user + ": "
+		base.AllowedCommands = appendUserChanges(base.AllowedCommands, d.diff.AllowedCommands, ud.diff.AllowedCommands, prefix)
+		base.RemovedDenials = appendUserChanges(base.RemovedDenials, d.diff.RemovedDenials, ud.diff.RemovedDenials, prefix)
+		base.WidenedDirectories = appendUserChanges(base.WidenedDirectories, d.diff.WidenedDirectories, ud.diff.WidenedDirectories, prefix)
+		base.LoosenedLimits = appendUserChanges(base.LoosenedLimits, d.diff.LoosenedLimits, ud.diff.LoosenedLimits, prefix)
+	}
+	return base
+}
+
+// appendUserChanges appends the changes of a user's policy that are not among the changes of
+// the base policy, with their fields prefixed by the user.
+func appendUserChanges(dst, base, user []PolicyChange, prefix string) []PolicyChange {
+	for _, change := range user {
+		if slices.ContainsFunc(base, func(c PolicyChange) bool { return c.Message == change.Message }) {
+			continue
+		}
+		change.Field = prefix + change.Field
+		dst = append(dst, change)
+	}
+	return dst
+}
+
+// policyDiffer accumulates the changes found while comparing two policies.
+type policyDiffer struct {
+	diff PolicyDiff
+}
+
+func (d *policyDiffer) allowed(field, format string, args ...any) {
+	d.diff.AllowedCommands = append(d.diff.AllowedCommands, PolicyChange{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (d *policyDiffer) undenied(field, format string, args ...any) {
+	d.diff.RemovedDenials = append(d.diff.RemovedDenials, PolicyChange{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (d *policyDiffer) widened(field, format string, args ...any) {
+	d.diff.WidenedDirectories = append(d.diff.WidenedDirectories, PolicyChange{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (d *policyDiffer) loosened(field, format string, args ...any) {
+	d.diff.LoosenedLimits = append(d.diff.LoosenedLimits, PolicyChange{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// diffPolicy compares every part of two policies except their users and roles sections.
+func (d *policyDiffer) diffPolicy(oldCfg, newCfg *ShellCommandConfig) {
+	d.diffAllowCommands(oldCfg, newCfg)
+	d.diffDenials(oldCfg, newCfg)
+	d.diffSafeguards(oldCfg, newCfg)
+	d.diffDirectories(oldCfg, newCfg)
+	d.diffLimits(oldCfg, newCfg)
+	d.diffSandbox(oldCfg, newCfg)
+}
+
+// diffAllowCommands reports commands and rules that allow more than before.
+func (d *policyDiffer) diffAllowCommands(oldCfg, newCfg *ShellCommandConfig) {
+	for i, rule := range newCfg.AllowCommands {
+		field := fmt.Sprintf("allowCommands[%d]", i)
+		j := slices.IndexFunc(oldCfg.AllowCommands, func(a AllowCommand) bool { return a.Command == rule.Command })
+		if j < 0 {
+			d.allowed(field, "%s is allowed", describeAllowCommand(rule))
+			continue
+		}
+		d.diffAllowCommand(field, oldCfg.AllowCommands[j], rule)
+	}
+
+	for _, name := range added(oldCfg.AllowCategories, newCfg.AllowCategories) {
+		d.allowed("allowCategories", "commands of category %s are allowed", name)
+	}
+	for _, name := range added(oldCfg.Builtins.Allow, newCfg.Builtins.Allow) {
+		d.allowed("builtins.allow", "builtin %s is allowed", name)
+	}
+	for _, name := range slices.Sorted(maps.Keys(newCfg.Templates)) {
+		if _, ok := oldCfg.Templates[name]; !ok {
+			d.allowed("templates."+name, "template %s is allowed: %s", name, newCfg.Templates[name])
+		} else if oldCfg.Templates[name] != newCfg.Templates[name] {
+			d.allowed("templates."+name, "template %s changed from %s to %s", name, oldCfg.Templates[name], newCfg.Templates[name])
+		}
+	}
+
+	switch {
+	case newCfg.Git.Enabled && !oldCfg.Git.Enabled:
+		d.allowed("git.enabled", "git is allowed by the built-in git rules")
+	case newCfg.Git.Enabled:
+		for _, sub := range added(oldCfg.Git.AllowSubCommands, newCfg.Git.AllowSubCommands) {
+			d.allowed("git.allowSubCommands", "git %s is allowed", sub)
+		}
+	}
+	if newCfg.Git.Enabled && oldCfg.Git.Enabled && len(oldCfg.Git.AllowedRemotes) > 0 {
+		if len(newCfg.Git.AllowedRemotes) == 0 {
+			d.allowed("git.allowedRemotes", "git may use any remote")
+		}
+		for _, remote := range added(oldCfg.Git.AllowedRemotes, newCfg.Git.AllowedRemotes) {
+			d.allowed("git.allowedRemotes", "git may use remotes matching %s", remote)
+		}
+	}
+}
+
+// describeAllowCommand names a rule and the subcommands it is limited to.
+func describeAllowCommand(rule AllowCommand) string {
+	if len(rule.SubCommands) == 0 {
+		return rule.Command
+	}
+	names := make([]string, len(rule.SubCommands))
+	for i, sub := range rule.SubCommands {
+		names[i] = sub.Name
+	}
+	return fmt.Sprintf("%s (subcommands %s)", rule.Command, strings.Join(names, ", "))
+}
+
+// diffAllowCommand reports how the rule of a command allowed by both policies became looser.
+func (d *policyDiffer) diffAllowCommand(field string, oldRule, newRule AllowCommand) {
+	name := newRule.Command
+	d.diffSubCommands(field, name, oldRule.SubCommands, newRule.SubCommands)
+	for _, sub := range removed(oldRule.DenySubCommands, newRule.DenySubCommands) {
+		d.undenied(field+".denySubCommands", "%s %s is no longer denied", name, sub)
+	}
+
+	if oldRule.ApprovalRequired && !newRule.ApprovalRequired {
+		d.undenied(field+".approvalRequired", "%s no longer requires approval", name)
+	}
+	if oldRule.Confirm && !newRule.Confirm {
+		d.undenied(field+".confirm", "%s is no longer confirmed before it runs", name)
+	}
+	if oldRule.LiteralArgs && !newRule.LiteralArgs {
+		d.undenied(field+".literalArgs", "%s may take arguments from expansions", name)
+	}
+	if !oldRule.ReadOnly && newRule.ReadOnly {
+		d.undenied(field+".readOnly", "%s is marked readOnly and runs when readOnlyOnly is set", name)
+	}
+	if !oldRule.AllowNetwork && newRule.AllowNetwork {
+		d.loosened(field+".allowNetwork", "%s may reach the network", name)
+	}
+	if !oldRule.AllowSetuid && newRule.AllowSetuid {
+		d.loosened(field+".allowSetuid", "%s may be a setuid program and keeps the capabilities of the server", name)
+	}
+	if oldRule.SeccompProfile != SeccompProfileUnconfined && newRule.SeccompProfile == SeccompProfileUnconfined {
+		d.loosened(field+".seccompProfile", "%s runs without a seccomp filter", name)
+	}
+}
+
+// diffSubCommands reports subcommands of command that became allowed, at any depth.
+func (d *policyDiffer) diffSubCommands(field, command string, oldSubs, newSubs []SubCommandRule) {
+	if len(oldSubs) > 0 && len(newSubs) == 0 {
+		d.allowed(field+".subCommands", "every subcommand of %s is allowed", command)
+		return
+	}
+	if len(oldSubs) == 0 {
+		// Every subcommand was already allowed
+		return
+	}
+	for i, sub := range newSubs {
+		subField := fmt.Sprintf("%s.subCommands[%d]", field, i)
+		j := slices.IndexFunc(oldSubs, func(s SubCommandRule) bool { return s.Name == sub.Name })
+		if j < 0 {
+			d.allowed(subField, "%s %s is allowed", command, sub.Name)
+			continue
+		}
+		oldSub := oldSubs[j]
+		for _, flag := range removed(oldSub.DenyFlags, sub.DenyFlags) {
+			d.undenied(subField+".denyFlags", "%s %s %s is no longer denied", command, sub.Name, flag)
+		}
+		for _, name := range removed(oldSub.DenySubCommands, sub.DenySubCommands) {
+			d.undenied(subField+".denySubCommands", "%s %s %s is no longer denied", command, sub.Name, name)
+		}
+		d.diffSubCommands(subField, command+" "+sub.Name, oldSub.SubCommands, sub.SubCommands)
+	}
+}
+
+// diffDenials reports deny rules and denied categories and builtins that were removed.
+func (d *policyDiffer) diffDenials(oldCfg, newCfg *ShellCommandConfig) {
+	for i, rule := range oldCfg.DenyCommands {
+		if slices.ContainsFunc(newCfg.DenyCommands, func(r DenyCommand) bool {
+			return r.Command == rule.Command && slices.Equal(r.Args, rule.Args)
+		}) {
+			continue
+		}
+		field := fmt.Sprintf("denyCommands[%d]", i)
+		if len(rule.Args) == 0 {
+			d.undenied(field, "%s is no longer denied", rule.Command)
+		} else {
+			d.undenied(field, "%s with arguments containing %s is no longer denied", rule.Command, strings.Join(rule.Args, ", "))
+		}
+	}
+	for _, name := range removed(oldCfg.DenyCategories, newCfg.DenyCategories) {
+		d.undenied("denyCategories", "commands of category %s are no longer denied", name)
+	}
+	for _, name := range removed(oldCfg.Builtins.Deny, newCfg.Builtins.Deny) {
+		d.undenied("builtins.deny", "builtin %s is no longer denied", name)
+	}
+}
+
+// diffSafeguards reports checks of scripts that were turned off or relaxed.
+func (d *policyDiffer) diffSafeguards(oldCfg, newCfg *ShellCommandConfig) {
+	if oldCfg.EnforcementMode != EnforcementPermissive && newCfg.EnforcementMode == EnforcementPermissive {
+		d.undenied("enforcementMode", "denied commands run in permissive mode")
+	}
+	if oldCfg.ReadOnlyOnly && !newCfg.ReadOnlyOnly {
+		d.undenied("readOnlyOnly", "commands not marked readOnly and writing redirections are allowed")
+	}
+	if oldCfg.DenyNestedCommands && !newCfg.DenyNestedCommands {
+		d.undenied("denyNestedCommands", "nested commands such as sh -c are validated instead of denied")
+	}
+	if oldCfg.DenyDynamicCommands && !newCfg.DenyDynamicCommands {
+		d.undenied("denyDynamicCommands", "command names from expansions are no longer denied")
+	}
+	if !oldCfg.AllowBackground && newCfg.AllowBackground {
+		d.undenied("allowBackground", "background commands are allowed")
+	}
+	if !oldCfg.AllowProcessSubstitution && newCfg.AllowProcessSubstitution {
+		d.undenied("allowProcessSubstitution", "process substitutions are allowed")
+	}
+	if interpreterInputRank(newCfg.InterpreterInput) > interpreterInputRank(oldCfg.InterpreterInput) {
+		d.undenied("interpreterInput", "programs read by interpreters from standard input are handled with %s instead of %s",
+			interpreterInputOrDefault(newCfg.InterpreterInput), interpreterInputOrDefault(oldCfg.InterpreterInput))
+	}
+	switch {
+	case oldCfg.Obfuscation.Action == ObfuscationActionDeny && newCfg.Obfuscation.Action != ObfuscationActionDeny:
+		d.undenied("obfuscation.action", "obfuscated code is no longer denied")
+	case oldCfg.Obfuscation.Action != "" && newCfg.Obfuscation.Action == "":
+		d.undenied("obfuscation.action", "obfuscated code is no longer detected")
+	}
+	if oldCfg.Obfuscation.Action != "" && newCfg.Obfuscation.Action != "" {
+		for _, pattern := range ObfuscationPatterns {
+			if oldCfg.Obfuscation.Detects(pattern) && !newCfg.Obfuscation.Detects(pattern) {
+				d.undenied("obfuscation.patterns", "obfuscation pattern %s is no longer detected", pattern)
+			}
+		}
+		for _, name := range added(oldCfg.Obfuscation.AllowInline, newCfg.Obfuscation.AllowInline) {
+			d.undenied("obfuscation.allowInline", "inline programs of %s are no longer detected", name)
+		}
+	}
+	if oldCfg.OPA.Enabled() && !newCfg.OPA.Enabled() {
+		d.undenied("opa", "commands are no longer checked with OPA")
+	}
+	if oldCfg.OPA.Enabled() && newCfg.OPA.Enabled() && !oldCfg.OPA.FailOpen && newCfg.OPA.FailOpen {
+		d.undenied("opa.failOpen", "commands run when OPA cannot decide")
+	}
+}
+
+// interpreterInputRank orders the InterpreterInput policies from the strictest.
+func interpreterInputRank(policy string) int {
+	switch interpreterInputOrDefault(policy) {
+	case InterpreterInputDeny:
+		return 0
+	case InterpreterInputScan:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// interpreterInputOrDefault returns policy, or the default InterpreterInputScan if it is empty.
+func interpreterInputOrDefault(policy string) string {
+	if policy == "" {
+		return InterpreterInputScan
+	}
+	return policy
+}
+
+// diffDirectories reports directories that became allowed, writable, or sources of executables.
+func (d *policyDiffer) diffDirectories(oldCfg, newCfg *ShellCommandConfig) {
+	for _, dir := range added(oldCfg.AllowedDirectories, newCfg.AllowedDirectories) {
+		d.widened("allowedDirectories", "%s is allowed", dir)
+	}
+	for _, dir := range removed(oldCfg.ReadOnlyDirectories, newCfg.ReadOnlyDirectories) {
+		d.widened("readOnlyDirectories", "%s is writable", dir)
+	}
+	if len(oldCfg.AllowedBinDirs) > 0 {
+		if len(newCfg.AllowedBinDirs) == 0 {
+			d.widened("allowedBinDirs", "executables are run from any directory on PATH")
+		}
+		for _, dir := range added(oldCfg.AllowedBinDirs, newCfg.AllowedBinDirs) {
+			d.widened("allowedBinDirs", "executables are run from %s", dir)
+		}
+	}
+	for _, device := range added(writableDevices(oldCfg), writableDevices(newCfg)) {
+		d.widened("writableDevices", "device %s is writable", device)
+	}
+	if oldCfg.Landlock.Enabled && newCfg.Landlock.Enabled {
+		for _, dir := range added(oldCfg.Landlock.ReadOnlyPaths, newCfg.Landlock.ReadOnlyPaths) {
+			d.widened("landlock.readOnlyPaths", "%s is readable in the Landlock sandbox", dir)
+		}
+	}
+}
+
+// writableDevices returns the devices redirections may write to under cfg.
+func writableDevices(cfg *ShellCommandConfig) []string {
+	if cfg.WritableDevices == nil {
+		return DefaultWritableDevices
+	}
+	return cfg.WritableDevices
+}
+
+// diffLimits reports limits that were raised or removed.
+func (d *policyDiffer) diffLimits(oldCfg, newCfg *ShellCommandConfig) {
+	diffLimit(d, "maxExecutionTime", oldCfg.MaxExecutionTime, newCfg.MaxExecutionTime)
+	diffLimit(d, "idleTimeout", oldCfg.IdleTimeout, newCfg.IdleTimeout)
+	diffLimit(d, "maxOutputSize", oldCfg.MaxOutputSize, newCfg.MaxOutputSize)
+	diffLimit(d, "maxOutputFileSize", oldCfg.OutputFileSize(), newCfg.OutputFileSize())
+
+	diffLimit(d, "rateLimit.commandsPerMinute", oldCfg.RateLimit.CommandsPerMinute, newCfg.RateLimit.CommandsPerMinute)
+	diffLimit(d, "rateLimit.burst", oldCfg.RateLimit.Burst, newCfg.RateLimit.Burst)
+	diffLimit(d, "rateLimit.maxConcurrent", oldCfg.RateLimit.MaxConcurrent, newCfg.RateLimit.MaxConcurrent)
+	diffLimit(d, "quota.maxCpuSeconds", oldCfg.Quota.MaxCPUSeconds, newCfg.Quota.MaxCPUSeconds)
+	diffLimit(d, "quota.maxOutputBytes", oldCfg.Quota.MaxOutputBytes, newCfg.Quota.MaxOutputBytes)
+	diffLimit(d, "quota.maxExecutions", oldCfg.Quota.MaxExecutions, newCfg.Quota.MaxExecutions)
+	diffLimit(d, "sessions.maxConcurrent", oldCfg.Sessions.MaxConcurrent, newCfg.Sessions.MaxConcurrent)
+	diffLimit(d, "sessions.maxCommands", oldCfg.Sessions.MaxCommands, newCfg.Sessions.MaxCommands)
+	diffLimit(d, "sessions.maxLifetime", oldCfg.Sessions.MaxLifetime, newCfg.Sessions.MaxLifetime)
+
+	oldScript, newScript := oldCfg.ScriptLimits, newCfg.ScriptLimits
+	diffLimit(d, "scriptLimits.maxSize", oldScript.MaxSize, newScript.MaxSize)
+	diffLimit(d, "scriptLimits.maxNodes", oldScript.MaxNodes, newScript.MaxNodes)
+	diffLimit(d, "scriptLimits.maxDepth", oldScript.MaxDepth, newScript.MaxDepth)
+	diffLimit(d, "scriptLimits.maxLoops", oldScript.MaxLoops, newScript.MaxLoops)
+	diffLimit(d, "scriptLimits.maxCommands", oldScript.MaxCommands, newScript.MaxCommands)
+	diffLimit(d, "scriptLimits.maxPipeline", oldScript.MaxPipeline, newScript.MaxPipeline)
+	diffLimit(d, "scriptLimits.maxChain", oldScript.MaxChain, newScript.MaxChain)
+	diffLimit(d, "scriptLimits.maxSourceDepth", oldScript.SourceDepth(), newScript.SourceDepth())
+
+	if oldCfg.Cgroup.Enabled && newCfg.Cgroup.Enabled {
+		diffLimit(d, "cgroup.memoryMax", oldCfg.Cgroup.MemoryMax, newCfg.Cgroup.MemoryMax)
+		diffLimit(d, "cgroup.cpuMax", oldCfg.Cgroup.CPUMax, newCfg.Cgroup.CPUMax)
+		diffLimit(d, "cgroup.pidsMax", oldCfg.Cgroup.PidsMax, newCfg.Cgroup.PidsMax)
+	}
+	diffLimit(d, "risk.threshold", oldCfg.Risk.Threshold, newCfg.Risk.Threshold)
+}
+
+// diffLimit reports a limit that was raised or removed. Zero means no limit.
+func diffLimit[T int | int64 | float64](d *policyDiffer, field string, oldLimit, newLimit T) {
+	switch {
+	case oldLimit == 0 || newLimit == oldLimit:
+	case newLimit == 0:
+		d.loosened(field, "limit of %v removed", oldLimit)
+	case newLimit > oldLimit:
+		d.loosened(field, "raised from %v to %v", oldLimit, newLimit)
+	}
+}
+
+// diffSandbox reports sandboxes that were turned off or that protect less.
+func (d *policyDiffer) diffSandbox(oldCfg, newCfg *ShellCommandConfig) {
+	if oldCfg.Landlock.Enabled && !newCfg.Landlock.Enabled {
+		d.loosened("landlock.enabled", "commands are no longer confined with Landlock")
+	}
+	if oldCfg.Seccomp.Enabled && !newCfg.Seccomp.Enabled {
+		d.loosened("seccomp.enabled", "commands no longer run with a seccomp filter")
+	}
+	if oldCfg.Seccomp.Enabled && newCfg.Seccomp.Enabled {
+		oldSyscalls, _ := oldCfg.Seccomp.DeniedSyscalls(SeccompProfileDefault)
+		newSyscalls, _ := newCfg.Seccomp.DeniedSyscalls(SeccompProfileDefault)
+		for _, syscall := range removed(oldSyscalls, newSyscalls) {
+			d.loosened("seccomp.denySyscalls", "system call %s is no longer denied", syscall)
+		}
+	}
+	if oldCfg.DisableNetwork && !newCfg.DisableNetwork {
+		d.loosened("disableNetwork", "every command may reach the network")
+	}
+	if oldCfg.EgressProxy.URL != "" && newCfg.EgressProxy.URL == "" {
+		d.loosened("egressProxy.url", "network traffic no longer goes through the egress proxy")
+	}
+	for _, name := range added(oldCfg.Privileges.KeepCapabilities, newCfg.Privileges.KeepCapabilities) {
+		d.loosened("privileges.keepCapabilities", "commands keep capability %s", name)
+	}
+	if oldCfg.Cgroup.Enabled && !newCfg.Cgroup.Enabled {
+		d.loosened("cgroup.enabled", "executions no longer run in a cgroup with resource limits")
+	}
+}
+
+// added returns the values of newValues that are not in oldValues.
+func added(oldValues, newValues []string) []string {
+	var result []string
+	for _, value := range newValues {
+		if !slices.Contains(oldValues, value) {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// removed returns the values of oldValues that are not in newValues.
+func removed(oldValues, newValues []string) []string {
+	return added(newValues, oldValues)
+}
@@ -0,0 +1,124 @@
+package config
+
+import (
+	"slices"
+	"testing"
+)
+
+// changeStrings formats changes for comparison.
+func changeStrings(changes []PolicyChange) []string {
+	result := make([]string, len(changes))
+	for i, c := range changes {
+		result[i] = c.String()
+	}
+	return result
+}
+
+func TestDiff(t *testing.T) {
+	oldCfg := &ShellCommandConfig{
+		AllowedDirectories:  []string{"/workspace"},
+		ReadOnlyDirectories: []string{"/workspace/vendor", "/workspace/.git"},
+		AllowCommands: []AllowCommand{
+			{Command: "ls"},
+			{Command: "git", SubCommands: []SubCommandRule{{Name: "status"}, {Name: "push", DenyFlags: []string{"--force"}}}},
+			{Command: "kubectl", ApprovalRequired: true},
+		},
+		DenyCommands:       []DenyCommand{{Command: "sudo"}, {Command: "rm", Args: []string{"-rf"}}},
+		DenyCategories:     []string{"network"},
+		DenyNestedCommands: true,
+		MaxExecutionTime:   60,
+		MaxOutputSize:      1024,
+		RateLimit:          RateLimitConfig{CommandsPerMinute: 30},
+		Landlock:           LandlockConfig{Enabled: true},
+	}
+	newCfg := &ShellCommandConfig{
+		AllowedDirectories:  []string{"/workspace", "/data"},
+		ReadOnlyDirectories: []string{"/workspace/.git"},
+		AllowCommands: []AllowCommand{
+			{Command: "ls"},
+			{Command: "git", SubCommands: []SubCommandRule{{Name: "status"}, {Name: "push"}, {Name: "commit"}}},
+			{Command: "kubectl", AllowNetwork: true},
+			{Command: "docker"},
+		},
+		DenyCommands:     []DenyCommand{{Command: "rm", Args: []string{"-rf"}}},
+		MaxExecutionTime: 300,
+		MaxOutputSize:    1024,
+		Landlock:         LandlockConfig{Enabled: false},
+	}
+	unrestricted := &ShellCommandConfig{AllowCommands: []AllowCommand{{Command: "git"}}}
+
+	diff := Diff(oldCfg, newCfg)
+	tests := []struct {
+		name    string
+		changes []PolicyChange
+		want    []string
+	}{
+		{"AllowedCommands", diff.AllowedCommands, []string{
+			"allowCommands[1].subCommands[2]: git commit is allowed",
+			"allowCommands[3]: docker is allowed",
+		}},
+		{"RemovedDenials", diff.RemovedDenials, []string{
+			"allowCommands[1].subCommands[1].denyFlags: git push --force is no longer denied",
+			"allowCommands[2].approvalRequired: kubectl no longer requires approval",
+			"denyCommands[0]: sudo is no longer denied",
+			"denyCategories: commands of category network are no longer denied",
+			"denyNestedCommands: nested commands such as sh -c are validated instead of denied",
+		}},
+		{"WidenedDirectories", diff.WidenedDirectories, []string{
+			"allowedDirectories: /data is allowed",
+			"readOnlyDirectories: /workspace/vendor is writable",
+		}},
+		{"LoosenedLimits", diff.LoosenedLimits, []string{
+			"allowCommands[2].allowNetwork: kubectl may reach the network",
+			"maxExecutionTime: raised from 60 to 300",
+			"rateLimit.commandsPerMinute: limit of 30 removed",
+			"landlock.enabled: commands are no longer confined with Landlock",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := changeStrings(tt.changes); !slices.Equal(got, tt.want) {
+				t.Errorf("changes =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+
+	// Nothing is reported the other way round, which only tightens the policy
+	if reverse := Diff(newCfg, oldCfg); !reverse.Empty() {
+		t.Errorf("Diff(new, old) = %+v", reverse)
+	}
+	want := []string{"allowCommands[0].subCommands: every subcommand of git is allowed"}
+	if got := changeStrings(Diff(oldCfg, unrestricted).AllowedCommands); !slices.Equal(got, want) {
+		t.Errorf("AllowedCommands = %q, want %q", got, want)
+	}
+	if !Diff(oldCfg, oldCfg).Empty() {
+		t.Error("Diff of a policy with itself should be empty")
+	}
+}
+
+func TestDiffUsers(t *testing.T) {
+	oldCfg := &ShellCommandConfig{
+		AllowedDirectories: []string{"/workspace"},
+		AllowCommands:      []AllowCommand{{Command: "ls"}},
+		Users: map[string]PolicyOverlay{
+			"alice": {AllowCommands: []AllowCommand{{Command: "make"}}},
+		},
+	}
+	newCfg := oldCfg.Clone()
+	newCfg.AllowCommands = append(newCfg.AllowCommands, AllowCommand{Command: "cat"})
+	newCfg.Users["alice"] = PolicyOverlay{AllowCommands: []AllowCommand{{Command: "make"}, {Command: "go"}}}
+	newCfg.Users["bob"] = PolicyOverlay{AllowedDirectories: []string{"/srv"}}
+
+	diff := Diff(oldCfg, newCfg)
+	want := []string{
+		"allowCommands[1]: cat is allowed",
+		"users.alice: allowCommands[3]: go is allowed",
+	}
+	if got := changeStrings(diff.AllowedCommands); !slices.Equal(got, want) {
+		t.Errorf("AllowedCommands = %q, want %q", got, want)
+	}
+	want = []string{"users.bob: allowedDirectories: /srv is allowed"}
+	if got := changeStrings(diff.WidenedDirectories); !slices.Equal(got, want) {
+		t.Errorf("WidenedDirectories = %q, want %q", got, want)
+	}
+}
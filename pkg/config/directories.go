@@ -0,0 +1,114 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// homePrefixes are the prefixes of allowed directories replaced by the home directory.
+var homePrefixes = []string{"~", "$HOME", "${HOME}"}
+
+// expandDirectories returns dirs with a leading ~, $HOME, or ${HOME} replaced by the home
+// directory of the user running the server. It rejects malformed glob patterns.
+func expandDirectories(dirs []string) ([]string, error) {
+	expanded := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		dir, err := expandHome(dir)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := filepath.Match(dir, ""); err != nil {
+			return nil, fmt.Errorf("invalid allowed directory pattern %q: %w", dir, err)
+		}
+		expanded = append(expanded, dir)
+	}
+	return expanded, nil
+}
+
+// expandHome replaces a leading ~, $HOME, or ${HOME} in dir by the home directory.
+func expandHome(dir string) (string, error) {
+	for _, prefix := range homePrefixes {
+		rest, ok := strings.CutPrefix(dir, prefix)
+		if !ok || (rest != "" && rest[0] != '/' && rest[0] != filepath.Separator) {
+			continue
+		}
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("cannot expand allowed directory %q: %w", dir, err)
+		}
+		return home + rest, nil
+	}
+	if strings.HasPrefix(dir, "~") {
+		return "", fmt.Errorf("cannot expand allowed directory %q: only the home directory of the server's user (~) is supported", dir)
+	}
+	return dir, nil
+}
+
+// isGlob reports whether an allowed directory is a glob pattern.
+func isGlob(dir string) bool {
+	return strings.ContainsAny(dir, "*?[")
+}
+
+// Directories returns the allowed directories, with each glob pattern replaced by the
+// directories that match it now, in order. Patterns are matched on every call, so that
+// directories created later are allowed without a reload.
+func (c *ShellCommandConfig) Directories() []string {
+	dirs := make([]string, 0, len(c.AllowedDirectories))
+	for _, dir := range c.AllowedDirectories {
+		if isGlob(dir) {
+			dirs = append(dirs, globDirectories(dir)...)
+		} else {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// globDirectories returns the directories matching pattern with symlinks resolved. A match
+// is only returned if its resolved path still matches the pattern, so that a symlink such as
+// /data/tenants/evil/workspace -> /etc does not allow its target.
+func globDirectories(pattern string) []string {
+	// The pattern was checked when the configuration was loaded
+	matches, _ := filepath.Glob(pattern)
+	resolvedPattern := resolvePatternPrefix(pattern)
+	var dirs []string
+	for _, match := range matches {
+		resolved, err := filepath.EvalSymlinks(match)
+		if err != nil {
+			continue
+		}
+		if ok, _ := filepath.Match(resolvedPattern, resolved); !ok {
+			continue
+		}
+		if info, err := os.Stat(resolved); err == nil && info.IsDir() {
+			dirs = append(dirs, resolved)
+		}
+	}
+	return dirs
+}
+
+// resolvePatternPrefix resolves symlinks in the directories of pattern before its first
+// component with glob characters.
+func resolvePatternPrefix(pattern string) string {
+	prefix, rest := pattern, ""
+	for isGlob(prefix) {
+		rest = filepath.Join(filepath.Base(prefix), rest)
+		prefix = filepath.Dir(prefix)
+	}
+	resolved, err := filepath.EvalSymlinks(prefix)
+	if err != nil {
+		return pattern
+	}
+	return filepath.Join(resolved, rest)
+}
+
+// DefaultDirectory returns the first allowed directory, in which commands run when the
+// caller names none, or "" if there is none.
+func (c *ShellCommandConfig) DefaultDirectory() string {
+	if dirs := c.Directories(); len(dirs) > 0 {
+		return dirs[0]
+	}
+	return ""
+}
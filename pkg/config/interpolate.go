@@ -0,0 +1,189 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// interpolatedFields are the string fields, by dotted path, in which ${VAR} and
+// ${VAR:-fallback} are replaced when the configuration is decoded. "*" stands for any key of a
+// map, and the strings of a list are replaced one by one.
+var interpolatedFields = []string{
+	"allowedDirectories",
+	"readOnlyDirectories",
+	"allowedBinDirs",
+	"blockLogPath",
+	"historyPath",
+	"recordingDir",
+	"scratch.dir",
+	"homeIsolation.dir",
+	"outputSpool.dir",
+	"snapshot.dir",
+	"trash.dir",
+	"landlock.readOnlyPaths",
+	"opa.policies",
+	"opa.bundle",
+	"users.*.allowedDirectories",
+	"roles.*.allowedDirectories",
+}
+
+// homeVariable may always be interpolated and is the home directory of the server's user, as a
+// leading ~ or $HOME of an allowed directory is.
+const homeVariable = "HOME"
+
+// checkInterpolateEnv rejects names in interpolateEnv that are not variable names.
+func checkInterpolateEnv(names []string) error {
+	for _, name := range names {
+		if !syntax.ValidName(name) {
+			return fmt.Errorf("invalid interpolateEnv variable name %q", name)
+		}
+	}
+	return nil
+}
+
+// interpolateFields returns the configuration data with the variables in interpolatedFields
+// replaced. Only the variables listed in its interpolateEnv and HOME may be used.
+func interpolateFields(data []byte) ([]byte, error) {
+	if !bytes.Contains(data, []byte("${")) {
+		return data, nil
+	}
+	var doc map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// Numbers are kept as they are written
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		// The decoder of the configuration reports the error
+		return data, nil //nolint:nilerr // reported when the data is decoded again
+	}
+
+	var allowed []string
+	if names, ok := doc["interpolateEnv"].([]any); ok {
+		for _, name := range names {
+			if s, ok := name.(string); ok {
+				allowed = append(allowed, s)
+			}
+		}
+	}
+
+	changed := false
+	for _, field := range interpolatedFields {
+		err := interpolatePath(doc, strings.Split(field, "."), field, func(s string) (string, error) {
+			replaced, err := interpolate(s, allowed)
+			changed = changed || replaced != s
+			return replaced, err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !changed {
+		return data, nil
+	}
+	return json.Marshal(doc)
+}
+
+// interpolatePath applies replace to the strings at path below node. field is the dotted path
+// of the whole field, for error messages.
+func interpolatePath(node any, path []string, field string, replace func(string) (string, error)) error {
+	obj, ok := node.(map[string]any)
+	if !ok {
+		return nil
+	}
+	keys := []string{path[0]}
+	if path[0] == "*" {
+		keys = make([]string, 0, len(obj))
+		for key := range obj {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+	}
+	for _, key := range keys {
+		value, ok := obj[key]
+		if !ok {
+			continue
+		}
+		if len(path) > 1 {
+			if err := interpolatePath(value, path[1:], field, replace); err != nil {
+				return err
+			}
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			s, err := replace(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", field, err)
+			}
+			obj[key] = s
+		case []any:
+			for i, elem := range v {
+				if s, ok := elem.(string); ok {
+					replaced, err := replace(s)
+					if err != nil {
+						return fmt.Errorf("%s[%d]: %w", field, i, err)
+					}
+					v[i] = replaced
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// interpolate replaces every ${VAR} in s by the value of the environment variable VAR, and
+// every ${VAR:-fallback} by the value or, if VAR is unset or empty, by fallback. VAR must be
+// HOME or listed in allowed. A variable that is unset or empty without a fallback is an error,
+// so that a path never silently loses its root.
+func interpolate(s string, allowed []string) (string, error) {
+	var b strings.Builder
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated ${ in %q", s)
+		}
+		expr := s[start+2 : start+end]
+		name, fallback, hasFallback := strings.Cut(expr, ":-")
+		if !syntax.ValidName(name) {
+			return "", fmt.Errorf("invalid variable ${%s} in %q", expr, s)
+		}
+		value, err := lookupInterpolated(name, allowed)
+		if err != nil {
+			return "", err
+		}
+		if value == "" {
+			if !hasFallback {
+				return "", fmt.Errorf("variable %s is not set and ${%s} has no fallback", name, name)
+			}
+			value = fallback
+		}
+		b.WriteString(s[:start])
+		b.WriteString(value)
+		s = s[start+end+1:]
+	}
+}
+
+// lookupInterpolated returns the value of the variable name, which must be HOME or in allowed.
+func lookupInterpolated(name string, allowed []string) (string, error) {
+	if name == homeVariable {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", nil //nolint:nilerr // an unknown home directory is an unset variable
+		}
+		return home, nil
+	}
+	if !slices.Contains(allowed, name) {
+		return "", fmt.Errorf("variable %s may not be interpolated: it is not listed in interpolateEnv", name)
+	}
+	return os.Getenv(name), nil
+}
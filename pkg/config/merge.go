@@ -0,0 +1,282 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// MergeKey is the top-level key through which a layer chooses how its allow lists are merged.
+// It maps a list name (see MergeAppend) to MergeAppend or MergeReplace and is removed from the result.
+const MergeKey = "merge"
+
+// Merge modes for allow lists.
+const (
+	// MergeAppend adds a layer's entries to the list, replacing entries for the same command.
+	MergeAppend = "append"
+	// MergeReplace replaces the whole list with the layer's entries.
+	MergeReplace = "replace"
+)
+
+// listKind describes how a list is merged across layers.
+type listKind int
+
+const (
+	// denyList is always the union of every layer, so a later layer cannot remove a restriction.
+	denyList listKind = iota
+	// allowList is appended to or replaced according to the layer's merge directive.
+	allowList
+)
+
+// mergedLists are the lists that are not simply replaced by later layers, by dotted path.
+var mergedLists = map[string]listKind{
+	"allowCommands":          allowList,
+	"allowedDirectories":     allowList,
+	"allowedBinDirs":         allowList,
+	"allowCategories":        allowList,
+	"builtins.allow":         allowList,
+	"git.allowSubCommands":   allowList,
+	"git.allowedRemotes":     allowList,
+	"interpolateEnv":         allowList,
+	"denyCommands":           denyList,
+	"denyCategories":         denyList,
+	"readOnlyDirectories":    denyList,
+	"builtins.deny":          denyList,
+	"redaction.patterns":     denyList,
+	"landlock.readOnlyPaths": denyList,
+}
+
+// FileList is a flag.Value collecting configuration files from a repeated or comma-separated flag.
+type FileList []string
+
+// String returns the files separated by commas.
+func (f *FileList) String() string {
+	return strings.Join(*f, ",")
+}
+
+// Set adds the comma-separated files in value.
+func (f *FileList) Set(value string) error {
+	for _, path := range strings.Split(value, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			*f = append(*f, path)
+		}
+	}
+	return nil
+}
+
+// LoadConfigFromFiles loads a configuration layered from several JSON files, each
+// overriding or extending the ones before it as described by MergeJSON. A path of the form
+// "preset:name" is an embedded preset, and a file naming a preset with ExtendsKey is layered
+// on it. Like Parse, it rejects fields the format does not define.
+func LoadConfigFromFiles(filePaths ...string) (*ShellCommandConfig, error) {
+	if len(filePaths) == 0 {
+		return nil, errors.New("no config file given")
+	}
+
+	var sources []string
+	var layers [][]byte
+	for _, filePath := range filePaths {
+		var data []byte
+		var err error
+		if strings.HasPrefix(filePath, PresetPrefix) {
+			filePath, data, err = presetData(filePath)
+		} else {
+			data, err = os.ReadFile(filePath)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		// Check each layer so that an unknown field is reported with the file it is in
+		if err := checkFields(data); err != nil {
+			return nil, fmt.Errorf("failed to decode config file %s: %w", filePath, err)
+		}
+		ref, base, err := baseLayer(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode config file %s: %w", filePath, err)
+		}
+		if base != nil {
+			sources, layers = append(sources, ref), append(layers, base)
+		}
+		sources, layers = append(sources, filePath), append(layers, data)
+	}
+
+	merged, err := MergeJSON(layers...)
+	if err != nil {
+		return nil, err
+	}
+
+	var config ShellCommandConfig
+	if err := json.Unmarshal(merged, &config); err != nil {
+		return nil, fmt.Errorf("failed to decode config file: %w", err)
+	}
+	setLayerSources(&config, sources, layers)
+
+	return &config, nil
+}
+
+// setLayerSources records the file of the layer that last set each allow and deny rule,
+// which is the layer the merged rule comes from.
+func setLayerSources(config *ShellCommandConfig, filePaths []string, layers [][]byte) {
+	sources := make(map[string]string)
+	for i, layer := range layers {
+		var lists struct {
+			AllowCommands []any `json:"allowCommands"`
+			DenyCommands  []any `json:"denyCommands"`
+		}
+		if err := json.Unmarshal(layer, &lists); err != nil {
+			continue
+		}
+		for _, entry := range lists.AllowCommands {
+			if key, ok := entryKey(entry); ok {
+				sources["allow\x00"+key] = filePaths[i]
+			}
+		}
+		for _, entry := range lists.DenyCommands {
+			if key, ok := entryKey(entry); ok {
+				sources["deny\x00"+key] = filePaths[i]
+			}
+		}
+	}
+	for i, rule := range config.AllowCommands {
+		config.AllowCommands[i].Source = sources["allow\x00"+rule.Command]
+	}
+	for i, rule := range config.DenyCommands {
+		key := rule.Command
+		for _, arg := range rule.Args {
+			key += "\x00" + arg
+		}
+		config.DenyCommands[i].Source = sources["deny\x00"+key]
+	}
+}
+
+// MergeJSON merges configuration layers, later layers taking precedence:
+//   - Deny lists (denyCommands, denyCategories, builtins.deny, redaction.patterns, landlock.readOnlyPaths) are
+//     the union of every layer; a later denyCommands entry for the same command replaces its message.
+//   - Allow lists (allowCommands, allowedDirectories, allowedBinDirs, allowCategories, builtins.allow) are appended to by default,
+//     a later allowCommands entry replacing the whole rule for the same command. A layer replaces
+//     a list instead by setting it to MergeReplace under MergeKey, e.g. {"merge": {"allowCommands": "replace"}}.
+//   - Objects are merged key by key; other values are replaced.
+//
+// ExtendsKey is removed from every layer; LoadConfigFromFiles adds the presets it names as layers.
+func MergeJSON(layers ...[]byte) ([]byte, error) {
+	merged := map[string]any{}
+	for i, layer := range layers {
+		var obj map[string]any
+		dec := json.NewDecoder(bytes.NewReader(layer))
+		dec.UseNumber()
+		if err := dec.Decode(&obj); err != nil {
+			return nil, fmt.Errorf("failed to decode config layer %d: %w", i+1, err)
+		}
+		if obj == nil {
+			return nil, fmt.Errorf("config layer %d is not a JSON object", i+1)
+		}
+
+		delete(obj, ExtendsKey)
+		modes, err := mergeModes(obj)
+		if err != nil {
+			return nil, fmt.Errorf("config layer %d: %w", i+1, err)
+		}
+		mergeObject(merged, obj, "", modes)
+	}
+	return json.Marshal(merged)
+}
+
+// mergeModes removes the merge directive from a layer and returns the mode of each allow list it names.
+func mergeModes(obj map[string]any) (map[string]string, error) {
+	raw, ok := obj[MergeKey]
+	if !ok {
+		return nil, nil
+	}
+	delete(obj, MergeKey)
+
+	directive, ok := raw.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s must be an object", MergeKey)
+	}
+	modes := make(map[string]string, len(directive))
+	for list, value := range directive {
+		if mergedLists[list] != allowList {
+			return nil, fmt.Errorf("%s: %q is not an allow list", MergeKey, list)
+		}
+		mode, _ := value.(string)
+		if mode != MergeAppend && mode != MergeReplace {
+			return nil, fmt.Errorf("%s: mode for %s must be %q or %q", MergeKey, list, MergeAppend, MergeReplace)
+		}
+		modes[list] = mode
+	}
+	return modes, nil
+}
+
+// mergeObject merges src into dst. prefix is the dotted path of dst.
+func mergeObject(dst, src map[string]any, prefix string, modes map[string]string) {
+	for key, value := range src {
+		path := prefix + key
+		if kind, ok := mergedLists[path]; ok {
+			if (kind == allowList && modes[path] == MergeReplace) || dst[key] == nil {
+				dst[key] = value
+				continue
+			}
+			dst[key] = mergeList(dst[key], value)
+			continue
+		}
+
+		srcObj, srcIsObj := value.(map[string]any)
+		dstObj, dstIsObj := dst[key].(map[string]any)
+		if srcIsObj && dstIsObj {
+			mergeObject(dstObj, srcObj, path+".", modes)
+			continue
+		}
+		dst[key] = value
+	}
+}
+
+// mergeList appends the entries of src to dst. An entry for a command or value already in
+// dst replaces it in place. Values that are not both lists are replaced by src.
+func mergeList(dst, src any) any {
+	dstList, dstOK := dst.([]any)
+	srcList, srcOK := src.([]any)
+	if !dstOK || !srcOK {
+		return src
+	}
+
+	result := append([]any(nil), dstList...)
+	index := make(map[string]int, len(result))
+	for i, entry := range result {
+		if key, ok := entryKey(entry); ok {
+			index[key] = i
+		}
+	}
+	for _, entry := range srcList {
+		key, ok := entryKey(entry)
+		if i, exists := index[key]; ok && exists {
+			result[i] = entry
+			continue
+		}
+		if ok {
+			index[key] = len(result)
+		}
+		result = append(result, entry)
+	}
+	return result
+}
+
+// entryKey identifies a list entry: a string is its own key and a command rule is keyed
+// by its command and, for deny rules limited to some arguments, by those arguments.
+func entryKey(entry any) (string, bool) {
+	switch e := entry.(type) {
+	case string:
+		return e, true
+	case map[string]any:
+		cmd, ok := e["command"].(string)
+		if args, hasArgs := e["args"].([]any); ok && hasArgs {
+			for _, arg := range args {
+				cmd += "\x00" + fmt.Sprint(arg)
+			}
+		}
+		return cmd, ok
+	}
+	return "", false
+}
@@ -0,0 +1,189 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergeJSON(t *testing.T) {
+	base := `{
+		"allowedDirectories": ["/srv"],
+		"allowCommands": ["ls", {"command": "git", "denySubCommands": ["push"]}],
+		"denyCommands": [{"command": "rm", "message": "no rm"}],
+		"builtins": {"deny": ["eval"], "message": "builtin denied"},
+		"rateLimit": {"commandsPerMinute": 60, "burst": 10},
+		"maxExecutionTime": 30
+	}`
+
+	tests := []struct {
+		name         string
+		override     string
+		wantAllow    []string
+		wantDirs     []string
+		wantDeny     []DenyCommand
+		wantBuiltins []string
+		wantBurst    int
+		wantRate     int
+		wantTime     int
+		wantGitDeny  []string
+		wantError    string
+	}{
+		{
+			name:         "override appends to allow lists and unions deny lists",
+			override:     `{"allowedDirectories": ["/work"], "allowCommands": ["cat", "git"], "denyCommands": ["sudo"], "builtins": {"deny": ["source"]}, "rateLimit": {"burst": 5}, "maxExecutionTime": 60}`,
+			wantAllow:    []string{"ls", "git", "cat"},
+			wantDirs:     []string{"/srv", "/work"},
+			wantDeny:     []DenyCommand{{Command: "rm", Message: "no rm"}, {Command: "sudo"}},
+			wantBuiltins: []string{"eval", "source"},
+			wantBurst:    5,
+			wantRate:     60,
+			wantTime:     60,
+			wantGitDeny:  nil,
+		},
+		{
+			name:         "replace directive",
+			override:     `{"merge": {"allowCommands": "replace"}, "allowCommands": ["cat"]}`,
+			wantAllow:    []string{"cat"},
+			wantDirs:     []string{"/srv"},
+			wantDeny:     []DenyCommand{{Command: "rm", Message: "no rm"}},
+			wantBuiltins: []string{"eval"},
+			wantBurst:    10,
+			wantRate:     60,
+			wantTime:     30,
+		},
+		{
+			name:         "later deny entry replaces the message",
+			override:     `{"denyCommands": [{"command": "rm", "message": "use trash"}]}`,
+			wantAllow:    []string{"ls", "git"},
+			wantDirs:     []string{"/srv"},
+			wantDeny:     []DenyCommand{{Command: "rm", Message: "use trash"}},
+			wantBuiltins: []string{"eval"},
+			wantBurst:    10,
+			wantRate:     60,
+			wantTime:     30,
+			wantGitDeny:  []string{"push"},
+		},
+		{
+			name:      "deny lists cannot be replaced",
+			override:  `{"merge": {"denyCommands": "replace"}}`,
+			wantError: "not an allow list",
+		},
+		{
+			name:      "unknown mode",
+			override:  `{"merge": {"allowCommands": "prepend"}}`,
+			wantError: "must be",
+		},
+		{
+			name:      "not an object",
+			override:  `["ls"]`,
+			wantError: "layer 2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, err := MergeJSON([]byte(base), []byte(tt.override))
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Fatalf("MergeJSON() error = %v, want it to contain %q", err, tt.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("MergeJSON() error = %v", err)
+			}
+			if strings.Contains(string(merged), `"merge"`) {
+				t.Errorf("merged config still contains the merge directive: %s", merged)
+			}
+
+			var cfg ShellCommandConfig
+			if err := cfg.UnmarshalJSON(merged); err != nil {
+				t.Fatalf("UnmarshalJSON() error = %v", err)
+			}
+
+			var allow []string
+			var gitDeny []string
+			for _, cmd := range cfg.AllowCommands {
+				allow = append(allow, cmd.Command)
+				if cmd.Command == "git" {
+					gitDeny = cmd.DenySubCommands
+				}
+			}
+			if !reflect.DeepEqual(allow, tt.wantAllow) {
+				t.Errorf("AllowCommands = %v, want %v", allow, tt.wantAllow)
+			}
+			if !reflect.DeepEqual(gitDeny, tt.wantGitDeny) {
+				t.Errorf("git DenySubCommands = %v, want %v", gitDeny, tt.wantGitDeny)
+			}
+			if !reflect.DeepEqual(cfg.AllowedDirectories, tt.wantDirs) {
+				t.Errorf("AllowedDirectories = %v, want %v", cfg.AllowedDirectories, tt.wantDirs)
+			}
+			if !reflect.DeepEqual(cfg.DenyCommands, tt.wantDeny) {
+				t.Errorf("DenyCommands = %v, want %v", cfg.DenyCommands, tt.wantDeny)
+			}
+			if !reflect.DeepEqual(cfg.Builtins.Deny, tt.wantBuiltins) {
+				t.Errorf("Builtins.Deny = %v, want %v", cfg.Builtins.Deny, tt.wantBuiltins)
+			}
+			if cfg.Builtins.Message != "builtin denied" {
+				t.Errorf("Builtins.Message = %q, want %q", cfg.Builtins.Message, "builtin denied")
+			}
+			if cfg.RateLimit.Burst != tt.wantBurst || cfg.RateLimit.CommandsPerMinute != tt.wantRate {
+				t.Errorf("RateLimit = %+v, want burst %d and rate %d", cfg.RateLimit, tt.wantBurst, tt.wantRate)
+			}
+			if cfg.MaxExecutionTime != tt.wantTime {
+				t.Errorf("MaxExecutionTime = %d, want %d", cfg.MaxExecutionTime, tt.wantTime)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFromFiles(t *testing.T) {
+	dir := t.TempDir()
+	basePath := filepath.Join(dir, "base.json")
+	projectPath := filepath.Join(dir, "project.json")
+	if err := os.WriteFile(basePath, []byte(`{"allowCommands": ["ls"], "denyCommands": ["rm"]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(projectPath, []byte(`{"allowedDirectories": ["/work"], "allowCommands": ["make"]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfigFromFiles(basePath, projectPath)
+	if err != nil {
+		t.Fatalf("LoadConfigFromFiles() error = %v", err)
+	}
+	if !cfg.IsCommandAllowed("ls") || !cfg.IsCommandAllowed("make") {
+		t.Errorf("AllowCommands = %v, want ls and make", cfg.AllowCommands)
+	}
+	if len(cfg.DenyCommands) != 1 || cfg.DenyCommands[0].Command != "rm" {
+		t.Errorf("DenyCommands = %v, want [rm]", cfg.DenyCommands)
+	}
+	// Each rule records the layer it comes from
+	if cfg.AllowCommands[0].Source != basePath || cfg.AllowCommands[1].Source != projectPath || cfg.DenyCommands[0].Source != basePath {
+		t.Errorf("rule sources = %q, %q, %q", cfg.AllowCommands[0].Source, cfg.AllowCommands[1].Source, cfg.DenyCommands[0].Source)
+	}
+	// Defaults still apply to fields no layer sets
+	if cfg.MaxExecutionTime != DefaultExecutionTimeout {
+		t.Errorf("MaxExecutionTime = %d, want %d", cfg.MaxExecutionTime, DefaultExecutionTimeout)
+	}
+
+	if _, err := LoadConfigFromFiles(basePath, filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("LoadConfigFromFiles() with a missing file succeeded")
+	}
+}
+
+func TestFileList(t *testing.T) {
+	var files FileList
+	_ = files.Set("base.json, team.json")
+	_ = files.Set("project.json")
+	want := FileList{"base.json", "team.json", "project.json"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("FileList = %v, want %v", files, want)
+	}
+	if got := files.String(); got != "base.json,team.json,project.json" {
+		t.Errorf("String() = %q", got)
+	}
+}
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/category"
+)
+
+// PolicyOverlay layers additional rules and different limits on top of the base policy for
+// the callers of a user or role. Overlays only add rules: a command or category denied by the
+// base policy stays denied, since deny rules are checked before allow rules.
+type PolicyOverlay struct {
+	// Roles lists the roles whose overlays apply to a user, in order, before the user's own.
+	// It is only permitted in the users section.
+	Roles []string `json:"roles,omitempty"`
+	// AllowCommands are allowed in addition to the base list; an entry for a command the base
+	// policy already allows replaces its rule.
+	AllowCommands []AllowCommand `json:"allowCommands,omitempty"`
+	// DenyCommands are denied in addition to the base list.
+	DenyCommands []DenyCommand `json:"denyCommands,omitempty"`
+	// AllowedDirectories are allowed in addition to the base list.
+	AllowedDirectories []string `json:"allowedDirectories,omitempty"`
+	// AllowCategories and DenyCategories are added to the base lists.
+	AllowCategories []string `json:"allowCategories,omitempty"`
+	DenyCategories  []string `json:"denyCategories,omitempty"`
+	// MaxExecutionTime, MaxOutputSize, RateLimit, and Quota replace the base limits when set.
+	MaxExecutionTime *int             `json:"maxExecutionTime,omitempty"`
+	MaxOutputSize    *int             `json:"maxOutputSize,omitempty"`
+	RateLimit        *RateLimitConfig `json:"rateLimit,omitempty"`
+	Quota            *QuotaConfig     `json:"quota,omitempty"`
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface for PolicyOverlay.
+// Like the base policy, its command lists accept both strings and objects.
+func (o *PolicyOverlay) UnmarshalJSON(data []byte) error {
+	type policyOverlayAlias PolicyOverlay
+	var raw struct {
+		policyOverlayAlias
+		AllowCommands json.RawMessage `json:"allowCommands,omitempty"`
+		DenyCommands  json.RawMessage `json:"denyCommands,omitempty"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*o = PolicyOverlay(raw.policyOverlayAlias)
+
+	if raw.AllowCommands != nil {
+		allowCommands, err := UnmarshalAllowCommands(raw.AllowCommands)
+		if err != nil {
+			return fmt.Errorf("error unmarshaling allow commands: %w", err)
+		}
+		o.AllowCommands = allowCommands
+	}
+	if raw.DenyCommands != nil {
+		denyCommands, err := UnmarshalDenyCommands(raw.DenyCommands)
+		if err != nil {
+			return fmt.Errorf("error unmarshaling deny commands: %w", err)
+		}
+		o.DenyCommands = denyCommands
+	}
+	if o.AllowedDirectories != nil {
+		allowedDirectories, err := expandDirectories(o.AllowedDirectories)
+		if err != nil {
+			return err
+		}
+		o.AllowedDirectories = allowedDirectories
+	}
+	return nil
+}
+
+// check rejects unknown categories and negative limits.
+func (o *PolicyOverlay) check() error {
+	for _, names := range [][]string{o.AllowCategories, o.DenyCategories} {
+		for _, name := range names {
+			if !category.Known(name) {
+				return fmt.Errorf("unknown command category %q", name)
+			}
+		}
+	}
+	if (o.MaxExecutionTime != nil && *o.MaxExecutionTime < 0) || (o.MaxOutputSize != nil && *o.MaxOutputSize < 0) {
+		return errors.New("limits must not be negative")
+	}
+	if o.RateLimit != nil && (o.RateLimit.CommandsPerMinute < 0 || o.RateLimit.Burst < 0 || o.RateLimit.MaxConcurrent < 0) {
+		return errors.New("rateLimit values must not be negative")
+	}
+	if o.Quota != nil {
+		return o.Quota.check()
+	}
+	return nil
+}
+
+// checkOverlays validates the users and roles sections: every overlay must be valid, roles
+// may not list roles of their own, and users may only refer to defined roles.
+func checkOverlays(users, roles map[string]PolicyOverlay) error {
+	for _, name := range slices.Sorted(maps.Keys(roles)) {
+		role := roles[name]
+		if len(role.Roles) > 0 {
+			return fmt.Errorf("roles.%s: roles cannot be assigned to a role", name)
+		}
+		if err := role.check(); err != nil {
+			return fmt.Errorf("roles.%s: %w", name, err)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(users)) {
+		user := users[name]
+		for _, role := range user.Roles {
+			if _, ok := roles[role]; !ok {
+				return fmt.Errorf("users.%s: unknown role %q", name, role)
+			}
+		}
+		if err := user.check(); err != nil {
+			return fmt.Errorf("users.%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// ForUser returns the policy of the callers identified as user (see identity.Identity.Key):
+// the base policy with the overlays of the user's roles and then of the user applied. It
+// returns c itself when the users section has no entry for user. The returned policy has no
+// users or roles of its own, so that applying it again changes nothing.
+func (c *ShellCommandConfig) ForUser(user string) *ShellCommandConfig {
+	overlay, ok := c.Users[user]
+	if !ok {
+		return c
+	}
+
+	cfg := c.Clone()
+	cfg.Users, cfg.Roles = nil, nil
+	for _, role := range overlay.Roles {
+		cfg.apply(c.Roles[role])
+	}
+	cfg.apply(overlay)
+	return cfg
+}
+
+// apply layers an overlay onto c, whose lists must not be shared with another policy.
+func (c *ShellCommandConfig) apply(o PolicyOverlay) {
+	for _, allow := range o.AllowCommands {
+		i := slices.IndexFunc(c.AllowCommands, func(a AllowCommand) bool { return a.Command == allow.Command })
+		if i >= 0 {
+			c.AllowCommands[i] = allow
+			continue
+		}
+		c.AllowCommands = append(c.AllowCommands, allow)
+	}
+	c.DenyCommands = append(c.DenyCommands, o.DenyCommands...)
+	c.AllowedDirectories = appendMissing(c.AllowedDirectories, o.AllowedDirectories)
+	c.AllowCategories = appendMissing(c.AllowCategories, o.AllowCategories)
+	c.DenyCategories = appendMissing(c.DenyCategories, o.DenyCategories)
+	if o.MaxExecutionTime != nil {
+		c.MaxExecutionTime = *o.MaxExecutionTime
+	}
+	if o.MaxOutputSize != nil {
+		c.MaxOutputSize = *o.MaxOutputSize
+	}
+	if o.RateLimit != nil {
+		c.RateLimit = *o.RateLimit
+	}
+	if o.Quota != nil {
+		c.Quota = *o.Quota
+	}
+}
+
+// appendMissing appends the values of src that are not yet in dst.
+func appendMissing(dst, src []string) []string {
+	for _, value := range src {
+		if !slices.Contains(dst, value) {
+			dst = append(dst, value)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const overlayPolicy = `{
+	"allowedDirectories": ["/srv"],
+	"allowCommands": ["ls", {"command": "git", "subCommands": ["status"]}],
+	"denyCommands": ["rm"],
+	"maxExecutionTime": 30,
+	"rateLimit": {"commandsPerMinute": 10},
+	"roles": {
+		"developer": {"allowCommands": ["make", {"command": "git", "subCommands": ["status", "commit"]}], "maxExecutionTime": 600},
+		"ci": {"denyCommands": ["curl"], "rateLimit": {"commandsPerMinute": 120}, "quota": {"maxCpuSeconds": 3600}}
+	},
+	"users": {
+		"alice": {"roles": ["developer"], "allowedDirectories": ["/home/alice"], "maxOutputSize": 0},
+		"uid:1001": {"roles": ["ci"]}
+	}
+}`
+
+func TestForUser(t *testing.T) {
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal([]byte(overlayPolicy), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	if got := cfg.ForUser("bob"); got != &cfg {
+		t.Error("ForUser() of a user without an overlay should return the base policy")
+	}
+
+	alice := cfg.ForUser("alice")
+	if !alice.IsCommandAllowed("make") || !alice.IsCommandAllowed("ls") {
+		t.Errorf("alice AllowCommands = %+v, want the base and developer commands", alice.AllowCommands)
+	}
+	if git := alice.AllowCommands[1]; git.Command != "git" || len(git.SubCommands) != 2 {
+		t.Errorf("alice git rule = %+v, want the developer rule replacing the base one", git)
+	}
+	if !slices.Equal(alice.AllowedDirectories, []string{"/srv", "/home/alice"}) {
+		t.Errorf("alice AllowedDirectories = %v", alice.AllowedDirectories)
+	}
+	if alice.MaxExecutionTime != 600 || alice.MaxOutputSize != 0 || alice.RateLimit.CommandsPerMinute != 10 {
+		t.Errorf("alice limits = %d, %d, %+v", alice.MaxExecutionTime, alice.MaxOutputSize, alice.RateLimit)
+	}
+	if alice.Users != nil || alice.ForUser("alice") != alice {
+		t.Error("the policy of a user should not apply overlays again")
+	}
+
+	ci := cfg.ForUser("uid:1001")
+	if len(ci.DenyCommands) != 2 || ci.DenyCommands[1].Command != "curl" || ci.RateLimit.CommandsPerMinute != 120 {
+		t.Errorf("ci DenyCommands = %+v, RateLimit = %+v", ci.DenyCommands, ci.RateLimit)
+	}
+	if ci.Quota != (QuotaConfig{MaxCPUSeconds: 3600}) || alice.Quota.Enabled() {
+		t.Errorf("ci Quota = %+v, alice Quota = %+v", ci.Quota, alice.Quota)
+	}
+
+	// The base policy is left unchanged
+	if len(cfg.AllowCommands) != 2 || len(cfg.AllowCommands[1].SubCommands) != 1 || len(cfg.AllowedDirectories) != 1 || len(cfg.DenyCommands) != 1 {
+		t.Errorf("base policy modified: %+v", cfg)
+	}
+}
+
+func TestUnmarshalOverlays_Invalid(t *testing.T) {
+	tests := []struct {
+		data    string
+		wantErr string
+	}{
+		{`{"allowCommands": [], "denyCommands": [], "users": {"alice": {"roles": ["admin"]}}}`, `users.alice: unknown role "admin"`},
+		{`{"allowCommands": [], "denyCommands": [], "roles": {"dev": {"roles": ["ops"]}}}`, "roles.dev: roles cannot be assigned"},
+		{`{"allowCommands": [], "denyCommands": [], "roles": {"dev": {"allowCategories": ["nope"]}}}`, `unknown command category "nope"`},
+		{`{"allowCommands": [], "denyCommands": [], "users": {"alice": {"maxExecutionTime": -1}}}`, "must not be negative"},
+		{`{"allowCommands": [], "denyCommands": [], "users": {"alice": {"quota": {"maxExecutions": -1}}}}`, "quota values must not be negative"},
+	}
+	for _, tt := range tests {
+		var cfg ShellCommandConfig
+		err := json.Unmarshal([]byte(tt.data), &cfg)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Unmarshal(%s) error = %v, want %q", tt.data, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParse_OverlayFields(t *testing.T) {
+	if _, err := Parse([]byte(overlayPolicy)); err != nil {
+		t.Errorf("Parse() error = %v", err)
+	}
+	_, err := Parse([]byte(`{"allowCommands": [], "denyCommands": [], "users": {"alice": {"allowCommand": ["make"]}}}`))
+	if err == nil || !strings.Contains(err.Error(), `"users.alice.allowCommand" (did you mean "allowCommands"?)`) {
+		t.Errorf("Parse() error = %v, want an unknown field in the overlay", err)
+	}
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// PresetPrefix marks a reference to an embedded preset, as in "preset:developer". Such a
+// reference may be given to LoadConfigFromFiles in place of a file.
+const PresetPrefix = "preset:"
+
+// ExtendsKey is the top-level key through which a configuration file names the preset it is
+// layered on, e.g. {"extends": "preset:developer"}. It is removed from the result.
+const ExtendsKey = "extends"
+
+// PresetVersion is the version of the embedded presets. It changes whenever a preset allows
+// or denies something it did not before, so that a reference pinned to a version, such as
+// "preset:developer@1", fails to load instead of silently changing the policy.
+const PresetVersion = 1
+
+// presetFiles holds the presets, one JSON file per preset.
+//
+//go:embed presets/*.json
+var presetFiles embed.FS
+
+// Presets returns the names of the embedded presets, sorted.
+func Presets() []string {
+	entries, err := fs.ReadDir(presetFiles, "presets")
+	if err != nil {
+		panic("config: cannot read embedded presets: " + err.Error())
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), ".json"))
+	}
+	slices.Sort(names)
+	return names
+}
+
+// LoadPreset returns the embedded preset name, which may carry PresetPrefix and a version
+// pin, as in "preset:ci@1". Presets allow no directories; the layers on top of them add those.
+func LoadPreset(name string) (*ShellCommandConfig, error) {
+	ref, data, err := presetData(name)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid preset %s: %w", ref, err)
+	}
+	cfg.SetRuleSource(ref)
+	return cfg, nil
+}
+
+// PresetData returns the JSON of the embedded preset name, as accepted by LoadPreset.
+func PresetData(name string) ([]byte, error) {
+	_, data, err := presetData(name)
+	return data, err
+}
+
+// presetData returns the canonical reference and the JSON of the preset name.
+func presetData(name string) (string, []byte, error) {
+	base, version, pinned := strings.Cut(strings.TrimPrefix(name, PresetPrefix), "@")
+	if pinned && version != strconv.Itoa(PresetVersion) {
+		return "", nil, fmt.Errorf("preset %s: version %s is not available, the presets are at version %d", base, version, PresetVersion)
+	}
+	data, err := presetFiles.ReadFile(path.Join("presets", base+".json"))
+	if err != nil || base == "" {
+		return "", nil, fmt.Errorf("unknown preset %q: must be one of %s", base, strings.Join(Presets(), ", "))
+	}
+	return PresetPrefix + base, data, nil
+}
+
+// baseLayer returns the reference and the JSON of the preset a configuration layer extends
+// with ExtendsKey, or nil when it extends none.
+func baseLayer(layer []byte) (string, []byte, error) {
+	var directive struct {
+		Extends *string `json:"extends"`
+	}
+	if err := json.Unmarshal(layer, &directive); err != nil || directive.Extends == nil {
+		// Errors are left for the decoding of the layer to report
+		return "", nil, nil
+	}
+	if !strings.HasPrefix(*directive.Extends, PresetPrefix) {
+		return "", nil, errors.New(ExtendsKey + " must name a preset, such as " + PresetPrefix + "developer")
+	}
+	return presetData(*directive.Extends)
+}
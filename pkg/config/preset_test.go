@@ -0,0 +1,80 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestLoadPreset(t *testing.T) {
+	if got := Presets(); !slices.Equal(got, []string{"ci", "developer", "read-only"}) {
+		t.Errorf("Presets() = %v", got)
+	}
+
+	// Every preset is valid once directories are layered on it
+	for _, name := range Presets() {
+		cfg, err := LoadPreset(name)
+		if err != nil {
+			t.Fatalf("LoadPreset(%q) error = %v", name, err)
+		}
+		if len(cfg.AllowCommands) == 0 || cfg.AllowCommands[0].Source != PresetPrefix+name {
+			t.Errorf("LoadPreset(%q) rules = %v", name, cfg.AllowCommands)
+		}
+		cfg.AllowedDirectories = []string{t.TempDir()}
+		if issues := Validate(cfg); len(issues) > 0 {
+			t.Errorf("preset %s: %v", name, issues)
+		}
+	}
+
+	cfg, err := LoadPreset("preset:read-only@1")
+	if err != nil || !cfg.ReadOnlyOnly {
+		t.Errorf("LoadPreset(preset:read-only@1) = %+v, %v", cfg, err)
+	}
+	for _, name := range []string{"preset:read-only@2", "admin", "", "../schema"} {
+		if _, err := LoadPreset(name); err == nil {
+			t.Errorf("LoadPreset(%q) should fail", name)
+		}
+	}
+}
+
+func TestLoadConfigFromFiles_Preset(t *testing.T) {
+	dir := t.TempDir()
+	projectPath := filepath.Join(dir, "project.json")
+	project := `{"extends": "preset:read-only", "allowedDirectories": ["/work"], "allowCommands": [{"command": "tree", "readOnly": true}]}`
+	if err := os.WriteFile(projectPath, []byte(project), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, paths := range [][]string{{projectPath}, {"preset:developer", projectPath}} {
+		cfg, err := LoadConfigFromFiles(paths...)
+		if err != nil {
+			t.Fatalf("LoadConfigFromFiles(%v) error = %v", paths, err)
+		}
+		if !cfg.ReadOnlyOnly || !cfg.IsCommandAllowed("cat") || !cfg.IsCommandAllowed("tree") {
+			t.Errorf("LoadConfigFromFiles(%v) did not layer the project on the preset", paths)
+		}
+		// Each rule records the preset or file it comes from
+		if cfg.AllowCommands[0].Source != "preset:read-only" {
+			t.Errorf("rule source = %q, want preset:read-only", cfg.AllowCommands[0].Source)
+		}
+		if i := slices.IndexFunc(cfg.AllowCommands, func(c AllowCommand) bool { return c.Command == "tree" }); cfg.AllowCommands[i].Source != projectPath {
+			t.Errorf("rule source = %q, want %s", cfg.AllowCommands[i].Source, projectPath)
+		}
+	}
+
+	// Parse layers a configuration on the preset it extends as well
+	cfg, err := Parse([]byte(project))
+	if err != nil || !cfg.ReadOnlyOnly || !cfg.IsCommandAllowed("cat") {
+		t.Errorf("Parse() = %+v, %v", cfg, err)
+	}
+
+	for _, data := range []string{
+		`{"extends": "base.json", "allowCommands": [], "denyCommands": []}`,
+		`{"extends": "preset:admin", "allowCommands": [], "denyCommands": []}`,
+	} {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("Parse(%s) should fail", data)
+		}
+	}
+}
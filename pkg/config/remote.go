@@ -0,0 +1,257 @@
+package config
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// SignatureHeader carries the base64-encoded Ed25519 signature of the response body.
+	SignatureHeader = "X-Config-Signature"
+	// defaultFetchTimeout bounds a single fetch when no HTTP client is given.
+	defaultFetchTimeout = 30 * time.Second
+	// maxRemoteConfigSize bounds the size of a fetched configuration.
+	maxRemoteConfigSize = 10 * 1024 * 1024
+	// cacheFilePermissions are used for the cached configuration and its metadata.
+	cacheFilePermissions = 0o600
+)
+
+// ErrInvalidSignature is returned when a fetched configuration is unsigned or its signature does not verify.
+var ErrInvalidSignature = errors.New("configuration signature verification failed")
+
+// RemoteOptions configures LoadConfigFromURL and WatchConfigURL.
+type RemoteOptions struct {
+	// Client is used for requests. Defaults to a client with a 30 second timeout.
+	Client *http.Client
+	// PublicKey, when set, requires every configuration to carry a valid Ed25519
+	// signature of the body in the X-Config-Signature response header.
+	PublicKey ed25519.PublicKey
+	// CachePath stores the last verified configuration so that unchanged configurations
+	// are revalidated with ETags and the cached copy is used when the server is unreachable.
+	CachePath string
+	// RefreshInterval is how often WatchConfigURL polls for changes.
+	RefreshInterval time.Duration
+}
+
+// cachedConfig is the on-disk cache entry, kept alongside the configuration body.
+type cachedConfig struct {
+	URL       string `json:"url"`
+	ETag      string `json:"etag"`
+	Signature string `json:"signature,omitempty"`
+	Body      []byte `json:"body"`
+}
+
+// remoteSource fetches a configuration and remembers the last verified response.
+type remoteSource struct {
+	url    string
+	opts   RemoteOptions
+	client *http.Client
+	cached *cachedConfig
+}
+
+// LoadConfigFromURL fetches a configuration over HTTP(S). If CachePath is set, a cached copy
+// is revalidated with If-None-Match and used as a fallback when the fetch fails.
+func LoadConfigFromURL(url string, opts RemoteOptions) (*ShellCommandConfig, error) {
+	src := newRemoteSource(url, opts)
+	cfg, _, err := src.fetch(context.Background())
+	return cfg, err
+}
+
+// WatchConfigURL loads the configuration, calls onUpdate with it, and then polls every
+// RefreshInterval until ctx is done, calling onUpdate whenever a new configuration is fetched.
+// Errors from later polls are passed to onError and the previous configuration stays in effect.
+func WatchConfigURL(ctx context.Context, url string, opts RemoteOptions,
+	onUpdate func(*ShellCommandConfig), onError func(error),
+) error {
+	if opts.RefreshInterval <= 0 {
+		return errors.New("refresh interval must be positive")
+	}
+
+	src := newRemoteSource(url, opts)
+	cfg, _, err := src.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	onUpdate(cfg)
+
+	ticker := time.NewTicker(opts.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			cfg, changed, err := src.fetch(ctx)
+			switch {
+			case err != nil:
+				if onError != nil {
+					onError(err)
+				}
+			case changed:
+				onUpdate(cfg)
+			}
+		}
+	}
+}
+
+// ParsePublicKey parses an Ed25519 public key given as a PEM "PUBLIC KEY" block or as base64 of the raw key.
+func ParsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key: %w", err)
+		}
+		edKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, errors.New("public key is not an Ed25519 key")
+		}
+		return edKey, nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode public key: %w", err)
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key must be %d bytes, got %d", ed25519.PublicKeySize, len(raw))
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// newRemoteSource creates a source for url, starting from the cached copy if there is one.
+func newRemoteSource(url string, opts RemoteOptions) *remoteSource {
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{Timeout: defaultFetchTimeout}
+	}
+	src := &remoteSource{url: url, opts: opts, client: client}
+	src.cached = src.readCache()
+	return src
+}
+
+// fetch requests the configuration and reports whether it differs from the last one returned.
+func (s *remoteSource) fetch(ctx context.Context) (*ShellCommandConfig, bool, error) {
+	entry, notModified, err := s.request(ctx)
+	if err != nil {
+		if s.cached == nil {
+			return nil, false, err
+		}
+		// Keep serving the last verified configuration while the server is unreachable
+		cfg, decodeErr := s.decode(s.cached)
+		if decodeErr != nil {
+			return nil, false, errors.Join(err, decodeErr)
+		}
+		return cfg, false, nil
+	}
+	if notModified {
+		cfg, err := s.decode(s.cached)
+		return cfg, false, err
+	}
+
+	cfg, err := s.decode(entry)
+	if err != nil {
+		return nil, false, err
+	}
+	s.cached = entry
+	s.writeCache(entry)
+	return cfg, true, nil
+}
+
+// request performs the HTTP request. notModified is true when the cached copy is still current.
+func (s *remoteSource) request(ctx context.Context) (*cachedConfig, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create config request: %w", err)
+	}
+	if s.cached != nil && s.cached.ETag != "" {
+		req.Header.Set("If-None-Match", s.cached.ETag)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to fetch config: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && s.cached != nil:
+		return nil, true, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, false, fmt.Errorf("failed to fetch config: unexpected status %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteConfigSize+1))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read config: %w", err)
+	}
+	if len(body) > maxRemoteConfigSize {
+		return nil, false, fmt.Errorf("config exceeds %d bytes", maxRemoteConfigSize)
+	}
+
+	return &cachedConfig{
+		URL:       s.url,
+		ETag:      resp.Header.Get("ETag"),
+		Signature: resp.Header.Get(SignatureHeader),
+		Body:      body,
+	}, false, nil
+}
+
+// decode verifies the signature of a fetched or cached configuration and parses it.
+func (s *remoteSource) decode(entry *cachedConfig) (*ShellCommandConfig, error) {
+	if s.opts.PublicKey != nil {
+		sig, err := base64.StdEncoding.DecodeString(entry.Signature)
+		if err != nil || !ed25519.Verify(s.opts.PublicKey, entry.Body, sig) {
+			return nil, ErrInvalidSignature
+		}
+	}
+
+	cfg, err := Parse(entry.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode config: %w", err)
+	}
+	cfg.SetRuleSource(s.url)
+	return cfg, nil
+}
+
+// readCache loads the cache file, ignoring a missing or corrupt cache or one for another URL.
+func (s *remoteSource) readCache() *cachedConfig {
+	if s.opts.CachePath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.opts.CachePath)
+	if err != nil {
+		return nil
+	}
+	var entry cachedConfig
+	if err := json.Unmarshal(data, &entry); err != nil || entry.URL != s.url {
+		return nil
+	}
+	return &entry
+}
+
+// writeCache stores a verified configuration. Failing to cache does not fail the fetch.
+func (s *remoteSource) writeCache(entry *cachedConfig) {
+	if s.opts.CachePath == "" {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	tmp := s.opts.CachePath + ".tmp"
+	if err := os.WriteFile(tmp, data, cacheFilePermissions); err != nil {
+		return
+	}
+	_ = os.Rename(tmp, s.opts.CachePath)
+}
@@ -0,0 +1,180 @@
+package config
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const remoteConfigBody = `{"allowedDirectories": ["/tmp"], "allowCommands": ["ls"], "denyCommands": ["rm"]}`
+
+// newConfigServer serves body with an ETag, answering If-None-Match with 304.
+// The returned counter records how many full responses were sent.
+func newConfigServer(t *testing.T, body *atomic.Value, priv ed25519.PrivateKey) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var served atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content := body.Load().(string)
+		sum := sha256.Sum256([]byte(content))
+		etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		if priv != nil {
+			w.Header().Set(SignatureHeader, base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte(content))))
+		}
+		served.Add(1)
+		_, _ = w.Write([]byte(content))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &served
+}
+
+func TestLoadConfigFromURL(t *testing.T) {
+	var body atomic.Value
+	body.Store(remoteConfigBody)
+	srv, served := newConfigServer(t, &body, nil)
+	cachePath := filepath.Join(t.TempDir(), "config.cache")
+	opts := RemoteOptions{CachePath: cachePath}
+
+	cfg, err := LoadConfigFromURL(srv.URL, opts)
+	if err != nil {
+		t.Fatalf("LoadConfigFromURL() error = %v", err)
+	}
+	if !cfg.IsCommandAllowed("ls") {
+		t.Error("IsCommandAllowed(ls) = false, want true")
+	}
+
+	// The cached ETag is revalidated, so the body is not sent again
+	if _, err := LoadConfigFromURL(srv.URL, opts); err != nil {
+		t.Fatalf("LoadConfigFromURL() with cache error = %v", err)
+	}
+	if got := served.Load(); got != 1 {
+		t.Errorf("server sent the body %d times, want 1", got)
+	}
+
+	// The cached copy is used when the server is unreachable
+	srv.Close()
+	cfg, err = LoadConfigFromURL(srv.URL, opts)
+	if err != nil {
+		t.Fatalf("LoadConfigFromURL() offline error = %v", err)
+	}
+	if !cfg.IsCommandAllowed("ls") {
+		t.Error("offline IsCommandAllowed(ls) = false, want true")
+	}
+
+	// Without a cache the failure is reported
+	if _, err := LoadConfigFromURL(srv.URL, RemoteOptions{}); err == nil {
+		t.Error("LoadConfigFromURL() offline without cache should fail")
+	}
+}
+
+func TestLoadConfigFromURL_Signature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body atomic.Value
+	body.Store(remoteConfigBody)
+
+	signed, _ := newConfigServer(t, &body, priv)
+	if _, err := LoadConfigFromURL(signed.URL, RemoteOptions{PublicKey: pub}); err != nil {
+		t.Errorf("LoadConfigFromURL() with valid signature error = %v", err)
+	}
+
+	wrongKey, _ := newConfigServer(t, &body, otherPriv)
+	if _, err := LoadConfigFromURL(wrongKey.URL, RemoteOptions{PublicKey: pub}); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("LoadConfigFromURL() with wrong key error = %v, want ErrInvalidSignature", err)
+	}
+
+	unsigned, _ := newConfigServer(t, &body, nil)
+	if _, err := LoadConfigFromURL(unsigned.URL, RemoteOptions{PublicKey: pub}); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("LoadConfigFromURL() unsigned error = %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestWatchConfigURL(t *testing.T) {
+	var body atomic.Value
+	body.Store(remoteConfigBody)
+	srv, _ := newConfigServer(t, &body, nil)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	updates := make(chan *ShellCommandConfig, 4)
+	done := make(chan error, 1)
+	go func() {
+		done <- WatchConfigURL(ctx, srv.URL, RemoteOptions{RefreshInterval: 10 * time.Millisecond},
+			func(cfg *ShellCommandConfig) { updates <- cfg }, nil)
+	}()
+
+	first := <-updates
+	if first.IsCommandAllowed("cat") {
+		t.Error("initial config should not allow cat")
+	}
+
+	body.Store(`{"allowedDirectories": ["/tmp"], "allowCommands": ["cat"], "denyCommands": []}`)
+	select {
+	case second := <-updates:
+		if !second.IsCommandAllowed("cat") {
+			t.Error("updated config should allow cat")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no update received after the config changed")
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("WatchConfigURL() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	for name, data := range map[string][]byte{
+		"pem":    pemKey,
+		"base64": []byte(base64.StdEncoding.EncodeToString(pub) + "\n"),
+	} {
+		got, err := ParsePublicKey(data)
+		if err != nil {
+			t.Errorf("ParsePublicKey(%s) error = %v", name, err)
+			continue
+		}
+		if !got.Equal(pub) {
+			t.Errorf("ParsePublicKey(%s) returned a different key", name)
+		}
+	}
+
+	if _, err := ParsePublicKey([]byte("c2hvcnQ=")); err == nil {
+		t.Error("ParsePublicKey() with a short key should fail")
+	}
+}
@@ -0,0 +1,304 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/category"
+)
+
+// SchemaURI is the JSON Schema dialect of the schema returned by JSONSchema.
+const SchemaURI = "https://json-schema.org/draft/2020-12/schema"
+
+// ErrUnknownField is returned when a configuration contains a field the policy format does not define.
+var ErrUnknownField = errors.New("unknown field")
+
+// schemaNode is a JSON Schema, or a part of one, as decoded JSON.
+type schemaNode = map[string]any
+
+// shorthandTypes may be written as a plain string naming the command in place of an object.
+var shorthandTypes = map[reflect.Type]bool{
+	reflect.TypeFor[AllowCommand]():   true,
+	reflect.TypeFor[DenyCommand]():    true,
+	reflect.TypeFor[SubCommandRule](): true,
+}
+
+// fieldEnums lists the values permitted for string fields, or for the elements of string
+// lists, by "Type.jsonName".
+func fieldEnums() map[string][]string {
+	categories := make([]string, 0, len(category.All()))
+	for _, c := range category.All() {
+		categories = append(categories, string(c))
+	}
+	return map[string][]string{
+		"ShellCommandConfig.dialect":          {DialectBash, DialectPOSIX, DialectMksh},
+		"ShellCommandConfig.executionBackend": {BackendLocal, BackendDocker, BackendKubernetes},
+		"ShellCommandConfig.interpreterInput": {InterpreterInputScan, InterpreterInputDeny, InterpreterInputAllow},
+		"ShellCommandConfig.enforcementMode":  {EnforcementEnforcing, EnforcementPermissive},
+		"ShellCommandConfig.allowCategories":  categories,
+		"ShellCommandConfig.denyCategories":   categories,
+		"PolicyOverlay.allowCategories":       categories,
+		"PolicyOverlay.denyCategories":        categories,
+		"RiskConfig.action":                   {RiskActionApprove, RiskActionDeny},
+		"ObfuscationConfig.action":            {ObfuscationActionDeny, ObfuscationActionFlag},
+		"ObfuscationConfig.patterns":          ObfuscationPatterns,
+		"OutputSafetyConfig.binary":           {BinaryPlaceholder, BinaryBase64, BinaryRaw},
+		"SeccompConfig.action":                {SeccompActionErrno, SeccompActionKill},
+		"SeccompConfig.denySyscalls":          SeccompSyscalls,
+		"PrivilegesConfig.keepCapabilities":   Capabilities,
+		"SyslogConfig.network":                {"", "udp", "tcp"},
+		"SyslogConfig.format":                 {SyslogFormatRFC5424, SyslogFormatCEF},
+		"SyslogConfig.facility":               slices.Sorted(maps.Keys(SyslogFacilities)),
+	}
+}
+
+// schema is the JSON Schema of ShellCommandConfig, built once from its Go types.
+var schema = sync.OnceValue(func() schemaNode {
+	g := &schemaGenerator{defs: schemaNode{}, enums: fieldEnums()}
+	root := g.object(reflect.TypeFor[ShellCommandConfig]())
+	root["$schema"] = SchemaURI
+	root["title"] = "secure-shell-server policy"
+	// A layer of a layered configuration chooses how its allow lists are merged
+	root["properties"].(schemaNode)[MergeKey] = schemaNode{
+		"type":                 "object",
+		"propertyNames":        schemaNode{"enum": slices.Sorted(maps.Keys(allowLists()))},
+		"additionalProperties": schemaNode{"enum": []string{MergeAppend, MergeReplace}},
+	}
+	// A configuration may be layered on an embedded preset
+	presets := make([]string, 0, len(Presets()))
+	for _, name := range Presets() {
+		presets = append(presets, PresetPrefix+name, fmt.Sprintf("%s%s@%d", PresetPrefix, name, PresetVersion))
+	}
+	root["properties"].(schemaNode)[ExtendsKey] = schemaNode{"type": "string", "enum": presets}
+	root["$defs"] = g.defs
+	return root
+})
+
+// allowLists returns the lists whose merging a layer may choose, as a set.
+func allowLists() map[string]bool {
+	lists := make(map[string]bool)
+	for list, kind := range mergedLists {
+		if kind == allowList {
+			lists[list] = true
+		}
+	}
+	return lists
+}
+
+// JSONSchema returns a JSON Schema (draft 2020-12) describing the configuration file format,
+// for editor completion and for validating policies in CI.
+func JSONSchema() []byte {
+	data, err := json.MarshalIndent(schema(), "", "  ")
+	if err != nil {
+		panic("config: cannot encode schema: " + err.Error())
+	}
+	return data
+}
+
+// schemaGenerator derives JSON Schemas from Go types and their json tags.
+type schemaGenerator struct {
+	// defs holds the schemas of named struct types, which are referenced by "$ref"
+	defs  schemaNode
+	enums map[string][]string
+}
+
+// typeSchema returns the schema of t.
+func (g *schemaGenerator) typeSchema(t reflect.Type) schemaNode {
+	switch t.Kind() {
+	case reflect.String:
+		return schemaNode{"type": "string"}
+	case reflect.Bool:
+		return schemaNode{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return schemaNode{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return schemaNode{"type": "number"}
+	case reflect.Pointer:
+		return g.typeSchema(t.Elem())
+	case reflect.Slice:
+		return schemaNode{"type": "array", "items": g.typeSchema(t.Elem())}
+	case reflect.Map:
+		return schemaNode{"type": "object", "additionalProperties": g.typeSchema(t.Elem())}
+	case reflect.Struct:
+		return g.ref(t)
+	default:
+		return schemaNode{}
+	}
+}
+
+// ref returns a reference to the definition of a struct type, adding it on first use.
+func (g *schemaGenerator) ref(t reflect.Type) schemaNode {
+	name := t.Name()
+	if _, ok := g.defs[name]; !ok {
+		// Reserve the name first: SubCommandRule refers to itself
+		g.defs[name] = schemaNode{}
+		def := g.object(t)
+		if shorthandTypes[t] {
+			def = schemaNode{"oneOf": []any{schemaNode{"type": "string"}, def}}
+		}
+		g.defs[name] = def
+	}
+	return schemaNode{"$ref": "#/$defs/" + name}
+}
+
+// object returns the schema of a struct, which permits only the fields it declares.
+func (g *schemaGenerator) object(t reflect.Type) schemaNode {
+	properties := schemaNode{}
+	for field := range fieldsOf(t) {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		prop := g.typeSchema(field.Type)
+		if enum, ok := g.enums[t.Name()+"."+name]; ok {
+			if items, isArray := prop["items"].(schemaNode); isArray {
+				items["enum"] = enum
+			} else {
+				prop["enum"] = enum
+			}
+		}
+		properties[name] = prop
+	}
+	return schemaNode{"type": "object", "properties": properties, "additionalProperties": false}
+}
+
+// fieldsOf yields the exported fields of t that are encoded in JSON.
+func fieldsOf(t reflect.Type) func(yield func(reflect.StructField) bool) {
+	return func(yield func(reflect.StructField) bool) {
+		for i := range t.NumField() {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if !field.IsExported() || name == "" || name == "-" {
+				continue
+			}
+			if !yield(field) {
+				return
+			}
+		}
+	}
+}
+
+// Parse decodes a configuration strictly: unlike json.Unmarshal, it fails with ErrUnknownField
+// on fields the format does not define, so that a misspelled key is not silently ignored.
+// A configuration naming a preset with ExtendsKey is layered on the preset.
+func Parse(data []byte) (*ShellCommandConfig, error) {
+	if err := checkFields(data); err != nil {
+		return nil, err
+	}
+	_, base, err := baseLayer(data)
+	if err != nil {
+		return nil, err
+	}
+	if base != nil {
+		if data, err = MergeJSON(base, data); err != nil {
+			return nil, err
+		}
+	}
+	var cfg ShellCommandConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// checkFields reports the first field in a JSON configuration that the schema does not define.
+// Values of the wrong type are left for json.Unmarshal to report.
+func checkFields(data []byte) error {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	root := schema()
+	return checkNode(root, root, value, "")
+}
+
+// checkNode checks value against node; path is the dotted path of value.
+func checkNode(root, node schemaNode, value any, path string) error {
+	if ref, ok := node["$ref"].(string); ok {
+		node = root["$defs"].(schemaNode)[strings.TrimPrefix(ref, "#/$defs/")].(schemaNode)
+	}
+	if alternatives, ok := node["oneOf"].([]any); ok {
+		// Only the object form of a shorthand type has fields to check
+		for _, alternative := range alternatives {
+			if alt := alternative.(schemaNode); alt["type"] == "object" {
+				node = alt
+			}
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		properties, _ := node["properties"].(schemaNode)
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			field := key
+			if path != "" {
+				field = path + "." + key
+			}
+			if prop, ok := properties[key].(schemaNode); ok {
+				if err := checkNode(root, prop, v[key], field); err != nil {
+					return err
+				}
+				continue
+			}
+			switch additional := node["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					return unknownFieldError(field, key, properties)
+				}
+			case schemaNode:
+				if err := checkNode(root, additional, v[key], field); err != nil {
+					return err
+				}
+			}
+		}
+	case []any:
+		if items, ok := node["items"].(schemaNode); ok {
+			for i, item := range v {
+				if err := checkNode(root, items, item, path+"["+strconv.Itoa(i)+"]"); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// unknownFieldError reports an unknown field, suggesting the closest known name for a likely typo.
+func unknownFieldError(field, key string, properties schemaNode) error {
+	best, bestDistance := "", len(key)/3+1
+	for _, name := range slices.Sorted(maps.Keys(properties)) {
+		if d := editDistance(strings.ToLower(key), strings.ToLower(name)); d < bestDistance {
+			best, bestDistance = name, d
+		}
+	}
+	if best != "" {
+		return fmt.Errorf("%w %q (did you mean %q?)", ErrUnknownField, field, best)
+	}
+	return fmt.Errorf("%w %q", ErrUnknownField, field)
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJSONSchema(t *testing.T) {
+	var s map[string]any
+	if err := json.Unmarshal(JSONSchema(), &s); err != nil {
+		t.Fatalf("JSONSchema() is not valid JSON: %v", err)
+	}
+	if s["$schema"] != SchemaURI {
+		t.Errorf("$schema = %v", s["$schema"])
+	}
+
+	properties, _ := s["properties"].(map[string]any)
+	for _, name := range []string{"allowedDirectories", "allowCommands", "denyCommands", "dialect", "docker", MergeKey} {
+		if _, ok := properties[name]; !ok {
+			t.Errorf("schema has no property %q", name)
+		}
+	}
+	dialect, _ := properties["dialect"].(map[string]any)
+	if enum, _ := dialect["enum"].([]any); len(enum) != 3 {
+		t.Errorf("dialect enum = %v", dialect["enum"])
+	}
+	defs, _ := s["$defs"].(map[string]any)
+	if _, ok := defs["SubCommandRule"]; !ok {
+		t.Error("schema has no SubCommandRule definition")
+	}
+}
+
+func TestParseRejectsUnknownFields(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string // empty when the configuration is valid
+	}{
+		{
+			name: "valid with shorthands and merge directive",
+			data: `{"allowCommands": ["ls", {"command": "git", "subCommands": ["status", {"name": "push", "denyFlags": ["-f"]}]}],
+				"denyCommands": ["rm"], "templates": {"list": "ls"}, "merge": {"allowCommands": "replace"}}`,
+		},
+		{
+			name: "misspelled top-level field",
+			data: `{"allowComands": ["ls"], "denyCommands": []}`,
+			want: `unknown field "allowComands" (did you mean "allowCommands"?)`,
+		},
+		{
+			name: "misspelled nested field",
+			data: `{"allowCommands": [{"command": "git", "subCommands": [{"name": "push", "denyFlag": ["-f"]}]}], "denyCommands": []}`,
+			want: `unknown field "allowCommands[0].subCommands[0].denyFlag" (did you mean "denyFlags"?)`,
+		},
+		{
+			name: "unrelated field",
+			data: `{"allowCommands": [], "denyCommands": [], "rateLimit": {"perHour": 5}}`,
+			want: `unknown field "rateLimit.perHour"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.data))
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("Parse() error = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrUnknownField) || err.Error() != tt.want {
+				t.Errorf("Parse() error = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFromFilesRejectsUnknownFields(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.json")
+	override := filepath.Join(dir, "override.json")
+	if err := os.WriteFile(base, []byte(`{"allowCommands": ["ls"], "denyCommands": []}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(override, []byte(`{"maxExecutionTme": 5}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := LoadConfigFromFiles(base, override)
+	if !errors.Is(err, ErrUnknownField) || !strings.Contains(err.Error(), override) {
+		t.Errorf("LoadConfigFromFiles() error = %v, want an unknown field in %s", err, override)
+	}
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// Store holds the current policy of a server so that it can be read by concurrent executions
+// while a reload replaces it. A policy is never modified once it is in a Store: Replace swaps
+// in a new one and Update applies its change to a copy, so that readers keep a consistent
+// policy for as long as they hold the pointer returned by Load.
+type Store struct {
+	current atomic.Pointer[ShellCommandConfig]
+	// mu serializes Update, whose read-copy-swap must not interleave with another writer
+	mu sync.Mutex
+}
+
+// NewStore returns a store holding cfg, which the caller must no longer modify.
+func NewStore(cfg *ShellCommandConfig) *Store {
+	s := &Store{}
+	s.current.Store(cfg)
+	return s
+}
+
+// Load returns the current policy. It must not be modified.
+func (s *Store) Load() *ShellCommandConfig {
+	return s.current.Load()
+}
+
+// Replace makes cfg the current policy and returns the previous one. The caller must no
+// longer modify cfg.
+func (s *Store) Replace(cfg *ShellCommandConfig) *ShellCommandConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current.Swap(cfg)
+}
+
+// Update applies change to a copy of the current policy (see Clone) and makes the copy
+// current, returning it.
+func (s *Store) Update(change func(*ShellCommandConfig)) *ShellCommandConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cfg := s.current.Load().Clone()
+	change(cfg)
+	s.current.Store(cfg)
+	return cfg
+}
+
+// Clone returns a copy of the policy whose lists and maps can be changed without affecting c.
+// The rules and nested settings they hold are shared, so entries must be replaced rather than
+// modified in place.
+func (c *ShellCommandConfig) Clone() *ShellCommandConfig {
+	cfg := *c
+	cfg.AllowedDirectories = slices.Clone(c.AllowedDirectories)
+	cfg.AllowCommands = slices.Clone(c.AllowCommands)
+	cfg.DenyCommands = slices.Clone(c.DenyCommands)
+	cfg.AllowedBinDirs = slices.Clone(c.AllowedBinDirs)
+	cfg.ReadOnlyDirectories = slices.Clone(c.ReadOnlyDirectories)
+	cfg.WritableDevices = slices.Clone(c.WritableDevices)
+	cfg.AllowCategories = slices.Clone(c.AllowCategories)
+	cfg.DenyCategories = slices.Clone(c.DenyCategories)
+	cfg.Templates = maps.Clone(c.Templates)
+	cfg.Rewrites = maps.Clone(c.Rewrites)
+	cfg.Env = maps.Clone(c.Env)
+	cfg.InterpolateEnv = slices.Clone(c.InterpolateEnv)
+	cfg.Users = maps.Clone(c.Users)
+	cfg.Roles = maps.Clone(c.Roles)
+	return &cfg
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestStoreUpdateCopiesPolicy(t *testing.T) {
+	base := NewDefaultConfig()
+	store := NewStore(base)
+
+	updated := store.Update(func(cfg *ShellCommandConfig) {
+		cfg.AddAllowedCommand("git")
+		cfg.AllowedDirectories[0] = "/workspace"
+	})
+
+	if store.Load() != updated {
+		t.Error("Load() does not return the updated policy")
+	}
+	if !updated.IsCommandAllowed("git") || updated.AllowedDirectories[0] != "/workspace" {
+		t.Errorf("updated policy = %v %v, want git allowed in /workspace", updated.AllowCommands, updated.AllowedDirectories)
+	}
+	if base.IsCommandAllowed("git") || base.AllowedDirectories[0] != "/home" {
+		t.Errorf("base policy was modified: %v %v", base.AllowCommands, base.AllowedDirectories)
+	}
+
+	replaced := NewDefaultConfig()
+	if previous := store.Replace(replaced); previous != updated {
+		t.Error("Replace() did not return the previous policy")
+	}
+	if store.Load() != replaced {
+		t.Error("Load() does not return the replaced policy")
+	}
+}
+
+func TestAddAllowedCommandDoesNotShareBackingArray(t *testing.T) {
+	cfg := NewDefaultConfig()
+	cfg.AllowCommands = make([]AllowCommand, 1, 4)
+	cfg.AllowCommands[0] = AllowCommand{Command: "ls"}
+	held := cfg.AllowCommands
+
+	cfg.AddAllowedCommand("cat")
+
+	if extended := held[:2]; extended[1].Command == "cat" {
+		t.Error("AddAllowedCommand() appended into the backing array of the previous list")
+	}
+}
+
+// TestStoreConcurrentAccess is meaningful under the race detector (make test).
+func TestStoreConcurrentAccess(t *testing.T) {
+	store := NewStore(NewDefaultConfig())
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 200 {
+				cfg := store.Load()
+				for _, allowed := range cfg.AllowCommands {
+					_ = allowed.Command
+				}
+				_ = cfg.Directories()
+			}
+		}()
+	}
+	for i := range 50 {
+		store.Update(func(cfg *ShellCommandConfig) {
+			cfg.AddAllowedCommand("cmd" + string(rune('a'+i%26)))
+			cfg.MaxOutputSize++
+		})
+	}
+	wg.Wait()
+
+	if got := store.Load().MaxOutputSize; got != DefaultMaxOutputSize+50 {
+		t.Errorf("MaxOutputSize = %d, want %d", got, DefaultMaxOutputSize+50)
+	}
+}
@@ -0,0 +1,544 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/category"
+	"github.com/shimizu1995/secure-shell-server/pkg/cmdtemplate"
+)
+
+// Severity indicates how serious a ValidationIssue is.
+type Severity string
+
+const (
+	// SeverityError marks a configuration that cannot be used as written.
+	SeverityError Severity = "error"
+	// SeverityWarning marks a configuration that works but probably does not do what was intended.
+	SeverityWarning Severity = "warning"
+)
+
+// ValidationIssue describes a single problem found by Validate.
+type ValidationIssue struct {
+	Severity Severity
+	// Field is the JSON path of the offending setting, e.g. "allowCommands[2].subCommands[0]".
+	Field   string
+	Message string
+}
+
+// String formats the issue as "severity: field: message".
+func (i ValidationIssue) String() string {
+	if i.Field == "" {
+		return fmt.Sprintf("%s: %s", i.Severity, i.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", i.Severity, i.Field, i.Message)
+}
+
+// HasErrors reports whether any issue has SeverityError.
+func HasErrors(issues []ValidationIssue) bool {
+	return slices.ContainsFunc(issues, func(i ValidationIssue) bool { return i.Severity == SeverityError })
+}
+
+// Validate checks a configuration for contradictions, unreachable rules, and invalid values.
+// It reports every problem found rather than stopping at the first one.
+func Validate(cfg *ShellCommandConfig) []ValidationIssue {
+	v := &configValidator{}
+
+	v.checkDirectories(cfg.AllowedDirectories)
+	v.checkBinDirs(cfg)
+	denied := v.checkDenyCommands(cfg.DenyCommands)
+	v.checkAllowCommands(cfg.AllowCommands, denied)
+	v.checkBuiltins(cfg.Builtins)
+	v.checkCategories(cfg)
+	v.checkOverlays(cfg, denied)
+
+	for i, pattern := range cfg.Redaction.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			v.errorf(fmt.Sprintf("redaction.patterns[%d]", i), "invalid regular expression %q: %v", pattern, err)
+		}
+	}
+
+	if cfg.ReadOnlyOnly && !slices.ContainsFunc(cfg.AllowCommands, func(c AllowCommand) bool { return c.ReadOnly }) {
+		v.warnf("readOnlyOnly", "read-only mode is enabled but no allowed command is marked readOnly")
+	}
+
+	if cfg.MaxExecutionTime < 0 {
+		v.errorf("maxExecutionTime", "max execution time must not be negative: %d", cfg.MaxExecutionTime)
+	}
+	if cfg.IdleTimeout < 0 {
+		v.errorf("idleTimeout", "idle timeout must not be negative: %d", cfg.IdleTimeout)
+	} else if cfg.IdleTimeout > 0 && cfg.MaxExecutionTime > 0 && cfg.IdleTimeout >= cfg.MaxExecutionTime {
+		v.warnf("idleTimeout", "idle timeout has no effect because it is not shorter than maxExecutionTime (%ds)", cfg.MaxExecutionTime)
+	}
+	if cfg.MaxOutputSize < 0 {
+		v.errorf("maxOutputSize", "max output size must not be negative: %d", cfg.MaxOutputSize)
+	}
+	if cfg.ApprovalTimeout < 0 {
+		v.errorf("approvalTimeout", "approval timeout must not be negative: %d", cfg.ApprovalTimeout)
+	}
+	if slices.ContainsFunc(cfg.AllowCommands, func(c AllowCommand) bool { return c.ApprovalRequired }) &&
+		cfg.MaxExecutionTime > 0 && (cfg.ApprovalTimeout == 0 || cfg.ApprovalTimeout > cfg.MaxExecutionTime) {
+		v.warnf("approvalTimeout", "commands waiting for approval are denied after maxExecutionTime (%ds)", cfg.MaxExecutionTime)
+	}
+	if cfg.Risk.Threshold < 0 {
+		v.errorf("risk.threshold", "risk threshold must not be negative: %d", cfg.Risk.Threshold)
+	}
+	if cfg.Risk.Action != "" && cfg.Risk.Action != RiskActionApprove && cfg.Risk.Action != RiskActionDeny {
+		v.errorf("risk.action", "risk action must be %q or %q: %q", RiskActionApprove, RiskActionDeny, cfg.Risk.Action)
+	}
+	if err := cfg.ScriptLimits.check(); err != nil {
+		v.errorf("scriptLimits", "%v", err)
+	}
+	if err := cfg.Obfuscation.check(); err != nil {
+		v.errorf("obfuscation", "%v", err)
+	}
+	if err := cfg.Privileges.check(); err != nil {
+		v.errorf("privileges.keepCapabilities", "%v", err)
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(cfg.Templates)) {
+		if _, err := cmdtemplate.Parse(name, cfg.Templates[name]); err != nil {
+			v.errorf("templates."+name, "%v", err)
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(cfg.Rewrites)) {
+		to := cfg.Rewrites[name]
+		if err := checkRewrite(name, to); err != nil {
+			v.errorf("rewrites."+name, "%v", err)
+		} else if !cfg.IsCommandAllowed(filepath.Base(to[0])) {
+			v.warnf("rewrites."+name, "%q is not in allowCommands, but rewritten commands are not validated again", to[0])
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(cfg.Env)) {
+		v.checkEnv(name, cfg.Env[name])
+	}
+	if cfg.Secrets.CacheTTL < 0 {
+		v.errorf("secrets.cacheTtl", "secret cache duration must not be negative: %d", cfg.Secrets.CacheTTL)
+	}
+
+	if cfg.Alerts.FreezeSession && !slices.ContainsFunc(cfg.DenyCommands, func(d DenyCommand) bool { return d.Alert }) {
+		v.warnf("alerts.freezeSession", "sessions are never frozen because no deny rule is marked alert")
+	}
+
+	for i, sink := range cfg.Audit.Syslog {
+		if err := sink.Check(); err != nil {
+			v.errorf(fmt.Sprintf("audit.syslog[%d]", i), "%v", err)
+		}
+	}
+
+	if err := cfg.Auth.check(); err != nil {
+		v.errorf("auth", "%v", err)
+	}
+
+	if _, err := ParseDialect(cfg.Dialect); err != nil {
+		v.errorf("dialect", "%v", err)
+	}
+
+	switch cfg.EnforcementMode {
+	case "", EnforcementEnforcing:
+	case EnforcementPermissive:
+		v.warnf("enforcementMode", "permissive mode runs every command the policy denies")
+	default:
+		v.errorf("enforcementMode", "enforcement mode must be %q or %q: %q", EnforcementEnforcing, EnforcementPermissive, cfg.EnforcementMode)
+	}
+
+	switch cfg.InterpreterInput {
+	case "", InterpreterInputScan, InterpreterInputDeny, InterpreterInputAllow:
+	default:
+		v.errorf("interpreterInput", "interpreter input policy must be %q, %q, or %q: %q",
+			InterpreterInputScan, InterpreterInputDeny, InterpreterInputAllow, cfg.InterpreterInput)
+	}
+
+	switch cfg.ExecutionBackend {
+	case "", BackendLocal:
+	case BackendDocker:
+		if cfg.Docker.Image == "" {
+			v.errorf("docker.image", "an image is required for the docker execution backend")
+		}
+		if cfg.Landlock.Enabled {
+			v.warnf("landlock", "landlock has no effect on commands run by the docker execution backend")
+		}
+		if cfg.Seccomp.Enabled {
+			v.warnf("seccomp", "seccomp profiles have no effect on commands run by the docker execution backend")
+		}
+		if cfg.Cgroup.Enabled {
+			v.warnf("cgroup", "cgroup limits have no effect on commands run by the docker execution backend")
+		}
+		if cfg.DisableNetwork && cfg.Docker.Network != "" && cfg.Docker.Network != DefaultDockerNetwork {
+			v.warnf("disableNetwork", "containers of commands not marked allowNetwork get no network instead of docker.network %q", cfg.Docker.Network)
+		}
+	case BackendKubernetes:
+		if cfg.Kubernetes.Pod == "" {
+			v.errorf("kubernetes.pod", "a pod is required for the kubernetes execution backend")
+		}
+		if cfg.Landlock.Enabled {
+			v.warnf("landlock", "landlock has no effect on commands run by the kubernetes execution backend")
+		}
+		if cfg.Seccomp.Enabled {
+			v.warnf("seccomp", "seccomp profiles have no effect on commands run by the kubernetes execution backend")
+		}
+		if cfg.Cgroup.Enabled {
+			v.warnf("cgroup", "cgroup limits have no effect on commands run by the kubernetes execution backend")
+		}
+		if cfg.DisableNetwork {
+			v.warnf("disableNetwork", "commands run by the kubernetes execution backend share the network of the pod")
+		}
+	default:
+		v.errorf("executionBackend", "execution backend must be %q, %q, or %q: %q", BackendLocal, BackendDocker, BackendKubernetes, cfg.ExecutionBackend)
+	}
+
+	if cfg.Scratch.MaxSize < 0 {
+		v.errorf("scratch.maxSize", "scratch quota must not be negative: %d", cfg.Scratch.MaxSize)
+	}
+	if cfg.DisableNetwork && runtime.GOOS != "linux" && (cfg.ExecutionBackend == "" || cfg.ExecutionBackend == BackendLocal) {
+		v.warnf("disableNetwork", "network namespaces are only supported on Linux; every command not marked allowNetwork will fail")
+	}
+	networkCommands := slices.ContainsFunc(cfg.AllowCommands, func(c AllowCommand) bool { return c.AllowNetwork })
+	if !cfg.DisableNetwork && cfg.EgressProxy.URL == "" && networkCommands {
+		v.warnf("allowCommands", "allowNetwork has no effect because neither disableNetwork nor egressProxy is set")
+	}
+	if err := cfg.EgressProxy.check(); err != nil {
+		v.errorf("egressProxy", "%v", err)
+	}
+	if cfg.EgressProxy.URL != "" && !networkCommands {
+		v.warnf("egressProxy", "no command is marked allowNetwork, so no command is given the egress proxy")
+	}
+	if !cfg.AllowBackground && slices.Contains(cfg.Builtins.Allow, "wait") {
+		v.warnf("builtins.allow", "the wait builtin is denied because allowBackground is not set")
+	}
+	if err := cfg.Seccomp.check(); err != nil {
+		v.errorf("seccomp", "%v", err)
+	}
+	if cfg.Seccomp.Enabled && runtime.GOOS != "linux" && !cfg.Seccomp.BestEffort {
+		v.warnf("seccomp", "seccomp is only supported on Linux; every external command will fail unless bestEffort is set")
+	}
+	if err := cfg.Cgroup.check(); err != nil {
+		v.errorf("cgroup", "%v", err)
+	}
+	if cfg.Cgroup.Enabled && runtime.GOOS != "linux" && !cfg.Cgroup.BestEffort {
+		v.warnf("cgroup", "cgroups are only supported on Linux; every execution will fail unless bestEffort is set")
+	}
+	if !cfg.Cgroup.Enabled && (cfg.Cgroup.MemoryMax > 0 || cfg.Cgroup.CPUMax > 0 || cfg.Cgroup.PidsMax > 0) {
+		v.warnf("cgroup", "cgroup limits have no effect because cgroup.enabled is not set")
+	}
+	for i, allowed := range cfg.AllowCommands {
+		if _, ok := cfg.Seccomp.DeniedSyscalls(allowed.SeccompProfile); !ok {
+			v.errorf(fmt.Sprintf("allowCommands[%d].seccompProfile", i), "unknown seccomp profile %q", allowed.SeccompProfile)
+		}
+		if err := allowed.Hooks.check(); err != nil {
+			v.errorf(fmt.Sprintf("allowCommands[%d].hooks", i), "%v", err)
+		}
+		for _, name := range slices.Sorted(maps.Keys(allowed.Env)) {
+			if err := checkEnvVar(name, allowed.Env[name]); err != nil {
+				v.errorf(fmt.Sprintf("allowCommands[%d].env", i), "%v", err)
+			}
+		}
+	}
+	if cfg.Scratch.Tmpfs && runtime.GOOS != "linux" {
+		v.warnf("scratch.tmpfs", "tmpfs workspaces are only supported on Linux")
+	}
+	if cfg.OutputSpool.MaxSize < 0 {
+		v.errorf("outputSpool.maxSize", "spool size limit must not be negative: %d", cfg.OutputSpool.MaxSize)
+	}
+	if cfg.OutputSpool.Retention < 0 {
+		v.errorf("outputSpool.retention", "spool retention must not be negative: %d", cfg.OutputSpool.Retention)
+	}
+	if cfg.OutputSpool.Enabled && cfg.MaxOutputSize == 0 {
+		v.warnf("outputSpool", "output is never spooled because maxOutputSize is unlimited")
+	}
+	switch cfg.OutputSafety.Binary {
+	case "", BinaryPlaceholder, BinaryBase64, BinaryRaw:
+	default:
+		v.errorf("outputSafety.binary", "binary output handling must be %q, %q, or %q: %q",
+			BinaryPlaceholder, BinaryBase64, BinaryRaw, cfg.OutputSafety.Binary)
+	}
+	if !cfg.OutputSafety.Enabled && (cfg.OutputSafety.Binary != "" || cfg.OutputSafety.KeepEscapes) {
+		v.warnf("outputSafety", "output is not sanitized because outputSafety.enabled is not set")
+	}
+	if cfg.ResultCache.TTL < 0 || cfg.ResultCache.MaxEntries < 0 || cfg.ResultCache.MaxEntrySize < 0 {
+		v.errorf("resultCache", "result cache values must not be negative: %+v", cfg.ResultCache)
+	}
+	if cfg.ResultCache != (ResultCacheConfig{}) && !cfg.CachesResults() {
+		v.warnf("resultCache", "no results are cached because no allowCommands entry is marked cacheable")
+	}
+	if cfg.Snapshot.MaxSize < 0 {
+		v.errorf("snapshot.maxSize", "snapshot size limit must not be negative: %d", cfg.Snapshot.MaxSize)
+	}
+	if cfg.Snapshot.Retention < 0 {
+		v.errorf("snapshot.retention", "snapshot retention must not be negative: %d", cfg.Snapshot.Retention)
+	}
+	if err := cfg.Messages.check(); err != nil {
+		v.errorf("messages", "%v", err)
+	}
+	if cfg.Trash.TTL < 0 {
+		v.errorf("trash.ttl", "trash TTL must not be negative: %d", cfg.Trash.TTL)
+	}
+	if cfg.Trash.Enabled && cfg.Trash.Dir != "" {
+		for _, dir := range cfg.Directories() {
+			if rel, err := filepath.Rel(dir, cfg.Trash.Dir); err == nil && filepath.IsLocal(rel) {
+				v.warnf("trash.dir", "the trash %s is in the allowed directory %s, so commands can change deleted files", cfg.Trash.Dir, dir)
+				break
+			}
+		}
+	}
+	if cfg.Sessions.MaxConcurrent < 0 || cfg.Sessions.MaxCommands < 0 || cfg.Sessions.MaxLifetime < 0 {
+		v.errorf("sessions", "session limits must not be negative")
+	}
+	if cfg.Sessions.MaxConcurrent > 0 && cfg.Sessions.MaxLifetime == 0 {
+		v.warnf("sessions.maxConcurrent", "MCP sessions are only closed by maxLifetime, so without it MCP callers are refused once they have opened maxConcurrent sessions")
+	}
+	if err := cfg.Quota.check(); err != nil {
+		v.errorf("quota", "%v", err)
+	}
+	if cfg.FileAudit.MaxFiles < 0 {
+		v.errorf("fileAudit.maxFiles", "file audit limit must not be negative: %d", cfg.FileAudit.MaxFiles)
+	}
+	for i, pattern := range cfg.FileAudit.Exclude {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			v.errorf(fmt.Sprintf("fileAudit.exclude[%d]", i), "invalid pattern %q: %v", pattern, err)
+		}
+	}
+
+	return v.issues
+}
+
+// configValidator accumulates issues while a configuration is checked.
+type configValidator struct {
+	issues []ValidationIssue
+}
+
+func (v *configValidator) errorf(field, format string, args ...any) {
+	v.issues = append(v.issues, ValidationIssue{Severity: SeverityError, Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *configValidator) warnf(field, format string, args ...any) {
+	v.issues = append(v.issues, ValidationIssue{Severity: SeverityWarning, Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// checkDirectories requires at least one allowed directory and warns about ones that do not exist
+// and patterns that match none.
+func (v *configValidator) checkDirectories(dirs []string) {
+	if len(dirs) == 0 {
+		v.errorf("allowedDirectories", "at least one allowed directory is required")
+	}
+	for i, dir := range dirs {
+		field := fmt.Sprintf("allowedDirectories[%d]", i)
+		if dir == "" {
+			v.errorf(field, "allowed directory must not be empty")
+			continue
+		}
+		if isGlob(dir) {
+			if matches, err := filepath.Glob(dir); err != nil {
+				v.errorf(field, "invalid allowed directory pattern %q: %v", dir, err)
+			} else if len(matches) == 0 {
+				v.warnf(field, "allowed directory pattern %q matches no directory", dir)
+			}
+			continue
+		}
+		if _, err := os.Stat(dir); err != nil {
+			v.warnf(field, "allowed directory %q does not exist", dir)
+		}
+	}
+}
+
+// checkBinDirs requires absolute executable directories and warns about ones that do not exist.
+func (v *configValidator) checkBinDirs(cfg *ShellCommandConfig) {
+	for i, dir := range cfg.AllowedBinDirs {
+		field := fmt.Sprintf("allowedBinDirs[%d]", i)
+		if !filepath.IsAbs(dir) {
+			v.errorf(field, "executable directory must be an absolute path: %q", dir)
+			continue
+		}
+		if _, err := os.Stat(dir); err != nil {
+			v.warnf(field, "executable directory %q does not exist", dir)
+		}
+	}
+	if len(cfg.AllowedBinDirs) > 0 && (cfg.ExecutionBackend == BackendDocker || cfg.ExecutionBackend == BackendKubernetes) {
+		v.warnf("allowedBinDirs", "commands in containers are found through the container's PATH, not allowedBinDirs")
+	}
+}
+
+// checkDenyCommands validates deny entries and returns the set of command names denied
+// regardless of their arguments.
+func (v *configValidator) checkDenyCommands(commands []DenyCommand) map[string]bool {
+	denied := make(map[string]bool, len(commands))
+	seen := make(map[string]bool, len(commands))
+	for i, deny := range commands {
+		field := fmt.Sprintf("denyCommands[%d]", i)
+		key := deny.Command + "\x00" + strings.Join(deny.Args, "\x00")
+		switch {
+		case deny.Command == "":
+			v.errorf(field, "denied command name must not be empty")
+		case seen[key]:
+			v.warnf(field, "command %q is denied more than once", deny.Command)
+		case slices.Contains(deny.Args, ""):
+			v.errorf(field, "denied arguments must not be empty")
+		}
+		seen[key] = true
+		if len(deny.Args) == 0 {
+			denied[deny.Command] = true
+		}
+	}
+	return denied
+}
+
+// checkAllowCommands validates allow entries against each other and the deny list.
+func (v *configValidator) checkAllowCommands(commands []AllowCommand, denied map[string]bool) {
+	allowed := make(map[string]bool, len(commands))
+	for i, allow := range commands {
+		field := fmt.Sprintf("allowCommands[%d]", i)
+		switch {
+		case allow.Command == "":
+			v.errorf(field, "allowed command name must not be empty")
+		case allowed[allow.Command]:
+			// Only the first entry for a command is ever consulted
+			v.errorf(field, "command %q is allowed more than once", allow.Command)
+		case denied[allow.Command]:
+			v.errorf(field, "command %q is both allowed and denied", allow.Command)
+		}
+		allowed[allow.Command] = true
+
+		v.checkSubCommandLevel(field, allow.SubCommands, allow.DenySubCommands, nil)
+	}
+}
+
+// checkCategories rejects unknown categories and warns about allow rules that a denied category overrides.
+func (v *configValidator) checkCategories(cfg *ShellCommandConfig) {
+	for i, name := range cfg.DenyCategories {
+		if !category.Known(name) {
+			v.errorf(fmt.Sprintf("denyCategories[%d]", i), "unknown command category %q", name)
+		}
+	}
+	for i, name := range cfg.AllowCategories {
+		field := fmt.Sprintf("allowCategories[%d]", i)
+		switch {
+		case !category.Known(name):
+			v.errorf(field, "unknown command category %q", name)
+		case slices.Contains(cfg.DenyCategories, name):
+			v.errorf(field, "category %q is both allowed and denied", name)
+		}
+	}
+	for i, allow := range cfg.AllowCommands {
+		if c, ok := category.Match(allow.Command, cfg.DenyCategories); ok {
+			v.warnf(fmt.Sprintf("allowCommands[%d]", i), "command %q is unreachable because category %q is denied", allow.Command, c)
+		}
+	}
+}
+
+// checkOverlays rejects references to undefined roles and warns about overlay rules that
+// the base deny list makes unreachable.
+func (v *configValidator) checkOverlays(cfg *ShellCommandConfig, denied map[string]bool) {
+	check := func(field string, overlay PolicyOverlay) {
+		for i, allow := range overlay.AllowCommands {
+			if denied[allow.Command] {
+				v.warnf(fmt.Sprintf("%s.allowCommands[%d]", field, i), "command %q is unreachable because the base policy denies it", allow.Command)
+			}
+		}
+		for i, name := range overlay.AllowCategories {
+			if !category.Known(name) {
+				v.errorf(fmt.Sprintf("%s.allowCategories[%d]", field, i), "unknown command category %q", name)
+			}
+		}
+		for i, name := range overlay.DenyCategories {
+			if !category.Known(name) {
+				v.errorf(fmt.Sprintf("%s.denyCategories[%d]", field, i), "unknown command category %q", name)
+			}
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg.Roles)) {
+		role := cfg.Roles[name]
+		if len(role.Roles) > 0 {
+			v.errorf("roles."+name+".roles", "roles cannot be assigned to a role")
+		}
+		check("roles."+name, role)
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg.Users)) {
+		user := cfg.Users[name]
+		for i, role := range user.Roles {
+			if _, ok := cfg.Roles[role]; !ok {
+				v.errorf(fmt.Sprintf("users.%s.roles[%d]", name, i), "unknown role %q", role)
+			}
+		}
+		check("users."+name, user)
+	}
+}
+
+// checkSubCommandLevel looks for unreachable rules at one level of the subcommand tree and recurses.
+func (v *configValidator) checkSubCommandLevel(field string, subCommands []SubCommandRule, denySubCommands []string, denyFlags []string) {
+	// Deny flags are only checked once no further subcommand rules apply
+	if len(subCommands) > 0 && len(denyFlags) > 0 {
+		v.warnf(field+".denyFlags", "deny flags are never checked because subCommands are also set at this level")
+	}
+
+	for i, denied := range denySubCommands {
+		if !validSubCommandPattern(denied) {
+			v.errorf(fmt.Sprintf("%s.denySubCommands[%d]", field, i), "invalid subcommand pattern %q", denied)
+		}
+	}
+
+	names := make(map[string]bool, len(subCommands))
+	for i, rule := range subCommands {
+		ruleField := fmt.Sprintf("%s.subCommands[%d]", field, i)
+		switch {
+		case rule.Name == "":
+			v.errorf(ruleField, "subcommand name must not be empty")
+		case names[rule.Name]:
+			v.warnf(ruleField, "subcommand %q is listed more than once; only the first rule is used", rule.Name)
+		case slices.Contains(denySubCommands, rule.Name):
+			v.warnf(ruleField, "subcommand %q is unreachable because it is also denied", rule.Name)
+		case !validSubCommandPattern(rule.Name):
+			v.errorf(ruleField, "invalid subcommand pattern %q", rule.Name)
+		}
+		names[rule.Name] = true
+
+		v.checkSubCommandLevel(ruleField, rule.SubCommands, rule.DenySubCommands, rule.DenyFlags)
+	}
+}
+
+// validSubCommandPattern reports whether every word of a subcommand pattern is a valid glob.
+func validSubCommandPattern(pattern string) bool {
+	for _, word := range strings.Fields(pattern) {
+		if _, err := path.Match(word, ""); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// checkBuiltins warns about builtins that are both allowed and denied.
+func (v *configValidator) checkBuiltins(policy BuiltinPolicy) {
+	for i, name := range policy.Allow {
+		if slices.Contains(policy.Deny, name) {
+			v.warnf(fmt.Sprintf("builtins.allow[%d]", i), "builtin %q is unreachable because it is also denied", name)
+		}
+	}
+}
+
+// builtinSecretSchemes are the secret providers every runner has (see package secrets).
+var builtinSecretSchemes = []string{"file", "vault", "aws"}
+
+// checkEnv reports an env entry with an invalid name or secret reference, and warns about
+// references to providers that must be registered by the program embedding the runner.
+func (v *configValidator) checkEnv(name string, envVar EnvVar) {
+	field := "env." + name
+	if err := checkEnvVar(name, envVar); err != nil {
+		v.errorf(field, "%v", err)
+		return
+	}
+	if envVar.FromSecret == "" {
+		return
+	}
+	ref, _ := ParseSecretRef(envVar.FromSecret)
+	if !slices.Contains(builtinSecretSchemes, ref.Scheme) {
+		v.warnf(field, "secret provider %q is not built in and must be registered with runner.RegisterSecretProvider", ref.Scheme)
+	}
+}
@@ -0,0 +1,427 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name      string
+		cfg       ShellCommandConfig
+		want      []string
+		wantError bool
+	}{
+		{
+			name: "valid configuration",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				AllowCommands:      []AllowCommand{{Command: "ls"}},
+				DenyCommands:       []DenyCommand{{Command: "rm"}},
+			},
+		},
+		{
+			name: "allowed and denied",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				AllowCommands:      []AllowCommand{{Command: "rm"}},
+				DenyCommands:       []DenyCommand{{Command: "rm"}},
+			},
+			want:      []string{`error: allowCommands[0]: command "rm" is both allowed and denied`},
+			wantError: true,
+		},
+		{
+			name: "overlays",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				DenyCommands:       []DenyCommand{{Command: "rm"}},
+				Roles:              map[string]PolicyOverlay{"dev": {AllowCommands: []AllowCommand{{Command: "make"}, {Command: "rm"}}}},
+				Users:              map[string]PolicyOverlay{"alice": {Roles: []string{"dev", "admin"}}},
+			},
+			want: []string{
+				`warning: roles.dev.allowCommands[1]: command "rm" is unreachable because the base policy denies it`,
+				`error: users.alice.roles[1]: unknown role "admin"`,
+			},
+			wantError: true,
+		},
+		{
+			name: "allowNetwork without disableNetwork",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				AllowCommands:      []AllowCommand{{Command: "pip", AllowNetwork: true}},
+			},
+			want: []string{`warning: allowCommands: allowNetwork has no effect because neither disableNetwork nor egressProxy is set`},
+		},
+		{
+			name: "egressProxy without allowNetwork",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				AllowCommands:      []AllowCommand{{Command: "pip"}},
+				EgressProxy:        EgressProxyConfig{URL: "http://proxy.internal:3128"},
+			},
+			want: []string{`warning: egressProxy: no command is marked allowNetwork, so no command is given the egress proxy`},
+		},
+		{
+			name: "resultCache without cacheable commands",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				AllowCommands:      []AllowCommand{{Command: "git"}},
+				ResultCache:        ResultCacheConfig{TTL: 60},
+			},
+			want: []string{`warning: resultCache: no results are cached because no allowCommands entry is marked cacheable`},
+		},
+		{
+			name: "unknown seccomp profile",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				AllowCommands:      []AllowCommand{{Command: "gdb", SeccompProfile: "debug"}},
+			},
+			want:      []string{`error: allowCommands[0].seccompProfile: unknown seccomp profile "debug"`},
+			wantError: true,
+		},
+		{
+			name: "negative script limit",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				ScriptLimits:       ScriptLimitsConfig{MaxLoops: -1},
+			},
+			want:      []string{`error: scriptLimits: scriptLimits values must not be negative`},
+			wantError: true,
+		},
+		{
+			name: "unknown capability",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				Privileges:         PrivilegesConfig{KeepCapabilities: []string{"CAP_FOO"}},
+			},
+			want:      []string{`error: privileges.keepCapabilities: unknown capability "CAP_FOO"`},
+			wantError: true,
+		},
+		{
+			name: "jwt without key set",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				Auth:               AuthConfig{JWT: JWTConfig{Issuer: "https://issuer.example"}},
+			},
+			want:      []string{`error: auth: jwt requires jwksUrl`},
+			wantError: true,
+		},
+		{
+			name: "permissive mode",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				EnforcementMode:    EnforcementPermissive,
+			},
+			want: []string{`warning: enforcementMode: permissive mode runs every command the policy denies`},
+		},
+		{
+			name: "unknown enforcement mode",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				EnforcementMode:    "audit",
+			},
+			want:      []string{`error: enforcementMode: enforcement mode must be "enforcing" or "permissive": "audit"`},
+			wantError: true,
+		},
+		{
+			name: "wait without allowBackground",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				Builtins:           BuiltinPolicy{Allow: []string{"wait"}},
+			},
+			want: []string{`warning: builtins.allow: the wait builtin is denied because allowBackground is not set`},
+		},
+		{
+			name: "env",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				Env: map[string]EnvVar{
+					"API_TOKEN": {FromSecret: "gcp:projects/ci/secrets/token"},
+					"BAD-NAME":  {Value: "x"},
+					"STAGE":     {Value: "ci"},
+				},
+			},
+			want: []string{
+				`warning: env.API_TOKEN: secret provider "gcp" is not built in and must be registered with runner.RegisterSecretProvider`,
+				`error: env.BAD-NAME: invalid env variable name "BAD-NAME"`,
+			},
+			wantError: true,
+		},
+		{
+			name: "nonexistent directory",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir + "/missing"},
+			},
+			want: []string{`warning: allowedDirectories[0]: allowed directory "` + dir + `/missing" does not exist`},
+		},
+		{
+			name: "invalid hooks",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				AllowCommands:      []AllowCommand{{Command: "terraform", Hooks: RuleHooks{Post: []RuleHook{{}}}}},
+			},
+			want:      []string{"error: allowCommands[0].hooks: post hook 0 must set exactly one of command and callback"},
+			wantError: true,
+		},
+		{
+			name: "directory pattern matching nothing",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir, dir + "/*/missing"},
+			},
+			want: []string{`warning: allowedDirectories[1]: allowed directory pattern "` + dir + `/*/missing" matches no directory`},
+		},
+		{
+			name: "unreachable subcommand rules",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				AllowCommands: []AllowCommand{{
+					Command: "git",
+					SubCommands: []SubCommandRule{
+						{Name: "push", DenyFlags: []string{"-f"}, SubCommands: []SubCommandRule{{Name: "origin"}}},
+						{Name: "status"},
+						{Name: "status"},
+					},
+					DenySubCommands: []string{"push"},
+				}},
+			},
+			want: []string{
+				`warning: allowCommands[0].subCommands[0]: subcommand "push" is unreachable because it is also denied`,
+				"warning: allowCommands[0].subCommands[0].denyFlags: deny flags are never checked",
+				`warning: allowCommands[0].subCommands[2]: subcommand "status" is listed more than once`,
+			},
+		},
+		{
+			name: "invalid redaction pattern",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				Redaction:          RedactionConfig{Patterns: []string{"("}},
+			},
+			want:      []string{"error: redaction.patterns[0]: invalid regular expression"},
+			wantError: true,
+		},
+		{
+			name: "builtin allowed and denied",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				Builtins:           BuiltinPolicy{Allow: []string{"export"}, Deny: []string{"export"}},
+			},
+			want: []string{`warning: builtins.allow[0]: builtin "export" is unreachable because it is also denied`},
+		},
+		{
+			name: "command categories",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				AllowCommands:      []AllowCommand{{Command: "curl"}},
+				AllowCategories:    []string{"vcs", "games"},
+				DenyCategories:     []string{"network", "vcs"},
+			},
+			want: []string{
+				`error: allowCategories[0]: category "vcs" is both allowed and denied`,
+				`error: allowCategories[1]: unknown command category "games"`,
+				`warning: allowCommands[0]: command "curl" is unreachable because category "network" is denied`,
+			},
+			wantError: true,
+		},
+		{
+			name: "executable directories",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				AllowedBinDirs:     []string{dir, "bin", filepath.Join(dir, "missing")},
+			},
+			want: []string{
+				`error: allowedBinDirs[1]: executable directory must be an absolute path: "bin"`,
+				`warning: allowedBinDirs[2]: executable directory "` + filepath.Join(dir, "missing") + `" does not exist`,
+			},
+			wantError: true,
+		},
+		{
+			name: "read-only mode without read-only commands",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				AllowCommands:      []AllowCommand{{Command: "ls"}},
+				ReadOnlyOnly:       true,
+			},
+			want: []string{"warning: readOnlyOnly: read-only mode is enabled but no allowed command is marked readOnly"},
+		},
+		{
+			name: "approval wait exceeds execution time",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				AllowCommands:      []AllowCommand{{Command: "rm", ApprovalRequired: true}},
+				MaxExecutionTime:   60,
+				ApprovalTimeout:    120,
+			},
+			want: []string{"warning: approvalTimeout: commands waiting for approval are denied after maxExecutionTime (60s)"},
+		},
+		{
+			name: "negative approval timeout",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				AllowCommands:      []AllowCommand{{Command: "ls"}},
+				ApprovalTimeout:    -1,
+			},
+			want:      []string{"error: approvalTimeout: approval timeout must not be negative: -1"},
+			wantError: true,
+		},
+		{
+			name: "invalid risk action",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				AllowCommands:      []AllowCommand{{Command: "ls"}},
+				Risk:               RiskConfig{Threshold: 50, Action: "warn"},
+			},
+			want:      []string{`error: risk.action: risk action must be "approve" or "deny": "warn"`},
+			wantError: true,
+		},
+		{
+			name: "idle timeout not shorter than execution time",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				AllowCommands:      []AllowCommand{{Command: "ls"}},
+				MaxExecutionTime:   30,
+				IdleTimeout:        30,
+			},
+			want: []string{"warning: idleTimeout: idle timeout has no effect because it is not shorter than maxExecutionTime (30s)"},
+		},
+		{
+			name: "invalid template",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				AllowCommands:      []AllowCommand{{Command: "ls"}},
+				Templates:          map[string]string{"list": `ls "{{dir}}"`},
+			},
+			want:      []string{"error: templates.list: template list: placeholder inside quotes"},
+			wantError: true,
+		},
+		{
+			name: "rewrite to a command outside the allowlist",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				AllowCommands:      []AllowCommand{{Command: "rm"}},
+				Rewrites:           map[string][]string{"rm": {"/usr/bin/trash-put"}},
+			},
+			want: []string{`warning: rewrites.rm: "/usr/bin/trash-put" is not in allowCommands, but rewritten commands are not validated again`},
+		},
+		{
+			name: "trash in allowed directory",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				AllowCommands:      []AllowCommand{{Command: "rm"}},
+				Trash:              TrashConfig{Enabled: true, Dir: filepath.Join(dir, ".trash"), TTL: -1},
+			},
+			want: []string{
+				"error: trash.ttl: trash TTL must not be negative: -1",
+				"warning: trash.dir: the trash " + filepath.Join(dir, ".trash") + " is in the allowed directory " + dir + ", so commands can change deleted files",
+			},
+			wantError: true,
+		},
+		{
+			name: "empty message catalogue rule",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				AllowCommands:      []AllowCommand{{Command: "ls"}},
+				Messages:           MessagesConfig{Locale: "en", Catalog: map[string]map[string]string{"en": {"": "denied"}}},
+			},
+			want:      []string{"error: messages: messages.catalog.en rule names must not be empty"},
+			wantError: true,
+		},
+		{
+			name: "cgroup limits without cgroup",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				AllowCommands:      []AllowCommand{{Command: "ls"}},
+				Cgroup:             CgroupConfig{MemoryMax: 512},
+			},
+			want: []string{"warning: cgroup: cgroup limits have no effect because cgroup.enabled is not set"},
+		},
+		{
+			name: "unknown obfuscation pattern",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				AllowCommands:      []AllowCommand{{Command: "ls"}},
+				Obfuscation:        ObfuscationConfig{Action: ObfuscationActionDeny, Patterns: []string{"hex"}},
+			},
+			want:      []string{`error: obfuscation: invalid obfuscation pattern "hex"`},
+			wantError: true,
+		},
+		{
+			name: "freeze without alert rules",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				AllowCommands:      []AllowCommand{{Command: "cat"}},
+				DenyCommands:       []DenyCommand{{Command: "cat", Args: []string{"shadow"}}, {Command: "cat", Args: []string{""}}},
+				Alerts:             AlertConfig{FreezeSession: true},
+			},
+			want: []string{
+				"error: denyCommands[1]: denied arguments must not be empty",
+				"warning: alerts.freezeSession: sessions are never frozen because no deny rule is marked alert",
+			},
+			wantError: true,
+		},
+		{
+			name: "unknown dialect",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				AllowCommands:      []AllowCommand{{Command: "ls"}},
+				Dialect:            "zsh",
+			},
+			want:      []string{`error: dialect: unknown shell dialect "zsh"`},
+			wantError: true,
+		},
+		{
+			name: "docker backend without image",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				AllowCommands:      []AllowCommand{{Command: "ls"}},
+				ExecutionBackend:   BackendDocker,
+			},
+			want:      []string{"error: docker.image: an image is required for the docker execution backend"},
+			wantError: true,
+		},
+		{
+			name: "kubernetes backend",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				AllowCommands:      []AllowCommand{{Command: "ls"}},
+				ExecutionBackend:   BackendKubernetes,
+				Landlock:           LandlockConfig{Enabled: true},
+			},
+			want: []string{
+				"error: kubernetes.pod: a pod is required for the kubernetes execution backend",
+				"warning: landlock: landlock has no effect on commands run by the kubernetes execution backend",
+			},
+			wantError: true,
+		},
+		{
+			name: "negative scratch quota",
+			cfg: ShellCommandConfig{
+				AllowedDirectories: []string{dir},
+				AllowCommands:      []AllowCommand{{Command: "ls"}},
+				Scratch:            ScratchConfig{Enabled: true, MaxSize: -1},
+			},
+			want:      []string{"error: scratch.maxSize: scratch quota must not be negative: -1"},
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issues := Validate(&tt.cfg)
+
+			if len(issues) != len(tt.want) {
+				t.Fatalf("Validate() returned %d issues, want %d: %v", len(issues), len(tt.want), issues)
+			}
+			for i, want := range tt.want {
+				if !strings.HasPrefix(issues[i].String(), want) {
+					t.Errorf("issue %d = %q, want prefix %q", i, issues[i].String(), want)
+				}
+			}
+			if got := HasErrors(issues); got != tt.wantError {
+				t.Errorf("HasErrors() = %v, want %v", got, tt.wantError)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+// Package fileaudit finds the files a command created, modified, or deleted by scanning
+// directories before and after it runs, so that reviewers see the side effects of an
+// execution and not only the commands it ran.
+//
+// A file counts as modified when its type, permissions, size, or modification time changed.
+// Changes that keep all of these, such as rewriting a file within the resolution of the file
+// system's timestamps, go unnoticed.
+package fileaudit
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+// Kind is the kind of a change.
+type Kind string
+
+const (
+	// Created is a path that did not exist before the execution.
+	Created Kind = "created"
+	// Modified is a path whose type, permissions, size, or modification time changed.
+	Modified Kind = "modified"
+	// Deleted is a path that no longer exists.
+	Deleted Kind = "deleted"
+)
+
+// Change is a file changed by an execution.
+type Change struct {
+	// Path is absolute.
+	Path string `json:"path"`
+	Kind Kind   `json:"kind"`
+}
+
+// errLimit stops a scan once it has seen the most files it may.
+var errLimit = errors.New("file limit reached")
+
+// fileState is what a scan records of a file.
+type fileState struct {
+	mode    fs.FileMode
+	size    int64
+	modTime time.Time
+}
+
+// State is the state of the files in a set of directories at one point in time.
+type State struct {
+	files map[string]fileState
+	// Truncated is set when the directories held more files than the scan was allowed to see.
+	Truncated bool
+}
+
+// Scanner records the state of the files in a set of directories.
+type Scanner struct {
+	dirs     []string
+	maxFiles int
+	exclude  []string
+}
+
+// New returns a Scanner of dirs configured by cfg.
+func New(cfg config.FileAuditConfig, dirs []string) *Scanner {
+	maxFiles := cfg.MaxFiles
+	if maxFiles == 0 {
+		maxFiles = config.DefaultFileAuditMaxFiles
+	}
+	return &Scanner{dirs: dirs, maxFiles: maxFiles, exclude: cfg.Exclude}
+}
+
+// Scan records the current state of the files. Files that cannot be read are skipped, as a
+// command may be removing them while they are scanned.
+func (s *Scanner) Scan() *State {
+	state := &State{files: make(map[string]fileState)}
+	for _, dir := range s.dirs {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				// Unreadable directories are skipped along with their contents
+				return nil //nolint:nilerr // a partial scan is better than none
+			}
+			if path != dir && s.excluded(d.Name()) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if _, seen := state.files[path]; seen {
+				// Allowed directories may be nested
+				return nil
+			}
+			if len(state.files) >= s.maxFiles {
+				state.Truncated = true
+				return errLimit
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil //nolint:nilerr // the file was removed while scanning
+			}
+			state.files[path] = fileState{mode: info.Mode(), size: info.Size(), modTime: info.ModTime()}
+			return nil
+		})
+		if errors.Is(err, errLimit) {
+			break
+		}
+	}
+	return state
+}
+
+// excluded reports whether name matches one of the exclude patterns.
+func (s *Scanner) excluded(name string) bool {
+	for _, pattern := range s.exclude {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Diff returns the changes between before and after, ordered by path. Directories whose
+// only change is that their entries changed are not listed, and neither are the contents
+// of created or deleted directories. When either scan was truncated, files it did not see
+// are not reported as created or deleted.
+func Diff(before, after *State) []Change {
+	var changes []Change
+	for path, now := range after.files {
+		was, ok := before.files[path]
+		switch {
+		case !ok:
+			if !before.Truncated && !listedParent(before, after, path) {
+				changes = append(changes, Change{Path: path, Kind: Created})
+			}
+		case now.mode != was.mode:
+			changes = append(changes, Change{Path: path, Kind: Modified})
+		case now.mode.IsDir():
+			// A directory's size and time change with its entries, which are listed themselves
+		case now.size != was.size || !now.modTime.Equal(was.modTime):
+			changes = append(changes, Change{Path: path, Kind: Modified})
+		}
+	}
+	for path := range before.files {
+		if _, ok := after.files[path]; !ok && !after.Truncated && !listedParent(after, before, path) {
+			changes = append(changes, Change{Path: path, Kind: Deleted})
+		}
+	}
+	slices.SortFunc(changes, func(a, b Change) int { return strings.Compare(a.Path, b.Path) })
+	return changes
+}
+
+// listedParent reports whether path, which only in has, lies in a directory that only in
+// has too and that is therefore listed in its place.
+func listedParent(without, in *State, path string) bool {
+	parent := filepath.Dir(path)
+	_, inParent := in.files[parent]
+	_, withoutParent := without.files[parent]
+	return inParent && !withoutParent
+}
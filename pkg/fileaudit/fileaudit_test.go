@@ -0,0 +1,57 @@
+package fileaudit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+func TestDiff(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"kept.txt", "edited.txt", "removed.txt", "old/a.txt", "old/b.txt", ".git/HEAD"} {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+		assert.NoError(t, os.WriteFile(path, []byte("before"), 0o600))
+	}
+	scanner := New(config.FileAuditConfig{Exclude: []string{".git"}}, []string{dir})
+	before := scanner.Scan()
+
+	past := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "edited.txt"), []byte("after"), 0o600))
+	assert.NoError(t, os.Chtimes(filepath.Join(dir, "edited.txt"), past, past))
+	assert.NoError(t, os.Remove(filepath.Join(dir, "removed.txt")))
+	assert.NoError(t, os.RemoveAll(filepath.Join(dir, "old")))
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "new/sub"), 0o700))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "new/sub/c.txt"), nil, 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "created.txt"), nil, 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, ".git/HEAD"), []byte("excluded"), 0o600))
+
+	after := scanner.Scan()
+	assert.False(t, after.Truncated)
+	assert.Equal(t, []Change{
+		{Path: filepath.Join(dir, "created.txt"), Kind: Created},
+		{Path: filepath.Join(dir, "edited.txt"), Kind: Modified},
+		{Path: filepath.Join(dir, "new"), Kind: Created},
+		{Path: filepath.Join(dir, "old"), Kind: Deleted},
+		{Path: filepath.Join(dir, "removed.txt"), Kind: Deleted},
+	}, Diff(before, after))
+}
+
+func TestScan_MaxFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b", "c"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0o600))
+	}
+	scanner := New(config.FileAuditConfig{MaxFiles: 2}, []string{dir})
+	before := scanner.Scan()
+	assert.True(t, before.Truncated)
+
+	// Files beyond the limit are not reported as created or deleted
+	assert.NoError(t, os.Remove(filepath.Join(dir, "c")))
+	assert.Equal(t, 0, len(Diff(before, scanner.Scan())))
+}
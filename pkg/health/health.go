@@ -0,0 +1,151 @@
+// Package health serves liveness, readiness, and build information endpoints, so that
+// orchestrators only send traffic to a server that has loaded a valid policy and can sandbox
+// commands as the policy requires.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"slices"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/runner"
+)
+
+// Version is the version of the build, set with
+// -ldflags "-X github.com/shimizu1995/secure-shell-server/pkg/health.Version=v1.2.3".
+// When it is empty, the module version recorded by the Go toolchain is reported.
+var Version string
+
+// Source returns the policies a server has loaded by name, and the error of the last attempt
+// to load or reload them, if any.
+type Source func() (map[string]*config.ShellCommandConfig, error)
+
+// Static returns the Source of a server with a single policy loaded at startup.
+func Static(cfg *config.ShellCommandConfig) Source {
+	return func() (map[string]*config.ShellCommandConfig, error) {
+		return map[string]*config.ShellCommandConfig{"default": cfg}, nil
+	}
+}
+
+// Policy identifies a loaded policy.
+type Policy struct {
+	Name string `json:"name"`
+	// Hash is the policy's config.ShellCommandConfig.Hash.
+	Hash string `json:"hash"`
+}
+
+// Readiness is the body of the /readyz response.
+type Readiness struct {
+	Ready bool `json:"ready"`
+	// Reasons explains why the server is not ready.
+	Reasons []string `json:"reasons,omitempty"`
+	// ConfigError is the error of the last attempt to load the policies; the policies loaded
+	// before stay in effect.
+	ConfigError string                  `json:"configError,omitempty"`
+	Policies    []Policy                `json:"policies"`
+	Sandboxes   []runner.SandboxCheck   `json:"sandboxes,omitempty"`
+	KillSwitch  runner.KillSwitchStatus `json:"killSwitch"`
+}
+
+// BuildInfo is the body of the /version response.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"goVersion"`
+	// Revision, Time, and Modified describe the VCS commit the binary was built from, if known.
+	Revision string `json:"revision,omitempty"`
+	Time     string `json:"time,omitempty"`
+	Modified bool   `json:"modified,omitempty"`
+}
+
+// Register adds the endpoints to mux:
+//
+//	GET /healthz  reports that the process is serving requests
+//	GET /readyz   reports the loaded policies and sandboxes, with status 503 when not ready
+//	GET /version  reports build metadata
+//
+// The server is ready when a policy is loaded, every sandbox backend it requires is available,
+// and the kill switch is off.
+func Register(mux *http.ServeMux, source Source) {
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		readiness := Check(r.Context(), source)
+		status := http.StatusOK
+		if !readiness.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, readiness)
+	})
+	mux.HandleFunc("GET /version", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, Build())
+	})
+}
+
+// Check reports whether the server with the policies of source is ready.
+func Check(ctx context.Context, source Source) Readiness {
+	policies, err := source()
+	readiness := Readiness{Policies: []Policy{}, KillSwitch: runner.KillSwitch()}
+	if err != nil {
+		readiness.ConfigError = err.Error()
+	}
+	if len(policies) == 0 {
+		readiness.Reasons = append(readiness.Reasons, "no policy is loaded")
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(policies)) {
+		cfg := policies[name]
+		readiness.Policies = append(readiness.Policies, Policy{Name: name, Hash: cfg.Hash()})
+		for _, check := range runner.CheckSandboxes(ctx, cfg) {
+			// Policies sharing a backend report it once
+			if slices.Contains(readiness.Sandboxes, check) {
+				continue
+			}
+			readiness.Sandboxes = append(readiness.Sandboxes, check)
+			if check.Required && !check.Available {
+				readiness.Reasons = append(readiness.Reasons, "sandbox "+check.Name+" is unavailable: "+check.Error)
+			}
+		}
+	}
+
+	if readiness.KillSwitch.Disabled {
+		readiness.Reasons = append(readiness.Reasons, "command execution is disabled by the kill switch")
+	}
+	readiness.Ready = len(readiness.Reasons) == 0
+	return readiness
+}
+
+// Build returns the build metadata of the running binary.
+func Build() BuildInfo {
+	info := BuildInfo{Version: Version, GoVersion: runtime.Version()}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	if info.Version == "" {
+		info.Version = bi.Main.Version
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Revision = s.Value
+		case "vcs.time":
+			info.Time = s.Value
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+	return info
+}
+
+// writeJSON writes v as the JSON response body.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
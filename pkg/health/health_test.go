@@ -0,0 +1,86 @@
+package health_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/health"
+	"github.com/shimizu1995/secure-shell-server/pkg/runner"
+)
+
+// get serves a GET request for path with the endpoints of source and decodes the response into v.
+func get(t *testing.T, source health.Source, path string, v any) int {
+	t.Helper()
+	mux := http.NewServeMux()
+	health.Register(mux, source)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), v))
+	return rec.Code
+}
+
+func TestHealthz(t *testing.T) {
+	var body map[string]string
+	assert.Equal(t, http.StatusOK, get(t, health.Static(config.NewDefaultConfig()), "/healthz", &body))
+	assert.Equal(t, "ok", body["status"])
+}
+
+func TestReadyz(t *testing.T) {
+	cfg := config.NewDefaultConfig()
+
+	var readiness health.Readiness
+	assert.Equal(t, http.StatusOK, get(t, health.Static(cfg), "/readyz", &readiness))
+	assert.True(t, readiness.Ready)
+	assert.Equal(t, []health.Policy{{Name: "default", Hash: cfg.Hash()}}, readiness.Policies)
+
+	// A failed reload is reported while the policies loaded before keep the server ready
+	reloadFailed := func() (map[string]*config.ShellCommandConfig, error) {
+		return map[string]*config.ShellCommandConfig{"default": cfg}, errors.New("invalid policy")
+	}
+	readiness = health.Readiness{}
+	assert.Equal(t, http.StatusOK, get(t, reloadFailed, "/readyz", &readiness))
+	assert.Equal(t, "invalid policy", readiness.ConfigError)
+
+	noPolicies := func() (map[string]*config.ShellCommandConfig, error) { return nil, nil }
+	readiness = health.Readiness{}
+	assert.Equal(t, http.StatusServiceUnavailable, get(t, noPolicies, "/readyz", &readiness))
+	assert.False(t, readiness.Ready)
+	assert.Equal(t, []string{"no policy is loaded"}, readiness.Reasons)
+
+	runner.Disable("maintenance")
+	t.Cleanup(runner.Enable)
+	readiness = health.Readiness{}
+	assert.Equal(t, http.StatusServiceUnavailable, get(t, health.Static(cfg), "/readyz", &readiness))
+	assert.True(t, readiness.KillSwitch.Disabled)
+	assert.Equal(t, "maintenance", readiness.KillSwitch.Reason)
+}
+
+func TestReadyz_RequiredSandboxUnavailable(t *testing.T) {
+	cfg := config.NewDefaultConfig()
+	cfg.ExecutionBackend = config.BackendDocker
+	cfg.Docker.Host = "unix://" + t.TempDir() + "/docker.sock"
+
+	var readiness health.Readiness
+	assert.Equal(t, http.StatusServiceUnavailable, get(t, health.Static(cfg), "/readyz", &readiness))
+	assert.Equal(t, 1, len(readiness.Sandboxes))
+	assert.Equal(t, runner.SandboxDocker, readiness.Sandboxes[0].Name)
+	assert.False(t, readiness.Sandboxes[0].Available)
+}
+
+func TestVersion(t *testing.T) {
+	health.Version = "v1.2.3"
+	t.Cleanup(func() { health.Version = "" })
+
+	var info health.BuildInfo
+	assert.Equal(t, http.StatusOK, get(t, nil, "/version", &info))
+	assert.Equal(t, "v1.2.3", info.Version)
+	assert.Equal(t, runtime.Version(), info.GoVersion)
+}
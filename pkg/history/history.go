@@ -0,0 +1,196 @@
+// Package history stores a record of every command executed or denied in SQLite,
+// so that past activity can be searched during an investigation.
+//
+// Arguments are stored only as a hash, so the history does not retain secrets passed
+// on the command line; HashArgs computes the same hash for a known argument list.
+package history
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	// Registers the "sqlite3" database/sql driver
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// DefaultLimit is the number of entries Search returns when the filter sets no limit.
+const DefaultLimit = 100
+
+// busyTimeout is how long a write waits for another connection to release the database, in milliseconds.
+const busyTimeout = 5000
+
+// Decision is the policy outcome of a command.
+type Decision string
+
+const (
+	// DecisionAllowed means the command passed validation and ran.
+	DecisionAllowed Decision = "allowed"
+	// DecisionDenied means the command was rejected before it ran.
+	DecisionDenied Decision = "denied"
+	// DecisionWouldDeny means the policy denied the command, but it ran in permissive mode.
+	DecisionWouldDeny Decision = "would-deny"
+)
+
+// schema creates the history table and the indexes used by Search.
+const schema = `
+CREATE TABLE IF NOT EXISTS executions (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	time        INTEGER NOT NULL,
+	caller      TEXT    NOT NULL,
+	command     TEXT    NOT NULL,
+	args_hash   TEXT    NOT NULL,
+	work_dir    TEXT    NOT NULL,
+	decision    TEXT    NOT NULL,
+	exit_code   INTEGER NOT NULL,
+	duration_ns INTEGER NOT NULL,
+	message     TEXT    NOT NULL
+);
+CREATE INDEX IF NOT EXISTS executions_time ON executions (time);
+CREATE INDEX IF NOT EXISTS executions_command ON executions (command, time);
+CREATE INDEX IF NOT EXISTS executions_caller ON executions (caller, time);
+`
+
+// Entry is a single recorded command.
+type Entry struct {
+	// ID is assigned when the entry is recorded.
+	ID   int64
+	Time time.Time
+	// Caller identifies who ran the command, e.g. an MCP session or SSH key fingerprint.
+	Caller   string
+	Command  string
+	ArgsHash string
+	WorkDir  string
+	Decision Decision
+	// ExitCode is the command's exit status; it is 0 for denied commands.
+	ExitCode int
+	Duration time.Duration
+	// Message is the reason a command was denied.
+	Message string
+}
+
+// Filter selects entries in Search. Zero fields match everything.
+type Filter struct {
+	Command  string
+	Caller   string
+	ArgsHash string
+	Decision Decision
+	// Since and Until bound the time of the entries, inclusively.
+	Since time.Time
+	Until time.Time
+	// Limit is the maximum number of entries returned (DefaultLimit if zero).
+	Limit int
+}
+
+// History is an execution history backed by a SQLite database. It is safe for concurrent use.
+type History struct {
+	db *sql.DB
+}
+
+// Open opens or creates the history database at path.
+func Open(path string) (*History, error) {
+	dsn := fmt.Sprintf("file:%s?_busy_timeout=%d&_journal_mode=WAL", url.PathEscape(path), busyTimeout)
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history database: %w", err)
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize history database %s: %w", path, err)
+	}
+	return &History{db: db}, nil
+}
+
+// Close closes the database.
+func (h *History) Close() error {
+	return h.db.Close()
+}
+
+// HashArgs returns the hash under which an argument list is stored.
+func HashArgs(args []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(args, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// Record stores an entry, setting its time to now if it is zero.
+func (h *History) Record(ctx context.Context, e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	_, err := h.db.ExecContext(ctx,
+		`INSERT INTO executions (time, caller, command, args_hash, work_dir, decision, exit_code, duration_ns, message)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.Time.UnixNano(), e.Caller, e.Command, e.ArgsHash, e.WorkDir, string(e.Decision), e.ExitCode, int64(e.Duration), e.Message)
+	if err != nil {
+		return fmt.Errorf("failed to record history: %w", err)
+	}
+	return nil
+}
+
+// Search returns the entries matching f, newest first.
+func (h *History) Search(ctx context.Context, f Filter) ([]Entry, error) {
+	var where []string
+	var args []any
+	add := func(cond string, arg any) {
+		where = append(where, cond)
+		args = append(args, arg)
+	}
+	if f.Command != "" {
+		add("command = ?", f.Command)
+	}
+	if f.Caller != "" {
+		add("caller = ?", f.Caller)
+	}
+	if f.ArgsHash != "" {
+		add("args_hash = ?", f.ArgsHash)
+	}
+	if f.Decision != "" {
+		add("decision = ?", string(f.Decision))
+	}
+	if !f.Since.IsZero() {
+		add("time >= ?", f.Since.UnixNano())
+	}
+	if !f.Until.IsZero() {
+		add("time <= ?", f.Until.UnixNano())
+	}
+
+	query := "SELECT id, time, caller, command, args_hash, work_dir, decision, exit_code, duration_ns, message FROM executions"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY time DESC, id DESC LIMIT ?"
+	limit := f.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	args = append(args, limit)
+
+	rows, err := h.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search history: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var e Entry
+		var nanos, duration int64
+		var decision string
+		if err := rows.Scan(&e.ID, &nanos, &e.Caller, &e.Command, &e.ArgsHash, &e.WorkDir, &decision, &e.ExitCode, &duration, &e.Message); err != nil {
+			return nil, fmt.Errorf("failed to read history: %w", err)
+		}
+		e.Time = time.Unix(0, nanos)
+		e.Decision = Decision(decision)
+		e.Duration = time.Duration(duration)
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,79 @@
+package history
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func openTestHistory(t *testing.T) *History {
+	t.Helper()
+	h, err := Open(filepath.Join(t.TempDir(), "history dir", "..", "history.db"))
+	assert.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, h.Close()) })
+	return h
+}
+
+func TestRecordAndSearch(t *testing.T) {
+	h := openTestHistory(t)
+	ctx := t.Context()
+	base := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	entries := []Entry{
+		{Time: base, Caller: "alice", Command: "ls", ArgsHash: HashArgs([]string{"-la"}), WorkDir: "/tmp", Decision: DecisionAllowed, Duration: 5 * time.Millisecond},
+		{Time: base.Add(time.Minute), Caller: "bob", Command: "rm", ArgsHash: HashArgs([]string{"-rf", "/"}), WorkDir: "/tmp", Decision: DecisionDenied, Message: "rm is dangerous"},
+		{Time: base.Add(2 * time.Minute), Caller: "alice", Command: "cat", ArgsHash: HashArgs([]string{"missing"}), WorkDir: "/tmp", Decision: DecisionAllowed, ExitCode: 1},
+	}
+	for _, e := range entries {
+		assert.NoError(t, h.Record(ctx, e))
+	}
+
+	all, err := h.Search(ctx, Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(all))
+	// Newest first
+	assert.Equal(t, "cat", all[0].Command)
+	assert.Equal(t, 1, all[0].ExitCode)
+	assert.True(t, all[2].Time.Equal(base))
+	assert.Equal(t, 5*time.Millisecond, all[2].Duration)
+	assert.NotEqual(t, int64(0), all[2].ID)
+
+	denied, err := h.Search(ctx, Filter{Decision: DecisionDenied})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(denied))
+	assert.Equal(t, "bob", denied[0].Caller)
+	assert.Equal(t, "rm is dangerous", denied[0].Message)
+
+	byArgs, err := h.Search(ctx, Filter{ArgsHash: HashArgs([]string{"-rf", "/"})})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(byArgs))
+
+	alice, err := h.Search(ctx, Filter{Caller: "alice", Since: base.Add(time.Second)})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(alice))
+	assert.Equal(t, "cat", alice[0].Command)
+
+	limited, err := h.Search(ctx, Filter{Until: base.Add(time.Minute), Limit: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(limited))
+	assert.Equal(t, "rm", limited[0].Command)
+}
+
+func TestRecord_DefaultTime(t *testing.T) {
+	h := openTestHistory(t)
+	before := time.Now()
+	assert.NoError(t, h.Record(t.Context(), Entry{Command: "ls", Decision: DecisionAllowed}))
+
+	entries, err := h.Search(t.Context(), Filter{Command: "ls"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.False(t, entries[0].Time.Before(before))
+}
+
+func TestHashArgs(t *testing.T) {
+	assert.Equal(t, HashArgs([]string{"a", "b"}), HashArgs([]string{"a", "b"}))
+	assert.NotEqual(t, HashArgs([]string{"a", "b"}), HashArgs([]string{"a b"}))
+	assert.NotEqual(t, HashArgs([]string{"ab"}), HashArgs([]string{"a", "b"}))
+}
@@ -0,0 +1,67 @@
+// Package identity carries who requested an execution through a context, so that logs, audit
+// records, rate limits, and policies are attributed to the calling user, agent, and session.
+package identity
+
+import (
+	"context"
+	"strings"
+)
+
+// Identity describes the caller of an execution. Any field may be empty when the frontend
+// cannot tell it.
+type Identity struct {
+	// User is the authenticated user, such as "uid:1001" for a Unix socket peer or the key
+	// fingerprint of an SSH client.
+	User string `json:"user,omitempty"`
+	// Agent names the program or AI agent acting for the user.
+	Agent string `json:"agent,omitempty"`
+	// Session identifies the connection or session the request arrived on.
+	Session string `json:"session,omitempty"`
+}
+
+// contextKey is the key of the Identity stored in a context.
+type contextKey struct{}
+
+// WithIdentity returns a copy of ctx carrying id.
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the identity carried by ctx, if any.
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(contextKey{}).(Identity)
+	return id, ok && !id.IsZero()
+}
+
+// IsZero reports whether no field of id is set.
+func (id Identity) IsZero() bool {
+	return id == Identity{}
+}
+
+// Key returns the value that rate limits, history, and recordings are keyed by: the user when it
+// is known, otherwise the session, otherwise the agent.
+func (id Identity) Key() string {
+	switch {
+	case id.User != "":
+		return id.User
+	case id.Session != "":
+		return id.Session
+	default:
+		return id.Agent
+	}
+}
+
+// String formats the set fields as "user=... agent=... session=...".
+func (id Identity) String() string {
+	var parts []string
+	for _, field := range []struct{ name, value string }{
+		{"user", id.User},
+		{"agent", id.Agent},
+		{"session", id.Session},
+	} {
+		if field.value != "" {
+			parts = append(parts, field.name+"="+field.value)
+		}
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,42 @@
+package identity
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFromContext(t *testing.T) {
+	if _, ok := FromContext(context.Background()); ok {
+		t.Error("FromContext() of an empty context reported an identity")
+	}
+	if _, ok := FromContext(WithIdentity(context.Background(), Identity{})); ok {
+		t.Error("FromContext() reported a zero identity")
+	}
+
+	want := Identity{User: "uid:1001", Agent: "claude", Session: "abc"}
+	got, ok := FromContext(WithIdentity(context.Background(), want))
+	if !ok || got != want {
+		t.Errorf("FromContext() = %+v, %v, want %+v, true", got, ok, want)
+	}
+}
+
+func TestIdentity_KeyAndString(t *testing.T) {
+	tests := []struct {
+		id      Identity
+		wantKey string
+		wantStr string
+	}{
+		{Identity{User: "uid:1001", Agent: "claude", Session: "abc"}, "uid:1001", "user=uid:1001 agent=claude session=abc"},
+		{Identity{Agent: "claude", Session: "abc"}, "abc", "agent=claude session=abc"},
+		{Identity{Agent: "claude"}, "claude", "agent=claude"},
+		{Identity{}, "", ""},
+	}
+	for _, tt := range tests {
+		if got := tt.id.Key(); got != tt.wantKey {
+			t.Errorf("%+v.Key() = %q, want %q", tt.id, got, tt.wantKey)
+		}
+		if got := tt.id.String(); got != tt.wantStr {
+			t.Errorf("%+v.String() = %q, want %q", tt.id, got, tt.wantStr)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+// Package jobs runs scripts asynchronously on a bounded worker pool.
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/runner"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+// Default pool settings used when Options leaves them unset.
+const (
+	DefaultWorkers         = 4
+	DefaultQueueSize       = 64
+	DefaultMaxFinishedJobs = 100
+)
+
+// jobIDBytes is the number of random bytes in a JobID.
+const jobIDBytes = 16
+
+var (
+	// ErrQueueFull is returned by Submit when no more jobs can be queued.
+	ErrQueueFull = errors.New("job queue is full")
+	// ErrJobNotFound is returned when a JobID is unknown or has been evicted.
+	ErrJobNotFound = errors.New("job not found")
+	// ErrQueueClosed is returned by Submit after Close has been called.
+	ErrQueueClosed = errors.New("job queue is closed")
+)
+
+// JobID identifies a submitted job.
+type JobID string
+
+// State is the lifecycle state of a job.
+type State string
+
+// Job states.
+const (
+	StateQueued    State = "queued"
+	StateRunning   State = "running"
+	StateSucceeded State = "succeeded"
+	StateFailed    State = "failed"
+	StateCanceled  State = "canceled"
+)
+
+// Done reports whether the state is terminal.
+func (s State) Done() bool {
+	return s == StateSucceeded || s == StateFailed || s == StateCanceled
+}
+
+// Status describes the current state of a job.
+type Status struct {
+	ID          JobID
+	State       State
+	Script      string
+	WorkingDir  string
+	SubmittedAt time.Time
+	StartedAt   time.Time
+	FinishedAt  time.Time
+	// Err is the execution error for failed jobs.
+	Err error
+}
+
+// Output holds the output captured from a job so far.
+type Output struct {
+	Stdout          string
+	Stderr          string
+	StdoutTruncated bool
+	StderrTruncated bool
+}
+
+// Options configures a Queue.
+type Options struct {
+	// Workers is the number of jobs executed concurrently.
+	Workers int
+	// QueueSize is the number of jobs that may wait for a worker.
+	QueueSize int
+	// MaxFinishedJobs is the number of finished jobs retained for Status and Output.
+	MaxFinishedJobs int
+	// WorkingDir is the directory used by Submit.
+	WorkingDir string
+}
+
+// Queue executes submitted scripts on a bounded pool of workers.
+type Queue struct {
+	config    *config.ShellCommandConfig
+	validator *validator.CommandValidator
+	logger    *logger.Logger
+	opts      Options
+
+	mu       sync.Mutex
+	jobs     map[JobID]*job
+	finished []JobID
+	closed   bool
+
+	pending chan *job
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// job is the internal record of a submitted script.
+type job struct {
+	status Status
+	cancel context.CancelFunc
+	stdout syncBuffer
+	stderr syncBuffer
+	// truncation flags are set once the job finishes
+	stdoutTruncated bool
+	stderrTruncated bool
+}
+
+// NewQueue creates a Queue and starts its workers.
+func NewQueue(cfg *config.ShellCommandConfig, v *validator.CommandValidator, log *logger.Logger, opts Options) *Queue {
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultWorkers
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+	if opts.MaxFinishedJobs <= 0 {
+		opts.MaxFinishedJobs = DefaultMaxFinishedJobs
+	}
+	if opts.WorkingDir == "" {
+		opts.WorkingDir = cfg.DefaultDirectory()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &Queue{
+		config:    cfg,
+		validator: v,
+		logger:    log,
+		opts:      opts,
+		jobs:      make(map[JobID]*job),
+		pending:   make(chan *job, opts.QueueSize),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+
+	for range opts.Workers {
+		q.wg.Add(1)
+		go q.worker()
+	}
+
+	return q
+}
+
+// Submit queues a script for execution in the queue's default working directory.
+func (q *Queue) Submit(script string) (JobID, error) {
+	return q.SubmitInDir(script, q.opts.WorkingDir)
+}
+
+// SubmitInDir queues a script for execution in the given working directory.
+func (q *Queue) SubmitInDir(script, workingDir string) (JobID, error) {
+	id, err := newJobID()
+	if err != nil {
+		return "", err
+	}
+
+	j := &job{status: Status{
+		ID:          id,
+		State:       StateQueued,
+		Script:      script,
+		WorkingDir:  workingDir,
+		SubmittedAt: time.Now(),
+	}}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return "", ErrQueueClosed
+	}
+
+	select {
+	case q.pending <- j:
+	default:
+		return "", ErrQueueFull
+	}
+
+	q.jobs[id] = j
+	q.logger.LogInfof("Job %s queued: %s", id, script)
+	return id, nil
+}
+
+// Status returns the current status of a job.
+func (q *Queue) Status(id JobID) (Status, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	j, ok := q.jobs[id]
+	if !ok {
+		return Status{}, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	return j.status, nil
+}
+
+// Output returns the output a job has produced so far.
+func (q *Queue) Output(id JobID) (Output, error) {
+	q.mu.Lock()
+	j, ok := q.jobs[id]
+	if !ok {
+		q.mu.Unlock()
+		return Output{}, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	out := Output{StdoutTruncated: j.stdoutTruncated, StderrTruncated: j.stderrTruncated}
+	q.mu.Unlock()
+
+	out.Stdout = j.stdout.String()
+	out.Stderr = j.stderr.String()
+	return out, nil
+}
+
+// Cancel stops a queued or running job. Canceling a finished job is a no-op.
+func (q *Queue) Cancel(id JobID) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	j, ok := q.jobs[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+
+	switch j.status.State {
+	case StateQueued:
+		// The worker skips jobs that were canceled while waiting
+		q.finishLocked(j, StateCanceled, context.Canceled)
+	case StateRunning:
+		j.cancel()
+	case StateSucceeded, StateFailed, StateCanceled:
+	}
+	return nil
+}
+
+// Close cancels all queued and running jobs and waits for the workers to exit.
+func (q *Queue) Close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	close(q.pending)
+	q.mu.Unlock()
+
+	q.cancel()
+	q.wg.Wait()
+}
+
+// worker executes jobs until the pending channel is closed.
+func (q *Queue) worker() {
+	defer q.wg.Done()
+	for j := range q.pending {
+		q.run(j)
+	}
+}
+
+// run executes a single job and records its result.
+func (q *Queue) run(j *job) {
+	q.mu.Lock()
+	if j.status.State != StateQueued {
+		q.mu.Unlock()
+		return
+	}
+	if q.ctx.Err() != nil {
+		q.finishLocked(j, StateCanceled, q.ctx.Err())
+		q.mu.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(q.ctx)
+	defer cancel()
+	j.cancel = cancel
+	j.status.State = StateRunning
+	j.status.StartedAt = time.Now()
+	q.mu.Unlock()
+
+	r := runner.New(q.config, q.validator, q.logger)
+	r.SetOutputs(&j.stdout, &j.stderr)
+	result := r.RunCommand(ctx, j.status.Script, j.status.WorkingDir)
+	if result.Snapshot != nil {
+		// Jobs have no one to commit a snapshot, so with snapshot enabled they are dry runs
+		if err := result.Snapshot.Discard(); err != nil {
+			q.logger.LogErrorf("Failed to discard snapshot %s: %v", result.Snapshot.ID, err)
+		}
+	}
+	stdoutTruncated, stderrTruncated := r.GetTruncationStatus()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	j.stdoutTruncated = stdoutTruncated
+	j.stderrTruncated = stderrTruncated
+	switch {
+	case ctx.Err() != nil && errors.Is(ctx.Err(), context.Canceled):
+		q.finishLocked(j, StateCanceled, result.Err)
+	case result.Err != nil:
+		q.finishLocked(j, StateFailed, result.Err)
+	default:
+		q.finishLocked(j, StateSucceeded, nil)
+	}
+}
+
+// finishLocked marks a job as finished and evicts the oldest finished jobs beyond the retention limit.
+// q.mu must be held.
+func (q *Queue) finishLocked(j *job, state State, err error) {
+	j.status.State = state
+	j.status.Err = err
+	j.status.FinishedAt = time.Now()
+	q.logger.LogInfof("Job %s %s", j.status.ID, state)
+
+	q.finished = append(q.finished, j.status.ID)
+	for len(q.finished) > q.opts.MaxFinishedJobs {
+		delete(q.jobs, q.finished[0])
+		q.finished = q.finished[1:]
+	}
+}
+
+// newJobID returns a random job identifier.
+func newJobID() (JobID, error) {
+	b := make([]byte, jobIDBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate job ID: %w", err)
+	}
+	return JobID(hex.EncodeToString(b)), nil
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent writes and reads.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// Write implements the io.Writer interface.
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// String returns the buffered contents.
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,138 @@
+package jobs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+func newTestQueue(t *testing.T, opts Options) *Queue {
+	t.Helper()
+	cfg := config.NewDefaultConfig()
+	cfg.AllowedDirectories = []string{t.TempDir()}
+	cfg.AddAllowedCommand("sleep")
+	log := logger.New()
+	q := NewQueue(cfg, validator.New(cfg, log), log, opts)
+	t.Cleanup(q.Close)
+	return q
+}
+
+// waitForState polls until the job reaches a terminal state or the timeout elapses.
+func waitForState(t *testing.T, q *Queue, id JobID, want State) Status {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		st, err := q.Status(id)
+		assert.NoError(t, err)
+		if st.State == want {
+			return st
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not reach state %s", id, want)
+	return Status{}
+}
+
+func TestQueue_SubmitSucceeds(t *testing.T) {
+	q := newTestQueue(t, Options{})
+
+	id, err := q.Submit("echo hello")
+	assert.NoError(t, err)
+
+	st := waitForState(t, q, id, StateSucceeded)
+	assert.NoError(t, st.Err)
+	assert.False(t, st.FinishedAt.IsZero())
+
+	out, err := q.Output(id)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello\n", out.Stdout)
+}
+
+func TestQueue_SubmitFails(t *testing.T) {
+	q := newTestQueue(t, Options{})
+
+	id, err := q.Submit("rm -rf /")
+	assert.NoError(t, err)
+
+	st := waitForState(t, q, id, StateFailed)
+	assert.Error(t, st.Err)
+}
+
+func TestQueue_CancelRunning(t *testing.T) {
+	q := newTestQueue(t, Options{Workers: 1})
+
+	id, err := q.Submit("sleep 10")
+	assert.NoError(t, err)
+	waitForState(t, q, id, StateRunning)
+
+	assert.NoError(t, q.Cancel(id))
+	waitForState(t, q, id, StateCanceled)
+}
+
+func TestQueue_CancelQueued(t *testing.T) {
+	q := newTestQueue(t, Options{Workers: 1})
+
+	running, err := q.Submit("sleep 10")
+	assert.NoError(t, err)
+	waitForState(t, q, running, StateRunning)
+
+	queued, err := q.Submit("echo never")
+	assert.NoError(t, err)
+	assert.NoError(t, q.Cancel(queued))
+	waitForState(t, q, queued, StateCanceled)
+
+	assert.NoError(t, q.Cancel(running))
+}
+
+func TestQueue_Full(t *testing.T) {
+	q := newTestQueue(t, Options{Workers: 1, QueueSize: 1})
+
+	running, err := q.Submit("sleep 10")
+	assert.NoError(t, err)
+	waitForState(t, q, running, StateRunning)
+
+	_, err = q.Submit("echo queued")
+	assert.NoError(t, err)
+
+	_, err = q.Submit("echo rejected")
+	assert.True(t, errors.Is(err, ErrQueueFull))
+}
+
+func TestQueue_UnknownJob(t *testing.T) {
+	q := newTestQueue(t, Options{})
+
+	_, err := q.Status("missing")
+	assert.True(t, errors.Is(err, ErrJobNotFound))
+	_, err = q.Output("missing")
+	assert.True(t, errors.Is(err, ErrJobNotFound))
+	assert.True(t, errors.Is(q.Cancel("missing"), ErrJobNotFound))
+}
+
+func TestQueue_EvictsFinishedJobs(t *testing.T) {
+	q := newTestQueue(t, Options{Workers: 1, MaxFinishedJobs: 1})
+
+	first, err := q.Submit("echo one")
+	assert.NoError(t, err)
+	waitForState(t, q, first, StateSucceeded)
+
+	second, err := q.Submit("echo two")
+	assert.NoError(t, err)
+	waitForState(t, q, second, StateSucceeded)
+
+	_, err = q.Status(first)
+	assert.True(t, errors.Is(err, ErrJobNotFound))
+}
+
+func TestQueue_SubmitAfterClose(t *testing.T) {
+	q := newTestQueue(t, Options{})
+	q.Close()
+
+	_, err := q.Submit("echo hello")
+	assert.True(t, errors.Is(err, ErrQueueClosed))
+}
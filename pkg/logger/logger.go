@@ -6,12 +6,17 @@ import (
 	"log"
 	"os"
 	"time"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/redact"
 )
 
 // Logger provides logging functionality.
 type Logger struct {
-	logger *log.Logger
-	file   *os.File
+	logger   *log.Logger
+	file     *os.File
+	redactor *redact.Redactor
+	// prefix, when set, is written in brackets after the level of every entry
+	prefix string
 }
 
 // New creates a new logger with no output.
@@ -49,6 +54,33 @@ func NewWithWriter(w io.Writer) *Logger {
 	}
 }
 
+// SetRedactor sets the redactor applied to every log entry before it is written.
+func (l *Logger) SetRedactor(redactor *redact.Redactor) {
+	l.redactor = redactor
+}
+
+// With returns a logger writing to the same destination whose entries are marked with prefix,
+// e.g. the identity of the caller, in place of any prefix of l. Closing it does not close l.
+func (l *Logger) With(prefix string) *Logger {
+	clone := *l
+	clone.file = nil
+	clone.prefix = prefix
+	return &clone
+}
+
+// tag returns the prefix of an entry, including its trailing space.
+func (l *Logger) tag() string {
+	if l.prefix == "" {
+		return ""
+	}
+	return "[" + l.prefix + "] "
+}
+
+// printf formats and writes a log entry, masking any secrets it contains.
+func (l *Logger) printf(format string, args ...interface{}) {
+	l.logger.Print(l.redactor.Redact(fmt.Sprintf(format, args...)))
+}
+
 // LogCommandAttempt logs an attempted command execution.
 func (l *Logger) LogCommandAttempt(cmd string, args []string, allowed bool) {
 	status := "ALLOWED"
@@ -57,33 +89,52 @@ func (l *Logger) LogCommandAttempt(cmd string, args []string, allowed bool) {
 	}
 
 	timestamp := time.Now().Format(time.RFC3339)
-	l.logger.Printf("%s [%s] Command: %s %v\n", timestamp, status, cmd, args)
+	l.printf("%s [%s] %sCommand: %s %v\n", timestamp, status, l.tag(), cmd, args)
+}
+
+// LogCommandWouldBlock logs a command the policy denies that runs in permissive mode.
+func (l *Logger) LogCommandWouldBlock(cmd string, args []string, message string) {
+	timestamp := time.Now().Format(time.RFC3339)
+	l.printf("%s [WOULD BLOCK] %sCommand: %s %v, %s\n", timestamp, l.tag(), cmd, args, message)
+}
+
+// LogCommandRewrite logs an allowed command that a rewrite rule replaced, with the words it
+// runs as.
+func (l *Logger) LogCommandRewrite(cmd string, args []string, rewritten []string) {
+	timestamp := time.Now().Format(time.RFC3339)
+	l.printf("%s [REWRITTEN] %sCommand: %s %v, runs as %v\n", timestamp, l.tag(), cmd, args, rewritten)
+}
+
+// LogCommandMetrics logs resource usage of an executed command.
+func (l *Logger) LogCommandMetrics(cmd string, args []string, summary string) {
+	timestamp := time.Now().Format(time.RFC3339)
+	l.printf("%s [METRICS] %sCommand: %s %v, %s\n", timestamp, l.tag(), cmd, args, summary)
 }
 
 // LogErrorf logs an error with formatted message.
 func (l *Logger) LogErrorf(format string, args ...interface{}) {
 	timestamp := time.Now().Format(time.RFC3339)
 	message := fmt.Sprintf(format, args...)
-	l.logger.Printf("%s [ERROR] %s\n", timestamp, message)
+	l.printf("%s [ERROR] %s%s\n", timestamp, l.tag(), message)
 }
 
 // LogError logs an error message.
 func (l *Logger) LogError(message string) {
 	timestamp := time.Now().Format(time.RFC3339)
-	l.logger.Printf("%s [ERROR] %s\n", timestamp, message)
+	l.printf("%s [ERROR] %s%s\n", timestamp, l.tag(), message)
 }
 
 // LogInfof logs an informational message with formatting.
 func (l *Logger) LogInfof(format string, args ...interface{}) {
 	timestamp := time.Now().Format(time.RFC3339)
 	message := fmt.Sprintf(format, args...)
-	l.logger.Printf("%s [INFO] %s\n", timestamp, message)
+	l.printf("%s [INFO] %s%s\n", timestamp, l.tag(), message)
 }
 
 // LogInfo logs an informational message.
 func (l *Logger) LogInfo(message string) {
 	timestamp := time.Now().Format(time.RFC3339)
-	l.logger.Printf("%s [INFO] %s\n", timestamp, message)
+	l.printf("%s [INFO] %s%s\n", timestamp, l.tag(), message)
 }
 
 // Close closes the logger's file if it exists.
@@ -6,6 +6,9 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/redact"
 )
 
 func TestLogger_LogCommandAttempt(t *testing.T) {
@@ -136,6 +139,42 @@ func TestLogger_LogInfof(t *testing.T) {
 	}
 }
 
+func TestLogger_LogCommandMetrics(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := NewWithWriter(buf)
+
+	logger.LogCommandMetrics("ls", []string{"-l"}, "wall=1ms user=0s sys=0s maxrss=1024KiB exit=0")
+
+	want := "[METRICS] Command: ls [-l], wall=1ms user=0s sys=0s maxrss=1024KiB exit=0"
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("LogCommandMetrics() output = %v, want to contain %v", buf.String(), want)
+	}
+}
+
+func TestLogger_LogCommandWouldBlock(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := NewWithWriter(buf)
+
+	logger.LogCommandWouldBlock("rm", []string{"-rf", "build"}, "rm is not allowed")
+
+	want := "[WOULD BLOCK] Command: rm [-rf build], rm is not allowed"
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("LogCommandWouldBlock() output = %v, want to contain %v", buf.String(), want)
+	}
+}
+
+func TestLogger_LogCommandRewrite(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := NewWithWriter(buf)
+
+	logger.LogCommandRewrite("rm", []string{"-rf", "build"}, []string{"trash-put", "-rf", "build"})
+
+	want := "[REWRITTEN] Command: rm [-rf build], runs as [trash-put -rf build]"
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("LogCommandRewrite() output = %v, want to contain %v", buf.String(), want)
+	}
+}
+
 func TestNewWithPath(t *testing.T) {
 	// Create a temporary directory for test files
 	tmpDir := t.TempDir()
@@ -233,3 +272,46 @@ func TestNew_NoOutput(_ *testing.T) {
 
 	// If we reached here without errors, the test passed
 }
+
+func TestLogger_SetRedactor(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := NewWithWriter(buf)
+
+	redactor, err := redact.New(config.RedactionConfig{Enabled: true})
+	if err != nil {
+		t.Fatalf("redact.New() error = %v", err)
+	}
+	logger.SetRedactor(redactor)
+
+	logger.LogCommandAttempt("curl", []string{"-H", "Authorization: Bearer secret-token"}, true)
+	if strings.Contains(buf.String(), "secret-token") {
+		t.Errorf("LogCommandAttempt() output = %v, want token to be redacted", buf.String())
+	}
+	if !strings.Contains(buf.String(), "Bearer [REDACTED]") {
+		t.Errorf("LogCommandAttempt() output = %v, want to contain redaction marker", buf.String())
+	}
+}
+
+func TestLogger_With(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := NewWithWriter(buf)
+	derived := logger.With("user=uid:1001").With("user=alice session=s1")
+
+	derived.LogCommandAttempt("ls", []string{"-l"}, true)
+	derived.LogInfof("started %d", 1)
+	logger.LogInfo("plain")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want 3: %q", len(lines), buf.String())
+	}
+	if !strings.Contains(lines[0], "[ALLOWED] [user=alice session=s1] Command: ls [-l]") {
+		t.Errorf("LogCommandAttempt() output = %v, want the prefix of the derived logger", lines[0])
+	}
+	if !strings.Contains(lines[1], "[INFO] [user=alice session=s1] started 1") {
+		t.Errorf("LogInfof() output = %v, want the prefix of the derived logger", lines[1])
+	}
+	if strings.Contains(lines[2], "user=") {
+		t.Errorf("LogInfo() output = %v, want no prefix from the original logger", lines[2])
+	}
+}
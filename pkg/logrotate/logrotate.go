@@ -0,0 +1,251 @@
+// Package logrotate provides a file writer that rotates the file once it reaches
+// a maximum size, keeping a bounded number of optionally compressed backups.
+package logrotate
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// backupTimeFormat is embedded in backup file names; it sorts lexically and is valid on all platforms.
+	backupTimeFormat = "2006-01-02T15-04-05.000"
+	// compressSuffix is appended to compressed backups.
+	compressSuffix = ".gz"
+	// filePermissions are used for the log file and its backups.
+	filePermissions = 0o644
+)
+
+// Options configures rotation. Zero values disable the corresponding limit.
+type Options struct {
+	// MaxSize is the size in bytes at which the file is rotated.
+	MaxSize int64
+	// MaxBackups is the number of rotated files to keep.
+	MaxBackups int
+	// MaxAge is how long rotated files are kept.
+	MaxAge time.Duration
+	// Compress gzips rotated files.
+	Compress bool
+}
+
+// Writer appends to a file and rotates it according to its Options.
+// It is safe for concurrent use.
+type Writer struct {
+	path string
+	opts Options
+	now  func() time.Time
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// New creates a Writer for path. The file is opened on the first write.
+func New(path string, opts Options) *Writer {
+	return &Writer{path: path, opts: opts, now: time.Now}
+}
+
+// Write appends p to the file, rotating first if p would exceed MaxSize.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	if w.opts.MaxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.opts.MaxSize {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Close closes the current file.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// open opens the log file for appending and records its current size.
+func (w *Writer) open() error {
+	f, err := os.OpenFile(w.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, filePermissions)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+	w.file = f
+	w.size = info.Size()
+	return nil
+}
+
+// rotate renames the current file to a timestamped backup and opens a new one.
+func (w *Writer) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %w", err)
+	}
+	w.file = nil
+
+	now := w.now()
+	backup := w.backupName(now)
+	if err := os.Rename(w.path, backup); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+
+	if w.opts.Compress {
+		if err := compressFile(backup); err != nil {
+			return err
+		}
+	}
+	return w.removeOldBackups(now)
+}
+
+// backupName returns the backup path for a rotation at t in UTC, e.g. blocked-2024-01-02T15-04-05.000.log.
+func (w *Writer) backupName(t time.Time) string {
+	dir, prefix, ext := w.nameParts()
+	return filepath.Join(dir, prefix+t.UTC().Format(backupTimeFormat)+ext)
+}
+
+// nameParts splits the log path into its directory, backup prefix, and extension.
+func (w *Writer) nameParts() (string, string, string) {
+	dir := filepath.Dir(w.path)
+	base := filepath.Base(w.path)
+	ext := filepath.Ext(base)
+	return dir, strings.TrimSuffix(base, ext) + "-", ext
+}
+
+// backup is a rotated file and the time it was rotated.
+type backup struct {
+	path string
+	time time.Time
+}
+
+// backups lists rotated files, newest first.
+func (w *Writer) backups() ([]backup, error) {
+	dir, prefix, ext := w.nameParts()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list log backups: %w", err)
+	}
+
+	var result []backup
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		stamp := strings.TrimPrefix(name, prefix)
+		stamp = strings.TrimSuffix(stamp, compressSuffix)
+		stamp, ok := strings.CutSuffix(stamp, ext)
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(backupTimeFormat, stamp)
+		if err != nil {
+			continue
+		}
+		result = append(result, backup{path: filepath.Join(dir, name), time: t})
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].time.After(result[j].time) })
+	return result, nil
+}
+
+// Files lists the files of the log at path in the order they were written: the rotated
+// backups, oldest first, followed by path itself when it exists. Backups may be compressed.
+func Files(path string) ([]string, error) {
+	w := &Writer{path: path}
+	backups, err := w.backups()
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0, len(backups)+1)
+	for i := len(backups) - 1; i >= 0; i-- {
+		files = append(files, backups[i].path)
+	}
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+	return files, nil
+}
+
+// removeOldBackups deletes backups beyond MaxBackups or older than MaxAge.
+func (w *Writer) removeOldBackups(now time.Time) error {
+	if w.opts.MaxBackups <= 0 && w.opts.MaxAge <= 0 {
+		return nil
+	}
+
+	backups, err := w.backups()
+	if err != nil {
+		return err
+	}
+
+	cutoff := now.Add(-w.opts.MaxAge)
+	for i, b := range backups {
+		tooMany := w.opts.MaxBackups > 0 && i >= w.opts.MaxBackups
+		tooOld := w.opts.MaxAge > 0 && b.time.Before(cutoff)
+		if tooMany || tooOld {
+			if err := os.Remove(b.path); err != nil {
+				return fmt.Errorf("failed to remove old log backup: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// compressFile gzips path into path.gz and removes the original.
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open log backup: %w", err)
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+compressSuffix, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, filePermissions)
+	if err != nil {
+		return fmt.Errorf("failed to create compressed log backup: %w", err)
+	}
+
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		_ = dst.Close()
+		return fmt.Errorf("failed to compress log backup: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		_ = dst.Close()
+		return fmt.Errorf("failed to compress log backup: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		return fmt.Errorf("failed to compress log backup: %w", err)
+	}
+
+	return os.Remove(path)
+}
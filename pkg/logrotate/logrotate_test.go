@@ -0,0 +1,138 @@
+package logrotate
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+// newTestWriter creates a Writer whose clock advances one second per call.
+func newTestWriter(t *testing.T, opts Options) (*Writer, string) {
+	t.Helper()
+	dir := t.TempDir()
+	w := New(filepath.Join(dir, "blocked.log"), opts)
+	clock := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	w.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+	t.Cleanup(func() { _ = w.Close() })
+	return w, dir
+}
+
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestWriter_NoRotationBelowMaxSize(t *testing.T) {
+	w, dir := newTestWriter(t, Options{MaxSize: 100})
+
+	_, err := w.Write([]byte("first\n"))
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("second\n"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"blocked.log"}, listFiles(t, dir))
+	content, err := os.ReadFile(filepath.Join(dir, "blocked.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, "first\nsecond\n", string(content))
+}
+
+func TestWriter_RotatesAndKeepsMaxBackups(t *testing.T) {
+	w, dir := newTestWriter(t, Options{MaxSize: 10, MaxBackups: 2})
+
+	for _, line := range []string{"aaaaaaaa\n", "bbbbbbbb\n", "cccccccc\n", "dddddddd\n"} {
+		_, err := w.Write([]byte(line))
+		assert.NoError(t, err)
+	}
+
+	files := listFiles(t, dir)
+	assert.Equal(t, 3, len(files))
+	assert.Equal(t, []string{
+		"blocked-2024-01-02T03-04-07.000.log",
+		"blocked-2024-01-02T03-04-08.000.log",
+		"blocked.log",
+	}, files)
+
+	content, err := os.ReadFile(filepath.Join(dir, "blocked.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, "dddddddd\n", string(content))
+
+	// Files lists them in the order they were written
+	paths, err := Files(filepath.Join(dir, "blocked.log"))
+	assert.NoError(t, err)
+	for i, name := range files {
+		files[i] = filepath.Join(dir, name)
+	}
+	assert.Equal(t, files, paths)
+}
+
+func TestWriter_RemovesOldBackups(t *testing.T) {
+	w, dir := newTestWriter(t, Options{MaxSize: 10, MaxAge: 500 * time.Millisecond})
+
+	for _, line := range []string{"aaaaaaaa\n", "bbbbbbbb\n", "cccccccc\n"} {
+		_, err := w.Write([]byte(line))
+		assert.NoError(t, err)
+	}
+
+	// Each rotation advances the clock by a second, so only the newest backup is young enough
+	files := listFiles(t, dir)
+	assert.Equal(t, 2, len(files))
+}
+
+func TestWriter_Compress(t *testing.T) {
+	w, dir := newTestWriter(t, Options{MaxSize: 10, Compress: true})
+
+	for _, line := range []string{"aaaaaaaa\n", "bbbbbbbb\n"} {
+		_, err := w.Write([]byte(line))
+		assert.NoError(t, err)
+	}
+
+	var compressed string
+	for _, name := range listFiles(t, dir) {
+		if strings.HasSuffix(name, ".log.gz") {
+			compressed = name
+		}
+	}
+	assert.NotEqual(t, "", compressed)
+
+	f, err := os.Open(filepath.Join(dir, compressed))
+	assert.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	assert.NoError(t, err)
+	content, err := io.ReadAll(gz)
+	assert.NoError(t, err)
+	assert.Equal(t, "aaaaaaaa\n", string(content))
+}
+
+func TestWriter_AppendsToExistingFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "blocked.log")
+	assert.NoError(t, os.WriteFile(path, []byte("existing\n"), 0o600))
+
+	w := New(path, Options{MaxSize: 12})
+	defer w.Close()
+
+	// The existing size counts toward MaxSize, so this write rotates the old content away
+	_, err := w.Write([]byte("new\n"))
+	assert.NoError(t, err)
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "new\n", string(content))
+	assert.Equal(t, 2, len(listFiles(t, dir)))
+}
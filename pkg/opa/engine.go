@@ -0,0 +1,143 @@
+package opa
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+// Engine evaluates decisions in-process with Rego policies and bundles, without an OPA server.
+// Policies are written in Rego v1, the syntax of OPA 1.0.
+type Engine struct {
+	query rego.PreparedEvalQuery
+	// Timeout bounds each evaluation; zero means DefaultTimeout.
+	Timeout time.Duration
+}
+
+// NewEngine compiles the policies and the bundle of cfg.
+func NewEngine(ctx context.Context, cfg config.OPAConfig) (*Engine, error) {
+	decision := cfg.Decision
+	if decision == "" {
+		decision = config.DefaultOPADecision
+	}
+	ref := ast.DefaultRootRef.Copy()
+	for _, segment := range strings.Split(strings.Trim(decision, "/"), "/") {
+		ref = ref.Append(ast.StringTerm(segment))
+	}
+
+	options := []func(*rego.Rego){rego.Query(ref.String()), rego.SetRegoVersion(ast.RegoV1)}
+	if len(cfg.Policies) > 0 {
+		options = append(options, rego.Load(cfg.Policies, regoFilesOnly))
+	}
+	if cfg.Bundle != "" {
+		options = append(options, rego.LoadBundle(cfg.Bundle))
+	}
+	query, err := rego.New(options...).PrepareForEval(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile OPA policies: %w", err)
+	}
+	return &Engine{query: query, Timeout: time.Duration(cfg.Timeout) * time.Second}, nil
+}
+
+// regoFilesOnly skips the files of policy directories that are not Rego, such as READMEs and
+// tests' JSON data.
+func regoFilesOnly(_ string, info fs.FileInfo, _ int) bool {
+	return !info.IsDir() && filepath.Ext(info.Name()) != ".rego"
+}
+
+// Evaluate evaluates the decision document for input, like Client.Evaluate.
+func (e *Engine) Evaluate(ctx context.Context, input Input) (Decision, error) {
+	timeout := e.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	results, err := e.query.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		return Decision{}, fmt.Errorf("failed to evaluate OPA policies: %w", err)
+	}
+	if len(results) == 0 || len(results[0].Expressions) == 0 {
+		return Decision{}, ErrUndefined
+	}
+	data, err := json.Marshal(results[0].Expressions[0].Value)
+	if err != nil {
+		return Decision{}, err
+	}
+	return parseDecision(data)
+}
+
+// engines caches compiled engines by configuration, since runners are created for every
+// execution. An engine is compiled again when its policy files change.
+var engines = struct {
+	sync.Mutex
+	m map[string]cachedEngine
+}{m: map[string]cachedEngine{}}
+
+// cachedEngine is an engine with the fingerprint of the files it was compiled from.
+type cachedEngine struct {
+	engine      *Engine
+	fingerprint string
+}
+
+// LoadEngine returns the Engine for the policies and the bundle of cfg. Engines are shared
+// between calls with the same configuration until a policy file changes.
+func LoadEngine(cfg config.OPAConfig) (*Engine, error) {
+	key, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint, err := policyFingerprint(cfg)
+	if err != nil {
+		return nil, err
+	}
+	engines.Lock()
+	defer engines.Unlock()
+	if cached, ok := engines.m[string(key)]; ok && cached.fingerprint == fingerprint {
+		return cached.engine, nil
+	}
+	engine, err := NewEngine(context.Background(), cfg)
+	if err != nil {
+		return nil, err
+	}
+	engines.m[string(key)] = cachedEngine{engine: engine, fingerprint: fingerprint}
+	return engine, nil
+}
+
+// policyFingerprint describes the size and modification time of every file of the policies
+// and the bundle of cfg.
+func policyFingerprint(cfg config.OPAConfig) (string, error) {
+	var b strings.Builder
+	for _, root := range slices.Concat(cfg.Policies, []string{cfg.Bundle}) {
+		if root == "" {
+			continue
+		}
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&b, "%s\x00%d\x00%d\n", path, info.Size(), info.ModTime().UnixNano())
+			return nil
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to read OPA policies: %w", err)
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,124 @@
+package opa
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
+)
+
+const testPolicy = `package secure_shell
+
+default decision := {"allow": true}
+
+decision := {"allow": false, "reason": "kubectl is only allowed in the deploy directory"} if {
+	input.command == "kubectl"
+	not startswith(input.cwd, "/srv/deploy")
+}
+
+decision := {"allow": false, "reason": "CI may not use the network"} if {
+	startswith(input.identity.user, "ci-")
+	input.command in {"curl", "wget"}
+}
+`
+
+// writePolicy writes a Rego file named name in dir.
+func writePolicy(t *testing.T, dir, name, policy string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(policy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestEngine_Evaluate(t *testing.T) {
+	dir := t.TempDir()
+	writePolicy(t, dir, "policy.rego", testPolicy)
+	writePolicy(t, dir, "README.md", "not Rego")
+
+	e, err := NewEngine(t.Context(), config.OPAConfig{Policies: []string{dir}})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	tests := []struct {
+		name  string
+		input Input
+		want  Decision
+	}{
+		{"Allowed", Input{Command: "ls", Cwd: "/srv/app"}, Decision{Allow: true}},
+		{"KubectlOutsideDeploy", Input{Command: "kubectl", Cwd: "/srv/app"}, Decision{Reason: "kubectl is only allowed in the deploy directory"}},
+		{"KubectlInDeploy", Input{Command: "kubectl", Cwd: "/srv/deploy/prod"}, Decision{Allow: true}},
+		{"CINetwork", Input{Command: "curl", Identity: identity.Identity{User: "ci-bot"}}, Decision{Reason: "CI may not use the network"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := e.Evaluate(t.Context(), tt.input)
+			if err != nil {
+				t.Fatalf("Evaluate() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Evaluate() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEngine_Bundle(t *testing.T) {
+	dir := t.TempDir()
+	writePolicy(t, dir, "allow.rego", "package shell\n\nallow if input.command == \"ls\"\n")
+
+	e, err := NewEngine(t.Context(), config.OPAConfig{Bundle: dir, Decision: "shell/allow"})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	if got, err := e.Evaluate(t.Context(), Input{Command: "ls"}); err != nil || !got.Allow {
+		t.Errorf("Evaluate(ls) = %+v, %v", got, err)
+	}
+	// allow is undefined for other commands
+	if _, err := e.Evaluate(t.Context(), Input{Command: "rm"}); !errors.Is(err, ErrUndefined) {
+		t.Errorf("Evaluate(rm) error = %v, want ErrUndefined", err)
+	}
+}
+
+func TestNewEngine_Errors(t *testing.T) {
+	dir := t.TempDir()
+	syntaxError := writePolicy(t, dir, "broken.rego", "package secure_shell\n\ndecision := {")
+	if _, err := NewEngine(t.Context(), config.OPAConfig{Policies: []string{syntaxError}}); err == nil {
+		t.Error("NewEngine() of a policy with a syntax error should fail")
+	}
+	if _, err := LoadEngine(config.OPAConfig{Policies: []string{filepath.Join(dir, "missing.rego")}}); err == nil {
+		t.Error("LoadEngine() of a missing policy should fail")
+	}
+}
+
+func TestLoadEngine_Reload(t *testing.T) {
+	dir := t.TempDir()
+	path := writePolicy(t, dir, "policy.rego", "package secure_shell\n\ndecision := true\n")
+	cfg := config.OPAConfig{Policies: []string{path}}
+
+	first, err := LoadEngine(cfg)
+	if err != nil {
+		t.Fatalf("LoadEngine() error = %v", err)
+	}
+	if again, _ := LoadEngine(cfg); again != first {
+		t.Error("LoadEngine() should share the engine of an unchanged policy")
+	}
+
+	writePolicy(t, dir, "policy.rego", "package secure_shell\n\ndecision := false\n")
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := LoadEngine(cfg)
+	if err != nil {
+		t.Fatalf("LoadEngine() error = %v", err)
+	}
+	if got, err := reloaded.Evaluate(t.Context(), Input{Command: "ls"}); err != nil || got.Allow {
+		t.Errorf("Evaluate() after the policy changed = %+v, %v", got, err)
+	}
+}
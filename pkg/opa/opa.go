@@ -0,0 +1,151 @@
+// Package opa delegates command decisions to Open Policy Agent, so that organizations can
+// express conditional rules in Rego that the static allow and deny lists cannot, such as
+// "deploy only from the release directory" or "CI bots may not use the network".
+//
+// The runner evaluates every command that the static policy allows against the configured
+// decision with an Input document; the command runs only if the decision allows it too.
+// Engine evaluates Rego policies in-process, and Client queries an OPA server.
+package opa
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
+)
+
+// ErrUndefined is returned when the policy does not define the decision for an input.
+var ErrUndefined = errors.New("policy decision is undefined")
+
+// Input is the document a command is evaluated with, available in Rego as input.
+type Input struct {
+	// Command is the command name, without a directory or, on Windows, an executable suffix.
+	Command string `json:"command"`
+	// Args are the arguments after expansion.
+	Args []string `json:"args"`
+	// Cwd is the directory the command runs in.
+	Cwd string `json:"cwd"`
+	// Identity is the caller of the execution; its fields are omitted when unknown.
+	Identity identity.Identity `json:"identity"`
+	// Env holds the exported variables the command receives, with secrets redacted.
+	Env map[string]string `json:"env"`
+}
+
+// Decision is the result of evaluating a command.
+type Decision struct {
+	Allow bool `json:"allow"`
+	// Reason is returned to the caller when the command is denied.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Evaluator decides whether a command may run. It is implemented by Engine and Client, and
+// by functions through EvaluatorFunc.
+type Evaluator interface {
+	Evaluate(ctx context.Context, input Input) (Decision, error)
+}
+
+// EvaluatorFunc adapts a function to an Evaluator.
+type EvaluatorFunc func(ctx context.Context, input Input) (Decision, error)
+
+// Evaluate calls f.
+func (f EvaluatorFunc) Evaluate(ctx context.Context, input Input) (Decision, error) {
+	return f(ctx, input)
+}
+
+// Client evaluates decisions with the Data API of an OPA server, such as a sidecar.
+type Client struct {
+	// URL is the base URL of the server, e.g. "http://127.0.0.1:8181".
+	URL string
+	// Decision is the path of the decision document, e.g. "secure_shell/decision".
+	Decision string
+	// Timeout bounds each evaluation; zero means DefaultTimeout.
+	Timeout    time.Duration
+	HTTPClient *http.Client
+}
+
+// DefaultTimeout is the Client.Timeout used when none is set.
+const DefaultTimeout = 2 * time.Second
+
+// New returns a Client for cfg, or nil when no OPA server is configured.
+func New(cfg config.OPAConfig) *Client {
+	if cfg.URL == "" {
+		return nil
+	}
+	decision := cfg.Decision
+	if decision == "" {
+		decision = config.DefaultOPADecision
+	}
+	return &Client{URL: cfg.URL, Decision: decision, Timeout: time.Duration(cfg.Timeout) * time.Second}
+}
+
+// Evaluate queries the decision document for input. The document may be a boolean or an
+// object with "allow" and "reason" fields.
+func (c *Client) Evaluate(ctx context.Context, input Input) (Decision, error) {
+	body, err := json.Marshal(map[string]Input{"input": input})
+	if err != nil {
+		return Decision{}, err
+	}
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	endpoint, err := url.JoinPath(c.URL, "v1/data", strings.Trim(c.Decision, "/"))
+	if err != nil {
+		return Decision{}, fmt.Errorf("invalid OPA URL: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return Decision{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Decision{}, fmt.Errorf("failed to query OPA: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return Decision{}, fmt.Errorf("failed to query OPA: server responded %s", resp.Status)
+	}
+
+	var result struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return Decision{}, fmt.Errorf("failed to decode OPA response: %w", err)
+	}
+	return parseDecision(result.Result)
+}
+
+// parseDecision decodes a decision document, which may be a boolean or a Decision.
+func parseDecision(data json.RawMessage) (Decision, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return Decision{}, ErrUndefined
+	}
+	var allow bool
+	if err := json.Unmarshal(data, &allow); err == nil {
+		return Decision{Allow: allow}, nil
+	}
+	var decision Decision
+	if err := json.Unmarshal(data, &decision); err != nil {
+		return Decision{}, fmt.Errorf("decision must be a boolean or an object with \"allow\" and \"reason\": %s", data)
+	}
+	return decision, nil
+}
@@ -0,0 +1,80 @@
+package opa
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
+)
+
+func TestClient_Evaluate(t *testing.T) {
+	var got struct {
+		Input Input `json:"input"`
+	}
+	var gotPath string
+	response := `{"result": {"allow": false, "reason": "deploys only from /srv/release"}}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		_, _ = w.Write([]byte(response))
+	}))
+	defer srv.Close()
+
+	c := New(config.OPAConfig{URL: srv.URL})
+	input := Input{
+		Command:  "deploy",
+		Args:     []string{"--prod"},
+		Cwd:      "/srv/app",
+		Identity: identity.Identity{User: "ci-bot"},
+		Env:      map[string]string{"STAGE": "prod"},
+	}
+	decision, err := c.Evaluate(t.Context(), input)
+	if err != nil {
+		t.Fatalf("Evaluate() error = %v", err)
+	}
+	if decision.Allow || decision.Reason != "deploys only from /srv/release" {
+		t.Errorf("Evaluate() = %+v", decision)
+	}
+	if gotPath != "/v1/data/secure_shell/decision" {
+		t.Errorf("queried %s, want the default decision", gotPath)
+	}
+	if got.Input.Command != "deploy" || got.Input.Cwd != "/srv/app" || got.Input.Identity.User != "ci-bot" || got.Input.Env["STAGE"] != "prod" {
+		t.Errorf("input = %+v", got.Input)
+	}
+
+	response = `{"result": true}`
+	if decision, err := c.Evaluate(t.Context(), input); err != nil || !decision.Allow {
+		t.Errorf("Evaluate() of a boolean decision = %+v, %v", decision, err)
+	}
+
+	response = `{}`
+	if _, err := c.Evaluate(t.Context(), input); !errors.Is(err, ErrUndefined) {
+		t.Errorf("Evaluate() of an undefined decision error = %v, want ErrUndefined", err)
+	}
+
+	response = `{"result": "yes"}`
+	if _, err := c.Evaluate(t.Context(), input); err == nil {
+		t.Error("Evaluate() of a string decision should fail")
+	}
+}
+
+func TestClient_EvaluateServerError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "policy error", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	c := New(config.OPAConfig{URL: srv.URL, Decision: "/shell/allow/"})
+	if _, err := c.Evaluate(t.Context(), Input{Command: "ls"}); err == nil {
+		t.Error("Evaluate() should fail when the server responds with an error")
+	}
+	if New(config.OPAConfig{}) != nil {
+		t.Error("New() without a URL should return nil")
+	}
+}
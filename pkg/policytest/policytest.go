@@ -0,0 +1,181 @@
+// Package policytest checks a configuration against table-driven expectations, so
+// that changes to an allowlist can be reviewed and tested in CI like code.
+//
+// A suite is a YAML file listing scripts and whether the policy must allow or deny them:
+//
+//	config: config.json
+//	tests:
+//	  - name: no recursive delete of the root
+//	    script: rm -rf /
+//	    expect: denied
+//	    message: is denied
+//	  - script: git status
+//	    dir: /workspace
+//	    expect: allowed
+//
+// Scripts are validated statically, as by validator.ValidateScript; nothing is executed.
+package policytest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+// Expectations of a test case.
+const (
+	ExpectAllowed = "allowed"
+	ExpectDenied  = "denied"
+)
+
+// Case is a single expectation about the policy.
+type Case struct {
+	// Name describes the case; the script is used when it is empty.
+	Name   string `yaml:"name"`
+	Script string `yaml:"script"`
+	// Dir is the working directory (default: the first allowed directory).
+	Dir string `yaml:"dir"`
+	// Expect is ExpectAllowed or ExpectDenied.
+	Expect string `yaml:"expect"`
+	// Message, if set, must appear in the message of a denied script.
+	Message string `yaml:"message"`
+	// Rule, if set, must be the rule of a violation of a denied script, e.g. "deny-command".
+	Rule string `yaml:"rule"`
+}
+
+// Title returns the name of the case, or its script if it has none.
+func (c Case) Title() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.Script
+}
+
+// Suite is a set of cases and the configuration they test.
+type Suite struct {
+	// Config lists the configuration files, layered as by config.LoadConfigFromFiles.
+	// Relative paths are resolved against the directory of the suite file.
+	Config Paths  `yaml:"config"`
+	Tests  []Case `yaml:"tests"`
+}
+
+// Paths is a list of files that may be written in YAML as a single string.
+type Paths []string
+
+// UnmarshalYAML accepts a string or a list of strings.
+func (p *Paths) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*p = Paths{node.Value}
+		return nil
+	}
+	var paths []string
+	if err := node.Decode(&paths); err != nil {
+		return err
+	}
+	*p = paths
+	return nil
+}
+
+// Load reads a suite from a YAML file and checks its cases.
+func Load(path string) (*Suite, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy test file: %w", err)
+	}
+
+	var suite Suite
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&suite); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to decode policy test file %s: %w", path, err)
+	}
+
+	for i, configPath := range suite.Config {
+		if !filepath.IsAbs(configPath) {
+			suite.Config[i] = filepath.Join(filepath.Dir(path), configPath)
+		}
+	}
+	for i, c := range suite.Tests {
+		if c.Script == "" {
+			return nil, fmt.Errorf("%s: test %d has no script", path, i+1)
+		}
+		if c.Expect != ExpectAllowed && c.Expect != ExpectDenied {
+			return nil, fmt.Errorf("%s: test %q: expect must be %q or %q", path, c.Title(), ExpectAllowed, ExpectDenied)
+		}
+	}
+	return &suite, nil
+}
+
+// Result is the outcome of a single case.
+type Result struct {
+	Case Case
+	// Allowed is whether the policy allowed the script.
+	Allowed bool
+	// Message is the denial message, one line per violation.
+	Message string
+	// Failure explains why the case failed; it is empty when the case passed.
+	Failure string
+}
+
+// Passed reports whether the policy behaved as the case expects.
+func (r Result) Passed() bool {
+	return r.Failure == ""
+}
+
+// Run checks every case against cfg.
+func Run(cfg *config.ShellCommandConfig, cases []Case) []Result {
+	// Test runs must not fill the block log
+	quiet := *cfg
+	quiet.BlockLogPath = ""
+	v := validator.New(&quiet, logger.NewWithWriter(io.Discard))
+
+	results := make([]Result, 0, len(cases))
+	for _, c := range cases {
+		results = append(results, check(v, cfg, c))
+	}
+	return results
+}
+
+// check validates the script of a case and compares the outcome with the expectation.
+func check(v *validator.CommandValidator, cfg *config.ShellCommandConfig, c Case) Result {
+	dir := c.Dir
+	if dir == "" {
+		dir = cfg.DefaultDirectory()
+	}
+
+	// The runner refuses to start in a directory that is not allowed
+	report := v.ValidateScript(c.Script, dir)
+	if allowed, message := v.IsDirectoryAllowed(dir); !allowed {
+		report.Violations = append([]validator.Violation{{Rule: validator.RulePath, Message: message}}, report.Violations...)
+	}
+	messages := make([]string, 0, len(report.Violations))
+	rules := make([]string, 0, len(report.Violations))
+	for _, violation := range report.Violations {
+		messages = append(messages, violation.Message)
+		rules = append(rules, string(violation.Rule))
+	}
+	result := Result{Case: c, Allowed: report.Valid(), Message: strings.Join(messages, "\n")}
+
+	switch {
+	case c.Expect == ExpectAllowed && !result.Allowed:
+		result.Failure = "expected allowed, but denied: " + result.Message
+	case c.Expect == ExpectDenied && result.Allowed:
+		result.Failure = "expected denied, but allowed"
+	case c.Expect == ExpectDenied && c.Message != "" && !strings.Contains(result.Message, c.Message):
+		result.Failure = fmt.Sprintf("expected message containing %q, got %q", c.Message, result.Message)
+	case c.Expect == ExpectDenied && c.Rule != "" && !slices.Contains(rules, c.Rule):
+		result.Failure = fmt.Sprintf("expected rule %q, got %s", c.Rule, strings.Join(rules, ", "))
+	}
+	return result
+}
@@ -0,0 +1,74 @@
+package policytest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "policy_test.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(`
+config: [base.json, /etc/project.json]
+tests:
+  - name: no rm
+    script: rm -rf /
+    expect: denied
+    message: is denied
+    rule: deny-command
+  - script: ls
+    expect: allowed
+`), 0o600))
+
+	suite, err := Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, Paths{filepath.Join(dir, "base.json"), "/etc/project.json"}, suite.Config)
+	assert.Equal(t, 2, len(suite.Tests))
+	assert.Equal(t, "no rm", suite.Tests[0].Title())
+	assert.Equal(t, "ls", suite.Tests[1].Title())
+
+	for _, invalid := range []string{
+		"tests:\n  - script: ls\n    expect: maybe\n",
+		"tests:\n  - expect: allowed\n",
+		"tests:\n  - script: ls\n    expect: allowed\n    unknown: true\n",
+	} {
+		assert.NoError(t, os.WriteFile(path, []byte(invalid), 0o600))
+		_, err := Load(path)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestRun(t *testing.T) {
+	workspace := t.TempDir()
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories:  []string{workspace},
+		AllowCommands:       []config.AllowCommand{{Command: "ls"}, {Command: "git", SubCommands: []config.SubCommandRule{{Name: "status"}}}},
+		DenyCommands:        []config.DenyCommand{{Command: "rm", Message: "use trash"}},
+		DefaultErrorMessage: "Command not allowed by security policy",
+	}
+
+	results := Run(cfg, []Case{
+		{Script: "rm -rf /", Expect: ExpectDenied, Message: "use trash", Rule: "deny-command"},
+		{Script: "git status", Dir: workspace, Expect: ExpectAllowed},
+		{Script: "git status", Dir: "/", Expect: ExpectDenied, Message: "is not allowed"},
+		{Script: "git push", Expect: ExpectAllowed},
+		{Script: "ls", Expect: ExpectDenied},
+		{Script: "rm -rf /", Expect: ExpectDenied, Message: "forbidden"},
+		{Script: "rm -rf /", Expect: ExpectDenied, Rule: "path"},
+	})
+
+	passed := make([]bool, len(results))
+	for i, result := range results {
+		passed[i] = result.Passed()
+	}
+	assert.Equal(t, []bool{true, true, true, false, false, false, false}, passed)
+	assert.Contains(t, results[3].Failure, "expected allowed, but denied")
+	assert.Equal(t, "expected denied, but allowed", results[4].Failure)
+	assert.Contains(t, results[5].Failure, `expected message containing "forbidden"`)
+	assert.Equal(t, `expected rule "path", got deny-command`, results[6].Failure)
+}
@@ -0,0 +1,176 @@
+// Package ratelimit throttles command execution per caller identity using a
+// token bucket for the command rate and a counter for concurrent executions.
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+// ErrRateLimited is returned when a caller exceeds its command rate or concurrency limit.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// maxIdleBuckets is the number of tracked callers above which idle buckets are dropped.
+const maxIdleBuckets = 1024
+
+// bucket tracks the state of a single caller.
+type bucket struct {
+	*limits
+	tokens float64
+	last   time.Time
+	active int
+}
+
+// limits are the limits of a caller.
+type limits struct {
+	ratePerSecond float64
+	burst         float64
+	maxConcurrent int
+}
+
+// newLimits converts the configured limits, returning nil when none are set.
+func newLimits(cfg config.RateLimitConfig) *limits {
+	if cfg.CommandsPerMinute <= 0 && cfg.MaxConcurrent <= 0 {
+		return nil
+	}
+
+	const secondsPerMinute = 60
+	burst := cfg.Burst
+	if burst <= 0 {
+		burst = cfg.CommandsPerMinute
+	}
+	return &limits{
+		ratePerSecond: float64(cfg.CommandsPerMinute) / secondsPerMinute,
+		burst:         float64(burst),
+		maxConcurrent: cfg.MaxConcurrent,
+	}
+}
+
+// Limiter enforces per-caller limits. A nil Limiter allows everything.
+type Limiter struct {
+	// limits apply to callers without an entry in callers
+	limits *limits
+	// callers holds the limits of callers whose policy overlay sets its own; a nil entry
+	// leaves the caller unlimited
+	callers map[string]*limits
+	now     func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// New creates a Limiter from the configuration.
+// It returns nil when no limits are configured.
+func New(cfg config.RateLimitConfig) *Limiter {
+	l := newLimits(cfg)
+	if l == nil {
+		return nil
+	}
+	return &Limiter{limits: l, now: time.Now, buckets: make(map[string]*bucket)}
+}
+
+// NewForPolicy creates a Limiter applying the rate limits of a policy, in which callers listed
+// in the users section are limited by the rateLimit of their overlay (see
+// config.ShellCommandConfig.ForUser). It returns nil when no caller is limited.
+func NewForPolicy(cfg *config.ShellCommandConfig) *Limiter {
+	l := &Limiter{limits: newLimits(cfg.RateLimit), now: time.Now, buckets: make(map[string]*bucket)}
+	limited := l.limits != nil
+	for user := range cfg.Users {
+		if userCfg := cfg.ForUser(user); userCfg.RateLimit != cfg.RateLimit {
+			if l.callers == nil {
+				l.callers = make(map[string]*limits)
+			}
+			l.callers[user] = newLimits(userCfg.RateLimit)
+			limited = limited || l.callers[user] != nil
+		}
+	}
+	if !limited {
+		return nil
+	}
+	return l
+}
+
+// limitsFor returns the limits of caller, or nil when it is unlimited.
+func (l *Limiter) limitsFor(caller string) *limits {
+	if callerLimits, ok := l.callers[caller]; ok {
+		return callerLimits
+	}
+	return l.limits
+}
+
+// Acquire reserves a command slot for caller. On success the returned release
+// function must be called once the command has finished.
+func (l *Limiter) Acquire(caller string) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[caller]
+	if !ok {
+		callerLimits := l.limitsFor(caller)
+		if callerLimits == nil {
+			return func() {}, nil
+		}
+		l.evictIdle(now)
+		b = &bucket{limits: callerLimits, tokens: callerLimits.burst, last: now}
+		l.buckets[caller] = b
+	}
+
+	if b.maxConcurrent > 0 && b.active >= b.maxConcurrent {
+		return nil, fmt.Errorf("%w: caller %q already has %d commands running", ErrRateLimited, caller, b.active)
+	}
+
+	if b.ratePerSecond > 0 {
+		b.refill(now)
+		if b.tokens < 1 {
+			wait := time.Duration((1 - b.tokens) / b.ratePerSecond * float64(time.Second))
+			return nil, fmt.Errorf("%w: caller %q may run another command in %s",
+				ErrRateLimited, caller, wait.Round(time.Second))
+		}
+		b.tokens--
+	}
+
+	b.active++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			b.active--
+			l.mu.Unlock()
+		})
+	}, nil
+}
+
+// refill adds the tokens accumulated since the bucket was last used.
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	b.last = now
+	b.tokens = min(b.burst, b.tokens+elapsed*b.ratePerSecond)
+}
+
+// evictIdle drops callers with no running commands and a full bucket once too many are tracked.
+func (l *Limiter) evictIdle(now time.Time) {
+	if len(l.buckets) < maxIdleBuckets {
+		return
+	}
+	for caller, b := range l.buckets {
+		if b.active > 0 {
+			continue
+		}
+		if b.ratePerSecond > 0 {
+			b.refill(now)
+			if b.tokens < b.burst {
+				continue
+			}
+		}
+		delete(l.buckets, caller)
+	}
+}
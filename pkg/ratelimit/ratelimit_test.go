@@ -0,0 +1,132 @@
+package ratelimit
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+// fakeClock returns a controllable time source.
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func newTestLimiter(cfg config.RateLimitConfig) (*Limiter, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	l := New(cfg)
+	l.now = clock.now
+	return l, clock
+}
+
+func TestNew_Disabled(t *testing.T) {
+	l := New(config.RateLimitConfig{})
+	assert.Zero(t, l)
+
+	for range 100 {
+		release, err := l.Acquire("agent")
+		assert.NoError(t, err)
+		release()
+	}
+}
+
+func TestLimiter_CommandsPerMinute(t *testing.T) {
+	l, clock := newTestLimiter(config.RateLimitConfig{CommandsPerMinute: 60, Burst: 2})
+
+	for range 2 {
+		release, err := l.Acquire("agent")
+		assert.NoError(t, err)
+		release()
+	}
+
+	_, err := l.Acquire("agent")
+	assert.True(t, errors.Is(err, ErrRateLimited))
+
+	// Other callers have their own bucket
+	release, err := l.Acquire("other")
+	assert.NoError(t, err)
+	release()
+
+	// One token is refilled per second at 60 commands per minute
+	clock.t = clock.t.Add(time.Second)
+	release, err = l.Acquire("agent")
+	assert.NoError(t, err)
+	release()
+
+	_, err = l.Acquire("agent")
+	assert.True(t, errors.Is(err, ErrRateLimited))
+}
+
+func TestLimiter_MaxConcurrent(t *testing.T) {
+	l, _ := newTestLimiter(config.RateLimitConfig{MaxConcurrent: 1})
+
+	release, err := l.Acquire("agent")
+	assert.NoError(t, err)
+
+	_, err = l.Acquire("agent")
+	assert.True(t, errors.Is(err, ErrRateLimited))
+
+	// Releasing twice must not free an extra slot
+	release()
+	release()
+
+	second, err := l.Acquire("agent")
+	assert.NoError(t, err)
+	_, err = l.Acquire("agent")
+	assert.True(t, errors.Is(err, ErrRateLimited))
+	second()
+}
+
+func TestLimiter_EvictsIdleCallers(t *testing.T) {
+	l, _ := newTestLimiter(config.RateLimitConfig{MaxConcurrent: 1})
+
+	for i := range maxIdleBuckets + 1 {
+		release, err := l.Acquire(string(rune('a' + i)))
+		assert.NoError(t, err)
+		release()
+	}
+	assert.True(t, len(l.buckets) <= maxIdleBuckets)
+}
+
+func TestNewForPolicy(t *testing.T) {
+	cfg := &config.ShellCommandConfig{
+		RateLimit: config.RateLimitConfig{MaxConcurrent: 1},
+		Roles: map[string]config.PolicyOverlay{
+			"ci": {RateLimit: &config.RateLimitConfig{MaxConcurrent: 2}},
+		},
+		Users: map[string]config.PolicyOverlay{
+			"bot":   {Roles: []string{"ci"}},
+			"admin": {RateLimit: &config.RateLimitConfig{}},
+		},
+	}
+	l := NewForPolicy(cfg)
+
+	acquire := func(caller string, n int) {
+		t.Helper()
+		for range n {
+			_, err := l.Acquire(caller)
+			assert.NoError(t, err)
+		}
+	}
+	acquire("agent", 1)
+	_, err := l.Acquire("agent")
+	assert.True(t, errors.Is(err, ErrRateLimited))
+
+	// The overlay of a role raises the limit of its users
+	acquire("bot", 2)
+	_, err = l.Acquire("bot")
+	assert.True(t, errors.Is(err, ErrRateLimited))
+
+	// An overlay with empty limits leaves its user unlimited
+	acquire("admin", 10)
+
+	assert.Zero(t, NewForPolicy(&config.ShellCommandConfig{}))
+	assert.NotZero(t, NewForPolicy(&config.ShellCommandConfig{
+		Users: map[string]config.PolicyOverlay{"bot": {RateLimit: &config.RateLimitConfig{CommandsPerMinute: 1}}},
+	}))
+}
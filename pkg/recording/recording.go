@@ -0,0 +1,305 @@
+// Package recording records sessions in the asciicast v2 format used by asciinema,
+// so that reviewers can replay exactly what an agent ran and saw.
+//
+// A recording is a header line followed by one JSON array per event:
+// [seconds since start, code, data]. Output uses the standard "o" code and
+// commands are written both as "o" (so players show them) and as "m" markers.
+// Standard error uses the "e" code, which asciicast players skip.
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event codes.
+const (
+	// CodeOutput is standard output, and the echoed command line.
+	CodeOutput = "o"
+	// CodeError is standard error. It is not part of the asciicast specification.
+	CodeError = "e"
+	// CodeMarker marks the start of a command; its data is the command.
+	CodeMarker = "m"
+)
+
+const (
+	// asciicastVersion is the format version written in the header.
+	asciicastVersion = 2
+	// defaultWidth and defaultHeight are the terminal size advertised to players.
+	defaultWidth  = 120
+	defaultHeight = 40
+	// commandPrompt precedes each command in the output stream.
+	commandPrompt = "$ "
+	// fileTimeFormat is embedded in recording file names.
+	fileTimeFormat = "20060102T150405.000000000Z"
+	// Recordings may contain sensitive output, so they are readable only by the owner.
+	dirPermissions  = 0o700
+	filePermissions = 0o600
+)
+
+// Header is the first line of a recording.
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Event is a single timestamped entry in a recording.
+type Event struct {
+	// Time is the offset from the start of the recording.
+	Time time.Duration
+	Code string
+	Data string
+}
+
+// MarshalJSON encodes the event as an asciicast [time, code, data] array.
+func (e Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]any{e.Time.Seconds(), e.Code, e.Data})
+}
+
+// UnmarshalJSON decodes an asciicast [time, code, data] array.
+func (e *Event) UnmarshalJSON(data []byte) error {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if len(fields) != 3 { //nolint:mnd // time, code, data
+		return fmt.Errorf("event must have 3 fields, got %d", len(fields))
+	}
+	var seconds float64
+	if err := json.Unmarshal(fields[0], &seconds); err != nil {
+		return fmt.Errorf("invalid event time: %w", err)
+	}
+	if err := json.Unmarshal(fields[1], &e.Code); err != nil {
+		return fmt.Errorf("invalid event code: %w", err)
+	}
+	if err := json.Unmarshal(fields[2], &e.Data); err != nil {
+		return fmt.Errorf("invalid event data: %w", err)
+	}
+	e.Time = time.Duration(seconds * float64(time.Second))
+	return nil
+}
+
+// Recorder writes a recording. It is safe for concurrent use.
+type Recorder struct {
+	mu    sync.Mutex
+	w     io.Writer
+	enc   *json.Encoder
+	start time.Time
+	now   func() time.Time
+	err   error
+}
+
+// New writes the header to w and returns a Recorder for the session.
+func New(w io.Writer, title string) (*Recorder, error) {
+	r := &Recorder{w: w, enc: json.NewEncoder(w), start: time.Now(), now: time.Now}
+	header := Header{
+		Version:   asciicastVersion,
+		Width:     defaultWidth,
+		Height:    defaultHeight,
+		Timestamp: r.start.Unix(),
+		Title:     title,
+	}
+	if err := r.enc.Encode(header); err != nil {
+		return nil, fmt.Errorf("failed to write recording header: %w", err)
+	}
+	return r, nil
+}
+
+// Command records the start of a command.
+func (r *Recorder) Command(command string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t := r.now().Sub(r.start)
+	r.write(Event{Time: t, Code: CodeMarker, Data: command})
+	r.write(Event{Time: t, Code: CodeOutput, Data: commandPrompt + command + "\r\n"})
+}
+
+// Stdout returns a writer that records standard output.
+func (r *Recorder) Stdout() io.Writer {
+	return streamWriter{r: r, code: CodeOutput}
+}
+
+// Stderr returns a writer that records standard error.
+func (r *Recorder) Stderr() io.Writer {
+	return streamWriter{r: r, code: CodeError}
+}
+
+// Err returns the first error encountered while writing the recording.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Close closes the underlying writer if it is an io.Closer.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// write encodes an event. Errors are remembered rather than returned so that a failing
+// recording never interrupts the command being recorded.
+func (r *Recorder) write(e Event) {
+	if r.err != nil {
+		return
+	}
+	if err := r.enc.Encode(e); err != nil {
+		r.err = fmt.Errorf("failed to write recording event: %w", err)
+	}
+}
+
+// streamWriter records everything written to it as events with one code.
+type streamWriter struct {
+	r    *Recorder
+	code string
+}
+
+// Write records p as a single event. It never fails.
+func (w streamWriter) Write(p []byte) (int, error) {
+	w.r.mu.Lock()
+	defer w.r.mu.Unlock()
+	w.r.write(Event{Time: w.r.now().Sub(w.r.start), Code: w.code, Data: string(p)})
+	return len(p), nil
+}
+
+// Read parses a recording.
+func Read(rd io.Reader) (Header, []Event, error) {
+	scanner := bufio.NewScanner(rd)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), 16*1024*1024) //nolint:mnd // large output chunks
+
+	var header Header
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return header, nil, fmt.Errorf("failed to read recording: %w", err)
+		}
+		return header, nil, errors.New("recording is empty")
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return header, nil, fmt.Errorf("invalid recording header: %w", err)
+	}
+	if header.Version != asciicastVersion {
+		return header, nil, fmt.Errorf("unsupported recording version %d", header.Version)
+	}
+
+	var events []Event
+	for line := 2; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return header, nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		events = append(events, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return header, nil, fmt.Errorf("failed to read recording: %w", err)
+	}
+	return header, events, nil
+}
+
+// ReplayOptions controls Replay.
+type ReplayOptions struct {
+	// Speed multiplies playback speed. Zero or negative replays without delays.
+	Speed float64
+	// MaxIdle caps the pause between events. Zero means no cap.
+	MaxIdle time.Duration
+	// Stderr receives standard error events. If nil, they are written to the output writer.
+	Stderr io.Writer
+}
+
+// Replay writes the output of a recording to w, optionally reproducing its timing.
+func Replay(rd io.Reader, w io.Writer, opts ReplayOptions) error {
+	_, events, err := Read(rd)
+	if err != nil {
+		return err
+	}
+	stderr := opts.Stderr
+	if stderr == nil {
+		stderr = w
+	}
+
+	var last time.Duration
+	for _, e := range events {
+		if opts.Speed > 0 {
+			delay := e.Time - last
+			if opts.MaxIdle > 0 && delay > opts.MaxIdle {
+				delay = opts.MaxIdle
+			}
+			time.Sleep(time.Duration(float64(delay) / opts.Speed))
+		}
+		last = e.Time
+
+		switch e.Code {
+		case CodeOutput:
+			_, err = io.WriteString(w, e.Data)
+		case CodeError:
+			_, err = io.WriteString(stderr, e.Data)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to replay recording: %w", err)
+		}
+	}
+	return nil
+}
+
+// Commands returns the commands run in a recording, in order.
+func Commands(events []Event) []string {
+	var commands []string
+	for _, e := range events {
+		if e.Code == CodeMarker {
+			commands = append(commands, e.Data)
+		}
+	}
+	return commands
+}
+
+// Create starts a recording in a new file in dir named after the session and the current time.
+func Create(dir, session, title string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, dirPermissions); err != nil {
+		return nil, fmt.Errorf("failed to create recording directory: %w", err)
+	}
+	name := fmt.Sprintf("%s-%s.cast", sanitizeName(session), time.Now().UTC().Format(fileTimeFormat))
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, filePermissions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording: %w", err)
+	}
+	r, err := New(f, title)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// sanitizeName replaces characters that are unsafe in file names.
+func sanitizeName(name string) string {
+	if name == "" {
+		return "session"
+	}
+	return strings.Map(func(c rune) rune {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+			return c
+		default:
+			return '_'
+		}
+	}, name)
+}
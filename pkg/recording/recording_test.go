@@ -0,0 +1,94 @@
+package recording
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+// newTestRecorder creates a Recorder whose clock advances 100ms per event.
+func newTestRecorder(t *testing.T, buf *bytes.Buffer) *Recorder {
+	t.Helper()
+	rec, err := New(buf, "test session")
+	assert.NoError(t, err)
+	clock := rec.start
+	rec.now = func() time.Time {
+		clock = clock.Add(100 * time.Millisecond)
+		return clock
+	}
+	return rec
+}
+
+func TestRecorder_RoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	rec := newTestRecorder(t, &buf)
+
+	rec.Command("ls -la")
+	_, _ = fmt.Fprint(rec.Stdout(), "file.txt\n")
+	_, _ = fmt.Fprint(rec.Stderr(), "warning\n")
+	rec.Command("echo done")
+	assert.NoError(t, rec.Err())
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, `[0.1,"m","ls -la"]`, lines[1])
+	assert.Equal(t, `[0.1,"o","$ ls -la\r\n"]`, lines[2])
+
+	header, events, err := Read(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, header.Version)
+	assert.Equal(t, "test session", header.Title)
+	assert.Equal(t, 6, len(events))
+	assert.Equal(t, Event{Time: 200 * time.Millisecond, Code: CodeOutput, Data: "file.txt\n"}, events[2])
+	assert.Equal(t, []string{"ls -la", "echo done"}, Commands(events))
+}
+
+func TestReplay(t *testing.T) {
+	var buf bytes.Buffer
+	rec := newTestRecorder(t, &buf)
+	rec.Command("cat missing")
+	_, _ = fmt.Fprint(rec.Stdout(), "out\n")
+	_, _ = fmt.Fprint(rec.Stderr(), "err\n")
+
+	var stdout, stderr bytes.Buffer
+	err := Replay(bytes.NewReader(buf.Bytes()), &stdout, ReplayOptions{Stderr: &stderr})
+	assert.NoError(t, err)
+	assert.Equal(t, "$ cat missing\r\nout\n", stdout.String())
+	assert.Equal(t, "err\n", stderr.String())
+
+	// Timing is reproduced, with pauses capped by MaxIdle
+	start := time.Now()
+	err = Replay(bytes.NewReader(buf.Bytes()), &bytes.Buffer{}, ReplayOptions{Speed: 1, MaxIdle: 10 * time.Millisecond})
+	assert.NoError(t, err)
+	assert.True(t, time.Since(start) >= 30*time.Millisecond)
+}
+
+func TestRead_Invalid(t *testing.T) {
+	_, _, err := Read(strings.NewReader(""))
+	assert.Error(t, err)
+
+	_, _, err = Read(strings.NewReader(`{"version": 1}`))
+	assert.Error(t, err)
+
+	_, _, err = Read(strings.NewReader("{\"version\": 2}\n[1, \"o\"]\n"))
+	assert.Error(t, err)
+}
+
+func TestCreate(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "recordings")
+	rec, err := Create(dir, "SHA256:abc/def", "title")
+	assert.NoError(t, err)
+	rec.Command("ls")
+	assert.NoError(t, rec.Close())
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.True(t, strings.HasPrefix(entries[0].Name(), "SHA256_abc_def-"))
+	assert.True(t, strings.HasSuffix(entries[0].Name(), ".cast"))
+}
@@ -0,0 +1,239 @@
+// Package redact masks secrets such as API keys and private keys in text.
+package redact
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+// DefaultReplacement is substituted for secrets when no replacement is configured.
+const DefaultReplacement = "[REDACTED]"
+
+// secretGroup is the name of the capture group that limits masking to part of a match.
+const secretGroup = "secret"
+
+// maxPendingBytes bounds how much unterminated output the Writer holds back.
+const maxPendingBytes = 64 * 1024
+
+// builtinPatterns match commonly leaked credentials.
+var builtinPatterns = []string{
+	// AWS access key IDs
+	`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`,
+	// AWS secret access keys in key=value or key: value form
+	`(?i)aws_secret_access_key["']?\s*[=:]\s*["']?(?P<secret>[A-Za-z0-9/+=]{40})`,
+	// Bearer tokens in Authorization headers
+	`(?i)\bbearer\s+(?P<secret>[A-Za-z0-9\-._~+/]+=*)`,
+	// GitHub tokens
+	`\bgh[pousr]_[A-Za-z0-9]{36,}\b`,
+	// PEM private key blocks
+	`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`,
+}
+
+// builtinRegexps are the compiled builtinPatterns, shared by every Redactor.
+var builtinRegexps = compileBuiltins()
+
+var (
+	privateKeyBegin = regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`)
+	privateKeyEnd   = regexp.MustCompile(`-----END [A-Z ]*PRIVATE KEY-----`)
+)
+
+// Redactor replaces secrets in text with a fixed replacement.
+// A nil *Redactor is valid and leaves text unchanged.
+type Redactor struct {
+	replacement string
+
+	mu       sync.RWMutex
+	patterns []*regexp.Regexp
+	masked   map[string]bool
+}
+
+// New creates a Redactor from the configuration.
+// It returns nil when redaction is disabled. Invalid custom patterns are skipped
+// and reported in the returned error, but the returned Redactor is still usable
+// so that the built-in patterns keep protecting output.
+func New(cfg config.RedactionConfig) (*Redactor, error) {
+	if !cfg.Enabled {
+		return nil, nil //nolint:nilnil // nil Redactor means redaction is disabled
+	}
+
+	r := &Redactor{replacement: cfg.Replacement}
+	if r.replacement == "" {
+		r.replacement = DefaultReplacement
+	}
+
+	r.patterns = append(r.patterns, builtinRegexps...)
+
+	var errs []error
+	for _, pattern := range cfg.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid redaction pattern %q: %w", pattern, err))
+			continue
+		}
+		r.patterns = append(r.patterns, re)
+	}
+
+	return r, errors.Join(errs...)
+}
+
+// compileBuiltins compiles builtinPatterns once rather than for every Redactor, as servers
+// create one per command.
+func compileBuiltins() []*regexp.Regexp {
+	regexps := make([]*regexp.Regexp, 0, len(builtinPatterns))
+	for _, pattern := range builtinPatterns {
+		regexps = append(regexps, regexp.MustCompile(pattern))
+	}
+	return regexps
+}
+
+// Mask adds values, such as secrets passed to commands, to be replaced wherever they appear.
+// It does nothing on a nil Redactor.
+func (r *Redactor) Mask(values ...string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, value := range values {
+		if value == "" || r.masked[value] {
+			continue
+		}
+		if r.masked == nil {
+			r.masked = make(map[string]bool)
+		}
+		r.masked[value] = true
+		r.patterns = append(r.patterns, regexp.MustCompile(regexp.QuoteMeta(value)))
+	}
+}
+
+// Redact returns s with every secret replaced.
+func (r *Redactor) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, re := range r.patterns {
+		s = r.replace(re, s)
+	}
+	return s
+}
+
+// replace masks all matches of re in s, limited to the "secret" group when present.
+func (r *Redactor) replace(re *regexp.Regexp, s string) string {
+	matches := re.FindAllStringSubmatchIndex(s, -1)
+	if matches == nil {
+		return s
+	}
+
+	group := re.SubexpIndex(secretGroup)
+	var sb strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		if group > 0 && m[2*group] >= 0 {
+			start, end = m[2*group], m[2*group+1]
+		}
+		sb.WriteString(s[last:start])
+		sb.WriteString(r.replacement)
+		last = end
+	}
+	sb.WriteString(s[last:])
+	return sb.String()
+}
+
+// Writer redacts secrets from data before passing it to an underlying writer.
+// Output is processed line by line so that secrets split across writes are still
+// matched; private key blocks are held back until their END marker is seen.
+// Flush must be called once all output has been written.
+type Writer struct {
+	w        io.Writer
+	redactor *Redactor
+	pending  []byte
+	inBlock  bool
+}
+
+// NewWriter wraps w so that everything written is redacted by redactor.
+func NewWriter(w io.Writer, redactor *Redactor) *Writer {
+	return &Writer{w: w, redactor: redactor}
+}
+
+// Write implements the io.Writer interface.
+func (rw *Writer) Write(p []byte) (int, error) {
+	rw.pending = append(rw.pending, p...)
+
+	for {
+		idx := bytes.IndexByte(rw.pending, '\n')
+		if idx < 0 {
+			break
+		}
+		// Inside a private key block, only emit once the END marker arrives
+		if rw.inBlock || privateKeyBegin.Match(rw.pending[:idx+1]) {
+			end := privateKeyEnd.FindIndex(rw.pending)
+			if end == nil {
+				rw.inBlock = true
+				break
+			}
+			lineEnd := bytes.IndexByte(rw.pending[end[1]:], '\n')
+			if lineEnd < 0 {
+				rw.inBlock = true
+				break
+			}
+			idx = end[1] + lineEnd
+			rw.inBlock = false
+		}
+		if err := rw.emit(rw.pending[:idx+1]); err != nil {
+			return 0, err
+		}
+		rw.pending = rw.pending[idx+1:]
+	}
+
+	// Avoid holding unbounded output when no line break or END marker arrives
+	if len(rw.pending) > maxPendingBytes {
+		if err := rw.Flush(); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Flush redacts and writes any buffered output.
+func (rw *Writer) Flush() error {
+	if len(rw.pending) == 0 {
+		return nil
+	}
+	data := rw.pending
+	rw.pending = nil
+	if rw.inBlock {
+		// An unterminated private key block is masked entirely
+		rw.inBlock = false
+		begin := privateKeyBegin.FindIndex(data)
+		if begin != nil {
+			_, err := io.WriteString(rw.w, rw.redactor.Redact(string(data[:begin[0]]))+rw.redactor.replacementText())
+			return err
+		}
+	}
+	return rw.emit(data)
+}
+
+// emit redacts data and writes it to the underlying writer.
+func (rw *Writer) emit(data []byte) error {
+	_, err := io.WriteString(rw.w, rw.redactor.Redact(string(data)))
+	return err
+}
+
+// replacementText returns the configured replacement, or the default for a nil Redactor.
+func (r *Redactor) replacementText() string {
+	if r == nil {
+		return DefaultReplacement
+	}
+	return r.replacement
+}
@@ -0,0 +1,160 @@
+// Package resultcache keeps the results of commands marked cacheable for a short time, so that
+// agents repeating inspection commands such as git status or ls get them without running the
+// commands again.
+package resultcache
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+const bytesPerKilobyte = 1024
+
+// Stream is the output stream a chunk was written to.
+type Stream int
+
+// Output streams of a command.
+const (
+	Stdout Stream = iota
+	Stderr
+)
+
+// Chunk is output written to a stream at once. Results keep chunks in the order they were
+// written, so that replaying them interleaves standard output and standard error as before.
+type Chunk struct {
+	Stream Stream
+	Data   []byte
+}
+
+// Result is the output and exit status of a command.
+type Result struct {
+	Chunks []Chunk
+	Status uint8
+}
+
+// Size returns the number of bytes of output in res.
+func (res Result) Size() int {
+	size := 0
+	for _, c := range res.Chunks {
+		size += len(c.Data)
+	}
+	return size
+}
+
+// entry is a cached result.
+type entry struct {
+	key     string
+	result  Result
+	expires time.Time
+}
+
+// Cache holds the results of commands keyed by their arguments and working directory, each
+// for a fixed time. When it is full, the least recently used result is evicted.
+type Cache struct {
+	ttl          time.Duration
+	maxEntries   int
+	maxEntrySize int
+	// now returns the current time; replaced in tests
+	now func() time.Time
+
+	mu sync.Mutex
+	// lru holds the entries, most recently used first
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+// New creates a Cache configured by cfg.
+func New(cfg config.ResultCacheConfig) *Cache {
+	ttl := cfg.TTL
+	if ttl == 0 {
+		ttl = config.DefaultResultCacheTTL
+	}
+	maxEntries := cfg.MaxEntries
+	if maxEntries == 0 {
+		maxEntries = config.DefaultResultCacheMaxEntries
+	}
+	maxEntrySize := cfg.MaxEntrySize
+	if maxEntrySize == 0 {
+		maxEntrySize = config.DefaultResultCacheMaxEntrySize
+	}
+	return &Cache{
+		ttl:          time.Duration(ttl) * time.Second,
+		maxEntries:   maxEntries,
+		maxEntrySize: maxEntrySize * bytesPerKilobyte,
+		now:          time.Now,
+		lru:          list.New(),
+		entries:      make(map[string]*list.Element),
+	}
+}
+
+// MaxEntrySize returns the size in bytes of the largest output that is cached.
+func (c *Cache) MaxEntrySize() int {
+	return c.maxEntrySize
+}
+
+// Get returns the result of args run in dir, unless there is none or it has expired.
+func (c *Cache) Get(dir string, args []string) (Result, bool) {
+	k := key(dir, args)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[k]
+	if !ok {
+		return Result{}, false
+	}
+	e := el.Value.(*entry)
+	if !c.now().Before(e.expires) {
+		c.remove(el)
+		return Result{}, false
+	}
+	c.lru.MoveToFront(el)
+	return e.result, true
+}
+
+// Put stores the result of args run in dir, unless its output is larger than MaxEntrySize.
+func (c *Cache) Put(dir string, args []string, res Result) {
+	if res.Size() > c.maxEntrySize {
+		return
+	}
+	k := key(dir, args)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[k]; ok {
+		c.remove(el)
+	}
+	c.entries[k] = c.lru.PushFront(&entry{key: k, result: res, expires: c.now().Add(c.ttl)})
+	for c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+// Purge removes every result, e.g. after a command that may have changed what cached commands
+// would print.
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	clear(c.entries)
+}
+
+// Len returns the number of cached results, including expired ones not yet removed.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// remove removes el from the cache. c.mu must be held.
+func (c *Cache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*entry).key)
+}
+
+// key returns the cache key of args run in dir. Arguments cannot contain NUL bytes, so joining
+// with them keeps keys of different commands apart.
+func key(dir string, args []string) string {
+	return dir + "\x00" + strings.Join(args, "\x00")
+}
@@ -0,0 +1,86 @@
+package resultcache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+// result returns a result with a single chunk of standard output.
+func result(out string) Result {
+	return Result{Chunks: []Chunk{{Stream: Stdout, Data: []byte(out)}}}
+}
+
+func TestGetPut(t *testing.T) {
+	c := New(config.ResultCacheConfig{})
+	want := Result{Chunks: []Chunk{{Stream: Stdout, Data: []byte("out")}, {Stream: Stderr, Data: []byte("err")}}, Status: 1}
+	c.Put("/repo", []string{"git", "status"}, want)
+
+	got, ok := c.Get("/repo", []string{"git", "status"})
+	if !ok || got.Status != 1 || len(got.Chunks) != 2 || string(got.Chunks[1].Data) != "err" {
+		t.Errorf("Get() = %+v, %v; want %+v", got, ok, want)
+	}
+
+	misses := []struct {
+		dir  string
+		args []string
+	}{
+		{"/other", []string{"git", "status"}},
+		{"/repo", []string{"git", "status", "-s"}},
+		{"/repo", []string{"git status"}},
+	}
+	for _, m := range misses {
+		if _, ok := c.Get(m.dir, m.args); ok {
+			t.Errorf("Get(%q, %q) hit the result of git status in /repo", m.dir, m.args)
+		}
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	now := time.Now()
+	c := New(config.ResultCacheConfig{TTL: 10})
+	c.now = func() time.Time { return now }
+
+	c.Put("/repo", []string{"ls"}, result("a\n"))
+	now = now.Add(9 * time.Second)
+	if _, ok := c.Get("/repo", []string{"ls"}); !ok {
+		t.Error("result expired before its TTL")
+	}
+	now = now.Add(time.Second)
+	if _, ok := c.Get("/repo", []string{"ls"}); ok {
+		t.Error("result did not expire after its TTL")
+	}
+	if c.Len() != 0 {
+		t.Errorf("expired result was kept: %d entries", c.Len())
+	}
+}
+
+func TestBounds(t *testing.T) {
+	c := New(config.ResultCacheConfig{MaxEntries: 2, MaxEntrySize: 1})
+
+	c.Put("/", []string{"a"}, result("a"))
+	c.Put("/", []string{"b"}, result("b"))
+	// Using a makes b the least recently used
+	c.Get("/", []string{"a"})
+	c.Put("/", []string{"c"}, result("c"))
+	if _, ok := c.Get("/", []string{"b"}); ok {
+		t.Error("least recently used result was not evicted")
+	}
+	for _, name := range []string{"a", "c"} {
+		if _, ok := c.Get("/", []string{name}); !ok {
+			t.Errorf("result of %s was evicted", name)
+		}
+	}
+
+	large := make([]byte, 1025)
+	c.Put("/", []string{"large"}, result(string(large)))
+	if _, ok := c.Get("/", []string{"large"}); ok {
+		t.Error("result larger than maxEntrySize was cached")
+	}
+
+	c.Purge()
+	if c.Len() != 0 {
+		t.Errorf("Purge() left %d entries", c.Len())
+	}
+}
@@ -0,0 +1,304 @@
+// Package risk scores how dangerous a shell script looks before it runs.
+//
+// Each command is matched against a set of categories (file deletion, network
+// access, privilege escalation, obfuscation). A script's score is the sum of the
+// weights of the categories it matches, each counted once, capped at MaxScore.
+package risk
+
+import (
+	"path"
+	"slices"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// MaxScore is the highest possible score.
+const MaxScore = 100
+
+// Category is a kind of risky behavior.
+type Category string
+
+const (
+	// CategoryFileDeletion covers commands that delete files.
+	CategoryFileDeletion Category = "file-deletion"
+	// CategoryNetwork covers commands that transfer data over the network.
+	CategoryNetwork Category = "network"
+	// CategoryPrivilegeEscalation covers commands that change users or gain privileges.
+	CategoryPrivilegeEscalation Category = "privilege-escalation"
+	// CategoryObfuscation covers constructs that hide what will actually run.
+	CategoryObfuscation Category = "obfuscation"
+)
+
+// weights is the score contributed by each category.
+var weights = map[Category]int{
+	CategoryFileDeletion:        30,
+	CategoryNetwork:             25,
+	CategoryPrivilegeEscalation: 50,
+	CategoryObfuscation:         40,
+}
+
+// Weight returns the score contributed by c.
+func Weight(c Category) int {
+	return weights[c]
+}
+
+// commandCategories maps command names to the category they always match.
+var commandCategories = map[string]Category{
+	"rm":      CategoryFileDeletion,
+	"rmdir":   CategoryFileDeletion,
+	"unlink":  CategoryFileDeletion,
+	"shred":   CategoryFileDeletion,
+	"srm":     CategoryFileDeletion,
+	"curl":    CategoryNetwork,
+	"wget":    CategoryNetwork,
+	"nc":      CategoryNetwork,
+	"ncat":    CategoryNetwork,
+	"netcat":  CategoryNetwork,
+	"socat":   CategoryNetwork,
+	"telnet":  CategoryNetwork,
+	"ftp":     CategoryNetwork,
+	"ssh":     CategoryNetwork,
+	"scp":     CategoryNetwork,
+	"sftp":    CategoryNetwork,
+	"rsync":   CategoryNetwork,
+	"nmap":    CategoryNetwork,
+	"sudo":    CategoryPrivilegeEscalation,
+	"su":      CategoryPrivilegeEscalation,
+	"doas":    CategoryPrivilegeEscalation,
+	"pkexec":  CategoryPrivilegeEscalation,
+	"runuser": CategoryPrivilegeEscalation,
+	"setcap":  CategoryPrivilegeEscalation,
+	"chown":   CategoryPrivilegeEscalation,
+	"chgrp":   CategoryPrivilegeEscalation,
+	"eval":    CategoryObfuscation,
+}
+
+// gitNetworkSubCommands are git subcommands that contact a remote.
+var gitNetworkSubCommands = map[string]bool{"clone": true, "fetch": true, "pull": true, "push": true}
+
+// shells are interpreters that run code read from stdin when given no script.
+var shells = map[string]bool{
+	"sh": true, "bash": true, "dash": true, "zsh": true, "ksh": true,
+	"python": true, "python3": true, "perl": true, "ruby": true, "node": true,
+}
+
+// Finding is a single match of a category in a script.
+type Finding struct {
+	Category Category
+	Command  string
+	Line     uint
+	Column   uint
+	Reason   string
+}
+
+// Assessment is the risk score of a script and the findings behind it.
+type Assessment struct {
+	Score    int
+	Findings []Finding
+}
+
+// Categories returns the distinct categories found, highest weight first.
+func (a Assessment) Categories() []Category {
+	var categories []Category
+	for _, f := range a.Findings {
+		if !slices.Contains(categories, f.Category) {
+			categories = append(categories, f.Category)
+		}
+	}
+	slices.SortStableFunc(categories, func(x, y Category) int { return weights[y] - weights[x] })
+	return categories
+}
+
+// Assess scores a parsed script.
+func Assess(prog *syntax.File) Assessment {
+	var a Assessment
+	syntax.Walk(prog, func(node syntax.Node) bool {
+		switch n := node.(type) {
+		case *syntax.CallExpr:
+			a.assessCall(n)
+		case *syntax.BinaryCmd:
+			a.assessPipe(n)
+		case *syntax.Redirect:
+			a.assessRedirect(n)
+		case *syntax.SglQuoted:
+			if n.Dollar && hasEncodedBytes(n.Value) {
+				a.add(CategoryObfuscation, "", n.Pos(), "ANSI-C quoted string with encoded bytes")
+			}
+		}
+		return true
+	})
+
+	for _, c := range a.Categories() {
+		a.Score += weights[c]
+	}
+	a.Score = min(a.Score, MaxScore)
+	return a
+}
+
+// add records a finding.
+func (a *Assessment) add(c Category, cmd string, pos syntax.Pos, reason string) {
+	a.Findings = append(a.Findings, Finding{Category: c, Command: cmd, Line: pos.Line(), Column: pos.Col(), Reason: reason})
+}
+
+// assessCall matches a simple command.
+func (a *Assessment) assessCall(call *syntax.CallExpr) {
+	if len(call.Args) == 0 {
+		return
+	}
+	name, ok := literalWord(call.Args[0])
+	if !ok {
+		a.add(CategoryObfuscation, "", call.Pos(), "command name is computed at run time")
+		return
+	}
+	args := make([]string, 0, len(call.Args)-1)
+	for _, word := range call.Args[1:] {
+		// Dynamic arguments are skipped; they are never needed to recognize a category
+		if arg, ok := literalWord(word); ok {
+			args = append(args, arg)
+		}
+	}
+	a.assessCommand(path.Base(name), args, call.Pos())
+}
+
+// assessCommand matches a command and the commands it runs, such as those run by xargs or find -exec.
+func (a *Assessment) assessCommand(cmd string, args []string, pos syntax.Pos) {
+	if c, ok := commandCategories[cmd]; ok {
+		a.add(c, cmd, pos, "runs "+cmd)
+	}
+
+	switch cmd {
+	case "git":
+		for i := 0; i < len(args); i++ {
+			switch arg := args[i]; {
+			case arg == "-C" || arg == "-c":
+				// Skip the option's value
+				i++
+			case strings.HasPrefix(arg, "-"):
+			default:
+				if gitNetworkSubCommands[arg] {
+					a.add(CategoryNetwork, cmd, pos, "runs git "+arg)
+				}
+				return
+			}
+		}
+	case "chmod":
+		if len(args) > 0 && isSetIDMode(args[0]) {
+			a.add(CategoryPrivilegeEscalation, cmd, pos, "sets the setuid or setgid bit")
+		}
+	case "base64":
+		if slices.Contains(args, "-d") || slices.Contains(args, "--decode") || slices.Contains(args, "-D") {
+			a.add(CategoryObfuscation, cmd, pos, "decodes base64 data")
+		}
+	case "xxd":
+		if slices.Contains(args, "-r") {
+			a.add(CategoryObfuscation, cmd, pos, "decodes hex data")
+		}
+	case "find":
+		for i, arg := range args {
+			switch arg {
+			case "-delete":
+				a.add(CategoryFileDeletion, cmd, pos, "runs find -delete")
+			case "-exec", "-execdir", "-ok", "-okdir":
+				if i+1 < len(args) {
+					a.assessCommand(path.Base(args[i+1]), args[i+2:], pos)
+				}
+			}
+		}
+	case "xargs", "env", "nohup", "nice", "timeout", "sudo", "doas":
+		// The first operand is the command that is run; options and their numeric values are skipped
+		for i, arg := range args {
+			if strings.HasPrefix(arg, "-") || strings.Contains(arg, "=") || strings.Trim(arg, "0123456789") == "" ||
+				(cmd == "timeout" && i == 0) {
+				continue
+			}
+			a.assessCommand(path.Base(arg), args[i+1:], pos)
+			break
+		}
+	}
+}
+
+// assessPipe matches a pipeline into a shell that reads its program from stdin, as in "curl ... | sh".
+func (a *Assessment) assessPipe(bin *syntax.BinaryCmd) {
+	if bin.Op != syntax.Pipe && bin.Op != syntax.PipeAll {
+		return
+	}
+	call, ok := bin.Y.Cmd.(*syntax.CallExpr)
+	if !ok || len(call.Args) == 0 {
+		return
+	}
+	name, ok := literalWord(call.Args[0])
+	if !ok || !shells[path.Base(name)] {
+		return
+	}
+	for _, word := range call.Args[1:] {
+		arg, ok := literalWord(word)
+		if !ok || !strings.HasPrefix(arg, "-") || arg == "-c" {
+			return
+		}
+	}
+	a.add(CategoryObfuscation, path.Base(name), call.Pos(), "pipes data into an interpreter")
+}
+
+// assessRedirect matches redirections to the network pseudo-devices of bash.
+func (a *Assessment) assessRedirect(redir *syntax.Redirect) {
+	if redir.Word == nil {
+		return
+	}
+	target, ok := literalWord(redir.Word)
+	if ok && (strings.HasPrefix(target, "/dev/tcp/") || strings.HasPrefix(target, "/dev/udp/")) {
+		a.add(CategoryNetwork, "", redir.Pos(), "redirects to "+target)
+	}
+}
+
+// isSetIDMode reports whether a chmod mode sets the setuid or setgid bit.
+func isSetIDMode(mode string) bool {
+	if strings.ContainsRune(mode, 's') && strings.ContainsAny(mode, "+=") {
+		return true
+	}
+	// Four-digit octal modes carry the special bits in the first digit; 1 is only the sticky bit
+	if len(mode) == 4 && strings.Trim(mode, "01234567") == "" {
+		return mode[0] != '0' && mode[0] != '1'
+	}
+	return false
+}
+
+// hasEncodedBytes reports whether an ANSI-C quoted string contains hex, unicode, or octal escapes.
+func hasEncodedBytes(s string) bool {
+	for i := 0; i+1 < len(s); i++ {
+		if s[i] != '\\' {
+			continue
+		}
+		switch next := s[i+1]; {
+		case next == 'x' || next == 'u' || next == 'U' || (next >= '0' && next <= '7'):
+			return true
+		case next == '\\':
+			i++
+		}
+	}
+	return false
+}
+
+// literalWord returns the value of a word made only of literal and quoted literal parts.
+func literalWord(word *syntax.Word) (string, bool) {
+	var sb strings.Builder
+	for _, part := range word.Parts {
+		switch p := part.(type) {
+		case *syntax.Lit:
+			sb.WriteString(p.Value)
+		case *syntax.SglQuoted:
+			sb.WriteString(p.Value)
+		case *syntax.DblQuoted:
+			for _, inner := range p.Parts {
+				lit, ok := inner.(*syntax.Lit)
+				if !ok {
+					return "", false
+				}
+				sb.WriteString(lit.Value)
+			}
+		default:
+			return "", false
+		}
+	}
+	return sb.String(), true
+}
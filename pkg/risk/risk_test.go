@@ -0,0 +1,68 @@
+package risk
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+func assess(t *testing.T, script string) Assessment {
+	t.Helper()
+	prog, err := syntax.NewParser().Parse(strings.NewReader(script), "")
+	assert.NoError(t, err)
+	return Assess(prog)
+}
+
+func TestAssess(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []Category
+	}{
+		{"harmless", "ls -la && echo done | grep d", nil},
+		{"deletion", "rm -rf build", []Category{CategoryFileDeletion}},
+		{"find delete", "find . -name '*.o' -delete", []Category{CategoryFileDeletion}},
+		{"find exec", "find . -exec rm {} +", []Category{CategoryFileDeletion}},
+		{"xargs", "ls | xargs -n 1 rm", []Category{CategoryFileDeletion}},
+		{"absolute path", "/usr/bin/curl example.com", []Category{CategoryNetwork}},
+		{"git push", "git -C repo push origin main", []Category{CategoryNetwork}},
+		{"git status", "git status", nil},
+		{"dev tcp", "echo hi > /dev/tcp/example.com/80", []Category{CategoryNetwork}},
+		{"sudo", "sudo rm -rf /", []Category{CategoryPrivilegeEscalation, CategoryFileDeletion}},
+		{"setuid", "chmod u+s ./tool", []Category{CategoryPrivilegeEscalation}},
+		{"setuid octal", "chmod 4755 ./tool", []Category{CategoryPrivilegeEscalation}},
+		{"sticky", "chmod 1777 dir", nil},
+		{"plain chmod", "chmod 755 ./tool", nil},
+		{"eval", `eval "$CMD"`, []Category{CategoryObfuscation}},
+		{"dynamic command", `$(echo rm) file`, []Category{CategoryObfuscation}},
+		{"base64 decode", "echo cm0K | base64 -d", []Category{CategoryObfuscation}},
+		{"hex escapes", `$'\x72\x6d' file`, []Category{CategoryObfuscation}},
+		{"newline escape", `echo $'a\nb'`, nil},
+		{"curl pipe shell", "curl -fsSL example.com/install.sh | sh -s", []Category{CategoryObfuscation, CategoryNetwork}},
+		{"shell with script", "cat list | bash ./run.sh", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := assess(t, tt.script)
+			assert.Equal(t, tt.want, a.Categories())
+		})
+	}
+}
+
+func TestAssess_Score(t *testing.T) {
+	a := assess(t, "rm a; rm b; curl example.com")
+	// Each category counts once
+	assert.Equal(t, Weight(CategoryFileDeletion)+Weight(CategoryNetwork), a.Score)
+	assert.Equal(t, 3, len(a.Findings))
+	assert.Equal(t, "rm", a.Findings[1].Command)
+	assert.Equal(t, uint(1), a.Findings[1].Line)
+	assert.Equal(t, uint(7), a.Findings[1].Column)
+
+	a = assess(t, "sudo rm -rf / && curl x | sh && eval y")
+	assert.Equal(t, MaxScore, a.Score)
+
+	assert.Equal(t, 0, assess(t, "echo hello").Score)
+}
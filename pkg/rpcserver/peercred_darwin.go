@@ -0,0 +1,36 @@
+package rpcserver
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// peerCredentials returns the credentials of the process connected to conn, read with LOCAL_PEERCRED.
+func peerCredentials(conn *net.UnixConn) (Peer, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return Peer{}, fmt.Errorf("failed to read peer credentials: %w", err)
+	}
+	var cred *unix.Xucred
+	var pid int
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+		if credErr == nil {
+			// The PID is informational; older kernels do not report it
+			pid, _ = unix.GetsockoptInt(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERPID)
+		}
+	}); err != nil {
+		return Peer{}, fmt.Errorf("failed to read peer credentials: %w", err)
+	}
+	if credErr != nil {
+		return Peer{}, fmt.Errorf("failed to read peer credentials: %w", credErr)
+	}
+	peer := Peer{PID: pid, UID: cred.Uid}
+	if cred.Ngroups > 0 {
+		peer.GID = cred.Groups[0]
+	}
+	return peer, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package rpcserver
+
+import "net"
+
+// peerCredentials fails because the platform cannot identify the peer of a Unix socket.
+func peerCredentials(_ *net.UnixConn) (Peer, error) {
+	return Peer{}, errPeerCredentialsUnsupported
+}
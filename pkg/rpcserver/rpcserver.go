@@ -0,0 +1,658 @@
+// Package rpcserver exposes the secure shell policy as line-delimited JSON-RPC 2.0,
+// so editors and agent runtimes can embed the server as a subprocess over stdin/stdout.
+//
+// Each line read is one request and each line written is one response. ServeUnix serves
+// the same protocol to local processes over a Unix domain socket, identifying each by its
+// peer credentials. Three methods
+// are supported: "exec" runs a command, "validate" checks a script without running it,
+// and "cancel" aborts an in-flight exec by its request id. With outputSpool enabled,
+// "output" reads the full output of an exec whose output was truncated. With snapshot
+// enabled, execs run against a snapshot of their working directory, whose changes "commit"
+// applies to the directory and "discard" throws away.
+package rpcserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+	"mvdan.cc/sh/v3/interp"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/alert"
+	"github.com/shimizu1995/secure-shell-server/pkg/approval"
+	"github.com/shimizu1995/secure-shell-server/pkg/audit"
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/fileaudit"
+	"github.com/shimizu1995/secure-shell-server/pkg/history"
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/ratelimit"
+	"github.com/shimizu1995/secure-shell-server/pkg/recording"
+	"github.com/shimizu1995/secure-shell-server/pkg/resultcache"
+	"github.com/shimizu1995/secure-shell-server/pkg/runner"
+	"github.com/shimizu1995/secure-shell-server/pkg/session"
+	"github.com/shimizu1995/secure-shell-server/pkg/snapshot"
+	"github.com/shimizu1995/secure-shell-server/pkg/spool"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+const (
+	// jsonrpcVersion is the only protocol version accepted.
+	jsonrpcVersion = "2.0"
+	// maxLineSize bounds a single request line.
+	maxLineSize = 1024 * 1024
+	// stdioCallerID identifies the stdio client for rate limiting; there is only ever one.
+	stdioCallerID = "stdio"
+)
+
+// JSON-RPC error codes.
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+	// codeRequestCancelled is returned for an exec aborted by "cancel".
+	codeRequestCancelled = -32800
+)
+
+// request is a JSON-RPC request or notification. Notifications have no id and get no response.
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// response is a JSON-RPC response carrying either a result or an error.
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// rpcError is the error object of a failed request.
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// ExecParams are the parameters of the "exec" method.
+type ExecParams struct {
+	Command string `json:"command"`
+	// WorkDir defaults to the first allowed directory.
+	WorkDir string `json:"workDir,omitempty"`
+	// Snapshot is the ID of a snapshot from an earlier ExecResult to run in, so that the
+	// command sees its changes.
+	Snapshot string `json:"snapshot,omitempty"`
+}
+
+// ExecResult is the result of the "exec" method. A command rejected by the policy
+// or exiting non-zero is still a successful call; Error and ExitCode describe the failure.
+type ExecResult struct {
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	ExitCode int    `json:"exitCode"`
+	Error    string `json:"error,omitempty"`
+	// WorkDir is set when the command changed directory with cd.
+	WorkDir string `json:"workDir,omitempty"`
+	// StdoutSpool and StderrSpool are the output IDs of the full output of a truncated
+	// stream, read with the "output" method.
+	StdoutSpool string `json:"stdoutSpool,omitempty"`
+	StderrSpool string `json:"stderrSpool,omitempty"`
+	// Snapshot is the ID of the snapshot keeping the command's changes, to be committed or
+	// discarded, and Changes lists them.
+	Snapshot string            `json:"snapshot,omitempty"`
+	Changes  []snapshot.Change `json:"changes,omitempty"`
+	// Files lists the files the command created, modified, or deleted when fileAudit is
+	// enabled; FilesIncomplete is set when some files were not scanned.
+	Files           []fileaudit.Change `json:"files,omitempty"`
+	FilesIncomplete bool               `json:"filesIncomplete,omitempty"`
+}
+
+// ValidateParams are the parameters of the "validate" method.
+type ValidateParams struct {
+	Command string `json:"command"`
+	WorkDir string `json:"workDir,omitempty"`
+}
+
+// ValidateResult is the result of the "validate" method.
+type ValidateResult struct {
+	Valid      bool        `json:"valid"`
+	Violations []Violation `json:"violations"`
+	// RiskScore is the script's risk score from 0 to 100; RiskCategories are the categories behind it.
+	RiskScore      int      `json:"riskScore"`
+	RiskCategories []string `json:"riskCategories,omitempty"`
+}
+
+// Violation is a validator.Violation as sent over the wire.
+type Violation struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+	Line    uint     `json:"line"`
+	Column  uint     `json:"column"`
+	Rule    string   `json:"rule"`
+	Message string   `json:"message"`
+	// RuleRef is the allowCommands or denyCommands entry behind the violation, if one is.
+	RuleRef *validator.RuleRef `json:"ruleRef,omitempty"`
+}
+
+// CancelParams are the parameters of the "cancel" method.
+type CancelParams struct {
+	// ID is the id of the exec request to cancel.
+	ID json.RawMessage `json:"id"`
+}
+
+// CancelResult is the result of the "cancel" method.
+type CancelResult struct {
+	// Cancelled is false when no exec with that id was running.
+	Cancelled bool `json:"cancelled"`
+}
+
+// OutputParams are the parameters of the "output" method.
+type OutputParams struct {
+	// ID is an output ID from ExecResult.
+	ID     string `json:"id"`
+	Offset int64  `json:"offset,omitempty"`
+	// Length defaults to, and may not exceed, maxOutputSize.
+	Length int `json:"length,omitempty"`
+	// Tail reads the last Length bytes instead of reading from Offset.
+	Tail bool `json:"tail,omitempty"`
+}
+
+// OutputResult is the result of the "output" method.
+type OutputResult struct {
+	Data   string `json:"data"`
+	Offset int64  `json:"offset"`
+	// Size is the size of the whole output.
+	Size int64 `json:"size"`
+	// EOF is true when Data reaches the end of the output.
+	EOF bool `json:"eof"`
+}
+
+// SnapshotParams are the parameters of the "commit" and "discard" methods.
+type SnapshotParams struct {
+	// ID is a snapshot ID from ExecResult.
+	ID string `json:"id"`
+}
+
+// CommitResult is the result of the "commit" method.
+type CommitResult struct {
+	// Changes lists the changes applied to the snapshotted directory.
+	Changes []snapshot.Change `json:"changes"`
+}
+
+// DiscardResult is the result of the "discard" method.
+type DiscardResult struct {
+	Discarded bool `json:"discarded"`
+}
+
+// Server answers JSON-RPC requests under the configured policy.
+type Server struct {
+	config    *config.ShellCommandConfig
+	validator *validator.CommandValidator
+	logger    *logger.Logger
+	limiter   *ratelimit.Limiter
+	// sessions limits the connections of each identity
+	sessions *session.Manager
+	// connSession is the session of the connection served, opened by Serve; sessionErr is
+	// why it could not be opened, which every exec fails with
+	connSession *session.Session
+	sessionErr  error
+	// identity identifies the client in logs, rate limits, history, and recordings
+	identity identity.Identity
+	// policy, when set, selects the policy of each Unix socket connection
+	policy PolicyFunc
+
+	writeMu sync.Mutex
+	encoder *json.Encoder
+	// recorder records the session when recordingDir is configured
+	recorder *recording.Recorder
+	// tracerProvider, when set, receives the spans of every exec
+	tracerProvider trace.TracerProvider
+	// approvals, when set, holds commands marked approvalRequired until an approver decides
+	approvals *approval.Queue
+	// history, when set, records every command
+	history *history.History
+	// alerter raises alerts for honeypot deny rules and tracks frozen sessions
+	alerter *alert.Alerter
+	// auditor sends every denied or executed command to the configured audit sinks
+	auditor *audit.Auditor
+	// spool keeps the full output of truncated execs when outputSpool is enabled
+	spool *spool.Store
+	// snapshots holds the snapshots execs ran in when snapshot is enabled
+	snapshots *snapshot.Store
+	// resultCache holds the results of commands marked cacheable
+	resultCache *resultcache.Cache
+
+	mu       sync.Mutex
+	inflight map[string]context.CancelFunc
+	// limiters holds the rate limiters of policies chosen by the PolicyFunc
+	limiters map[*config.ShellCommandConfig]*ratelimit.Limiter
+	// sessionManagers holds the session limits of policies chosen by the PolicyFunc
+	sessionManagers map[*config.ShellCommandConfig]*session.Manager
+	wg              sync.WaitGroup
+}
+
+// New creates a Server.
+func New(cfg *config.ShellCommandConfig, v *validator.CommandValidator, log *logger.Logger) *Server {
+	return &Server{
+		config:      cfg,
+		validator:   v,
+		logger:      log,
+		limiter:     ratelimit.NewForPolicy(cfg),
+		sessions:    session.New(cfg.Sessions),
+		identity:    identity.Identity{Session: stdioCallerID},
+		alerter:     alert.New(cfg.Alerts),
+		auditor:     audit.New(cfg.Audit),
+		spool:       newSpool(cfg),
+		snapshots:   newSnapshots(cfg),
+		resultCache: newResultCache(cfg),
+		inflight:    make(map[string]context.CancelFunc),
+	}
+}
+
+// newSpool returns the store of spooled outputs, or nil when outputSpool is disabled.
+func newSpool(cfg *config.ShellCommandConfig) *spool.Store {
+	if !cfg.OutputSpool.Enabled {
+		return nil
+	}
+	return spool.New(cfg.OutputSpool)
+}
+
+// newSnapshots returns the store of snapshots, or nil when snapshot is disabled.
+func newSnapshots(cfg *config.ShellCommandConfig) *snapshot.Store {
+	if !cfg.Snapshot.Enabled {
+		return nil
+	}
+	return snapshot.New(cfg.Snapshot)
+}
+
+// newResultCache returns the cache of results, or nil when no command is marked cacheable.
+func newResultCache(cfg *config.ShellCommandConfig) *resultcache.Cache {
+	if !cfg.CachesResults() {
+		return nil
+	}
+	return resultcache.New(cfg.ResultCache)
+}
+
+// SetTracerProvider emits the spans of executed commands through tp instead of the global provider.
+// It must be called before Serve.
+func (s *Server) SetTracerProvider(tp trace.TracerProvider) {
+	s.tracerProvider = tp
+}
+
+// SetApprovals holds commands marked approvalRequired in q. Without a queue, such commands are denied.
+// It must be called before Serve.
+func (s *Server) SetApprovals(q *approval.Queue) {
+	s.approvals = q
+}
+
+// SetIdentity attributes the requests served by Serve to id instead of an anonymous stdio session,
+// e.g. when the program starting the server knows the user or agent. It must be called before Serve.
+func (s *Server) SetIdentity(id identity.Identity) {
+	s.identity = id
+}
+
+// SetHistory records every executed or denied command in h. It must be called before Serve.
+func (s *Server) SetHistory(h *history.History) {
+	s.history = h
+}
+
+// Serve reads requests from r and writes responses to w until r is exhausted or ctx is done.
+// exec requests run concurrently; Serve waits for them to finish before returning.
+func (s *Server) Serve(ctx context.Context, r io.Reader, w io.Writer) error {
+	s.encoder = json.NewEncoder(w)
+	s.connSession, s.sessionErr = s.sessions.Open(s.identity.Key())
+	if s.sessionErr != nil {
+		s.logger.LogErrorf("Session refused: %v", s.sessionErr)
+	}
+	defer s.connSession.Close()
+	// Execs still running when the session's lifetime ends are stopped
+	ctx, cancel := s.connSession.Context(ctx)
+	defer cancel()
+	if s.config.RecordingDir != "" {
+		rec, err := recording.Create(s.config.RecordingDir, s.identity.Key(), "JSON-RPC session")
+		if err != nil {
+			s.logger.LogErrorf("Failed to start session recording: %v", err)
+		} else {
+			s.recorder = rec
+			defer rec.Close()
+		}
+	}
+	if s.spool != nil {
+		// Spooled outputs can only be read during the session
+		defer s.spool.Close()
+	}
+	if s.snapshots != nil {
+		// Snapshots that were not committed during the session are discarded
+		defer s.snapshots.Close()
+	}
+	defer s.wg.Wait()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxLineSize)
+	for scanner.Scan() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		s.handleLine(ctx, []byte(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read request: %w", err)
+	}
+	return nil
+}
+
+// handleLine decodes and dispatches a single request.
+func (s *Server) handleLine(ctx context.Context, line []byte) {
+	var req request
+	if err := json.Unmarshal(line, &req); err != nil {
+		s.writeError(nil, codeParseError, "parse error: "+err.Error())
+		return
+	}
+	if req.JSONRPC != jsonrpcVersion || req.Method == "" {
+		s.writeError(req.ID, codeInvalidRequest, "invalid request")
+		return
+	}
+
+	switch req.Method {
+	case "exec":
+		var params ExecParams
+		if !s.decodeParams(req, &params) {
+			return
+		}
+		s.startExec(ctx, req.ID, params)
+	case "validate":
+		var params ValidateParams
+		if !s.decodeParams(req, &params) {
+			return
+		}
+		s.writeResult(req.ID, s.validate(params))
+	case "cancel":
+		var params CancelParams
+		if !s.decodeParams(req, &params) {
+			return
+		}
+		s.writeResult(req.ID, CancelResult{Cancelled: s.cancel(params.ID)})
+	case "output":
+		var params OutputParams
+		if !s.decodeParams(req, &params) {
+			return
+		}
+		result, err := s.output(params)
+		if err != nil {
+			s.writeError(req.ID, codeInvalidParams, err.Error())
+			return
+		}
+		s.writeResult(req.ID, result)
+	case "commit", "discard":
+		var params SnapshotParams
+		if !s.decodeParams(req, &params) {
+			return
+		}
+		result, err := s.finishSnapshot(req.Method, params)
+		if err != nil {
+			s.writeError(req.ID, codeInvalidParams, err.Error())
+			return
+		}
+		s.writeResult(req.ID, result)
+	default:
+		s.writeError(req.ID, codeMethodNotFound, "method not found: "+req.Method)
+	}
+}
+
+// decodeParams unmarshals the request parameters, responding with an error if they are invalid.
+func (s *Server) decodeParams(req request, params any) bool {
+	if len(req.Params) == 0 {
+		s.writeError(req.ID, codeInvalidParams, "missing params")
+		return false
+	}
+	if err := json.Unmarshal(req.Params, params); err != nil {
+		s.writeError(req.ID, codeInvalidParams, "invalid params: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// startExec runs a command in the background so that it can be cancelled by a later request.
+func (s *Server) startExec(ctx context.Context, id json.RawMessage, params ExecParams) {
+	if params.Command == "" {
+		s.writeError(id, codeInvalidParams, "command must not be empty")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	key := string(id)
+	if id != nil {
+		s.mu.Lock()
+		if _, exists := s.inflight[key]; exists {
+			s.mu.Unlock()
+			cancel()
+			s.writeError(id, codeInvalidRequest, "request id is already in use: "+key)
+			return
+		}
+		s.inflight[key] = cancel
+		s.mu.Unlock()
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+
+		result, err := s.exec(ctx, params)
+
+		if id == nil {
+			return
+		}
+		s.mu.Lock()
+		delete(s.inflight, key)
+		s.mu.Unlock()
+
+		switch {
+		case errors.Is(ctx.Err(), context.Canceled):
+			s.writeError(id, codeRequestCancelled, "request cancelled")
+		case err != nil:
+			s.writeError(id, codeInternalError, err.Error())
+		default:
+			s.writeResult(id, result)
+		}
+	}()
+}
+
+// exec runs a single command and collects its output.
+func (s *Server) exec(ctx context.Context, params ExecParams) (ExecResult, error) {
+	workDir := params.WorkDir
+	if workDir == "" {
+		workDir = s.defaultWorkingDir()
+	}
+	s.logger.LogInfof("RPC exec: %s in directory: %s", params.Command, workDir)
+
+	ctx = identity.WithIdentity(ctx, s.identity)
+	if s.sessionErr != nil {
+		return ExecResult{}, s.sessionErr
+	}
+	if err := s.connSession.Begin(); err != nil {
+		return ExecResult{}, err
+	}
+	release, err := s.limiter.Acquire(s.identity.Key())
+	if err != nil {
+		return ExecResult{}, err
+	}
+	defer release()
+
+	var stdout, stderr strings.Builder
+	r := runner.New(s.config, s.validator, s.logger)
+	if s.tracerProvider != nil {
+		r.SetTracerProvider(s.tracerProvider)
+	}
+	r.SetApprovals(s.approvals)
+	r.SetHistory(s.history, s.identity.Key())
+	r.SetAlerter(s.alerter)
+	r.SetAuditor(s.auditor)
+	r.SetSpool(s.spool)
+	r.SetSnapshots(s.snapshots)
+	r.SetSnapshot(params.Snapshot)
+	r.SetResultCache(s.resultCache)
+	if s.recorder != nil {
+		r.SetRecorder(s.recorder)
+	}
+	r.SetOutputs(&stdout, &stderr)
+
+	result := r.RunCommand(ctx, params.Command, workDir)
+	execResult := ExecResult{
+		Stdout:  stdout.String(),
+		Stderr:  stderr.String(),
+		WorkDir: result.NewWorkDir,
+	}
+	if result.StdoutSpool != nil {
+		execResult.StdoutSpool = result.StdoutSpool.ID
+	}
+	if result.StderrSpool != nil {
+		execResult.StderrSpool = result.StderrSpool.ID
+	}
+	if result.Snapshot != nil {
+		execResult.Snapshot = result.Snapshot.ID
+		execResult.Changes, _ = result.Snapshot.Changes()
+	}
+	execResult.Files, execResult.FilesIncomplete = result.FileChanges, result.FileChangesIncomplete
+	execResult.ExitCode = runner.ExitCode(result.Err)
+	if _, ok := interp.IsExitStatus(result.Err); result.Err != nil && !ok {
+		execResult.Error = result.Err.Error()
+	}
+	return execResult, nil
+}
+
+// validate checks a script against the policy without running it.
+func (s *Server) validate(params ValidateParams) ValidateResult {
+	workDir := params.WorkDir
+	if workDir == "" {
+		workDir = s.defaultWorkingDir()
+	}
+
+	report := s.validator.ValidateScript(params.Command, workDir)
+	violations := make([]Violation, 0, len(report.Violations))
+	for _, v := range report.Violations {
+		violations = append(violations, Violation{
+			Command: v.Command,
+			Args:    v.Args,
+			Line:    v.Line,
+			Column:  v.Column,
+			Rule:    string(v.Rule),
+			Message: v.Message,
+			RuleRef: v.Ref,
+		})
+	}
+	categories := make([]string, 0, len(report.Risk.Findings))
+	for _, c := range report.Risk.Categories() {
+		categories = append(categories, string(c))
+	}
+	return ValidateResult{Valid: report.Valid(), Violations: violations, RiskScore: report.Risk.Score, RiskCategories: categories}
+}
+
+// output reads part of a spooled output.
+func (s *Server) output(params OutputParams) (OutputResult, error) {
+	if s.spool == nil {
+		return OutputResult{}, errors.New("output spooling is disabled")
+	}
+	out, err := s.spool.Get(params.ID)
+	if err != nil {
+		return OutputResult{}, err
+	}
+
+	length := s.config.MaxOutputSize
+	if params.Length > 0 && (length == 0 || params.Length < length) {
+		length = params.Length
+	}
+	offset := params.Offset
+	if params.Tail {
+		offset = max(0, out.Size()-int64(length))
+	}
+	data, err := out.ReadRange(offset, length)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return OutputResult{}, err
+	}
+	end := offset + int64(len(data))
+	return OutputResult{Data: string(data), Offset: offset, Size: out.Size(), EOF: end >= out.Size()}, nil
+}
+
+// finishSnapshot commits or discards a snapshot, as method says.
+func (s *Server) finishSnapshot(method string, params SnapshotParams) (any, error) {
+	if s.snapshots == nil {
+		return nil, errors.New("snapshots are disabled")
+	}
+	snap, err := s.snapshots.Get(params.ID)
+	if err != nil {
+		return nil, err
+	}
+	if method == "discard" {
+		if err := snap.Discard(); err != nil {
+			return nil, err
+		}
+		return DiscardResult{Discarded: true}, nil
+	}
+	changes, err := snap.Commit()
+	if err != nil {
+		return nil, err
+	}
+	s.logger.LogInfof("Snapshot %s committed to %s", snap.ID, snap.Source)
+	return CommitResult{Changes: changes}, nil
+}
+
+// cancel aborts the in-flight exec with the given id.
+func (s *Server) cancel(id json.RawMessage) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cancel, ok := s.inflight[string(id)]
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// defaultWorkingDir returns the directory used when a request does not specify one.
+func (s *Server) defaultWorkingDir() string {
+	return s.config.DefaultDirectory()
+}
+
+// writeResult sends a successful response. Notifications are not answered.
+func (s *Server) writeResult(id json.RawMessage, result any) {
+	if id == nil {
+		return
+	}
+	s.write(response{JSONRPC: jsonrpcVersion, ID: id, Result: result})
+}
+
+// writeError sends an error response. Parse errors are answered with a null id.
+func (s *Server) writeError(id json.RawMessage, code int, message string) {
+	if id == nil && code != codeParseError && code != codeInvalidRequest {
+		return
+	}
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	s.write(response{JSONRPC: jsonrpcVersion, ID: id, Error: &rpcError{Code: code, Message: message}})
+}
+
+// write encodes one response per line.
+func (s *Server) write(resp response) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if err := s.encoder.Encode(resp); err != nil {
+		s.logger.LogErrorf("Failed to write RPC response: %v", err)
+	}
+}
@@ -0,0 +1,245 @@
+package rpcserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/runner"
+	"github.com/shimizu1995/secure-shell-server/pkg/session"
+	"github.com/shimizu1995/secure-shell-server/pkg/snapshot"
+	"github.com/shimizu1995/secure-shell-server/pkg/spool"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+// testResponse decodes a response with its result left raw.
+type testResponse struct {
+	ID     json.RawMessage `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories: []string{t.TempDir()},
+		AllowCommands:      []config.AllowCommand{{Command: "echo"}, {Command: "ls"}, {Command: "sleep"}},
+		DenyCommands:       []config.DenyCommand{{Command: "rm", Message: "rm is dangerous"}},
+		MaxExecutionTime:   10,
+	}
+	log := logger.New()
+	return New(cfg, validator.New(cfg, log), log)
+}
+
+// serve runs the given request lines to completion and returns the responses by id.
+func serve(t *testing.T, lines ...string) map[string]testResponse {
+	t.Helper()
+	var out bytes.Buffer
+	err := newTestServer(t).Serve(t.Context(), strings.NewReader(strings.Join(lines, "\n")), &out)
+	assert.NoError(t, err)
+	return decodeResponses(t, &out)
+}
+
+func decodeResponses(t *testing.T, r io.Reader) map[string]testResponse {
+	t.Helper()
+	responses := make(map[string]testResponse)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var resp testResponse
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &resp))
+		responses[string(resp.ID)] = resp
+	}
+	return responses
+}
+
+func TestServe_Exec(t *testing.T) {
+	responses := serve(t,
+		`{"jsonrpc":"2.0","id":1,"method":"exec","params":{"command":"echo hello"}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"exec","params":{"command":"rm -rf ."}}`,
+		`{"jsonrpc":"2.0","method":"exec","params":{"command":"echo notification"}}`,
+	)
+	assert.Equal(t, 2, len(responses))
+
+	var ok ExecResult
+	assert.NoError(t, json.Unmarshal(responses["1"].Result, &ok))
+	assert.Equal(t, ExecResult{Stdout: "hello\n"}, ok)
+
+	var denied ExecResult
+	assert.NoError(t, json.Unmarshal(responses["2"].Result, &denied))
+	assert.Equal(t, runner.ExitDenied, denied.ExitCode)
+	assert.Contains(t, denied.Error, "rm is dangerous")
+}
+
+func TestServe_Validate(t *testing.T) {
+	responses := serve(t,
+		`{"jsonrpc":"2.0","id":"a","method":"validate","params":{"command":"echo ok && rm -rf ."}}`,
+	)
+
+	var result ValidateResult
+	assert.NoError(t, json.Unmarshal(responses[`"a"`].Result, &result))
+	assert.False(t, result.Valid)
+	assert.Equal(t, 1, len(result.Violations))
+	assert.Equal(t, "rm", result.Violations[0].Command)
+	assert.Equal(t, "deny-command", result.Violations[0].Rule)
+	assert.Equal(t, 30, result.RiskScore)
+	assert.Equal(t, []string{"file-deletion"}, result.RiskCategories)
+}
+
+func TestServe_Errors(t *testing.T) {
+	responses := serve(t,
+		`not json`,
+		`{"jsonrpc":"2.0","id":1,"method":"unknown"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"exec"}`,
+		`{"jsonrpc":"2.0","id":3,"method":"exec","params":{"command":""}}`,
+	)
+
+	assert.Equal(t, codeParseError, responses["null"].Error.Code)
+	assert.Equal(t, codeMethodNotFound, responses["1"].Error.Code)
+	assert.Equal(t, codeInvalidParams, responses["2"].Error.Code)
+	assert.Equal(t, codeInvalidParams, responses["3"].Error.Code)
+}
+
+func TestServe_SessionCommandLimit(t *testing.T) {
+	srv := newTestServer(t)
+	srv.config.Sessions = config.SessionLimitsConfig{MaxCommands: 1}
+	srv.sessions = session.New(srv.config.Sessions)
+
+	var out bytes.Buffer
+	err := srv.Serve(t.Context(), strings.NewReader(strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"exec","params":{"command":"echo one"}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"exec","params":{"command":"echo two"}}`,
+	}, "\n")), &out)
+	assert.NoError(t, err)
+
+	// Execs run concurrently, so either may be the one refused
+	var refused int
+	for _, resp := range decodeResponses(t, &out) {
+		if resp.Error != nil {
+			refused++
+			assert.Contains(t, resp.Error.Message, "session command limit reached")
+		}
+	}
+	assert.Equal(t, 1, refused)
+}
+
+func TestServe_Cancel(t *testing.T) {
+	in, inWriter := io.Pipe()
+	outReader, out := io.Pipe()
+	srv := newTestServer(t)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Serve(t.Context(), in, out)
+		_ = out.Close()
+	}()
+	scanner := bufio.NewScanner(outReader)
+
+	_, err := io.WriteString(inWriter, `{"jsonrpc":"2.0","id":1,"method":"exec","params":{"command":"sleep 30"}}`+"\n")
+	assert.NoError(t, err)
+
+	// Cancelling an unknown id reports that nothing was cancelled
+	_, err = io.WriteString(inWriter, `{"jsonrpc":"2.0","id":2,"method":"cancel","params":{"id":99}}`+"\n")
+	assert.NoError(t, err)
+	assert.True(t, scanner.Scan())
+	assert.Equal(t, `{"jsonrpc":"2.0","id":2,"result":{"cancelled":false}}`, scanner.Text())
+
+	_, err = io.WriteString(inWriter, `{"jsonrpc":"2.0","id":3,"method":"cancel","params":{"id":1}}`+"\n")
+	assert.NoError(t, err)
+	assert.NoError(t, inWriter.Close())
+
+	responses := decodeResponses(t, outReader)
+	assert.NoError(t, <-done)
+	assert.Equal(t, `{"cancelled":true}`, string(responses["3"].Result))
+	assert.Equal(t, codeRequestCancelled, responses["1"].Error.Code)
+}
+
+func TestServe_Output(t *testing.T) {
+	in, inWriter := io.Pipe()
+	outReader, out := io.Pipe()
+	srv := newTestServer(t)
+	srv.config.MaxOutputSize = 8
+	srv.spool = spool.New(config.OutputSpoolConfig{Enabled: true, Dir: t.TempDir()})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Serve(t.Context(), in, out)
+		_ = out.Close()
+	}()
+	scanner := bufio.NewScanner(outReader)
+
+	_, err := io.WriteString(inWriter, `{"jsonrpc":"2.0","id":1,"method":"exec","params":{"command":"echo 0123456789abcdef"}}`+"\n")
+	assert.NoError(t, err)
+	assert.True(t, scanner.Scan())
+	var resp testResponse
+	assert.NoError(t, json.Unmarshal(scanner.Bytes(), &resp))
+	var exec ExecResult
+	assert.NoError(t, json.Unmarshal(resp.Result, &exec))
+	assert.NotEqual(t, "", exec.StdoutSpool)
+	assert.Equal(t, "", exec.StderrSpool)
+
+	// Each response is read before the next request is sent, as the server answers in order
+	request := func(line string) string {
+		_, err := io.WriteString(inWriter, line+"\n")
+		assert.NoError(t, err)
+		assert.True(t, scanner.Scan())
+		return scanner.Text()
+	}
+	assert.Equal(t, `{"jsonrpc":"2.0","id":2,"result":{"data":"4567","offset":4,"size":17,"eof":false}}`,
+		request(`{"jsonrpc":"2.0","id":2,"method":"output","params":{"id":"`+exec.StdoutSpool+`","offset":4,"length":4}}`))
+	assert.Equal(t, `{"jsonrpc":"2.0","id":3,"result":{"data":"9abcdef\n","offset":9,"size":17,"eof":true}}`,
+		request(`{"jsonrpc":"2.0","id":3,"method":"output","params":{"id":"`+exec.StdoutSpool+`","tail":true}}`))
+	assert.Contains(t, request(`{"jsonrpc":"2.0","id":4,"method":"output","params":{"id":"unknown"}}`), `"code":-32602`)
+
+	assert.NoError(t, inWriter.Close())
+	assert.False(t, scanner.Scan())
+	assert.NoError(t, <-done)
+}
+
+func TestServe_Snapshot(t *testing.T) {
+	in, inWriter := io.Pipe()
+	outReader, out := io.Pipe()
+	srv := newTestServer(t)
+	srv.snapshots = snapshot.New(config.SnapshotConfig{Enabled: true, Dir: t.TempDir()})
+	dir := srv.config.AllowedDirectories[0]
+
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Serve(t.Context(), in, out)
+		_ = out.Close()
+	}()
+	scanner := bufio.NewScanner(outReader)
+	request := func(line string) testResponse {
+		_, err := io.WriteString(inWriter, line+"\n")
+		assert.NoError(t, err)
+		assert.True(t, scanner.Scan())
+		var resp testResponse
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &resp))
+		return resp
+	}
+
+	var exec ExecResult
+	assert.NoError(t, json.Unmarshal(request(`{"jsonrpc":"2.0","id":1,"method":"exec","params":{"command":"echo hi > new.txt"}}`).Result, &exec))
+	assert.NotEqual(t, "", exec.Snapshot)
+	assert.Equal(t, []snapshot.Change{{Path: "new.txt", Kind: snapshot.Added}}, exec.Changes)
+	_, err := os.Stat(filepath.Join(dir, "new.txt"))
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Equal(t, `{"changes":[{"path":"new.txt","kind":"added"}]}`,
+		string(request(`{"jsonrpc":"2.0","id":2,"method":"commit","params":{"id":"`+exec.Snapshot+`"}}`).Result))
+	_, err = os.Stat(filepath.Join(dir, "new.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, codeInvalidParams, request(`{"jsonrpc":"2.0","id":3,"method":"discard","params":{"id":"`+exec.Snapshot+`"}}`).Error.Code)
+
+	assert.NoError(t, inWriter.Close())
+	assert.False(t, scanner.Scan())
+	assert.NoError(t, <-done)
+}
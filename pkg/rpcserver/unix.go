@@ -0,0 +1,161 @@
+package rpcserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
+	"github.com/shimizu1995/secure-shell-server/pkg/ratelimit"
+	"github.com/shimizu1995/secure-shell-server/pkg/session"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+// errPeerCredentialsUnsupported is returned on platforms without peer credentials.
+var errPeerCredentialsUnsupported = errors.New("peer credentials are not supported on this platform")
+
+// Peer identifies the process at the other end of a Unix domain socket connection.
+type Peer struct {
+	// PID is zero when the platform does not report it.
+	PID int
+	UID uint32
+	GID uint32
+}
+
+// CallerID returns the identifier under which the peer is rate limited and recorded, e.g. "uid:1000".
+func (p Peer) CallerID() string {
+	return "uid:" + strconv.FormatUint(uint64(p.UID), 10)
+}
+
+// Identity returns the identity of the peer's requests: its UID as the user and, when known,
+// its PID as the session.
+func (p Peer) Identity() identity.Identity {
+	id := identity.Identity{User: p.CallerID()}
+	if p.PID != 0 {
+		id.Session = "pid:" + strconv.Itoa(p.PID)
+	}
+	return id
+}
+
+// PolicyFunc selects the policy for a connecting peer. Returning an error rejects the connection.
+type PolicyFunc func(peer Peer) (*config.ShellCommandConfig, *validator.CommandValidator, error)
+
+// SetPolicyFunc chooses the policy of each Unix socket connection with fn, e.g. by UID.
+// Without it, every connection uses the policy passed to New. It must be called before ServeUnix.
+func (s *Server) SetPolicyFunc(fn PolicyFunc) {
+	s.policy = fn
+}
+
+// ServeUnix accepts connections on ln until ctx is done, serving each as its own session
+// identified by the peer's credentials. It waits for open connections to finish before returning.
+func (s *Server) ServeUnix(ctx context.Context, ln *net.UnixListener) error {
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	defer stop()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := ln.AcceptUnix()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer conn.Close()
+			s.serveConn(ctx, conn)
+		}()
+	}
+}
+
+// serveConn identifies the peer of conn and serves its requests under the peer's policy.
+func (s *Server) serveConn(ctx context.Context, conn *net.UnixConn) {
+	peer, err := peerCredentials(conn)
+	if err != nil {
+		s.logger.LogErrorf("Rejected socket connection: %v", err)
+		return
+	}
+
+	cfg, v := s.config, s.validator
+	if s.policy != nil {
+		cfg, v, err = s.policy(peer)
+		if err != nil {
+			s.logger.LogErrorf("Rejected socket connection from %s (pid %d): %v", peer.CallerID(), peer.PID, err)
+			return
+		}
+	}
+	s.logger.LogInfof("Socket connection from %s (pid %d)", peer.CallerID(), peer.PID)
+
+	// Unblock the read when the server shuts down
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if err := s.session(cfg, v, peer.Identity()).Serve(ctx, conn, conn); err != nil && ctx.Err() == nil {
+		s.logger.LogErrorf("Socket connection from %s failed: %v", peer.CallerID(), err)
+	}
+}
+
+// limiterFor returns the rate limiter of a policy, shared by every connection using it,
+// so that a peer cannot escape its limits by reconnecting.
+func (s *Server) limiterFor(cfg *config.ShellCommandConfig) *ratelimit.Limiter {
+	if cfg == s.config {
+		return s.limiter
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.limiters == nil {
+		s.limiters = make(map[*config.ShellCommandConfig]*ratelimit.Limiter)
+	}
+	limiter, ok := s.limiters[cfg]
+	if !ok {
+		limiter = ratelimit.NewForPolicy(cfg)
+		s.limiters[cfg] = limiter
+	}
+	return limiter
+}
+
+// sessionsFor returns the session limits of a policy, shared by every connection using it.
+func (s *Server) sessionsFor(cfg *config.ShellCommandConfig) *session.Manager {
+	if cfg == s.config {
+		return s.sessions
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sessionManagers == nil {
+		s.sessionManagers = make(map[*config.ShellCommandConfig]*session.Manager)
+	}
+	manager, ok := s.sessionManagers[cfg]
+	if !ok {
+		manager = session.New(cfg.Sessions)
+		s.sessionManagers[cfg] = manager
+	}
+	return manager
+}
+
+// session returns a server for a single connection that shares the settings and rate limits of s.
+// Spooled output and snapshots are kept per connection, so that a peer cannot read or commit another's.
+func (s *Server) session(cfg *config.ShellCommandConfig, v *validator.CommandValidator, id identity.Identity) *Server {
+	return &Server{
+		config:         cfg,
+		validator:      v,
+		logger:         s.logger,
+		limiter:        s.limiterFor(cfg),
+		sessions:       s.sessionsFor(cfg),
+		identity:       id,
+		tracerProvider: s.tracerProvider,
+		approvals:      s.approvals,
+		history:        s.history,
+		alerter:        s.alerter,
+		auditor:        s.auditor,
+		spool:          newSpool(cfg),
+		snapshots:      newSnapshots(cfg),
+		inflight:       make(map[string]context.CancelFunc),
+	}
+}
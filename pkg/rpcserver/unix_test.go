@@ -0,0 +1,105 @@
+//go:build linux || darwin
+
+package rpcserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+// serveUnix starts s on a socket in a temporary directory and returns the socket path.
+func serveUnix(t *testing.T, s *Server) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rpc.sock")
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.ServeUnix(ctx, ln) }()
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, <-done)
+	})
+	return path
+}
+
+// call sends a single request over a new connection and returns the response.
+func call(t *testing.T, path, line string) (testResponse, error) {
+	t.Helper()
+	conn, err := net.Dial("unix", path)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(line + "\n"))
+	assert.NoError(t, err)
+	scanner := bufio.NewScanner(conn)
+	if !scanner.Scan() {
+		return testResponse{}, errors.New("connection closed without a response")
+	}
+	var resp testResponse
+	assert.NoError(t, json.Unmarshal(scanner.Bytes(), &resp))
+	return resp, nil
+}
+
+func TestServeUnix_PolicyByUID(t *testing.T) {
+	s := newTestServer(t)
+	strict := &config.ShellCommandConfig{
+		AllowedDirectories:  s.config.AllowedDirectories,
+		AllowCommands:       []config.AllowCommand{{Command: "ls"}},
+		DefaultErrorMessage: "not for this user",
+	}
+	var peers []Peer
+	s.SetPolicyFunc(func(peer Peer) (*config.ShellCommandConfig, *validator.CommandValidator, error) {
+		peers = append(peers, peer)
+		return strict, validator.New(strict, s.logger), nil
+	})
+	path := serveUnix(t, s)
+
+	resp, err := call(t, path, `{"jsonrpc":"2.0","id":1,"method":"exec","params":{"command":"echo hello"}}`)
+	assert.NoError(t, err)
+	var result ExecResult
+	assert.NoError(t, json.Unmarshal(resp.Result, &result))
+	assert.Contains(t, result.Error, "not for this user")
+
+	assert.Equal(t, 1, len(peers))
+	assert.Equal(t, uint32(os.Getuid()), peers[0].UID)
+	assert.Equal(t, "uid:"+strconv.Itoa(os.Getuid()), peers[0].CallerID())
+	assert.Equal(t, peers[0].CallerID(), peers[0].Identity().User)
+	if peers[0].PID != 0 {
+		assert.Equal(t, "pid:"+strconv.Itoa(peers[0].PID), peers[0].Identity().Session)
+	}
+}
+
+func TestServeUnix_DefaultPolicy(t *testing.T) {
+	path := serveUnix(t, newTestServer(t))
+
+	resp, err := call(t, path, `{"jsonrpc":"2.0","id":1,"method":"exec","params":{"command":"echo hello"}}`)
+	assert.NoError(t, err)
+	var result ExecResult
+	assert.NoError(t, json.Unmarshal(resp.Result, &result))
+	assert.Equal(t, ExecResult{Stdout: "hello\n"}, result)
+}
+
+func TestServeUnix_Rejected(t *testing.T) {
+	s := newTestServer(t)
+	s.SetPolicyFunc(func(Peer) (*config.ShellCommandConfig, *validator.CommandValidator, error) {
+		return nil, nil, errors.New("unknown user")
+	})
+	path := serveUnix(t, s)
+
+	_, err := call(t, path, `{"jsonrpc":"2.0","id":1,"method":"exec","params":{"command":"echo hello"}}`)
+	assert.Error(t, err)
+}
@@ -0,0 +1,51 @@
+package runner
+
+import (
+	"context"
+	"strings"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/alert"
+)
+
+// SetAlerter sends alerts for honeypot deny rules to a instead of the runner's own alerter.
+// Servers share one alerter between sessions so that a frozen session stays frozen.
+func (r *SafeRunner) SetAlerter(a *alert.Alerter) {
+	r.alerter = a
+}
+
+// raiseAlert alerts if the denied command matches a deny rule marked alert.
+func (r *SafeRunner) raiseAlert(ctx context.Context, cmd string, args []string, workDir, message string) {
+	rule, ok := r.validator.MatchAlert(cmd, args)
+	if !ok || r.alerter == nil {
+		return
+	}
+	// Secrets in the arguments must not leave the server with the alert
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		redacted[i] = r.redactor.Redact(arg)
+	}
+	r.logger.LogErrorf("ALERT: honeypot rule for %q matched: %s %s", rule.Command, cmd, strings.Join(redacted, " "))
+	event := alert.Event{
+		Severity: alert.SeverityHigh,
+		Caller:   r.caller,
+		Command:  cmd,
+		Args:     redacted,
+		WorkDir:  workDir,
+		Message:  r.redactor.Redact(message),
+	}
+	if err := r.alerter.Alert(ctx, event); err != nil {
+		r.logger.LogErrorf("Failed to send alert: %v", err)
+	}
+}
+
+// frozen returns the denial message for a session frozen by an alert.
+func (r *SafeRunner) frozen() (string, bool) {
+	if r.alerter == nil || r.caller == "" {
+		return "", false
+	}
+	event, ok := r.alerter.Frozen(r.caller)
+	if !ok {
+		return "", false
+	}
+	return "session is frozen for review after a denied command: " + event.Command, true
+}
@@ -0,0 +1,52 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/alert"
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+func TestHoneypotAlert(t *testing.T) {
+	tmpDir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "notes.txt"), []byte("notes\n"), 0o600))
+	r, stdout := newOptionsTestRunner(t, tmpDir)
+	r.config.DenyCommands = append(r.config.DenyCommands,
+		config.DenyCommand{Command: "cat", Args: []string{"credentials"}, Message: "honeypot", Alert: true})
+
+	alerter := alert.New(config.AlertConfig{FreezeSession: true})
+	var events []alert.Event
+	alerter.AddNotifier(alert.NotifierFunc(func(_ context.Context, e alert.Event) error {
+		events = append(events, e)
+		return nil
+	}))
+	r.SetAlerter(alerter)
+	r.SetHistory(nil, "key")
+
+	// Other arguments do not trip the rule
+	result := r.RunCommand(t.Context(), "cat notes.txt", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "notes\n", stdout.String())
+	assert.Equal(t, 0, len(events))
+
+	result = r.RunCommand(t.Context(), "cat credentials.json", tmpDir)
+	assert.Equal(t, ExitDenied, ExitCode(result.Err))
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, alert.SeverityHigh, events[0].Severity)
+	assert.Equal(t, "key", events[0].Caller)
+	assert.Equal(t, []string{"credentials.json"}, events[0].Args)
+	assert.True(t, events[0].Frozen)
+
+	// The frozen session cannot run anything until it is thawed
+	result = r.RunCommand(t.Context(), "echo hello", tmpDir)
+	assert.Equal(t, ExitDenied, ExitCode(result.Err))
+	assert.Contains(t, result.Err.Error(), "frozen")
+
+	assert.True(t, alerter.Thaw("key"))
+	assert.NoError(t, r.RunCommand(t.Context(), "echo hello", tmpDir).Err)
+}
@@ -0,0 +1,52 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/trace"
+	"mvdan.cc/sh/v3/syntax"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/approval"
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+// SetApprovals holds commands marked approvalRequired in q until an approver decides.
+// Without a queue, such commands are denied.
+func (r *SafeRunner) SetApprovals(q *approval.Queue) {
+	r.approvals = q
+}
+
+// awaitApproval blocks until the command is approved, returning the denial message otherwise.
+func (r *SafeRunner) awaitApproval(ctx context.Context, req approval.Request) (string, bool) {
+	if r.approvals == nil {
+		return fmt.Sprintf("command %q requires approval, but no approver is configured", req.Command), false
+	}
+
+	req.Caller = r.identity.String()
+	r.logger.LogInfof("Command %s is waiting for approval", req.Command)
+	if err := r.approvals.Wait(ctx, req); err != nil {
+		return fmt.Sprintf("command %q was not approved: %v", req.Command, err), false
+	}
+	r.logger.LogInfof("Command %s was approved", req.Command)
+	return "", true
+}
+
+// checkRisk scores a validated script and, when the score exceeds the configured
+// threshold, rejects it or holds it for approval according to the risk action.
+func (r *SafeRunner) checkRisk(ctx context.Context, command string, prog *syntax.File, workDir string) error {
+	assessment, d := r.validator.CheckRisk(prog)
+	trace.SpanFromContext(ctx).SetAttributes(attrRiskScore.Int(assessment.Score))
+	if d.Allowed {
+		return nil
+	}
+
+	if r.config.Risk.Action == config.RiskActionDeny {
+		return r.deny(ctx, command, nil, workDir, d.Message)
+	}
+	if errMsg, approved := r.awaitApproval(ctx, approval.Request{Command: command, WorkDir: workDir, Reason: d.Message}); !approved {
+		r.denied(ctx, command, nil, workDir, errMsg)
+		return deniedError(errMsg)
+	}
+	return nil
+}
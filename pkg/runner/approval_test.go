@@ -0,0 +1,147 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/approval"
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+// newApprovalTestRunner returns a runner on which ls requires approval.
+func newApprovalTestRunner(t *testing.T, tmpDir string) *SafeRunner {
+	t.Helper()
+	r := newHintTestRunner(t, tmpDir)
+	for i := range r.config.AllowCommands {
+		if r.config.AllowCommands[i].Command == "ls" {
+			r.config.AllowCommands[i].ApprovalRequired = true
+		}
+	}
+	return r
+}
+
+// decideNext waits for a pending request and applies decide to it.
+func decideNext(t *testing.T, q *approval.Queue, decide func(approval.Request)) {
+	t.Helper()
+	go func() {
+		for range 500 {
+			if pending := q.Pending(); len(pending) > 0 {
+				decide(pending[0])
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+}
+
+func TestSafeRunner_ApprovalApproved(t *testing.T) {
+	tmpDir := t.TempDir()
+	r := newApprovalTestRunner(t, tmpDir)
+	q := approval.New(time.Minute)
+	r.SetApprovals(q)
+
+	var seen approval.Request
+	decideNext(t, q, func(req approval.Request) {
+		seen = req
+		_ = q.Approve(req.ID)
+	})
+
+	result := r.RunCommand(t.Context(), "ls -a", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "ls", seen.Command)
+	assert.Equal(t, []string{"-a"}, seen.Args)
+	assert.Equal(t, tmpDir, seen.WorkDir)
+}
+
+func TestSafeRunner_ApprovalRejected(t *testing.T) {
+	tmpDir := t.TempDir()
+	r := newApprovalTestRunner(t, tmpDir)
+	q := approval.New(time.Minute)
+	r.SetApprovals(q)
+
+	var denied string
+	r.OnDeny(func(_ context.Context, _ *ExecContext, message string) { denied = message })
+	decideNext(t, q, func(req approval.Request) { _ = q.Reject(req.ID, "not now") })
+
+	result := r.RunCommand(t.Context(), "ls", tmpDir)
+	assert.Error(t, result.Err)
+	assert.Contains(t, result.Err.Error(), `command "ls" was not approved: command was rejected: not now`)
+	assert.Equal(t, result.Err.Error(), denied)
+	// Commands without approvalRequired are not held
+	assert.NoError(t, r.RunCommand(t.Context(), "echo hello", tmpDir).Err)
+}
+
+func TestSafeRunner_ApprovalTimeout(t *testing.T) {
+	tmpDir := t.TempDir()
+	r := newApprovalTestRunner(t, tmpDir)
+	r.SetApprovals(approval.New(20 * time.Millisecond))
+
+	result := r.RunCommand(t.Context(), "ls", tmpDir)
+	assert.Error(t, result.Err)
+	assert.Contains(t, result.Err.Error(), "timed out waiting for approval")
+}
+
+func TestSafeRunner_ApprovalWithoutQueue(t *testing.T) {
+	tmpDir := t.TempDir()
+	r := newApprovalTestRunner(t, tmpDir)
+
+	result := r.RunCommand(t.Context(), "ls", tmpDir)
+	assert.Error(t, result.Err)
+	assert.Contains(t, result.Err.Error(), "no approver is configured")
+}
+
+// newRiskTestRunner returns a runner that allows rm and holds back scripts scoring above 20.
+func newRiskTestRunner(t *testing.T, tmpDir, action string) *SafeRunner {
+	t.Helper()
+	r := newHintTestRunner(t, tmpDir)
+	r.config.AllowCommands = append(r.config.AllowCommands, config.AllowCommand{Command: "rm"})
+	r.config.Risk = config.RiskConfig{Threshold: 20, Action: action}
+	return r
+}
+
+func TestSafeRunner_RiskDeny(t *testing.T) {
+	tmpDir := t.TempDir()
+	file := filepath.Join(tmpDir, "file.txt")
+	assert.NoError(t, os.WriteFile(file, nil, 0o600))
+	r := newRiskTestRunner(t, tmpDir, config.RiskActionDeny)
+
+	var denied string
+	r.OnDeny(func(_ context.Context, _ *ExecContext, message string) { denied = message })
+
+	result := r.RunCommand(t.Context(), "echo start; rm file.txt", tmpDir)
+	assert.Error(t, result.Err)
+	assert.Equal(t, "script risk score 30 exceeds the threshold of 20 (file-deletion)", result.Err.Error())
+	assert.Equal(t, result.Err.Error(), denied)
+	// Nothing in the script runs
+	_, err := os.Stat(file)
+	assert.NoError(t, err)
+
+	assert.NoError(t, r.RunCommand(t.Context(), "echo hello", tmpDir).Err)
+}
+
+func TestSafeRunner_RiskApproval(t *testing.T) {
+	tmpDir := t.TempDir()
+	file := filepath.Join(tmpDir, "file.txt")
+	assert.NoError(t, os.WriteFile(file, nil, 0o600))
+	r := newRiskTestRunner(t, tmpDir, config.RiskActionApprove)
+	q := approval.New(time.Minute)
+	r.SetApprovals(q)
+
+	var seen approval.Request
+	decideNext(t, q, func(req approval.Request) {
+		seen = req
+		_ = q.Approve(req.ID)
+	})
+
+	result := r.RunCommand(t.Context(), "rm file.txt", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "rm file.txt", seen.Command)
+	assert.Contains(t, seen.Reason, "risk score 30")
+	_, err := os.Stat(file)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,49 @@
+package runner
+
+import (
+	"github.com/shimizu1995/secure-shell-server/pkg/audit"
+	"github.com/shimizu1995/secure-shell-server/pkg/history"
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
+)
+
+// SetAuditor sends audit events to a instead of the runner's own auditor. Servers share one
+// auditor between sessions so that every session writes through the same connections.
+func (r *SafeRunner) SetAuditor(a *audit.Auditor) {
+	r.auditor = a
+}
+
+// sendAudit sends a denied or executed command, as recorded in the history, to the auditor.
+// Failures are logged rather than returned so that an unreachable daemon does not stop execution.
+func (r *SafeRunner) sendAudit(e history.Entry, args []string) {
+	if r.auditor == nil {
+		return
+	}
+	// Secrets in the arguments must not leave the server with the event
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		redacted[i] = r.redactor.Redact(arg)
+	}
+	caller := r.identity
+	if caller.IsZero() && r.caller != "" {
+		caller = identity.Identity{Session: r.caller}
+	}
+	event := audit.Event{
+		Outcome:  audit.OutcomeExecuted,
+		Caller:   caller,
+		Command:  e.Command,
+		Args:     redacted,
+		WorkDir:  e.WorkDir,
+		Message:  e.Message,
+		ExitCode: e.ExitCode,
+		Duration: e.Duration,
+	}
+	switch e.Decision {
+	case history.DecisionDenied:
+		event.Outcome = audit.OutcomeDenied
+	case history.DecisionWouldDeny:
+		event.Outcome = audit.OutcomeWouldDeny
+	}
+	if err := r.auditor.Send(event); err != nil {
+		r.logger.LogErrorf("Failed to send audit event: %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+var (
+	// ErrBatchRefused is the error of the items of a batch run WithAllOrNothing that did not
+	// run because another item was denied.
+	ErrBatchRefused = errors.New("batch refused because another item was denied")
+	// ErrBatchSkipped is the error of the items of a batch run WithStopOnError that did not
+	// run because an earlier item failed.
+	ErrBatchSkipped = errors.New("skipped because an earlier item failed")
+)
+
+// BatchCommand is an item of a batch: a script and the options it runs with.
+type BatchCommand struct {
+	Script  string
+	Options []ExecOption
+}
+
+// BatchResult is the outcome of an item of a batch.
+type BatchResult struct {
+	RunResult
+	// Stdout and Stderr hold the output of the item, limited like that of any execution.
+	Stdout string
+	Stderr string
+	// Violations are what validating the batch found in the item, which did not run then.
+	Violations []validator.Violation
+}
+
+// batchOptions holds the settings requested by BatchOption values.
+type batchOptions struct {
+	parallelism  int
+	allOrNothing bool
+	stopOnError  bool
+}
+
+// BatchOption changes how RunBatch runs a batch.
+type BatchOption func(*batchOptions)
+
+// WithParallelism runs up to n items at once. By default items run one after another, in order.
+func WithParallelism(n int) BatchOption {
+	return func(o *batchOptions) { o.parallelism = n }
+}
+
+// WithAllOrNothing runs no item of the batch if validating it denies any item.
+func WithAllOrNothing() BatchOption {
+	return func(o *batchOptions) { o.allOrNothing = true }
+}
+
+// WithStopOnError starts no more items once an item fails. Items already running finish.
+func WithStopOnError() BatchOption {
+	return func(o *batchOptions) { o.stopOnError = true }
+}
+
+// batchItem is an item of a batch being run.
+type batchItem struct {
+	r        *SafeRunner
+	settings execSettings
+}
+
+// RunBatch validates every item of a batch and then runs the items that passed, each with its
+// own runner like Execute, returning their results in the order of cmds. An item whose options
+// are not permitted or whose script the policy denies does not run; WithAllOrNothing refuses
+// the others as well. Items take slots like other executions, so a full queue fails them with
+// ErrTooManyExecutions. Validation is static: commands that only a running script reveals are
+// validated when they run, as in any execution.
+func (m *Manager) RunBatch(ctx context.Context, cmds []BatchCommand, opts ...BatchOption) []BatchResult {
+	var o batchOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	results := make([]BatchResult, len(cmds))
+	items := make([]*batchItem, len(cmds))
+	refused := false
+	for i, cmd := range cmds {
+		item, result := m.prepareBatchItem(ctx, cmd)
+		if item == nil {
+			results[i] = result
+			refused = true
+			continue
+		}
+		items[i] = item
+	}
+	if refused && o.allOrNothing {
+		for i, item := range items {
+			if item != nil {
+				results[i].Err = &ExitError{Code: ExitDenied, Err: ErrBatchRefused}
+			}
+		}
+		return results
+	}
+
+	parallelism := max(o.parallelism, 1)
+	slots := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := false
+	for i, item := range items {
+		if item == nil {
+			continue
+		}
+		slots <- struct{}{}
+		mu.Lock()
+		stop := failed && o.stopOnError
+		mu.Unlock()
+		if err := ctx.Err(); err != nil || stop {
+			<-slots
+			if err == nil {
+				err = ErrBatchSkipped
+			}
+			results[i].Err = asExitError(err)
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			var stdout, stderr bytes.Buffer
+			result := m.execute(ctx, item.r, cmds[i].Script, item.settings, &stdout, &stderr)
+			results[i] = BatchResult{RunResult: result, Stdout: stdout.String(), Stderr: stderr.String()}
+			if result.Err != nil {
+				mu.Lock()
+				failed = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// prepareBatchItem creates the runner of an item and validates the item. An item that may
+// not run is returned as nil, with its result.
+func (m *Manager) prepareBatchItem(ctx context.Context, cmd BatchCommand) (*batchItem, BatchResult) {
+	r := New(m.config, m.validator, m.logger)
+	settings, err := r.resolveOptions(ctx, cmd.Options)
+	if err != nil {
+		return nil, BatchResult{RunResult: RunResult{Err: invalidError(err)}}
+	}
+	if m.opts.Setup != nil {
+		m.opts.Setup(r)
+	}
+	// In permissive mode, denials are recorded as the items run
+	if r.permissive() {
+		return &batchItem{r: r, settings: settings}, BatchResult{}
+	}
+
+	var violations []validator.Violation
+	workDir, err := filepath.Abs(settings.workDir)
+	if err != nil {
+		return nil, BatchResult{RunResult: RunResult{Err: invalidError(err)}}
+	}
+	if allowed, message := r.validator.IsDirectoryAllowed(workDir); !allowed {
+		violations = append(violations, validator.Violation{Rule: validator.RuleDirectory, Message: "directory validation failed: " + message})
+	} else {
+		violations = r.validator.ValidateScriptAs(cmd.Script, workDir, settings.lang).Violations
+	}
+	if len(violations) == 0 {
+		return &batchItem{r: r, settings: settings}, BatchResult{}
+	}
+
+	v := violations[0]
+	if v.Rule == validator.RuleParse {
+		return nil, BatchResult{RunResult: RunResult{Err: invalidError(errors.New(v.Message))}, Violations: violations}
+	}
+	if v.Rule != validator.RuleDirectory {
+		r.validator.WithWorkDir(workDir).LogViolation(v)
+	}
+	r.denied(ctx, v.Command, v.Args, workDir, v.Message)
+	return nil, BatchResult{RunResult: RunResult{Err: deniedError(v.Message)}, Violations: violations}
+}
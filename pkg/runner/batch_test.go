@@ -0,0 +1,91 @@
+package runner
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+func TestManager_RunBatch(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, _ := newOptionsTestRunner(t, tmpDir)
+	m := NewManager(r.config, r.validator, r.logger, ManagerOptions{})
+
+	results := m.RunBatch(t.Context(), []BatchCommand{
+		{Script: "echo one > out.txt", Options: []ExecOption{WithWorkdir(tmpDir)}},
+		{Script: "rm out.txt", Options: []ExecOption{WithWorkdir(tmpDir)}},
+		{Script: "cat out.txt; echo two", Options: []ExecOption{WithWorkdir(tmpDir)}},
+		{Script: "echo 'unterminated", Options: []ExecOption{WithWorkdir(tmpDir)}},
+		{Script: "echo x", Options: []ExecOption{WithWorkdir(tmpDir), WithTimeout(-time.Second)}},
+	})
+	assert.Equal(t, 5, len(results))
+	assert.NoError(t, results[0].Err)
+
+	assert.Equal(t, ExitDenied, ExitCode(results[1].Err))
+	assert.Equal(t, 1, len(results[1].Violations))
+	assert.Equal(t, validator.RuleNotAllowed, results[1].Violations[0].Rule)
+
+	// Items run in order, after the earlier ones finished
+	assert.NoError(t, results[2].Err)
+	assert.Equal(t, "one\ntwo\n", results[2].Stdout)
+
+	assert.Equal(t, ExitInvalid, ExitCode(results[3].Err))
+	assert.Equal(t, ExitInvalid, ExitCode(results[4].Err))
+	assert.True(t, errors.Is(results[4].Err, ErrOptionNotPermitted))
+}
+
+func TestManager_RunBatchAllOrNothing(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, _ := newOptionsTestRunner(t, tmpDir)
+	m := NewManager(r.config, r.validator, r.logger, ManagerOptions{})
+
+	results := m.RunBatch(t.Context(), []BatchCommand{
+		{Script: "echo one > out.txt", Options: []ExecOption{WithWorkdir(tmpDir)}},
+		{Script: "echo ok; sudo ls", Options: []ExecOption{WithWorkdir(tmpDir)}},
+	}, WithAllOrNothing())
+	assert.True(t, errors.Is(results[0].Err, ErrBatchRefused))
+	assert.Equal(t, ExitDenied, ExitCode(results[0].Err))
+	assert.Equal(t, ExitDenied, ExitCode(results[1].Err))
+	assert.Equal(t, "sudo", results[1].Violations[0].Command)
+	_, err := os.Stat(filepath.Join(tmpDir, "out.txt"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestManager_RunBatchStopOnError(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, _ := newOptionsTestRunner(t, tmpDir)
+	m := NewManager(r.config, r.validator, r.logger, ManagerOptions{})
+
+	results := m.RunBatch(t.Context(), []BatchCommand{
+		{Script: "cat missing.txt", Options: []ExecOption{WithWorkdir(tmpDir)}},
+		{Script: "echo two", Options: []ExecOption{WithWorkdir(tmpDir)}},
+	}, WithStopOnError())
+	assert.Equal(t, 1, ExitCode(results[0].Err))
+	assert.Contains(t, results[0].Stderr, "missing.txt")
+	assert.True(t, errors.Is(results[1].Err, ErrBatchSkipped))
+	assert.Equal(t, "", results[1].Stdout)
+}
+
+func TestManager_RunBatchParallel(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, _ := newOptionsTestRunner(t, tmpDir)
+	m := NewManager(r.config, r.validator, r.logger, ManagerOptions{MaxConcurrent: 3})
+
+	cmds := make([]BatchCommand, 3)
+	for i := range cmds {
+		cmds[i] = BatchCommand{Script: "sleep 1; echo done", Options: []ExecOption{WithWorkdir(tmpDir)}}
+	}
+	start := time.Now()
+	results := m.RunBatch(t.Context(), cmds, WithParallelism(3))
+	assert.True(t, time.Since(start) < 2500*time.Millisecond, "items did not run in parallel")
+	for _, result := range results {
+		assert.NoError(t, result.Err)
+		assert.Equal(t, "done\n", result.Stdout)
+	}
+}
@@ -0,0 +1,74 @@
+package runner
+
+import (
+	"os"
+	"runtime"
+	"strings"
+
+	"mvdan.cc/sh/v3/expand"
+)
+
+// pathVar is the variable executables are looked up in.
+const pathVar = "PATH"
+
+// isPathVar reports whether name is PATH; environment names are case-insensitive on Windows.
+func isPathVar(name string) bool {
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(name, pathVar)
+	}
+	return name == pathVar
+}
+
+// baseEnviron returns the process environment with PATH replaced by path when it is set,
+// so that the caller's PATH is never inherited.
+func baseEnviron(path string) []string {
+	if path == "" {
+		return os.Environ()
+	}
+	env := make([]string, 0, len(os.Environ())+1)
+	for _, kv := range os.Environ() {
+		if name, _, _ := strings.Cut(kv, "="); !isPathVar(name) {
+			env = append(env, kv)
+		}
+	}
+	return append(env, pathVar+"="+path)
+}
+
+// pinnedPathEnviron is an environment whose PATH is fixed, whatever the script assigns to it.
+type pinnedPathEnviron struct {
+	expand.Environ
+	path string
+}
+
+// Get returns the pinned PATH or the variable of the wrapped environment.
+func (e pinnedPathEnviron) Get(name string) expand.Variable {
+	if isPathVar(name) {
+		return expand.Variable{Set: true, Exported: true, Kind: expand.String, Str: e.path}
+	}
+	return e.Environ.Get(name)
+}
+
+// Each iterates over the wrapped environment with PATH replaced by the pinned one.
+func (e pinnedPathEnviron) Each(fn func(name string, vr expand.Variable) bool) {
+	stopped := false
+	e.Environ.Each(func(name string, vr expand.Variable) bool {
+		if isPathVar(name) {
+			return true
+		}
+		stopped = !fn(name, vr)
+		return !stopped
+	})
+	if !stopped {
+		fn(pathVar, e.Get(pathVar))
+	}
+}
+
+// commandEnviron returns the environment executables are looked up and run with. When
+// allowedBinDirs is set, PATH is pinned to them, so a script cannot point it elsewhere.
+func (r *SafeRunner) commandEnviron(env expand.Environ) expand.Environ {
+	path := r.config.BinPath()
+	if path == "" {
+		return env
+	}
+	return pinnedPathEnviron{Environ: env, path: path}
+}
@@ -0,0 +1,47 @@
+//go:build !windows
+
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+func TestAllowedBinDirs(t *testing.T) {
+	tmpDir := t.TempDir()
+	binDir := t.TempDir()
+	// The script prints the PATH it was started with; its interpreter is found without PATH
+	tool := []byte("#!/bin/sh\necho \"$PATH\"\n")
+	assert.NoError(t, os.WriteFile(filepath.Join(binDir, "tool"), tool, 0o700)) //nolint:gosec // the script must be executable
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "tool"), tool, 0o700)) //nolint:gosec // the script must be executable
+
+	r, stdout := newOptionsTestRunner(t, tmpDir)
+	r.config.AllowCommands = append(r.config.AllowCommands, config.AllowCommand{Command: "tool"})
+	r.config.AllowedBinDirs = []string{binDir}
+
+	// The caller's PATH is not inherited
+	t.Setenv("PATH", tmpDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	result := r.RunCommand(t.Context(), "tool", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, binDir+"\n", stdout.String())
+
+	// Assigning PATH in the script changes neither lookup nor the child's PATH
+	stdout.Reset()
+	result = r.RunCommand(t.Context(), "PATH="+tmpDir+" tool; PATH="+tmpDir+"; tool", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, binDir+"\n"+binDir+"\n", stdout.String())
+
+	// Commands may not be run by a relative path or from elsewhere
+	stdout.Reset()
+	result = r.RunCommand(t.Context(), "./tool", tmpDir)
+	assert.Equal(t, ExitDenied, ExitCode(result.Err))
+	result = r.RunCommand(t.Context(), filepath.Join(tmpDir, "tool"), tmpDir)
+	assert.Equal(t, ExitDenied, ExitCode(result.Err))
+	result = r.RunCommand(t.Context(), filepath.Join(binDir, "tool"), tmpDir)
+	assert.NoError(t, result.Err)
+}
@@ -0,0 +1,43 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrMemoryLimitExceeded is returned when a process of an execution was killed for using more memory than cgroup.memoryMax.
+var ErrMemoryLimitExceeded = errors.New("execution exceeded its memory limit")
+
+// errCgroupUnsupported is returned when executions cannot be given a cgroup on this system.
+var errCgroupUnsupported = errors.New("cgroup v2 is not available on this system")
+
+// setupCgroup creates the cgroup of an execution, in which every external command of the
+// execution starts. The returned function kills the processes left in it and removes it.
+func (r *SafeRunner) setupCgroup() (func(), error) {
+	cfg := r.config.Cgroup
+	cg, err := newCgroup(cfg)
+	switch {
+	case errors.Is(err, errCgroupUnsupported) && cfg.BestEffort:
+		r.logger.LogErrorf("Running without a cgroup: %v", err)
+		return func() {}, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to create cgroup: %w", err)
+	}
+
+	r.cgroup = cg
+	return func() {
+		r.cgroup = nil
+		if err := cg.remove(killTimeout); err != nil {
+			r.logger.LogErrorf("Failed to remove cgroup: %v", err)
+		}
+	}, nil
+}
+
+// cgroupError returns the error of an execution that failed with err, which is
+// ErrMemoryLimitExceeded if the kernel killed one of its processes for exceeding memory.max.
+func (r *SafeRunner) cgroupError(err error) error {
+	if err == nil || !r.cgroup.oomKilled() {
+		return err
+	}
+	return fmt.Errorf("%w (%d MB)", ErrMemoryLimitExceeded, r.config.Cgroup.MemoryMax)
+}
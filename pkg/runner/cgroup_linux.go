@@ -0,0 +1,300 @@
+//go:build linux
+
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+// cgroupRoot is where the cgroup v2 hierarchy is mounted.
+var cgroupRoot = "/sys/fs/cgroup"
+
+const (
+	// cgroupPattern is the name pattern of the cgroups of executions.
+	cgroupPattern = "secure-shell-exec-"
+	// cgroupLeaf is the cgroup the server moves its processes to when its own cgroup is the
+	// parent: a cgroup with processes cannot enable controllers for its children.
+	cgroupLeaf = "secure-shell-server"
+	// cpuMaxPeriod is the period of cpu.max in microseconds.
+	cpuMaxPeriod = 100000
+	// cgroupPollInterval is how often a cgroup being removed is checked for remaining processes.
+	cgroupPollInterval = 10 * time.Millisecond
+)
+
+// serverCgroup is the cgroup the server started in, found the first time it is the parent.
+var serverCgroup struct {
+	sync.Mutex
+	path string
+}
+
+// cgroup is the cgroup v2 of an execution.
+type cgroup struct {
+	dir string
+	// fd is the open directory of the cgroup, in which processes are started with CLONE_INTO_CGROUP
+	fd int
+}
+
+// newCgroup creates a cgroup for an execution below cfg.Parent with the limits of cfg.
+func newCgroup(cfg config.CgroupConfig) (*cgroup, error) {
+	var fs unix.Statfs_t
+	if err := unix.Statfs(cgroupRoot, &fs); err != nil || fs.Type != unix.CGROUP2_SUPER_MAGIC {
+		return nil, fmt.Errorf("%w: %s is not a cgroup2 mount", errCgroupUnsupported, cgroupRoot)
+	}
+	parent, err := prepareCgroupParent(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp(parent, cgroupPattern)
+	if err != nil {
+		return nil, err
+	}
+	cg := &cgroup{dir: dir, fd: -1}
+	if err := cg.setLimits(cfg); err != nil {
+		_ = cg.remove(0)
+		return nil, err
+	}
+	if cg.fd, err = unix.Open(dir, unix.O_DIRECTORY|unix.O_RDONLY|unix.O_CLOEXEC, 0); err != nil {
+		_ = cg.remove(0)
+		return nil, fmt.Errorf("failed to open cgroup %s: %w", dir, err)
+	}
+	return cg, nil
+}
+
+// prepareCgroupParent returns the directory of the parent cgroup of executions, with the
+// controllers the limits of cfg need enabled for its children.
+func prepareCgroupParent(cfg config.CgroupConfig) (string, error) {
+	serverCgroup.Lock()
+	defer serverCgroup.Unlock()
+
+	if cfg.Parent != "" {
+		parent := filepath.Join(cgroupRoot, cfg.Parent)
+		return parent, enableControllers(parent, cgroupControllers(cfg))
+	}
+
+	if serverCgroup.path == "" {
+		data, err := os.ReadFile("/proc/self/cgroup")
+		if err != nil {
+			return "", err
+		}
+		path, ok := parseProcCgroup(data)
+		if !ok {
+			return "", fmt.Errorf("%w: the server is not in a cgroup v2", errCgroupUnsupported)
+		}
+		serverCgroup.path = path
+	}
+	parent := filepath.Join(cgroupRoot, serverCgroup.path)
+	err := enableControllers(parent, cgroupControllers(cfg))
+	if errors.Is(err, unix.EBUSY) {
+		// The server's processes move to a leaf so that its cgroup may have controllers for its children
+		if err := moveProcesses(parent, filepath.Join(parent, cgroupLeaf)); err != nil {
+			return "", fmt.Errorf("failed to move the server out of its cgroup: %w", err)
+		}
+		err = enableControllers(parent, cgroupControllers(cfg))
+	}
+	return parent, err
+}
+
+// parseProcCgroup returns the cgroup v2 path of the contents of /proc/<pid>/cgroup.
+func parseProcCgroup(data []byte) (string, bool) {
+	for line := range strings.Lines(string(data)) {
+		if path, ok := strings.CutPrefix(strings.TrimSuffix(line, "\n"), "0::"); ok {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// cgroupControllers returns the controllers enforcing the limits of cfg.
+func cgroupControllers(cfg config.CgroupConfig) []string {
+	var controllers []string
+	if cfg.MemoryMax > 0 {
+		controllers = append(controllers, "memory")
+	}
+	if cfg.CPUMax > 0 {
+		controllers = append(controllers, "cpu")
+	}
+	if cfg.PidsMax > 0 {
+		controllers = append(controllers, "pids")
+	}
+	return controllers
+}
+
+// enableControllers enables controllers for the children of the cgroup dir, unless they already are.
+func enableControllers(dir string, controllers []string) error {
+	data, err := os.ReadFile(filepath.Join(dir, "cgroup.subtree_control"))
+	if err != nil {
+		return err
+	}
+	enabled := strings.Fields(string(data))
+	var missing []string
+	for _, controller := range controllers {
+		if !slices.Contains(enabled, controller) {
+			missing = append(missing, "+"+controller)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return writeCgroupFile(dir, "cgroup.subtree_control", strings.Join(missing, " "))
+}
+
+// moveProcesses moves the processes of the cgroup from to the cgroup to, which is created if needed.
+func moveProcesses(from, to string) error {
+	if err := os.Mkdir(to, 0o755); err != nil && !errors.Is(err, os.ErrExist) {
+		return err
+	}
+	pids, err := cgroupProcs(from)
+	if err != nil {
+		return err
+	}
+	for _, pid := range pids {
+		// Processes may exit while they are moved
+		if err := writeCgroupFile(to, "cgroup.procs", strconv.Itoa(pid)); err != nil && !errors.Is(err, unix.ESRCH) {
+			return err
+		}
+	}
+	return nil
+}
+
+// setLimits writes the limits of cfg to the cgroup.
+func (c *cgroup) setLimits(cfg config.CgroupConfig) error {
+	if cfg.MemoryMax > 0 {
+		if err := writeCgroupFile(c.dir, "memory.max", strconv.FormatInt(int64(cfg.MemoryMax)*bytesPerMegabyte, 10)); err != nil {
+			return err
+		}
+		// Swap would let an execution go beyond its memory limit; systems without swap have no such file
+		if err := writeCgroupFile(c.dir, "memory.swap.max", "0"); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if cfg.CPUMax > 0 {
+		if err := writeCgroupFile(c.dir, "cpu.max", cpuMax(cfg.CPUMax)); err != nil {
+			return err
+		}
+	}
+	if cfg.PidsMax > 0 {
+		if err := writeCgroupFile(c.dir, "pids.max", strconv.Itoa(cfg.PidsMax)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cpuMax returns the cpu.max value allowing cpus CPUs.
+func cpuMax(cpus float64) string {
+	quota := max(int64(cpus*cpuMaxPeriod), 1000)
+	return fmt.Sprintf("%d %d", quota, cpuMaxPeriod)
+}
+
+// attach makes cmd start in the cgroup.
+func (c *cgroup) attach(cmd *exec.Cmd) {
+	if c == nil {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		// startProcess only puts the command in a group of its own when it sets no attributes
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = c.fd
+}
+
+// kill kills every process in the cgroup, including those that left the process group of
+// the command that started them.
+func (c *cgroup) kill() {
+	if c == nil {
+		return
+	}
+	if err := writeCgroupFile(c.dir, "cgroup.kill", "1"); err == nil {
+		return
+	}
+	// Kernels before 5.14 have no cgroup.kill
+	pids, _ := cgroupProcs(c.dir)
+	for _, pid := range pids {
+		_ = unix.Kill(pid, unix.SIGKILL)
+	}
+}
+
+// oomKilled reports whether the kernel killed a process of the cgroup for exceeding memory.max.
+func (c *cgroup) oomKilled() bool {
+	if c == nil {
+		return false
+	}
+	data, err := os.ReadFile(filepath.Join(c.dir, "memory.events"))
+	if err != nil {
+		return false
+	}
+	return cgroupEventCount(data, "oom_kill") > 0
+}
+
+// cgroupEventCount returns the count of event in the contents of a cgroup events file.
+func cgroupEventCount(data []byte, event string) int {
+	for line := range strings.Lines(string(data)) {
+		if value, ok := strings.CutPrefix(line, event+" "); ok {
+			n, _ := strconv.Atoi(strings.TrimSpace(value))
+			return n
+		}
+	}
+	return 0
+}
+
+// remove kills the processes left in the cgroup and removes it, waiting up to timeout for
+// them to exit.
+func (c *cgroup) remove(timeout time.Duration) error {
+	if c.fd >= 0 {
+		_ = unix.Close(c.fd)
+		c.fd = -1
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		err := os.Remove(c.dir)
+		if !errors.Is(err, unix.EBUSY) || time.Now().After(deadline) {
+			return err
+		}
+		c.kill()
+		time.Sleep(cgroupPollInterval)
+	}
+}
+
+// cgroupProcs returns the processes in the cgroup dir.
+func cgroupProcs(dir string) ([]int, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "cgroup.procs"))
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, field := range strings.Fields(string(data)) {
+		if pid, err := strconv.Atoi(field); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// writeCgroupFile writes value to the interface file name of the cgroup dir.
+func writeCgroupFile(dir, name, value string) error {
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(value)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
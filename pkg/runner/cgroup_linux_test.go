@@ -0,0 +1,94 @@
+//go:build linux
+
+package runner
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+// writableCgroupRoot returns a cgroup2 mount in which the test may create cgroups.
+func writableCgroupRoot(t *testing.T) string {
+	t.Helper()
+	mounts, err := os.ReadFile("/proc/self/mounts")
+	if err != nil {
+		t.Skipf("mounts unavailable: %v", err)
+	}
+	for line := range strings.Lines(string(mounts)) {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[2] != "cgroup2" {
+			continue
+		}
+		probe := filepath.Join(fields[1], "secure-shell-test")
+		if err := os.Mkdir(probe, 0o755); err == nil {
+			_ = os.Remove(probe)
+			return fields[1]
+		}
+	}
+	t.Skip("no writable cgroup2 mount")
+	return ""
+}
+
+func TestCgroup(t *testing.T) {
+	root := writableCgroupRoot(t)
+	saved := cgroupRoot
+	cgroupRoot = root
+	t.Cleanup(func() { cgroupRoot = saved })
+
+	tmpDir := t.TempDir()
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories:  []string{tmpDir, "/proc"},
+		AllowCommands:       []config.AllowCommand{{Command: "cat"}, {Command: "sh"}, {Command: "sleep"}},
+		DefaultErrorMessage: "Command not allowed",
+		MaxExecutionTime:    10,
+		AllowBackground:     true,
+		Cgroup:              config.CgroupConfig{Enabled: true},
+	}
+	log := logger.New()
+	r := New(cfg, validator.New(cfg, log), log)
+	var stdout bytes.Buffer
+	r.SetOutputs(&stdout, &bytes.Buffer{})
+
+	// Commands start in the execution's cgroup
+	result := r.RunCommand(t.Context(), "cat /proc/self/cgroup", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.Contains(t, stdout.String(), "/"+cgroupPattern)
+
+	// Processes left behind are killed with the cgroup when the execution ends
+	start := time.Now()
+	result = r.RunCommand(t.Context(), "sh -c 'sleep 30 &'", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.True(t, time.Since(start) < 10*time.Second)
+
+	data, err := os.ReadFile("/proc/self/cgroup")
+	assert.NoError(t, err)
+	own, _ := parseProcCgroup(data)
+	remaining, err := filepath.Glob(filepath.Join(root, own, cgroupPattern+"*"))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(remaining), "cgroups left: %v", remaining)
+}
+
+func TestCgroupFiles(t *testing.T) {
+	path, ok := parseProcCgroup([]byte("12:pids:/\n0::/system.slice/secure-shell.service\n"))
+	assert.True(t, ok)
+	assert.Equal(t, "/system.slice/secure-shell.service", path)
+	_, ok = parseProcCgroup([]byte("12:pids:/\n"))
+	assert.False(t, ok)
+
+	assert.Equal(t, "150000 100000", cpuMax(1.5))
+	assert.Equal(t, "1000 100000", cpuMax(0.001))
+
+	events := []byte("low 0\nhigh 0\nmax 12\noom 1\noom_kill 1\noom_group_kill 0\n")
+	assert.Equal(t, 1, cgroupEventCount(events, "oom_kill"))
+	assert.Equal(t, 0, cgroupEventCount(events, "oom_group_kill"))
+}
@@ -0,0 +1,34 @@
+//go:build !linux
+
+package runner
+
+import (
+	"os/exec"
+	"time"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+// cgroup is never created because cgroups are only available on Linux.
+type cgroup struct{}
+
+// newCgroup fails because cgroups are only available on Linux.
+func newCgroup(_ config.CgroupConfig) (*cgroup, error) {
+	return nil, errCgroupUnsupported
+}
+
+// attach does nothing: no cgroup is ever created.
+func (c *cgroup) attach(_ *exec.Cmd) {}
+
+// kill does nothing: no cgroup is ever created.
+func (c *cgroup) kill() {}
+
+// oomKilled returns false: no cgroup is ever created.
+func (c *cgroup) oomKilled() bool {
+	return false
+}
+
+// remove does nothing: no cgroup is ever created.
+func (c *cgroup) remove(_ time.Duration) error {
+	return nil
+}
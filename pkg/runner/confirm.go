@@ -0,0 +1,80 @@
+package runner
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// ExecRequest is a command marked confirm that is about to run.
+type ExecRequest struct {
+	Command string
+	Args    []string
+	WorkDir string
+	// Caller is the identity of the caller running the command, when known
+	Caller string
+}
+
+// ConfirmationFunc asks a human whether the command of req may run. It returns true to run
+// the command and false to refuse it; an error, e.g. when no one could be asked, refuses it too.
+type ConfirmationFunc func(req ExecRequest) (bool, error)
+
+// SetConfirmation makes fn decide whether commands marked confirm run. Without a function,
+// such commands are denied.
+func (r *SafeRunner) SetConfirmation(fn ConfirmationFunc) {
+	r.confirm = fn
+}
+
+// confirmExec asks the confirmation function whether the command of req may run, returning
+// the denial message otherwise.
+func (r *SafeRunner) confirmExec(req ExecRequest) (string, bool) {
+	if r.confirm == nil {
+		return fmt.Sprintf("command %q requires confirmation, but no confirmation prompt is configured", req.Command), false
+	}
+
+	req.Caller = r.identity.String()
+	confirmed, err := r.confirm(req)
+	switch {
+	case err != nil:
+		return fmt.Sprintf("command %q was not confirmed: %v", req.Command, err), false
+	case !confirmed:
+		return fmt.Sprintf("command %q was not confirmed", req.Command), false
+	}
+	r.logger.LogInfof("Command %s was confirmed", req.Command)
+	return "", true
+}
+
+// PromptConfirmation returns a ConfirmationFunc that shows each command on out and reads a
+// yes or no answer from in, typically the controlling terminal. Anything but "y" or "yes"
+// refuses the command. Commands of a pipeline are asked about one at a time.
+func PromptConfirmation(in io.Reader, out io.Writer) ConfirmationFunc {
+	var mu sync.Mutex
+	reader := bufio.NewReader(in)
+	return func(req ExecRequest) (bool, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		command, err := quoteArgs(append([]string{req.Command}, req.Args...), syntax.LangBash)
+		if err != nil {
+			command = strings.Join(append([]string{req.Command}, req.Args...), " ")
+		}
+		if _, err := fmt.Fprintf(out, "Run %s in %s? [y/N] ", command, req.WorkDir); err != nil {
+			return false, err
+		}
+		answer, err := reader.ReadString('\n')
+		if err != nil && (!errors.Is(err, io.EOF) || answer == "") {
+			return false, fmt.Errorf("no answer: %w", err)
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return true, nil
+		default:
+			return false, nil
+		}
+	}
+}
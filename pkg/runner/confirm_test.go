@@ -0,0 +1,71 @@
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+// newConfirmTestRunner returns a runner on which ls must be confirmed.
+func newConfirmTestRunner(t *testing.T, tmpDir string) *SafeRunner {
+	t.Helper()
+	r := newHintTestRunner(t, tmpDir)
+	for i := range r.config.AllowCommands {
+		if r.config.AllowCommands[i].Command == "ls" {
+			r.config.AllowCommands[i].Confirm = true
+		}
+	}
+	return r
+}
+
+func TestSafeRunner_Confirmation(t *testing.T) {
+	tmpDir := t.TempDir()
+	r := newConfirmTestRunner(t, tmpDir)
+
+	// Without a confirmation function, the command is denied
+	result := r.RunCommand(t.Context(), "ls -a", tmpDir)
+	assert.Equal(t, ExitDenied, ExitCode(result.Err))
+	assert.Contains(t, result.Err.Error(), "no confirmation prompt is configured")
+
+	var seen []ExecRequest
+	answer, answerErr := true, error(nil)
+	r.SetConfirmation(func(req ExecRequest) (bool, error) {
+		seen = append(seen, req)
+		return answer, answerErr
+	})
+
+	result = r.RunCommand(t.Context(), "echo ok; ls -a", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, []ExecRequest{{Command: "ls", Args: []string{"-a"}, WorkDir: tmpDir}}, seen)
+
+	answer = false
+	result = r.RunCommand(t.Context(), "ls", tmpDir)
+	assert.Equal(t, ExitDenied, ExitCode(result.Err))
+	assert.Contains(t, result.Err.Error(), `command "ls" was not confirmed`)
+
+	answer, answerErr = true, errors.New("prompt closed")
+	result = r.RunCommand(t.Context(), "ls", tmpDir)
+	assert.Equal(t, ExitDenied, ExitCode(result.Err))
+	assert.Contains(t, result.Err.Error(), "prompt closed")
+}
+
+func TestPromptConfirmation(t *testing.T) {
+	var out bytes.Buffer
+	confirm := PromptConfirmation(strings.NewReader("y\nno\nYES"), &out)
+	req := ExecRequest{Command: "rm", Args: []string{"-r", "build dir"}, WorkDir: "/work"}
+
+	for _, want := range []bool{true, false, true} {
+		got, err := confirm(req)
+		assert.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+	assert.Equal(t, strings.Repeat("Run rm -r 'build dir' in /work? [y/N] ", 3), out.String())
+
+	// Without an answer the command is refused
+	got, err := confirm(req)
+	assert.Error(t, err)
+	assert.False(t, got)
+}
@@ -0,0 +1,36 @@
+package runner
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+func TestDialect(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, stdout := newOptionsTestRunner(t, tmpDir)
+	script := "words=(posix bash); echo ${words[1]}"
+
+	// Bash is the default dialect
+	assert.NoError(t, r.RunCommand(t.Context(), script, tmpDir).Err)
+	assert.Equal(t, "bash\n", stdout.String())
+
+	// Bash syntax is rejected when the script is parsed as POSIX
+	result := r.RunScriptCapture(t.Context(), script, WithWorkdir(tmpDir), WithDialect(config.DialectPOSIX))
+	assert.Equal(t, ExitInvalid, ExitCode(result.Err))
+	assert.Contains(t, result.Err.Error(), "parse error")
+
+	r.config.Dialect = config.DialectPOSIX
+	assert.Equal(t, ExitInvalid, ExitCode(r.RunCommand(t.Context(), script, tmpDir).Err))
+	assert.Equal(t, ExitInvalid, ExitCode(r.Run(t.Context(), []string{"echo", "\x01"}).Err))
+	assert.False(t, r.validator.ValidateScript(script, tmpDir).Valid())
+
+	// A call can still choose the dialect its script was written in
+	assert.NoError(t, r.RunScriptCapture(t.Context(), script, WithWorkdir(tmpDir), WithDialect(config.DialectBash)).Err)
+
+	result = r.RunScriptCapture(t.Context(), "echo", WithDialect("zsh"))
+	assert.True(t, errors.Is(result.Err, ErrOptionNotPermitted))
+}
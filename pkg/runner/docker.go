@@ -0,0 +1,353 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"mvdan.cc/sh/v3/interp"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+const (
+	// dockerAPIVersion is the Engine API version requested; daemons from Docker 20.10 on support it.
+	dockerAPIVersion = "v1.41"
+	// defaultDockerHost is the daemon address used when neither the configuration nor DOCKER_HOST set one.
+	defaultDockerHost = "unix:///var/run/docker.sock"
+	// dockerCleanupTimeout bounds how long removing a container may take after its command finished.
+	dockerCleanupTimeout = 10 * time.Second
+	// dockerTmpfs is mounted on /tmp, the only writable path of the read-only root filesystem.
+	dockerTmpfs = "rw,noexec,nosuid,size=64m"
+)
+
+// Stream types in the multiplexed output of a container attached without a TTY.
+const (
+	dockerStreamStdout = 1
+	dockerStreamStderr = 2
+	dockerFrameHeader  = 8
+)
+
+// errDockerHost is returned for daemon addresses other than unix:// and tcp://.
+var errDockerHost = errors.New("unsupported docker host")
+
+// dockerClient is a minimal client of the Docker Engine API, covering what is needed
+// to run a command in a container and stream its output.
+type dockerClient struct {
+	network string
+	address string
+	http    *http.Client
+}
+
+// newDockerClient returns a client for the daemon at host, e.g. unix:///var/run/docker.sock or tcp://127.0.0.1:2375.
+func newDockerClient(host string) (*dockerClient, error) {
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+	if host == "" {
+		host = defaultDockerHost
+	}
+	network, address, ok := strings.Cut(host, "://")
+	if !ok || (network != "unix" && network != "tcp") {
+		return nil, fmt.Errorf("%w: %s", errDockerHost, host)
+	}
+
+	c := &dockerClient{network: network, address: address}
+	c.http = &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return c.dial(ctx)
+	}}}
+	return c, nil
+}
+
+// dial connects to the daemon.
+func (c *dockerClient) dial(ctx context.Context) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, c.network, c.address)
+}
+
+// newRequest builds an API request; the host is ignored because every request goes to the daemon.
+func (c *dockerClient) newRequest(ctx context.Context, method, path string, body any) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, "http://docker/"+dockerAPIVersion+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// do sends a request and decodes the JSON response into out, if it is not nil.
+func (c *dockerClient) do(ctx context.Context, method, path string, body, out any) error {
+	req, err := c.newRequest(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return dockerError(resp)
+	}
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// dockerError converts an error response of the daemon into an error.
+func dockerError(resp *http.Response) error {
+	var body struct {
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Message == "" {
+		return fmt.Errorf("docker: %s", resp.Status)
+	}
+	return fmt.Errorf("docker: %s", body.Message)
+}
+
+// attach connects to the streams of a created container. The returned connection is
+// hijacked from HTTP: writes go to the container's stdin, and reads return its output
+// multiplexed as described by demuxDocker.
+func (c *dockerClient) attach(ctx context.Context, id string, stdin bool) (net.Conn, io.Reader, error) {
+	query := url.Values{"stream": {"1"}, "stdout": {"1"}, "stderr": {"1"}}
+	if stdin {
+		query.Set("stdin", "1")
+	}
+	req, err := c.newRequest(ctx, http.MethodPost, "/containers/"+id+"/attach?"+query.Encode(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols && resp.StatusCode != http.StatusOK {
+		defer conn.Close()
+		return nil, nil, dockerError(resp)
+	}
+	return conn, reader, nil
+}
+
+// containerSpec is the body of a container create request.
+type containerSpec struct {
+	Image        string            `json:"Image"`
+	Cmd          []string          `json:"Cmd"`
+	Env          []string          `json:"Env"`
+	WorkingDir   string            `json:"WorkingDir"`
+	User         string            `json:"User,omitempty"`
+	AttachStdin  bool              `json:"AttachStdin"`
+	AttachStdout bool              `json:"AttachStdout"`
+	AttachStderr bool              `json:"AttachStderr"`
+	OpenStdin    bool              `json:"OpenStdin"`
+	StdinOnce    bool              `json:"StdinOnce"`
+	HostConfig   containerHost     `json:"HostConfig"`
+	Labels       map[string]string `json:"Labels,omitempty"`
+}
+
+// containerHost is the host configuration of a container.
+type containerHost struct {
+	Mounts         []containerMount  `json:"Mounts"`
+	NetworkMode    string            `json:"NetworkMode"`
+	ReadonlyRootfs bool              `json:"ReadonlyRootfs"`
+	Tmpfs          map[string]string `json:"Tmpfs"`
+	CapDrop        []string          `json:"CapDrop"`
+	SecurityOpt    []string          `json:"SecurityOpt"`
+}
+
+// containerMount is a bind mount of a host path.
+type containerMount struct {
+	Type     string `json:"Type"`
+	Source   string `json:"Source"`
+	Target   string `json:"Target"`
+	ReadOnly bool   `json:"ReadOnly"`
+}
+
+// containerSpec describes the container that runs args in dir. Only the allowed
+// directories are mounted, at their host paths, so paths validated by the policy mean
+// the same inside the container.
+func (r *SafeRunner) containerSpec(args []string, dir string, env []string, stdin bool) containerSpec {
+	cfg := r.config.Docker
+	network := cfg.Network
+	if network == "" || r.networkDisabled(args[0]) {
+		network = config.DefaultDockerNetwork
+	}
+	user := cfg.User
+	if user == "" && os.Getuid() >= 0 {
+		user = strconv.Itoa(os.Getuid()) + ":" + strconv.Itoa(os.Getgid())
+	}
+
+	var mounts []containerMount
+	for _, path := range r.config.Directories() {
+		// Devices such as /dev/null exist in every container, and bind mounts of missing paths fail
+		if strings.HasPrefix(path, "/dev/") {
+			continue
+		}
+		if info, err := os.Stat(path); err != nil || !info.IsDir() {
+			continue
+		}
+		mounts = append(mounts, containerMount{Type: "bind", Source: path, Target: path, ReadOnly: r.config.ReadOnlyOnly})
+	}
+
+	// The image's PATH applies, not the host's
+	containerEnv := make([]string, 0, len(env))
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, "PATH=") {
+			containerEnv = append(containerEnv, kv)
+		}
+	}
+
+	return containerSpec{
+		Image:        cfg.Image,
+		Cmd:          args,
+		Env:          containerEnv,
+		WorkingDir:   dir,
+		User:         user,
+		AttachStdin:  stdin,
+		AttachStdout: true,
+		AttachStderr: true,
+		OpenStdin:    stdin,
+		StdinOnce:    stdin,
+		HostConfig: containerHost{
+			Mounts:         mounts,
+			NetworkMode:    network,
+			ReadonlyRootfs: true,
+			Tmpfs:          map[string]string{"/tmp": dockerTmpfs},
+			CapDrop:        []string{"ALL"},
+			SecurityOpt:    []string{"no-new-privileges"},
+		},
+		Labels: map[string]string{"secure-shell-server": "1"},
+	}
+}
+
+// runContainer runs args in a new container, forwarding stdin and streaming its output
+// to the handler's writers, and removes the container afterwards.
+func (r *SafeRunner) runContainer(ctx context.Context, hc interp.HandlerContext, args, env []string) error {
+	client, err := newDockerClient(r.config.Docker.Host)
+	if err != nil {
+		return err
+	}
+	stdin := hc.Stdin != nil
+
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := client.do(ctx, http.MethodPost, "/containers/create", r.containerSpec(args, hc.Dir, env, stdin), &created); err != nil {
+		return fmt.Errorf("failed to create container: %w", err)
+	}
+	// The container is removed even when ctx was canceled
+	cleanupCtx := context.WithoutCancel(ctx)
+	defer func() {
+		ctx, cancel := context.WithTimeout(cleanupCtx, dockerCleanupTimeout)
+		defer cancel()
+		if err := client.do(ctx, http.MethodDelete, "/containers/"+created.ID+"?force=1", nil, nil); err != nil {
+			r.logger.LogErrorf("Failed to remove container %s: %v", created.ID, err)
+		}
+	}()
+
+	conn, output, err := client.attach(ctx, created.ID, stdin)
+	if err != nil {
+		return fmt.Errorf("failed to attach to container: %w", err)
+	}
+	defer conn.Close()
+
+	if err := client.do(ctx, http.MethodPost, "/containers/"+created.ID+"/start", nil, nil); err != nil {
+		return fmt.Errorf("failed to start container: %w", err)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = client.do(cleanupCtx, http.MethodPost, "/containers/"+created.ID+"/kill", nil, nil)
+	})
+	defer stop()
+
+	if stdin {
+		go func() {
+			_, _ = io.Copy(conn, hc.Stdin)
+			// Closing our end of the stream closes the container's stdin
+			if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+				_ = cw.CloseWrite()
+			}
+		}()
+	}
+	if err := demuxDocker(output, hc.Stdout, hc.Stderr); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("failed to read container output: %w", err)
+	}
+
+	var waited struct {
+		StatusCode int `json:"StatusCode"`
+	}
+	if err := client.do(cleanupCtx, http.MethodPost, "/containers/"+created.ID+"/wait", nil, &waited); err != nil {
+		return fmt.Errorf("failed to wait for container: %w", err)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if waited.StatusCode != 0 {
+		return interp.NewExitStatus(uint8(waited.StatusCode)) //nolint:gosec // exit codes are truncated like a shell does
+	}
+	return nil
+}
+
+// demuxDocker copies the multiplexed output of an attached container to stdout and
+// stderr until EOF. Each frame is an 8-byte header, holding the stream type in its
+// first byte and the big-endian payload size in its last four, followed by the payload.
+func demuxDocker(r io.Reader, stdout, stderr io.Writer) error {
+	header := make([]byte, dockerFrameHeader)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		var dst io.Writer
+		switch header[0] {
+		case dockerStreamStdout:
+			dst = stdout
+		case dockerStreamStderr:
+			dst = stderr
+		default:
+			dst = io.Discard
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(dst, r, size); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,143 @@
+//go:build !windows
+
+package runner
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+// fakeDocker is a Docker daemon running every container as a command that echoes its
+// stdin to stdout, writes "warn" to stderr, and exits with status 3.
+type fakeDocker struct {
+	mu      sync.Mutex
+	spec    containerSpec
+	removed bool
+	started chan struct{}
+}
+
+func (d *fakeDocker) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := strings.TrimPrefix(req.URL.Path, "/"+dockerAPIVersion)
+	switch {
+	case req.Method == http.MethodPost && path == "/containers/create":
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if err := json.NewDecoder(req.Body).Decode(&d.spec); err != nil {
+			http.Error(w, `{"message":"bad spec"}`, http.StatusBadRequest)
+			return
+		}
+		_, _ = io.WriteString(w, `{"Id":"c1"}`)
+	case path == "/containers/c1/attach":
+		d.attach(w, req)
+	case path == "/containers/c1/start":
+		close(d.started)
+		w.WriteHeader(http.StatusNoContent)
+	case path == "/containers/c1/wait":
+		_, _ = io.WriteString(w, `{"StatusCode":3}`)
+	case req.Method == http.MethodDelete && path == "/containers/c1":
+		d.mu.Lock()
+		d.removed = true
+		d.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, `{"message":"not found"}`, http.StatusNotFound)
+	}
+}
+
+// attach hijacks the connection and runs the fake command once the container is started.
+func (d *fakeDocker) attach(w http.ResponseWriter, req *http.Request) {
+	conn, buf, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	_, _ = io.WriteString(conn, "HTTP/1.1 101 UPGRADED\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+	<-d.started
+
+	var input []byte
+	if req.URL.Query().Get("stdin") == "1" {
+		input, _ = io.ReadAll(buf)
+	}
+	writeFrame(conn, dockerStreamStdout, input)
+	writeFrame(conn, dockerStreamStderr, []byte("warn\n"))
+}
+
+// writeFrame writes a frame of multiplexed container output.
+func writeFrame(w io.Writer, stream byte, payload []byte) {
+	header := make([]byte, dockerFrameHeader)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(payload))) //nolint:gosec // test payloads are small
+	_, _ = w.Write(append(header, payload...))
+}
+
+func TestDockerBackend(t *testing.T) {
+	socketDir, err := os.MkdirTemp("", "docker")
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(socketDir) })
+	socket := filepath.Join(socketDir, "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	assert.NoError(t, err)
+
+	daemon := &fakeDocker{started: make(chan struct{})}
+	server := httptest.NewUnstartedServer(daemon)
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+
+	tmpDir := t.TempDir()
+	r, stdout := newOptionsTestRunner(t, tmpDir)
+	var stderr bytes.Buffer
+	r.SetOutputs(stdout, &stderr)
+	r.config.ExecutionBackend = config.BackendDocker
+	r.config.Docker = config.DockerConfig{Image: "alpine:3", Host: "unix://" + socket}
+
+	result := r.RunCommand(t.Context(), "echo hello | cat", tmpDir)
+	assert.Equal(t, 3, ExitCode(result.Err))
+	assert.Equal(t, "hello\n", stdout.String())
+	assert.Equal(t, "warn\n", stderr.String())
+
+	daemon.mu.Lock()
+	defer daemon.mu.Unlock()
+	assert.True(t, daemon.removed)
+	spec := daemon.spec
+	assert.Equal(t, "alpine:3", spec.Image)
+	assert.Equal(t, []string{"cat"}, spec.Cmd)
+	assert.Equal(t, tmpDir, spec.WorkingDir)
+	assert.True(t, spec.OpenStdin)
+	assert.Equal(t, config.DefaultDockerNetwork, spec.HostConfig.NetworkMode)
+	assert.True(t, spec.HostConfig.ReadonlyRootfs)
+	assert.Equal(t, []containerMount{{Type: "bind", Source: tmpDir, Target: tmpDir}}, spec.HostConfig.Mounts)
+	for _, kv := range spec.Env {
+		assert.False(t, strings.HasPrefix(kv, "PATH="), "host PATH must not reach the container")
+	}
+}
+
+func TestDemuxDocker(t *testing.T) {
+	var stream bytes.Buffer
+	writeFrame(&stream, dockerStreamStdout, []byte("out1 "))
+	writeFrame(&stream, dockerStreamStderr, []byte("err"))
+	writeFrame(&stream, dockerStreamStdout, []byte("out2"))
+
+	var stdout, stderr bytes.Buffer
+	assert.NoError(t, demuxDocker(&stream, &stdout, &stderr))
+	assert.Equal(t, "out1 out2", stdout.String())
+	assert.Equal(t, "err", stderr.String())
+
+	// A truncated frame is an error
+	writeFrame(&stream, dockerStreamStdout, []byte("data"))
+	assert.Error(t, demuxDocker(bytes.NewReader(stream.Bytes()[:10]), io.Discard, io.Discard))
+}
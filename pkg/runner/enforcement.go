@@ -0,0 +1,54 @@
+package runner
+
+import (
+	"context"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/history"
+)
+
+// Denial is a denial the policy would have made, recorded instead of enforced in
+// permissive mode.
+type Denial struct {
+	// Command is the denied command, or empty when the denial concerns the whole script,
+	// its working directory, or a file it opens.
+	Command string   `json:"command,omitempty"`
+	Args    []string `json:"args,omitempty"`
+	Message string   `json:"message"`
+}
+
+// permissive reports whether denials are recorded instead of enforced.
+func (r *SafeRunner) permissive() bool {
+	return r.config.EnforcementMode == config.EnforcementPermissive
+}
+
+// deny handles a command the policy denies. When enforcing, the denial is reported and
+// returned as the error failing the command; in permissive mode it is only recorded, and
+// deny returns nil so that the command runs.
+func (r *SafeRunner) deny(ctx context.Context, cmd string, args []string, workDir, message string) error {
+	if !r.permissive() {
+		r.denied(ctx, cmd, args, workDir, message)
+		return deniedError(message)
+	}
+	r.wouldHaveDenied(ctx, cmd, args, workDir, message)
+	return nil
+}
+
+// wouldHaveDenied records a denial that permissive mode does not enforce. OnDeny hooks and
+// alerts are not notified, since nothing was blocked.
+func (r *SafeRunner) wouldHaveDenied(ctx context.Context, cmd string, args []string, workDir, message string) {
+	r.logger.LogCommandWouldBlock(cmd, args, message)
+	r.recordHistory(ctx, history.Entry{Command: cmd, WorkDir: workDir, Decision: history.DecisionWouldDeny, Message: message}, args)
+	r.wouldDenyMu.Lock()
+	r.wouldDeny = append(r.wouldDeny, Denial{Command: cmd, Args: args, Message: r.redactor.Redact(message)})
+	r.wouldDenyMu.Unlock()
+}
+
+// takeWouldDeny returns the denials recorded since it was last called.
+func (r *SafeRunner) takeWouldDeny() []Denial {
+	r.wouldDenyMu.Lock()
+	defer r.wouldDenyMu.Unlock()
+	denials := r.wouldDeny
+	r.wouldDeny = nil
+	return denials
+}
@@ -0,0 +1,105 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/audit"
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+func TestSafeRunner_PermissiveMode(t *testing.T) {
+	tmpDir := t.TempDir()
+	outside := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "old.txt"), []byte("old\n"), 0o600))
+	cfg := setupCustomConfig()
+	cfg.AllowedDirectories = []string{tmpDir}
+	cfg.EnforcementMode = config.EnforcementPermissive
+	var logs bytes.Buffer
+	log := logger.NewWithWriter(&logs)
+	r := New(cfg, validator.New(cfg, log), log)
+	var stdout strings.Builder
+	r.SetOutputs(&stdout, io.Discard)
+
+	var onDeny []string
+	r.OnDeny(func(_ context.Context, ec *ExecContext, _ string) {
+		onDeny = append(onDeny, ec.Command)
+	})
+	var events []audit.Event
+	auditor := &audit.Auditor{}
+	auditor.AddSink(sinkFunc(func(e audit.Event) error {
+		events = append(events, e)
+		return nil
+	}))
+	r.SetAuditor(auditor)
+
+	outsideFile := filepath.Join(outside, "out.txt")
+	result := r.RunCommand(t.Context(), "rm old.txt; echo done > "+outsideFile, tmpDir)
+	assert.NoError(t, result.Err)
+
+	// Both denials were recorded, and both commands ran
+	assert.Equal(t, 2, len(result.WouldDeny))
+	assert.Equal(t, "rm", result.WouldDeny[0].Command)
+	assert.Equal(t, []string{"old.txt"}, result.WouldDeny[0].Args)
+	assert.Contains(t, result.WouldDeny[0].Message, "Remove command is not allowed")
+	assert.Equal(t, "", result.WouldDeny[1].Command)
+	assert.Contains(t, result.WouldDeny[1].Message, "file is outside allowed directories")
+	_, err := os.Stat(filepath.Join(tmpDir, "old.txt"))
+	assert.True(t, os.IsNotExist(err))
+	data, err := os.ReadFile(outsideFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "done\n", string(data))
+
+	assert.Contains(t, logs.String(), `[WOULD BLOCK] Command: rm [old.txt], command "rm" is denied: Remove command is not allowed`)
+	assert.Equal(t, 0, len(onDeny))
+	assert.Equal(t, audit.OutcomeWouldDeny, events[0].Outcome)
+	assert.Equal(t, "rm", events[0].Command)
+	assert.Equal(t, audit.OutcomeExecuted, events[1].Outcome)
+	assert.Equal(t, "rm", events[1].Command)
+
+	// Denials are reported per execution
+	result = r.RunCommand(t.Context(), "echo ok", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, 0, len(result.WouldDeny))
+
+	// The same policy enforced blocks the script
+	cfg.EnforcementMode = config.EnforcementEnforcing
+	result = r.RunCommand(t.Context(), "echo again > "+outsideFile+"; rm "+outsideFile, tmpDir)
+	assert.Equal(t, ExitDenied, ExitCode(result.Err))
+	assert.Equal(t, 0, len(result.WouldDeny))
+	assert.Equal(t, []string{"rm"}, onDeny)
+	data, err = os.ReadFile(outsideFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "done\n", string(data))
+}
+
+func TestSafeRunner_PermissiveModeScriptChecks(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := setupCustomConfig()
+	cfg.AllowedDirectories = []string{tmpDir}
+	cfg.ScriptLimits = config.ScriptLimitsConfig{MaxDepth: 1}
+	cfg.EnforcementMode = config.EnforcementPermissive
+	log := logger.New()
+	r := New(cfg, validator.New(cfg, log), log)
+	var stdout strings.Builder
+	r.SetOutputs(&stdout, io.Discard)
+
+	result := r.RunCommand(t.Context(), "echo $(echo $(echo deep)) &", tmpDir)
+	assert.NoError(t, result.Err)
+	messages := make([]string, 0, len(result.WouldDeny))
+	for _, d := range result.WouldDeny {
+		messages = append(messages, d.Message)
+	}
+	assert.Equal(t, 2, len(messages), "%v", messages)
+	assert.Contains(t, messages[0], "script nests more than 1 levels deep")
+	assert.Contains(t, messages[1], "background")
+}
@@ -0,0 +1,198 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
+)
+
+// Event is an execution event delivered to the channels passed to Subscribe. It is one of
+// *StartedEvent, *OutputEvent, *DeniedEvent, *TimedOutEvent, and *FinishedEvent.
+type Event interface {
+	// Info returns the fields shared by every event.
+	Info() EventInfo
+}
+
+// EventInfo holds the fields shared by every event.
+type EventInfo struct {
+	// ExecutionID identifies the execution within the process; all events of an
+	// execution carry the same ID.
+	ExecutionID uint64
+	// Caller is the identity the execution runs as (see SetIdentity), zero if none.
+	Caller identity.Identity
+	// Time is when the event happened.
+	Time time.Time
+}
+
+// Info returns e.
+func (e EventInfo) Info() EventInfo {
+	return e
+}
+
+// StartedEvent is published when an execution starts, before the script is validated.
+type StartedEvent struct {
+	EventInfo
+	// Script is the script or command line, redacted.
+	Script  string
+	WorkDir string
+}
+
+// OutputEvent is published for each write of an execution to stdout or stderr, after
+// truncation and redaction. Output written to an *os.File passed to SetOutputs, which
+// commands write to directly, is not published.
+type OutputEvent struct {
+	EventInfo
+	Stream Stream
+	Data   []byte
+}
+
+// DeniedEvent is published when the policy denies a command or a BeforeExec hook vetoes it.
+// Denials that permissive mode does not enforce are not published.
+type DeniedEvent struct {
+	EventInfo
+	Command string
+	// Args and Message are redacted.
+	Args    []string
+	WorkDir string
+	Message string
+}
+
+// TimedOutEvent is published when an execution is stopped by its time or idle limit,
+// just before its FinishedEvent.
+type TimedOutEvent struct {
+	EventInfo
+	// Duration is how long the execution ran.
+	Duration time.Duration
+}
+
+// FinishedEvent is the last event of every execution, including executions rejected
+// before they ran.
+type FinishedEvent struct {
+	EventInfo
+	// ExitCode is the code ExitCode returns for Err.
+	ExitCode int
+	// Err is the execution's error, the same as RunResult.Err.
+	Err      error
+	Duration time.Duration
+}
+
+// eventBus is the process-wide set of subscribers. Like the kill switch, it covers every
+// runner in the process, so that one subscription observes all servers and sessions.
+var eventBus = struct {
+	mu          sync.Mutex
+	subscribers map[*chan<- Event]struct{}
+	// count is the number of subscribers, read without the lock to skip publishing
+	count atomic.Int32
+	// lastID is the ID of the last execution started
+	lastID atomic.Uint64
+}{subscribers: make(map[*chan<- Event]struct{})}
+
+// Subscribe delivers the events of every execution in the process to ch until the
+// returned function is called. Publishing never blocks an execution: events that do not
+// fit into ch are dropped, so ch should be buffered and drained promptly. Events of one
+// execution arrive in order. ch is not closed when the subscription ends.
+func Subscribe(ch chan<- Event) func() {
+	key := &ch
+	eventBus.mu.Lock()
+	eventBus.subscribers[key] = struct{}{}
+	eventBus.count.Add(1)
+	eventBus.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			eventBus.mu.Lock()
+			delete(eventBus.subscribers, key)
+			eventBus.count.Add(-1)
+			eventBus.mu.Unlock()
+		})
+	}
+}
+
+// hasSubscribers reports whether any channel is subscribed.
+func hasSubscribers() bool {
+	return eventBus.count.Load() > 0
+}
+
+// publish sends e to every subscriber whose channel has room.
+func publish(e Event) {
+	eventBus.mu.Lock()
+	defer eventBus.mu.Unlock()
+	for ch := range eventBus.subscribers {
+		select {
+		case *ch <- e:
+		default:
+		}
+	}
+}
+
+// eventInfo returns the shared fields of an event of the current execution.
+func (r *SafeRunner) eventInfo() EventInfo {
+	return EventInfo{ExecutionID: r.executionID.Load(), Caller: r.identity, Time: time.Now()}
+}
+
+// publishStarted assigns the execution its ID and publishes its StartedEvent.
+func (r *SafeRunner) publishStarted(script, workDir string) {
+	r.executionID.Store(eventBus.lastID.Add(1))
+	if !hasSubscribers() {
+		return
+	}
+	publish(&StartedEvent{EventInfo: r.eventInfo(), Script: r.redactor.Redact(script), WorkDir: workDir})
+}
+
+// publishFinished publishes the TimedOutEvent of an execution stopped by its limits and its
+// FinishedEvent.
+func (r *SafeRunner) publishFinished(err error, started time.Time) {
+	if !hasSubscribers() {
+		return
+	}
+	duration := time.Since(started)
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrIdleTimeout) {
+		publish(&TimedOutEvent{EventInfo: r.eventInfo(), Duration: duration})
+	}
+	publish(&FinishedEvent{EventInfo: r.eventInfo(), ExitCode: ExitCode(err), Err: err, Duration: duration})
+}
+
+// publishDenied publishes the DeniedEvent of a command.
+func (r *SafeRunner) publishDenied(cmd string, args []string, workDir, message string) {
+	if !hasSubscribers() {
+		return
+	}
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		redacted[i] = r.redactor.Redact(arg)
+	}
+	publish(&DeniedEvent{EventInfo: r.eventInfo(), Command: cmd, Args: redacted, WorkDir: workDir, Message: r.redactor.Redact(message)})
+}
+
+// publishOutput returns a writer publishing what is written to w as OutputEvents. Files are
+// returned as they are, since commands write to them directly and their terminals must stay
+// visible to the commands.
+func (r *SafeRunner) publishOutput(w io.Writer, stream Stream) io.Writer {
+	if _, ok := w.(*os.File); ok {
+		return w
+	}
+	return &eventWriter{w: w, runner: r, stream: stream}
+}
+
+// eventWriter publishes what is written to a stream as OutputEvents on its way to w.
+type eventWriter struct {
+	w      io.Writer
+	runner *SafeRunner
+	stream Stream
+}
+
+func (e *eventWriter) Write(p []byte) (int, error) {
+	n, err := e.w.Write(p)
+	if n > 0 && hasSubscribers() {
+		// The caller may reuse p after Write returns
+		publish(&OutputEvent{EventInfo: e.runner.eventInfo(), Stream: e.stream, Data: append([]byte(nil), p[:n]...)})
+	}
+	return n, err
+}
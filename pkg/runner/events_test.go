@@ -0,0 +1,140 @@
+package runner
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+// collectEvents returns the events published for the last execution started.
+func collectEvents(ch chan Event) []Event {
+	var events []Event
+	for {
+		select {
+		case e := <-ch:
+			events = append(events, e)
+		default:
+			if len(events) == 0 {
+				return nil
+			}
+			last := events[len(events)-1].Info().ExecutionID
+			var execution []Event
+			for _, e := range events {
+				if e.Info().ExecutionID == last {
+					execution = append(execution, e)
+				}
+			}
+			return execution
+		}
+	}
+}
+
+func TestSubscribe_PublishesExecution(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, _ := newOptionsTestRunner(t, tmpDir)
+	ch := make(chan Event, 64)
+	unsubscribe := Subscribe(ch)
+	defer unsubscribe()
+
+	result := r.RunCommand(t.Context(), "echo hello; echo oops >&2", tmpDir)
+	assert.NoError(t, result.Err)
+
+	events := collectEvents(ch)
+	assert.True(t, len(events) >= 4)
+	started, ok := events[0].(*StartedEvent)
+	assert.True(t, ok)
+	assert.Equal(t, "echo hello; echo oops >&2", started.Script)
+	assert.Equal(t, tmpDir, started.WorkDir)
+	assert.NotZero(t, started.ExecutionID)
+
+	// Output arrives as it is written, possibly in several chunks
+	output := map[Stream]string{}
+	for _, e := range events[1 : len(events)-1] {
+		chunk, ok := e.(*OutputEvent)
+		assert.True(t, ok)
+		output[chunk.Stream] += string(chunk.Data)
+	}
+	assert.Equal(t, map[Stream]string{StreamStdout: "hello\n", StreamStderr: "oops\n"}, output)
+
+	finished, ok := events[len(events)-1].(*FinishedEvent)
+	assert.True(t, ok)
+	assert.Equal(t, 0, finished.ExitCode)
+	assert.NoError(t, finished.Err)
+}
+
+func TestSubscribe_PublishesDenial(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, _ := newOptionsTestRunner(t, tmpDir)
+	ch := make(chan Event, 64)
+	defer Subscribe(ch)()
+
+	result := r.RunCommand(t.Context(), "rm -rf /", tmpDir)
+	assert.Error(t, result.Err)
+
+	events := collectEvents(ch)
+	assert.Equal(t, 3, len(events))
+	denied, ok := events[1].(*DeniedEvent)
+	assert.True(t, ok)
+	assert.Equal(t, "rm", denied.Command)
+	assert.Equal(t, []string{"-rf", "/"}, denied.Args)
+	assert.NotZero(t, denied.Message)
+	finished, ok := events[2].(*FinishedEvent)
+	assert.True(t, ok)
+	assert.Equal(t, ExitDenied, finished.ExitCode)
+}
+
+func TestSubscribe_PublishesTimeout(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, _ := newOptionsTestRunner(t, tmpDir)
+	ch := make(chan Event, 64)
+	defer Subscribe(ch)()
+
+	result := r.Run(t.Context(), []string{"sleep", "5"}, WithTimeout(100*time.Millisecond))
+	assert.Error(t, result.Err)
+
+	events := collectEvents(ch)
+	assert.Equal(t, 3, len(events))
+	timedOut, ok := events[1].(*TimedOutEvent)
+	assert.True(t, ok)
+	assert.True(t, timedOut.Duration >= 100*time.Millisecond)
+	finished, ok := events[2].(*FinishedEvent)
+	assert.True(t, ok)
+	assert.Equal(t, ExitTimeout, finished.ExitCode)
+}
+
+func TestSubscribe_Unsubscribe(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, _ := newOptionsTestRunner(t, tmpDir)
+	ch := make(chan Event, 64)
+	unsubscribe := Subscribe(ch)
+	unsubscribe()
+	unsubscribe()
+
+	assert.NoError(t, r.RunCommand(t.Context(), "echo hello", tmpDir).Err)
+	assert.Equal(t, 0, len(collectEvents(ch)))
+	assert.False(t, hasSubscribers())
+}
+
+func TestSubscribe_DropsEventsWhenFull(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, _ := newOptionsTestRunner(t, tmpDir)
+	ch := make(chan Event, 1)
+	defer Subscribe(ch)()
+
+	// The execution does not wait for the subscriber
+	done := make(chan error, 1)
+	go func() { done <- r.RunCommand(t.Context(), "echo a; echo b; echo c", tmpDir).Err }()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal(errors.New("execution blocked on a full subscriber"))
+	}
+
+	events := collectEvents(ch)
+	assert.Equal(t, 1, len(events))
+	_, ok := events[0].(*StartedEvent)
+	assert.True(t, ok)
+}
@@ -0,0 +1,238 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"slices"
+	"syscall"
+	"time"
+
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+// killTimeout is how long an interrupted command has to exit before it is killed.
+const killTimeout = 2 * time.Second
+
+// Exit statuses used by shells when a command cannot be run.
+const (
+	exitCommandNotFound = 127
+	exitSignalOffset    = 128
+)
+
+// execHandler runs external commands. It mirrors interp.DefaultExecHandler, but resolves
+// executables and terminates process trees in a platform-specific way.
+func (r *SafeRunner) execHandler(ctx context.Context, args []string) error {
+	hc := interp.HandlerCtx(ctx)
+	return r.withRuleHooks(ctx, hc, args, func() error {
+		if r.cacheable(hc, args) {
+			return r.execCached(ctx, hc, args)
+		}
+		return r.execCommand(ctx, hc, args)
+	})
+}
+
+// execCommand runs a command in-process or as an external command.
+func (r *SafeRunner) execCommand(ctx context.Context, hc interp.HandlerContext, args []string) error {
+	// Read-only inspection commands can run without starting a process
+	if fn, ok := r.lookupInProcess(args[0]); ok {
+		return r.execInProcess(ctx, hc, args, fn)
+	}
+	// With trash enabled, deleted files are moved into the trash instead
+	if fn, ok := r.lookupTrash(args[0]); ok {
+		err := r.execInProcess(ctx, hc, args, fn)
+		r.invalidateCache()
+		return err
+	}
+
+	ctx, span := r.startSpan(ctx, spanExec, attrCommandName.String(args[0]), attrInProcess.Bool(false))
+	err := r.execProcess(ctx, hc, args)
+	endSpan(span, err)
+	if !r.cacheable(hc, args) {
+		r.invalidateCache()
+	}
+	return err
+}
+
+// execInProcess runs the in-process implementation fn of a command.
+func (r *SafeRunner) execInProcess(ctx context.Context, hc interp.HandlerContext, args []string, fn inProcessFunc) error {
+	_, span := r.startSpan(ctx, spanExec, attrCommandName.String(args[0]), attrInProcess.Bool(true))
+	start := time.Now()
+	err := fn(r, hc, args)
+	r.recordExecution(ctx, args, hc.Dir, err, time.Since(start))
+	endSpan(span, err)
+	return err
+}
+
+// execProcess resolves and runs an external command.
+func (r *SafeRunner) execProcess(ctx context.Context, hc interp.HandlerContext, args []string) error {
+	switch r.config.ExecutionBackend {
+	case config.BackendDocker:
+		return r.execRemote(ctx, hc, args, r.runContainer)
+	case config.BackendKubernetes:
+		return r.execRemote(ctx, hc, args, r.runInPod)
+	}
+
+	env := r.commandEnviron(hc.Env)
+	path, err := lookPath(ctx, hc.Dir, env, args[0])
+	if err != nil {
+		fmt.Fprintln(hc.Stderr, err)
+		err = interp.NewExitStatus(exitCommandNotFound)
+		r.recordExecution(ctx, args, hc.Dir, err, 0)
+		return err
+	}
+
+	if err := r.checkSetuid(ctx, args, path, hc.Dir); err != nil {
+		return err
+	}
+
+	ec := &ExecContext{Command: args[0], Args: args[1:], WorkDir: hc.Dir, Env: execEnv(env)}
+	if err := r.runBeforeExec(ctx, ec); err != nil {
+		return err
+	}
+	// Variables of env and of the command's rule, secrets among them, reach the command but
+	// not the script or hooks
+	configured, err := r.configuredEnv(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	cmd := &exec.Cmd{
+		Path:   path,
+		Args:   args,
+		Env:    r.egressEnv(args[0], slices.Concat(ec.Env, configured)),
+		Dir:    hc.Dir,
+		Stdin:  hc.Stdin,
+		Stdout: hc.Stdout,
+		Stderr: hc.Stderr,
+	}
+
+	// Interactive commands run in a pseudo-terminal instead of with pipes
+	term, err := r.openPty(cmd, hc.Stdin, hc.Stdout, hc.Stderr)
+	if err != nil {
+		return err
+	}
+
+	// Read stdout and stderr in one goroutine to keep their interleaving
+	var pump *outputPump
+	if term == nil {
+		if pump, err = newOutputPump(cmd, hc.Stdout, hc.Stderr); err != nil {
+			return fmt.Errorf("failed to create output pipes: %w", err)
+		}
+	}
+
+	start := time.Now()
+	metrics := CommandMetrics{Command: args[0], Args: args[1:]}
+	proc, err := r.start(cmd)
+	switch {
+	case term != nil && err != nil:
+		term.abort()
+	case term != nil:
+		term.start()
+	case pump != nil && err != nil:
+		pump.abort()
+	case pump != nil:
+		pump.start()
+	}
+	if err == nil {
+		exited := r.processStarted(cmd.Process.Pid, args)
+		untrack := r.trackProcess(proc)
+		cg := r.cgroup
+		stop := context.AfterFunc(ctx, func() {
+			proc.terminate(killTimeout)
+			// Processes that left the command's process group are still in the execution's cgroup
+			cg.kill()
+		})
+		err = cmd.Wait()
+		stop()
+		untrack()
+		exited()
+		if pump != nil {
+			pump.wait(killTimeout)
+		}
+		if term != nil {
+			term.wait(killTimeout)
+		}
+		proc.release()
+		if cmd.ProcessState != nil {
+			metrics = newCommandMetrics(args, time.Since(start), cmd.ProcessState)
+			r.recordMetrics(metrics)
+		}
+	}
+
+	err = exitError(ctx, hc.Stderr, err)
+	// A command stopped by a forwarded signal stops the script, as it would in a shell
+	if sigErr := r.interruption(); sigErr != nil && isExitStatus(err) {
+		err = sigErr
+	}
+	r.recordExecution(ctx, args, hc.Dir, err, time.Since(start))
+	r.runAfterExec(ctx, ec, metrics, err)
+	return err
+}
+
+// exitError converts the error from running a process into what the interpreter expects.
+func exitError(ctx context.Context, stderr io.Writer, err error) error {
+	switch err := err.(type) {
+	case *exec.ExitError:
+		if status, ok := err.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return interp.NewExitStatus(uint8(exitSignalOffset + int(status.Signal()))) //nolint:gosec // signal numbers are small
+		}
+		return interp.NewExitStatus(uint8(err.ExitCode())) //nolint:gosec // exit codes are truncated like a shell does
+	case *exec.Error:
+		// The command did not start
+		fmt.Fprintf(stderr, "%v\n", err)
+		return interp.NewExitStatus(exitCommandNotFound)
+	default:
+		return err
+	}
+}
+
+// execEnv converts the interpreter's exported variables to the os/exec format.
+func execEnv(env expand.Environ) []string {
+	var list []string
+	env.Each(func(name string, vr expand.Variable) bool {
+		if vr.Exported && vr.IsSet() && vr.Kind == expand.String {
+			list = append(list, name+"="+vr.String())
+		}
+		return true
+	})
+	return list
+}
+
+// remoteRunFunc runs args with env in a container, as runContainer and runInPod do.
+type remoteRunFunc func(ctx context.Context, hc interp.HandlerContext, args, env []string) error
+
+// execRemote runs an external command with the docker or kubernetes execution backend.
+func (r *SafeRunner) execRemote(ctx context.Context, hc interp.HandlerContext, args []string, run remoteRunFunc) error {
+	ec := &ExecContext{Command: args[0], Args: args[1:], WorkDir: hc.Dir, Env: execEnv(hc.Env)}
+	if err := r.runBeforeExec(ctx, ec); err != nil {
+		return err
+	}
+
+	configured, err := r.configuredEnv(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err = run(ctx, hc, args, r.egressEnv(args[0], slices.Concat(ec.Env, configured)))
+	metrics := CommandMetrics{Command: args[0], Args: args[1:], WallTime: time.Since(start)}
+	if status, ok := interp.IsExitStatus(err); ok {
+		metrics.ExitCode = int(status)
+	}
+	// Resource usage is not reported for containers, only the wall time and exit code
+	if err == nil || metrics.ExitCode != 0 {
+		r.recordMetrics(metrics)
+	}
+
+	r.recordExecution(ctx, args, hc.Dir, err, time.Since(start))
+	r.runAfterExec(ctx, ec, metrics, err)
+	return err
+}
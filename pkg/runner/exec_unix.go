@@ -0,0 +1,87 @@
+//go:build !windows
+
+package runner
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"time"
+
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
+)
+
+// lookPath resolves an executable using the interpreter's PATH.
+func lookPath(_ context.Context, dir string, env expand.Environ, name string) (string, error) {
+	return interp.LookPathDir(dir, env, name)
+}
+
+// process is a started command.
+type process struct {
+	cmd *exec.Cmd
+}
+
+// startProcess starts the command in a process group of its own, so that signals reach every
+// process it spawned. Commands in a pseudo-terminal lead a session, and with it a group, already.
+func startProcess(cmd *exec.Cmd) (*process, error) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &process{cmd: cmd}, nil
+}
+
+// terminate interrupts the process group and kills it if it has not exited after timeout.
+func (p *process) terminate(timeout time.Duration) {
+	p.signal(os.Interrupt)
+	time.Sleep(timeout)
+	p.signal(os.Kill)
+}
+
+// signal sends sig to the process group of the process.
+func (p *process) signal(sig os.Signal) {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return
+	}
+	if err := syscall.Kill(-p.cmd.Process.Pid, s); err != nil {
+		// The group may be gone while the process has not been waited for yet
+		_ = p.cmd.Process.Signal(sig)
+	}
+}
+
+// forwardedSignals are the signals ForwardSignals passes on to commands.
+var forwardedSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGWINCH}
+
+// isResizeSignal reports whether sig tells of a changed terminal size rather than asking the
+// command to stop.
+func isResizeSignal(sig os.Signal) bool {
+	return sig == syscall.SIGWINCH
+}
+
+// release frees resources held for the process after it has exited.
+func (p *process) release() {}
+
+// maxRSS returns the peak resident set size of a finished process in bytes.
+func maxRSS(state *os.ProcessState) int64 {
+	usage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0
+	}
+	// Darwin reports ru_maxrss in bytes, other systems in kilobytes
+	if runtime.GOOS == "darwin" {
+		return usage.Maxrss
+	}
+	return usage.Maxrss * bytesPerKiB
+}
+
+// setControllingTerminal starts cmd in a new session whose controlling terminal is its stdin,
+// so that it receives the terminal's signals, such as SIGINT for Ctrl-C and SIGWINCH.
+func setControllingTerminal(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+}
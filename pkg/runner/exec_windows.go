@@ -0,0 +1,124 @@
+//go:build windows
+
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
+)
+
+// lookPath resolves bare command names with where.exe, which honors PATHEXT the same
+// way cmd.exe does. Names containing a path are resolved by the interpreter.
+func lookPath(ctx context.Context, dir string, env expand.Environ, name string) (string, error) {
+	if strings.ContainsAny(name, `\/:`) {
+		return interp.LookPathDir(dir, env, name)
+	}
+
+	where := exec.CommandContext(ctx, "where.exe", name)
+	where.Dir = dir
+	where.Env = execEnv(env)
+	out, err := where.Output()
+	if err != nil {
+		return interp.LookPathDir(dir, env, name)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	if scanner.Scan() {
+		if path := strings.TrimSpace(scanner.Text()); path != "" {
+			return path, nil
+		}
+	}
+	return interp.LookPathDir(dir, env, name)
+}
+
+// process is a started command assigned to a job object, so that terminating
+// it also terminates every process it spawned.
+type process struct {
+	cmd *exec.Cmd
+	job windows.Handle
+}
+
+// startProcess starts the command inside a new job object.
+func startProcess(cmd *exec.Cmd) (*process, error) {
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Closing the last handle to the job kills any processes still running in it
+	info := windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION{}
+	info.BasicLimitInformation.LimitFlags = windows.JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE
+	if _, err := windows.SetInformationJobObject(job, windows.JobObjectExtendedLimitInformation,
+		uintptr(unsafe.Pointer(&info)), uint32(unsafe.Sizeof(info))); err != nil {
+		_ = windows.CloseHandle(job)
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		_ = windows.CloseHandle(job)
+		return nil, err
+	}
+
+	handle, err := windows.OpenProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE, false, uint32(cmd.Process.Pid))
+	if err == nil {
+		err = windows.AssignProcessToJobObject(job, handle)
+		_ = windows.CloseHandle(handle)
+	}
+	if err != nil {
+		// Fall back to killing only the direct child
+		_ = windows.CloseHandle(job)
+		return &process{cmd: cmd}, nil
+	}
+
+	return &process{cmd: cmd, job: job}, nil
+}
+
+// terminate kills the whole process tree. Windows has no equivalent of SIGINT for
+// arbitrary processes, so the timeout is not used.
+func (p *process) terminate(_ time.Duration) {
+	if p.job != 0 {
+		_ = windows.TerminateJobObject(p.job, 1)
+		return
+	}
+	_ = p.cmd.Process.Kill()
+}
+
+// signal terminates the process tree, as Windows cannot deliver signals to arbitrary processes.
+func (p *process) signal(_ os.Signal) {
+	p.terminate(0)
+}
+
+// forwardedSignals are the signals ForwardSignals passes on to commands; Ctrl-C arrives as
+// os.Interrupt.
+var forwardedSignals = []os.Signal{os.Interrupt}
+
+// isResizeSignal reports whether sig tells of a changed terminal size, which it never does on
+// Windows.
+func isResizeSignal(_ os.Signal) bool {
+	return false
+}
+
+// release closes the job object, killing any processes left behind by the command.
+func (p *process) release() {
+	if p.job != 0 {
+		_ = windows.CloseHandle(p.job)
+	}
+}
+
+// maxRSS is not reported by os.ProcessState on Windows.
+func maxRSS(_ *os.ProcessState) int64 {
+	return 0
+}
+
+// setControllingTerminal does nothing, as pseudo-terminals are not supported on Windows.
+func setControllingTerminal(_ *exec.Cmd) {}
@@ -0,0 +1,98 @@
+package runner
+
+import (
+	"context"
+	"errors"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// Exit codes reserved for executions that fail outside the script's own commands. They
+// follow the conventions of shells and timeout(1), so a CLI exiting with them reports
+// the failure the way a shell would.
+const (
+	// ExitFailure is used for errors without a more specific code.
+	ExitFailure = 1
+	// ExitTimeout is used when the execution exceeded its time or idle limit.
+	ExitTimeout = 124
+	// ExitInvalid is used when the request was invalid: a script that does not parse,
+	// execution options outside the policy, or rejected template parameters.
+	ExitInvalid = 125
+	// ExitDenied is used when the policy denied a command, directory, or script.
+	ExitDenied = 126
+)
+
+// ExitError is the error of an execution that did not succeed. RunResult.Err is
+// always an *ExitError when it is not nil.
+type ExitError struct {
+	// Code is the exit status of the failing command or one of the reserved exit codes.
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// ExitCode returns the status a CLI running the script should exit with.
+func (e *ExitError) ExitCode() int {
+	return e.Code
+}
+
+// ExitCode returns the exit code for the error of a RunResult: 0 for nil, the code of
+// an *ExitError, the status of an interpreter exit status, and ExitFailure otherwise.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+	if status, ok := interp.IsExitStatus(err); ok {
+		return int(status)
+	}
+	return ExitFailure
+}
+
+// isExitStatus reports whether err is the nonzero exit status of a command.
+func isExitStatus(err error) bool {
+	_, ok := interp.IsExitStatus(err)
+	return ok
+}
+
+// deniedError returns the error for a command or script rejected by the policy.
+func deniedError(message string) error {
+	return &ExitError{Code: ExitDenied, Err: errors.New(message)}
+}
+
+// invalidError marks err as a rejected request.
+func invalidError(err error) error {
+	return &ExitError{Code: ExitInvalid, Err: err}
+}
+
+// asExitError wraps an execution error in an *ExitError with the code it maps to.
+func asExitError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return err
+	}
+
+	code := ExitFailure
+	var sigErr *SignalError
+	if errors.As(err, &sigErr) {
+		code = sigErr.exitCode()
+	} else if status, ok := interp.IsExitStatus(err); ok {
+		code = int(status)
+	} else if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrIdleTimeout) {
+		code = ExitTimeout
+	}
+	return &ExitError{Code: code, Err: err}
+}
@@ -0,0 +1,45 @@
+package runner
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestExitCode(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, _ := newOptionsTestRunner(t, tmpDir)
+
+	tests := []struct {
+		name   string
+		script string
+		opts   []ExecOption
+		want   int
+	}{
+		{name: "success", script: "echo ok", want: 0},
+		{name: "command exit status", script: "ls missing", want: 2},
+		{name: "denied command", script: "rm -rf .", want: ExitDenied},
+		{name: "denied after earlier commands", script: "echo ok; rm -rf .", want: ExitDenied},
+		{name: "denied directory", script: "ls", opts: []ExecOption{WithWorkdir(t.TempDir())}, want: ExitDenied},
+		{name: "parse error", script: "echo 'unterminated", want: ExitInvalid},
+		{name: "option outside the policy", script: "echo ok", opts: []ExecOption{WithEnv("PATH=/tmp")}, want: ExitInvalid},
+		{name: "timeout", script: "sleep 5", opts: []ExecOption{WithTimeout(100 * time.Millisecond)}, want: ExitTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := r.RunScriptCapture(t.Context(), tt.script, tt.opts...)
+			assert.Equal(t, tt.want, ExitCode(result.Err))
+			if tt.want == 0 {
+				assert.NoError(t, result.Err)
+				return
+			}
+
+			var exitErr *ExitError
+			assert.True(t, errors.As(result.Err, &exitErr))
+			assert.Equal(t, tt.want, exitErr.ExitCode())
+		})
+	}
+}
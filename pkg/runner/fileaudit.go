@@ -0,0 +1,20 @@
+package runner
+
+import (
+	"github.com/shimizu1995/secure-shell-server/pkg/fileaudit"
+)
+
+// auditFiles records the state of the allowed directories before an execution and returns a
+// function that attaches the files the execution changed to its result.
+func (r *SafeRunner) auditFiles() func(result *RunResult) {
+	scanner := fileaudit.New(r.config.FileAudit, r.config.Directories())
+	before := scanner.Scan()
+	return func(result *RunResult) {
+		after := scanner.Scan()
+		result.FileChanges = fileaudit.Diff(before, after)
+		result.FileChangesIncomplete = before.Truncated || after.Truncated
+		for _, c := range result.FileChanges {
+			r.logger.LogInfof("Execution %s %s", c.Kind, c.Path)
+		}
+	}
+}
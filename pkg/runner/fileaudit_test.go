@@ -0,0 +1,35 @@
+package runner
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/fileaudit"
+)
+
+func TestRunCommand_FileAudit(t *testing.T) {
+	tmpDir, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NoError(t, err)
+	r, _ := newOptionsTestRunner(t, tmpDir)
+
+	// Disabled by default
+	result := r.RunCommand(t.Context(), "echo hello > out.txt", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, 0, len(result.FileChanges))
+
+	r.config.FileAudit.Enabled = true
+	result = r.RunCommand(t.Context(), "echo changed >> out.txt && echo new > new.txt", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, []fileaudit.Change{
+		{Path: filepath.Join(tmpDir, "new.txt"), Kind: fileaudit.Created},
+		{Path: filepath.Join(tmpDir, "out.txt"), Kind: fileaudit.Modified},
+	}, result.FileChanges)
+	assert.False(t, result.FileChangesIncomplete)
+
+	// Denied scripts change nothing and are not scanned
+	result = r.RunCommand(t.Context(), "rm out.txt", tmpDir)
+	assert.Error(t, result.Err)
+	assert.Equal(t, 0, len(result.FileChanges))
+}
@@ -0,0 +1,48 @@
+package runner
+
+import (
+	"context"
+	"time"
+
+	"mvdan.cc/sh/v3/interp"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/history"
+)
+
+// SetHistory records every executed or denied command in h, attributed to caller.
+func (r *SafeRunner) SetHistory(h *history.History, caller string) {
+	r.history = h
+	r.caller = caller
+}
+
+// recordHistory adds a command to the execution history, if one is set, and sends it to the
+// auditor. Failures are logged rather than returned so that an unavailable database does not
+// stop execution.
+func (r *SafeRunner) recordHistory(ctx context.Context, e history.Entry, args []string) {
+	e.Message = r.redactor.Redact(e.Message)
+	r.sendAudit(e, args)
+	if r.history == nil {
+		return
+	}
+	e.Caller = r.caller
+	e.ArgsHash = history.HashArgs(args)
+	// Record even if the command was canceled
+	if err := r.history.Record(context.WithoutCancel(ctx), e); err != nil {
+		r.logger.LogErrorf("%v", err)
+	}
+}
+
+// recordExecution adds a command that ran to the execution history. err is the error
+// returned to the interpreter; errors other than exit statuses are recorded as exit code -1.
+func (r *SafeRunner) recordExecution(ctx context.Context, args []string, workDir string, err error, d time.Duration) {
+	e := history.Entry{Command: args[0], WorkDir: workDir, Decision: history.DecisionAllowed, Duration: d}
+	if err != nil {
+		if status, ok := interp.IsExitStatus(err); ok {
+			e.ExitCode = int(status)
+		} else {
+			e.ExitCode = -1
+			e.Message = err.Error()
+		}
+	}
+	r.recordHistory(ctx, e, args[1:])
+}
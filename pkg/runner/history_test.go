@@ -0,0 +1,47 @@
+package runner
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/history"
+)
+
+func TestSafeRunner_History(t *testing.T) {
+	tmpDir := t.TempDir()
+	h, err := history.Open(filepath.Join(t.TempDir(), "history.db"))
+	assert.NoError(t, err)
+	defer h.Close()
+
+	r := newHintTestRunner(t, tmpDir)
+	r.config.InProcessCommands = true
+	r.config.AllowCommands = append(r.config.AllowCommands, config.AllowCommand{Command: "sleep"})
+	r.SetHistory(h, "alice")
+
+	result := r.RunCommand(t.Context(), "sleep 0.01; cat missing.txt; rm -rf /", tmpDir)
+	assert.Error(t, result.Err)
+
+	entries, err := h.Search(t.Context(), history.Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(entries))
+
+	// Newest first
+	denied, inProcess, external := entries[0], entries[1], entries[2]
+	assert.Equal(t, "rm", denied.Command)
+	assert.Equal(t, history.DecisionDenied, denied.Decision)
+	assert.Equal(t, history.HashArgs([]string{"-rf", "/"}), denied.ArgsHash)
+	assert.Contains(t, denied.Message, "not permitted")
+
+	assert.Equal(t, "cat", inProcess.Command)
+	assert.Equal(t, history.DecisionAllowed, inProcess.Decision)
+	assert.Equal(t, 1, inProcess.ExitCode)
+
+	assert.Equal(t, "sleep", external.Command)
+	assert.Equal(t, "alice", external.Caller)
+	assert.Equal(t, tmpDir, external.WorkDir)
+	assert.Equal(t, 0, external.ExitCode)
+	assert.True(t, external.Duration > 0)
+}
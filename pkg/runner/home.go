@@ -0,0 +1,57 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// homePattern is the name pattern of per-execution home directories.
+const homePattern = "secure-shell-home-"
+
+// xdgDirs are the XDG base directory variables pointed into an isolated home, with the
+// directories the specification gives them by default.
+var xdgDirs = []struct{ env, dir string }{
+	{"XDG_CONFIG_HOME", ".config"},
+	{"XDG_CACHE_HOME", ".cache"},
+	{"XDG_DATA_HOME", ".local/share"},
+	{"XDG_STATE_HOME", ".local/state"},
+}
+
+// setupHome creates the throwaway home directory of an execution, points HOME and the XDG
+// base directories in settings at it, and allows it for the duration of the execution. The
+// returned function revokes access and removes the directory.
+func (r *SafeRunner) setupHome(settings *execSettings) (func(), error) {
+	home, err := os.MkdirTemp(r.config.HomeIsolation.Dir, homePattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create home directory: %w", err)
+	}
+	// Resolve symlinks, e.g. /tmp on macOS, so that the path matches what commands see
+	if resolved, err := filepath.EvalSymlinks(home); err == nil {
+		home = resolved
+	}
+
+	env := append(slices.Clone(settings.env), "HOME="+home)
+	for _, xdg := range xdgDirs {
+		dir := filepath.Join(home, xdg.dir)
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			_ = os.RemoveAll(home)
+			return nil, fmt.Errorf("failed to create home directory: %w", err)
+		}
+		env = append(env, xdg.env+"="+dir)
+	}
+	settings.env = env
+
+	config, validator := r.config, r.validator
+	homeConfig := *config
+	homeConfig.AllowedDirectories = append(slices.Clone(config.AllowedDirectories), home)
+	r.config, r.validator = &homeConfig, validator.WithAllowedDirectories(home)
+
+	return func() {
+		r.config, r.validator = config, validator
+		if err := os.RemoveAll(home); err != nil {
+			r.logger.LogErrorf("Failed to remove home directory %s: %v", home, err)
+		}
+	}, nil
+}
@@ -0,0 +1,50 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+func TestHomeIsolation_HomeIsCreatedAndRemoved(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, stdout := newOptionsTestRunner(t, tmpDir)
+	r.config.HomeIsolation = config.HomeIsolationConfig{Enabled: true, Dir: t.TempDir()}
+
+	script := `printenv HOME XDG_CONFIG_HOME XDG_CACHE_HOME && echo token > "$XDG_CONFIG_HOME/state" && cat "$HOME/.config/state"`
+	result := r.RunCommand(t.Context(), script, tmpDir)
+	assert.NoError(t, result.Err)
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	assert.Equal(t, 4, len(lines))
+	home := lines[0]
+	assert.True(t, strings.HasPrefix(home, r.config.HomeIsolation.Dir))
+	assert.Equal(t, filepath.Join(home, ".config"), lines[1])
+	assert.Equal(t, filepath.Join(home, ".cache"), lines[2])
+	assert.Equal(t, "token", lines[3])
+
+	// The home directory is removed and access to it revoked after the execution
+	_, err := os.Stat(home)
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, []string{tmpDir}, r.config.AllowedDirectories)
+
+	// Nothing written by one execution is seen by the next
+	stdout.Reset()
+	result = r.RunCommand(t.Context(), `printenv HOME; cat "$XDG_CONFIG_HOME/state"`, tmpDir)
+	assert.Error(t, result.Err)
+	assert.NotEqual(t, home, strings.TrimSpace(stdout.String()))
+}
+
+func TestHomeIsolation_OverridesEnv(t *testing.T) {
+	r, stdout := newOptionsTestRunner(t, t.TempDir())
+	r.config.HomeIsolation = config.HomeIsolationConfig{Enabled: true, Dir: t.TempDir()}
+
+	result := r.Run(t.Context(), []string{"printenv", "HOME"}, WithEnv("HOME=/root"))
+	assert.NoError(t, result.Err)
+	assert.True(t, strings.HasPrefix(stdout.String(), r.config.HomeIsolation.Dir))
+}
@@ -0,0 +1,94 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/history"
+)
+
+// ExecContext describes a command passed to hooks.
+type ExecContext struct {
+	// Command is the command as invoked, e.g. "ls" or "/usr/bin/find".
+	Command string
+	Args    []string
+	// WorkDir is the interpreter's current directory when the command runs.
+	WorkDir string
+	// Env is the environment of an external command in "NAME=value" form. BeforeExec hooks
+	// may modify it; it is nil for commands denied before reaching the exec stage.
+	Env []string
+}
+
+// BeforeExecFunc is called before an external command starts. Returning an error vetoes
+// the command: it is not run, the error is reported like a policy denial, and OnDeny hooks are called.
+type BeforeExecFunc func(ctx context.Context, ec *ExecContext) error
+
+// AfterExecFunc is called after an external command finishes, with its resource usage and
+// the error returned to the interpreter (nil on a zero exit status).
+type AfterExecFunc func(ctx context.Context, ec *ExecContext, metrics CommandMetrics, err error)
+
+// OnDenyFunc is called when a command is denied by the validator or vetoed by a BeforeExec hook.
+type OnDenyFunc func(ctx context.Context, ec *ExecContext, message string)
+
+// hooks holds the functions registered on a SafeRunner, called in registration order.
+type hooks struct {
+	beforeExec []BeforeExecFunc
+	afterExec  []AfterExecFunc
+	onDeny     []OnDenyFunc
+}
+
+// BeforeExec registers a hook called before each external command starts.
+// Hooks must be registered before RunCommand is called.
+func (r *SafeRunner) BeforeExec(fn BeforeExecFunc) {
+	r.hooks.beforeExec = append(r.hooks.beforeExec, fn)
+}
+
+// AfterExec registers a hook called after each external command finishes.
+// Hooks must be registered before RunCommand is called.
+func (r *SafeRunner) AfterExec(fn AfterExecFunc) {
+	r.hooks.afterExec = append(r.hooks.afterExec, fn)
+}
+
+// OnDeny registers a hook called whenever a command is denied.
+// Hooks must be registered before RunCommand is called.
+func (r *SafeRunner) OnDeny(fn OnDenyFunc) {
+	r.hooks.onDeny = append(r.hooks.onDeny, fn)
+}
+
+// runBeforeExec calls BeforeExec hooks until one vetoes the command.
+func (r *SafeRunner) runBeforeExec(ctx context.Context, ec *ExecContext) error {
+	for _, fn := range r.hooks.beforeExec {
+		if err := fn(ctx, ec); err != nil {
+			message := fmt.Sprintf("command %q vetoed: %v", ec.Command, err)
+			r.logger.LogCommandAttempt(ec.Command, ec.Args, false)
+			r.recordHistory(ctx, history.Entry{Command: ec.Command, WorkDir: ec.WorkDir, Decision: history.DecisionDenied, Message: message}, ec.Args)
+			r.runOnDeny(ctx, ec, message)
+			r.publishDenied(ec.Command, ec.Args, ec.WorkDir, message)
+			return fmt.Errorf("%s", message)
+		}
+	}
+	return nil
+}
+
+// runAfterExec calls every AfterExec hook.
+func (r *SafeRunner) runAfterExec(ctx context.Context, ec *ExecContext, metrics CommandMetrics, err error) {
+	for _, fn := range r.hooks.afterExec {
+		fn(ctx, ec, metrics, err)
+	}
+}
+
+// runOnDeny calls every OnDeny hook.
+func (r *SafeRunner) runOnDeny(ctx context.Context, ec *ExecContext, message string) {
+	for _, fn := range r.hooks.onDeny {
+		fn(ctx, ec, message)
+	}
+}
+
+// denied logs a command rejected by the validator and notifies OnDeny hooks and subscribers.
+func (r *SafeRunner) denied(ctx context.Context, cmd string, args []string, workDir, message string) {
+	r.logger.LogCommandAttempt(cmd, args, false)
+	r.recordHistory(ctx, history.Entry{Command: cmd, WorkDir: workDir, Decision: history.DecisionDenied, Message: message}, args)
+	r.runOnDeny(ctx, &ExecContext{Command: cmd, Args: args, WorkDir: workDir}, message)
+	r.publishDenied(cmd, args, workDir, message)
+	r.raiseAlert(ctx, cmd, args, workDir, message)
+}
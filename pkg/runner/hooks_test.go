@@ -0,0 +1,89 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+func TestHooks_BeforeAndAfterExec(t *testing.T) {
+	tmpDir := t.TempDir()
+	r := newHintTestRunner(t, tmpDir)
+	var stdout bytes.Buffer
+	r.SetOutputs(&stdout, &bytes.Buffer{})
+
+	var before, after []string
+	r.BeforeExec(func(_ context.Context, ec *ExecContext) error {
+		before = append(before, ec.Command)
+		assert.Equal(t, tmpDir, ec.WorkDir)
+		return nil
+	})
+	r.AfterExec(func(_ context.Context, ec *ExecContext, metrics CommandMetrics, err error) {
+		after = append(after, ec.Command)
+		assert.Equal(t, "ls", metrics.Command)
+		assert.NoError(t, err)
+	})
+
+	// echo is a builtin and does not reach the exec hooks
+	result := r.RunCommand(t.Context(), "echo hi && ls", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, []string{"ls"}, before)
+	assert.Equal(t, []string{"ls"}, after)
+}
+
+func TestHooks_BeforeExecMutatesEnv(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories: []string{tmpDir},
+		AllowCommands:      []config.AllowCommand{{Command: "env"}},
+		DenyCommands:       []config.DenyCommand{},
+	}
+	log := logger.New()
+	r := New(cfg, validator.New(cfg, log), log)
+	var stdout bytes.Buffer
+	r.SetOutputs(&stdout, &bytes.Buffer{})
+
+	r.BeforeExec(func(_ context.Context, ec *ExecContext) error {
+		ec.Env = append(ec.Env, "HOOK_VALUE=injected")
+		return nil
+	})
+
+	result := r.RunCommand(t.Context(), "env", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.True(t, strings.Contains(stdout.String(), "HOOK_VALUE=injected"))
+}
+
+func TestHooks_VetoCallsOnDeny(t *testing.T) {
+	tmpDir := t.TempDir()
+	r := newHintTestRunner(t, tmpDir)
+
+	r.BeforeExec(func(_ context.Context, ec *ExecContext) error {
+		if ec.Command == "ls" {
+			return errors.New("listing is disabled")
+		}
+		return nil
+	})
+	var denials []string
+	r.OnDeny(func(_ context.Context, ec *ExecContext, message string) {
+		denials = append(denials, ec.Command+": "+message)
+	})
+
+	result := r.RunCommand(t.Context(), "ls", tmpDir)
+	assert.Error(t, result.Err)
+	assert.Contains(t, result.Err.Error(), "listing is disabled")
+	assert.Equal(t, 1, len(denials))
+
+	// Validator denials are reported too
+	result = r.RunCommand(t.Context(), "rm file", tmpDir)
+	assert.Error(t, result.Err)
+	assert.Equal(t, 2, len(denials))
+	assert.True(t, strings.HasPrefix(denials[1], "rm: "))
+}
@@ -0,0 +1,31 @@
+package runner
+
+import (
+	"context"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
+)
+
+// SetIdentity attributes the runner's executions to id: log entries, blocked commands, history,
+// alerts, and approval requests name the caller, and history and frozen sessions are keyed by
+// id.Key(). Commands are validated and limited by the policy of the caller, which includes the
+// overlays of the user and its roles (see config.ShellCommandConfig.ForUser). An identity carried
+// by the context of an execution (see identity.WithIdentity) is applied automatically.
+func (r *SafeRunner) SetIdentity(id identity.Identity) {
+	r.identity = id
+	r.caller = id.Key()
+	r.logger = r.logger.With(id.String())
+	if cfg := r.policy.ForUser(r.caller); cfg != r.config {
+		r.config = cfg
+		r.validator = r.validator.WithConfig(cfg)
+	}
+	r.validator = r.validator.WithIdentity(id)
+}
+
+// identify applies the identity carried by ctx, if any. It is called before the settings of
+// an execution are resolved, so that they are bounded by the caller's limits.
+func (r *SafeRunner) identify(ctx context.Context) {
+	if id, ok := identity.FromContext(ctx); ok {
+		r.SetIdentity(id)
+	}
+}
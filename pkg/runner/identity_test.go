@@ -0,0 +1,96 @@
+package runner
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/audit"
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/history"
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+func TestSafeRunner_IdentityFromContext(t *testing.T) {
+	tmpDir := t.TempDir()
+	blockLog := filepath.Join(t.TempDir(), "blocked.log")
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories:  []string{tmpDir},
+		AllowCommands:       []config.AllowCommand{{Command: "ls"}},
+		DefaultErrorMessage: "Command not allowed",
+		BlockLogPath:        blockLog,
+	}
+	var logs bytes.Buffer
+	log := logger.NewWithWriter(&logs)
+	r := New(cfg, validator.New(cfg, log), log)
+	r.SetOutputs(&bytes.Buffer{}, &bytes.Buffer{})
+	h, err := history.Open(filepath.Join(t.TempDir(), "history.db"))
+	assert.NoError(t, err)
+	defer h.Close()
+	r.SetHistory(h, "")
+
+	id := identity.Identity{User: "alice", Agent: "claude", Session: "s1"}
+	ctx := identity.WithIdentity(t.Context(), id)
+	result := r.RunCommand(ctx, "ls; rm x", tmpDir)
+	assert.Error(t, result.Err)
+
+	entries, err := h.Search(t.Context(), history.Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(entries))
+	for _, e := range entries {
+		assert.Equal(t, "alice", e.Caller)
+	}
+
+	assert.Contains(t, logs.String(), "[ALLOWED] [user=alice agent=claude session=s1] Command: ls")
+	assert.Contains(t, logs.String(), "[BLOCKED] [user=alice agent=claude session=s1] Command: rm")
+
+	records, err := audit.NewReader(blockLog).Records(audit.Filter{Session: "s1"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, identity.Identity{User: "alice", Agent: "claude"}, records[0].Identity)
+	assert.Equal(t, "rm", records[0].Command)
+	assert.Equal(t, []string{"x"}, records[0].Argv)
+	assert.Equal(t, tmpDir, records[0].Cwd)
+}
+
+func TestSafeRunner_PolicyOverlay(t *testing.T) {
+	tmpDir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "notes.txt"), []byte("hello\n"), 0o600))
+	maxOutput := 1 << 20
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories:  []string{tmpDir},
+		AllowCommands:       []config.AllowCommand{{Command: "ls"}},
+		DefaultErrorMessage: "Command not allowed",
+		MaxOutputSize:       1024,
+		Roles: map[string]config.PolicyOverlay{
+			"developer": {AllowCommands: []config.AllowCommand{{Command: "cat"}}, MaxOutputSize: &maxOutput},
+		},
+		Users: map[string]config.PolicyOverlay{"alice": {Roles: []string{"developer"}}},
+	}
+	run := func(user string, opts ...ExecOption) (RunResult, string) {
+		var stdout bytes.Buffer
+		log := logger.NewWithWriter(&bytes.Buffer{})
+		r := New(cfg, validator.New(cfg, log), log)
+		r.SetOutputs(&stdout, &bytes.Buffer{})
+		ctx := identity.WithIdentity(t.Context(), identity.Identity{User: user})
+		result := r.Run(ctx, []string{"cat", "notes.txt"}, append(opts, WithWorkdir(tmpDir))...)
+		return result, stdout.String()
+	}
+
+	result, out := run("alice", WithMaxOutput(4096))
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "hello\n", out)
+
+	result, _ = run("bob")
+	assert.Error(t, result.Err)
+	assert.Contains(t, result.Err.Error(), "Command not allowed")
+
+	// Limits are bounded by the base policy for callers without an overlay
+	result, _ = run("bob", WithMaxOutput(4096))
+	assert.IsError(t, result.Err, ErrOptionNotPermitted)
+}
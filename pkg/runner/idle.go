@@ -0,0 +1,93 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrIdleTimeout is returned when a command is killed for producing no output within idleTimeout.
+var ErrIdleTimeout = errors.New("command produced no output within the idle timeout")
+
+// idleWatchdog cancels a run when no output is written for a period of time.
+// Pipes between commands in a pipeline do not count; only output returned to the caller does.
+type idleWatchdog struct {
+	timeout time.Duration
+
+	mu     sync.Mutex
+	timer  *time.Timer
+	paused int
+}
+
+// newIdleWatchdog returns a context that is canceled with ErrIdleTimeout when the
+// returned watchdog is not touched for timeout. The stop function releases its resources.
+func newIdleWatchdog(ctx context.Context, timeout time.Duration) (context.Context, *idleWatchdog, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	w := &idleWatchdog{timeout: timeout}
+	w.timer = time.AfterFunc(timeout, func() { cancel(ErrIdleTimeout) })
+	return ctx, w, func() {
+		w.mu.Lock()
+		w.timer.Stop()
+		w.mu.Unlock()
+		cancel(nil)
+	}
+}
+
+// touch restarts the idle period.
+func (w *idleWatchdog) touch() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.paused == 0 {
+		w.timer.Reset(w.timeout)
+	}
+}
+
+// pause stops the idle period until resume is called, e.g. while waiting for an approver.
+func (w *idleWatchdog) pause() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.paused++
+	w.timer.Stop()
+}
+
+// resume restarts the idle period after pause.
+func (w *idleWatchdog) resume() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.paused--
+	if w.paused == 0 {
+		w.timer.Reset(w.timeout)
+	}
+}
+
+// writer returns a writer that touches the watchdog on every write to out.
+func (w *idleWatchdog) writer(out io.Writer) io.Writer {
+	if w == nil {
+		return out
+	}
+	return &activityWriter{out: out, watchdog: w}
+}
+
+// activityWriter passes writes through and records them as activity.
+type activityWriter struct {
+	out      io.Writer
+	watchdog *idleWatchdog
+}
+
+func (a *activityWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		a.watchdog.touch()
+	}
+	return a.out.Write(p)
+}
@@ -0,0 +1,31 @@
+package runner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestSafeRunner_IdleTimeout(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, stdout := newOptionsTestRunner(t, tmpDir)
+	r.config.IdleTimeout = 1
+
+	start := time.Now()
+	result := r.RunCommand(t.Context(), "echo started; sleep 5; echo finished", tmpDir)
+	assert.IsError(t, result.Err, ErrIdleTimeout)
+	assert.True(t, time.Since(start) < 4*time.Second)
+	assert.Equal(t, "started\n", stdout.String())
+}
+
+func TestSafeRunner_IdleTimeoutResetByOutput(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, stdout := newOptionsTestRunner(t, tmpDir)
+	r.config.IdleTimeout = 1
+
+	// Runs longer than the idle timeout, but never goes a second without output
+	result := r.RunCommand(t.Context(), "echo a; sleep 0.6; echo b; sleep 0.6; echo c", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "a\nb\nc\n", stdout.String())
+}
@@ -0,0 +1,563 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"mvdan.cc/sh/v3/interp"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+// Exit statuses of the in-process commands, matching coreutils.
+const (
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+// defaultLineCount is the number of lines printed by head and tail without -n.
+const defaultLineCount = 10
+
+// File errors, worded as coreutils prints them.
+var (
+	errNoSuchFile       = errors.New("No such file or directory") //nolint:stylecheck // matches coreutils output
+	errPermissionDenied = errors.New("Permission denied")         //nolint:stylecheck // matches coreutils output
+	errIsDirectory      = errors.New("Is a directory")            //nolint:stylecheck // matches coreutils output
+)
+
+// inProcessFunc implements a command without starting a process.
+type inProcessFunc func(r *SafeRunner, hc interp.HandlerContext, args []string) error
+
+// inProcessCommands are read-only commands implemented in-process when inProcessCommands is enabled.
+var inProcessCommands = map[string]inProcessFunc{
+	"cat":  (*SafeRunner).catCommand,
+	"ls":   (*SafeRunner).lsCommand,
+	"head": (*SafeRunner).headCommand,
+	"tail": (*SafeRunner).tailCommand,
+	"wc":   (*SafeRunner).wcCommand,
+}
+
+// lookupInProcess returns the in-process implementation of cmd, if enabled and available.
+func (r *SafeRunner) lookupInProcess(cmd string) (inProcessFunc, bool) {
+	if !r.config.InProcessCommands {
+		return nil, false
+	}
+	fn, ok := inProcessCommands[validator.NormalizeCommandName(cmd)]
+	return fn, ok
+}
+
+// commandError reports an error from an in-process command the way coreutils does.
+type commandError struct {
+	hc     interp.HandlerContext
+	name   string
+	failed bool
+}
+
+func (e *commandError) printf(format string, args ...any) {
+	fmt.Fprintf(e.hc.Stderr, "%s: %s\n", e.name, fmt.Sprintf(format, args...))
+	e.failed = true
+}
+
+// status returns the exit status for the command.
+func (e *commandError) status() error {
+	if e.failed {
+		return interp.NewExitStatus(exitFailure)
+	}
+	return nil
+}
+
+// usage reports an unsupported option and returns the usage exit status.
+func (e *commandError) usage(format string, args ...any) error {
+	fmt.Fprintf(e.hc.Stderr, "%s: %s (in-process implementation)\n", e.name, fmt.Sprintf(format, args...))
+	return interp.NewExitStatus(exitUsage)
+}
+
+// parseShortFlags splits arguments into single-letter flags and operands.
+// Flags that take a value are listed in valued; their value is the rest of the
+// argument or the next argument. Everything after "--" is an operand.
+func parseShortFlags(args []string, valued string) (map[rune]string, []string, error) {
+	flags := make(map[rune]string)
+	var operands []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			operands = append(operands, args[i+1:]...)
+			break
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			operands = append(operands, arg)
+			continue
+		}
+		letters := []rune(arg[1:])
+		for j, c := range letters {
+			if !strings.ContainsRune(valued, c) {
+				flags[c] = ""
+				continue
+			}
+			value := string(letters[j+1:])
+			if value == "" {
+				if i+1 >= len(args) {
+					return nil, nil, fmt.Errorf("option requires an argument -- '%c'", c)
+				}
+				i++
+				value = args[i]
+			}
+			flags[c] = value
+			break
+		}
+	}
+	return flags, operands, nil
+}
+
+// checkFlags returns the first flag not in allowed.
+func checkFlags(flags map[rune]string, allowed string) (rune, bool) {
+	for c := range flags {
+		if !strings.ContainsRune(allowed, c) {
+			return c, false
+		}
+	}
+	return 0, true
+}
+
+// openRead opens a file for reading after checking it against the allowed directories.
+// The name "-" reads standard input.
+func (r *SafeRunner) openRead(hc interp.HandlerContext, name string) (io.ReadCloser, error) {
+	if name == "-" {
+		if hc.Stdin == nil {
+			return io.NopCloser(strings.NewReader("")), nil
+		}
+		return io.NopCloser(hc.Stdin), nil
+	}
+	path, err := r.allowedPath(hc, name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, describeError(err)
+	}
+	if info.IsDir() {
+		return nil, errIsDirectory
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, describeError(err)
+	}
+	return f, nil
+}
+
+// allowedPath resolves name against the interpreter's directory and checks it against the allowed directories.
+func (r *SafeRunner) allowedPath(hc interp.HandlerContext, name string) (string, error) {
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(hc.Dir, path)
+	}
+	path = filepath.Clean(path)
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	if allowed, msg := r.validator.IsPathInAllowedDirectory(path, "/"); !allowed {
+		return "", fmt.Errorf("access denied: %s", msg)
+	}
+	return path, nil
+}
+
+// describeError returns the message coreutils prints for common file errors.
+func describeError(err error) error {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return errNoSuchFile
+	case errors.Is(err, fs.ErrPermission):
+		return errPermissionDenied
+	default:
+		return err
+	}
+}
+
+// forEachInput calls fn for each named file, or for standard input when there are none.
+func (r *SafeRunner) forEachInput(hc interp.HandlerContext, e *commandError, names []string, fn func(name string, rd io.Reader) error) {
+	if len(names) == 0 {
+		names = []string{"-"}
+	}
+	for _, name := range names {
+		f, err := r.openRead(hc, name)
+		if err != nil {
+			e.printf("%s: %v", name, err)
+			continue
+		}
+		if err := fn(name, f); err != nil {
+			e.printf("%s: %v", name, err)
+		}
+		_ = f.Close()
+	}
+}
+
+// catCommand implements cat [-n] [file...].
+func (r *SafeRunner) catCommand(hc interp.HandlerContext, args []string) error {
+	e := &commandError{hc: hc, name: "cat"}
+	flags, names, err := parseShortFlags(args[1:], "")
+	if err != nil {
+		return e.usage("%v", err)
+	}
+	if c, ok := checkFlags(flags, "n"); !ok {
+		return e.usage("unsupported option -- '%c'", c)
+	}
+	_, number := flags['n']
+
+	line := 0
+	r.forEachInput(hc, e, names, func(_ string, rd io.Reader) error {
+		if !number {
+			_, err := io.Copy(hc.Stdout, rd)
+			return err
+		}
+		br := bufio.NewReader(rd)
+		for {
+			text, err := br.ReadString('\n')
+			if text != "" {
+				line++
+				fmt.Fprintf(hc.Stdout, "%6d\t%s", line, text)
+			}
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	})
+	return e.status()
+}
+
+// lsCommand implements ls [-1aAlF] [path...].
+func (r *SafeRunner) lsCommand(hc interp.HandlerContext, args []string) error {
+	e := &commandError{hc: hc, name: "ls"}
+	flags, names, err := parseShortFlags(args[1:], "")
+	if err != nil {
+		return e.usage("%v", err)
+	}
+	if c, ok := checkFlags(flags, "1aAlF"); !ok {
+		return e.usage("unsupported option -- '%c'", c)
+	}
+	_, all := flags['a']
+	_, almostAll := flags['A']
+	_, long := flags['l']
+	_, classify := flags['F']
+
+	if len(names) == 0 {
+		names = []string{"."}
+	}
+
+	var files []fs.FileInfo
+	var dirs []string
+	for _, name := range names {
+		path, err := r.allowedPath(hc, name)
+		if err != nil {
+			e.printf("cannot access '%s': %v", name, err)
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			e.printf("cannot access '%s': %v", name, describeError(err))
+			continue
+		}
+		if info.IsDir() {
+			dirs = append(dirs, name)
+		} else {
+			files = append(files, renamedInfo{FileInfo: info, name: name})
+		}
+	}
+
+	for _, info := range files {
+		r.printEntry(hc, info, long, classify)
+	}
+	for i, name := range dirs {
+		if len(names) > 1 {
+			if len(files) > 0 || i > 0 {
+				fmt.Fprintln(hc.Stdout)
+			}
+			fmt.Fprintf(hc.Stdout, "%s:\n", name)
+		}
+		path, _ := r.allowedPath(hc, name)
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			e.printf("cannot open directory '%s': %v", name, describeError(err))
+			continue
+		}
+		var infos []fs.FileInfo
+		if all {
+			for _, special := range []string{".", ".."} {
+				if info, err := os.Stat(filepath.Join(path, special)); err == nil {
+					infos = append(infos, renamedInfo{FileInfo: info, name: special})
+				}
+			}
+		}
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), ".") && !all && !almostAll {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			infos = append(infos, info)
+		}
+		slices.SortStableFunc(infos, func(a, b fs.FileInfo) int { return strings.Compare(a.Name(), b.Name()) })
+		if long {
+			var total int64
+			for _, info := range infos {
+				total += (info.Size() + 1023) / 1024 //nolint:mnd // sizes are reported in 1K blocks
+			}
+			fmt.Fprintf(hc.Stdout, "total %d\n", total)
+		}
+		for _, info := range infos {
+			r.printEntry(hc, info, long, classify)
+		}
+	}
+	return e.status()
+}
+
+// renamedInfo reports a file under the name it was listed with.
+type renamedInfo struct {
+	fs.FileInfo
+	name string
+}
+
+// Name returns the name the file was listed with.
+func (i renamedInfo) Name() string { return i.name }
+
+// printEntry prints one ls entry.
+func (r *SafeRunner) printEntry(hc interp.HandlerContext, info fs.FileInfo, long, classify bool) {
+	name := info.Name()
+	if classify {
+		switch {
+		case info.IsDir():
+			name += "/"
+		case info.Mode()&fs.ModeSymlink != 0:
+			name += "@"
+		case info.Mode()&0o111 != 0:
+			name += "*"
+		}
+	}
+	if long {
+		fmt.Fprintf(hc.Stdout, "%s %8d %s %s\n",
+			info.Mode().String(), info.Size(), info.ModTime().Format("Jan _2 15:04"), name)
+		return
+	}
+	fmt.Fprintln(hc.Stdout, name)
+}
+
+// lineCountArgs extracts the -N shorthand accepted by head and tail, e.g. "head -5".
+func lineCountArgs(args []string) []string {
+	out := make([]string, 0, len(args))
+	for _, arg := range args {
+		if len(arg) > 1 && arg[0] == '-' && isDigits(arg[1:]) {
+			out = append(out, "-n", arg[1:])
+			continue
+		}
+		out = append(out, arg)
+	}
+	return out
+}
+
+func isDigits(s string) bool {
+	return s != "" && strings.IndexFunc(s, func(c rune) bool { return !unicode.IsDigit(c) }) < 0
+}
+
+// parseCount parses the value of -n or -c.
+func parseCount(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid number: '%s'", value)
+	}
+	return n, nil
+}
+
+// headCommand implements head [-n N] [-c N] [file...].
+func (r *SafeRunner) headCommand(hc interp.HandlerContext, args []string) error {
+	return r.headTail(hc, "head", args, func(w io.Writer, rd io.Reader, lines, byteCount int) error {
+		if byteCount >= 0 {
+			_, err := io.CopyN(w, rd, int64(byteCount))
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		br := bufio.NewReader(rd)
+		for range lines {
+			text, err := br.ReadString('\n')
+			if _, werr := io.WriteString(w, text); werr != nil {
+				return werr
+			}
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// tailCommand implements tail [-n N] [-c N] [file...].
+func (r *SafeRunner) tailCommand(hc interp.HandlerContext, args []string) error {
+	return r.headTail(hc, "tail", args, func(w io.Writer, rd io.Reader, lines, byteCount int) error {
+		data, err := io.ReadAll(rd)
+		if err != nil {
+			return err
+		}
+		if byteCount >= 0 {
+			_, err := w.Write(data[max(0, len(data)-byteCount):])
+			return err
+		}
+		start := len(data)
+		// A trailing newline ends the last line rather than starting a new one
+		end := len(data)
+		if end > 0 && data[end-1] == '\n' {
+			end--
+		}
+		for range lines {
+			idx := bytes.LastIndexByte(data[:end], '\n')
+			start = idx + 1
+			if idx < 0 {
+				break
+			}
+			end = idx
+		}
+		_, err = w.Write(data[start:])
+		return err
+	})
+}
+
+// headTail parses the options shared by head and tail and applies fn to each input.
+// fn receives byteCount < 0 when counting lines.
+func (r *SafeRunner) headTail(hc interp.HandlerContext, name string, args []string,
+	fn func(w io.Writer, rd io.Reader, lines, byteCount int) error,
+) error {
+	e := &commandError{hc: hc, name: name}
+	flags, names, err := parseShortFlags(lineCountArgs(args[1:]), "nc")
+	if err != nil {
+		return e.usage("%v", err)
+	}
+	if c, ok := checkFlags(flags, "ncqv"); !ok {
+		return e.usage("unsupported option -- '%c'", c)
+	}
+
+	lines, byteCount := defaultLineCount, -1
+	if value, ok := flags['n']; ok {
+		if lines, err = parseCount(value); err != nil {
+			return e.usage("%v", err)
+		}
+	}
+	if value, ok := flags['c']; ok {
+		if byteCount, err = parseCount(value); err != nil {
+			return e.usage("%v", err)
+		}
+	}
+	_, quiet := flags['q']
+	_, verbose := flags['v']
+	headers := verbose || (len(names) > 1 && !quiet)
+
+	first := true
+	r.forEachInput(hc, e, names, func(file string, rd io.Reader) error {
+		if headers {
+			if !first {
+				fmt.Fprintln(hc.Stdout)
+			}
+			if file == "-" {
+				file = "standard input"
+			}
+			fmt.Fprintf(hc.Stdout, "==> %s <==\n", file)
+		}
+		first = false
+		return fn(hc.Stdout, rd, lines, byteCount)
+	})
+	return e.status()
+}
+
+// wcCounts holds the counts printed by wc.
+type wcCounts struct {
+	lines, words, bytes int
+}
+
+// wcCommand implements wc [-lwc] [file...].
+func (r *SafeRunner) wcCommand(hc interp.HandlerContext, args []string) error {
+	e := &commandError{hc: hc, name: "wc"}
+	flags, names, err := parseShortFlags(args[1:], "")
+	if err != nil {
+		return e.usage("%v", err)
+	}
+	if c, ok := checkFlags(flags, "lwc"); !ok {
+		return e.usage("unsupported option -- '%c'", c)
+	}
+	_, showLines := flags['l']
+	_, showWords := flags['w']
+	_, showBytes := flags['c']
+	if !showLines && !showWords && !showBytes {
+		showLines, showWords, showBytes = true, true, true
+	}
+
+	printCounts := func(c wcCounts, label string) {
+		var fields []string
+		if showLines {
+			fields = append(fields, fmt.Sprintf("%7d", c.lines))
+		}
+		if showWords {
+			fields = append(fields, fmt.Sprintf("%7d", c.words))
+		}
+		if showBytes {
+			fields = append(fields, fmt.Sprintf("%7d", c.bytes))
+		}
+		if label != "" && label != "-" {
+			fields = append(fields, label)
+		}
+		fmt.Fprintln(hc.Stdout, strings.Join(fields, " "))
+	}
+
+	var total wcCounts
+	count := 0
+	r.forEachInput(hc, e, names, func(name string, rd io.Reader) error {
+		var c wcCounts
+		inWord := false
+		br := bufio.NewReader(rd)
+		for {
+			b, err := br.ReadByte()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			c.bytes++
+			if b == '\n' {
+				c.lines++
+			}
+			if unicode.IsSpace(rune(b)) {
+				inWord = false
+			} else if !inWord {
+				inWord = true
+				c.words++
+			}
+		}
+		printCounts(c, name)
+		total.lines += c.lines
+		total.words += c.words
+		total.bytes += c.bytes
+		count++
+		return nil
+	})
+	if count > 1 {
+		printCounts(total, "total")
+	}
+	return e.status()
+}
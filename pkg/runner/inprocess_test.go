@@ -0,0 +1,145 @@
+package runner
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+	"mvdan.cc/sh/v3/interp"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+// newInProcessTestRunner creates a runner with in-process commands enabled, writing to the returned buffers.
+func newInProcessTestRunner(t *testing.T, tmpDir string) (*SafeRunner, *bytes.Buffer, *bytes.Buffer) {
+	t.Helper()
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories: []string{tmpDir},
+		AllowCommands: []config.AllowCommand{
+			{Command: "cat"}, {Command: "ls"}, {Command: "head"}, {Command: "tail"}, {Command: "wc"}, {Command: "cd"},
+		},
+		DenyCommands:        []config.DenyCommand{},
+		DefaultErrorMessage: "Command not allowed",
+		MaxExecutionTime:    10,
+		MaxOutputSize:       1024,
+		InProcessCommands:   true,
+	}
+	log := logger.New()
+	v := validator.New(cfg, log)
+	r := New(cfg, v, log)
+	var stdout, stderr bytes.Buffer
+	r.SetOutputs(&stdout, &stderr)
+	return r, &stdout, &stderr
+}
+
+func TestInProcessCommands(t *testing.T) {
+	tmpDir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("one\ntwo\nthree\n"), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "b.txt"), []byte("four five\n"), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, ".hidden"), []byte(""), 0o600))
+	assert.NoError(t, os.Mkdir(filepath.Join(tmpDir, "sub"), 0o700))
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "sub", "c.txt"), []byte("six\n"), 0o600))
+
+	tests := []struct {
+		name     string
+		command  string
+		expected string
+	}{
+		{"cat", "cat a.txt b.txt", "one\ntwo\nthree\nfour five\n"},
+		{"cat numbered", "cat -n b.txt", "     1\tfour five\n"},
+		{"cat stdin", "cat < a.txt", "one\ntwo\nthree\n"},
+		{"ls", "ls", "a.txt\nb.txt\nsub\n"},
+		{"ls all", "ls -aF", "./\n../\n.hidden\na.txt\nb.txt\nsub/\n"},
+		{"ls almost all", "ls -A sub a.txt", "a.txt\n\nsub:\nc.txt\n"},
+		{"head", "head -n 2 a.txt", "one\ntwo\n"},
+		{"head shorthand", "head -1 a.txt", "one\n"},
+		{"head bytes", "head -c 3 b.txt", "fou"},
+		{"head headers", "head -n 1 a.txt b.txt", "==> a.txt <==\none\n\n==> b.txt <==\nfour five\n"},
+		{"tail", "tail -n 2 a.txt", "two\nthree\n"},
+		{"tail more than file", "tail -n 5 a.txt", "one\ntwo\nthree\n"},
+		{"tail zero", "tail -n 0 a.txt", ""},
+		{"tail bytes", "tail -c 5 b.txt", "five\n"},
+		{"wc lines", "wc -l a.txt", "      3 a.txt\n"},
+		{"wc total", "wc a.txt b.txt", "      3       3      14 a.txt\n      1       2      10 b.txt\n      4       5      24 total\n"},
+		{"wc pipe", "cat a.txt | wc -w", "      3\n"},
+		{"relative to cd", "cd sub && cat c.txt", "six\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, stdout, stderr := newInProcessTestRunner(t, tmpDir)
+			result := r.RunCommand(t.Context(), tt.command, tmpDir)
+			assert.NoError(t, result.Err, "stderr: %s", stderr.String())
+			assert.Equal(t, tt.expected, stdout.String())
+			// No process is started, so there is nothing to measure
+			assert.Equal(t, 0, len(result.Metrics))
+		})
+	}
+}
+
+func TestInProcessCommands_DeniesPathsOutsideAllowedDirectories(t *testing.T) {
+	tmpDir := t.TempDir()
+	outsideDir := t.TempDir()
+	secret := filepath.Join(outsideDir, "secret.txt")
+	assert.NoError(t, os.WriteFile(secret, []byte("secret\n"), 0o600))
+	assert.NoError(t, os.Symlink(secret, filepath.Join(tmpDir, "link.txt")))
+
+	for _, command := range []string{"cat link.txt", "head link.txt", "tail link.txt", "wc link.txt", "ls link.txt"} {
+		t.Run(command, func(t *testing.T) {
+			r, stdout, stderr := newInProcessTestRunner(t, tmpDir)
+			result := r.RunCommand(t.Context(), command, tmpDir)
+			status, ok := interp.IsExitStatus(result.Err)
+			assert.True(t, ok)
+			assert.Equal(t, uint8(exitFailure), status)
+			assert.NotContains(t, stdout.String(), "secret")
+			assert.Contains(t, stderr.String(), "access denied")
+		})
+	}
+}
+
+func TestInProcessCommands_Errors(t *testing.T) {
+	tmpDir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("one\n"), 0o600))
+
+	tests := []struct {
+		name    string
+		command string
+		status  uint8
+		stderr  string
+		stdout  string
+	}{
+		{"unsupported flag", "cat -v a.txt", exitUsage, "cat: unsupported option -- 'v'", ""},
+		{"missing value", "head -n", exitUsage, "head: option requires an argument -- 'n'", ""},
+		{"invalid count", "tail -n x a.txt", exitUsage, "tail: invalid number: 'x'", ""},
+		{"missing file", "cat missing.txt a.txt", exitFailure, "cat: missing.txt: No such file or directory", "one\n"},
+		{"directory", "wc .", exitFailure, "wc: .: Is a directory", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, stdout, stderr := newInProcessTestRunner(t, tmpDir)
+			result := r.RunCommand(t.Context(), tt.command, tmpDir)
+			status, ok := interp.IsExitStatus(result.Err)
+			assert.True(t, ok)
+			assert.Equal(t, tt.status, status)
+			assert.True(t, strings.HasPrefix(stderr.String(), tt.stderr), "stderr: %s", stderr.String())
+			assert.Equal(t, tt.stdout, stdout.String())
+		})
+	}
+}
+
+func TestInProcessCommands_Disabled(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, stdout, _ := newInProcessTestRunner(t, tmpDir)
+	r.config.InProcessCommands = false
+
+	result := r.RunCommand(t.Context(), "ls", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "", stdout.String())
+	assert.Equal(t, 1, len(result.Metrics))
+}
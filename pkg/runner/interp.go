@@ -0,0 +1,184 @@
+package runner
+
+import (
+	"context"
+	"io"
+	"os"
+	"slices"
+	"sync"
+
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// maxInterpPools bounds the number of working directories whose interpreters are kept for
+// reuse. Runs in other directories, such as fresh snapshots, use a new interpreter.
+const maxInterpPools = 64
+
+// environCache holds the interpreter environment built last. Building one sorts the whole
+// process environment, which costs more than running a short command.
+var environCache struct {
+	mu      sync.Mutex
+	environ []string
+	env     expand.Environ
+}
+
+// cachedEnviron returns an interpreter environment of the "NAME=value" pairs in environ,
+// reusing the last one built when the pairs have not changed.
+func cachedEnviron(environ []string) expand.Environ {
+	environCache.mu.Lock()
+	defer environCache.mu.Unlock()
+	if environCache.env == nil || !slices.Equal(environ, environCache.environ) {
+		environCache.environ = environ
+		environCache.env = expand.ListEnviron(environ...)
+	}
+	return environCache.env
+}
+
+// interpKey identifies the interpreters that can be reused for a run: those started in the
+// same directory with the same temporary directory, which an interpreter fixes when it is
+// first reset.
+type interpKey struct {
+	dir     string
+	tempDir string
+}
+
+// interpPools holds the idle interpreters for each interpKey.
+var interpPools = struct {
+	mu    sync.Mutex
+	pools map[interpKey]*sync.Pool
+}{pools: make(map[interpKey]*sync.Pool)}
+
+// interpHooks are the handlers and writers of the run an interpreter currently serves. The
+// interpreter calls them through shellInterp, as its own are fixed when it is created.
+type interpHooks struct {
+	call   interp.CallHandlerFunc
+	exec   interp.ExecHandlerFunc
+	open   interp.OpenHandlerFunc
+	stdout hookWriter
+	stderr hookWriter
+}
+
+// hookWriter writes to the writer of the current run.
+type hookWriter struct {
+	w io.Writer
+}
+
+// Write implements the io.Writer interface.
+func (h *hookWriter) Write(p []byte) (int, error) {
+	return h.w.Write(p)
+}
+
+// shellInterp is an interpreter running a script, possibly one that ran others before.
+type shellInterp struct {
+	*interp.Runner
+	hooks *interpHooks
+	// stdout and stderr are the writers commands receive
+	stdout io.Writer
+	stderr io.Writer
+	// pool is where the interpreter returns once the script finishes, if anywhere
+	pool *sync.Pool
+}
+
+// newInterp returns an interpreter that runs in dir with env, calling call for every command
+// and writing to stdout and stderr. Interpreters are reused when the writers are not files,
+// which commands write to directly.
+func (r *SafeRunner) newInterp(dir string, env expand.Environ, call interp.CallHandlerFunc, stdout, stderr io.Writer) (*shellInterp, error) {
+	_, stdoutFile := stdout.(*os.File)
+	_, stderrFile := stderr.(*os.File)
+	if stdoutFile || stderrFile {
+		runner, err := interp.New(
+			interp.CallHandler(call),
+			interp.StdIO(nil, stdout, stderr),
+			interp.Env(env),
+			interp.Dir(dir),
+			interp.OpenHandler(r.secureOpenHandler),
+			interp.ExecHandlers(func(interp.ExecHandlerFunc) interp.ExecHandlerFunc { return r.execHandler }),
+		)
+		if err != nil {
+			return nil, err
+		}
+		s := &shellInterp{Runner: runner, stdout: stdout, stderr: stderr}
+		s.reset(env)
+		return s, nil
+	}
+
+	pool := interpPool(interpKey{dir: dir, tempDir: env.Get("TMPDIR").String()})
+	s, _ := pool.Get().(*shellInterp)
+	if s == nil {
+		hooks := &interpHooks{}
+		runner, err := interp.New(
+			interp.CallHandler(func(ctx context.Context, args []string) ([]string, error) { return hooks.call(ctx, args) }),
+			interp.StdIO(nil, &hooks.stdout, &hooks.stderr),
+			interp.Env(env),
+			interp.Dir(dir),
+			interp.OpenHandler(func(ctx context.Context, path string, flag int, perm os.FileMode) (io.ReadWriteCloser, error) {
+				return hooks.open(ctx, path, flag, perm)
+			}),
+			interp.ExecHandlers(func(interp.ExecHandlerFunc) interp.ExecHandlerFunc {
+				return func(ctx context.Context, args []string) error { return hooks.exec(ctx, args) }
+			}),
+		)
+		if err != nil {
+			return nil, err
+		}
+		s = &shellInterp{Runner: runner, hooks: hooks, stdout: &hooks.stdout, stderr: &hooks.stderr}
+	}
+	s.pool = pool
+	s.hooks.call, s.hooks.exec, s.hooks.open = call, r.execHandler, r.secureOpenHandler
+	s.hooks.stdout.w, s.hooks.stderr.w = stdout, stderr
+	s.reset(env)
+	return s, nil
+}
+
+// reset clears the state left by the previous script and sets the environment of the next.
+func (s *shellInterp) reset(env expand.Environ) {
+	s.Env = env
+	s.Reset()
+	// Nothing reads the variables the interpreter would otherwise copy there after every run
+	s.Vars = nil
+}
+
+// release returns the interpreter to its pool once prog has finished. Background commands
+// and process substitutions may outlive the script, so interpreters that ran them are
+// dropped.
+func (s *shellInterp) release(prog *syntax.File) {
+	if s.pool == nil || startsBackground(prog) {
+		return
+	}
+	// Keep nothing of the run alive
+	*s.hooks = interpHooks{}
+	s.pool.Put(s)
+}
+
+// interpPool returns the pool of interpreters for key. Once maxInterpPools keys are pooled,
+// it returns an unshared pool, which the interpreter is dropped with.
+func interpPool(key interpKey) *sync.Pool {
+	interpPools.mu.Lock()
+	defer interpPools.mu.Unlock()
+	pool, ok := interpPools.pools[key]
+	if !ok {
+		pool = &sync.Pool{}
+		if len(interpPools.pools) < maxInterpPools {
+			interpPools.pools[key] = pool
+		}
+	}
+	return pool
+}
+
+// startsBackground reports whether prog has background commands or process substitutions,
+// which the interpreter runs in goroutines that need not finish with the script.
+func startsBackground(prog *syntax.File) bool {
+	found := false
+	syntax.Walk(prog, func(node syntax.Node) bool {
+		switch n := node.(type) {
+		case *syntax.Stmt:
+			found = found || n.Background || n.Coprocess
+		case *syntax.ProcSubst:
+			found = true
+		}
+		return !found
+	})
+	return found
+}
@@ -0,0 +1,58 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestRunCommand_ReusedInterpreter(t *testing.T) {
+	tmpDir := t.TempDir()
+	sub := filepath.Join(tmpDir, "sub")
+	assert.NoError(t, os.Mkdir(sub, 0o700))
+	r, stdout := newOptionsTestRunner(t, tmpDir)
+
+	result := r.RunCommand(t.Context(), "GREETING=hello; cd sub; echo $GREETING", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "hello\n", stdout.String())
+
+	// Nothing of the previous script is left in the interpreter that runs the next
+	stdout.Reset()
+	result = r.RunCommand(t.Context(), "echo \"[$GREETING]\" $PWD", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "[] "+tmpDir+"\n", stdout.String())
+
+	stdout.Reset()
+	result = r.Run(t.Context(), []string{"printenv", "GREETING"}, WithWorkdir(tmpDir), WithEnv("GREETING=again"))
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "again\n", stdout.String())
+
+	stdout.Reset()
+	result = r.RunCommand(t.Context(), "printenv GREETING || echo unset", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "unset\n", stdout.String())
+}
+
+func TestRunCommand_ProcessEnvironment(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, stdout := newOptionsTestRunner(t, tmpDir)
+
+	t.Setenv("SSS_TEST_VAR", "first")
+	assert.NoError(t, r.RunCommand(t.Context(), "echo $SSS_TEST_VAR", tmpDir).Err)
+	t.Setenv("SSS_TEST_VAR", "second")
+	assert.NoError(t, r.RunCommand(t.Context(), "echo $SSS_TEST_VAR", tmpDir).Err)
+	assert.Equal(t, "first\nsecond\n", stdout.String())
+}
+
+func BenchmarkRunCommand(b *testing.B) {
+	tmpDir := b.TempDir()
+	r, stdout := newOptionsTestRunner(b, tmpDir)
+	for b.Loop() {
+		stdout.Reset()
+		if result := r.RunCommand(b.Context(), "echo hello && echo world | cat", tmpDir); result.Err != nil {
+			b.Fatal(result.Err)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrDisabled is the error of executions rejected or terminated while the kill switch is on.
+var ErrDisabled = errors.New("command execution is disabled")
+
+// KillSwitchStatus describes the state of the kill switch.
+type KillSwitchStatus struct {
+	Disabled bool      `json:"disabled"`
+	Reason   string    `json:"reason,omitempty"`
+	Since    time.Time `json:"since,omitzero"`
+	// Running is the number of executions in progress in this process.
+	Running int `json:"running"`
+}
+
+// killSwitch is the process-wide state behind Disable and Enable. It applies to every runner,
+// so that one call stops all servers and sessions in the process.
+var killSwitch = struct {
+	mu       sync.Mutex
+	disabled bool
+	reason   string
+	since    time.Time
+	// running holds the cancel function of every execution in progress
+	running map[*context.CancelCauseFunc]struct{}
+}{running: make(map[*context.CancelCauseFunc]struct{})}
+
+// Disable turns on the kill switch: every new execution, and every command not yet started by a
+// running script, is rejected with the configured disabledMessage and reason until Enable is called.
+// Executions already running are left to finish unless TerminateRunning is called as well.
+func Disable(reason string) {
+	killSwitch.mu.Lock()
+	defer killSwitch.mu.Unlock()
+	if !killSwitch.disabled {
+		killSwitch.since = time.Now()
+	}
+	killSwitch.disabled = true
+	killSwitch.reason = reason
+}
+
+// Enable turns off the kill switch.
+func Enable() {
+	killSwitch.mu.Lock()
+	defer killSwitch.mu.Unlock()
+	killSwitch.disabled = false
+	killSwitch.reason = ""
+	killSwitch.since = time.Time{}
+}
+
+// TerminateRunning stops every execution in progress, terminating its processes as a timeout
+// would, and returns how many were stopped. Their results fail with ErrDisabled.
+func TerminateRunning() int {
+	killSwitch.mu.Lock()
+	defer killSwitch.mu.Unlock()
+	for cancel := range killSwitch.running {
+		(*cancel)(ErrDisabled)
+	}
+	return len(killSwitch.running)
+}
+
+// KillSwitch returns the state of the kill switch.
+func KillSwitch() KillSwitchStatus {
+	killSwitch.mu.Lock()
+	defer killSwitch.mu.Unlock()
+	return KillSwitchStatus{
+		Disabled: killSwitch.disabled,
+		Reason:   killSwitch.reason,
+		Since:    killSwitch.since,
+		Running:  len(killSwitch.running),
+	}
+}
+
+// trackExecution registers an execution so that TerminateRunning can stop it. The returned
+// function unregisters it and must be called when it has finished.
+func trackExecution(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	killSwitch.mu.Lock()
+	killSwitch.running[&cancel] = struct{}{}
+	killSwitch.mu.Unlock()
+	return ctx, func() {
+		killSwitch.mu.Lock()
+		delete(killSwitch.running, &cancel)
+		killSwitch.mu.Unlock()
+		cancel(nil)
+	}
+}
+
+// disabledError is the error of an execution rejected by the kill switch.
+type disabledError struct {
+	message string
+}
+
+func (e *disabledError) Error() string {
+	return e.message
+}
+
+func (e *disabledError) Is(target error) bool {
+	return target == ErrDisabled
+}
+
+// checkKillSwitch returns an error wrapping ErrDisabled if the kill switch is on.
+func (r *SafeRunner) checkKillSwitch() error {
+	killSwitch.mu.Lock()
+	disabled, reason := killSwitch.disabled, killSwitch.reason
+	killSwitch.mu.Unlock()
+	if !disabled {
+		return nil
+	}
+	return r.disabledError(reason)
+}
+
+// disabledError returns the error for an execution rejected or stopped by the kill switch.
+func (r *SafeRunner) disabledError(reason string) error {
+	message := r.config.DisabledMessage
+	if message == "" {
+		message = ErrDisabled.Error()
+	}
+	if reason != "" {
+		message += ": " + reason
+	}
+	return &ExitError{Code: ExitDenied, Err: &disabledError{message: message}}
+}
@@ -0,0 +1,56 @@
+package runner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestDisable_RejectsExecutions(t *testing.T) {
+	t.Cleanup(Enable)
+	tmpDir := t.TempDir()
+	r, stdout := newOptionsTestRunner(t, tmpDir)
+	r.config.DisabledMessage = "maintenance in progress"
+
+	Disable("incident 42")
+	status := KillSwitch()
+	assert.True(t, status.Disabled)
+	assert.Equal(t, "incident 42", status.Reason)
+	assert.False(t, status.Since.IsZero())
+
+	result := r.Run(t.Context(), []string{"echo", "hello"})
+	assert.IsError(t, result.Err, ErrDisabled)
+	assert.Equal(t, ExitDenied, ExitCode(result.Err))
+	assert.Equal(t, "maintenance in progress: incident 42", result.Err.Error())
+	assert.Equal(t, "", stdout.String())
+
+	Enable()
+	assert.False(t, KillSwitch().Disabled)
+	result = r.Run(t.Context(), []string{"echo", "hello"})
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "hello\n", stdout.String())
+}
+
+func TestTerminateRunning(t *testing.T) {
+	t.Cleanup(Enable)
+	tmpDir := t.TempDir()
+	r, _ := newOptionsTestRunner(t, tmpDir)
+
+	done := make(chan RunResult, 1)
+	go func() { done <- r.RunCommand(t.Context(), "sleep 10; echo never", tmpDir) }()
+	for KillSwitch().Running == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	Disable("")
+	assert.Equal(t, 1, TerminateRunning())
+	select {
+	case result := <-done:
+		assert.IsError(t, result.Err, ErrDisabled)
+		assert.Equal(t, ErrDisabled.Error(), result.Err.Error())
+	case <-time.After(5 * time.Second):
+		t.Fatal("execution was not terminated")
+	}
+	assert.Equal(t, 0, KillSwitch().Running)
+}
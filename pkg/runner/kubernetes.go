@@ -0,0 +1,481 @@
+package runner
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"mvdan.cc/sh/v3/interp"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/websocket"
+)
+
+const (
+	// kubeServiceAccountDir holds the credentials of the service account of a pod.
+	kubeServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	// kubeDefaultNamespace is the namespace used when none is configured.
+	kubeDefaultNamespace = "default"
+	// kubeExecScript changes to the working directory, given as $1, and runs the command
+	// with the environment variables that precede it, since exec has no parameters for either.
+	kubeExecScript = `cd -- "$1" || exit; shift; exec env "$@"`
+)
+
+// Subprotocols of the exec stream. v5 adds the close channel, through which the end of stdin
+// is signaled; with v4 the command's stdin stays open until it exits.
+const (
+	kubeProtocolV5 = "v5.channel.k8s.io"
+	kubeProtocolV4 = "v4.channel.k8s.io"
+)
+
+// Channels of the exec stream; each message starts with the channel it belongs to.
+const (
+	kubeChannelStdin  = 0
+	kubeChannelStdout = 1
+	kubeChannelStderr = 2
+	kubeChannelError  = 3
+	kubeChannelClose  = 255
+)
+
+// errKubeAuth is returned for kubeconfig users authenticating in ways the client does not support.
+var errKubeAuth = errors.New("unsupported kubeconfig authentication")
+
+// kubeClient is a minimal client of the Kubernetes API, covering what is needed to run a
+// command in a pod and stream its output.
+type kubeClient struct {
+	// server is the URL of the API server, e.g. https://10.0.0.1:443
+	server    string
+	tls       *tls.Config
+	token     string
+	tokenFile string
+	// namespace is the default namespace of the context or service account
+	namespace string
+}
+
+// newKubeClient returns a client connecting as cfg configures: with a kubeconfig file when
+// one is set, otherwise as the service account of the pod the server runs in, otherwise with
+// $KUBECONFIG or ~/.kube/config.
+func newKubeClient(cfg config.KubernetesConfig) (*kubeClient, error) {
+	path := cfg.Kubeconfig
+	if path == "" {
+		if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+			return inClusterKubeClient()
+		}
+		path = defaultKubeconfig()
+	}
+	return kubeconfigClient(path, cfg.Context)
+}
+
+// defaultKubeconfig returns the first file of $KUBECONFIG, or ~/.kube/config.
+func defaultKubeconfig() string {
+	if paths := filepath.SplitList(os.Getenv("KUBECONFIG")); len(paths) > 0 && paths[0] != "" {
+		return paths[0]
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".kube", "config")
+	}
+	return filepath.Join(home, ".kube", "config")
+}
+
+// inClusterKubeClient returns a client authenticating as the service account of the pod.
+func inClusterKubeClient() (*kubeClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if port == "" {
+		port = "443"
+	}
+	ca, err := os.ReadFile(filepath.Join(kubeServiceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the service account CA: %w", err)
+	}
+	tlsConfig, err := kubeTLSConfig(ca, "", false)
+	if err != nil {
+		return nil, err
+	}
+	namespace, _ := os.ReadFile(filepath.Join(kubeServiceAccountDir, "namespace"))
+	return &kubeClient{
+		server:    "https://" + net.JoinHostPort(host, port),
+		tls:       tlsConfig,
+		tokenFile: filepath.Join(kubeServiceAccountDir, "token"),
+		namespace: strings.TrimSpace(string(namespace)),
+	}, nil
+}
+
+// kubeconfig is the part of a kubeconfig file the client uses.
+type kubeconfig struct {
+	CurrentContext string              `yaml:"current-context"`
+	Clusters       []kubeconfigCluster `yaml:"clusters"`
+	Users          []kubeconfigUser    `yaml:"users"`
+	Contexts       []kubeconfigContext `yaml:"contexts"`
+}
+
+// kubeconfigCluster is a named API server of a kubeconfig.
+type kubeconfigCluster struct {
+	Name    string `yaml:"name"`
+	Cluster struct {
+		Server                   string `yaml:"server"`
+		CertificateAuthority     string `yaml:"certificate-authority"`
+		CertificateAuthorityData string `yaml:"certificate-authority-data"`
+		InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		TLSServerName            string `yaml:"tls-server-name"`
+	} `yaml:"cluster"`
+}
+
+// kubeconfigUser is a named set of credentials of a kubeconfig.
+type kubeconfigUser struct {
+	Name string `yaml:"name"`
+	User struct {
+		Token                 string `yaml:"token"`
+		TokenFile             string `yaml:"tokenFile"`
+		ClientCertificate     string `yaml:"client-certificate"`
+		ClientCertificateData string `yaml:"client-certificate-data"`
+		ClientKey             string `yaml:"client-key"`
+		ClientKeyData         string `yaml:"client-key-data"`
+		Exec                  any    `yaml:"exec"`
+		AuthProvider          any    `yaml:"auth-provider"`
+	} `yaml:"user"`
+}
+
+// kubeconfigContext names the cluster, user, and namespace to use together.
+type kubeconfigContext struct {
+	Name    string `yaml:"name"`
+	Context struct {
+		Cluster   string `yaml:"cluster"`
+		User      string `yaml:"user"`
+		Namespace string `yaml:"namespace"`
+	} `yaml:"context"`
+}
+
+// kubeconfigClient returns a client for the context contextName of the kubeconfig at path,
+// or for its current context when contextName is empty.
+func kubeconfigClient(path, contextName string) (*kubeClient, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kubeconfig: %w", err)
+	}
+	var kc kubeconfig
+	if err := yaml.Unmarshal(data, &kc); err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig %s: %w", path, err)
+	}
+	if contextName == "" {
+		contextName = kc.CurrentContext
+	}
+
+	i := slices.IndexFunc(kc.Contexts, func(c kubeconfigContext) bool { return c.Name == contextName })
+	if i < 0 {
+		return nil, fmt.Errorf("kubeconfig %s has no context %q", path, contextName)
+	}
+	ctx := kc.Contexts[i].Context
+	c := slices.IndexFunc(kc.Clusters, func(c kubeconfigCluster) bool { return c.Name == ctx.Cluster })
+	if c < 0 {
+		return nil, fmt.Errorf("kubeconfig %s has no cluster %q", path, ctx.Cluster)
+	}
+	cluster := kc.Clusters[c].Cluster
+
+	// Relative paths in a kubeconfig are relative to the file
+	dir := filepath.Dir(path)
+	resolve := func(p string) string {
+		if p != "" && !filepath.IsAbs(p) {
+			return filepath.Join(dir, p)
+		}
+		return p
+	}
+	ca, err := kubeconfigData(cluster.CertificateAuthorityData, resolve(cluster.CertificateAuthority))
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := kubeTLSConfig(ca, cluster.TLSServerName, cluster.InsecureSkipTLSVerify)
+	if err != nil {
+		return nil, err
+	}
+	client := &kubeClient{server: strings.TrimSuffix(cluster.Server, "/"), tls: tlsConfig, namespace: ctx.Namespace}
+
+	u := slices.IndexFunc(kc.Users, func(u kubeconfigUser) bool { return u.Name == ctx.User })
+	if u < 0 {
+		return client, nil
+	}
+	user := kc.Users[u].User
+	if user.Exec != nil || user.AuthProvider != nil {
+		return nil, fmt.Errorf("%w: user %q uses an exec or auth-provider plugin; use a token or a client certificate", errKubeAuth, ctx.User)
+	}
+	client.token, client.tokenFile = user.Token, resolve(user.TokenFile)
+	cert, err := kubeconfigData(user.ClientCertificateData, resolve(user.ClientCertificate))
+	if err != nil {
+		return nil, err
+	}
+	key, err := kubeconfigData(user.ClientKeyData, resolve(user.ClientKey))
+	if err != nil {
+		return nil, err
+	}
+	if len(cert) > 0 || len(key) > 0 {
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate of kubeconfig user %q: %w", ctx.User, err)
+		}
+		client.tls.Certificates = []tls.Certificate{pair}
+	}
+	return client, nil
+}
+
+// kubeconfigData returns the base64-encoded data of a kubeconfig field, or the contents of
+// the file it names otherwise.
+func kubeconfigData(data, path string) ([]byte, error) {
+	if data != "" {
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 data in kubeconfig: %w", err)
+		}
+		return decoded, nil
+	}
+	if path == "" {
+		return nil, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kubeconfig file: %w", err)
+	}
+	return content, nil
+}
+
+// kubeTLSConfig returns the TLS configuration verifying the API server with the PEM
+// certificates ca, or with the system roots when ca is empty.
+func kubeTLSConfig(ca []byte, serverName string, insecure bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         serverName,
+		InsecureSkipVerify: insecure, //nolint:gosec // set explicitly in the kubeconfig
+	}
+	if len(ca) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New("invalid certificate authority in kubeconfig")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// header returns the headers authenticating a request. The token file is read every time,
+// since service account tokens are rotated.
+func (c *kubeClient) header() (http.Header, error) {
+	token := c.token
+	if c.tokenFile != "" {
+		data, err := os.ReadFile(c.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read kubernetes token: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	header := http.Header{}
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	return header, nil
+}
+
+// podPath returns the API path of a pod.
+func podPath(namespace, pod string) string {
+	return "/api/v1/namespaces/" + url.PathEscape(namespace) + "/pods/" + url.PathEscape(pod)
+}
+
+// getPod checks that the pod exists and can be read.
+func (c *kubeClient) getPod(ctx context.Context, namespace, pod string) error {
+	header, err := c.header()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.server+podPath(namespace, pod), nil)
+	if err != nil {
+		return err
+	}
+	req.Header = header
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: c.tls}}
+	defer client.CloseIdleConnections()
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return kubeError(resp)
+	}
+	return nil
+}
+
+// kubeStatus is the Status object of the API, reporting errors and the outcome of an exec.
+type kubeStatus struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Reason  string `json:"reason"`
+	Details struct {
+		Causes []struct {
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		} `json:"causes"`
+	} `json:"details"`
+}
+
+// kubeError converts an error response of the API server into an error.
+func kubeError(resp *http.Response) error {
+	var status kubeStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil || status.Message == "" {
+		return fmt.Errorf("kubernetes: %s", resp.Status)
+	}
+	return fmt.Errorf("kubernetes: %s", status.Message)
+}
+
+// err returns the error of an exec that ended with s, an exit status for a command that
+// exited unsuccessfully.
+func (s kubeStatus) err() error {
+	if s.Status == "Success" {
+		return nil
+	}
+	if s.Reason == "NonZeroExitCode" {
+		for _, cause := range s.Details.Causes {
+			if code, err := strconv.Atoi(cause.Message); err == nil && cause.Reason == "ExitCode" {
+				return interp.NewExitStatus(uint8(code)) //nolint:gosec // exit codes are truncated like a shell does
+			}
+		}
+	}
+	return fmt.Errorf("kubernetes: %s", s.Message)
+}
+
+// exec runs command in a container of a pod, forwarding stdin when it is not nil and copying
+// the command's output to stdout and stderr. Canceling ctx closes the stream.
+func (c *kubeClient) exec(ctx context.Context, namespace, pod, container string, command []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	query := url.Values{"command": command, "stdout": {"true"}, "stderr": {"true"}}
+	if container != "" {
+		query.Set("container", container)
+	}
+	if stdin != nil {
+		query.Set("stdin", "true")
+	}
+	header, err := c.header()
+	if err != nil {
+		return err
+	}
+	header.Set("Sec-WebSocket-Protocol", kubeProtocolV5+", "+kubeProtocolV4)
+
+	execURL := strings.Replace(c.server, "http", "ws", 1) + podPath(namespace, pod) + "/exec?" + query.Encode()
+	dialer := websocket.Dialer{TLSConfig: c.tls}
+	conn, resp, err := dialer.Dial(ctx, execURL, header)
+	if err != nil {
+		if resp != nil {
+			defer resp.Body.Close()
+			return kubeError(resp)
+		}
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close(websocket.CloseNormal, "") })
+	defer stop()
+	defer conn.Close(websocket.CloseNormal, "")
+
+	if stdin != nil {
+		go copyKubeStdin(conn, stdin, resp.Header.Get("Sec-WebSocket-Protocol") == kubeProtocolV5)
+	}
+
+	var status *kubeStatus
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			var closeErr *websocket.CloseError
+			switch {
+			case ctx.Err() != nil:
+				return ctx.Err()
+			case status != nil:
+				return status.err()
+			case errors.As(err, &closeErr):
+				return errors.New("kubernetes: exec stream closed without a status")
+			default:
+				return fmt.Errorf("failed to read exec stream: %w", err)
+			}
+		}
+		if len(message) == 0 {
+			continue
+		}
+		switch message[0] {
+		case kubeChannelStdout:
+			_, err = stdout.Write(message[1:])
+		case kubeChannelStderr:
+			_, err = stderr.Write(message[1:])
+		case kubeChannelError:
+			status = &kubeStatus{}
+			if err := json.Unmarshal(message[1:], status); err != nil {
+				return fmt.Errorf("kubernetes: invalid exec status: %w", err)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// copyKubeStdin sends stdin on the stdin channel of conn and, when the server speaks v5,
+// signals its end on the close channel.
+func copyKubeStdin(conn *websocket.Conn, stdin io.Reader, signalEOF bool) {
+	buf := make([]byte, 32*1024) //nolint:mnd // the size of io.Copy's buffer
+	for {
+		n, err := stdin.Read(buf)
+		if n > 0 {
+			if conn.WriteMessage(websocket.OpBinary, append([]byte{kubeChannelStdin}, buf[:n]...)) != nil {
+				return
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	if signalEOF {
+		_ = conn.WriteMessage(websocket.OpBinary, []byte{kubeChannelClose, kubeChannelStdin})
+	}
+}
+
+// kubeCommand returns the command exec runs for args in dir with env, through sh.
+func kubeCommand(args []string, dir string, env []string) []string {
+	command := make([]string, 0, len(args)+len(env)+5) //nolint:mnd // sh -c script sh dir
+	command = append(command, "sh", "-c", kubeExecScript, "sh", dir)
+	// The container's PATH applies, not the host's
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, "PATH=") {
+			command = append(command, kv)
+		}
+	}
+	return append(command, args...)
+}
+
+// kubeNamespace returns the namespace of the pod commands run in with cfg.
+func kubeNamespace(cfg config.KubernetesConfig, client *kubeClient) string {
+	switch {
+	case cfg.Namespace != "":
+		return cfg.Namespace
+	case client.namespace != "":
+		return client.namespace
+	default:
+		return kubeDefaultNamespace
+	}
+}
+
+// runInPod runs args in the configured pod, forwarding stdin and streaming its output to the
+// handler's writers.
+func (r *SafeRunner) runInPod(ctx context.Context, hc interp.HandlerContext, args, env []string) error {
+	cfg := r.config.Kubernetes
+	client, err := newKubeClient(cfg)
+	if err != nil {
+		return err
+	}
+	return client.exec(ctx, kubeNamespace(cfg, client), cfg.Pod, cfg.Container, kubeCommand(args, hc.Dir, env), hc.Stdin, hc.Stdout, hc.Stderr)
+}
@@ -0,0 +1,163 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/websocket"
+)
+
+// fakeKubernetes is an API server running every exec as a command that echoes its stdin to
+// stdout, writes "warn" to stderr, and exits with status 3.
+type fakeKubernetes struct {
+	mu    sync.Mutex
+	query url.Values
+}
+
+func (k *fakeKubernetes) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Authorization") != "Bearer s3cret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"kind":"Status","status":"Failure","message":"Unauthorized"}`))
+		return
+	}
+	switch req.URL.Path {
+	case "/api/v1/namespaces/debug/pods/web":
+		_, _ = w.Write([]byte(`{"kind":"Pod"}`))
+	case "/api/v1/namespaces/debug/pods/web/exec":
+		k.mu.Lock()
+		k.query = req.URL.Query()
+		k.mu.Unlock()
+		k.exec(w, req)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"kind":"Status","status":"Failure","message":"pods \"other\" not found"}`))
+	}
+}
+
+// exec streams the fake command over the v5 protocol.
+func (k *fakeKubernetes) exec(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Sec-WebSocket-Protocol", strings.Split(req.Header.Get("Sec-WebSocket-Protocol"), ",")[0])
+	conn, err := websocket.Upgrade(w, req)
+	if err != nil {
+		return
+	}
+	defer conn.Close(websocket.CloseNormal, "")
+
+	var input []byte
+	for req.URL.Query().Get("stdin") == "true" {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if message[0] == kubeChannelClose {
+			break
+		}
+		input = append(input, message[1:]...)
+	}
+	_ = conn.WriteMessage(websocket.OpBinary, append([]byte{kubeChannelStdout}, input...))
+	_ = conn.WriteMessage(websocket.OpBinary, append([]byte{kubeChannelStderr}, "warn\n"...))
+	_ = conn.WriteMessage(websocket.OpBinary, append([]byte{kubeChannelError},
+		`{"status":"Failure","reason":"NonZeroExitCode","details":{"causes":[{"reason":"ExitCode","message":"3"}]}}`...))
+}
+
+// writeKubeconfig writes a kubeconfig for server whose context "dev" authenticates with user.
+func writeKubeconfig(t *testing.T, server *httptest.Server, user string) string {
+	t.Helper()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	kubeconfig := `apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+- name: test
+  cluster:
+    server: ` + server.URL + `
+    certificate-authority-data: ` + base64.StdEncoding.EncodeToString(ca) + `
+contexts:
+- name: dev
+  context:
+    cluster: test
+    user: dev
+    namespace: debug
+users:
+- name: dev
+  user:
+` + user
+	path := filepath.Join(t.TempDir(), "config")
+	assert.NoError(t, os.WriteFile(path, []byte(kubeconfig), 0o600))
+	return path
+}
+
+func TestKubernetesBackend(t *testing.T) {
+	api := &fakeKubernetes{}
+	server := httptest.NewTLSServer(api)
+	t.Cleanup(server.Close)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, os.WriteFile(tokenFile, []byte("s3cret\n"), 0o600))
+	kubeconfig := writeKubeconfig(t, server, "    tokenFile: "+tokenFile+"\n")
+
+	tmpDir := t.TempDir()
+	r, stdout := newOptionsTestRunner(t, tmpDir)
+	var stderr bytes.Buffer
+	r.SetOutputs(stdout, &stderr)
+	r.config.ExecutionBackend = config.BackendKubernetes
+	r.config.Kubernetes = config.KubernetesConfig{Pod: "web", Container: "app", Kubeconfig: kubeconfig}
+
+	result := r.RunCommand(t.Context(), "echo hello | cat", tmpDir)
+	assert.Equal(t, 3, ExitCode(result.Err))
+	assert.Equal(t, "hello\n", stdout.String())
+	assert.Equal(t, "warn\n", stderr.String())
+
+	api.mu.Lock()
+	query := api.query
+	api.mu.Unlock()
+	assert.Equal(t, "app", query.Get("container"))
+	command := query["command"]
+	assert.Equal(t, []string{"sh", "-c", kubeExecScript, "sh", tmpDir}, command[:5])
+	assert.Equal(t, "cat", command[len(command)-1])
+	for _, kv := range command[5:] {
+		assert.False(t, strings.HasPrefix(kv, "PATH="), "host PATH must not reach the pod")
+	}
+
+	checks := CheckSandboxes(t.Context(), r.config)
+	assert.Equal(t, []SandboxCheck{{Name: SandboxKubernetes, Required: true, Available: true}}, checks)
+
+	// Errors of the API server are reported
+	r.config.Kubernetes.Pod = "other"
+	result = r.RunCommand(t.Context(), "cat", tmpDir)
+	assert.Error(t, result.Err)
+	assert.Contains(t, result.Err.Error(), `pods "other" not found`)
+}
+
+func TestKubeconfigClient(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(server.Close)
+
+	client, err := kubeconfigClient(writeKubeconfig(t, server, "    token: s3cret\n"), "")
+	assert.NoError(t, err)
+	assert.Equal(t, server.URL, client.server)
+	assert.Equal(t, "debug", kubeNamespace(config.KubernetesConfig{}, client))
+	assert.Equal(t, "prod", kubeNamespace(config.KubernetesConfig{Namespace: "prod"}, client))
+	header, err := client.header()
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer s3cret", header.Get("Authorization"))
+
+	_, err = kubeconfigClient(writeKubeconfig(t, server, "    token: s3cret\n"), "prod")
+	assert.Error(t, err)
+
+	plugin := "    exec:\n      command: aws\n"
+	_, err = kubeconfigClient(writeKubeconfig(t, server, plugin), "")
+	assert.True(t, errors.Is(err, errKubeAuth))
+}
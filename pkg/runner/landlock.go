@@ -0,0 +1,82 @@
+package runner
+
+import (
+	"errors"
+	"os/exec"
+	"slices"
+)
+
+// errLandlockUnsupported is returned when the platform or kernel does not provide Landlock.
+var errLandlockUnsupported = errors.New("landlock is not supported on this system")
+
+// defaultLandlockReadOnlyPaths may be read and executed by sandboxed commands when
+// landlock.readOnlyPaths is not configured. Paths that do not exist are skipped.
+var defaultLandlockReadOnlyPaths = []string{
+	"/usr", "/bin", "/sbin", "/lib", "/lib32", "/lib64", "/etc", "/opt", "/dev", "/proc",
+}
+
+// landlockDevices may always be written by sandboxed commands, as redirections to them are.
+var landlockDevices = []string{"/dev/null", "/dev/zero", "/dev/full", "/dev/tty"}
+
+// landlockPolicy lists the paths a sandboxed command may access.
+type landlockPolicy struct {
+	readWrite []string
+	readOnly  []string
+}
+
+// landlockPolicy returns the paths a sandboxed command may access under the configuration.
+func (r *SafeRunner) landlockPolicy() landlockPolicy {
+	readOnly := r.config.Landlock.ReadOnlyPaths
+	if len(readOnly) == 0 {
+		readOnly = defaultLandlockReadOnlyPaths
+	}
+	policy := landlockPolicy{readWrite: slices.Clone(landlockDevices), readOnly: slices.Clone(readOnly)}
+	// In read-only mode the allowed directories may not be written either
+	if r.config.ReadOnlyOnly {
+		policy.readOnly = append(policy.readOnly, r.config.Directories()...)
+	} else {
+		policy.readWrite = append(policy.readWrite, r.config.Directories()...)
+	}
+	return policy
+}
+
+// start starts cmd, inside the Landlock sandbox, without network access, under a seccomp
+// filter, and in the execution's cgroup when they are enabled, and without the privileges the
+// command's rule does not grant.
+func (r *SafeRunner) start(cmd *exec.Cmd) (*process, error) {
+	r.cgroup.attach(cmd)
+	var restrictions []threadRestriction
+	if r.networkDisabled(cmd.Args[0]) {
+		restrictions = append(restrictions, func() error { return isolateNetwork(cmd) })
+	}
+	if r.config.Landlock.Enabled {
+		restrict, err := landlockRestriction(r.landlockPolicy())
+		switch {
+		case errors.Is(err, errLandlockUnsupported) && r.config.Landlock.BestEffort:
+			r.logger.LogErrorf("Running %s without Landlock sandbox: %v", cmd.Path, err)
+		case err != nil:
+			return nil, err
+		default:
+			restrictions = append(restrictions, restrict)
+		}
+	}
+
+	// Capabilities are dropped after the restrictions that need them
+	if restrict := r.privilegeRestriction(cmd.Args[0]); restrict != nil {
+		restrictions = append(restrictions, restrict)
+	}
+
+	// The filter comes last so that it cannot deny the calls of the other restrictions
+	restrict, err := r.seccompRestriction(cmd.Args[0])
+	if err != nil {
+		return nil, err
+	}
+	if restrict != nil {
+		restrictions = append(restrictions, restrict)
+	}
+
+	if len(restrictions) == 0 {
+		return startProcess(cmd)
+	}
+	return startRestricted(cmd, restrictions)
+}
@@ -0,0 +1,133 @@
+//go:build linux
+
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Filesystem access rights by Landlock ABI version.
+const (
+	landlockAccessV1 = unix.LANDLOCK_ACCESS_FS_EXECUTE |
+		unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_DIR |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_DIR |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_CHAR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_DIR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_REG |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_FIFO |
+		unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SYM
+	landlockAccessV2 = landlockAccessV1 | unix.LANDLOCK_ACCESS_FS_REFER
+	landlockAccessV3 = landlockAccessV2 | unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	landlockAccessV5 = landlockAccessV3 | unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+)
+
+// landlockReadAccess is granted on read-only paths.
+const landlockReadAccess = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR
+
+// landlockFileAccess are the rights that apply to files rather than directories.
+const landlockFileAccess = unix.LANDLOCK_ACCESS_FS_EXECUTE |
+	unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+	unix.LANDLOCK_ACCESS_FS_READ_FILE |
+	unix.LANDLOCK_ACCESS_FS_TRUNCATE |
+	unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+
+// landlockRestriction returns the restriction confining a thread to policy.
+func landlockRestriction(policy landlockPolicy) (threadRestriction, error) {
+	abi, err := landlockABI()
+	if err != nil {
+		return nil, err
+	}
+	return func() error { return restrictThread(abi, policy) }, nil
+}
+
+// landlockABI returns the Landlock ABI version supported by the kernel.
+func landlockABI() (int, error) {
+	version, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		return 0, fmt.Errorf("%w: %w", errLandlockUnsupported, errno)
+	}
+	return int(version), nil
+}
+
+// handledAccess returns every filesystem right the ABI version can restrict.
+func handledAccess(abi int) uint64 {
+	switch {
+	case abi >= 5: //nolint:mnd // ABI version that added IOCTL_DEV
+		return landlockAccessV5
+	case abi >= 3: //nolint:mnd // ABI version that added TRUNCATE
+		return landlockAccessV3
+	case abi >= 2: //nolint:mnd // ABI version that added REFER
+		return landlockAccessV2
+	default:
+		return landlockAccessV1
+	}
+}
+
+// restrictThread confines the calling thread, and every process it starts, to policy.
+func restrictThread(abi int, policy landlockPolicy) error {
+	handled := handledAccess(abi)
+	attr := unix.LandlockRulesetAttr{Access_fs: handled}
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("landlock: failed to create ruleset: %w", errno)
+	}
+	ruleset := int(fd)
+	defer unix.Close(ruleset)
+
+	for _, path := range policy.readWrite {
+		if err := addLandlockRule(ruleset, path, handled); err != nil {
+			return err
+		}
+	}
+	for _, path := range policy.readOnly {
+		if err := addLandlockRule(ruleset, path, landlockReadAccess&handled); err != nil {
+			return err
+		}
+	}
+
+	// Required to restrict an unprivileged thread; also stops children gaining privileges through setuid binaries
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("landlock: failed to set no_new_privs: %w", err)
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_LANDLOCK_RESTRICT_SELF, uintptr(ruleset), 0, 0); errno != 0 {
+		return fmt.Errorf("landlock: failed to restrict thread: %w", errno)
+	}
+	return nil
+}
+
+// addLandlockRule allows access beneath path. Paths that do not exist are skipped.
+func addLandlockRule(ruleset int, path string, access uint64) error {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if errors.Is(err, unix.ENOENT) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("landlock: failed to open %s: %w", path, err)
+	}
+	defer unix.Close(fd)
+
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return fmt.Errorf("landlock: failed to stat %s: %w", path, err)
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFDIR {
+		access &= landlockFileAccess
+	}
+
+	rule := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(fd)} //nolint:gosec // file descriptors fit in int32
+	_, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset), unix.LANDLOCK_RULE_PATH_BENEATH,
+		uintptr(unsafe.Pointer(&rule)), 0, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("landlock: failed to add rule for %s: %w", path, errno)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+//go:build linux
+
+package runner
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+// newLandlockTestRunner returns a runner allowing script.sh in tmpDir, writing to the returned buffers.
+func newLandlockTestRunner(t *testing.T, tmpDir string, landlock config.LandlockConfig) (*SafeRunner, *bytes.Buffer, *bytes.Buffer) {
+	t.Helper()
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories:  []string{tmpDir},
+		AllowCommands:       []config.AllowCommand{{Command: "script.sh"}},
+		DenyCommands:        []config.DenyCommand{},
+		DefaultErrorMessage: "Command not allowed",
+		MaxExecutionTime:    10,
+		Landlock:            landlock,
+	}
+	log := logger.New()
+	r := New(cfg, validator.New(cfg, log), log)
+	var stdout, stderr bytes.Buffer
+	r.SetOutputs(&stdout, &stderr)
+	return r, &stdout, &stderr
+}
+
+func TestLandlock_ConfinesChildProcesses(t *testing.T) {
+	if _, err := landlockABI(); err != nil {
+		t.Skipf("Landlock unavailable: %v", err)
+	}
+
+	tmpDir := t.TempDir()
+	outsideDir := t.TempDir()
+	secret := filepath.Join(outsideDir, "secret.txt")
+	assert.NoError(t, os.WriteFile(secret, []byte("secret\n"), 0o600))
+
+	// The script reads and writes outside the allowed directory without naming the paths in argv
+	script := "#!/bin/sh\ncat " + secret + "\necho escaped > " + filepath.Join(outsideDir, "out.txt") + "\necho inside > inside.txt\n"
+	scriptPath := filepath.Join(tmpDir, "script.sh")
+	assert.NoError(t, os.WriteFile(scriptPath, []byte(script), 0o700)) //nolint:gosec // the script must be executable
+
+	// Without the sandbox only argv is validated, and the commands of a script started through
+	// its shebang are not seen, so the script escapes
+	r, stdout, _ := newLandlockTestRunner(t, tmpDir, config.LandlockConfig{})
+	result := r.RunCommand(t.Context(), scriptPath, tmpDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "secret\n", stdout.String())
+	assert.NoError(t, os.Remove(filepath.Join(outsideDir, "out.txt")))
+
+	r, stdout, stderr := newLandlockTestRunner(t, tmpDir, config.LandlockConfig{Enabled: true})
+	result = r.RunCommand(t.Context(), scriptPath, tmpDir)
+	// The last line of the script succeeds
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "", stdout.String())
+	assert.Contains(t, stderr.String(), "Permission denied")
+	_, err := os.Stat(filepath.Join(outsideDir, "out.txt"))
+	assert.True(t, os.IsNotExist(err))
+
+	// The allowed directory stays writable
+	data, err := os.ReadFile(filepath.Join(tmpDir, "inside.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "inside\n", string(data))
+
+	// The server process itself is not restricted
+	_, err = os.ReadFile(secret)
+	assert.NoError(t, err)
+}
+
+func TestLandlock_ReadOnlyOnly(t *testing.T) {
+	if _, err := landlockABI(); err != nil {
+		t.Skipf("Landlock unavailable: %v", err)
+	}
+
+	tmpDir := t.TempDir()
+	scriptPath := filepath.Join(tmpDir, "script.sh")
+	script := "#!/bin/sh\ncat script.sh > /dev/null && echo read\necho x > inside.txt\n"
+	assert.NoError(t, os.WriteFile(scriptPath, []byte(script), 0o700)) //nolint:gosec // the script must be executable
+
+	r, stdout, _ := newLandlockTestRunner(t, tmpDir, config.LandlockConfig{Enabled: true})
+	r.config.ReadOnlyOnly = true
+	r.config.AllowCommands[0].ReadOnly = true
+
+	result := r.RunCommand(t.Context(), scriptPath, tmpDir)
+	assert.Error(t, result.Err)
+	assert.Equal(t, "read\n", stdout.String())
+	_, err := os.Stat(filepath.Join(tmpDir, "inside.txt"))
+	assert.True(t, os.IsNotExist(err))
+}
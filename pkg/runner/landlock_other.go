@@ -0,0 +1,8 @@
+//go:build !linux
+
+package runner
+
+// landlockRestriction fails because Landlock is only available on Linux.
+func landlockRestriction(_ landlockPolicy) (threadRestriction, error) {
+	return nil, errLandlockUnsupported
+}
@@ -0,0 +1,247 @@
+package runner
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+// DefaultMaxConcurrentExecutions is the limit used when ManagerOptions.MaxConcurrent is unset.
+const DefaultMaxConcurrentExecutions = 8
+
+// executionIDBytes is the number of random bytes in an ExecutionID.
+const executionIDBytes = 8
+
+var (
+	// ErrTooManyExecutions is returned by Manager.Execute when every slot is taken and the queue is full.
+	ErrTooManyExecutions = errors.New("too many concurrent executions")
+	// ErrExecutionNotFound is returned by Manager.Kill when no execution with the ID is running.
+	ErrExecutionNotFound = errors.New("execution not found")
+	// ErrExecutionKilled is the error of an execution stopped by Manager.Kill.
+	ErrExecutionKilled = errors.New("execution killed")
+)
+
+// ExecutionID identifies a script running under a Manager.
+type ExecutionID string
+
+// ProcessInfo describes an external process started by a running script.
+type ProcessInfo struct {
+	PID       int
+	Command   string
+	Args      []string
+	StartedAt time.Time
+}
+
+// ExecutionInfo describes a script running under a Manager.
+type ExecutionInfo struct {
+	ID        ExecutionID
+	Script    string
+	WorkDir   string
+	StartedAt time.Time
+	// Processes are the external processes currently running, in the order they started.
+	Processes []ProcessInfo
+}
+
+// ManagerOptions configures a Manager.
+type ManagerOptions struct {
+	// MaxConcurrent is the number of scripts that may run at once across all callers.
+	MaxConcurrent int
+	// MaxQueued is the number of executions that may wait for a free slot; beyond it Execute
+	// fails with ErrTooManyExecutions. Zero rejects executions as soon as every slot is taken.
+	MaxQueued int
+	// Setup, if set, configures the runner of each execution before it runs, e.g. with hooks,
+	// approvals, or history.
+	Setup func(r *SafeRunner)
+}
+
+// Manager runs scripts concurrently, each with its own SafeRunner, while enforcing a global
+// limit on the number of executions, and lets callers inspect and kill running executions.
+type Manager struct {
+	config    *config.ShellCommandConfig
+	validator *validator.CommandValidator
+	logger    *logger.Logger
+	opts      ManagerOptions
+	slots     chan struct{}
+
+	mu      sync.Mutex
+	queued  int
+	running map[ExecutionID]*execution
+}
+
+// execution is the internal record of a running script.
+type execution struct {
+	info   ExecutionInfo
+	cancel context.CancelCauseFunc
+	// processes maps the PID of each running process to its description
+	processes map[int]ProcessInfo
+}
+
+// NewManager creates a Manager running scripts under cfg.
+func NewManager(cfg *config.ShellCommandConfig, v *validator.CommandValidator, log *logger.Logger, opts ManagerOptions) *Manager {
+	if opts.MaxConcurrent <= 0 {
+		opts.MaxConcurrent = DefaultMaxConcurrentExecutions
+	}
+	return &Manager{
+		config:    cfg,
+		validator: v,
+		logger:    log,
+		opts:      opts,
+		slots:     make(chan struct{}, opts.MaxConcurrent),
+		running:   make(map[ExecutionID]*execution),
+	}
+}
+
+// Execute runs a script like RunScriptStream, writing its output to stdout and stderr, once a
+// slot is free. It waits in the queue while every slot is taken, or fails with
+// ErrTooManyExecutions if the queue is full too; a canceled ctx also ends the wait.
+func (m *Manager) Execute(ctx context.Context, script string, stdout, stderr io.Writer, opts ...ExecOption) RunResult {
+	r := New(m.config, m.validator, m.logger)
+	settings, err := r.resolveOptions(ctx, opts)
+	if err != nil {
+		return RunResult{Err: invalidError(err)}
+	}
+	if m.opts.Setup != nil {
+		m.opts.Setup(r)
+	}
+	return m.execute(ctx, r, script, settings, stdout, stderr)
+}
+
+// execute runs script with r, which has been set up, under a slot of m.
+func (m *Manager) execute(ctx context.Context, r *SafeRunner, script string, settings execSettings, stdout, stderr io.Writer) RunResult {
+	if err := m.acquire(ctx); err != nil {
+		m.logger.LogErrorf("Execution rejected: %v", err)
+		return RunResult{Err: asExitError(err)}
+	}
+	defer func() { <-m.slots }()
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	e := &execution{
+		info:      ExecutionInfo{ID: newExecutionID(), Script: script, WorkDir: settings.workDir, StartedAt: time.Now()},
+		cancel:    cancel,
+		processes: make(map[int]ProcessInfo),
+	}
+	m.mu.Lock()
+	m.running[e.info.ID] = e
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.running, e.info.ID)
+		m.mu.Unlock()
+	}()
+
+	r.SetOutputs(stdout, stderr)
+	finish, err := r.applyOutputs(settings)
+	if err != nil {
+		return RunResult{Err: asExitError(err)}
+	}
+	r.onProcess = func(p ProcessInfo) func() {
+		m.mu.Lock()
+		e.processes[p.PID] = p
+		m.mu.Unlock()
+		return func() {
+			m.mu.Lock()
+			delete(e.processes, p.PID)
+			m.mu.Unlock()
+		}
+	}
+
+	result := r.run(ctx, script, settings)
+	finish(&result)
+	if errors.Is(context.Cause(ctx), ErrExecutionKilled) {
+		result.Err = asExitError(ErrExecutionKilled)
+	}
+	return result
+}
+
+// acquire takes a slot, waiting in the queue if there is room in it.
+func (m *Manager) acquire(ctx context.Context) error {
+	select {
+	case m.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	m.mu.Lock()
+	if m.queued >= m.opts.MaxQueued {
+		m.mu.Unlock()
+		return ErrTooManyExecutions
+	}
+	m.queued++
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		m.queued--
+		m.mu.Unlock()
+	}()
+
+	select {
+	case m.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Running returns the executions currently running, oldest first.
+func (m *Manager) Running() []ExecutionInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	infos := make([]ExecutionInfo, 0, len(m.running))
+	for _, e := range m.running {
+		info := e.info
+		for _, p := range e.processes {
+			info.Processes = append(info.Processes, p)
+		}
+		slices.SortFunc(info.Processes, func(a, b ProcessInfo) int { return a.StartedAt.Compare(b.StartedAt) })
+		infos = append(infos, info)
+	}
+	slices.SortFunc(infos, func(a, b ExecutionInfo) int { return a.StartedAt.Compare(b.StartedAt) })
+	return infos
+}
+
+// Queued returns the number of executions waiting for a slot.
+func (m *Manager) Queued() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.queued
+}
+
+// Kill stops a running execution, terminating its processes as a timeout would. The
+// execution's result fails with ErrExecutionKilled.
+func (m *Manager) Kill(id ExecutionID) error {
+	m.mu.Lock()
+	e, ok := m.running[id]
+	m.mu.Unlock()
+	if !ok {
+		return ErrExecutionNotFound
+	}
+	m.logger.LogInfof("Killing execution %s: %s", id, e.info.Script)
+	e.cancel(ErrExecutionKilled)
+	return nil
+}
+
+// newExecutionID returns a random ExecutionID.
+func newExecutionID() ExecutionID {
+	b := make([]byte, executionIDBytes)
+	_, _ = rand.Read(b)
+	return ExecutionID(hex.EncodeToString(b))
+}
+
+// processStarted reports a started process to the Manager, if any, and returns the function
+// to call once it has exited.
+func (r *SafeRunner) processStarted(pid int, args []string) func() {
+	if r.onProcess == nil {
+		return func() {}
+	}
+	return r.onProcess(ProcessInfo{PID: pid, Command: args[0], Args: args[1:], StartedAt: time.Now()})
+}
@@ -0,0 +1,91 @@
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+// waitForProcess waits until m runs an execution with a running process and returns it.
+func waitForProcess(t *testing.T, m *Manager) ExecutionInfo {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if running := m.Running(); len(running) > 0 && len(running[0].Processes) > 0 {
+			return running[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no process started")
+	return ExecutionInfo{}
+}
+
+func TestManager_LimitAndKill(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, _ := newOptionsTestRunner(t, tmpDir)
+	m := NewManager(r.config, r.validator, r.logger, ManagerOptions{MaxConcurrent: 1})
+
+	done := make(chan RunResult, 1)
+	go func() {
+		done <- m.Execute(t.Context(), "sleep 30", &bytes.Buffer{}, &bytes.Buffer{}, WithWorkdir(tmpDir))
+	}()
+	info := waitForProcess(t, m)
+	assert.Equal(t, "sleep 30", info.Script)
+	assert.Equal(t, tmpDir, info.WorkDir)
+	assert.Equal(t, "sleep", info.Processes[0].Command)
+	assert.Equal(t, []string{"30"}, info.Processes[0].Args)
+	assert.True(t, info.Processes[0].PID > 0)
+
+	// Every slot is taken and nothing may queue
+	result := m.Execute(t.Context(), "echo hi", &bytes.Buffer{}, &bytes.Buffer{}, WithWorkdir(tmpDir))
+	assert.True(t, errors.Is(result.Err, ErrTooManyExecutions))
+
+	assert.True(t, errors.Is(m.Kill("unknown"), ErrExecutionNotFound))
+	assert.NoError(t, m.Kill(info.ID))
+	select {
+	case result = <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("killed execution did not finish")
+	}
+	assert.True(t, errors.Is(result.Err, ErrExecutionKilled))
+	assert.Equal(t, 0, len(m.Running()))
+}
+
+func TestManager_Queue(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, _ := newOptionsTestRunner(t, tmpDir)
+	m := NewManager(r.config, r.validator, r.logger, ManagerOptions{MaxConcurrent: 1, MaxQueued: 1})
+
+	go func() {
+		m.Execute(t.Context(), "sleep 30", &bytes.Buffer{}, &bytes.Buffer{}, WithWorkdir(tmpDir))
+	}()
+	first := waitForProcess(t, m)
+
+	queued := make(chan RunResult, 1)
+	var stdout bytes.Buffer
+	go func() {
+		queued <- m.Execute(t.Context(), "echo queued", &stdout, &bytes.Buffer{}, WithWorkdir(tmpDir))
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for m.Queued() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 1, m.Queued())
+
+	// The queue is full as well
+	result := m.Execute(t.Context(), "echo rejected", &bytes.Buffer{}, &bytes.Buffer{}, WithWorkdir(tmpDir))
+	assert.True(t, errors.Is(result.Err, ErrTooManyExecutions))
+
+	// The queued execution runs once the slot is freed
+	assert.NoError(t, m.Kill(first.ID))
+	select {
+	case result = <-queued:
+	case <-time.After(10 * time.Second):
+		t.Fatal("queued execution did not run")
+	}
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "queued\n", stdout.String())
+}
@@ -0,0 +1,53 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// CommandMetrics records the resources used by a single external command.
+type CommandMetrics struct {
+	// Command is the command as invoked, e.g. "ls" or "/usr/bin/find".
+	Command string
+	Args    []string
+	// WallTime is the time from starting the process until it exited.
+	WallTime time.Duration
+	// UserTime and SystemTime are the CPU time spent by the process.
+	UserTime   time.Duration
+	SystemTime time.Duration
+	// MaxRSS is the peak resident set size in bytes, or 0 if the platform does not report it.
+	MaxRSS   int64
+	ExitCode int
+}
+
+// String formats the metrics for log entries.
+func (m CommandMetrics) String() string {
+	return fmt.Sprintf("wall=%s user=%s sys=%s maxrss=%dKiB exit=%d",
+		m.WallTime.Round(time.Microsecond), m.UserTime, m.SystemTime, m.MaxRSS/bytesPerKiB, m.ExitCode)
+}
+
+// bytesPerKiB converts MaxRSS to the unit used in logs.
+const bytesPerKiB = 1024
+
+// newCommandMetrics builds metrics from a finished process.
+func newCommandMetrics(args []string, wall time.Duration, state *os.ProcessState) CommandMetrics {
+	return CommandMetrics{
+		Command:    args[0],
+		Args:       args[1:],
+		WallTime:   wall,
+		UserTime:   state.UserTime(),
+		SystemTime: state.SystemTime(),
+		MaxRSS:     maxRSS(state),
+		ExitCode:   state.ExitCode(),
+	}
+}
+
+// recordMetrics stores metrics for the current run and writes them to the log.
+func (r *SafeRunner) recordMetrics(m CommandMetrics) {
+	r.metricsMu.Lock()
+	r.metrics = append(r.metrics, m)
+	r.metricsMu.Unlock()
+
+	r.logger.LogCommandMetrics(m.Command, m.Args, m.String())
+}
@@ -0,0 +1,16 @@
+package runner
+
+import (
+	"errors"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+// errNetworkIsolationUnsupported is returned when the platform cannot run a command without network access.
+var errNetworkIsolationUnsupported = errors.New("network isolation is not supported on this system")
+
+// networkDisabled reports whether cmd runs without network access: disableNetwork is set and
+// the command's allowCommands entry is not marked allowNetwork.
+func (r *SafeRunner) networkDisabled(cmd string) bool {
+	return r.config.DisableNetwork && !r.validator.AllowsNetwork(validator.NormalizeCommandName(cmd))
+}
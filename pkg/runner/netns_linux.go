@@ -0,0 +1,62 @@
+//go:build linux
+
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// isolateNetwork moves the calling thread into a new network namespace with only loopback,
+// which the processes it starts inherit. Without CAP_SYS_ADMIN, cmd is instead started in new
+// user and network namespaces, in which loopback exists but is down.
+func isolateNetwork(cmd *exec.Cmd) error {
+	err := unix.Unshare(unix.CLONE_NEWNET)
+	if errors.Is(err, unix.EPERM) {
+		isolateUnprivileged(cmd)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", errNetworkIsolationUnsupported, err)
+	}
+	return bringUpLoopback()
+}
+
+// isolateUnprivileged makes cmd start in new user and network namespaces, mapping the
+// server's user and group to themselves so that files keep their owners.
+func isolateUnprivileged(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		// startProcess only puts the command in a group of its own when it sets no attributes
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	}
+	attr := cmd.SysProcAttr
+	attr.Cloneflags |= syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET
+	attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}}
+	attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}}
+	attr.GidMappingsEnableSetgroups = false
+}
+
+// bringUpLoopback brings up the loopback interface of the calling thread's network namespace,
+// which starts out down.
+func bringUpLoopback() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("network isolation: failed to open socket: %w", err)
+	}
+	defer unix.Close(fd)
+
+	ifr, err := unix.NewIfreq("lo")
+	if err != nil {
+		return fmt.Errorf("network isolation: %w", err)
+	}
+	ifr.SetUint16(unix.IFF_UP | unix.IFF_LOOPBACK | unix.IFF_RUNNING)
+	if err := unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifr); err != nil {
+		return fmt.Errorf("network isolation: failed to bring up loopback: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+//go:build linux
+
+package runner
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+// interfaces returns the network interfaces listed in the contents of /proc/net/dev.
+func interfaces(dev string) []string {
+	var names []string
+	for _, line := range strings.Split(dev, "\n") {
+		if name, _, ok := strings.Cut(line, ":"); ok && !strings.Contains(name, "|") {
+			names = append(names, strings.TrimSpace(name))
+		}
+	}
+	return names
+}
+
+func TestDisableNetwork(t *testing.T) {
+	if _, err := os.Stat("/proc/self/ns/net"); err != nil {
+		t.Skipf("network namespaces unavailable: %v", err)
+	}
+
+	tmpDir := t.TempDir()
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories:  []string{tmpDir, "/proc"},
+		AllowCommands:       []config.AllowCommand{{Command: "cat"}, {Command: "head", AllowNetwork: true}},
+		DenyCommands:        []config.DenyCommand{},
+		DefaultErrorMessage: "Command not allowed",
+		MaxExecutionTime:    10,
+		DisableNetwork:      true,
+	}
+	log := logger.New()
+	r := New(cfg, validator.New(cfg, log), log)
+	var stdout bytes.Buffer
+	r.SetOutputs(&stdout, &bytes.Buffer{})
+
+	result := r.RunCommand(t.Context(), "cat /proc/net/dev", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, []string{"lo"}, interfaces(stdout.String()))
+
+	// A command marked allowNetwork stays in the server's namespace. /proc/self follows the main
+	// thread, which may have been wedged in a restricted namespace, so read this thread's view
+	runtime.LockOSThread()
+	host, err := os.ReadFile("/proc/thread-self/net/dev")
+	runtime.UnlockOSThread()
+	assert.NoError(t, err)
+	stdout.Reset()
+	result = r.RunCommand(t.Context(), "head -n 100 /proc/net/dev", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, interfaces(string(host)), interfaces(stdout.String()))
+
+	if os.Geteuid() == 0 {
+		// Loopback is up, so its addresses are in the local routing table
+		stdout.Reset()
+		result = r.RunCommand(t.Context(), "cat /proc/net/fib_trie", tmpDir)
+		assert.NoError(t, result.Err)
+		assert.Contains(t, stdout.String(), "127.0.0.1")
+	}
+}
+
+func TestIsolateUnprivileged(t *testing.T) {
+	cmd := exec.Command("cat", "/proc/net/dev")
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	isolateUnprivileged(cmd)
+
+	proc, err := startProcess(cmd)
+	if err != nil {
+		t.Skipf("user namespaces unavailable: %v", err)
+	}
+	assert.NoError(t, cmd.Wait())
+	proc.release()
+	assert.Equal(t, []string{"lo"}, interfaces(stdout.String()))
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package runner
+
+import "os/exec"
+
+// isolateNetwork fails because network namespaces are only available on Linux.
+func isolateNetwork(_ *exec.Cmd) error {
+	return errNetworkIsolationUnsupported
+}
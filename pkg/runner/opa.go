@@ -0,0 +1,81 @@
+package runner
+
+import (
+	"context"
+
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/opa"
+)
+
+// loadPolicyEvaluator returns the evaluator configured in opa: the Rego policies of
+// opa.policies and opa.bundle, the OPA server at opa.url, or nil when none is. Policies that
+// cannot be compiled leave every evaluation failing, like an unreachable server.
+func (r *SafeRunner) loadPolicyEvaluator() opa.Evaluator {
+	if !r.config.OPA.Embedded() {
+		if client := opa.New(r.config.OPA); client != nil {
+			return client
+		}
+		return nil
+	}
+	engine, err := opa.LoadEngine(r.config.OPA)
+	if err != nil {
+		r.logger.LogErrorf("Failed to load OPA policies: %v", err)
+		return opa.EvaluatorFunc(func(context.Context, opa.Input) (opa.Decision, error) {
+			return opa.Decision{}, err
+		})
+	}
+	return engine
+}
+
+// SetPolicyEvaluator evaluates every command the static policy allows with e instead of the
+// evaluator configured in opa. A nil e disables it.
+func (r *SafeRunner) SetPolicyEvaluator(e opa.Evaluator) {
+	r.evaluator = e
+}
+
+// evaluatePolicy asks the policy evaluator, if any, whether a command allowed by the static
+// policy may run, returning the denial message when it may not. Evaluation failures deny the
+// command unless opa.failOpen is set.
+func (r *SafeRunner) evaluatePolicy(ctx context.Context, cmd string, args []string) (bool, string) {
+	if r.evaluator == nil {
+		return true, ""
+	}
+	hc := interp.HandlerCtx(ctx)
+	input := opa.Input{
+		Command:  cmd,
+		Args:     args,
+		Cwd:      hc.Dir,
+		Identity: r.identity,
+		Env:      r.exportedEnv(hc.Env),
+	}
+	decision, err := r.evaluator.Evaluate(ctx, input)
+	if err != nil {
+		if r.config.OPA.FailOpen {
+			r.logger.LogErrorf("Policy evaluation of %q failed, allowing it: %v", cmd, err)
+			return true, ""
+		}
+		r.logger.LogErrorf("Policy evaluation of %q failed: %v", cmd, err)
+		return false, r.config.DefaultErrorMessage + ": the policy engine could not decide"
+	}
+	if !decision.Allow {
+		if decision.Reason != "" {
+			return false, decision.Reason
+		}
+		return false, r.config.DefaultErrorMessage
+	}
+	return true, ""
+}
+
+// exportedEnv returns the exported variables of env, with secrets redacted.
+func (r *SafeRunner) exportedEnv(env expand.Environ) map[string]string {
+	vars := make(map[string]string)
+	env.Each(func(name string, vr expand.Variable) bool {
+		if vr.Exported && vr.Kind == expand.String {
+			vars[name] = r.redactor.Redact(vr.Str)
+		}
+		return true
+	})
+	return vars
+}
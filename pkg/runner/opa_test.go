@@ -0,0 +1,88 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
+	"github.com/shimizu1995/secure-shell-server/pkg/opa"
+)
+
+func TestSafeRunner_PolicyEvaluator(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, stdout := newOptionsTestRunner(t, tmpDir)
+
+	var inputs []opa.Input
+	r.SetPolicyEvaluator(opa.EvaluatorFunc(func(_ context.Context, input opa.Input) (opa.Decision, error) {
+		inputs = append(inputs, input)
+		if input.Command == "sleep" {
+			return opa.Decision{}, errors.New("engine unavailable")
+		}
+		if input.Env["STAGE"] == "prod" {
+			return opa.Decision{Allow: false, Reason: "no commands in prod"}, nil
+		}
+		return opa.Decision{Allow: true}, nil
+	}))
+
+	ctx := identity.WithIdentity(t.Context(), identity.Identity{User: "alice"})
+	result := r.Run(ctx, []string{"printenv", "STAGE"}, WithWorkdir(tmpDir), WithEnv("STAGE=dev"))
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "dev\n", stdout.String())
+	assert.Equal(t, 1, len(inputs))
+	assert.Equal(t, opa.Input{
+		Command:  "printenv",
+		Args:     []string{"STAGE"},
+		Cwd:      tmpDir,
+		Identity: identity.Identity{User: "alice"},
+		Env:      inputs[0].Env,
+	}, inputs[0])
+
+	// The static policy is checked first: denied commands never reach the evaluator
+	result = r.RunCommand(t.Context(), "rm -rf x", tmpDir)
+	assert.Equal(t, ExitDenied, ExitCode(result.Err))
+	assert.Equal(t, 1, len(inputs))
+
+	result = r.Run(t.Context(), []string{"printenv"}, WithWorkdir(tmpDir), WithEnv("STAGE=prod"))
+	assert.Equal(t, ExitDenied, ExitCode(result.Err))
+	assert.Contains(t, result.Err.Error(), "no commands in prod")
+
+	// Evaluation failures deny the command unless failOpen is set
+	result = r.RunCommand(t.Context(), "sleep 0", tmpDir)
+	assert.Equal(t, ExitDenied, ExitCode(result.Err))
+	assert.Contains(t, result.Err.Error(), "could not decide")
+
+	r.config.OPA.FailOpen = true
+	assert.NoError(t, r.RunCommand(t.Context(), "sleep 0", tmpDir).Err)
+}
+
+func TestSafeRunner_EmbeddedPolicy(t *testing.T) {
+	tmpDir := t.TempDir()
+	policy := filepath.Join(t.TempDir(), "policy.rego")
+	assert.NoError(t, os.WriteFile(policy, []byte(`package secure_shell
+
+decision := {"allow": false, "reason": "sleeping is not allowed"} if input.command == "sleep"
+
+decision := {"allow": true} if input.command != "sleep"
+`), 0o600))
+	r, _ := newOptionsTestRunner(t, tmpDir)
+	r.config.OPA = config.OPAConfig{Policies: []string{policy}}
+	r.SetPolicyEvaluator(r.loadPolicyEvaluator())
+
+	assert.NoError(t, r.RunCommand(t.Context(), "echo hi", tmpDir).Err)
+	result := r.RunCommand(t.Context(), "sleep 0", tmpDir)
+	assert.Equal(t, ExitDenied, ExitCode(result.Err))
+	assert.Contains(t, result.Err.Error(), "sleeping is not allowed")
+
+	// A policy that does not compile denies every command, unless failOpen is set
+	r.config.OPA = config.OPAConfig{Policies: []string{filepath.Join(tmpDir, "missing.rego")}}
+	r.SetPolicyEvaluator(r.loadPolicyEvaluator())
+	result = r.RunCommand(t.Context(), "echo hi", tmpDir)
+	assert.Equal(t, ExitDenied, ExitCode(result.Err))
+	assert.Contains(t, result.Err.Error(), "could not decide")
+}
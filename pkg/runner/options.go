@@ -0,0 +1,263 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/syntax"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+// ErrOptionNotPermitted is returned when an ExecOption is invalid or exceeds the configured policy.
+var ErrOptionNotPermitted = errors.New("execution option not permitted by policy")
+
+// protectedEnv lists variables that change how executables are found or loaded,
+// which WithEnv may not set.
+var protectedEnv = map[string]bool{
+	"PATH":       true,
+	"IFS":        true,
+	"ENV":        true,
+	"BASH_ENV":   true,
+	"SHELLOPTS":  true,
+	"BASHOPTS":   true,
+	"CDPATH":     true,
+	"GCONV_PATH": true,
+}
+
+// protectedEnvPrefixes lists prefixes of dynamic loader variables, which WithEnv may not set.
+var protectedEnvPrefixes = []string{"LD_", "DYLD_"}
+
+// execSettings are the settings of a single run.
+type execSettings struct {
+	workDir string
+	// timeout of zero means unlimited
+	timeout time.Duration
+	// idleTimeout of zero means a command may go without output indefinitely
+	idleTimeout time.Duration
+	// maxOutput of zero means unlimited
+	maxOutput int
+	// env holds "NAME=value" pairs added to the process environment
+	env []string
+	// lang is the shell dialect the script is parsed in
+	lang syntax.LangVariant
+	// path, when set, replaces the PATH of the process environment
+	path string
+	// outputFile, when set, is the absolute path stdout and stderr are written to instead
+	// of the writers passed to SetOutputs
+	outputFile string
+}
+
+// environ returns the interpreter environment: the process environment with the settings'
+// PATH and variables.
+func (s execSettings) environ() expand.Environ {
+	if len(s.env) == 0 && s.path == "" {
+		return cachedEnviron(os.Environ())
+	}
+	return cachedEnviron(append(baseEnviron(s.path), s.env...))
+}
+
+// defaultSettings returns the settings given by the configuration.
+func (r *SafeRunner) defaultSettings(workDir string) execSettings {
+	return execSettings{
+		workDir:     workDir,
+		timeout:     time.Duration(r.config.MaxExecutionTime) * time.Second,
+		idleTimeout: time.Duration(r.config.IdleTimeout) * time.Second,
+		maxOutput:   r.config.MaxOutputSize,
+		lang:        r.config.Lang(),
+		path:        r.config.BinPath(),
+	}
+}
+
+// execOptions holds the overrides requested by ExecOptions.
+type execOptions struct {
+	workDir    string
+	timeout    *time.Duration
+	maxOutput  *int
+	env        []string
+	dialect    *string
+	outputFile string
+}
+
+// ExecOption overrides a setting for a single call to Run.
+type ExecOption func(*execOptions)
+
+// WithWorkdir runs the command in dir, which must be an allowed directory.
+// Without it, the first allowed directory is used.
+func WithWorkdir(dir string) ExecOption {
+	return func(o *execOptions) { o.workDir = dir }
+}
+
+// WithTimeout limits the execution time. It may not exceed maxExecutionTime when that is set.
+func WithTimeout(d time.Duration) ExecOption {
+	return func(o *execOptions) { o.timeout = &d }
+}
+
+// WithEnv adds "NAME=value" variables to the environment. Variables that affect how
+// executables are found or loaded, such as PATH and LD_PRELOAD, are not permitted.
+func WithEnv(vars ...string) ExecOption {
+	return func(o *execOptions) { o.env = append(o.env, vars...) }
+}
+
+// WithMaxOutput limits stdout and stderr to n bytes each. It may not exceed maxOutputSize when that is set.
+func WithMaxOutput(n int) ExecOption {
+	return func(o *execOptions) { o.maxOutput = &n }
+}
+
+// WithDialect parses the script in the named dialect (config.DialectBash, DialectPOSIX, or
+// DialectMksh) instead of the configured one, so that it is validated with the syntax it was written in.
+func WithDialect(name string) ExecOption {
+	return func(o *execOptions) { o.dialect = &name }
+}
+
+// WithOutputFile writes stdout and stderr to the file at path instead of the writers passed
+// to SetOutputs, so that long build logs are not held in memory. A relative path is resolved
+// against the working directory. The file must be writable under the policy, like the target
+// of a redirection; it is truncated first and limited to maxOutputFileSize bytes.
+func WithOutputFile(path string) ExecOption {
+	return func(o *execOptions) { o.outputFile = path }
+}
+
+// Run runs a single command given as arguments, without shell interpretation of their
+// contents, applying opts on top of the configuration. Options outside the policy bounds
+// fail with ErrOptionNotPermitted. Each call starts new output limiters, so
+// WasOutputTruncated reports on this call alone.
+func (r *SafeRunner) Run(ctx context.Context, args []string, opts ...ExecOption) RunResult {
+	settings, err := r.resolveOptions(ctx, opts)
+	if err != nil {
+		return RunResult{Err: invalidError(err)}
+	}
+	command, err := quoteArgs(args, settings.lang)
+	if err != nil {
+		return RunResult{Err: invalidError(err)}
+	}
+
+	finish, err := r.applyOutputs(settings)
+	if err != nil {
+		return RunResult{Err: asExitError(err)}
+	}
+	result := r.run(ctx, command, settings)
+	finish(&result)
+	return result
+}
+
+// resolveOptions applies opts to the configured settings and checks them against the policy
+// of the caller identified by ctx.
+func (r *SafeRunner) resolveOptions(ctx context.Context, opts []ExecOption) (execSettings, error) {
+	r.identify(ctx)
+	var o execOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	workDir := o.workDir
+	if workDir == "" {
+		if workDir = r.config.DefaultDirectory(); workDir == "" {
+			return execSettings{}, fmt.Errorf("%w: no working directory given and no allowed directories configured", ErrOptionNotPermitted)
+		}
+	}
+	settings := r.defaultSettings(workDir)
+
+	if o.timeout != nil {
+		maxTimeout := time.Duration(r.config.MaxExecutionTime) * time.Second
+		switch {
+		case *o.timeout <= 0:
+			return execSettings{}, fmt.Errorf("%w: timeout must be positive", ErrOptionNotPermitted)
+		case maxTimeout > 0 && *o.timeout > maxTimeout:
+			return execSettings{}, fmt.Errorf("%w: timeout %s exceeds the maximum of %s", ErrOptionNotPermitted, *o.timeout, maxTimeout)
+		}
+		settings.timeout = *o.timeout
+	}
+
+	if o.maxOutput != nil {
+		switch {
+		case *o.maxOutput <= 0:
+			return execSettings{}, fmt.Errorf("%w: max output must be positive", ErrOptionNotPermitted)
+		case r.config.MaxOutputSize > 0 && *o.maxOutput > r.config.MaxOutputSize:
+			return execSettings{}, fmt.Errorf("%w: max output %d exceeds the maximum of %d bytes", ErrOptionNotPermitted, *o.maxOutput, r.config.MaxOutputSize)
+		}
+		settings.maxOutput = *o.maxOutput
+	}
+
+	for _, kv := range o.env {
+		if err := CheckEnv(kv); err != nil {
+			return execSettings{}, err
+		}
+	}
+	settings.env = o.env
+
+	if o.dialect != nil {
+		lang, err := config.ParseDialect(*o.dialect)
+		if err != nil {
+			return execSettings{}, fmt.Errorf("%w: %w", ErrOptionNotPermitted, err)
+		}
+		settings.lang = lang
+	}
+
+	if o.outputFile != "" {
+		path, err := r.checkOutputFile(o.outputFile, workDir)
+		if err != nil {
+			return execSettings{}, err
+		}
+		settings.outputFile = path
+	}
+
+	return settings, nil
+}
+
+// checkOutputFile checks the path passed to WithOutputFile against the policy for files
+// written to and returns it as an absolute path.
+func (r *SafeRunner) checkOutputFile(path, workDir string) (string, error) {
+	if r.config.ReadOnlyOnly {
+		return "", fmt.Errorf("%w: output files are not written in read-only mode", ErrOptionNotPermitted)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(workDir, path)
+	}
+	path = filepath.Clean(path)
+	// Like a redirection, the file must be in an allowed directory and outside the
+	// read-only directories
+	if writable, message := r.validator.CheckWriteTarget(path, workDir); !writable {
+		return "", fmt.Errorf("%w: output file: %s", ErrOptionNotPermitted, message)
+	}
+	return path, nil
+}
+
+// CheckEnv checks a "NAME=value" pair as WithEnv does, failing with ErrOptionNotPermitted.
+func CheckEnv(kv string) error {
+	name, _, ok := strings.Cut(kv, "=")
+	if !ok || !syntax.ValidName(name) {
+		return fmt.Errorf("%w: invalid environment variable %q", ErrOptionNotPermitted, kv)
+	}
+	if protectedEnv[name] {
+		return fmt.Errorf("%w: environment variable %s may not be set", ErrOptionNotPermitted, name)
+	}
+	for _, prefix := range protectedEnvPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return fmt.Errorf("%w: environment variable %s may not be set", ErrOptionNotPermitted, name)
+		}
+	}
+	return nil
+}
+
+// quoteArgs turns arguments into a script in lang running them as a single command.
+func quoteArgs(args []string, lang syntax.LangVariant) (string, error) {
+	if len(args) == 0 {
+		return "", errors.New("no command given")
+	}
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		q, err := syntax.Quote(arg, lang)
+		if err != nil {
+			return "", fmt.Errorf("cannot quote argument %q: %w", arg, err)
+		}
+		quoted[i] = q
+	}
+	return strings.Join(quoted, " "), nil
+}
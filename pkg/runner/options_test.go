@@ -0,0 +1,109 @@
+package runner
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+// newOptionsTestRunner returns a runner that also allows sleep and printenv, writing to the returned buffer.
+func newOptionsTestRunner(t testing.TB, tmpDir string) (*SafeRunner, *bytes.Buffer) {
+	t.Helper()
+	r := newHintTestRunner(t, tmpDir)
+	r.config.AllowCommands = append(r.config.AllowCommands, config.AllowCommand{Command: "sleep"}, config.AllowCommand{Command: "printenv"})
+	var stdout bytes.Buffer
+	r.SetOutputs(&stdout, &bytes.Buffer{})
+	return r, &stdout
+}
+
+func TestRun_QuotesArguments(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, stdout := newOptionsTestRunner(t, tmpDir)
+
+	result := r.Run(t.Context(), []string{"echo", "a; rm -rf /", "$HOME", "it's"})
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "a; rm -rf / $HOME it's\n", stdout.String())
+
+	assert.Error(t, r.Run(t.Context(), nil).Err)
+}
+
+func TestRun_WithWorkdir(t *testing.T) {
+	tmpDir := t.TempDir()
+	sub := filepath.Join(tmpDir, "sub")
+	assert.NoError(t, os.Mkdir(sub, 0o700))
+	assert.NoError(t, os.WriteFile(filepath.Join(sub, "file.txt"), nil, 0o600))
+	r, stdout := newOptionsTestRunner(t, tmpDir)
+
+	result := r.Run(t.Context(), []string{"ls"}, WithWorkdir(sub))
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "file.txt\n", stdout.String())
+
+	// The first allowed directory is the default
+	stdout.Reset()
+	result = r.Run(t.Context(), []string{"ls"})
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "sub\n", stdout.String())
+
+	result = r.Run(t.Context(), []string{"ls"}, WithWorkdir(t.TempDir()))
+	assert.Error(t, result.Err)
+	assert.Contains(t, result.Err.Error(), "directory validation failed")
+}
+
+func TestRun_WithTimeout(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, _ := newOptionsTestRunner(t, tmpDir)
+
+	start := time.Now()
+	result := r.Run(t.Context(), []string{"sleep", "5"}, WithTimeout(100*time.Millisecond))
+	assert.Error(t, result.Err)
+	assert.True(t, time.Since(start) < 4*time.Second, "the timeout was not applied")
+
+	// The configured maximum is 10 seconds
+	result = r.Run(t.Context(), []string{"sleep", "0"}, WithTimeout(time.Minute))
+	assert.IsError(t, result.Err, ErrOptionNotPermitted)
+	result = r.Run(t.Context(), []string{"sleep", "0"}, WithTimeout(0))
+	assert.IsError(t, result.Err, ErrOptionNotPermitted)
+}
+
+func TestRun_WithEnv(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, stdout := newOptionsTestRunner(t, tmpDir)
+
+	result := r.Run(t.Context(), []string{"printenv", "GREETING"}, WithEnv("GREETING=hello world"))
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "hello world\n", stdout.String())
+
+	for _, kv := range []string{"PATH=/tmp", "LD_PRELOAD=/tmp/evil.so", "DYLD_INSERT_LIBRARIES=x", "BASH_ENV=x", "1BAD=x", "NOVALUE"} {
+		result = r.Run(t.Context(), []string{"printenv"}, WithEnv(kv))
+		assert.IsError(t, result.Err, ErrOptionNotPermitted, kv)
+	}
+}
+
+func TestRun_WithMaxOutput(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, stdout := newOptionsTestRunner(t, tmpDir)
+
+	result := r.Run(t.Context(), []string{"echo", "hello world"}, WithMaxOutput(5))
+	assert.NoError(t, result.Err)
+	assert.True(t, strings.HasPrefix(stdout.String(), "hello"))
+	assert.False(t, strings.Contains(stdout.String(), "world"))
+	assert.True(t, r.WasOutputTruncated())
+
+	// Each call starts with fresh limits
+	stdout.Reset()
+	result = r.Run(t.Context(), []string{"echo", "hello world"})
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "hello world\n", stdout.String())
+	assert.False(t, r.WasOutputTruncated())
+
+	// The configured maximum is 1024 bytes
+	result = r.Run(t.Context(), []string{"echo"}, WithMaxOutput(4096))
+	assert.IsError(t, result.Err, ErrOptionNotPermitted)
+}
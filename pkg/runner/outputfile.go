@@ -0,0 +1,74 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// applyOutputs wraps the outputs for a run with settings: the writers passed to SetOutputs
+// with limiters of settings.maxOutput or, with WithOutputFile, the output file. The returned
+// function records the output file in the result, closes it, and restores the outputs.
+func (r *SafeRunner) applyOutputs(settings execSettings) (func(*RunResult), error) {
+	if settings.outputFile == "" {
+		r.limitOutputs(settings.maxOutput)
+		return func(*RunResult) {}, nil
+	}
+
+	f, err := os.OpenFile(settings.outputFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open output file: %w", err)
+	}
+	sink := &outputFile{f: f, max: r.config.OutputFileSize()}
+
+	// The writers set by SetOutputs are used again once the script finishes
+	saved := r.saveOutputs()
+	r.baseStdout, r.baseStderr = sink, sink
+	r.limitOutputs(0)
+	return func(result *RunResult) {
+		r.restoreOutputs(saved)
+		if err := f.Close(); err != nil && result.Err == nil {
+			result.Err = asExitError(fmt.Errorf("failed to write output file: %w", err))
+		}
+		result.OutputFile = settings.outputFile
+		result.OutputFileTruncated = sink.wasTruncated()
+	}, nil
+}
+
+// outputFile writes the output of both streams to a file, up to max bytes.
+type outputFile struct {
+	// mu serializes the writes of the two streams and of pipelines
+	mu        sync.Mutex
+	f         *os.File
+	max       int
+	written   int
+	truncated bool
+}
+
+func (o *outputFile) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.truncated {
+		return len(p), nil
+	}
+	if remaining := o.max - o.written; len(p) > remaining {
+		n, err := o.f.Write(p[:remaining])
+		o.written += n
+		o.truncated = true
+		if err == nil {
+			_, err = fmt.Fprintf(o.f, "\n\n[Output truncated, exceeded %d bytes limit]\n", o.max)
+		}
+		// Output beyond the limit is discarded, which the command need not know about
+		return len(p), err
+	}
+	n, err := o.f.Write(p)
+	o.written += n
+	return n, err
+}
+
+// wasTruncated reports whether output was discarded at the size limit.
+func (o *outputFile) wasTruncated() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.truncated
+}
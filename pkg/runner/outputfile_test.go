@@ -0,0 +1,64 @@
+package runner
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestRun_WithOutputFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, stdout := newOptionsTestRunner(t, tmpDir)
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "build.log"), []byte("old contents\n"), 0o600))
+
+	result := r.Run(t.Context(), []string{"echo", "hello"}, WithOutputFile("build.log"))
+	assert.NoError(t, result.Err)
+	assert.Equal(t, filepath.Join(tmpDir, "build.log"), result.OutputFile)
+	assert.False(t, result.OutputFileTruncated)
+	data, err := os.ReadFile(filepath.Join(tmpDir, "build.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello\n", string(data))
+	assert.Equal(t, "", stdout.String())
+
+	// The writers passed to SetOutputs are used again afterwards
+	assert.NoError(t, r.Run(t.Context(), []string{"echo", "again"}).Err)
+	assert.Equal(t, "again\n", stdout.String())
+}
+
+func TestRun_WithOutputFileLimit(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, _ := newOptionsTestRunner(t, tmpDir)
+	r.config.MaxOutputFileSize = 4
+	path := filepath.Join(tmpDir, "build.log")
+
+	result := r.Run(t.Context(), []string{"echo", "hello world"}, WithOutputFile(path))
+	assert.NoError(t, result.Err)
+	assert.True(t, result.OutputFileTruncated)
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "hell\n\n[Output truncated, exceeded 4 bytes limit]\n", string(data))
+}
+
+func TestRun_WithOutputFileNotPermitted(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, _ := newOptionsTestRunner(t, tmpDir)
+	vendor := filepath.Join(tmpDir, "vendor")
+	assert.NoError(t, os.Mkdir(vendor, 0o755))
+	r.config.ReadOnlyDirectories = []string{vendor}
+
+	for _, path := range []string{filepath.Join(t.TempDir(), "out.log"), "../out.log", "vendor/out.log"} {
+		result := r.Run(t.Context(), []string{"echo", "hello"}, WithOutputFile(path))
+		assert.True(t, errors.Is(result.Err, ErrOptionNotPermitted), path)
+		assert.Equal(t, ExitInvalid, ExitCode(result.Err))
+	}
+	_, err := os.Stat(filepath.Join(vendor, "out.log"))
+	assert.True(t, os.IsNotExist(err))
+
+	result := r.RunScriptStream(t.Context(), "echo hello", func(OutputChunk) error { return nil }, WithOutputFile("out.log"))
+	assert.True(t, errors.Is(result.Err, ErrOptionNotPermitted))
+	assert.True(t, strings.Contains(result.Err.Error(), "output files"))
+}
@@ -0,0 +1,45 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+// checkSetuid denies running the program at path, which args[0] resolved to, if it is a
+// setuid or setgid program and the command's allowCommands entry is not marked allowSetuid.
+// no_new_privs already keeps such programs from gaining privileges, but they are refused
+// outright so that the caller learns why they fail.
+func (r *SafeRunner) checkSetuid(ctx context.Context, args []string, path, workDir string) error {
+	if r.validator.AllowsSetuid(validator.NormalizeCommandName(args[0])) {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() || info.Mode()&(fs.ModeSetuid|fs.ModeSetgid) == 0 {
+		return nil
+	}
+	message := fmt.Sprintf("command %q is a setuid or setgid program: mark its allowCommands entry allowSetuid to run it", args[0])
+	return r.deny(ctx, args[0], args[1:], workDir, message)
+}
+
+// privilegeRestriction returns the restriction setting no_new_privs and dropping every
+// capability not in privileges.keepCapabilities, or nil for a command marked allowSetuid
+// and on systems without capabilities.
+func (r *SafeRunner) privilegeRestriction(cmd string) threadRestriction {
+	if r.validator.AllowsSetuid(validator.NormalizeCommandName(cmd)) {
+		return nil
+	}
+	keep := make([]int, 0, len(r.config.Privileges.KeepCapabilities))
+	for _, name := range r.config.Privileges.KeepCapabilities {
+		// Unknown names were rejected when the configuration was loaded
+		if i := slices.Index(config.Capabilities, name); i >= 0 {
+			keep = append(keep, i)
+		}
+	}
+	return dropPrivileges(keep)
+}
@@ -0,0 +1,82 @@
+//go:build linux
+
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// dropPrivileges returns the restriction setting no_new_privs on the calling thread and
+// dropping every capability but keep from its bounding, effective, permitted, inheritable,
+// and ambient sets, which the processes it starts inherit.
+func dropPrivileges(keep []int) threadRestriction {
+	return func() error {
+		if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+			return fmt.Errorf("failed to set no_new_privs: %w", err)
+		}
+
+		// The bounding set limits what a program run as root gets. Without CAP_SETPCAP it
+		// cannot be changed, but then the server has no capabilities to pass on either.
+		for c := 0; c <= lastCapability(); c++ {
+			if slices.Contains(keep, c) {
+				continue
+			}
+			err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0)
+			if errors.Is(err, unix.EPERM) || errors.Is(err, unix.EINVAL) {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("failed to drop capability %d: %w", c, err)
+			}
+		}
+
+		hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+		var data [2]unix.CapUserData
+		if err := unix.Capget(&hdr, &data[0]); err != nil {
+			return fmt.Errorf("failed to read capabilities: %w", err)
+		}
+		var mask [2]uint32
+		for _, c := range keep {
+			mask[c/32] |= 1 << (c % 32)
+		}
+		for i := range data {
+			data[i].Effective &= mask[i]
+			data[i].Permitted &= mask[i]
+			data[i].Inheritable = data[i].Permitted
+		}
+		if err := unix.Capset(&hdr, &data[0]); err != nil {
+			return fmt.Errorf("failed to drop capabilities: %w", err)
+		}
+
+		// Ambient capabilities are what a program run as another user keeps
+		if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil && !errors.Is(err, unix.EINVAL) {
+			return fmt.Errorf("failed to clear ambient capabilities: %w", err)
+		}
+		for _, c := range keep {
+			if data[c/32].Permitted&(1<<(c%32)) != 0 {
+				_ = unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_RAISE, uintptr(c), 0, 0)
+			}
+		}
+		return nil
+	}
+}
+
+// lastCapability returns the number of the last capability the kernel knows.
+func lastCapability() int {
+	data, err := os.ReadFile("/proc/sys/kernel/cap_last_cap")
+	if err != nil {
+		return unix.CAP_LAST_CAP
+	}
+	last, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return unix.CAP_LAST_CAP
+	}
+	return last
+}
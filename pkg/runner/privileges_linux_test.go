@@ -0,0 +1,102 @@
+//go:build linux
+
+package runner
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+// newPrivilegesTestRunner returns a runner allowing cat, writing to the returned buffer.
+func newPrivilegesTestRunner(t *testing.T, cat config.AllowCommand, privileges config.PrivilegesConfig) (*SafeRunner, *bytes.Buffer) {
+	t.Helper()
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories:  []string{t.TempDir(), "/proc"},
+		AllowCommands:       []config.AllowCommand{cat},
+		DenyCommands:        []config.DenyCommand{},
+		DefaultErrorMessage: "Command not allowed",
+		MaxExecutionTime:    10,
+		Privileges:          privileges,
+	}
+	log := logger.New()
+	r := New(cfg, validator.New(cfg, log), log)
+	var stdout bytes.Buffer
+	r.SetOutputs(&stdout, &stdout)
+	return r, &stdout
+}
+
+// statusField returns the value of a field of /proc/self/status as printed by cat.
+func statusField(t *testing.T, status, name string) string {
+	t.Helper()
+	m := regexp.MustCompile(`(?m)^` + name + `:\s*(\S+)$`).FindStringSubmatch(status)
+	assert.NotZero(t, m, "no %s in %q", name, status)
+	return m[1]
+}
+
+func TestPrivileges_DroppedByDefault(t *testing.T) {
+	r, stdout := newPrivilegesTestRunner(t, config.AllowCommand{Command: "cat"}, config.PrivilegesConfig{})
+	result := r.RunCommand(t.Context(), "cat /proc/self/status", r.config.AllowedDirectories[0])
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "1", statusField(t, stdout.String(), "NoNewPrivs"))
+	assert.Equal(t, "0000000000000000", statusField(t, stdout.String(), "CapEff"))
+	assert.Equal(t, "0000000000000000", statusField(t, stdout.String(), "CapAmb"))
+	if os.Geteuid() == 0 {
+		assert.Equal(t, "0000000000000000", statusField(t, stdout.String(), "CapBnd"))
+	}
+}
+
+func TestPrivileges_KeepCapabilities(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("the server has no capabilities to keep")
+	}
+	privileges := config.PrivilegesConfig{KeepCapabilities: []string{"CAP_NET_RAW", "CAP_NET_BIND_SERVICE"}}
+	r, stdout := newPrivilegesTestRunner(t, config.AllowCommand{Command: "cat"}, privileges)
+	result := r.RunCommand(t.Context(), "cat /proc/self/status", r.config.AllowedDirectories[0])
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "0000000000002400", statusField(t, stdout.String(), "CapEff"))
+	assert.Equal(t, "0000000000002400", statusField(t, stdout.String(), "CapBnd"))
+}
+
+func TestPrivileges_AllowSetuid(t *testing.T) {
+	r, stdout := newPrivilegesTestRunner(t, config.AllowCommand{Command: "cat", AllowSetuid: true}, config.PrivilegesConfig{})
+	result := r.RunCommand(t.Context(), "cat /proc/self/status", r.config.AllowedDirectories[0])
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "0", statusField(t, stdout.String(), "NoNewPrivs"))
+}
+
+func TestPrivileges_SetuidProgramDenied(t *testing.T) {
+	catPath, err := exec.LookPath("cat")
+	if err != nil {
+		t.Skipf("cat unavailable: %v", err)
+	}
+	program, err := os.ReadFile(catPath)
+	assert.NoError(t, err)
+
+	r, stdout := newPrivilegesTestRunner(t, config.AllowCommand{Command: "cat"}, config.PrivilegesConfig{})
+	dir := r.config.AllowedDirectories[0]
+	setuidCat := filepath.Join(dir, "cat")
+	assert.NoError(t, os.WriteFile(setuidCat, program, 0o755))
+	assert.NoError(t, os.Chmod(setuidCat, os.ModeSetuid|0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "file"), []byte("contents\n"), 0o644))
+
+	result := r.RunCommand(t.Context(), setuidCat+" file", dir)
+	assert.Equal(t, ExitDenied, ExitCode(result.Err))
+	assert.Contains(t, result.Err.Error(), `is a setuid or setgid program: mark its allowCommands entry allowSetuid to run it`)
+	assert.Equal(t, "", stdout.String())
+
+	r, stdout = newPrivilegesTestRunner(t, config.AllowCommand{Command: "cat", AllowSetuid: true}, config.PrivilegesConfig{})
+	r.config.AllowedDirectories = []string{dir}
+	result = r.RunCommand(t.Context(), setuidCat+" file", dir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "contents\n", stdout.String())
+}
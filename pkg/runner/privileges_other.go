@@ -0,0 +1,8 @@
+//go:build !linux
+
+package runner
+
+// dropPrivileges returns nil: no_new_privs and capabilities are specific to Linux.
+func dropPrivileges(_ []int) threadRestriction {
+	return nil
+}
@@ -0,0 +1,40 @@
+package runner
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+// proxyVars are the variables through which tools pick up a proxy. Their lowercase forms are
+// honored too, and curl reads http_proxy in lowercase only.
+var proxyVars = []string{"HTTP_PROXY", "HTTPS_PROXY", "FTP_PROXY", "ALL_PROXY", "NO_PROXY"}
+
+// isProxyVar reports whether the "NAME=value" pair kv sets a proxy variable in any case.
+func isProxyVar(kv string) bool {
+	name, _, _ := strings.Cut(kv, "=")
+	return slices.ContainsFunc(proxyVars, func(v string) bool { return strings.EqualFold(v, name) })
+}
+
+// egressEnv applies egressProxy to the environment env of cmd: proxy variables set by the
+// caller, the script, or env are removed, so that they cannot route traffic elsewhere, and a
+// command marked allowNetwork is given the egress proxy.
+func (r *SafeRunner) egressEnv(cmd string, env []string) []string {
+	proxy := r.config.EgressProxy
+	if proxy.URL == "" {
+		return env
+	}
+	env = slices.DeleteFunc(slices.Clone(env), isProxyVar)
+	if !r.validator.AllowsNetwork(validator.NormalizeCommandName(cmd)) {
+		return env
+	}
+	for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "ALL_PROXY"} {
+		env = append(env, name+"="+proxy.URL, strings.ToLower(name)+"="+proxy.URL)
+	}
+	if len(proxy.NoProxy) > 0 {
+		noProxy := strings.Join(proxy.NoProxy, ",")
+		env = append(env, "NO_PROXY="+noProxy, "no_proxy="+noProxy)
+	}
+	return env
+}
@@ -0,0 +1,46 @@
+package runner
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+func TestEgressProxy(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories:  []string{tmpDir},
+		AllowCommands:       []config.AllowCommand{{Command: "printenv", AllowNetwork: true}, {Command: "env"}},
+		DenyCommands:        []config.DenyCommand{},
+		DefaultErrorMessage: "Command not allowed",
+		MaxExecutionTime:    10,
+		Env:                 map[string]config.EnvVar{"NO_PROXY": {Value: "*"}},
+		EgressProxy:         config.EgressProxyConfig{URL: "http://proxy.internal:3128", NoProxy: []string{"localhost", ".internal"}},
+	}
+	log := logger.New()
+	r := New(cfg, validator.New(cfg, log), log)
+	var stdout bytes.Buffer
+	r.SetOutputs(&stdout, &bytes.Buffer{})
+
+	// Commands marked allowNetwork get the egress proxy, whatever the caller or script set
+	result := r.Run(t.Context(), []string{"printenv", "HTTPS_PROXY", "http_proxy", "NO_PROXY"}, WithEnv("HTTPS_PROXY=http://evil.example.com"))
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "http://proxy.internal:3128\nhttp://proxy.internal:3128\nlocalhost,.internal\n", stdout.String())
+
+	stdout.Reset()
+	result = r.RunCommand(t.Context(), "all_proxy=socks5://evil.example.com printenv all_proxy", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "http://proxy.internal:3128\n", stdout.String())
+
+	// Other commands get no proxy variables at all
+	stdout.Reset()
+	result = r.Run(t.Context(), []string{"env"}, WithEnv("HTTPS_PROXY=http://evil.example.com", "ftp_proxy=http://evil.example.com"))
+	assert.NoError(t, result.Err)
+	assert.NotContains(t, stdout.String(), "PROXY=")
+	assert.NotContains(t, stdout.String(), "proxy=")
+}
@@ -0,0 +1,285 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/creack/pty"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+// DefaultTerm is the TERM of commands run in a pseudo-terminal when Terminal.Term is empty.
+const DefaultTerm = "xterm-256color"
+
+// terminalReadSize is the size of the chunks in which terminal input is read.
+const terminalReadSize = 4 * 1024
+
+// WindowSize is the size of a terminal in character cells.
+type WindowSize struct {
+	Rows uint16
+	Cols uint16
+}
+
+// Terminal is the terminal of an interactive caller, such as an SSH client that requested a
+// pty. Commands marked allowPty that RunInteractive starts run in a pseudo-terminal that
+// receives the terminal's input and size; their output goes to the runner's stdout.
+//
+// A Terminal reads its input from the moment it is created, so that nothing typed between
+// two commands is lost: input that no command consumes is returned by Read.
+type Terminal struct {
+	// Term is the TERM of commands run in a pseudo-terminal.
+	Term string
+
+	input    chan []byte
+	inputErr error
+	closed   chan struct{}
+	close    sync.Once
+
+	mu      sync.Mutex
+	size    WindowSize
+	pending []byte
+	// active is the pseudo-terminal of the running command, if any
+	active *os.File
+}
+
+// NewTerminal creates a Terminal of the given size reading from input.
+func NewTerminal(input io.Reader, size WindowSize) *Terminal {
+	t := &Terminal{Term: DefaultTerm, input: make(chan []byte), closed: make(chan struct{}), size: size}
+	go t.readInput(input)
+	return t
+}
+
+// readInput passes the chunks read from input to whoever reads the terminal next.
+func (t *Terminal) readInput(input io.Reader) {
+	// Closing the channel publishes inputErr to readers
+	defer close(t.input)
+	buf := make([]byte, terminalReadSize)
+	for {
+		n, err := input.Read(buf)
+		if n > 0 {
+			select {
+			case t.input <- append([]byte(nil), buf[:n]...):
+			case <-t.closed:
+				t.inputErr = io.ErrClosedPipe
+				return
+			}
+		}
+		if err != nil {
+			t.inputErr = err
+			return
+		}
+	}
+}
+
+// Close stops reading input once the read in progress, if any, returns. It does not close
+// the input.
+func (t *Terminal) Close() {
+	t.close.Do(func() { close(t.closed) })
+}
+
+// Read reads terminal input that was not consumed by a command, e.g. to edit the next line.
+func (t *Terminal) Read(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.pending) == 0 {
+		// Resizes must not wait for input
+		t.mu.Unlock()
+		chunk, ok := <-t.input
+		t.mu.Lock()
+		if !ok {
+			return 0, t.inputErr
+		}
+		t.pending = chunk
+	}
+	n := copy(p, t.pending)
+	t.pending = t.pending[n:]
+	return n, nil
+}
+
+// Size returns the current size of the terminal.
+func (t *Terminal) Size() WindowSize {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.size
+}
+
+// Resize changes the size of the terminal and of the pseudo-terminal of the running command.
+func (t *Terminal) Resize(size WindowSize) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.size = size
+	if t.active != nil {
+		_ = pty.Setsize(t.active, &pty.Winsize{Rows: size.Rows, Cols: size.Cols})
+	}
+}
+
+// attach makes master the pseudo-terminal that receives resizes, and returns the input left
+// over from the last Read.
+func (t *Terminal) attach(master *os.File) []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active = master
+	_ = pty.Setsize(master, &pty.Winsize{Rows: t.size.Rows, Cols: t.size.Cols})
+	pending := t.pending
+	t.pending = nil
+	return pending
+}
+
+// detach stops passing resizes to the pseudo-terminal of the finished command.
+func (t *Terminal) detach() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active = nil
+}
+
+// RunInteractive runs a script like RunScriptStream, except that its output goes to the
+// writers passed to SetOutputs and that commands marked allowPty run in a pseudo-terminal
+// connected to t. Other commands, and allowPty commands whose input or output is redirected
+// or piped, run without input as usual.
+func (r *SafeRunner) RunInteractive(ctx context.Context, script string, t *Terminal, opts ...ExecOption) RunResult {
+	settings, err := r.resolveOptions(ctx, opts)
+	if err != nil {
+		return RunResult{Err: invalidError(err)}
+	}
+	if settings.outputFile != "" {
+		return RunResult{Err: invalidError(fmt.Errorf("%w: output files are not written by interactive sessions", ErrOptionNotPermitted))}
+	}
+
+	r.terminal = t
+	defer func() { r.terminal = nil }()
+	r.limitOutputs(settings.maxOutput)
+	return r.run(ctx, script, settings)
+}
+
+// ptySession connects a command to a pseudo-terminal: it copies the terminal's input to the
+// command and the command's output to the runner's stdout.
+type ptySession struct {
+	terminal *Terminal
+	master   *os.File
+	tty      *os.File
+	out      io.Writer
+	// flush writes output held back by redaction, so that prompts reach the caller
+	flush func()
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newPtySession opens a pseudo-terminal for cmd, whose output is written to out.
+func newPtySession(cmd *exec.Cmd, t *Terminal, out io.Writer, flush func()) (*ptySession, error) {
+	master, tty, err := pty.Open()
+	if err != nil {
+		return nil, err
+	}
+	// The size must be set before the command starts, or it may read a size of zero
+	size := t.Size()
+	_ = pty.Setsize(master, &pty.Winsize{Rows: size.Rows, Cols: size.Cols})
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = tty, tty, tty
+	cmd.Env = append(cmd.Env, "TERM="+t.Term)
+	setControllingTerminal(cmd)
+	return &ptySession{
+		terminal: t, master: master, tty: tty, out: out, flush: flush,
+		stop: make(chan struct{}), done: make(chan struct{}),
+	}, nil
+}
+
+// start begins copying after the command has started.
+func (s *ptySession) start() {
+	// Only the command holds the terminal now, so reading it fails once the command exits
+	_ = s.tty.Close()
+	pending := s.terminal.attach(s.master)
+	go s.copyInput(pending)
+	go s.copyOutput()
+}
+
+// copyInput writes the terminal's input to the command until it exits.
+func (s *ptySession) copyInput(pending []byte) {
+	if len(pending) > 0 {
+		_, _ = s.master.Write(pending)
+	}
+	for {
+		select {
+		case <-s.stop:
+			return
+		case chunk, ok := <-s.terminal.input:
+			if !ok {
+				return
+			}
+			if _, err := s.master.Write(chunk); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// copyOutput writes the command's output to out until the terminal is closed.
+func (s *ptySession) copyOutput() {
+	defer close(s.done)
+	buf := make([]byte, terminalReadSize)
+	for {
+		n, err := s.master.Read(buf)
+		if n > 0 {
+			if _, werr := s.out.Write(buf[:n]); werr != nil {
+				return
+			}
+			s.flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// wait stops copying input and waits up to timeout for the output of the exited command,
+// which processes it left behind may keep open.
+func (s *ptySession) wait(timeout time.Duration) {
+	close(s.stop)
+	select {
+	case <-s.done:
+	case <-time.After(timeout):
+	}
+	s.terminal.detach()
+	_ = s.master.Close()
+	<-s.done
+}
+
+// abort closes the pseudo-terminal of a command that failed to start.
+func (s *ptySession) abort() {
+	_ = s.tty.Close()
+	_ = s.master.Close()
+}
+
+// openPty returns a pseudo-terminal session for cmd when the caller runs
+// interactively, the command is marked allowPty, and its input and output are those of the
+// script rather than redirected or piped. It returns nil otherwise.
+func (r *SafeRunner) openPty(cmd *exec.Cmd, stdin io.Reader, stdout, stderr io.Writer) (*ptySession, error) {
+	if r.terminal == nil || !r.validator.AllowsPty(validator.NormalizeCommandName(cmd.Args[0])) {
+		return nil, nil
+	}
+	if !noInput(stdin) || stdout != r.terminalStdout || stderr != r.terminalStderr {
+		return nil, nil
+	}
+	s, err := newPtySession(cmd, r.terminal, stdout, r.flushOutputs)
+	if errors.Is(err, pty.ErrUnsupported) {
+		r.logger.LogErrorf("Running %s without a pseudo-terminal: %v", cmd.Args[0], err)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pseudo-terminal: %w", err)
+	}
+	return s, nil
+}
+
+// noInput reports whether stdin is the script's own input, which the interpreter leaves unset,
+// rather than a redirection or pipe.
+func noInput(stdin io.Reader) bool {
+	f, ok := stdin.(*os.File)
+	return stdin == nil || (ok && f == nil)
+}
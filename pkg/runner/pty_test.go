@@ -0,0 +1,62 @@
+//go:build !windows
+
+package runner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+func TestRunInteractive_Pty(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, stdout := newOptionsTestRunner(t, tmpDir)
+	for i, allowed := range r.config.AllowCommands {
+		if allowed.Command == "cat" || allowed.Command == "printenv" {
+			r.config.AllowCommands[i].AllowPty = true
+		}
+	}
+	tty := NewTerminal(strings.NewReader("hello\n\x04"), WindowSize{Rows: 24, Cols: 80})
+
+	result := r.RunInteractive(t.Context(), "cat", tty, WithWorkdir(tmpDir))
+	assert.NoError(t, result.Err)
+	// The terminal echoes the input before cat prints it
+	assert.Equal(t, 2, strings.Count(stdout.String(), "hello\r\n"))
+
+	stdout.Reset()
+	result = r.RunInteractive(t.Context(), "printenv TERM", tty, WithWorkdir(tmpDir))
+	assert.NoError(t, result.Err)
+	assert.Equal(t, DefaultTerm+"\r\n", stdout.String())
+
+	// Piped commands keep their pipes
+	stdout.Reset()
+	result = r.RunInteractive(t.Context(), "echo piped | cat", tty, WithWorkdir(tmpDir))
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "piped\n", stdout.String())
+}
+
+func TestRunInteractive_NotAllowed(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, stdout := newOptionsTestRunner(t, tmpDir)
+	tty := NewTerminal(strings.NewReader("hello\n"), WindowSize{Rows: 24, Cols: 80})
+
+	// Commands not marked allowPty run without input
+	result := r.RunInteractive(t.Context(), "cat", tty, WithWorkdir(tmpDir))
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "", stdout.String())
+
+	buf := make([]byte, 16)
+	n, err := tty.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello\n", string(buf[:n]))
+}
+
+func TestAllowsPty(t *testing.T) {
+	r, _ := newOptionsTestRunner(t, t.TempDir())
+	r.config.AllowCommands = append(r.config.AllowCommands, config.AllowCommand{Command: "python3", AllowPty: true})
+	assert.True(t, r.validator.AllowsPty("python3"))
+	assert.False(t, r.validator.AllowsPty("cat"))
+}
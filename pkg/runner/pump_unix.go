@@ -0,0 +1,132 @@
+//go:build !windows
+
+package runner
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// pumpPollInterval bounds how long the pump waits for output before checking whether it was stopped.
+	pumpPollInterval = 100 * time.Millisecond
+	pumpBufferSize   = 32 * 1024
+)
+
+// outputPump copies the stdout and stderr of a process to their writers from a single
+// goroutine, reading whichever pipe is ready first, so the writers receive output in the
+// order the process tree wrote it. With separate copying goroutines, as os/exec uses, a
+// write to stderr can overtake an earlier write to stdout.
+type outputPump struct {
+	dst [2]io.Writer
+	// readers are the pump's ends of the pipes, writers the ends given to the process
+	readers [2]*os.File
+	writers [2]*os.File
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// newOutputPump connects the outputs of cmd to pipes read by a pump. It returns nil when
+// an output is already a file, which the process then writes to directly.
+func newOutputPump(cmd *exec.Cmd, stdout, stderr io.Writer) (*outputPump, error) {
+	if _, ok := stdout.(*os.File); ok {
+		return nil, nil
+	}
+	if _, ok := stderr.(*os.File); ok {
+		return nil, nil
+	}
+
+	p := &outputPump{dst: [2]io.Writer{stdout, stderr}, stop: make(chan struct{}), done: make(chan struct{})}
+	for i := range p.readers {
+		r, w, err := os.Pipe()
+		if err != nil {
+			p.abort()
+			return nil, err
+		}
+		p.readers[i], p.writers[i] = r, w
+	}
+	cmd.Stdout, cmd.Stderr = p.writers[0], p.writers[1]
+	return p, nil
+}
+
+// start begins copying after the process has started.
+func (p *outputPump) start() {
+	// Only the process tree holds the write ends now, so the pipes reach EOF when it exits
+	for _, w := range p.writers {
+		_ = w.Close()
+	}
+	go p.run()
+}
+
+// abort releases the pipes of a process that failed to start.
+func (p *outputPump) abort() {
+	for _, f := range append(p.readers[:], p.writers[:]...) {
+		if f != nil {
+			_ = f.Close()
+		}
+	}
+}
+
+// wait waits for the pump to copy everything after the process has exited. Descendants
+// that still hold the pipes open get timeout to finish; later output is discarded.
+func (p *outputPump) wait(timeout time.Duration) {
+	select {
+	case <-p.done:
+	case <-time.After(timeout):
+		close(p.stop)
+		<-p.done
+	}
+	for _, r := range p.readers {
+		_ = r.Close()
+	}
+}
+
+// run copies output until both pipes reach EOF or the pump is stopped.
+func (p *outputPump) run() {
+	defer close(p.done)
+
+	fds := make([]unix.PollFd, len(p.readers))
+	for i, r := range p.readers {
+		fds[i] = unix.PollFd{Fd: int32(r.Fd()), Events: unix.POLLIN} //nolint:gosec // file descriptors fit in int32
+	}
+	buf := make([]byte, pumpBufferSize)
+	open := len(fds)
+	for open > 0 {
+		select {
+		case <-p.stop:
+			return
+		default:
+		}
+
+		n, err := unix.Poll(fds, int(pumpPollInterval/time.Millisecond))
+		if errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		if n == 0 {
+			continue
+		}
+		for i := range fds {
+			if fds[i].Fd < 0 || fds[i].Revents == 0 {
+				continue
+			}
+			nr, err := unix.Read(int(fds[i].Fd), buf)
+			if nr > 0 {
+				// Keep draining after a write error so the process does not block on a full pipe
+				_, _ = p.dst[i].Write(buf[:nr])
+			}
+			if nr == 0 || (err != nil && !errors.Is(err, unix.EINTR) && !errors.Is(err, unix.EAGAIN)) {
+				// poll ignores negative descriptors
+				fds[i].Fd = -1
+				open--
+			}
+		}
+	}
+}
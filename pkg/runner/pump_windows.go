@@ -0,0 +1,21 @@
+package runner
+
+import (
+	"io"
+	"os/exec"
+	"time"
+)
+
+// outputPump is not used on Windows, where os/exec copies each output in its own goroutine.
+type outputPump struct{}
+
+// newOutputPump returns nil, leaving the outputs of cmd to os/exec.
+func newOutputPump(_ *exec.Cmd, _, _ io.Writer) (*outputPump, error) {
+	return nil, nil
+}
+
+func (p *outputPump) start() {}
+
+func (p *outputPump) abort() {}
+
+func (p *outputPump) wait(_ time.Duration) {}
@@ -0,0 +1,146 @@
+package runner
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrQuotaExceeded is the error of executions rejected because their caller has used up one of
+// its daily quotas.
+var ErrQuotaExceeded = errors.New("daily quota exceeded")
+
+// QuotaUsage is what a caller used towards its quotas on a day.
+type QuotaUsage struct {
+	// Caller is the key of the caller's identity (see identity.Identity.Key).
+	Caller string `json:"caller"`
+	// Day is the UTC date the usage accrued on, as YYYY-MM-DD.
+	Day string `json:"day"`
+	// CPUSeconds is the user and system CPU time of the caller's external commands.
+	CPUSeconds float64 `json:"cpuSeconds"`
+	// OutputBytes is the output the caller's executions wrote, before truncation.
+	OutputBytes int64 `json:"outputBytes"`
+	// Executions is the number of scripts the caller ran.
+	Executions int `json:"executions"`
+}
+
+// quotas is the process-wide usage behind Quotas. Like the kill switch, it is shared by every
+// runner, so that a caller's usage adds up across servers and sessions. Only the current day
+// is kept.
+var quotas = struct {
+	mu    sync.Mutex
+	now   func() time.Time
+	day   string
+	usage map[string]*QuotaUsage
+}{now: time.Now, usage: make(map[string]*QuotaUsage)}
+
+// Quotas returns today's usage of every caller that ran an execution today, sorted by caller.
+func Quotas() []QuotaUsage {
+	quotas.mu.Lock()
+	defer quotas.mu.Unlock()
+	startQuotaDay()
+	usage := make([]QuotaUsage, 0, len(quotas.usage))
+	for _, u := range quotas.usage {
+		usage = append(usage, *u)
+	}
+	slices.SortFunc(usage, func(a, b QuotaUsage) int { return cmp.Compare(a.Caller, b.Caller) })
+	return usage
+}
+
+// QuotaUsageOf returns today's usage of caller.
+func QuotaUsageOf(caller string) QuotaUsage {
+	quotas.mu.Lock()
+	defer quotas.mu.Unlock()
+	return *quotaUsage(caller)
+}
+
+// ResetQuota clears today's usage of caller, giving it its full quotas again.
+func ResetQuota(caller string) {
+	quotas.mu.Lock()
+	defer quotas.mu.Unlock()
+	startQuotaDay()
+	delete(quotas.usage, caller)
+}
+
+// startQuotaDay drops the usage of previous days. quotas.mu must be held.
+func startQuotaDay() {
+	day := quotas.now().UTC().Format(time.DateOnly)
+	if day != quotas.day {
+		quotas.day = day
+		clear(quotas.usage)
+	}
+}
+
+// quotaUsage returns today's usage of caller, which quotas.mu must be held to access.
+func quotaUsage(caller string) *QuotaUsage {
+	startQuotaDay()
+	u, ok := quotas.usage[caller]
+	if !ok {
+		u = &QuotaUsage{Caller: caller, Day: quotas.day}
+		quotas.usage[caller] = u
+	}
+	return u
+}
+
+// checkQuota returns an error wrapping ErrQuotaExceeded if the caller has used up any of its
+// quotas today. Executions without a caller identity are not accounted.
+func (r *SafeRunner) checkQuota() error {
+	q := r.config.Quota
+	if r.caller == "" || !q.Enabled() {
+		return nil
+	}
+	quotas.mu.Lock()
+	u := *quotaUsage(r.caller)
+	quotas.mu.Unlock()
+
+	switch {
+	case q.MaxExecutions > 0 && u.Executions >= q.MaxExecutions:
+		return fmt.Errorf("%w: caller %q has run %d executions today (quota.maxExecutions)", ErrQuotaExceeded, r.caller, u.Executions)
+	case q.MaxCPUSeconds > 0 && u.CPUSeconds >= float64(q.MaxCPUSeconds):
+		return fmt.Errorf("%w: caller %q has used %.1f CPU seconds today (quota.maxCpuSeconds)", ErrQuotaExceeded, r.caller, u.CPUSeconds)
+	case q.MaxOutputBytes > 0 && u.OutputBytes >= q.MaxOutputBytes:
+		return fmt.Errorf("%w: caller %q has written %d bytes of output today (quota.maxOutputBytes)", ErrQuotaExceeded, r.caller, u.OutputBytes)
+	}
+	return nil
+}
+
+// chargeQuota adds an execution, the CPU time of its commands, and the output counted by
+// countOutput to the caller's usage.
+func (r *SafeRunner) chargeQuota(metrics []CommandMetrics) {
+	if r.caller == "" {
+		return
+	}
+	var cpu time.Duration
+	for _, m := range metrics {
+		cpu += m.UserTime + m.SystemTime
+	}
+	quotas.mu.Lock()
+	defer quotas.mu.Unlock()
+	u := quotaUsage(r.caller)
+	u.Executions++
+	u.CPUSeconds += cpu.Seconds()
+	u.OutputBytes += r.output.Swap(0)
+}
+
+// countOutput returns a writer to w that counts what is written towards the caller's quota.
+func (r *SafeRunner) countOutput(w io.Writer) io.Writer {
+	return &countingWriter{w: w, n: &r.output}
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+// Write implements the io.Writer interface.
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
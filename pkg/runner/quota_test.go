@@ -0,0 +1,104 @@
+package runner
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+// setQuotaClock makes the quota accounting see now, for the duration of the test.
+func setQuotaClock(t *testing.T, now *time.Time) {
+	t.Helper()
+	quotas.mu.Lock()
+	quotas.now = func() time.Time { return *now }
+	quotas.mu.Unlock()
+	t.Cleanup(func() {
+		quotas.mu.Lock()
+		quotas.now = time.Now
+		quotas.mu.Unlock()
+	})
+}
+
+// newQuotaRunner returns a runner of caller limited by quota.
+func newQuotaRunner(t *testing.T, caller string, quota config.QuotaConfig) *SafeRunner {
+	t.Helper()
+	cfg := setupCustomConfig()
+	cfg.AllowedDirectories = []string{t.TempDir()}
+	cfg.Quota = quota
+	log := logger.New()
+	r := New(cfg, validator.New(cfg, log), log)
+	r.SetOutputs(io.Discard, io.Discard)
+	r.SetIdentity(identity.Identity{User: caller})
+	return r
+}
+
+func TestSafeRunner_QuotaExecutions(t *testing.T) {
+	now := time.Date(2026, 3, 1, 23, 0, 0, 0, time.UTC)
+	setQuotaClock(t, &now)
+	r := newQuotaRunner(t, "quota-executions", config.QuotaConfig{MaxExecutions: 2})
+	dir := r.config.AllowedDirectories[0]
+
+	assert.NoError(t, r.RunCommand(t.Context(), "echo one", dir).Err)
+	// Denied scripts count as executions too
+	assert.Error(t, r.RunCommand(t.Context(), "rm -rf x", dir).Err)
+	result := r.RunCommand(t.Context(), "echo three", dir)
+	assert.True(t, errors.Is(result.Err, ErrQuotaExceeded))
+	assert.Contains(t, result.Err.Error(), `caller "quota-executions" has run 2 executions today (quota.maxExecutions)`)
+
+	usage := QuotaUsageOf("quota-executions")
+	assert.Equal(t, QuotaUsage{Caller: "quota-executions", Day: "2026-03-01", OutputBytes: 4, Executions: 2}, usage)
+	assert.True(t, slices.ContainsFunc(Quotas(), func(u QuotaUsage) bool { return u.Caller == "quota-executions" }))
+
+	// The quota starts over the next day (UTC)
+	now = now.Add(2 * time.Hour)
+	assert.NoError(t, r.RunCommand(t.Context(), "echo four", dir).Err)
+	assert.Equal(t, 1, QuotaUsageOf("quota-executions").Executions)
+
+	// Operators can reset a caller's usage
+	assert.Error(t, r.RunCommand(t.Context(), "rm -rf x", dir).Err)
+	ResetQuota("quota-executions")
+	assert.NoError(t, r.RunCommand(t.Context(), "echo five", dir).Err)
+}
+
+func TestSafeRunner_QuotaOutput(t *testing.T) {
+	r := newQuotaRunner(t, "quota-output", config.QuotaConfig{MaxOutputBytes: 10})
+	dir := r.config.AllowedDirectories[0]
+
+	// The execution that crosses the quota completes; the next one is rejected
+	assert.NoError(t, r.RunCommand(t.Context(), "echo hello; echo world", dir).Err)
+	assert.Equal(t, int64(12), QuotaUsageOf("quota-output").OutputBytes)
+	result := r.RunCommand(t.Context(), "echo again", dir)
+	assert.True(t, errors.Is(result.Err, ErrQuotaExceeded))
+	assert.Contains(t, result.Err.Error(), "has written 12 bytes of output today (quota.maxOutputBytes)")
+}
+
+func TestSafeRunner_QuotaCPU(t *testing.T) {
+	r := newQuotaRunner(t, "quota-cpu", config.QuotaConfig{MaxCPUSeconds: 1})
+
+	r.chargeQuota([]CommandMetrics{{UserTime: 700 * time.Millisecond, SystemTime: 400 * time.Millisecond}})
+	err := r.checkQuota()
+	assert.True(t, errors.Is(err, ErrQuotaExceeded))
+	assert.Contains(t, err.Error(), "has used 1.1 CPU seconds today (quota.maxCpuSeconds)")
+}
+
+func TestSafeRunner_QuotaAnonymous(t *testing.T) {
+	cfg := setupCustomConfig()
+	cfg.AllowedDirectories = []string{t.TempDir()}
+	cfg.Quota = config.QuotaConfig{MaxExecutions: 1}
+	log := logger.New()
+	r := New(cfg, validator.New(cfg, log), log)
+	r.SetOutputs(io.Discard, io.Discard)
+
+	// Executions without a caller identity are not accounted
+	assert.NoError(t, r.RunCommand(t.Context(), "echo one", cfg.AllowedDirectories[0]).Err)
+	assert.NoError(t, r.RunCommand(t.Context(), "echo two", cfg.AllowedDirectories[0]).Err)
+}
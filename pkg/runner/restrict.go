@@ -0,0 +1,34 @@
+package runner
+
+import (
+	"os/exec"
+	"runtime"
+)
+
+// threadRestriction restricts the calling OS thread, and with it every process the thread starts.
+type threadRestriction func() error
+
+// startRestricted starts cmd from a dedicated OS thread to which restrictions have been
+// applied in order, so that only the child process inherits them.
+func startRestricted(cmd *exec.Cmd, restrictions []threadRestriction) (*process, error) {
+	type started struct {
+		proc *process
+		err  error
+	}
+	ch := make(chan started, 1)
+	go func() {
+		// The thread is never unlocked, so it exits with this goroutine instead of
+		// returning to the scheduler with the restrictions in place
+		runtime.LockOSThread()
+		for _, restrict := range restrictions {
+			if err := restrict(); err != nil {
+				ch <- started{err: err}
+				return
+			}
+		}
+		proc, err := startProcess(cmd)
+		ch <- started{proc: proc, err: err}
+	}()
+	s := <-ch
+	return s.proc, s.err
+}
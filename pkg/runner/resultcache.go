@@ -0,0 +1,124 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+
+	"mvdan.cc/sh/v3/interp"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/resultcache"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+// SetResultCache caches the results of commands marked cacheable in c instead of the runner's
+// own cache. Servers share one cache so that later requests reuse the results.
+func (r *SafeRunner) SetResultCache(c *resultcache.Cache) {
+	r.resultCache = c
+}
+
+// cacheable reports whether the result of args can be taken from the cache. Commands reading
+// standard input and commands connected to a terminal are never cached, as their output
+// depends on more than their arguments.
+func (r *SafeRunner) cacheable(hc interp.HandlerContext, args []string) bool {
+	return r.resultCache != nil && r.terminal == nil && hc.Stdin == nil &&
+		r.validator.IsCacheable(validator.NormalizeCommandName(args[0]), args[1:])
+}
+
+// execCached replays the cached result of args, or runs the command and caches its result.
+// Results of commands that did not exit on their own, e.g. because they timed out, are not cached.
+func (r *SafeRunner) execCached(ctx context.Context, hc interp.HandlerContext, args []string) error {
+	if res, ok := r.resultCache.Get(hc.Dir, args); ok {
+		_, span := r.startSpan(ctx, spanExec, attrCommandName.String(args[0]), attrCached.Bool(true))
+		err := replayResult(hc, res)
+		r.recordExecution(ctx, args, hc.Dir, err, 0)
+		endSpan(span, err)
+		return err
+	}
+
+	rec := &resultRecorder{maxSize: r.resultCache.MaxEntrySize()}
+	recorded := hc
+	recorded.Stdout = rec.writer(hc.Stdout, resultcache.Stdout)
+	recorded.Stderr = rec.writer(hc.Stderr, resultcache.Stderr)
+	err := r.execCommand(ctx, recorded, args)
+
+	if rec.overflow || ctx.Err() != nil || r.interruption() != nil {
+		return err
+	}
+	if err == nil {
+		r.resultCache.Put(hc.Dir, args, resultcache.Result{Chunks: rec.chunks})
+	} else if status, ok := interp.IsExitStatus(err); ok {
+		r.resultCache.Put(hc.Dir, args, resultcache.Result{Chunks: rec.chunks, Status: status})
+	}
+	return err
+}
+
+// replayResult writes the output of res to the streams of hc and returns its exit status.
+func replayResult(hc interp.HandlerContext, res resultcache.Result) error {
+	for _, c := range res.Chunks {
+		w := hc.Stdout
+		if c.Stream == resultcache.Stderr {
+			w = hc.Stderr
+		}
+		if _, err := w.Write(c.Data); err != nil {
+			return err
+		}
+	}
+	if res.Status != 0 {
+		return interp.NewExitStatus(res.Status)
+	}
+	return nil
+}
+
+// invalidateCache drops the cached results after a command not marked cacheable ran, since it
+// may have changed what the cached commands would print.
+func (r *SafeRunner) invalidateCache() {
+	if r.resultCache != nil {
+		r.resultCache.Purge()
+	}
+}
+
+// resultRecorder records the output of a command in the order it was written, up to maxSize
+// bytes. Pipelines write both streams concurrently.
+type resultRecorder struct {
+	maxSize int
+
+	mu       sync.Mutex
+	chunks   []resultcache.Chunk
+	size     int
+	overflow bool
+}
+
+// writer returns w recording what is written to it as output of stream.
+func (rec *resultRecorder) writer(w io.Writer, stream resultcache.Stream) io.Writer {
+	return &recordingWriter{w: w, rec: rec, stream: stream}
+}
+
+// add records p as output of stream.
+func (rec *resultRecorder) add(stream resultcache.Stream, p []byte) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.overflow {
+		return
+	}
+	rec.size += len(p)
+	if rec.size > rec.maxSize {
+		rec.overflow, rec.chunks = true, nil
+		return
+	}
+	rec.chunks = append(rec.chunks, resultcache.Chunk{Stream: stream, Data: bytes.Clone(p)})
+}
+
+// recordingWriter passes writes to w and records them in rec.
+type recordingWriter struct {
+	w      io.Writer
+	rec    *resultRecorder
+	stream resultcache.Stream
+}
+
+// Write implements io.Writer.
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+	rw.rec.add(rw.stream, p)
+	return rw.w.Write(p)
+}
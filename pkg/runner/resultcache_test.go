@@ -0,0 +1,69 @@
+package runner
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+func TestSafeRunner_CachesResults(t *testing.T) {
+	tmpDir := t.TempDir()
+	file := filepath.Join(tmpDir, "file.txt")
+	assert.NoError(t, os.WriteFile(file, []byte("first\n"), 0o600))
+
+	cfg := config.NewDefaultConfig()
+	cfg.AllowedDirectories = []string{tmpDir}
+	cfg.AllowCommands = []config.AllowCommand{
+		{Command: "cat", Cacheable: true},
+		{Command: "echo"},
+		{Command: "touch"},
+	}
+	log := logger.New()
+	r := New(cfg, validator.New(cfg, log), log)
+
+	run := func(command string) (string, string, error) {
+		t.Helper()
+		var stdout, stderr bytes.Buffer
+		r.SetOutputs(&stdout, &stderr)
+		result := r.RunCommand(t.Context(), command, tmpDir)
+		return stdout.String(), stderr.String(), result.Err
+	}
+
+	out, _, err := run("cat file.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "first\n", out)
+
+	// The cached result is returned although the file changed
+	assert.NoError(t, os.WriteFile(file, []byte("second\n"), 0o600))
+	out, _, err = run("cat file.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "first\n", out)
+
+	// Standard input is not part of the key, so commands reading it are not cached
+	out, _, err = run("echo piped | cat")
+	assert.NoError(t, err)
+	assert.Equal(t, "piped\n", out)
+
+	// A command not marked cacheable clears the cache
+	_, _, err = run("touch other.txt")
+	assert.NoError(t, err)
+	out, _, err = run("cat file.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "second\n", out)
+
+	// Failures are replayed with their output and exit status
+	_, stderr, err := run("cat missing.txt")
+	assert.Error(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "missing.txt"), []byte("found\n"), 0o600))
+	out, cachedStderr, cachedErr := run("cat missing.txt")
+	assert.Equal(t, "", out)
+	assert.Equal(t, stderr, cachedStderr)
+	assert.Equal(t, err.Error(), cachedErr.Error())
+}
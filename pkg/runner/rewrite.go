@@ -0,0 +1,18 @@
+package runner
+
+import (
+	"slices"
+)
+
+// rewrite replaces an allowed command with the words its rewrite rule maps it to, if any,
+// keeping its arguments. name is the command name the rule is looked up by. The original and
+// the rewritten command are logged together.
+func (r *SafeRunner) rewrite(name string, args []string) []string {
+	to, ok := r.config.Rewrites[name]
+	if !ok {
+		return args
+	}
+	rewritten := append(slices.Clone(to), args[1:]...)
+	r.logger.LogCommandRewrite(args[0], args[1:], rewritten)
+	return rewritten
+}
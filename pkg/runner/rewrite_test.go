@@ -0,0 +1,40 @@
+package runner
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+func TestSafeRunner_Rewrites(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := setupCustomConfig()
+	cfg.AllowedDirectories = []string{tmpDir}
+	cfg.Rewrites = map[string][]string{
+		"cat": {"echo", "read:"},
+		"rm":  {"echo", "trashed:"},
+	}
+	var logs bytes.Buffer
+	log := logger.NewWithWriter(&logs)
+	r := New(cfg, validator.New(cfg, log), log)
+	var stdout strings.Builder
+	r.SetOutputs(&stdout, io.Discard)
+
+	// The allowed command runs in its rewritten form, with its arguments
+	result := r.RunCommand(t.Context(), "cat notes.txt | grep read", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "read: notes.txt\n", stdout.String())
+	assert.Contains(t, logs.String(), "[REWRITTEN] Command: cat [notes.txt], runs as [echo read: notes.txt]")
+
+	// Rewrites apply after validation, so they do not make denied commands run
+	stdout.Reset()
+	result = r.RunCommand(t.Context(), "rm -rf build", tmpDir)
+	assert.Equal(t, ExitDenied, ExitCode(result.Err))
+	assert.Equal(t, "", stdout.String())
+}
@@ -0,0 +1,162 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"mvdan.cc/sh/v3/interp"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+// Phases of a rule hook, passed to RuleHookFunc.
+const (
+	HookPre  = "pre"
+	HookPost = "post"
+)
+
+// RuleHookFunc is a Go function run as a hook of allowCommands rules (see config.RuleHooks).
+// phase is HookPre or HookPost; err is the command's error in a post hook and nil otherwise.
+// Returning an error fails the hook.
+type RuleHookFunc func(ctx context.Context, phase string, ec *ExecContext, err error) error
+
+// ruleHookFuncs holds the functions registered with RegisterRuleHook. Like the kill switch,
+// it is process-wide, since servers create a runner for every request.
+var ruleHookFuncs = struct {
+	mu    sync.RWMutex
+	funcs map[string]RuleHookFunc
+}{funcs: make(map[string]RuleHookFunc)}
+
+// RegisterRuleHook makes fn available to hooks with the callback name. It panics if fn is nil
+// or the name is already registered.
+func RegisterRuleHook(name string, fn RuleHookFunc) {
+	ruleHookFuncs.mu.Lock()
+	defer ruleHookFuncs.mu.Unlock()
+	if fn == nil {
+		panic("runner: RegisterRuleHook of nil function " + name)
+	}
+	if _, dup := ruleHookFuncs.funcs[name]; dup {
+		panic("runner: RegisterRuleHook called twice for " + name)
+	}
+	ruleHookFuncs.funcs[name] = fn
+}
+
+// lookupRuleHook returns the function registered with name.
+func lookupRuleHook(name string) (RuleHookFunc, bool) {
+	ruleHookFuncs.mu.RLock()
+	defer ruleHookFuncs.mu.RUnlock()
+	fn, ok := ruleHookFuncs.funcs[name]
+	return fn, ok
+}
+
+// withRuleHooks runs exec between the pre and post hooks of the command's rule. A failed pre
+// hook fails the command with the hook's exit status without running it; a failed post hook
+// fails a command that succeeded.
+func (r *SafeRunner) withRuleHooks(ctx context.Context, hc interp.HandlerContext, args []string, exec func() error) error {
+	if r.inHook {
+		return exec()
+	}
+	hooks := r.validator.Hooks(validator.NormalizeCommandName(args[0]))
+	if len(hooks.Pre) == 0 && len(hooks.Post) == 0 {
+		return exec()
+	}
+
+	ec := &ExecContext{Command: args[0], Args: args[1:], WorkDir: hc.Dir}
+	for _, hook := range hooks.Pre {
+		if err := r.runRuleHook(ctx, hc, HookPre, hook, ec, nil); err != nil {
+			return err
+		}
+	}
+
+	err := exec()
+
+	// Release what the pre hooks acquired even if the command was stopped
+	postCtx := context.WithoutCancel(ctx)
+	for _, hook := range hooks.Post {
+		if hookErr := r.runRuleHook(postCtx, hc, HookPost, hook, ec, err); hookErr != nil && err == nil {
+			err = hookErr
+		}
+	}
+	return err
+}
+
+// runRuleHook runs a hook within its timeout. A failure is reported on the command's stderr
+// and returned as the exit status the command fails with.
+func (r *SafeRunner) runRuleHook(ctx context.Context, hc interp.HandlerContext, phase string, hook config.RuleHook, ec *ExecContext, cmdErr error) error {
+	timeout := time.Duration(hook.Timeout) * time.Second
+	if hook.Timeout == 0 {
+		timeout = config.DefaultHookTimeout * time.Second
+	}
+
+	var err error
+	if hook.Callback != "" {
+		err = r.runHookCallback(ctx, timeout, phase, hook.Callback, ec, cmdErr)
+	} else {
+		settings := r.defaultSettings(hc.Dir)
+		settings.timeout = timeout
+		err = r.hookRunner(hc.Stderr).run(ctx, hook.Command, settings).Err
+	}
+	if err == nil {
+		return nil
+	}
+
+	name := hook.Callback
+	if name == "" {
+		name = fmt.Sprintf("%q", hook.Command)
+	}
+	r.logger.LogErrorf("%s hook %s of %s failed: %v", phase, name, ec.Command, err)
+	fmt.Fprintf(hc.Stderr, "%s hook %s of %s failed: %v\n", phase, name, ec.Command, err)
+	code := ExitCode(err)
+	if code > 255 {
+		code = ExitFailure
+	}
+	return interp.NewExitStatus(uint8(code))
+}
+
+// runHookCallback calls the registered function name within timeout.
+func (r *SafeRunner) runHookCallback(ctx context.Context, timeout time.Duration, phase, name string, ec *ExecContext, cmdErr error) error {
+	fn, ok := lookupRuleHook(name)
+	if !ok {
+		return fmt.Errorf("no hook is registered as %q", name)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := fn(ctx, phase, ec, cmdErr); err != nil {
+		if ctx.Err() != nil {
+			return &ExitError{Code: ExitTimeout, Err: err}
+		}
+		return err
+	}
+	return nil
+}
+
+// hookRunner returns a runner for the scripts of hooks, writing their output to stderr so
+// that it does not mix with the command's own output. It validates with the policy of r and
+// calls the same BeforeExec and AfterExec hooks, but runs no rule hooks. A hook is part of the
+// command it surrounds, so it gets no workspace, snapshot, or file audit of its own.
+func (r *SafeRunner) hookRunner(stderr io.Writer) *SafeRunner {
+	cfg := *r.config
+	cfg.Scratch.Enabled = false
+	cfg.Snapshot.Enabled = false
+	cfg.FileAudit.Enabled = false
+	cfg.OutputSpool.Enabled = false
+
+	hr := New(&cfg, r.validator, r.logger)
+	hr.inHook = true
+	hr.hooks = r.hooks
+	hr.tracer = r.tracer
+	hr.approvals = r.approvals
+	hr.confirm = r.confirm
+	hr.history, hr.caller = r.history, r.caller
+	hr.identity = r.identity
+	hr.alerter = r.alerter
+	hr.auditor = r.auditor
+	hr.evaluator = r.evaluator
+	hr.onProcess = r.onProcess
+	hr.SetOutputs(stderr, stderr)
+	return hr
+}
@@ -0,0 +1,107 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+func TestSafeRunner_RuleHooks(t *testing.T) {
+	tmpDir := t.TempDir()
+	lock := filepath.Join(tmpDir, "lock")
+
+	var calls []string
+	RegisterRuleHook("test-record", func(_ context.Context, phase string, ec *ExecContext, err error) error {
+		_, statErr := os.Stat(lock)
+		calls = append(calls, phase+" "+ec.Command+" "+ec.Args[0])
+		if statErr != nil {
+			calls = append(calls, "not locked")
+		}
+		if phase == HookPost && err != nil {
+			calls = append(calls, "failed")
+		}
+		return nil
+	})
+
+	cfg := config.NewDefaultConfig()
+	cfg.AllowedDirectories = []string{tmpDir}
+	cfg.AllowCommands = []config.AllowCommand{
+		{Command: "mkdir"},
+		{Command: "rmdir"},
+		{Command: "cat", Hooks: config.RuleHooks{
+			Pre:  []config.RuleHook{{Command: "mkdir lock"}, {Callback: "test-record"}},
+			Post: []config.RuleHook{{Callback: "test-record"}, {Command: "rmdir lock"}},
+		}},
+		{Command: "wc", Hooks: config.RuleHooks{
+			Pre: []config.RuleHook{{Command: "rm -rf /"}},
+		}},
+	}
+	log := logger.New()
+	r := New(cfg, validator.New(cfg, log), log)
+
+	run := func(command string) (string, string, error) {
+		t.Helper()
+		var stdout, stderr bytes.Buffer
+		r.SetOutputs(&stdout, &stderr)
+		result := r.RunCommand(t.Context(), command, tmpDir)
+		return stdout.String(), stderr.String(), result.Err
+	}
+
+	// The lock is held while the command runs and released afterwards, also when it fails
+	_, _, err := run("cat missing")
+	assert.Equal(t, 1, ExitCode(err))
+	assert.Equal(t, []string{"pre cat missing", "post cat missing", "failed"}, calls)
+	_, err = os.Stat(lock)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+
+	// A failed pre hook fails the command without running it or the post hooks
+	calls = nil
+	assert.NoError(t, os.Mkdir(lock, 0o755))
+	_, stderr, err := run("cat missing")
+	assert.Error(t, err)
+	assert.Contains(t, stderr, `pre hook "mkdir lock" of cat failed`)
+	assert.Equal(t, 0, len(calls))
+	_, err = os.Stat(lock)
+	assert.NoError(t, err)
+
+	// Hook scripts are validated like any other
+	_, stderr, err = run("wc missing")
+	assert.Error(t, err)
+	assert.Contains(t, stderr, `pre hook "rm -rf /" of wc failed: command "rm" is denied`)
+}
+
+func TestSafeRunner_RuleHookCallbackTimeout(t *testing.T) {
+	RegisterRuleHook("test-slow", func(ctx context.Context, _ string, _ *ExecContext, _ error) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	tmpDir := t.TempDir()
+	cfg := config.NewDefaultConfig()
+	cfg.AllowedDirectories = []string{tmpDir}
+	cfg.AllowCommands = []config.AllowCommand{
+		{Command: "cat", Hooks: config.RuleHooks{Pre: []config.RuleHook{{Callback: "test-slow", Timeout: 1}}}},
+		{Command: "ls", Hooks: config.RuleHooks{Pre: []config.RuleHook{{Callback: "unregistered"}}}},
+	}
+	log := logger.New()
+	r := New(cfg, validator.New(cfg, log), log)
+	var stdout, stderr bytes.Buffer
+	r.SetOutputs(&stdout, &stderr)
+
+	result := r.RunCommand(t.Context(), "cat", tmpDir)
+	assert.Equal(t, ExitTimeout, ExitCode(result.Err))
+
+	result = r.RunCommand(t.Context(), "ls", tmpDir)
+	assert.Error(t, result.Err)
+	assert.Equal(t, "", stdout.String())
+	assert.Contains(t, stderr.String(), `no hook is registered as "unregistered"`)
+}
@@ -8,59 +8,245 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel/trace"
 	"mvdan.cc/sh/v3/interp"
 	"mvdan.cc/sh/v3/syntax"
 
+	"github.com/shimizu1995/secure-shell-server/pkg/alert"
+	"github.com/shimizu1995/secure-shell-server/pkg/approval"
+	"github.com/shimizu1995/secure-shell-server/pkg/audit"
 	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/fileaudit"
 	"github.com/shimizu1995/secure-shell-server/pkg/hint"
+	"github.com/shimizu1995/secure-shell-server/pkg/history"
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
 	"github.com/shimizu1995/secure-shell-server/pkg/limiter"
 	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/opa"
+	"github.com/shimizu1995/secure-shell-server/pkg/recording"
+	"github.com/shimizu1995/secure-shell-server/pkg/redact"
+	"github.com/shimizu1995/secure-shell-server/pkg/resultcache"
+	"github.com/shimizu1995/secure-shell-server/pkg/sanitize"
+	"github.com/shimizu1995/secure-shell-server/pkg/snapshot"
+	"github.com/shimizu1995/secure-shell-server/pkg/spool"
+	"github.com/shimizu1995/secure-shell-server/pkg/trash"
 	"github.com/shimizu1995/secure-shell-server/pkg/validator"
 )
 
 // SafeRunner executes shell commands securely.
 type SafeRunner struct {
-	config    *config.ShellCommandConfig
+	config *config.ShellCommandConfig
+	// policy is the configuration passed to New, of which config is the caller's view (see SetIdentity)
+	policy    *config.ShellCommandConfig
 	validator *validator.CommandValidator
 	logger    *logger.Logger
 	stdout    io.Writer
 	stderr    io.Writer
+	// Writers passed to SetOutputs (teed to the recorder), before limiting and redaction
+	baseStdout io.Writer
+	baseStderr io.Writer
 	// Output limiters to track truncation
 	stdoutLimiter *limiter.OutputLimiter
 	stderrLimiter *limiter.OutputLimiter
+	// spool, when set, keeps the full output of truncated streams; the tees copy each stream to it
+	spool       *spool.Store
+	stdoutSpool *spoolTee
+	stderrSpool *spoolTee
+	// resultCache, when set, holds the results of commands marked cacheable
+	resultCache *resultcache.Cache
+	// snapshots, when set, holds the snapshots executions run in; snapshotID chooses an existing one
+	snapshots  *snapshot.Store
+	snapshotID string
+	// trash, when trash is enabled, receives the files deleted by rm and unlink. Items are kept
+	// on disk, so every runner with the same trash.dir sees them
+	trash *trash.Store
+	// terminal, set by RunInteractive, is connected to commands marked allowPty whose output
+	// goes to terminalStdout and terminalStderr, the outputs of the script
+	terminal       *Terminal
+	terminalStdout io.Writer
+	terminalStderr io.Writer
+	// Redaction of secrets in output; writers are nil when redaction is disabled
+	redactor       *redact.Redactor
+	stdoutRedactor *redact.Writer
+	stderrRedactor *redact.Writer
+	// Sanitizing of escape sequences and binary output; nil when outputSafety is disabled
+	stdoutSanitizer *sanitize.Writer
+	stderrSanitizer *sanitize.Writer
 	// hints collected during command execution, returned via RunResult
 	hints []hint.Hint
+	// metrics of external commands run by the current RunCommand; pipelines record concurrently
+	metricsMu sync.Mutex
+	metrics   []CommandMetrics
+	// output written by the current RunCommand, counted towards the caller's quota
+	output atomic.Int64
+	// executionID identifies the current execution in published events (see Subscribe)
+	executionID atomic.Uint64
+	// denials recorded instead of enforced in permissive mode; pipelines record concurrently
+	wouldDenyMu sync.Mutex
+	wouldDeny   []Denial
+	// hooks registered by callers to observe or veto execution
+	hooks hooks
+	// inHook is set on the runners of rule hooks, whose commands run no rule hooks themselves
+	inHook bool
+	// recorder receives commands and output when the session is being recorded
+	recorder *recording.Recorder
+	// tracer emits spans for validation and execution
+	tracer trace.Tracer
+	// approvals holds commands marked approvalRequired until an approver decides
+	approvals *approval.Queue
+	// confirm, when set, decides whether commands marked confirm run
+	confirm ConfirmationFunc
+	// history, when set, records every command under caller
+	history *history.History
+	caller  string
+	// identity is the caller of the current execution (see SetIdentity)
+	identity identity.Identity
+	// alerter is notified when a deny rule marked alert matches
+	alerter *alert.Alerter
+	// auditor receives every denied or executed command; nil when no audit sinks are configured
+	auditor *audit.Auditor
+	// evaluator, when set, must also allow every command the static policy allows
+	evaluator opa.Evaluator
+	// onProcess, set by a Manager, is called when an external process starts and
+	// returns the function to call when it exits
+	onProcess func(p ProcessInfo) func()
+	// signals holds the processes Signal forwards signals to
+	signals signalState
+	// cgroup, with cgroup enabled, is the cgroup the external commands of the current execution start in
+	cgroup *cgroup
 }
 
 // New creates a new SafeRunner.
 func New(config *config.ShellCommandConfig, validator *validator.CommandValidator, logger *logger.Logger) *SafeRunner {
-	return &SafeRunner{
+	redactor, err := redact.New(config.Redaction)
+	if err != nil {
+		logger.LogErrorf("Failed to configure redaction: %v", err)
+	}
+
+	r := &SafeRunner{
 		config:        config,
+		policy:        config,
 		validator:     validator,
 		logger:        logger,
 		stdout:        os.Stdout,
 		stderr:        os.Stderr,
 		stdoutLimiter: nil,
 		stderrLimiter: nil,
+		redactor:      redactor,
+		tracer:        defaultTracer(),
+		alerter:       alert.New(config.Alerts),
+		auditor:       audit.New(config.Audit),
+	}
+	if config.OutputSpool.Enabled {
+		r.spool = spool.New(config.OutputSpool)
+	}
+	if config.Snapshot.Enabled {
+		r.snapshots = snapshot.New(config.Snapshot)
 	}
+	if config.Trash.Enabled {
+		r.trash = trash.New(config.Trash)
+	}
+	if config.CachesResults() {
+		r.resultCache = resultcache.New(config.ResultCache)
+	}
+	r.evaluator = r.loadPolicyEvaluator()
+	r.wrapRedaction()
+	r.wrapSanitize()
+	return r
+}
+
+// SetRecorder records commands and their output to rec. It must be called before SetOutputs.
+func (r *SafeRunner) SetRecorder(rec *recording.Recorder) {
+	r.recorder = rec
 }
 
 // SetOutputs sets the stdout and stderr writers.
 func (r *SafeRunner) SetOutputs(stdout, stderr io.Writer) {
-	// If MaxOutputSize is set, wrap the writers with limiters
-	if r.config.MaxOutputSize > 0 {
-		r.stdoutLimiter = limiter.NewOutputLimiter(stdout, r.config.MaxOutputSize)
-		r.stderrLimiter = limiter.NewOutputLimiter(stderr, r.config.MaxOutputSize)
-		r.stdout = r.stdoutLimiter
-		r.stderr = r.stderrLimiter
+	// Record exactly what the caller receives, after truncation and redaction
+	if r.recorder != nil {
+		stdout = io.MultiWriter(stdout, r.recorder.Stdout())
+		stderr = io.MultiWriter(stderr, r.recorder.Stderr())
+	}
+	r.baseStdout = stdout
+	r.baseStderr = stderr
+	r.limitOutputs(r.config.MaxOutputSize)
+}
+
+// limitOutputs wraps the writers passed to SetOutputs with limiters of maxBytes
+// (0 means unlimited) and with redaction.
+func (r *SafeRunner) limitOutputs(maxBytes int) {
+	// Subscribers receive the output the caller receives
+	stdout := r.publishOutput(r.baseStdout, StreamStdout)
+	stderr := r.publishOutput(r.baseStderr, StreamStderr)
+	if maxBytes > 0 {
+		r.stdoutLimiter = limiter.NewOutputLimiter(stdout, maxBytes)
+		r.stderrLimiter = limiter.NewOutputLimiter(stderr, maxBytes)
+		// The spool receives the full, redacted output beside the limiter
+		r.stdout, r.stdoutSpool = r.teeSpool(r.stdoutLimiter)
+		r.stderr, r.stderrSpool = r.teeSpool(r.stderrLimiter)
 	} else {
 		// Use the writers directly if no limit is set
 		r.stdout = stdout
 		r.stderr = stderr
 		r.stdoutLimiter = nil
 		r.stderrLimiter = nil
+		r.stdoutSpool = nil
+		r.stderrSpool = nil
+	}
+
+	r.wrapRedaction()
+	r.wrapSanitize()
+}
+
+// wrapRedaction wraps the current outputs with redacting writers when redaction is enabled.
+// Secrets are redacted before they reach the limiter so the limit applies to what is returned.
+func (r *SafeRunner) wrapRedaction() {
+	r.stdoutRedactor = nil
+	r.stderrRedactor = nil
+	if r.redactor != nil {
+		r.stdoutRedactor = redact.NewWriter(r.stdout, r.redactor)
+		r.stderrRedactor = redact.NewWriter(r.stderr, r.redactor)
+		r.stdout = r.stdoutRedactor
+		r.stderr = r.stderrRedactor
+	}
+}
+
+// wrapSanitize wraps the current outputs with sanitizing writers when outputSafety is enabled.
+// Output is sanitized before it is redacted, so that escape sequences cannot split a secret
+// from the patterns that find it. Terminals of interactive sessions need escape sequences, so
+// their output is left alone.
+func (r *SafeRunner) wrapSanitize() {
+	r.stdoutSanitizer = nil
+	r.stderrSanitizer = nil
+	if r.config.OutputSafety.Enabled && r.terminal == nil {
+		r.stdoutSanitizer = sanitize.NewWriter(r.stdout, r.config.OutputSafety)
+		r.stderrSanitizer = sanitize.NewWriter(r.stderr, r.config.OutputSafety)
+		r.stdout = r.stdoutSanitizer
+		r.stderr = r.stderrSanitizer
+	}
+}
+
+// flushOutputs writes any output still held back by the sanitizing and redacting writers.
+func (r *SafeRunner) flushOutputs() {
+	for _, w := range []*sanitize.Writer{r.stdoutSanitizer, r.stderrSanitizer} {
+		if w == nil {
+			continue
+		}
+		if err := w.Flush(); err != nil {
+			r.logger.LogErrorf("Failed to flush sanitized output: %v", err)
+		}
+	}
+	for _, w := range []*redact.Writer{r.stdoutRedactor, r.stderrRedactor} {
+		if w == nil {
+			continue
+		}
+		if err := w.Flush(); err != nil {
+			r.logger.LogErrorf("Failed to flush redacted output: %v", err)
+		}
 	}
 }
 
@@ -109,6 +295,29 @@ type RunResult struct {
 	NewWorkDir string
 	// Hints contains token-saving suggestions collected during execution.
 	Hints []hint.Hint
+	// Metrics contains resource usage for each external command that was executed.
+	Metrics []CommandMetrics
+	// WouldDeny lists what the policy denied but let run because enforcementMode is permissive.
+	WouldDeny []Denial
+	// Transcript is the interleaved output, set only by RunScriptCapture.
+	Transcript *Transcript
+	// StdoutSpool and StderrSpool hold the full output of a stream that was truncated
+	// when outputSpool is enabled, to be paged through with ReadRange.
+	StdoutSpool *spool.Output
+	StderrSpool *spool.Output
+	// Snapshot holds the changes of an execution run in a snapshot when snapshot is enabled,
+	// to be committed or discarded; it is nil when the execution changed nothing.
+	Snapshot *snapshot.Snapshot
+	// FileChanges lists the files the execution created, modified, or deleted in the allowed
+	// directories when fileAudit is enabled.
+	FileChanges []fileaudit.Change
+	// FileChangesIncomplete is set when the allowed directories held more files than
+	// fileAudit.maxFiles, so that changes to some of them may be missing from FileChanges.
+	FileChangesIncomplete bool
+	// OutputFile is the absolute path of the file the output was written to with WithOutputFile.
+	OutputFile string
+	// OutputFileTruncated is set when output beyond maxOutputFileSize was left out of OutputFile.
+	OutputFileTruncated bool
 	// Err is the execution error, if any.
 	Err error
 }
@@ -116,35 +325,129 @@ type RunResult struct {
 // RunCommand runs a shell command in the specified working directory.
 // It enforces security constraints by validating commands and file access.
 func (r *SafeRunner) RunCommand(ctx context.Context, command string, workingDir string) RunResult {
-	// Get absolute path of the working directory
-	absWorkingDir, err := filepath.Abs(workingDir)
-	if err != nil {
-		r.logger.LogErrorf("Failed to get absolute path for working directory: %v", err)
-		return RunResult{Err: fmt.Errorf("failed to get absolute path for working directory: %w", err)}
+	r.identify(ctx)
+	return r.run(ctx, command, r.defaultSettings(workingDir))
+}
+
+// run records and traces a command and runs it with the given settings.
+func (r *SafeRunner) run(ctx context.Context, command string, settings execSettings) RunResult {
+	workingDir := settings.workDir
+	ctx, span := r.startSpan(ctx, spanRun, attrCommand.String(r.redactor.Redact(command)), attrWorkDir.String(workingDir))
+	if !r.identity.IsZero() {
+		span.SetAttributes(attrCaller.String(r.identity.String()))
+	}
+	if r.recorder != nil {
+		r.recorder.Command(r.redactor.Redact(command))
+	}
+	started := time.Now()
+	r.publishStarted(command, workingDir)
+	var result RunResult
+	if err := r.checkKillSwitch(); err != nil {
+		r.logger.LogErrorf("Execution rejected: %v", err)
+		result.Err = err
+	} else if message, frozen := r.frozen(); frozen {
+		r.logger.LogErrorf("%s", message)
+		result.Err = deniedError(message)
+	} else if err := r.checkQuota(); err != nil {
+		r.logger.LogErrorf("Execution rejected: %v", err)
+		result.Err = err
+	} else {
+		ctx, done := trackExecution(ctx)
+		r.takeWouldDeny()
+		result = r.runCommand(ctx, command, settings)
+		result.WouldDeny = r.takeWouldDeny()
+		done()
+		r.chargeQuota(result.Metrics)
+	}
+	r.finishSpools(&result)
+	result.Err = asExitError(result.Err)
+	r.publishFinished(result.Err, started)
+	span.SetAttributes(attrTruncated.Bool(r.WasOutputTruncated()))
+	endSpan(span, result.Err)
+	if r.recorder != nil && result.Err != nil {
+		if _, ok := interp.IsExitStatus(result.Err); !ok {
+			fmt.Fprintf(r.recorder.Stderr(), "Error: %s\n", r.redactor.Redact(result.Err.Error()))
+		}
 	}
+	return result
+}
 
-	// Validate that the working directory is allowed
-	dirAllowed, dirMessage := r.validator.IsDirectoryAllowed(absWorkingDir)
-	if !dirAllowed {
-		r.logger.LogErrorf("Directory validation failed: %s", dirMessage)
-		return RunResult{Err: fmt.Errorf("directory validation failed: %s", dirMessage)}
+// runCommand parses, validates, and runs a command.
+func (r *SafeRunner) runCommand(ctx context.Context, command string, settings execSettings) (result RunResult) {
+	// Run in a snapshot of the working directory, whose changes the caller commits or discards
+	if r.snapshots != nil || r.snapshotID != "" {
+		finish, err := r.setupSnapshot(&settings)
+		if err != nil {
+			return RunResult{Err: err}
+		}
+		defer func() { finish(&result) }()
 	}
 
-	// Parse the command
-	parser := syntax.NewParser()
-	prog, err := parser.Parse(strings.NewReader(command), "")
+	// Give the execution a workspace of its own, allowed before the script is validated
+	var ws *scratch
+	if r.config.Scratch.Enabled {
+		var cleanup func()
+		var err error
+		ws, cleanup, err = r.setupScratch(&settings)
+		if err != nil {
+			return RunResult{Err: err}
+		}
+		defer cleanup()
+	}
+
+	// Hide the home directory of the server's user behind one that lives as long as the execution
+	if r.config.HomeIsolation.Enabled {
+		cleanup, err := r.setupHome(&settings)
+		if err != nil {
+			return RunResult{Err: err}
+		}
+		defer cleanup()
+	}
+
+	absWorkingDir, prog, err := r.validateScript(ctx, command, settings.workDir, settings.lang)
 	if err != nil {
-		r.logger.LogErrorf("Parse error: %v", err)
-		return RunResult{Err: fmt.Errorf("parse error: %w", err)}
+		return RunResult{Err: err}
+	}
+	if err := r.checkRisk(ctx, command, prog, absWorkingDir); err != nil {
+		return RunResult{Err: err}
 	}
 
-	// Create a timeout context if MaxExecutionTime is set
-	if r.config.MaxExecutionTime > 0 {
-		timeoutCtx, cancel := context.WithTimeout(ctx, time.Duration(r.config.MaxExecutionTime)*time.Second)
+	// List the files the execution changes, once it is known to run
+	if r.config.FileAudit.Enabled {
+		defer r.auditFiles()(&result)
+	}
+
+	// Contain every process the execution starts, so that its limits hold for all of them
+	if r.config.Cgroup.Enabled && (r.config.ExecutionBackend == "" || r.config.ExecutionBackend == config.BackendLocal) {
+		cleanup, err := r.setupCgroup()
+		if err != nil {
+			return RunResult{Err: err}
+		}
+		defer cleanup()
+	}
+
+	// Create a timeout context if a timeout is set
+	if settings.timeout > 0 {
+		timeoutCtx, cancel := context.WithTimeout(ctx, settings.timeout)
 		defer cancel()
 		ctx = timeoutCtx
 	}
 
+	// Kill commands that stop producing output, e.g. while waiting for input that never comes
+	var idle *idleWatchdog
+	if settings.idleTimeout > 0 {
+		var stop func()
+		ctx, idle, stop = newIdleWatchdog(ctx, settings.idleTimeout)
+		defer stop()
+	}
+
+	// Stop commands that write more to the workspace than its quota
+	if ws != nil {
+		var stop func()
+		ctx, stop = ws.watch(ctx)
+		defer stop()
+	}
+
 	// Track the last directory set by cd
 	var lastCdDir string
 
@@ -152,17 +455,61 @@ func (r *SafeRunner) RunCommand(ctx context.Context, command string, workingDir
 		cmd := args[0]
 
 		// Normalize absolute path commands to basename for validation
-		// e.g., /usr/bin/rm → rm, so deny/allow rules match correctly
-		cmdForValidation := cmd
-		if filepath.IsAbs(cmd) {
-			cmdForValidation = filepath.Base(cmd)
+		// e.g., /usr/bin/rm → rm, or C:\Windows\System32\del.exe → del on Windows,
+		// so deny/allow rules match correctly
+		cmdForValidation := validator.NormalizeCommandName(cmd)
+
+		// A script that was running when the kill switch was turned on starts nothing more
+		if err := r.checkKillSwitch(); err != nil {
+			r.denied(callCtx, cmd, args[1:], interp.HandlerCtx(callCtx).Dir, err.Error())
+			return args, err
+		}
+		// Neither does one stopped by a signal
+		if err := r.interruption(); err != nil {
+			return args, err
+		}
+
+		// Source the file that is validated, not one the interpreter would find in PATH
+		if validator.IsSourceCommand(cmdForValidation) && len(args) > 1 {
+			args[1] = validator.ResolveSourcePath(args[1], interp.HandlerCtx(callCtx).Dir)
 		}
 
 		// Validate all commands (including cd) through the same pipeline
-		allowed, errMsg := r.validator.ValidateCommand(cmdForValidation, args[1:], absWorkingDir)
+		_, span := r.startSpan(callCtx, spanPolicy, attrCommandName.String(cmdForValidation))
+		allowed, errMsg := r.validator.WithWorkDir(absWorkingDir).CheckInvocation(cmd, args[1:])
+		if allowed {
+			allowed, errMsg = r.validator.ValidateCommand(cmdForValidation, args[1:], absWorkingDir)
+		}
+		if allowed {
+			allowed, errMsg = r.evaluatePolicy(callCtx, cmdForValidation, args[1:])
+		}
+		endDecisionSpan(span, allowed, errMsg)
 		if !allowed {
-			r.logger.LogCommandAttempt(cmd, args[1:], false)
-			return args, fmt.Errorf("%s", errMsg)
+			if err := r.deny(callCtx, cmd, args[1:], interp.HandlerCtx(callCtx).Dir, errMsg); err != nil {
+				return args, err
+			}
+		}
+
+		// High-risk commands wait for a human decision
+		if r.validator.RequiresApproval(cmdForValidation) {
+			idle.pause()
+			errMsg, approved := r.awaitApproval(callCtx, approval.Request{Command: cmd, Args: args[1:], WorkDir: interp.HandlerCtx(callCtx).Dir})
+			idle.resume()
+			if !approved {
+				r.denied(callCtx, cmd, args[1:], interp.HandlerCtx(callCtx).Dir, errMsg)
+				return args, deniedError(errMsg)
+			}
+		}
+
+		// Commands marked confirm run once the embedding application's user says so
+		if r.validator.RequiresConfirmation(cmdForValidation) {
+			idle.pause()
+			errMsg, confirmed := r.confirmExec(ExecRequest{Command: cmd, Args: args[1:], WorkDir: interp.HandlerCtx(callCtx).Dir})
+			idle.resume()
+			if !confirmed {
+				r.denied(callCtx, cmd, args[1:], interp.HandlerCtx(callCtx).Dir, errMsg)
+				return args, deniedError(errMsg)
+			}
 		}
 
 		// Collect token-saving hints
@@ -175,28 +522,200 @@ func (r *SafeRunner) RunCommand(ctx context.Context, command string, workingDir
 
 		r.logger.LogCommandAttempt(cmd, args[1:], true)
 
-		return args, nil
+		// Allowed commands run in the safer form their rewrite rule gives
+		return r.rewrite(cmdForValidation, args), nil
 	}
 
 	// Create interpreter
-	interpRunner, err := interp.New(
-		interp.CallHandler(callFunc),
-		interp.StdIO(nil, r.stdout, r.stderr),
-		interp.Env(nil),
-		interp.Dir(absWorkingDir),
-		interp.OpenHandler(r.secureOpenHandler),
-	)
+	stdout, stderr := idle.writer(r.stdout), idle.writer(r.stderr)
+	if r.caller != "" {
+		// Count the output towards the caller's daily quota
+		stdout, stderr = r.countOutput(stdout), r.countOutput(stderr)
+	}
+	interpRunner, err := r.newInterp(absWorkingDir, settings.environ(), callFunc, stdout, stderr)
 	if err != nil {
 		r.logger.LogErrorf("Interpreter creation error: %v", err)
 		return RunResult{Err: fmt.Errorf("interpreter creation error: %w", err)}
 	}
+	defer interpRunner.release(prog)
+	r.terminalStdout, r.terminalStderr = interpRunner.stdout, interpRunner.stderr
+
+	r.metricsMu.Lock()
+	r.metrics = nil
+	r.metricsMu.Unlock()
 
 	err = interpRunner.Run(ctx, prog)
-	return RunResult{NewWorkDir: lastCdDir, Hints: r.hints, Err: err}
+	r.flushOutputs()
+	if sigErr := r.interruption(); sigErr != nil {
+		// A script that failed once its commands were signaled failed because of the signal
+		if isExitStatus(err) {
+			err = sigErr
+		}
+		r.clearInterruption()
+	}
+	if err != nil && errors.Is(context.Cause(ctx), ErrIdleTimeout) {
+		err = fmt.Errorf("%w (%s)", ErrIdleTimeout, settings.idleTimeout)
+	}
+	if errors.Is(context.Cause(ctx), ErrDisabled) {
+		err = r.disabledError(KillSwitch().Reason)
+	}
+	err = r.cgroupError(err)
+	// A command that finished between two checks still exceeded the quota
+	if errors.Is(context.Cause(ctx), ErrScratchQuotaExceeded) || (err == nil && ws != nil && ws.exceeded()) {
+		err = fmt.Errorf("%w (%d MB)", ErrScratchQuotaExceeded, r.config.Scratch.MaxSize)
+	}
+
+	r.metricsMu.Lock()
+	metrics := r.metrics
+	r.metricsMu.Unlock()
+	return RunResult{NewWorkDir: lastCdDir, Hints: r.hints, Metrics: metrics, Err: err}
+}
+
+// validateScript checks the working directory, parses the command in lang, and validates its
+// declaration clauses, returning the absolute working directory and the parsed program.
+func (r *SafeRunner) validateScript(ctx context.Context, command, workingDir string, lang syntax.LangVariant) (string, *syntax.File, error) {
+	ctx, span := r.startSpan(ctx, spanValidate)
+	absWorkingDir, prog, err := r.parseAndValidate(ctx, command, workingDir, lang)
+	endDecisionSpan(span, err == nil, fmt.Sprint(err))
+	return absWorkingDir, prog, err
+}
+
+// parseAndValidate implements validateScript.
+func (r *SafeRunner) parseAndValidate(ctx context.Context, command, workingDir string, lang syntax.LangVariant) (string, *syntax.File, error) {
+	// Get absolute path of the working directory
+	absWorkingDir, err := filepath.Abs(workingDir)
+	if err != nil {
+		r.logger.LogErrorf("Failed to get absolute path for working directory: %v", err)
+		return "", nil, fmt.Errorf("failed to get absolute path for working directory: %w", err)
+	}
+
+	// Validate that the working directory is allowed
+	dirAllowed, dirMessage := r.validator.IsDirectoryAllowed(absWorkingDir)
+	if !dirAllowed {
+		r.logger.LogErrorf("Directory validation failed: %s", dirMessage)
+		message := r.validator.Localize(validator.RuleDirectory, "", nil, "directory validation failed: "+dirMessage)
+		if !r.permissive() {
+			return "", nil, deniedError(message)
+		}
+		r.wouldHaveDenied(ctx, "", nil, absWorkingDir, message)
+	}
+
+	// Denials of the script as a whole are recorded in the block log with its directory
+	checker := r.validator.WithWorkDir(absWorkingDir)
+
+	// Oversized scripts are rejected before the parser spends time and memory on them
+	if violations := checker.CheckScriptSize(command); len(violations) > 0 {
+		v := violations[0]
+		if err := r.deny(ctx, "", nil, absWorkingDir, r.validator.Localize(v.Rule, "", nil, v.Message)); err != nil {
+			return "", nil, err
+		}
+	}
+
+	// Parse the command
+	prog, err := validator.ParseScript(command, lang)
+	if err != nil {
+		r.logger.LogErrorf("Parse error: %v", err)
+		return "", nil, invalidError(fmt.Errorf("parse error: %w", err))
+	}
+
+	// Complex scripts are rejected before the other checks walk them
+	if violations := checker.CheckComplexity(prog); len(violations) > 0 {
+		v := violations[0]
+		if err := r.deny(ctx, v.Command, v.Args, absWorkingDir, r.validator.Localize(v.Rule, v.Command, v.Args, v.Message)); err != nil {
+			return "", nil, err
+		}
+	}
+
+	// Declaration builtins (export, declare, local, ...) bypass the call handler,
+	// so validate them before anything runs
+	if err := r.validateDeclarations(ctx, prog, absWorkingDir); err != nil {
+		return "", nil, err
+	}
+
+	// Command names and literalArgs arguments that come from expansions are only known at run time
+	if violations := checker.CheckExpansions(prog); len(violations) > 0 {
+		v := violations[0]
+		if err := r.deny(ctx, v.Command, v.Args, absWorkingDir, r.validator.Localize(v.Rule, v.Command, v.Args, v.Message)); err != nil {
+			return "", nil, err
+		}
+	}
+
+	// Programs fed to interpreters on standard input never reach the call handler
+	if violations := checker.CheckInterpreterInput(prog, absWorkingDir); len(violations) > 0 {
+		v := violations[0]
+		if err := r.deny(ctx, v.Command, v.Args, absWorkingDir, r.validator.Localize(v.Rule, v.Command, v.Args, v.Message)); err != nil {
+			return "", nil, err
+		}
+	}
+
+	// Decoded, piped, and inline programs run code no argument check sees
+	if violations := checker.CheckObfuscation(prog); len(violations) > 0 {
+		v := violations[0]
+		if err := r.deny(ctx, v.Command, v.Args, absWorkingDir, r.validator.Localize(v.Rule, v.Command, v.Args, v.Message)); err != nil {
+			return "", nil, err
+		}
+	}
+
+	// Background commands and process substitutions outlive the timeout and output accounting
+	if violations := checker.CheckBackground(prog); len(violations) > 0 {
+		v := violations[0]
+		if err := r.deny(ctx, v.Command, v.Args, absWorkingDir, r.validator.Localize(v.Rule, v.Command, v.Args, v.Message)); err != nil {
+			return "", nil, err
+		}
+	}
+
+	// Redirections the interpreter cannot perform would abort it, so they are refused even in
+	// permissive mode
+	if violations := checker.CheckRedirects(prog); len(violations) > 0 {
+		v := violations[0]
+		return "", nil, invalidError(errors.New(r.validator.Localize(v.Rule, v.Command, v.Args, v.Message)))
+	}
+
+	// Rules registered by the embedding program (see validator.RegisterRuleChecker)
+	if violations := checker.CheckRules(prog, absWorkingDir); len(violations) > 0 {
+		v := violations[0]
+		if err := r.deny(ctx, v.Command, v.Args, absWorkingDir, r.validator.Localize(v.Rule, v.Command, v.Args, v.Message)); err != nil {
+			return "", nil, err
+		}
+	}
+	return absWorkingDir, prog, nil
+}
+
+// validateDeclarations checks every declaration clause in the script against the policy.
+func (r *SafeRunner) validateDeclarations(ctx context.Context, prog *syntax.File, workingDir string) error {
+	var validationErr error
+	syntax.Walk(prog, func(node syntax.Node) bool {
+		if validationErr != nil {
+			return false
+		}
+		decl, ok := node.(*syntax.DeclClause)
+		if !ok || decl.Variant == nil {
+			return true
+		}
+
+		name := decl.Variant.Value
+		args := make([]string, 0, len(decl.Args))
+		for _, assign := range decl.Args {
+			if assign.Name != nil {
+				args = append(args, assign.Name.Value)
+			}
+		}
+
+		if allowed, errMsg := r.validator.ValidateCommand(name, args, workingDir); !allowed {
+			validationErr = r.deny(ctx, name, args, workingDir, errMsg)
+			return validationErr == nil
+		}
+		return true
+	})
+	return validationErr
 }
 
 // secureOpenHandler validates file access against allowed directories before opening.
 func (r *SafeRunner) secureOpenHandler(ctx context.Context, path string, flag int, perm os.FileMode) (io.ReadWriteCloser, error) {
+	// Relative redirections are relative to the interpreter's directory, not the process's
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(interp.HandlerCtx(ctx).Dir, path)
+	}
 	absPath, absErr := filepath.Abs(path)
 	if absErr != nil {
 		r.logger.LogErrorf("Failed to get absolute path for file %s: %v", path, absErr)
@@ -211,19 +730,59 @@ func (r *SafeRunner) secureOpenHandler(ctx context.Context, path string, flag in
 	// Check if the file path is within an allowed directory, or is itself an explicitly allowed path.
 	// Using IsPathInAllowedDirectory instead of IsDirectoryAllowed(fileDir) allows specific files
 	// like /dev/null to be permitted when listed in allowedDirectories.
+	// Pipes of process substitutions live in the temporary directory and open no files.
+	pipe := r.validator.IsProcessSubstitutionPipe(absPath)
 	allowed, msg := r.validator.IsPathInAllowedDirectory(absPath, "/")
-	if !allowed {
+	dir := interp.HandlerCtx(ctx).Dir
+	if !allowed && !pipe {
 		r.logger.LogErrorf("File access attempted outside allowed directories: %s", absPath)
-		return nil, &os.PathError{
-			Op:   "open",
-			Path: path,
-			Err:  fmt.Errorf("access denied: file is outside allowed directories: %s", msg),
+		if !r.permissive() {
+			return nil, &os.PathError{
+				Op:   "open",
+				Path: path,
+				Err:  fmt.Errorf("access denied: file is outside allowed directories: %s", msg),
+			}
 		}
+		r.wouldHaveDenied(ctx, "", nil, dir, "file is outside allowed directories: "+msg)
+	}
+
+	// Files written to must also be outside the read-only directories, /proc, and /sys, and
+	// devices other than writableDevices are refused
+	if allowed && flag&writeFlags != 0 {
+		if writable, msg := r.validator.CheckWriteTarget(absPath, "/"); !writable {
+			r.logger.LogErrorf("Write redirection blocked: %s", msg)
+			if !r.permissive() {
+				return nil, &os.PathError{Op: "open", Path: path, Err: fmt.Errorf("access denied: %s", msg)}
+			}
+			r.wouldHaveDenied(ctx, "", nil, dir, msg)
+		}
+	}
+
+	// In read-only mode, redirections may only write to devices such as /dev/null
+	if r.config.ReadOnlyOnly && flag&writeFlags != 0 && !isDevice(absPath) && !pipe {
+		r.logger.LogErrorf("Write redirection blocked in read-only mode: %s", absPath)
+		if !r.permissive() {
+			return nil, &os.PathError{
+				Op:   "open",
+				Path: path,
+				Err:  errors.New("access denied: writing files is not allowed in read-only mode"),
+			}
+		}
+		r.wouldHaveDenied(ctx, "", nil, dir, "writing files is not allowed in read-only mode: "+absPath)
 	}
 
 	return interp.DefaultOpenHandler()(ctx, path, flag, perm)
 }
 
+// writeFlags are the open flags that modify a file.
+const writeFlags = os.O_WRONLY | os.O_RDWR | os.O_APPEND | os.O_CREATE | os.O_TRUNC
+
+// isDevice reports whether path is an existing device file rather than a regular file or directory.
+func isDevice(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&os.ModeDevice != 0
+}
+
 // handleCdCall validates a cd command against allowed directories.
 // It resolves the target path relative to the interpreter's current directory,
 // checks it against the allowlist, and tracks the resolved path.
@@ -253,9 +812,8 @@ func (r *SafeRunner) handleCdCall(ctx context.Context, args []string, lastCdDir
 
 	// Validate against allowed directories
 	allowed, msg := r.validator.IsDirectoryAllowed(absTarget)
-	if !allowed {
-		r.logger.LogCommandAttempt("cd", args[1:], false)
-		return args, fmt.Errorf("cd: %s", msg)
+	if !allowed && r.deny(ctx, "cd", args[1:], currentDir, msg) != nil {
+		return args, deniedError("cd: " + msg)
 	}
 
 	// Check directory exists
@@ -2,10 +2,13 @@ package runner
 
 import (
 	"io"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/alecthomas/assert/v2"
+	"mvdan.cc/sh/v3/syntax"
 
 	"github.com/shimizu1995/secure-shell-server/pkg/config"
 	"github.com/shimizu1995/secure-shell-server/pkg/logger"
@@ -295,3 +298,345 @@ func TestSafeRunner_DevNullBlockedWhenNotConfigured(t *testing.T) {
 		assert.Error(t, result.Err)
 	})
 }
+
+func TestSafeRunner_DeclarationBuiltins(t *testing.T) {
+	tests := []struct {
+		name     string
+		builtins config.BuiltinPolicy
+		command  string
+		wantErr  string
+	}{
+		{
+			name:    "export denied by default",
+			command: "export FOO=bar; echo done",
+			wantErr: `command "export" is not permitted`,
+		},
+		{
+			name:    "local inside function denied by default",
+			command: "f() { local x=1; }; echo start",
+			wantErr: `command "local" is not permitted`,
+		},
+		{
+			name:     "export allowed by builtins policy",
+			builtins: config.BuiltinPolicy{Allow: []string{"export"}},
+			command:  "export FOO=bar; echo done",
+		},
+		{
+			name:     "export denied by builtins policy",
+			builtins: config.BuiltinPolicy{Deny: []string{"export"}},
+			command:  "export FOO=bar",
+			wantErr:  `shell builtin "export" is denied`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := setupCustomConfig()
+			cfg.Builtins = tt.builtins
+			log := logger.New()
+			r := New(cfg, validator.New(cfg, log), log)
+			r.SetOutputs(io.Discard, io.Discard)
+
+			result := r.RunCommand(t.Context(), tt.command, "/tmp")
+			if tt.wantErr == "" {
+				assert.NoError(t, result.Err)
+				return
+			}
+			assert.Error(t, result.Err)
+			assert.Contains(t, result.Err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestSafeRunner_WrapperBuiltins(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories:  []string{tmpDir},
+		AllowCommands:       []config.AllowCommand{{Command: "echo"}, {Command: "ls"}},
+		DenyCommands:        []config.DenyCommand{{Command: "rm"}},
+		Builtins:            config.BuiltinPolicy{Allow: []string{"command", "exec", "builtin"}},
+		DefaultErrorMessage: "Command not allowed by security policy",
+		MaxExecutionTime:    config.DefaultExecutionTimeout,
+	}
+	victim := filepath.Join(tmpDir, "victim")
+	assert.NoError(t, os.WriteFile(victim, nil, 0o600))
+	log := logger.New()
+	r := New(cfg, validator.New(cfg, log), log)
+	r.SetOutputs(io.Discard, io.Discard)
+
+	for _, command := range []string{"command rm victim", "command -p rm victim", "exec rm victim", "exec -a ls rm victim"} {
+		t.Run(command, func(t *testing.T) {
+			result := r.RunCommand(t.Context(), command, tmpDir)
+			assert.Equal(t, ExitDenied, ExitCode(result.Err))
+			assert.Contains(t, result.Err.Error(), `command "rm" is denied`)
+			_, err := os.Stat(victim)
+			assert.NoError(t, err)
+		})
+	}
+
+	assert.NoError(t, r.RunCommand(t.Context(), "command ls", tmpDir).Err)
+	assert.NoError(t, r.RunCommand(t.Context(), "command -v rm", tmpDir).Err)
+}
+
+func TestSafeRunner_ExpansionPolicy(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := setupCustomConfig()
+	cfg.AllowedDirectories = []string{tmpDir}
+	cfg.AllowCommands = append(cfg.AllowCommands, config.AllowCommand{Command: "touch", LiteralArgs: true})
+	cfg.DenyDynamicCommands = true
+	log := logger.New()
+	r := New(cfg, validator.New(cfg, log), log)
+	var stdout strings.Builder
+	r.SetOutputs(&stdout, io.Discard)
+
+	// The script is rejected before anything runs
+	result := r.RunCommand(t.Context(), "echo first; CMD=echo; $CMD hello", tmpDir)
+	assert.Error(t, result.Err)
+	assert.Equal(t, ExitDenied, ExitCode(result.Err))
+	assert.Contains(t, result.Err.Error(), "command name $CMD comes from an expansion")
+	assert.Equal(t, "", stdout.String())
+
+	result = r.RunCommand(t.Context(), "touch $FILE", tmpDir)
+	assert.Error(t, result.Err)
+	assert.Contains(t, result.Err.Error(), `argument $FILE of "touch" comes from an expansion`)
+
+	result = r.RunCommand(t.Context(), `touch new.txt; NAME=a; echo "$NAME"`, tmpDir)
+	assert.NoError(t, result.Err)
+}
+
+func TestSafeRunner_BackgroundPolicy(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := setupCustomConfig()
+	cfg.AllowedDirectories = []string{tmpDir}
+	log := logger.New()
+	r := New(cfg, validator.New(cfg, log), log)
+	var stdout strings.Builder
+	r.SetOutputs(&stdout, io.Discard)
+
+	// The script is rejected before anything runs
+	result := r.RunCommand(t.Context(), "echo first; echo second &", tmpDir)
+	assert.Error(t, result.Err)
+	assert.Equal(t, ExitDenied, ExitCode(result.Err))
+	assert.Contains(t, result.Err.Error(), `background command "echo" is not allowed`)
+	assert.Equal(t, "", stdout.String())
+
+	result = r.RunCommand(t.Context(), "cat <(echo hi)", tmpDir)
+	assert.Error(t, result.Err)
+	assert.Contains(t, result.Err.Error(), "process substitution <(...) is not allowed")
+
+	cfg.AllowBackground = true
+	cfg.AllowProcessSubstitution = true
+	r = New(cfg, validator.New(cfg, log), log)
+	stdout.Reset()
+	r.SetOutputs(&stdout, io.Discard)
+	result = r.RunCommand(t.Context(), "echo hi & wait; cat <(echo there)", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "hi\nthere\n", stdout.String())
+}
+
+func TestSafeRunner_ScriptLimits(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := setupCustomConfig()
+	cfg.AllowedDirectories = []string{tmpDir}
+	cfg.ScriptLimits = config.ScriptLimitsConfig{MaxSize: 100, MaxDepth: 2}
+	log := logger.New()
+	r := New(cfg, validator.New(cfg, log), log)
+	var stdout strings.Builder
+	r.SetOutputs(&stdout, io.Discard)
+
+	result := r.RunCommand(t.Context(), "echo start; "+strings.Repeat("echo x; ", 20), tmpDir)
+	assert.Equal(t, ExitDenied, ExitCode(result.Err))
+	assert.Contains(t, result.Err.Error(), "more than the limit of 100 (scriptLimits.maxSize)")
+
+	result = r.RunCommand(t.Context(), "echo start; (echo $(echo $(echo deep)))", tmpDir)
+	assert.Equal(t, ExitDenied, ExitCode(result.Err))
+	assert.Contains(t, result.Err.Error(), "script nests more than 2 levels deep")
+	assert.Equal(t, "", stdout.String())
+
+	result = r.RunCommand(t.Context(), "(echo $(echo ok))", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "ok\n", stdout.String())
+}
+
+func TestSafeRunner_InterpreterInput(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := setupCustomConfig()
+	cfg.AllowedDirectories = []string{tmpDir}
+	cfg.AllowCommands = append(cfg.AllowCommands, config.AllowCommand{Command: "python3"})
+	log := logger.New()
+	r := New(cfg, validator.New(cfg, log), log)
+	var stdout strings.Builder
+	r.SetOutputs(&stdout, io.Discard)
+
+	// The script is rejected before anything runs
+	result := r.RunCommand(t.Context(), "echo first; python3 - <<'EOF'\nimport os\nos.system('rm -rf ~')\nEOF", tmpDir)
+	assert.Error(t, result.Err)
+	assert.Equal(t, ExitDenied, ExitCode(result.Err))
+	assert.Contains(t, result.Err.Error(), `python3 program from a here-document would run a denied command: command "rm" is denied`)
+	assert.Equal(t, "", stdout.String())
+
+	cfg.InterpreterInput = config.InterpreterInputDeny
+	result = r.RunCommand(t.Context(), `echo "print(1)" | python3`, tmpDir)
+	assert.Error(t, result.Err)
+	assert.Contains(t, result.Err.Error(), "python3 reads a program from a pipe, which is not allowed")
+}
+
+func TestSafeRunner_ReadOnlyOnlyBlocksWriteRedirects(t *testing.T) {
+	tmpDir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "input.txt"), []byte("content\n"), 0o600))
+
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories: []string{tmpDir, "/dev/null"},
+		AllowCommands:      []config.AllowCommand{{Command: "echo"}, {Command: "cat", ReadOnly: true}},
+		DenyCommands:       []config.DenyCommand{},
+		ReadOnlyOnly:       true,
+	}
+	log := logger.New()
+	safeRunner := New(cfg, validator.New(cfg, log), log)
+	safeRunner.SetOutputs(io.Discard, io.Discard)
+
+	t.Run("ReadRedirect", func(t *testing.T) {
+		result := safeRunner.RunCommand(t.Context(), "cat < input.txt", tmpDir)
+		assert.NoError(t, result.Err)
+	})
+
+	t.Run("DevNullRedirect", func(t *testing.T) {
+		result := safeRunner.RunCommand(t.Context(), "cat input.txt > /dev/null", tmpDir)
+		assert.NoError(t, result.Err)
+	})
+
+	t.Run("FileRedirect", func(t *testing.T) {
+		var stderr strings.Builder
+		safeRunner.SetOutputs(io.Discard, &stderr)
+		result := safeRunner.RunCommand(t.Context(), "echo hello > output.txt", tmpDir)
+		assert.Error(t, result.Err)
+		assert.Contains(t, stderr.String(), "read-only mode")
+		_, err := os.Stat(filepath.Join(tmpDir, "output.txt"))
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("AppendRedirect", func(t *testing.T) {
+		result := safeRunner.RunCommand(t.Context(), "echo hello >> input.txt", tmpDir)
+		assert.Error(t, result.Err)
+	})
+}
+
+func TestSafeRunner_Source(t *testing.T) {
+	tmpDir := t.TempDir()
+	sub := filepath.Join(tmpDir, "sub")
+	assert.NoError(t, os.Mkdir(sub, 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(sub, "env.sh"), []byte("echo sourced \"$1\"\n"), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "evil.sh"), []byte("echo first\nrm -rf sub\n"), 0o600))
+	cfg := setupCustomConfig()
+	cfg.AllowedDirectories = []string{tmpDir}
+	cfg.Builtins.Allow = []string{"cd", "source", "."}
+	log := logger.New()
+	r := New(cfg, validator.New(cfg, log), log)
+	var stdout strings.Builder
+	r.SetOutputs(&stdout, io.Discard)
+
+	// Relative files are sourced from the interpreter's directory
+	result := r.RunCommand(t.Context(), "cd sub && . ./env.sh arg", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "sourced arg\n", stdout.String())
+
+	// A sourced file with a denied command is rejected before any of it runs
+	stdout.Reset()
+	result = r.RunCommand(t.Context(), "source evil.sh", tmpDir)
+	assert.Equal(t, ExitDenied, ExitCode(result.Err))
+	assert.Contains(t, result.Err.Error(), "evil.sh: line 2: ")
+	assert.Equal(t, "", stdout.String())
+	_, err := os.Stat(sub)
+	assert.NoError(t, err)
+}
+
+func TestSafeRunner_RuleCheckers(t *testing.T) {
+	validator.RegisterRuleChecker("test-no-launch", validator.RuleCheckerFunc(func(node syntax.Node, _ validator.RuleContext) []validator.Violation {
+		word, ok := node.(*syntax.Word)
+		if !ok {
+			return nil
+		}
+		if s, _ := validator.LiteralWord(word); s == "launch-codes" {
+			return []validator.Violation{{Message: "launch codes must not be printed"}}
+		}
+		return nil
+	}))
+	tmpDir := t.TempDir()
+	cfg := setupCustomConfig()
+	cfg.AllowedDirectories = []string{tmpDir}
+	log := logger.New()
+	r := New(cfg, validator.New(cfg, log), log)
+	var stdout strings.Builder
+	r.SetOutputs(&stdout, io.Discard)
+
+	// The script is rejected before any of it runs
+	result := r.RunCommand(t.Context(), "echo first; echo launch-codes", tmpDir)
+	assert.Equal(t, ExitDenied, ExitCode(result.Err))
+	assert.Contains(t, result.Err.Error(), "launch codes must not be printed")
+	assert.Equal(t, "", stdout.String())
+
+	result = r.RunCommand(t.Context(), "echo launch", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "launch\n", stdout.String())
+}
+
+func TestSafeRunner_Obfuscation(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := setupCustomConfig()
+	cfg.AllowedDirectories = []string{tmpDir}
+	cfg.AllowCommands = append(cfg.AllowCommands, config.AllowCommand{Command: "python3"})
+	cfg.Obfuscation = config.ObfuscationConfig{Action: config.ObfuscationActionDeny}
+	log := logger.New()
+	r := New(cfg, validator.New(cfg, log), log)
+	var stdout strings.Builder
+	r.SetOutputs(&stdout, io.Discard)
+
+	// The script is rejected before any of it runs
+	result := r.RunCommand(t.Context(), "echo first; python3 -c 'import os; os.system(\"id\")'", tmpDir)
+	assert.Equal(t, ExitDenied, ExitCode(result.Err))
+	assert.Contains(t, result.Err.Error(), "python3 runs an inline program given with -c")
+	assert.Equal(t, "", stdout.String())
+
+	result = r.RunCommand(t.Context(), "echo 'print(1)' | python3 -", tmpDir)
+	assert.Equal(t, ExitDenied, ExitCode(result.Err))
+	assert.Contains(t, result.Err.Error(), `python3 runs the output of "echo" piped into it as code`)
+}
+
+// TestSafeRunner_WriteTargets tests that redirections may read from but not write into
+// readOnlyDirectories, and may not write to devices other than writableDevices.
+func TestSafeRunner_WriteTargets(t *testing.T) {
+	tmpDir := t.TempDir()
+	vendor := filepath.Join(tmpDir, "vendor")
+	assert.NoError(t, os.Mkdir(vendor, 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(vendor, "lib.txt"), []byte("lib\n"), 0o600))
+	cfg := setupCustomConfig()
+	cfg.AllowedDirectories = []string{tmpDir, "/dev"}
+	cfg.ReadOnlyDirectories = []string{vendor}
+	log := logger.New()
+	r := New(cfg, validator.New(cfg, log), log)
+	var stderr strings.Builder
+	r.SetOutputs(io.Discard, &stderr)
+
+	result := r.RunCommand(t.Context(), "cat < vendor/lib.txt > copy.txt; echo x 2>/dev/null >> copy.txt", tmpDir)
+	assert.NoError(t, result.Err)
+	data, err := os.ReadFile(filepath.Join(tmpDir, "copy.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "lib\nx\n", string(data))
+
+	for _, script := range []string{"echo x > vendor/lib.txt", "echo x > /dev/zero"} {
+		stderr.Reset()
+		result = r.RunCommand(t.Context(), script, tmpDir)
+		assert.Error(t, result.Err)
+		assert.Contains(t, stderr.String(), "access denied")
+	}
+
+	// The interpreter cannot perform >|, so it is refused before the script starts
+	result = r.RunCommand(t.Context(), "echo x >| vendor/new.txt", tmpDir)
+	assert.Error(t, result.Err)
+	assert.Contains(t, result.Err.Error(), "the >| redirection is not supported")
+	data, err = os.ReadFile(filepath.Join(vendor, "lib.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "lib\n", string(data))
+	_, err = os.Stat(filepath.Join(vendor, "new.txt"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,94 @@
+package runner
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"slices"
+	"time"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+// dockerPingTimeout bounds how long CheckSandboxes waits for the Docker daemon or the
+// Kubernetes API server.
+const dockerPingTimeout = 5 * time.Second
+
+// Sandbox backends reported by CheckSandboxes.
+const (
+	SandboxLandlock         = "landlock"
+	SandboxSeccomp          = "seccomp"
+	SandboxNetworkNamespace = "network-namespace"
+	SandboxDocker           = "docker"
+	SandboxKubernetes       = "kubernetes"
+)
+
+// SandboxCheck reports whether a sandbox backend used by a policy is available.
+type SandboxCheck struct {
+	// Name is one of the Sandbox constants.
+	Name string `json:"name"`
+	// Required is set when commands fail without the backend, rather than running without it.
+	Required bool `json:"required"`
+	// Available is set when the backend can be used on this system.
+	Available bool `json:"available"`
+	// Error explains why the backend is unavailable.
+	Error string `json:"error,omitempty"`
+}
+
+// CheckSandboxes reports the availability of the sandbox backends cfg uses: Landlock,
+// seccomp, network namespaces, the Docker daemon, and the pod of the kubernetes backend. Nothing is started or restricted.
+func CheckSandboxes(ctx context.Context, cfg *config.ShellCommandConfig) []SandboxCheck {
+	var checks []SandboxCheck
+	add := func(name string, required bool, err error) {
+		check := SandboxCheck{Name: name, Required: required, Available: err == nil}
+		if err != nil {
+			check.Error = err.Error()
+		}
+		checks = append(checks, check)
+	}
+
+	if cfg.ExecutionBackend == config.BackendDocker {
+		ctx, cancel := context.WithTimeout(ctx, dockerPingTimeout)
+		defer cancel()
+		client, err := newDockerClient(cfg.Docker.Host)
+		if err == nil {
+			err = client.do(ctx, http.MethodGet, "/_ping", nil, nil)
+		}
+		add(SandboxDocker, true, err)
+		return checks
+	}
+	if cfg.ExecutionBackend == config.BackendKubernetes {
+		ctx, cancel := context.WithTimeout(ctx, dockerPingTimeout)
+		defer cancel()
+		client, err := newKubeClient(cfg.Kubernetes)
+		if err == nil {
+			err = client.getPod(ctx, kubeNamespace(cfg.Kubernetes, client), cfg.Kubernetes.Pod)
+		}
+		add(SandboxKubernetes, true, err)
+		return checks
+	}
+
+	if cfg.Landlock.Enabled {
+		_, err := landlockRestriction(landlockPolicy{})
+		add(SandboxLandlock, !cfg.Landlock.BestEffort, err)
+	}
+	if usesSeccomp(cfg) {
+		_, err := seccompFilter(nil, "")
+		add(SandboxSeccomp, !cfg.Seccomp.BestEffort, err)
+	}
+	if cfg.DisableNetwork {
+		var err error
+		if runtime.GOOS != "linux" {
+			err = errNetworkIsolationUnsupported
+		}
+		add(SandboxNetworkNamespace, true, err)
+	}
+	return checks
+}
+
+// usesSeccomp reports whether any command of cfg runs under a seccomp filter.
+func usesSeccomp(cfg *config.ShellCommandConfig) bool {
+	return cfg.Seccomp.Enabled || slices.ContainsFunc(cfg.AllowCommands, func(c config.AllowCommand) bool {
+		return c.SeccompProfile != "" && c.SeccompProfile != config.SeccompProfileUnconfined
+	})
+}
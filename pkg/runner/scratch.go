@@ -0,0 +1,135 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// ErrScratchQuotaExceeded is returned when an execution writes more to its scratch workspace than scratch.maxSize.
+var ErrScratchQuotaExceeded = errors.New("scratch workspace exceeded its size quota")
+
+// errTmpfsUnsupported is returned when tmpfs workspaces are requested on a platform without tmpfs.
+var errTmpfsUnsupported = errors.New("tmpfs workspaces are only supported on Linux")
+
+const (
+	// scratchEnv names the variable holding the path of the scratch workspace.
+	scratchEnv = "WORKSPACE"
+	// scratchPattern is the name pattern of scratch workspaces.
+	scratchPattern = "secure-shell-scratch-"
+	// scratchPollInterval is how often the size of a workspace is checked against its quota.
+	scratchPollInterval = 200 * time.Millisecond
+	bytesPerMegabyte    = 1024 * 1024
+)
+
+// scratch is the temporary workspace of a single execution.
+type scratch struct {
+	dir string
+	// maxBytes of zero means unlimited
+	maxBytes int64
+	// mounted is true when dir is a tmpfs, which enforces the quota itself
+	mounted bool
+}
+
+// setupScratch creates the scratch workspace of an execution, exposes it in settings as
+// $WORKSPACE, and allows it for the duration of the execution. The returned function
+// revokes access and removes the workspace.
+func (r *SafeRunner) setupScratch(settings *execSettings) (*scratch, func(), error) {
+	cfg := r.config.Scratch
+	dir, err := os.MkdirTemp(cfg.Dir, scratchPattern)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create scratch workspace: %w", err)
+	}
+	// Resolve symlinks, e.g. /tmp on macOS, so that the path matches what commands see
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+
+	ws := &scratch{dir: dir, maxBytes: int64(cfg.MaxSize) * bytesPerMegabyte}
+	if cfg.Tmpfs {
+		if err := mountTmpfs(dir, ws.maxBytes); err != nil {
+			_ = os.Remove(dir)
+			return nil, nil, fmt.Errorf("failed to mount scratch workspace: %w", err)
+		}
+		ws.mounted = true
+	}
+
+	settings.env = append(slices.Clone(settings.env), scratchEnv+"="+dir)
+
+	config, validator := r.config, r.validator
+	scratchConfig := *config
+	scratchConfig.AllowedDirectories = append(slices.Clone(config.AllowedDirectories), dir)
+	r.config, r.validator = &scratchConfig, validator.WithAllowedDirectories(dir)
+
+	return ws, func() {
+		r.config, r.validator = config, validator
+		if err := ws.remove(); err != nil {
+			r.logger.LogErrorf("Failed to remove scratch workspace %s: %v", dir, err)
+		}
+	}, nil
+}
+
+// remove unmounts and deletes the workspace.
+func (s *scratch) remove() error {
+	if s.mounted {
+		if err := unmountTmpfs(s.dir); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(s.dir)
+}
+
+// watch returns a context that is canceled with ErrScratchQuotaExceeded when the workspace
+// grows beyond its quota. The stop function releases its resources.
+func (s *scratch) watch(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	if s.maxBytes <= 0 || s.mounted {
+		return ctx, func() { cancel(nil) }
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(scratchPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if s.exceeded() {
+					cancel(ErrScratchQuotaExceeded)
+					return
+				}
+			}
+		}
+	}()
+	return ctx, func() {
+		close(done)
+		cancel(nil)
+	}
+}
+
+// exceeded reports whether the files in the workspace are larger than its quota.
+func (s *scratch) exceeded() bool {
+	if s.maxBytes <= 0 {
+		return false
+	}
+	var size int64
+	_ = filepath.WalkDir(s.dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil //nolint:nilerr // files removed while walking are not counted
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size > s.maxBytes
+}
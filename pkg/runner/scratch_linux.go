@@ -0,0 +1,21 @@
+package runner
+
+import (
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// mountTmpfs mounts a tmpfs of maxBytes (unlimited if zero) on dir.
+func mountTmpfs(dir string, maxBytes int64) error {
+	data := "mode=0700"
+	if maxBytes > 0 {
+		data += ",size=" + strconv.FormatInt(maxBytes, 10)
+	}
+	return unix.Mount("tmpfs", dir, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, data)
+}
+
+// unmountTmpfs detaches the tmpfs mounted on dir.
+func unmountTmpfs(dir string) error {
+	return unix.Unmount(dir, unix.MNT_DETACH)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package runner
+
+// mountTmpfs fails because tmpfs is only available on Linux.
+func mountTmpfs(_ string, _ int64) error {
+	return errTmpfsUnsupported
+}
+
+// unmountTmpfs is never called because mountTmpfs always fails.
+func unmountTmpfs(_ string) error {
+	return errTmpfsUnsupported
+}
@@ -0,0 +1,58 @@
+package runner
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+func TestScratch_WorkspaceIsCreatedAndRemoved(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, stdout := newOptionsTestRunner(t, tmpDir)
+	r.config.Scratch = config.ScratchConfig{Enabled: true, Dir: t.TempDir()}
+
+	result := r.RunCommand(t.Context(), `printenv WORKSPACE && echo data > "$WORKSPACE/out.txt" && cat "$WORKSPACE/out.txt"`, tmpDir)
+	assert.NoError(t, result.Err)
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+	assert.True(t, strings.HasPrefix(lines[0], r.config.Scratch.Dir))
+	assert.Equal(t, "data", lines[1])
+
+	// The workspace is removed and access to it revoked after the execution
+	_, err := os.Stat(lines[0])
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, []string{tmpDir}, r.config.AllowedDirectories)
+
+	// Every execution gets a fresh workspace
+	stdout.Reset()
+	assert.NoError(t, r.RunCommand(t.Context(), "printenv WORKSPACE", tmpDir).Err)
+	assert.NotEqual(t, lines[0], strings.TrimSpace(stdout.String()))
+}
+
+func TestScratch_WorkspaceOverridesEnv(t *testing.T) {
+	r, stdout := newOptionsTestRunner(t, t.TempDir())
+	r.config.Scratch = config.ScratchConfig{Enabled: true, Dir: t.TempDir()}
+
+	result := r.Run(t.Context(), []string{"printenv", "WORKSPACE"}, WithEnv("WORKSPACE=/"))
+	assert.NoError(t, result.Err)
+	assert.True(t, strings.HasPrefix(stdout.String(), r.config.Scratch.Dir))
+}
+
+func TestScratch_QuotaExceeded(t *testing.T) {
+	tmpDir := t.TempDir()
+	assert.NoError(t, os.WriteFile(tmpDir+"/big", make([]byte, 2*bytesPerMegabyte), 0o600))
+	r, _ := newOptionsTestRunner(t, tmpDir)
+	r.config.Scratch = config.ScratchConfig{Enabled: true, Dir: t.TempDir(), MaxSize: 1}
+
+	result := r.RunCommand(t.Context(), `cat big > "$WORKSPACE/copy"`, tmpDir)
+	assert.IsError(t, result.Err, ErrScratchQuotaExceeded)
+
+	// Writes within the quota succeed
+	result = r.RunCommand(t.Context(), `echo small > "$WORKSPACE/copy"`, tmpDir)
+	assert.NoError(t, result.Err)
+}
@@ -0,0 +1,31 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+// errSeccompUnsupported is returned when the platform or kernel does not provide seccomp filters.
+var errSeccompUnsupported = errors.New("seccomp is not supported on this system")
+
+// seccompRestriction returns the restriction applying the seccomp profile of cmd, or nil when
+// the command runs without a filter.
+func (r *SafeRunner) seccompRestriction(cmd string) (threadRestriction, error) {
+	profile := r.validator.SeccompProfile(validator.NormalizeCommandName(cmd))
+	syscalls, ok := r.config.Seccomp.DeniedSyscalls(profile)
+	if !ok {
+		return nil, fmt.Errorf("unknown seccomp profile %q for command %q", profile, cmd)
+	}
+	if len(syscalls) == 0 {
+		return nil, nil
+	}
+
+	restrict, err := seccompFilter(syscalls, r.config.Seccomp.Action)
+	if errors.Is(err, errSeccompUnsupported) && r.config.Seccomp.BestEffort {
+		r.logger.LogErrorf("Running %s without seccomp filter: %v", cmd, err)
+		return nil, nil
+	}
+	return restrict, err
+}
@@ -0,0 +1,82 @@
+//go:build linux && (amd64 || arm64)
+
+package runner
+
+import (
+	"bytes"
+	"os/exec"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+// newSeccompTestRunner returns a runner allowing cat and unshare under seccomp, writing to the returned buffers.
+func newSeccompTestRunner(t *testing.T, seccomp config.SeccompConfig, unshareProfile string) (*SafeRunner, *bytes.Buffer, *bytes.Buffer) {
+	t.Helper()
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories: []string{t.TempDir(), "/proc"},
+		AllowCommands: []config.AllowCommand{
+			{Command: "cat"},
+			{Command: "unshare", SeccompProfile: unshareProfile},
+		},
+		DenyCommands:        []config.DenyCommand{},
+		DefaultErrorMessage: "Command not allowed",
+		MaxExecutionTime:    10,
+		Seccomp:             seccomp,
+	}
+	log := logger.New()
+	r := New(cfg, validator.New(cfg, log), log)
+	var stdout, stderr bytes.Buffer
+	r.SetOutputs(&stdout, &stderr)
+	return r, &stdout, &stderr
+}
+
+// requireUnshare skips the test unless unshare can create a user namespace without a filter.
+func requireUnshare(t *testing.T) {
+	t.Helper()
+	if err := exec.Command("unshare", "-U", "true").Run(); err != nil {
+		t.Skipf("unshare unavailable: %v", err)
+	}
+}
+
+func TestSeccomp_FiltersChildProcesses(t *testing.T) {
+	requireUnshare(t)
+	seccomp := config.SeccompConfig{
+		Enabled:  true,
+		Profiles: map[string][]string{"no-namespaces": {"unshare", "setns"}},
+	}
+
+	// Commands run under the default profile
+	r, stdout, _ := newSeccompTestRunner(t, seccomp, "")
+	result := r.RunCommand(t.Context(), "cat /proc/self/status", r.config.AllowedDirectories[0])
+	assert.NoError(t, result.Err)
+	assert.Contains(t, stdout.String(), "Seccomp:\t2")
+
+	// The default profile does not deny unshare, the selected profile does
+	result = r.RunCommand(t.Context(), "unshare -U true", r.config.AllowedDirectories[0])
+	assert.NoError(t, result.Err)
+
+	r, _, stderr := newSeccompTestRunner(t, seccomp, "no-namespaces")
+	result = r.RunCommand(t.Context(), "unshare -U true", r.config.AllowedDirectories[0])
+	assert.Error(t, result.Err)
+	assert.Contains(t, stderr.String(), "Operation not permitted")
+
+	// Without seccomp configured, commands run without a filter
+	r, stdout, _ = newSeccompTestRunner(t, config.SeccompConfig{}, "")
+	result = r.RunCommand(t.Context(), "cat /proc/self/status", r.config.AllowedDirectories[0])
+	assert.NoError(t, result.Err)
+	assert.Contains(t, stdout.String(), "Seccomp:\t0")
+}
+
+func TestSeccomp_ProfileWithoutEnabled(t *testing.T) {
+	requireUnshare(t)
+	// Selecting a profile applies it even when the default profile is not enabled
+	r, _, stderr := newSeccompTestRunner(t, config.SeccompConfig{Profiles: map[string][]string{"strict": {"unshare"}}}, "strict")
+	result := r.RunCommand(t.Context(), "unshare -U true", r.config.AllowedDirectories[0])
+	assert.Error(t, result.Err)
+	assert.Contains(t, stderr.String(), "Operation not permitted")
+}
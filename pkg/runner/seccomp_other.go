@@ -0,0 +1,8 @@
+//go:build !linux || !(amd64 || arm64)
+
+package runner
+
+// seccompFilter fails because seccomp filters are only built for Linux on amd64 and arm64.
+func seccompFilter(_ []string, _ string) (threadRestriction, error) {
+	return nil, errSeccompUnsupported
+}
@@ -0,0 +1,91 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/secrets"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+// secretState is the process-wide state behind the secrets of env. Servers create a runner per
+// execution, so resolvers are shared by every runner with the same secrets configuration to
+// reuse what they resolved.
+var secretState = struct {
+	mu        sync.Mutex
+	resolvers map[config.SecretsConfig]*secrets.Resolver
+	// providers were registered with RegisterSecretProvider
+	providers map[string]secrets.Provider
+}{
+	resolvers: make(map[config.SecretsConfig]*secrets.Resolver),
+	providers: make(map[string]secrets.Provider),
+}
+
+// RegisterSecretProvider makes p resolve the fromSecret references of env whose scheme is
+// scheme, such as a provider backed by a cloud SDK, in every runner of the process. It replaces
+// the built-in provider of the scheme, if any.
+func RegisterSecretProvider(scheme string, p secrets.Provider) {
+	secretState.mu.Lock()
+	defer secretState.mu.Unlock()
+	secretState.providers[scheme] = p
+	for _, resolver := range secretState.resolvers {
+		resolver.Register(scheme, p)
+	}
+}
+
+// secretResolver returns the resolver shared by runners with the secrets configuration cfg.
+func secretResolver(cfg config.SecretsConfig) *secrets.Resolver {
+	secretState.mu.Lock()
+	defer secretState.mu.Unlock()
+	resolver, ok := secretState.resolvers[cfg]
+	if !ok {
+		resolver = secrets.New(cfg)
+		for scheme, p := range secretState.providers {
+			resolver.Register(scheme, p)
+		}
+		secretState.resolvers[cfg] = resolver
+	}
+	return resolver
+}
+
+// configuredEnv returns the variables of env followed by those of the env of cmd's
+// allowCommands entry, which take precedence, as "NAME=value" pairs, resolving secrets. The
+// values of secrets are masked in output when redaction is enabled.
+func (r *SafeRunner) configuredEnv(ctx context.Context, cmd string) ([]string, error) {
+	vars, err := r.resolveEnv(ctx, r.config.Env)
+	if err != nil {
+		return nil, err
+	}
+	ruleVars, err := r.resolveEnv(ctx, r.validator.CommandEnv(validator.NormalizeCommandName(cmd)))
+	if err != nil {
+		return nil, err
+	}
+	return append(vars, ruleVars...), nil
+}
+
+// resolveEnv returns env as "NAME=value" pairs sorted by name, resolving secrets.
+func (r *SafeRunner) resolveEnv(ctx context.Context, env map[string]config.EnvVar) ([]string, error) {
+	if len(env) == 0 {
+		return nil, nil
+	}
+	vars := make([]string, 0, len(env))
+	for _, name := range slices.Sorted(maps.Keys(env)) {
+		v := env[name]
+		value := v.Value
+		if v.FromSecret != "" {
+			secret, err := secretResolver(r.config.Secrets).Resolve(ctx, v.FromSecret)
+			if err != nil {
+				r.logger.LogErrorf("Failed to resolve %s: %v", name, err)
+				return nil, fmt.Errorf("failed to set %s: %w", name, err)
+			}
+			r.redactor.Mask(secret)
+			value = secret
+		}
+		vars = append(vars, name+"="+value)
+	}
+	return vars, nil
+}
@@ -0,0 +1,93 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/secrets"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+func TestConfiguredEnv_InjectsSecrets(t *testing.T) {
+	tmpDir := t.TempDir()
+	secretFile := filepath.Join(tmpDir, "token")
+	assert.NoError(t, os.WriteFile(secretFile, []byte("s3cr3t-file\n"), 0o600))
+	RegisterSecretProvider("runnertest", secrets.ProviderFunc(func(_ context.Context, _ string) (string, error) {
+		return `{"password": "s3cr3t-db"}`, nil
+	}))
+
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories:  []string{tmpDir},
+		AllowCommands:       []config.AllowCommand{{Command: "printenv"}, {Command: "echo"}},
+		DenyCommands:        []config.DenyCommand{},
+		DefaultErrorMessage: "Command not allowed",
+		MaxExecutionTime:    10,
+		Redaction:           config.RedactionConfig{Enabled: true},
+		Env: map[string]config.EnvVar{
+			"GREETING":    {Value: "hello"},
+			"API_TOKEN":   {FromSecret: "file:" + secretFile},
+			"DB_PASSWORD": {FromSecret: "runnertest:db#password"},
+		},
+	}
+	log := logger.New()
+	r := New(cfg, validator.New(cfg, log), log)
+	var stdout bytes.Buffer
+	r.SetOutputs(&stdout, &bytes.Buffer{})
+
+	result := r.RunCommand(t.Context(), "printenv GREETING API_TOKEN DB_PASSWORD", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "hello\n[REDACTED]\n[REDACTED]\n", stdout.String())
+
+	// The script itself does not see the variables
+	stdout.Reset()
+	result = r.RunCommand(t.Context(), "echo \"[$API_TOKEN]\"", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "[]\n", stdout.String())
+
+	cfg.Env["API_TOKEN"] = config.EnvVar{FromSecret: "file:" + secretFile + ".missing"}
+	result = r.RunCommand(t.Context(), "printenv GREETING", tmpDir)
+	assert.Error(t, result.Err)
+	assert.Contains(t, result.Err.Error(), "failed to set API_TOKEN")
+	assert.NotContains(t, result.Err.Error(), "s3cr3t")
+}
+
+func TestConfiguredEnv_RuleEnv(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories: []string{tmpDir},
+		AllowCommands: []config.AllowCommand{
+			{Command: "printenv", Env: map[string]config.EnvVar{
+				"GIT_TERMINAL_PROMPT": {Value: "0"},
+				"GREETING":            {Value: "rule"},
+			}},
+			{Command: "env"},
+		},
+		DenyCommands:        []config.DenyCommand{},
+		DefaultErrorMessage: "Command not allowed",
+		MaxExecutionTime:    10,
+		Env:                 map[string]config.EnvVar{"GREETING": {Value: "hello"}},
+	}
+	log := logger.New()
+	r := New(cfg, validator.New(cfg, log), log)
+	var stdout bytes.Buffer
+	r.SetOutputs(&stdout, &bytes.Buffer{})
+
+	// The rule's variables take precedence over env and over variables the script sets
+	result := r.RunCommand(t.Context(), "GIT_TERMINAL_PROMPT=1 printenv GIT_TERMINAL_PROMPT GREETING", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "0\nrule\n", stdout.String())
+
+	// Other commands do not receive them
+	stdout.Reset()
+	result = r.RunCommand(t.Context(), "env", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.Contains(t, stdout.String(), "GREETING=hello\n")
+	assert.NotContains(t, stdout.String(), "GIT_TERMINAL_PROMPT=0")
+}
@@ -0,0 +1,107 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// SignalError is the error of an execution stopped by a signal forwarded with Signal. Its exit
+// code is 128 plus the signal number, as a shell reports a command killed by the signal.
+type SignalError struct {
+	Signal os.Signal
+}
+
+func (e *SignalError) Error() string {
+	return fmt.Sprintf("execution stopped by signal: %v", e.Signal)
+}
+
+// exitCode returns the status of a command killed by the signal.
+func (e *SignalError) exitCode() int {
+	if s, ok := e.Signal.(syscall.Signal); ok {
+		return exitSignalOffset + int(s)
+	}
+	return ExitFailure
+}
+
+// signalState is what Signal needs of the runner: the processes it may forward a signal to,
+// and the signal that stopped the current script, if any.
+type signalState struct {
+	mu          sync.Mutex
+	processes   map[*process]struct{}
+	interrupted os.Signal
+}
+
+// Signal forwards sig to the process group of every external command the runner is running and
+// returns how many it was sent to. Any signal but SIGWINCH also stops the script: it starts no
+// further commands, and fails with a *SignalError unless it has finished already. A signal sent
+// while no script runs stops the next one.
+func (r *SafeRunner) Signal(sig os.Signal) int {
+	r.signals.mu.Lock()
+	defer r.signals.mu.Unlock()
+	if !isResizeSignal(sig) {
+		r.signals.interrupted = sig
+	}
+	for p := range r.signals.processes {
+		p.signal(sig)
+	}
+	return len(r.signals.processes)
+}
+
+// ForwardSignals forwards the signals a terminal sends a shell, SIGINT, SIGTERM, and SIGWINCH,
+// to the runner's commands with Signal until the returned function is called, so that Ctrl-C
+// stops the command running in a CLI instead of leaving it behind.
+func (r *SafeRunner) ForwardSignals() (stop func()) {
+	ch := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(ch, forwardedSignals...)
+	go func() {
+		for {
+			select {
+			case sig := <-ch:
+				r.logger.LogInfof("Forwarding signal %v", sig)
+				r.Signal(sig)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		close(done)
+	}
+}
+
+// interruption returns the error of a script stopped by Signal, or nil.
+func (r *SafeRunner) interruption() error {
+	r.signals.mu.Lock()
+	defer r.signals.mu.Unlock()
+	if r.signals.interrupted == nil {
+		return nil
+	}
+	return &SignalError{Signal: r.signals.interrupted}
+}
+
+// clearInterruption forgets the signal that stopped the script that finished.
+func (r *SafeRunner) clearInterruption() {
+	r.signals.mu.Lock()
+	defer r.signals.mu.Unlock()
+	r.signals.interrupted = nil
+}
+
+// trackProcess makes Signal reach p until the returned function is called.
+func (r *SafeRunner) trackProcess(p *process) func() {
+	r.signals.mu.Lock()
+	defer r.signals.mu.Unlock()
+	if r.signals.processes == nil {
+		r.signals.processes = make(map[*process]struct{})
+	}
+	r.signals.processes[p] = struct{}{}
+	return func() {
+		r.signals.mu.Lock()
+		defer r.signals.mu.Unlock()
+		delete(r.signals.processes, p)
+	}
+}
@@ -0,0 +1,46 @@
+package runner
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+// trackedProcesses returns how many processes Signal would reach.
+func (r *SafeRunner) trackedProcesses() int {
+	r.signals.mu.Lock()
+	defer r.signals.mu.Unlock()
+	return len(r.signals.processes)
+}
+
+func TestSignal_StopsScript(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, stdout := newOptionsTestRunner(t, tmpDir)
+
+	done := make(chan RunResult, 1)
+	go func() { done <- r.RunCommand(t.Context(), "sleep 10; echo never", tmpDir) }()
+	for r.trackedProcesses() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.Equal(t, 1, r.Signal(os.Interrupt))
+
+	select {
+	case result := <-done:
+		var sigErr *SignalError
+		assert.True(t, errors.As(result.Err, &sigErr))
+		assert.Equal(t, os.Interrupt, sigErr.Signal)
+		assert.Equal(t, 130, ExitCode(result.Err))
+	case <-time.After(5 * time.Second):
+		t.Fatal("execution was not stopped")
+	}
+	assert.Equal(t, "", stdout.String())
+
+	// The signal stopped only the script that was running
+	result := r.RunCommand(t.Context(), "echo after", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "after\n", stdout.String())
+}
@@ -0,0 +1,94 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/snapshot"
+)
+
+// errSnapshotsDisabled is returned when a snapshot is chosen but snapshots are not enabled.
+var errSnapshotsDisabled = errors.New("snapshots are disabled")
+
+// SetSnapshots keeps the snapshots of executions in s instead of the runner's own store.
+// Servers share one store so that later requests can commit or discard them.
+func (r *SafeRunner) SetSnapshots(s *snapshot.Store) {
+	r.snapshots = s
+}
+
+// SetSnapshot runs the following executions in the existing snapshot with the given ID, so
+// that they see the changes of earlier ones, instead of in a new snapshot each.
+func (r *SafeRunner) SetSnapshot(id string) {
+	r.snapshotID = id
+}
+
+// setupSnapshot runs an execution in a snapshot of its working directory: a new one, or the
+// one chosen with SetSnapshot. The snapshot replaces the allowed directories for the duration
+// of the execution, so that nothing outside it can be changed. The returned function restores
+// the policy, reports a directory changed with cd as the matching directory of the source,
+// and keeps the snapshot in result if the execution changed it or discards it.
+func (r *SafeRunner) setupSnapshot(settings *execSettings) (func(result *RunResult), error) {
+	if r.snapshots == nil {
+		return nil, invalidError(errSnapshotsDisabled)
+	}
+	workDir, err := filepath.Abs(settings.workDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path for working directory: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(workDir); err == nil {
+		workDir = resolved
+	}
+
+	// Snapshots are only taken of, and used by callers allowed in, the allowed directories
+	source := workDir
+	var snap *snapshot.Snapshot
+	if r.snapshotID != "" {
+		if snap, err = r.snapshots.Get(r.snapshotID); err != nil {
+			return nil, invalidError(err)
+		}
+		source = snap.Source
+	}
+	if allowed, message := r.validator.IsDirectoryAllowed(source); !allowed {
+		r.logger.LogErrorf("Directory validation failed: %s", message)
+		return nil, deniedError("directory validation failed: " + message)
+	}
+	if snap == nil {
+		if snap, err = r.snapshots.Create(workDir); err != nil {
+			return nil, err
+		}
+	}
+	dir, ok := snap.Path(workDir)
+	if !ok {
+		return nil, invalidError(fmt.Errorf("working directory %s is outside snapshot %s of %s", workDir, snap.ID, snap.Source))
+	}
+	settings.workDir = dir
+
+	config, validator := r.config, r.validator
+	snapshotConfig := *config
+	snapshotConfig.AllowedDirectories = []string{snap.Dir}
+	r.config, r.validator = &snapshotConfig, validator.WithConfig(&snapshotConfig)
+
+	return func(result *RunResult) {
+		r.config, r.validator = config, validator
+		if result.NewWorkDir != "" {
+			result.NewWorkDir, _ = snap.SourcePath(result.NewWorkDir)
+		}
+		result.Snapshot = snap
+		if r.snapshotID != "" {
+			return
+		}
+		changes, err := snap.Changes()
+		if err != nil {
+			r.logger.LogErrorf("Failed to compare snapshot %s: %v", snap.ID, err)
+			return
+		}
+		if len(changes) > 0 {
+			return
+		}
+		result.Snapshot = nil
+		if err := snap.Discard(); err != nil {
+			r.logger.LogErrorf("Failed to discard snapshot %s: %v", snap.ID, err)
+		}
+	}, nil
+}
@@ -0,0 +1,68 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/snapshot"
+)
+
+func TestSnapshot_CommitAndDiscard(t *testing.T) {
+	tmpDir := t.TempDir()
+	notes := filepath.Join(tmpDir, "notes.txt")
+	assert.NoError(t, os.WriteFile(notes, []byte("original\n"), 0o600))
+	r, stdout := newOptionsTestRunner(t, tmpDir)
+	store := snapshot.New(config.SnapshotConfig{Enabled: true, Dir: t.TempDir()})
+	defer store.Close()
+	r.SetSnapshots(store)
+
+	// Commands that change nothing leave no snapshot behind; cd reports the original directory
+	assert.NoError(t, os.Mkdir(filepath.Join(tmpDir, "sub"), 0o700))
+	result := r.RunCommand(t.Context(), "cat notes.txt; cd sub", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.Zero(t, result.Snapshot)
+	assert.Equal(t, "original\n", stdout.String())
+	assert.Equal(t, filepath.Join(tmpDir, "sub"), result.NewWorkDir)
+
+	result = r.RunCommand(t.Context(), "echo changed > notes.txt && echo new > added.txt", tmpDir)
+	assert.NoError(t, result.Err)
+	assert.NotZero(t, result.Snapshot)
+	changes, err := result.Snapshot.Changes()
+	assert.NoError(t, err)
+	assert.Equal(t, []snapshot.Change{{Path: "added.txt", Kind: snapshot.Added}, {Path: "notes.txt", Kind: snapshot.Modified}}, changes)
+
+	// The directory is unchanged, and the original cannot be reached from the snapshot
+	data, err := os.ReadFile(notes)
+	assert.NoError(t, err)
+	assert.Equal(t, "original\n", string(data))
+	assert.Error(t, r.RunCommand(t.Context(), "echo changed > "+notes, tmpDir).Err)
+	assert.Equal(t, []string{tmpDir}, r.config.AllowedDirectories)
+
+	// Later executions can continue in the snapshot
+	stdout.Reset()
+	r.SetSnapshot(result.Snapshot.ID)
+	assert.NoError(t, r.RunCommand(t.Context(), "cat notes.txt", tmpDir).Err)
+	assert.Equal(t, "changed\n", stdout.String())
+
+	_, err = result.Snapshot.Commit()
+	assert.NoError(t, err)
+	data, err = os.ReadFile(notes)
+	assert.NoError(t, err)
+	assert.Equal(t, "changed\n", string(data))
+	_, err = os.Stat(filepath.Join(tmpDir, "added.txt"))
+	assert.NoError(t, err)
+
+	// A committed snapshot is gone
+	assert.IsError(t, r.RunCommand(t.Context(), "cat notes.txt", tmpDir).Err, snapshot.ErrNotFound)
+}
+
+func TestSnapshot_Disabled(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, _ := newOptionsTestRunner(t, tmpDir)
+	r.SetSnapshot("missing")
+	assert.IsError(t, r.RunCommand(t.Context(), "ls", tmpDir).Err, errSnapshotsDisabled)
+}
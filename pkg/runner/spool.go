@@ -0,0 +1,63 @@
+package runner
+
+import (
+	"io"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/limiter"
+	"github.com/shimizu1995/secure-shell-server/pkg/spool"
+)
+
+// SetSpool keeps the full output of executions whose output is truncated in s instead of
+// the runner's own store. Servers share one store so that later requests can page through
+// the output. It must be called before SetOutputs.
+func (r *SafeRunner) SetSpool(s *spool.Store) {
+	r.spool = s
+}
+
+// spoolTee copies an output stream into a spooled output, started on the first write.
+type spoolTee struct {
+	store *spool.Store
+	out   *spool.Output
+}
+
+// Write implements io.Writer.
+func (t *spoolTee) Write(p []byte) (int, error) {
+	if t.out == nil {
+		t.out = t.store.Create()
+	}
+	return t.out.Write(p)
+}
+
+// teeSpool returns w writing also to a spooled output when spooling is enabled.
+func (r *SafeRunner) teeSpool(w io.Writer) (io.Writer, *spoolTee) {
+	if r.spool == nil {
+		return w, nil
+	}
+	tee := &spoolTee{store: r.spool}
+	return io.MultiWriter(w, tee), tee
+}
+
+// finishSpools keeps the spooled outputs of streams that were truncated, returning them in
+// result, and discards the others. The next run starts new spooled outputs.
+func (r *SafeRunner) finishSpools(result *RunResult) {
+	result.StdoutSpool = r.finishSpool(r.stdoutSpool, r.stdoutLimiter)
+	result.StderrSpool = r.finishSpool(r.stderrSpool, r.stderrLimiter)
+}
+
+// finishSpool keeps the spooled output of one stream if its limiter truncated it.
+func (r *SafeRunner) finishSpool(tee *spoolTee, l *limiter.OutputLimiter) *spool.Output {
+	if tee == nil || tee.out == nil {
+		return nil
+	}
+	out := tee.out
+	tee.out = nil
+	if !l.WasTruncated() {
+		out.Discard()
+		return nil
+	}
+	if err := out.Keep(); err != nil {
+		r.logger.LogErrorf("Failed to spool output: %v", err)
+		return nil
+	}
+	return out
+}
@@ -0,0 +1,40 @@
+package runner
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/spool"
+)
+
+func TestRun_SpoolsTruncatedOutput(t *testing.T) {
+	tmpDir := t.TempDir()
+	spoolDir := t.TempDir()
+	r, stdout := newOptionsTestRunner(t, tmpDir)
+	r.SetSpool(spool.New(config.OutputSpoolConfig{Enabled: true, Dir: spoolDir}))
+	r.SetOutputs(stdout, stdout)
+
+	long := strings.Repeat("x", 100)
+	result := r.Run(t.Context(), []string{"echo", long + "END"}, WithMaxOutput(10))
+	assert.NoError(t, result.Err)
+	assert.True(t, strings.HasPrefix(stdout.String(), "xxxxxxxxxx"))
+	assert.NotZero(t, result.StdoutSpool)
+	assert.Zero(t, result.StderrSpool)
+
+	assert.Equal(t, int64(len(long)+4), result.StdoutSpool.Size())
+	tail, err := result.StdoutSpool.Tail(4)
+	assert.NoError(t, err)
+	assert.Equal(t, "END\n", string(tail))
+
+	// Output within the limit is not kept
+	result = r.Run(t.Context(), []string{"echo", "short"}, WithMaxOutput(100))
+	assert.NoError(t, result.Err)
+	assert.Zero(t, result.StdoutSpool)
+	entries, err := os.ReadDir(spoolDir)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+}
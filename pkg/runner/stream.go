@@ -0,0 +1,157 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/limiter"
+	"github.com/shimizu1995/secure-shell-server/pkg/redact"
+)
+
+// ErrStreamAborted is returned when a StreamFunc returns an error and execution is stopped.
+var ErrStreamAborted = errors.New("output stream aborted")
+
+// Stream identifies the output stream a chunk was written to.
+type Stream string
+
+const (
+	// StreamStdout is standard output.
+	StreamStdout Stream = "stdout"
+	// StreamStderr is standard error.
+	StreamStderr Stream = "stderr"
+)
+
+// OutputChunk is a piece of output delivered by RunScriptStream as it is produced.
+type OutputChunk struct {
+	Stream Stream
+	Data   []byte
+	// Time is when the chunk was written.
+	Time time.Time
+}
+
+// StreamFunc receives output chunks. Returning an error stops the script.
+type StreamFunc func(chunk OutputChunk) error
+
+// RunScriptStream runs a script like Run, delivering its output to fn as it is produced
+// instead of to the writers passed to SetOutputs. Output is limited and redacted before
+// it reaches fn, and calls to fn are never concurrent. If fn returns an error, the script
+// is stopped and the result's error wraps both ErrStreamAborted and the error from fn.
+func (r *SafeRunner) RunScriptStream(ctx context.Context, script string, fn StreamFunc, opts ...ExecOption) RunResult {
+	settings, err := r.resolveOptions(ctx, opts)
+	if err != nil {
+		return RunResult{Err: invalidError(err)}
+	}
+	if settings.outputFile != "" {
+		return RunResult{Err: invalidError(fmt.Errorf("%w: streamed output is not written to output files", ErrOptionNotPermitted))}
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	sink := &streamSink{fn: fn, cancel: cancel}
+
+	var stdout, stderr io.Writer = sink.writer(StreamStdout), sink.writer(StreamStderr)
+	if r.recorder != nil {
+		stdout = io.MultiWriter(stdout, r.recorder.Stdout())
+		stderr = io.MultiWriter(stderr, r.recorder.Stderr())
+	}
+
+	// The writers set by SetOutputs are used again once the script finishes
+	saved := r.saveOutputs()
+	defer r.restoreOutputs(saved)
+	r.baseStdout, r.baseStderr = stdout, stderr
+	r.limitOutputs(settings.maxOutput)
+
+	result := r.run(ctx, script, settings)
+	if err := sink.err(); err != nil {
+		result.Err = asExitError(fmt.Errorf("%w: %w", ErrStreamAborted, err))
+	}
+	return result
+}
+
+// streamSink passes writes to a StreamFunc, serializing calls and recording the first error.
+type streamSink struct {
+	fn     StreamFunc
+	cancel context.CancelCauseFunc
+
+	mu      sync.Mutex
+	aborted error
+}
+
+// writer returns a writer delivering chunks of stream to the sink.
+func (s *streamSink) writer(stream Stream) io.Writer {
+	return &streamWriter{sink: s, stream: stream}
+}
+
+// send delivers a chunk unless the stream has already been aborted.
+func (s *streamSink) send(stream Stream, p []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.aborted != nil {
+		return s.aborted
+	}
+	// The caller may reuse p after Write returns
+	chunk := OutputChunk{Stream: stream, Data: append([]byte(nil), p...), Time: time.Now()}
+	if err := s.fn(chunk); err != nil {
+		s.aborted = err
+		s.cancel(err)
+		return err
+	}
+	return nil
+}
+
+// err returns the error returned by the StreamFunc, if any.
+func (s *streamSink) err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.aborted
+}
+
+// streamWriter is the writer of a single stream of a streamSink.
+type streamWriter struct {
+	sink   *streamSink
+	stream Stream
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := w.sink.send(w.stream, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// outputState is a copy of the runner's output writers.
+type outputState struct {
+	stdout, stderr                 io.Writer
+	baseStdout, baseStderr         io.Writer
+	stdoutLimiter, stderrLimiter   *limiter.OutputLimiter
+	stdoutRedactor, stderrRedactor *redact.Writer
+}
+
+// saveOutputs returns the current output writers.
+func (r *SafeRunner) saveOutputs() outputState {
+	return outputState{
+		stdout:         r.stdout,
+		stderr:         r.stderr,
+		baseStdout:     r.baseStdout,
+		baseStderr:     r.baseStderr,
+		stdoutLimiter:  r.stdoutLimiter,
+		stderrLimiter:  r.stderrLimiter,
+		stdoutRedactor: r.stdoutRedactor,
+		stderrRedactor: r.stderrRedactor,
+	}
+}
+
+// restoreOutputs sets the output writers saved by saveOutputs.
+func (r *SafeRunner) restoreOutputs(s outputState) {
+	r.stdout, r.stderr = s.stdout, s.stderr
+	r.baseStdout, r.baseStderr = s.baseStdout, s.baseStderr
+	r.stdoutLimiter, r.stderrLimiter = s.stdoutLimiter, s.stderrLimiter
+	r.stdoutRedactor, r.stderrRedactor = s.stdoutRedactor, s.stderrRedactor
+}
@@ -0,0 +1,58 @@
+package runner
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestRunScriptStream(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, stdout := newOptionsTestRunner(t, tmpDir)
+
+	var chunks []OutputChunk
+	start := time.Now()
+	result := r.RunScriptStream(t.Context(), "echo first; cat ./missing.txt; echo second", func(chunk OutputChunk) error {
+		chunks = append(chunks, chunk)
+		return nil
+	}, WithWorkdir(tmpDir))
+	assert.NoError(t, result.Err)
+
+	var streams []Stream
+	var out string
+	for _, chunk := range chunks {
+		streams = append(streams, chunk.Stream)
+		if chunk.Stream == StreamStdout {
+			out += string(chunk.Data)
+		}
+		assert.False(t, chunk.Time.Before(start))
+	}
+	// A command may write its output in several chunks
+	assert.Equal(t, []Stream{StreamStdout, StreamStderr, StreamStdout}, slices.Compact(streams))
+	assert.Equal(t, "first\nsecond\n", out)
+
+	// The writers passed to SetOutputs are used again afterwards
+	assert.Equal(t, "", stdout.String())
+	assert.NoError(t, r.RunCommand(t.Context(), "echo after", tmpDir).Err)
+	assert.Equal(t, "after\n", stdout.String())
+}
+
+func TestRunScriptStream_Abort(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, _ := newOptionsTestRunner(t, tmpDir)
+	errClosed := errors.New("client disconnected")
+
+	var received int
+	start := time.Now()
+	result := r.RunScriptStream(t.Context(), "echo started; sleep 5; echo finished", func(OutputChunk) error {
+		received++
+		return errClosed
+	})
+	assert.IsError(t, result.Err, ErrStreamAborted)
+	assert.IsError(t, result.Err, errClosed)
+	assert.Equal(t, 1, received)
+	assert.True(t, time.Since(start) < 4*time.Second, "the script was not stopped")
+}
@@ -0,0 +1,43 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/cmdtemplate"
+)
+
+// ErrUnknownTemplate is returned by RunTemplate for a name that is not in the configuration's templates.
+var ErrUnknownTemplate = errors.New("unknown command template")
+
+// RunTemplate runs the configured command template called name with params substituted
+// for its placeholders. Each value is checked against the type of its parameter and
+// shell-quoted, so it is passed as a single argument however it is written. The
+// expanded script is validated like any other script.
+func (r *SafeRunner) RunTemplate(ctx context.Context, name string, params map[string]string, opts ...ExecOption) RunResult {
+	text, ok := r.config.Templates[name]
+	if !ok {
+		return RunResult{Err: invalidError(fmt.Errorf("%w: %s", ErrUnknownTemplate, name))}
+	}
+	tmpl, err := cmdtemplate.Parse(name, text)
+	if err != nil {
+		return RunResult{Err: invalidError(err)}
+	}
+	script, err := tmpl.Expand(params)
+	if err != nil {
+		return RunResult{Err: invalidError(err)}
+	}
+
+	settings, err := r.resolveOptions(ctx, opts)
+	if err != nil {
+		return RunResult{Err: invalidError(err)}
+	}
+	finish, err := r.applyOutputs(settings)
+	if err != nil {
+		return RunResult{Err: asExitError(err)}
+	}
+	result := r.run(ctx, script, settings)
+	finish(&result)
+	return result
+}
@@ -0,0 +1,47 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/cmdtemplate"
+)
+
+func TestRunTemplate(t *testing.T) {
+	tmpDir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "notes.txt"), []byte("hello\n"), 0o600))
+	r, stdout := newOptionsTestRunner(t, tmpDir)
+	r.config.Templates = map[string]string{
+		"show":  "cat {{file:regexp:[a-z]+\\.txt}}",
+		"greet": "echo {{greeting:enum:hello|hi}} {{name}}",
+	}
+
+	result := r.RunTemplate(t.Context(), "show", map[string]string{"file": "notes.txt"})
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "hello\n", stdout.String())
+
+	// Values are passed as a single argument and never interpreted by the shell
+	stdout.Reset()
+	result = r.RunTemplate(t.Context(), "greet", map[string]string{"greeting": "hi", "name": "$(rm -rf /); `id`"})
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "hi $(rm -rf /); `id`\n", stdout.String())
+
+	result = r.RunTemplate(t.Context(), "show", map[string]string{"file": "../etc/passwd"})
+	assert.IsError(t, result.Err, cmdtemplate.ErrInvalidParameter)
+
+	result = r.RunTemplate(t.Context(), "missing", nil)
+	assert.IsError(t, result.Err, ErrUnknownTemplate)
+}
+
+func TestRunTemplate_StillValidated(t *testing.T) {
+	tmpDir := t.TempDir()
+	r, _ := newOptionsTestRunner(t, tmpDir)
+	r.config.Templates = map[string]string{"remove": "rm {{file}}"}
+
+	result := r.RunTemplate(t.Context(), "remove", map[string]string{"file": "x"})
+	assert.Error(t, result.Err)
+	assert.Contains(t, result.Err.Error(), "not allowed")
+}
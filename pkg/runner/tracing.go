@@ -0,0 +1,85 @@
+package runner
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"mvdan.cc/sh/v3/interp"
+)
+
+// tracerName identifies the spans emitted by the runner.
+const tracerName = "github.com/shimizu1995/secure-shell-server/pkg/runner"
+
+// Span names.
+const (
+	spanRun      = "shell.run"
+	spanValidate = "shell.validate"
+	spanPolicy   = "shell.policy"
+	spanExec     = "shell.exec"
+)
+
+// Span attribute keys.
+const (
+	attrCommand     = attribute.Key("shell.command")
+	attrCommandName = attribute.Key("shell.command.name")
+	attrWorkDir     = attribute.Key("shell.work_dir")
+	attrCaller      = attribute.Key("shell.caller")
+	attrDecision    = attribute.Key("shell.decision")
+	attrExitCode    = attribute.Key("shell.exit_code")
+	attrTruncated   = attribute.Key("shell.output.truncated")
+	attrInProcess   = attribute.Key("shell.in_process")
+	attrCached      = attribute.Key("shell.cached")
+	attrRiskScore   = attribute.Key("shell.risk.score")
+)
+
+// Values of the shell.decision attribute.
+const (
+	decisionAllow = "allow"
+	decisionDeny  = "deny"
+)
+
+// SetTracerProvider makes the runner emit spans through tp. By default the global
+// provider registered with otel.SetTracerProvider is used.
+func (r *SafeRunner) SetTracerProvider(tp trace.TracerProvider) {
+	r.tracer = tp.Tracer(tracerName)
+}
+
+// startSpan starts a span as a child of the span in ctx.
+func (r *SafeRunner) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return r.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// defaultTracer returns the tracer used until SetTracerProvider is called.
+func defaultTracer() trace.Tracer {
+	return otel.GetTracerProvider().Tracer(tracerName)
+}
+
+// endSpan records the outcome of err on span and ends it. Non-zero exit statuses are
+// recorded as the exit code; other errors mark the span as failed.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		if status, ok := interp.IsExitStatus(err); ok {
+			span.SetAttributes(attrExitCode.Int(int(status)))
+		} else {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+	} else {
+		span.SetAttributes(attrExitCode.Int(0))
+	}
+	span.End()
+}
+
+// endDecisionSpan records a policy decision on span and ends it.
+func endDecisionSpan(span trace.Span, allowed bool, message string) {
+	if allowed {
+		span.SetAttributes(attrDecision.String(decisionAllow))
+	} else {
+		span.SetAttributes(attrDecision.String(decisionDeny))
+		span.SetStatus(codes.Error, message)
+	}
+	span.End()
+}
@@ -0,0 +1,107 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// spanAttr returns the value of key on span, or an invalid value if it is not set.
+func spanAttr(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+// spansNamed returns the recorded spans with the given name.
+func spansNamed(spans []sdktrace.ReadOnlySpan, name string) []sdktrace.ReadOnlySpan {
+	var out []sdktrace.ReadOnlySpan
+	for _, span := range spans {
+		if span.Name() == name {
+			out = append(out, span)
+		}
+	}
+	return out
+}
+
+func TestSafeRunner_Tracing(t *testing.T) {
+	tmpDir := t.TempDir()
+	r := newHintTestRunner(t, tmpDir)
+	recorder := tracetest.NewSpanRecorder()
+	r.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	result := r.RunCommand(t.Context(), "ls && echo done && ls /nonexistent-dir-for-tracing", tmpDir)
+	assert.Error(t, result.Err)
+
+	spans := recorder.Ended()
+	runs := spansNamed(spans, spanRun)
+	assert.Equal(t, 1, len(runs))
+	run := runs[0]
+	assert.Equal(t, "ls && echo done && ls /nonexistent-dir-for-tracing", spanAttr(run, attrCommand).AsString())
+	assert.False(t, spanAttr(run, attrTruncated).AsBool())
+
+	validations := spansNamed(spans, spanValidate)
+	assert.Equal(t, 1, len(validations))
+	assert.Equal(t, decisionAllow, spanAttr(validations[0], attrDecision).AsString())
+	assert.Equal(t, run.SpanContext().SpanID(), validations[0].Parent().SpanID())
+
+	// The path outside the allowed directories is denied by policy
+	policies := spansNamed(spans, spanPolicy)
+	assert.Equal(t, 3, len(policies))
+	assert.Equal(t, "ls", spanAttr(policies[0], attrCommandName).AsString())
+	assert.Equal(t, decisionAllow, spanAttr(policies[0], attrDecision).AsString())
+	assert.Equal(t, "echo", spanAttr(policies[1], attrCommandName).AsString())
+	assert.Equal(t, decisionDeny, spanAttr(policies[2], attrDecision).AsString())
+	assert.Equal(t, codes.Error, policies[2].Status().Code)
+
+	// Only the allowed external command is executed
+	execs := spansNamed(spans, spanExec)
+	assert.Equal(t, 1, len(execs))
+	assert.Equal(t, "ls", spanAttr(execs[0], attrCommandName).AsString())
+	assert.Equal(t, int64(0), spanAttr(execs[0], attrExitCode).AsInt64())
+	assert.False(t, spanAttr(execs[0], attrInProcess).AsBool())
+	assert.Equal(t, run.SpanContext().TraceID(), execs[0].SpanContext().TraceID())
+}
+
+func TestSafeRunner_TracingExitCode(t *testing.T) {
+	tmpDir := t.TempDir()
+	r := newHintTestRunner(t, tmpDir)
+	recorder := tracetest.NewSpanRecorder()
+	r.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	result := r.RunCommand(t.Context(), "cat missing.txt", tmpDir)
+	assert.Error(t, result.Err)
+
+	spans := recorder.Ended()
+	execs := spansNamed(spans, spanExec)
+	assert.Equal(t, 1, len(execs))
+	assert.Equal(t, int64(1), spanAttr(execs[0], attrExitCode).AsInt64())
+
+	runs := spansNamed(spans, spanRun)
+	assert.Equal(t, 1, len(runs))
+	assert.Equal(t, int64(1), spanAttr(runs[0], attrExitCode).AsInt64())
+}
+
+func TestSafeRunner_TracingParseError(t *testing.T) {
+	tmpDir := t.TempDir()
+	r := newHintTestRunner(t, tmpDir)
+	recorder := tracetest.NewSpanRecorder()
+	r.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	result := r.RunCommand(t.Context(), "echo 'unterminated", tmpDir)
+	assert.Error(t, result.Err)
+
+	spans := recorder.Ended()
+	validations := spansNamed(spans, spanValidate)
+	assert.Equal(t, 1, len(validations))
+	assert.Equal(t, decisionDeny, spanAttr(validations[0], attrDecision).AsString())
+	assert.Equal(t, 0, len(spansNamed(spans, spanExec)))
+	assert.Equal(t, codes.Error, spansNamed(spans, spanRun)[0].Status().Code)
+}
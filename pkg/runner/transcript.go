@@ -0,0 +1,55 @@
+package runner
+
+import (
+	"context"
+	"strings"
+)
+
+// Transcript is the output of an execution as a single time-ordered sequence of chunks,
+// each tagged with its stream, so it can reproduce what a terminal would have shown.
+type Transcript struct {
+	Chunks []OutputChunk
+}
+
+// String returns stdout and stderr interleaved in the order they were written.
+func (t *Transcript) String() string {
+	var b strings.Builder
+	for _, chunk := range t.Chunks {
+		b.Write(chunk.Data)
+	}
+	return b.String()
+}
+
+// Stdout returns everything written to standard output.
+func (t *Transcript) Stdout() string {
+	return t.stream(StreamStdout)
+}
+
+// Stderr returns everything written to standard error.
+func (t *Transcript) Stderr() string {
+	return t.stream(StreamStderr)
+}
+
+// stream returns the data of the chunks written to s.
+func (t *Transcript) stream(s Stream) string {
+	var b strings.Builder
+	for _, chunk := range t.Chunks {
+		if chunk.Stream == s {
+			b.Write(chunk.Data)
+		}
+	}
+	return b.String()
+}
+
+// RunScriptCapture runs a script like RunScriptStream and returns its output in
+// RunResult.Transcript, preserving the interleaving of stdout and stderr.
+func (r *SafeRunner) RunScriptCapture(ctx context.Context, script string, opts ...ExecOption) RunResult {
+	transcript := &Transcript{}
+	result := r.RunScriptStream(ctx, script, func(chunk OutputChunk) error {
+		// Calls are serialized by RunScriptStream
+		transcript.Chunks = append(transcript.Chunks, chunk)
+		return nil
+	}, opts...)
+	result.Transcript = transcript
+	return result
+}
@@ -0,0 +1,37 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+func TestRunScriptCapture_Interleaving(t *testing.T) {
+	tmpDir := t.TempDir()
+	// A single process alternates between its outputs; the pauses make the order unambiguous
+	script := "echo out1; sleep 0.1; echo err1 >&2; sleep 0.1; echo out2; sleep 0.1; echo err2 >&2\n"
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "alternate.sh"), []byte(script), 0o600))
+	r, _ := newOptionsTestRunner(t, tmpDir)
+	r.config.AllowCommands = append(r.config.AllowCommands, config.AllowCommand{Command: "sh"})
+
+	result := r.RunScriptCapture(t.Context(), "echo start; sh alternate.sh; echo end")
+	assert.NoError(t, result.Err)
+
+	transcript := result.Transcript
+	assert.NotZero(t, transcript)
+	assert.Equal(t, "start\nout1\nerr1\nout2\nerr2\nend\n", transcript.String())
+	assert.Equal(t, "start\nout1\nout2\nend\n", transcript.Stdout())
+	assert.Equal(t, "err1\nerr2\n", transcript.Stderr())
+
+	var streams []Stream
+	for _, chunk := range transcript.Chunks {
+		if len(streams) == 0 || streams[len(streams)-1] != chunk.Stream {
+			streams = append(streams, chunk.Stream)
+		}
+	}
+	assert.Equal(t, []Stream{StreamStdout, StreamStderr, StreamStdout, StreamStderr, StreamStdout}, streams)
+}
@@ -0,0 +1,177 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"mvdan.cc/sh/v3/interp"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+// defaultTrashSession is the trash session of executions whose caller names no session.
+const defaultTrashSession = "default"
+
+// errDirectoryNotEmpty is worded as coreutils prints it.
+var errDirectoryNotEmpty = errors.New("Directory not empty") //nolint:stylecheck // matches coreutils output
+
+// trashCommands are the commands that delete files, which move them into the trash instead
+// when trash is enabled.
+var trashCommands = map[string]inProcessFunc{
+	"rm":     (*SafeRunner).rmCommand,
+	"unlink": (*SafeRunner).unlinkCommand,
+}
+
+// TrashSession returns the session whose trash the files deleted by the runner go to: the
+// session of its caller (see SetIdentity), or "default".
+func (r *SafeRunner) TrashSession() string {
+	if r.identity.Session != "" {
+		return r.identity.Session
+	}
+	return defaultTrashSession
+}
+
+// lookupTrash returns the implementation of cmd as a move into the trash, if the trash is
+// enabled and cmd deletes files.
+func (r *SafeRunner) lookupTrash(cmd string) (inProcessFunc, bool) {
+	if r.trash == nil {
+		return nil, false
+	}
+	fn, ok := trashCommands[validator.NormalizeCommandName(cmd)]
+	return fn, ok
+}
+
+// trashPath returns the absolute path of the file name names, resolving symlinks in the
+// directories leading to it but not in the file itself, which rm removes rather than its target.
+func (r *SafeRunner) trashPath(hc interp.HandlerContext, name string) (string, error) {
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(hc.Dir, path)
+	}
+	path = filepath.Clean(path)
+	if resolved, err := filepath.EvalSymlinks(filepath.Dir(path)); err == nil {
+		path = filepath.Join(resolved, filepath.Base(path))
+	}
+	if allowed, msg := r.validator.IsPathInAllowedDirectory(path, "/"); !allowed {
+		return "", fmt.Errorf("access denied: %s", msg)
+	}
+	return path, nil
+}
+
+// moveToTrash moves the file at path into the trash and logs where it went.
+func (r *SafeRunner) moveToTrash(path string) error {
+	item, err := r.trash.Delete(r.TrashSession(), path)
+	if err != nil {
+		return err
+	}
+	r.logger.LogInfof("Moved %s to trash item %s of session %s", path, item.ID, item.Session)
+	return nil
+}
+
+// rmCommand implements rm [-rRfdv] file... by moving the files into the trash.
+func (r *SafeRunner) rmCommand(hc interp.HandlerContext, args []string) error {
+	e := &commandError{hc: hc, name: "rm"}
+	flags, names, err := parseShortFlags(rmLongOptions(args[1:]), "")
+	if err != nil {
+		return e.usage("%v", err)
+	}
+	if c, ok := checkFlags(flags, "rRfdv"); !ok {
+		return e.usage("unsupported option -- '%c'", c)
+	}
+	_, recursive := flags['r']
+	if _, ok := flags['R']; ok {
+		recursive = true
+	}
+	_, force := flags['f']
+	_, dirs := flags['d']
+	_, verbose := flags['v']
+
+	if len(names) == 0 && !force {
+		return e.usage("missing operand")
+	}
+	for _, name := range names {
+		if base := filepath.Base(filepath.Clean(name)); base == "." || base == ".." || filepath.Clean(name) == "/" {
+			e.printf("refusing to remove '.' or '..' or '/' directory: skipping '%s'", name)
+			continue
+		}
+		path, err := r.trashPath(hc, name)
+		if err != nil {
+			e.printf("cannot remove '%s': %v", name, err)
+			continue
+		}
+		info, err := os.Lstat(path)
+		if err != nil {
+			if !force || !errors.Is(err, fs.ErrNotExist) {
+				e.printf("cannot remove '%s': %v", name, describeError(err))
+			}
+			continue
+		}
+		if info.IsDir() && !recursive {
+			if !dirs {
+				e.printf("cannot remove '%s': %v", name, errIsDirectory)
+				continue
+			}
+			if entries, err := os.ReadDir(path); err != nil || len(entries) > 0 {
+				e.printf("cannot remove '%s': %v", name, errDirectoryNotEmpty)
+				continue
+			}
+		}
+		if err := r.moveToTrash(path); err != nil {
+			e.printf("cannot remove '%s': %v", name, describeError(err))
+			continue
+		}
+		if verbose {
+			fmt.Fprintf(hc.Stdout, "removed '%s'\n", name)
+		}
+	}
+	return e.status()
+}
+
+// rmLongOptions replaces the long options of rm with their short forms.
+func rmLongOptions(args []string) []string {
+	short := map[string]string{"--recursive": "-r", "--force": "-f", "--dir": "-d", "--verbose": "-v"}
+	out := make([]string, 0, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			return append(out, args[i:]...)
+		}
+		if s, ok := short[arg]; ok {
+			arg = s
+		}
+		out = append(out, arg)
+	}
+	return out
+}
+
+// unlinkCommand implements unlink file by moving the file into the trash.
+func (r *SafeRunner) unlinkCommand(hc interp.HandlerContext, args []string) error {
+	e := &commandError{hc: hc, name: "unlink"}
+	names := args[1:]
+	if len(names) > 0 && names[0] == "--" {
+		names = names[1:]
+	}
+	if len(names) != 1 {
+		return e.usage("expected one operand")
+	}
+	name := names[0]
+	path, err := r.trashPath(hc, name)
+	if err != nil {
+		e.printf("cannot unlink '%s': %v", name, err)
+		return e.status()
+	}
+	info, err := os.Lstat(path)
+	switch {
+	case err != nil:
+		e.printf("cannot unlink '%s': %v", name, describeError(err))
+	case info.IsDir():
+		e.printf("cannot unlink '%s': %v", name, errIsDirectory)
+	default:
+		if err := r.moveToTrash(path); err != nil {
+			e.printf("cannot unlink '%s': %v", name, describeError(err))
+		}
+	}
+	return e.status()
+}
@@ -0,0 +1,89 @@
+package runner
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/trash"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+func TestSafeRunner_Trash(t *testing.T) {
+	tmpDir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("a"), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "b.txt"), []byte("b"), 0o600))
+	assert.NoError(t, os.MkdirAll(filepath.Join(tmpDir, "dir", "sub"), 0o700))
+	assert.NoError(t, os.Mkdir(filepath.Join(tmpDir, "empty"), 0o700))
+	assert.NoError(t, os.Symlink(filepath.Join(tmpDir, "b.txt"), filepath.Join(tmpDir, "link")))
+
+	cfg := config.NewDefaultConfig()
+	cfg.AllowedDirectories = []string{tmpDir}
+	cfg.AllowCommands = []config.AllowCommand{{Command: "rm"}, {Command: "unlink"}}
+	cfg.DenyCommands = nil
+	cfg.Trash = config.TrashConfig{Enabled: true, Dir: t.TempDir()}
+	log := logger.New()
+	r := New(cfg, validator.New(cfg, log), log)
+	r.SetIdentity(identity.Identity{Session: "s1"})
+
+	run := func(command string) (string, string, error) {
+		t.Helper()
+		var stdout, stderr strings.Builder
+		r.SetOutputs(&stdout, &stderr)
+		result := r.RunCommand(t.Context(), command, tmpDir)
+		return stdout.String(), stderr.String(), result.Err
+	}
+	exists := func(name string) bool {
+		_, err := os.Lstat(filepath.Join(tmpDir, name))
+		return !errors.Is(err, os.ErrNotExist)
+	}
+
+	stdout, _, err := run("rm -v a.txt link && unlink b.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "removed 'a.txt'\nremoved 'link'\n", stdout)
+	assert.False(t, exists("a.txt") || exists("link") || exists("b.txt"))
+
+	// Directories need -r, or -d when they are empty
+	_, stderr, err := run("rm dir")
+	assert.Equal(t, 1, ExitCode(err))
+	assert.Contains(t, stderr, "rm: cannot remove 'dir': Is a directory")
+	_, stderr, err = run("rm -d dir")
+	assert.Equal(t, 1, ExitCode(err))
+	assert.Contains(t, stderr, "Directory not empty")
+	_, _, err = run("rm -d empty && rm --recursive dir && rm -f missing")
+	assert.NoError(t, err)
+	assert.False(t, exists("dir") || exists("empty"))
+
+	_, stderr, err = run("rm missing")
+	assert.Equal(t, 1, ExitCode(err))
+	assert.Contains(t, stderr, "rm: cannot remove 'missing': No such file or directory")
+	_, stderr, err = run("rm -rf .")
+	assert.Equal(t, 1, ExitCode(err))
+	assert.Contains(t, stderr, "refusing to remove")
+
+	// Everything deleted is in the session's trash and can be restored
+	store := trash.New(cfg.Trash)
+	items, err := store.List("s1")
+	assert.NoError(t, err)
+	var paths []string
+	for _, item := range items {
+		paths = append(paths, filepath.Base(item.Path))
+	}
+	assert.Equal(t, []string{"a.txt", "b.txt", "dir", "empty", "link"}, slices.Sorted(slices.Values(paths)))
+	for _, item := range items {
+		_, err := store.Restore("s1", item.ID)
+		assert.NoError(t, err)
+	}
+	target, err := os.Readlink(filepath.Join(tmpDir, "link"))
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(tmpDir, "b.txt"), target)
+	assert.True(t, exists("a.txt") && exists("dir/sub") && exists("empty"))
+}
@@ -0,0 +1,309 @@
+// Package sanitize makes command output safe to show in terminals and to pass to language
+// models. It strips terminal escape sequences and other control characters, which can rewrite
+// what a terminal displays or hide instructions from the reader, and replaces or encodes binary
+// output, which is useless to both and can be large.
+package sanitize
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+// maxStringBytes bounds how much of an unterminated OSC, DCS, or similar control string is
+// discarded before the rest of the output is treated as text again.
+const maxStringBytes = 4096
+
+// base64LineLength is the length of the lines base64-encoded output is broken into.
+const base64LineLength = 76
+
+// escState is the position of a Writer within an escape sequence.
+type escState int
+
+const (
+	stateText escState = iota
+	// stateEsc follows an ESC
+	stateEsc
+	// stateEscIntermediate is within a two-character sequence with intermediate bytes, e.g. ESC ( B
+	stateEscIntermediate
+	// stateCSI is within a control sequence, e.g. ESC [ 3 1 m
+	stateCSI
+	// stateString is within a control string (OSC, DCS, SOS, PM, APC) ended by BEL or ST
+	stateString
+	// stateStringEsc follows an ESC within a control string, which may start ST (ESC \)
+	stateStringEsc
+)
+
+// Writer sanitizes everything written to it before passing it to an underlying writer. Output
+// is text until a write contains a NUL byte or invalid UTF-8; from then on until Flush, it is
+// binary and handled as configured.
+type Writer struct {
+	w   io.Writer
+	cfg config.OutputSafetyConfig
+
+	// pending holds an incomplete UTF-8 sequence at the end of the last write
+	pending []byte
+	state   escState
+	// stringBytes counts the bytes of the current control string
+	stringBytes int
+	// lastByte is the last byte written, to start notes on a line of their own
+	lastByte byte
+
+	binary bool
+	// omitted counts the bytes of binary output replaced by a placeholder
+	omitted int64
+	// encoder base64-encodes binary output
+	encoder io.WriteCloser
+}
+
+// NewWriter wraps w so that everything written is sanitized as cfg says. cfg.Enabled is not
+// consulted; callers only create a Writer when sanitizing is enabled.
+func NewWriter(w io.Writer, cfg config.OutputSafetyConfig) *Writer {
+	if cfg.Binary == "" {
+		cfg.Binary = config.BinaryPlaceholder
+	}
+	return &Writer{w: w, cfg: cfg}
+}
+
+// Write implements the io.Writer interface.
+func (sw *Writer) Write(p []byte) (int, error) {
+	if sw.binary {
+		return len(p), sw.writeBinary(p)
+	}
+
+	data := p
+	if len(sw.pending) > 0 {
+		data = append(sw.pending, p...)
+		sw.pending = nil
+	}
+	// Hold back a character whose bytes are split across writes
+	if cut := incompleteSuffix(data); cut > 0 {
+		sw.pending = bytes.Clone(data[len(data)-cut:])
+		data = data[:len(data)-cut]
+	}
+
+	if IsBinary(data) {
+		sw.binary = true
+		pending := sw.pending
+		sw.pending = nil
+		if err := sw.writeBinary(data); err != nil {
+			return 0, err
+		}
+		return len(p), sw.writeBinary(pending)
+	}
+	if err := sw.writeText(data); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush writes any output still held back, ends binary output, and resets the Writer for the
+// next execution.
+func (sw *Writer) Flush() error {
+	pending := sw.pending
+	sw.pending = nil
+	var err error
+	if len(pending) > 0 {
+		// The output ended within a character, e.g. because it was cut by head -c
+		err = sw.write([]byte(string(utf8.RuneError)))
+	}
+	if sw.binary {
+		if endErr := sw.endBinary(); err == nil {
+			err = endErr
+		}
+	}
+	sw.binary = false
+	sw.state = stateText
+	sw.stringBytes = 0
+	sw.lastByte = 0
+	return err
+}
+
+// writeText writes valid UTF-8 text, without escape sequences and control characters unless
+// they are kept.
+func (sw *Writer) writeText(data []byte) error {
+	if sw.cfg.KeepEscapes {
+		return sw.write(data)
+	}
+	out := make([]byte, 0, len(data))
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if sw.keep(r) {
+			out = append(out, data[:size]...)
+		}
+		data = data[size:]
+	}
+	return sw.write(out)
+}
+
+// keep advances the escape sequence state by r and reports whether r is text to keep.
+func (sw *Writer) keep(r rune) bool {
+	switch sw.state {
+	case stateEsc:
+		switch {
+		case r == '[':
+			sw.state = stateCSI
+		case r == ']' || r == 'P' || r == 'X' || r == '^' || r == '_':
+			sw.state, sw.stringBytes = stateString, 0
+		case r >= 0x20 && r <= 0x2f:
+			sw.state = stateEscIntermediate
+		default:
+			// A two-character sequence such as ESC 7 or ESC c ends here
+			sw.state = stateText
+		}
+		return false
+	case stateEscIntermediate:
+		if r < 0x20 || r > 0x2f {
+			sw.state = stateText
+		}
+		return false
+	case stateCSI:
+		if r >= 0x20 && r <= 0x3f {
+			return false
+		}
+		sw.state = stateText
+		if r >= 0x40 && r <= 0x7e {
+			return false
+		}
+		// A malformed sequence ends at the first character that cannot be part of it
+		return sw.keep(r)
+	case stateString, stateStringEsc:
+		sw.stringBytes += utf8.RuneLen(r)
+		switch {
+		case r == 0x07 || r == 0x9c || (sw.state == stateStringEsc && r == '\\'):
+			sw.state = stateText
+		case r == 0x1b:
+			sw.state = stateStringEsc
+		case sw.stringBytes > maxStringBytes:
+			sw.state = stateText
+		default:
+			sw.state = stateString
+		}
+		return false
+	}
+
+	switch {
+	case r == 0x1b:
+		sw.state = stateEsc
+		return false
+	case r == 0x9b:
+		// C1 control sequence introducer
+		sw.state = stateCSI
+		return false
+	case r == 0x9d || r == 0x90 || r == 0x98 || r == 0x9e || r == 0x9f:
+		// C1 OSC, DCS, SOS, PM, and APC
+		sw.state, sw.stringBytes = stateString, 0
+		return false
+	case r == '\n' || r == '\t' || r == '\r':
+		return true
+	case r < 0x20 || (r >= 0x7f && r <= 0x9f):
+		return false
+	}
+	return true
+}
+
+// writeBinary handles binary output as configured.
+func (sw *Writer) writeBinary(data []byte) error {
+	switch sw.cfg.Binary {
+	case config.BinaryRaw:
+		return sw.write(data)
+	case config.BinaryBase64:
+		if sw.encoder == nil {
+			if err := sw.note("[binary output, base64-encoded]\n"); err != nil {
+				return err
+			}
+			sw.encoder = base64.NewEncoder(base64.StdEncoding, &lineBreaker{w: sw})
+		}
+		_, err := sw.encoder.Write(data)
+		return err
+	default:
+		sw.omitted += int64(len(data))
+		return nil
+	}
+}
+
+// endBinary completes binary output at the end of an execution.
+func (sw *Writer) endBinary() error {
+	switch {
+	case sw.encoder != nil:
+		err := sw.encoder.Close()
+		sw.encoder = nil
+		if err == nil && sw.lastByte != '\n' {
+			err = sw.write([]byte{'\n'})
+		}
+		return err
+	case sw.omitted > 0:
+		omitted := sw.omitted
+		sw.omitted = 0
+		return sw.note(fmt.Sprintf("[binary output omitted: %d bytes]\n", omitted))
+	}
+	return nil
+}
+
+// note writes a note about the output on a line of its own.
+func (sw *Writer) note(text string) error {
+	if sw.lastByte != 0 && sw.lastByte != '\n' {
+		text = "\n" + text
+	}
+	return sw.write([]byte(text))
+}
+
+// write passes data to the underlying writer.
+func (sw *Writer) write(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	sw.lastByte = data[len(data)-1]
+	_, err := sw.w.Write(data)
+	return err
+}
+
+// lineBreaker writes base64 output to a Writer in lines of base64LineLength characters.
+type lineBreaker struct {
+	w      *Writer
+	column int
+}
+
+// Write implements the io.Writer interface.
+func (lb *lineBreaker) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		chunk := min(len(p), base64LineLength-lb.column)
+		line := p[:chunk]
+		lb.column += chunk
+		if lb.column == base64LineLength {
+			line = append(bytes.Clone(line), '\n')
+			lb.column = 0
+		}
+		if err := lb.w.write(line); err != nil {
+			return 0, err
+		}
+		p = p[chunk:]
+	}
+	return n, nil
+}
+
+// IsBinary reports whether data contains a NUL byte or is not valid UTF-8.
+func IsBinary(data []byte) bool {
+	return bytes.IndexByte(data, 0) >= 0 || !utf8.Valid(data)
+}
+
+// incompleteSuffix returns the length of an incomplete UTF-8 sequence at the end of data, which
+// may be completed by the next write.
+func incompleteSuffix(data []byte) int {
+	for i := 1; i < utf8.UTFMax && i <= len(data); i++ {
+		start := len(data) - i
+		if !utf8.RuneStart(data[start]) {
+			continue
+		}
+		if utf8.FullRune(data[start:]) {
+			return 0
+		}
+		return i
+	}
+	return 0
+}
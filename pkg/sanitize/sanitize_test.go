@@ -0,0 +1,113 @@
+package sanitize
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+// sanitize writes each chunk to a Writer configured by cfg and returns the flushed output.
+func sanitize(t *testing.T, cfg config.OutputSafetyConfig, chunks ...string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w := NewWriter(&buf, cfg)
+	for _, chunk := range chunks {
+		if n, err := w.Write([]byte(chunk)); err != nil || n != len(chunk) {
+			t.Fatalf("Write(%q) = %d, %v", chunk, n, err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	return buf.String()
+}
+
+func TestWriterEscapes(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   string
+	}{
+		{name: "PlainText", chunks: []string{"hello\tworld\r\n"}, want: "hello\tworld\r\n"},
+		{name: "Unicode", chunks: []string{"こんにちは ✓\n"}, want: "こんにちは ✓\n"},
+		{name: "Colors", chunks: []string{"\x1b[1;31merror\x1b[0m: failed\n"}, want: "error: failed\n"},
+		{name: "ClearScreen", chunks: []string{"\x1b[2J\x1b[Hsafe"}, want: "safe"},
+		{name: "Title", chunks: []string{"\x1b]0;pwned\x07ok"}, want: "ok"},
+		{name: "Hyperlink", chunks: []string{"\x1b]8;;https://evil.example\x1b\\click\x1b]8;;\x1b\\"}, want: "click"},
+		{name: "DCS", chunks: []string{"a\x1bPq#0;2;0;0;0\x1b\\b"}, want: "ab"},
+		{name: "Charset", chunks: []string{"\x1b(Btext\x1b7"}, want: "text"},
+		{name: "C1CSI", chunks: []string{"x\u009b31my"}, want: "xy"},
+		{name: "ControlCharacters", chunks: []string{"be\x07ll\x08\x7f\n"}, want: "bell\n"},
+		{name: "SplitSequence", chunks: []string{"a\x1b", "[3", "1mb\x1b]0;ti", "tle\x07c"}, want: "abc"},
+		{name: "SplitCharacter", chunks: []string{"\xe3\x81", "\x93\n"}, want: "こ\n"},
+		{name: "TruncatedCharacter", chunks: []string{"ok\xe3\x81"}, want: "ok�"},
+		{name: "UnterminatedString", chunks: []string{"\x1b]" + strings.Repeat("x", maxStringBytes+1) + "after"}, want: "after"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := sanitize(t, config.OutputSafetyConfig{}, tc.chunks...); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWriterKeepEscapes(t *testing.T) {
+	in := "\x1b[31mred\x1b[0m\n"
+	if got := sanitize(t, config.OutputSafetyConfig{KeepEscapes: true}, in); got != in {
+		t.Errorf("got %q, want %q", got, in)
+	}
+}
+
+func TestWriterBinary(t *testing.T) {
+	binary := "\x7fELF\x02\x01\x00\x00\xff\xfe"
+
+	t.Run("Placeholder", func(t *testing.T) {
+		got := sanitize(t, config.OutputSafetyConfig{}, "header", binary, binary)
+		want := "header\n[binary output omitted: 20 bytes]\n"
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("Base64", func(t *testing.T) {
+		data := strings.Repeat(binary, 10)
+		got := sanitize(t, config.OutputSafetyConfig{Binary: config.BinaryBase64}, data)
+		header, encoded, ok := strings.Cut(got, "\n")
+		if !ok || header != "[binary output, base64-encoded]" {
+			t.Fatalf("unexpected output %q", got)
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(encoded, "\n"), "\n") {
+			if len(line) > base64LineLength {
+				t.Errorf("line longer than %d characters: %q", base64LineLength, line)
+			}
+		}
+		decoded, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(encoded, "\n", ""))
+		if err != nil || string(decoded) != data {
+			t.Errorf("decoded %q, %v; want %q", decoded, err, data)
+		}
+	})
+
+	t.Run("Raw", func(t *testing.T) {
+		if got := sanitize(t, config.OutputSafetyConfig{Binary: config.BinaryRaw}, binary); got != binary {
+			t.Errorf("got %q, want %q", got, binary)
+		}
+	})
+
+	t.Run("ResetByFlush", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := NewWriter(&buf, config.OutputSafetyConfig{})
+		w.Write([]byte(binary))
+		w.Flush()
+		w.Write([]byte("text\n"))
+		w.Flush()
+		want := "[binary output omitted: 10 bytes]\ntext\n"
+		if got := buf.String(); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
@@ -0,0 +1,70 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignV4(t *testing.T) {
+	body := []byte(`{"SecretId":"prod/api"}`)
+	req, err := http.NewRequest(http.MethodPost, "https://secretsmanager.us-east-1.amazonaws.com", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	req.Header.Set("X-Amz-Security-Token", "tok")
+
+	signV4(req, body, awsCredentials{accessKeyID: "AKID", secretAccessKey: "SECRET", sessionToken: "tok"},
+		"us-east-1", "secretsmanager", time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+
+	// Signed the same way by the AWS SDK for Go
+	want := "AWS4-HMAC-SHA256 Credential=AKID/20240501/us-east-1/secretsmanager/aws4_request, " +
+		"SignedHeaders=content-type;host;x-amz-date;x-amz-security-token;x-amz-target, " +
+		"Signature=b31a4b2764f57647debc847678da4deee8267e96b56ac3bfdde81b31b8d49f3f"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization = %q, want %q", got, want)
+	}
+	if got := req.Header.Get("X-Amz-Date"); got != "20240501T120000Z" {
+		t.Errorf("X-Amz-Date = %q", got)
+	}
+}
+
+func TestAWS_Lookup(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var input struct {
+			SecretID string `json:"SecretId"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&input)
+		if input.SecretID != "prod/api" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"__type": "ResourceNotFoundException", "message": "not found"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"Name": "prod/api", "SecretString": "{\"token\": \"s3cr3t\"}"}`))
+	}))
+	defer srv.Close()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "SECRET")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	a := &AWS{Region: "eu-west-1", Endpoint: srv.URL}
+
+	got, err := a.Lookup(t.Context(), "prod/api")
+	if err != nil || got != `{"token": "s3cr3t"}` {
+		t.Errorf("Lookup() = %q, %v", got, err)
+	}
+	if _, err := a.Lookup(t.Context(), "prod/missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Lookup() of a missing secret error = %v, want ErrNotFound", err)
+	}
+}
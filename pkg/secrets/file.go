@@ -0,0 +1,31 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// File reads secrets from files, such as those mounted by Docker or Kubernetes under
+// /run/secrets: "file:/run/secrets/api_token". A trailing line break is removed.
+type File struct{}
+
+// Lookup reads the file at path, which must be absolute.
+func (File) Lookup(_ context.Context, path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("secret file path must be absolute: %q", path)
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("%w: %s does not exist", ErrNotFound, path)
+	}
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimSuffix(string(data), "\n")
+	return strings.TrimSuffix(value, "\r"), nil
+}
@@ -0,0 +1,147 @@
+// Package secrets resolves references such as "vault:kv/ci#token" to secrets held by external
+// providers, so that a configuration names the secrets commands need instead of containing
+// them. The runner resolves the references of env when a command starts and passes the values
+// to the command's environment only.
+//
+// A reference is "scheme:path" or "scheme:path#field". The scheme selects the Provider, which
+// looks up the path; a field selects a member of a secret that is a JSON object.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+// ErrNotFound is returned when a provider has no secret at the path, or the secret has no such field.
+var ErrNotFound = errors.New("secret not found")
+
+// Provider looks up secrets of one scheme.
+type Provider interface {
+	// Lookup returns the secret at path, the part of a reference between the scheme and the field.
+	Lookup(ctx context.Context, path string) (string, error)
+}
+
+// ProviderFunc adapts a function to a Provider.
+type ProviderFunc func(ctx context.Context, path string) (string, error)
+
+// Lookup calls f.
+func (f ProviderFunc) Lookup(ctx context.Context, path string) (string, error) {
+	return f(ctx, path)
+}
+
+// cached is a secret looked up at a point in time.
+type cached struct {
+	value   string
+	fetched time.Time
+}
+
+// Resolver resolves references with the provider registered for their scheme, reusing each
+// secret for a time so that a script of many commands looks it up once.
+// It is safe for concurrent use.
+type Resolver struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	providers map[string]Provider
+	cache     map[string]cached
+}
+
+// New returns a Resolver with the "file", "vault", and "aws" providers configured by cfg.
+func New(cfg config.SecretsConfig) *Resolver {
+	ttl := cfg.CacheTTL
+	if ttl == 0 {
+		ttl = config.DefaultSecretCacheTTL
+	}
+	r := &Resolver{ttl: time.Duration(ttl) * time.Second}
+	r.Register("file", File{})
+	r.Register("vault", &Vault{Address: cfg.Vault.Address, TokenFile: cfg.Vault.TokenFile, Namespace: cfg.Vault.Namespace})
+	r.Register("aws", &AWS{Region: cfg.AWS.Region, Endpoint: cfg.AWS.Endpoint})
+	return r
+}
+
+// Register makes p resolve the references of scheme, replacing any provider registered before.
+func (r *Resolver) Register(scheme string, p Provider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.providers == nil {
+		r.providers = make(map[string]Provider)
+	}
+	r.providers[scheme] = p
+	// Secrets of the previous provider must not outlive it
+	for key := range r.cache {
+		if ref, err := config.ParseSecretRef(key); err == nil && ref.Scheme == scheme {
+			delete(r.cache, key)
+		}
+	}
+}
+
+// Resolve returns the secret ref refers to. Errors name the reference but never a value.
+func (r *Resolver) Resolve(ctx context.Context, ref string) (string, error) {
+	parsed, err := config.ParseSecretRef(ref)
+	if err != nil {
+		return "", err
+	}
+	value, err := r.lookup(ctx, parsed)
+	if err != nil {
+		return "", fmt.Errorf("secret %s: %w", parsed, err)
+	}
+	if parsed.Field == "" {
+		return value, nil
+	}
+	field, err := selectField(value, parsed.Field)
+	if err != nil {
+		return "", fmt.Errorf("secret %s: %w", parsed, err)
+	}
+	return field, nil
+}
+
+// lookup returns the secret at ref's path from the cache or its provider.
+func (r *Resolver) lookup(ctx context.Context, ref config.SecretRef) (string, error) {
+	key := ref.Scheme + ":" + ref.Path
+	r.mu.Lock()
+	p, ok := r.providers[ref.Scheme]
+	c, hit := r.cache[key]
+	r.mu.Unlock()
+	if !ok {
+		return "", fmt.Errorf("no secret provider for scheme %q", ref.Scheme)
+	}
+	if hit && time.Since(c.fetched) < r.ttl {
+		return c.value, nil
+	}
+
+	value, err := p.Lookup(ctx, ref.Path)
+	if err != nil {
+		return "", err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cache == nil {
+		r.cache = make(map[string]cached)
+	}
+	r.cache[key] = cached{value: value, fetched: time.Now()}
+	return value, nil
+}
+
+// selectField returns the named member of secret, a JSON object. Members that are not
+// strings are returned as JSON.
+func selectField(secret, field string) (string, error) {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(secret), &object); err != nil {
+		return "", fmt.Errorf("field %q selected from a secret that is not a JSON object", field)
+	}
+	raw, ok := object[field]
+	if !ok {
+		return "", fmt.Errorf("%w: no field %q", ErrNotFound, field)
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err == nil {
+		return value, nil
+	}
+	return string(raw), nil
+}
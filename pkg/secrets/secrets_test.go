@@ -0,0 +1,105 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+func TestResolver_Resolve(t *testing.T) {
+	lookups := 0
+	r := New(config.SecretsConfig{})
+	r.Register("test", ProviderFunc(func(_ context.Context, path string) (string, error) {
+		lookups++
+		if path != "ci" {
+			return "", ErrNotFound
+		}
+		return `{"token": "s3cr3t", "port": 5432}`, nil
+	}))
+
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr error
+	}{
+		{ref: "test:ci#token", want: "s3cr3t"},
+		{ref: "test:ci#port", want: "5432"},
+		{ref: "test:ci", want: `{"token": "s3cr3t", "port": 5432}`},
+		{ref: "test:ci#missing", wantErr: ErrNotFound},
+		{ref: "test:other#token", wantErr: ErrNotFound},
+	}
+	for _, tt := range tests {
+		got, err := r.Resolve(t.Context(), tt.ref)
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Resolve(%q) error = %v, want %v", tt.ref, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Resolve(%q) = %q, %v, want %q", tt.ref, got, err, tt.want)
+		}
+	}
+	// Every field of ci came from one lookup
+	if lookups != 2 {
+		t.Errorf("provider was called %d times, want 2", lookups)
+	}
+
+	if _, err := r.Resolve(t.Context(), "unknown:x"); err == nil || !strings.Contains(err.Error(), `no secret provider for scheme "unknown"`) {
+		t.Errorf("Resolve() with an unknown scheme error = %v", err)
+	}
+}
+
+func TestFile_Lookup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("s3cr3t\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := New(config.SecretsConfig{}).Resolve(t.Context(), "file:"+path)
+	if err != nil || got != "s3cr3t" {
+		t.Errorf("Resolve() = %q, %v, want s3cr3t", got, err)
+	}
+	if _, err := (File{}).Lookup(t.Context(), path+".missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Lookup() of a missing file error = %v, want ErrNotFound", err)
+	}
+	if _, err := (File{}).Lookup(t.Context(), "token"); err == nil {
+		t.Error("Lookup() of a relative path should fail")
+	}
+}
+
+func TestVault_Lookup(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root-token" || r.Header.Get("X-Vault-Namespace") != "team" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.URL.Path != "/v1/kv/data/ci/deploy" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data": {"data": {"token": "s3cr3t"}, "metadata": {"version": 3}}}`))
+	}))
+	defer srv.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("root-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	r := New(config.SecretsConfig{Vault: config.VaultConfig{Address: srv.URL, TokenFile: tokenFile, Namespace: "team"}})
+
+	got, err := r.Resolve(t.Context(), "vault:kv/ci/deploy#token")
+	if err != nil || got != "s3cr3t" {
+		t.Errorf("Resolve() = %q, %v, want s3cr3t", got, err)
+	}
+	if _, err := r.Resolve(t.Context(), "vault:kv/ci/missing#token"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Resolve() of a missing secret error = %v, want ErrNotFound", err)
+	}
+}
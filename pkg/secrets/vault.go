@@ -0,0 +1,111 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout bounds each request of the Vault and AWS providers.
+const DefaultTimeout = 5 * time.Second
+
+// Vault reads secrets from the KV version 2 engine of a HashiCorp Vault server. The path is
+// the engine's mount followed by the secret's path, so "vault:kv/ci#token" is the token field of
+// the secret ci in the engine mounted at kv. The secret is its data as a JSON object.
+type Vault struct {
+	// Address is the server's URL; when empty, $VAULT_ADDR is used.
+	Address string
+	// TokenFile holds the token; when empty, $VAULT_TOKEN is used.
+	TokenFile string
+	// Namespace is sent as X-Vault-Namespace when set.
+	Namespace  string
+	HTTPClient *http.Client
+}
+
+// Lookup reads the latest version of the secret at path.
+func (v *Vault) Lookup(ctx context.Context, path string) (string, error) {
+	address := v.Address
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+	if address == "" {
+		return "", errors.New("vault address is not configured and VAULT_ADDR is not set")
+	}
+	token, err := v.token()
+	if err != nil {
+		return "", err
+	}
+	mount, secret, ok := strings.Cut(strings.Trim(path, "/"), "/")
+	if !ok || secret == "" {
+		return "", fmt.Errorf("vault path must be mount/secret: %q", path)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, DefaultTimeout)
+	defer cancel()
+	endpoint, err := url.JoinPath(address, "v1", mount, "data", secret)
+	if err != nil {
+		return "", fmt.Errorf("invalid vault address: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+
+	client := v.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to query vault: %w", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return "", ErrNotFound
+	default:
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return "", fmt.Errorf("failed to query vault: server responded %s", resp.Status)
+	}
+
+	var result struct {
+		Data struct {
+			Data json.RawMessage `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode vault response: %w", err)
+	}
+	if len(result.Data.Data) == 0 || string(result.Data.Data) == "null" {
+		return "", ErrNotFound
+	}
+	return string(result.Data.Data), nil
+}
+
+// token returns the Vault token from TokenFile or $VAULT_TOKEN.
+func (v *Vault) token() (string, error) {
+	if v.TokenFile != "" {
+		data, err := os.ReadFile(v.TokenFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read vault token: %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		return token, nil
+	}
+	return "", errors.New("vault token file is not configured and VAULT_TOKEN is not set")
+}
@@ -0,0 +1,176 @@
+// Package session limits the sessions of each caller identity: how many it may have open at
+// once, how many commands each may run, and how long each may last, so that one runaway agent
+// cannot monopolize the executor.
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+var (
+	// ErrTooManySessions is returned when a caller opens more sessions than sessions.maxConcurrent.
+	ErrTooManySessions = errors.New("too many concurrent sessions")
+	// ErrCommandLimit is returned when a session starts more commands than sessions.maxCommands.
+	ErrCommandLimit = errors.New("session command limit reached")
+	// ErrExpired is returned, and is the cause of the cancellation of running commands, once a
+	// session has lasted longer than sessions.maxLifetime.
+	ErrExpired = errors.New("session lifetime exceeded")
+)
+
+// Manager tracks the open sessions of every caller. It is safe for concurrent use. A nil
+// Manager allows everything.
+type Manager struct {
+	maxConcurrent int
+	maxCommands   int
+	maxLifetime   time.Duration
+	now           func() time.Time
+
+	mu sync.Mutex
+	// open counts the open sessions of each caller
+	open map[string]int
+	// byID holds the sessions opened by Lookup
+	byID map[string]*Session
+}
+
+// New creates a Manager from the configuration. It returns nil when no limits are configured.
+func New(cfg config.SessionLimitsConfig) *Manager {
+	if cfg.MaxConcurrent <= 0 && cfg.MaxCommands <= 0 && cfg.MaxLifetime <= 0 {
+		return nil
+	}
+	return &Manager{
+		maxConcurrent: cfg.MaxConcurrent,
+		maxCommands:   cfg.MaxCommands,
+		maxLifetime:   time.Duration(cfg.MaxLifetime) * time.Second,
+		now:           time.Now,
+		open:          make(map[string]int),
+		byID:          make(map[string]*Session),
+	}
+}
+
+// Session is a single session of a caller, such as an SSH channel or a JSON-RPC connection.
+// A nil Session allows everything.
+type Session struct {
+	manager *Manager
+	caller  string
+	id      string
+	started time.Time
+
+	mu       sync.Mutex
+	commands int
+	closed   bool
+}
+
+// Open starts a session of caller, which must be closed once it ends.
+func (m *Manager) Open(caller string) (*Session, error) {
+	if m == nil {
+		return nil, nil //nolint:nilnil // a nil Session is unlimited
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.openLocked(caller, "")
+}
+
+// Lookup returns the session with the given ID, opening it on first use. It is meant for
+// protocols whose sessions have no end the server is told of, such as MCP: the session is
+// closed once its lifetime has passed, and never otherwise.
+func (m *Manager) Lookup(caller, id string) (*Session, error) {
+	if m == nil {
+		return nil, nil //nolint:nilnil // a nil Session is unlimited
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.byID[id]; ok {
+		return s, nil
+	}
+	s, err := m.openLocked(caller, id)
+	if err != nil {
+		return nil, err
+	}
+	m.byID[id] = s
+	return s, nil
+}
+
+// openLocked opens a session of caller with m.mu held.
+func (m *Manager) openLocked(caller, id string) (*Session, error) {
+	m.expireLocked()
+	if m.maxConcurrent > 0 && m.open[caller] >= m.maxConcurrent {
+		return nil, fmt.Errorf("%w: caller %q already has %d sessions open", ErrTooManySessions, caller, m.open[caller])
+	}
+	m.open[caller]++
+	return &Session{manager: m, caller: caller, id: id, started: m.now()}, nil
+}
+
+// expireLocked closes the sessions opened by Lookup whose lifetime has passed.
+func (m *Manager) expireLocked() {
+	if m.maxLifetime <= 0 {
+		return
+	}
+	for id, s := range m.byID {
+		if m.now().Sub(s.started) >= m.maxLifetime {
+			delete(m.byID, id)
+			s.closeLocked()
+		}
+	}
+}
+
+// Begin admits one more command to the session, or returns why the session may not run it.
+func (s *Session) Begin() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.manager
+	if m.maxLifetime > 0 && m.now().Sub(s.started) >= m.maxLifetime {
+		return fmt.Errorf("%w: session of caller %q started %s ago", ErrExpired, s.caller, m.maxLifetime)
+	}
+	if m.maxCommands > 0 && s.commands >= m.maxCommands {
+		return fmt.Errorf("%w: session of caller %q has run %d commands", ErrCommandLimit, s.caller, s.commands)
+	}
+	s.commands++
+	return nil
+}
+
+// Context returns a context that is canceled with ErrExpired as its cause when the session's
+// lifetime ends, stopping the commands running under it.
+func (s *Session) Context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s == nil || s.manager.maxLifetime <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadlineCause(ctx, s.started.Add(s.manager.maxLifetime), ErrExpired)
+}
+
+// Close ends the session, making room for another of its caller. Closing a session twice
+// has no effect.
+func (s *Session) Close() {
+	if s == nil {
+		return
+	}
+	m := s.manager
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s.id != "" && m.byID[s.id] == s {
+		delete(m.byID, s.id)
+	}
+	s.closeLocked()
+}
+
+// closeLocked ends the session with the manager's mutex held.
+func (s *Session) closeLocked() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	m := s.manager
+	if m.open[s.caller]--; m.open[s.caller] <= 0 {
+		delete(m.open, s.caller)
+	}
+}
@@ -0,0 +1,119 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+// fakeClock returns a controllable time source.
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func newTestManager(cfg config.SessionLimitsConfig) (*Manager, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	m := New(cfg)
+	m.now = clock.now
+	return m, clock
+}
+
+func TestNew_Disabled(t *testing.T) {
+	m := New(config.SessionLimitsConfig{})
+	assert.Zero(t, m)
+
+	s, err := m.Open("agent")
+	assert.NoError(t, err)
+	for range 100 {
+		assert.NoError(t, s.Begin())
+	}
+	s.Close()
+
+	s, err = m.Lookup("agent", "id")
+	assert.NoError(t, err)
+	assert.NoError(t, s.Begin())
+}
+
+func TestManager_MaxConcurrent(t *testing.T) {
+	m, _ := newTestManager(config.SessionLimitsConfig{MaxConcurrent: 1})
+
+	s, err := m.Open("agent")
+	assert.NoError(t, err)
+
+	_, err = m.Open("agent")
+	assert.True(t, errors.Is(err, ErrTooManySessions))
+
+	// Other callers have their own limit
+	other, err := m.Open("other")
+	assert.NoError(t, err)
+	other.Close()
+
+	// Closing twice must not free an extra slot
+	s.Close()
+	s.Close()
+
+	second, err := m.Open("agent")
+	assert.NoError(t, err)
+	_, err = m.Open("agent")
+	assert.True(t, errors.Is(err, ErrTooManySessions))
+	second.Close()
+}
+
+func TestSession_MaxCommands(t *testing.T) {
+	m, _ := newTestManager(config.SessionLimitsConfig{MaxCommands: 2})
+
+	s, err := m.Open("agent")
+	assert.NoError(t, err)
+	assert.NoError(t, s.Begin())
+	assert.NoError(t, s.Begin())
+	assert.True(t, errors.Is(s.Begin(), ErrCommandLimit))
+
+	// A new session starts counting again
+	next, err := m.Open("agent")
+	assert.NoError(t, err)
+	assert.NoError(t, next.Begin())
+}
+
+func TestSession_MaxLifetime(t *testing.T) {
+	m, clock := newTestManager(config.SessionLimitsConfig{MaxLifetime: 60})
+
+	s, err := m.Open("agent")
+	assert.NoError(t, err)
+	assert.NoError(t, s.Begin())
+
+	clock.t = clock.t.Add(time.Minute)
+	assert.True(t, errors.Is(s.Begin(), ErrExpired))
+
+	// The context of a session ends with it
+	ctx, cancel := s.Context(context.Background())
+	defer cancel()
+	<-ctx.Done()
+	assert.True(t, errors.Is(context.Cause(ctx), ErrExpired))
+}
+
+func TestManager_Lookup(t *testing.T) {
+	m, clock := newTestManager(config.SessionLimitsConfig{MaxConcurrent: 1, MaxLifetime: 60})
+
+	s, err := m.Lookup("agent", "one")
+	assert.NoError(t, err)
+	again, err := m.Lookup("agent", "one")
+	assert.NoError(t, err)
+	assert.True(t, s == again)
+
+	_, err = m.Lookup("agent", "two")
+	assert.True(t, errors.Is(err, ErrTooManySessions))
+
+	// Expired sessions are closed, making room for new ones
+	clock.t = clock.t.Add(time.Minute)
+	fresh, err := m.Lookup("agent", "two")
+	assert.NoError(t, err)
+	assert.NoError(t, fresh.Begin())
+	assert.True(t, errors.Is(s.Begin(), ErrExpired))
+}
@@ -0,0 +1,336 @@
+package sftpserver
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"time"
+)
+
+// Packet types of SFTP version 3 (draft-ietf-secsh-filexfer-02).
+const (
+	fxpInit     = 1
+	fxpVersion  = 2
+	fxpOpen     = 3
+	fxpClose    = 4
+	fxpRead     = 5
+	fxpWrite    = 6
+	fxpLstat    = 7
+	fxpFstat    = 8
+	fxpSetstat  = 9
+	fxpFsetstat = 10
+	fxpOpendir  = 11
+	fxpReaddir  = 12
+	fxpRemove   = 13
+	fxpMkdir    = 14
+	fxpRmdir    = 15
+	fxpRealpath = 16
+	fxpStat     = 17
+	fxpRename   = 18
+	fxpReadlink = 19
+	fxpSymlink  = 20
+	fxpStatus   = 101
+	fxpHandle   = 102
+	fxpData     = 103
+	fxpName     = 104
+	fxpAttrs    = 105
+	fxpExtended = 200
+)
+
+// Status codes of SSH_FXP_STATUS.
+const (
+	fxOK               = 0
+	fxEOF              = 1
+	fxNoSuchFile       = 2
+	fxPermissionDenied = 3
+	fxFailure          = 4
+	fxBadMessage       = 5
+	fxOpUnsupported    = 8
+)
+
+// Flags of SSH_FXP_OPEN.
+const (
+	fxfRead   = 0x01
+	fxfWrite  = 0x02
+	fxfAppend = 0x04
+	fxfCreat  = 0x08
+	fxfTrunc  = 0x10
+	fxfExcl   = 0x20
+)
+
+// Flags of the fields present in file attributes.
+const (
+	attrSize        = 0x01
+	attrUIDGID      = 0x02
+	attrPermissions = 0x04
+	attrACModTime   = 0x08
+	attrExtended    = 0x80000000
+)
+
+// File type bits of the permissions attribute, as in st_mode.
+const (
+	modeFIFO    = 0o010000
+	modeChar    = 0o020000
+	modeDir     = 0o040000
+	modeBlock   = 0o060000
+	modeRegular = 0o100000
+	modeSymlink = 0o120000
+	modeSocket  = 0o140000
+)
+
+// maxPacketSize bounds the packets accepted from clients, which send at most 32 KB of data
+// in a write.
+const maxPacketSize = 256 * 1024
+
+// errBadMessage is the error of a packet too short for its fields.
+var errBadMessage = errors.New("malformed packet")
+
+// readPacket reads a packet from r and returns its type and payload.
+func readPacket(r io.Reader) (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	if length == 0 || length > maxPacketSize {
+		return 0, nil, errBadMessage
+	}
+	payload := make([]byte, length-1)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[4], payload, nil
+}
+
+// decoder reads the fields of a packet payload. The first short read sets err, after
+// which every field reads as zero.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) uint32() uint32 {
+	if len(d.buf) < 4 {
+		d.err = errBadMessage
+		return 0
+	}
+	v := binary.BigEndian.Uint32(d.buf)
+	d.buf = d.buf[4:]
+	return v
+}
+
+func (d *decoder) uint64() uint64 {
+	if len(d.buf) < 8 {
+		d.err = errBadMessage
+		return 0
+	}
+	v := binary.BigEndian.Uint64(d.buf)
+	d.buf = d.buf[8:]
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uint32()
+	if d.err != nil || uint64(len(d.buf)) < uint64(n) {
+		d.err = errBadMessage
+		return nil
+	}
+	v := d.buf[:n]
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+// attrs reads file attributes.
+func (d *decoder) attrs() fileAttrs {
+	a := fileAttrs{flags: d.uint32()}
+	if a.flags&attrSize != 0 {
+		a.size = d.uint64()
+	}
+	if a.flags&attrUIDGID != 0 {
+		a.uid, a.gid = d.uint32(), d.uint32()
+	}
+	if a.flags&attrPermissions != 0 {
+		a.permissions = d.uint32()
+	}
+	if a.flags&attrACModTime != 0 {
+		a.atime, a.mtime = d.uint32(), d.uint32()
+	}
+	if a.flags&attrExtended != 0 {
+		for n := d.uint32(); n > 0 && d.err == nil; n-- {
+			d.string()
+			d.string()
+		}
+	}
+	return a
+}
+
+// encoder builds a packet.
+type encoder struct {
+	buf []byte
+}
+
+// newPacket starts a packet of type t answering the request id.
+func newPacket(t byte, id uint32) *encoder {
+	e := &encoder{buf: make([]byte, 4, 64)}
+	e.buf = append(e.buf, t)
+	e.uint32(id)
+	return e
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.buf = binary.BigEndian.AppendUint32(e.buf, v)
+}
+
+func (e *encoder) uint64(v uint64) {
+	e.buf = binary.BigEndian.AppendUint64(e.buf, v)
+}
+
+func (e *encoder) bytes(v []byte) {
+	e.uint32(uint32(len(v))) //nolint:gosec // packets are far smaller than 4 GB
+	e.buf = append(e.buf, v...)
+}
+
+func (e *encoder) string(v string) {
+	e.bytes([]byte(v))
+}
+
+func (e *encoder) attrs(a fileAttrs) {
+	e.uint32(a.flags)
+	if a.flags&attrSize != 0 {
+		e.uint64(a.size)
+	}
+	if a.flags&attrUIDGID != 0 {
+		e.uint32(a.uid)
+		e.uint32(a.gid)
+	}
+	if a.flags&attrPermissions != 0 {
+		e.uint32(a.permissions)
+	}
+	if a.flags&attrACModTime != 0 {
+		e.uint32(a.atime)
+		e.uint32(a.mtime)
+	}
+}
+
+// packet returns the packet with its length filled in.
+func (e *encoder) packet() []byte {
+	binary.BigEndian.PutUint32(e.buf, uint32(len(e.buf)-4)) //nolint:gosec // packets are far smaller than 4 GB
+	return e.buf
+}
+
+// fileAttrs are the attributes of a file as sent over the wire.
+type fileAttrs struct {
+	flags       uint32
+	size        uint64
+	uid, gid    uint32
+	permissions uint32
+	atime       uint32
+	mtime       uint32
+}
+
+// attrsOf returns the attributes of the file described by info.
+func attrsOf(info fs.FileInfo) fileAttrs {
+	a := fileAttrs{
+		flags:       attrSize | attrPermissions | attrACModTime,
+		size:        uint64(max(info.Size(), 0)),
+		permissions: wireMode(info.Mode()),
+		atime:       unixTime(info.ModTime()),
+		mtime:       unixTime(info.ModTime()),
+	}
+	if uid, gid, ok := fileOwner(info); ok {
+		a.flags |= attrUIDGID
+		a.uid, a.gid = uid, gid
+	}
+	return a
+}
+
+// wireMode converts a file mode to the st_mode bits SFTP clients expect.
+func wireMode(mode fs.FileMode) uint32 {
+	bits := uint32(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		bits |= 0o4000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		bits |= 0o2000
+	}
+	if mode&fs.ModeSticky != 0 {
+		bits |= 0o1000
+	}
+	switch {
+	case mode.IsDir():
+		bits |= modeDir
+	case mode&fs.ModeSymlink != 0:
+		bits |= modeSymlink
+	case mode&fs.ModeNamedPipe != 0:
+		bits |= modeFIFO
+	case mode&fs.ModeSocket != 0:
+		bits |= modeSocket
+	case mode&fs.ModeCharDevice != 0:
+		bits |= modeChar
+	case mode&fs.ModeDevice != 0:
+		bits |= modeBlock
+	default:
+		bits |= modeRegular
+	}
+	return bits
+}
+
+// longName formats a directory entry like "ls -l", which clients show for long listings.
+func longName(name string, info fs.FileInfo, a fileAttrs) string {
+	kind := "-"
+	switch a.permissions &^ 0o7777 {
+	case modeDir:
+		kind = "d"
+	case modeSymlink:
+		kind = "l"
+	case modeFIFO:
+		kind = "p"
+	case modeSocket:
+		kind = "s"
+	case modeChar:
+		kind = "c"
+	case modeBlock:
+		kind = "b"
+	}
+	return fmt.Sprintf("%s%s %4d %-8d %-8d %8d %s %s",
+		kind, info.Mode().Perm().String()[1:], 1, a.uid, a.gid, info.Size(), info.ModTime().Format("Jan _2 15:04"), name)
+}
+
+// unixTime returns t in seconds since the epoch, clamped to the 32 bits of the protocol.
+func unixTime(t time.Time) uint32 {
+	return uint32(min(max(t.Unix(), 0), math.MaxUint32)) //nolint:gosec // clamped above
+}
+
+// osFlags converts the flags of SSH_FXP_OPEN to flags of os.OpenFile.
+func osFlags(pflags uint32) int {
+	var flags int
+	switch {
+	case pflags&fxfRead != 0 && pflags&fxfWrite != 0:
+		flags = os.O_RDWR
+	case pflags&(fxfWrite|fxfAppend) != 0:
+		flags = os.O_WRONLY
+	default:
+		flags = os.O_RDONLY
+	}
+	if pflags&fxfAppend != 0 {
+		flags |= os.O_APPEND
+	}
+	if pflags&fxfCreat != 0 {
+		flags |= os.O_CREATE
+	}
+	if pflags&fxfTrunc != 0 {
+		flags |= os.O_TRUNC
+	}
+	if pflags&fxfExcl != 0 {
+		flags |= os.O_EXCL
+	}
+	return flags
+}
@@ -0,0 +1,585 @@
+// Package sftpserver implements the server side of SFTP version 3, the file transfer protocol
+// of the "sftp" subsystem of SSH. Every operation is checked against the allowed directories
+// and read-only flags of the policy, like the paths of executed commands.
+package sftpserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+// protocolVersion is the version of SFTP served.
+const protocolVersion = 3
+
+const (
+	// maxHandles bounds the files and directories a client holds open at once.
+	maxHandles = 256
+	// maxReadSize bounds the data returned by a read, which clients request 32 KB at a time.
+	maxReadSize = 64 * 1024
+	// readdirBatch is the number of entries returned by a read of a directory.
+	readdirBatch = 100
+)
+
+// errReadOnly is the error of operations that modify files under a read-only policy.
+var errReadOnly = errors.New("the policy is read-only")
+
+// errNoHandle is the error of requests naming a handle that is not open.
+var errNoHandle = errors.New("invalid handle")
+
+// Server serves the SFTP requests of a single client.
+type Server struct {
+	config    *config.ShellCommandConfig
+	validator *validator.CommandValidator
+	logger    *logger.Logger
+	// home is the directory relative paths start from
+	home string
+
+	handles    map[string]*handle
+	nextHandle uint64
+}
+
+// handle is an open file or directory.
+type handle struct {
+	path  string
+	file  *os.File
+	dir   bool
+	write bool
+	// append is set for files opened for appending, which are written at their end
+	append bool
+}
+
+// New returns a server for the client identified as id, bounded by the policy of id (see
+// config.ShellCommandConfig.ForUser).
+func New(cfg *config.ShellCommandConfig, v *validator.CommandValidator, log *logger.Logger, id identity.Identity) *Server {
+	cfg = cfg.ForUser(id.Key())
+	return &Server{
+		config:    cfg,
+		validator: v.WithConfig(cfg).WithIdentity(id),
+		logger:    log.With(id.String()),
+		home:      cfg.DefaultDirectory(),
+		handles:   make(map[string]*handle),
+	}
+}
+
+// Serve answers the requests read from rw until it is closed, then closes the files left open.
+func (s *Server) Serve(rw io.ReadWriter) error {
+	defer s.closeAll()
+
+	t, _, err := readPacket(rw)
+	if err != nil {
+		return err
+	}
+	if t != fxpInit {
+		return fmt.Errorf("expected SSH_FXP_INIT, got packet type %d", t)
+	}
+	// The version packet carries no request id; the version takes its place
+	if _, err := rw.Write(newPacket(fxpVersion, protocolVersion).packet()); err != nil {
+		return err
+	}
+
+	for {
+		t, payload, err := readPacket(rw)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		reply := s.handle(t, &decoder{buf: payload})
+		if _, err := rw.Write(reply.packet()); err != nil {
+			return err
+		}
+	}
+}
+
+// handle answers a request.
+func (s *Server) handle(t byte, d *decoder) *encoder {
+	id := d.uint32()
+	if d.err != nil {
+		return statusPacket(id, errBadMessage)
+	}
+	switch t {
+	case fxpOpen:
+		return s.open(id, d)
+	case fxpClose:
+		return s.close(id, d)
+	case fxpRead:
+		return s.read(id, d)
+	case fxpWrite:
+		return s.write(id, d)
+	case fxpLstat, fxpStat:
+		return s.stat(id, t, d)
+	case fxpFstat:
+		return s.fstat(id, d)
+	case fxpSetstat:
+		return s.setstat(id, d)
+	case fxpFsetstat:
+		return s.fsetstat(id, d)
+	case fxpOpendir:
+		return s.opendir(id, d)
+	case fxpReaddir:
+		return s.readdir(id, d)
+	case fxpRemove:
+		return s.remove(id, d)
+	case fxpMkdir:
+		return s.mkdir(id, d)
+	case fxpRmdir:
+		return s.rmdir(id, d)
+	case fxpRealpath:
+		return s.realpath(id, d)
+	case fxpRename:
+		return s.rename(id, d)
+	case fxpReadlink:
+		return s.readlink(id, d)
+	default:
+		// Symbolic links could point outside of the allowed directories, and no extensions
+		// are offered
+		return newStatus(id, fxOpUnsupported, "operation not supported")
+	}
+}
+
+// check resolves the path of a request and checks it against the policy, logging the attempt
+// as the operation op. Modifying operations are refused under a read-only policy, and are
+// checked like redirections otherwise, so that readOnlyDirectories stay read-only.
+func (s *Server) check(op, name string, modify bool) (string, error) {
+	path := s.resolve(name)
+	allowed, reason := s.validator.IsPathInAllowedDirectory(path, s.home)
+	if allowed && modify && !s.readOnly() {
+		allowed, reason = s.validator.CheckWriteTarget(path, s.home)
+	}
+	var err error
+	switch {
+	case !allowed:
+		err = fmt.Errorf("%w: %s", fs.ErrPermission, reason)
+	case modify && s.readOnly():
+		err = fmt.Errorf("%w: cannot %s %q: %w", fs.ErrPermission, op, name, errReadOnly)
+	}
+	s.logger.LogCommandAttempt("sftp:"+op, []string{path}, err == nil)
+	return path, err
+}
+
+// readOnly reports whether the policy forbids modifying files.
+func (s *Server) readOnly() bool {
+	return s.config.SFTP.ReadOnly || s.config.ReadOnlyOnly
+}
+
+// resolve returns the absolute, clean form of a path, relative paths being relative to the
+// home directory.
+func (s *Server) resolve(name string) string {
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(s.home, name)
+	}
+	return filepath.Clean(name)
+}
+
+func (s *Server) open(id uint32, d *decoder) *encoder {
+	name, pflags, attrs := d.string(), d.uint32(), d.attrs()
+	if d.err != nil {
+		return statusPacket(id, d.err)
+	}
+	write := pflags&(fxfWrite|fxfAppend|fxfCreat|fxfTrunc) != 0
+	path, err := s.check("open", name, write)
+	if err != nil {
+		return statusPacket(id, err)
+	}
+	perm := fs.FileMode(0o644)
+	if attrs.flags&attrPermissions != 0 {
+		perm = fs.FileMode(attrs.permissions).Perm()
+	}
+	file, err := os.OpenFile(path, osFlags(pflags), perm)
+	if err != nil {
+		return statusPacket(id, err)
+	}
+	return s.addHandle(id, &handle{path: path, file: file, write: write, append: pflags&fxfAppend != 0})
+}
+
+func (s *Server) opendir(id uint32, d *decoder) *encoder {
+	name := d.string()
+	if d.err != nil {
+		return statusPacket(id, d.err)
+	}
+	path, err := s.check("opendir", name, false)
+	if err != nil {
+		return statusPacket(id, err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return statusPacket(id, err)
+	}
+	if info, err := file.Stat(); err != nil || !info.IsDir() {
+		file.Close()
+		if err == nil {
+			err = fmt.Errorf("%q is not a directory", name)
+		}
+		return statusPacket(id, err)
+	}
+	return s.addHandle(id, &handle{path: path, file: file, dir: true})
+}
+
+// addHandle registers h and returns the packet naming it.
+func (s *Server) addHandle(id uint32, h *handle) *encoder {
+	if len(s.handles) >= maxHandles {
+		h.file.Close()
+		return newStatus(id, fxFailure, "too many open handles")
+	}
+	s.nextHandle++
+	name := strconv.FormatUint(s.nextHandle, 10)
+	s.handles[name] = h
+	p := newPacket(fxpHandle, id)
+	p.string(name)
+	return p
+}
+
+// lookup returns the open handle named by the request.
+func (s *Server) lookup(d *decoder) (*handle, error) {
+	name := d.string()
+	if d.err != nil {
+		return nil, d.err
+	}
+	h, ok := s.handles[name]
+	if !ok {
+		return nil, errNoHandle
+	}
+	return h, nil
+}
+
+func (s *Server) close(id uint32, d *decoder) *encoder {
+	name := d.string()
+	h, ok := s.handles[name]
+	if d.err != nil || !ok {
+		return statusPacket(id, errNoHandle)
+	}
+	delete(s.handles, name)
+	return statusPacket(id, h.file.Close())
+}
+
+// closeAll closes the handles the client left open.
+func (s *Server) closeAll() {
+	for name, h := range s.handles {
+		h.file.Close()
+		delete(s.handles, name)
+	}
+}
+
+func (s *Server) read(id uint32, d *decoder) *encoder {
+	h, err := s.lookup(d)
+	offset, length := d.uint64(), d.uint32()
+	if err == nil {
+		err = d.err
+	}
+	if err == nil && h.dir {
+		err = errNoHandle
+	}
+	if err != nil {
+		return statusPacket(id, err)
+	}
+	buf := make([]byte, min(length, maxReadSize))
+	n, err := h.file.ReadAt(buf, int64(min(offset, 1<<62))) //nolint:gosec // bounded above
+	if n == 0 && err != nil {
+		return statusPacket(id, err)
+	}
+	p := newPacket(fxpData, id)
+	p.bytes(buf[:n])
+	return p
+}
+
+func (s *Server) write(id uint32, d *decoder) *encoder {
+	h, err := s.lookup(d)
+	offset, data := d.uint64(), d.bytes()
+	if err == nil {
+		err = d.err
+	}
+	if err == nil && (h.dir || !h.write) {
+		err = fmt.Errorf("%w: handle is not open for writing", fs.ErrPermission)
+	}
+	if err != nil {
+		return statusPacket(id, err)
+	}
+	if h.append {
+		_, err = h.file.Write(data)
+	} else {
+		_, err = h.file.WriteAt(data, int64(min(offset, 1<<62))) //nolint:gosec // bounded above
+	}
+	return statusPacket(id, err)
+}
+
+func (s *Server) stat(id uint32, t byte, d *decoder) *encoder {
+	name := d.string()
+	if d.err != nil {
+		return statusPacket(id, d.err)
+	}
+	op, statFn := "stat", os.Stat
+	if t == fxpLstat {
+		op, statFn = "lstat", os.Lstat
+	}
+	path, err := s.check(op, name, false)
+	if err != nil {
+		return statusPacket(id, err)
+	}
+	info, err := statFn(path)
+	if err != nil {
+		return statusPacket(id, err)
+	}
+	return attrsPacket(id, info)
+}
+
+func (s *Server) fstat(id uint32, d *decoder) *encoder {
+	h, err := s.lookup(d)
+	if err != nil {
+		return statusPacket(id, err)
+	}
+	info, err := h.file.Stat()
+	if err != nil {
+		return statusPacket(id, err)
+	}
+	return attrsPacket(id, info)
+}
+
+func (s *Server) setstat(id uint32, d *decoder) *encoder {
+	name, attrs := d.string(), d.attrs()
+	if d.err != nil {
+		return statusPacket(id, d.err)
+	}
+	path, err := s.check("setstat", name, true)
+	if err != nil {
+		return statusPacket(id, err)
+	}
+	return statusPacket(id, setAttrs(path, nil, attrs))
+}
+
+func (s *Server) fsetstat(id uint32, d *decoder) *encoder {
+	h, err := s.lookup(d)
+	attrs := d.attrs()
+	if err == nil {
+		err = d.err
+	}
+	if err != nil {
+		return statusPacket(id, err)
+	}
+	if _, err := s.check("fsetstat", h.path, true); err != nil {
+		return statusPacket(id, err)
+	}
+	return statusPacket(id, setAttrs(h.path, h.file, attrs))
+}
+
+// setAttrs changes the size, permissions, and times of the file at path, through file if it
+// is open. Owners cannot be changed.
+func setAttrs(path string, file *os.File, a fileAttrs) error {
+	if a.flags&attrUIDGID != 0 {
+		return errors.ErrUnsupported
+	}
+	if a.flags&attrSize != 0 {
+		size := int64(min(a.size, 1<<62)) //nolint:gosec // bounded above
+		var err error
+		if file != nil {
+			err = file.Truncate(size)
+		} else {
+			err = os.Truncate(path, size)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if a.flags&attrPermissions != 0 {
+		if err := os.Chmod(path, fs.FileMode(a.permissions).Perm()); err != nil {
+			return err
+		}
+	}
+	if a.flags&attrACModTime != 0 {
+		atime := time.Unix(int64(a.atime), 0)
+		mtime := time.Unix(int64(a.mtime), 0)
+		if err := os.Chtimes(path, atime, mtime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) readdir(id uint32, d *decoder) *encoder {
+	h, err := s.lookup(d)
+	if err == nil && !h.dir {
+		err = errNoHandle
+	}
+	if err != nil {
+		return statusPacket(id, err)
+	}
+	entries, err := h.file.ReadDir(readdirBatch)
+	if len(entries) == 0 {
+		if err == nil {
+			err = io.EOF
+		}
+		return statusPacket(id, err)
+	}
+	p := newPacket(fxpName, id)
+	p.uint32(uint32(len(entries))) //nolint:gosec // at most readdirBatch
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			// The entry was removed since it was listed
+			info = removedEntry{entry}
+		}
+		a := attrsOf(info)
+		p.string(entry.Name())
+		p.string(longName(entry.Name(), info, a))
+		p.attrs(a)
+	}
+	return p
+}
+
+// removedEntry describes a directory entry removed before its attributes were read.
+type removedEntry struct{ fs.DirEntry }
+
+func (e removedEntry) Size() int64        { return 0 }
+func (e removedEntry) Mode() fs.FileMode  { return e.Type() }
+func (e removedEntry) ModTime() time.Time { return time.Time{} }
+func (e removedEntry) Sys() any           { return nil }
+
+func (s *Server) remove(id uint32, d *decoder) *encoder {
+	name := d.string()
+	if d.err != nil {
+		return statusPacket(id, d.err)
+	}
+	path, err := s.check("remove", name, true)
+	if err != nil {
+		return statusPacket(id, err)
+	}
+	if info, err := os.Lstat(path); err == nil && info.IsDir() {
+		return newStatus(id, fxFailure, fmt.Sprintf("%q is a directory", name))
+	}
+	return statusPacket(id, os.Remove(path))
+}
+
+func (s *Server) mkdir(id uint32, d *decoder) *encoder {
+	name, attrs := d.string(), d.attrs()
+	if d.err != nil {
+		return statusPacket(id, d.err)
+	}
+	path, err := s.check("mkdir", name, true)
+	if err != nil {
+		return statusPacket(id, err)
+	}
+	perm := fs.FileMode(0o755)
+	if attrs.flags&attrPermissions != 0 {
+		perm = fs.FileMode(attrs.permissions).Perm()
+	}
+	return statusPacket(id, os.Mkdir(path, perm))
+}
+
+func (s *Server) rmdir(id uint32, d *decoder) *encoder {
+	name := d.string()
+	if d.err != nil {
+		return statusPacket(id, d.err)
+	}
+	path, err := s.check("rmdir", name, true)
+	if err != nil {
+		return statusPacket(id, err)
+	}
+	if info, err := os.Lstat(path); err == nil && !info.IsDir() {
+		return newStatus(id, fxFailure, fmt.Sprintf("%q is not a directory", name))
+	}
+	return statusPacket(id, os.Remove(path))
+}
+
+func (s *Server) rename(id uint32, d *decoder) *encoder {
+	oldName, newName := d.string(), d.string()
+	if d.err != nil {
+		return statusPacket(id, d.err)
+	}
+	oldPath, err := s.check("rename", oldName, true)
+	if err != nil {
+		return statusPacket(id, err)
+	}
+	newPath, err := s.check("rename", newName, true)
+	if err != nil {
+		return statusPacket(id, err)
+	}
+	// SFTP version 3 does not overwrite the target of a rename
+	if _, err := os.Lstat(newPath); err == nil {
+		return newStatus(id, fxFailure, fmt.Sprintf("%q already exists", newName))
+	}
+	return statusPacket(id, os.Rename(oldPath, newPath))
+}
+
+func (s *Server) realpath(id uint32, d *decoder) *encoder {
+	name := d.string()
+	if d.err != nil {
+		return statusPacket(id, d.err)
+	}
+	// Clients resolve "." to find the home directory and ".." to go up, so the policy is
+	// only checked when the path is used
+	path := s.resolve(name)
+	p := newPacket(fxpName, id)
+	p.uint32(1)
+	p.string(path)
+	p.string(path)
+	p.attrs(fileAttrs{})
+	return p
+}
+
+func (s *Server) readlink(id uint32, d *decoder) *encoder {
+	name := d.string()
+	if d.err != nil {
+		return statusPacket(id, d.err)
+	}
+	path, err := s.check("readlink", name, false)
+	if err != nil {
+		return statusPacket(id, err)
+	}
+	target, err := os.Readlink(path)
+	if err != nil {
+		return statusPacket(id, err)
+	}
+	p := newPacket(fxpName, id)
+	p.uint32(1)
+	p.string(target)
+	p.string(target)
+	p.attrs(fileAttrs{})
+	return p
+}
+
+// attrsPacket returns the packet describing the file of info.
+func attrsPacket(id uint32, info fs.FileInfo) *encoder {
+	p := newPacket(fxpAttrs, id)
+	p.attrs(attrsOf(info))
+	return p
+}
+
+// statusPacket returns the status packet reporting err, or success if it is nil.
+func statusPacket(id uint32, err error) *encoder {
+	switch {
+	case err == nil:
+		return newStatus(id, fxOK, "")
+	case errors.Is(err, io.EOF):
+		return newStatus(id, fxEOF, "end of file")
+	case errors.Is(err, errBadMessage):
+		return newStatus(id, fxBadMessage, err.Error())
+	case errors.Is(err, errors.ErrUnsupported):
+		return newStatus(id, fxOpUnsupported, err.Error())
+	case errors.Is(err, fs.ErrNotExist):
+		return newStatus(id, fxNoSuchFile, err.Error())
+	case errors.Is(err, fs.ErrPermission):
+		return newStatus(id, fxPermissionDenied, err.Error())
+	default:
+		return newStatus(id, fxFailure, err.Error())
+	}
+}
+
+// newStatus returns a status packet with code and message.
+func newStatus(id uint32, code uint32, message string) *encoder {
+	p := newPacket(fxpStatus, id)
+	p.uint32(code)
+	p.string(message)
+	p.string("en")
+	return p
+}
@@ -0,0 +1,336 @@
+package sftpserver
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+// testClient sends requests to a Server over a pipe.
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	id   uint32
+}
+
+// startServer serves cfg on a pipe and returns a client that has completed the handshake.
+func startServer(t *testing.T, cfg *config.ShellCommandConfig) *testClient {
+	t.Helper()
+	log := logger.New()
+	clientConn, serverConn := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- New(cfg, validator.New(cfg, log), log, identity.Identity{User: "tester"}).Serve(serverConn)
+		serverConn.Close()
+	}()
+	t.Cleanup(func() {
+		clientConn.Close()
+		assert.NoError(t, <-done)
+	})
+
+	c := &testClient{t: t, conn: clientConn}
+	init := newPacket(fxpInit, protocolVersion)
+	_, err := clientConn.Write(init.packet())
+	assert.NoError(t, err)
+	typ, payload, err := readPacket(clientConn)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(fxpVersion), typ)
+	d := &decoder{buf: payload}
+	assert.Equal(t, uint32(protocolVersion), d.uint32())
+	return c
+}
+
+// request sends a request of type typ whose fields are written by fill, and returns the
+// type and fields of the reply.
+func (c *testClient) request(typ byte, fill func(e *encoder)) (byte, *decoder) {
+	c.t.Helper()
+	c.id++
+	p := newPacket(typ, c.id)
+	if fill != nil {
+		fill(p)
+	}
+	_, err := c.conn.Write(p.packet())
+	assert.NoError(c.t, err)
+	replyType, payload, err := readPacket(c.conn)
+	assert.NoError(c.t, err)
+	d := &decoder{buf: payload}
+	assert.Equal(c.t, c.id, d.uint32())
+	return replyType, d
+}
+
+// status sends a request and returns the code of its status reply.
+func (c *testClient) status(typ byte, fill func(e *encoder)) uint32 {
+	c.t.Helper()
+	replyType, d := c.request(typ, fill)
+	assert.Equal(c.t, byte(fxpStatus), replyType)
+	return d.uint32()
+}
+
+// open opens name with pflags and returns the handle, or the status code of the failure.
+func (c *testClient) open(name string, pflags uint32) (string, uint32) {
+	c.t.Helper()
+	replyType, d := c.request(fxpOpen, func(e *encoder) {
+		e.string(name)
+		e.uint32(pflags)
+		e.uint32(0)
+	})
+	if replyType == fxpStatus {
+		return "", d.uint32()
+	}
+	assert.Equal(c.t, byte(fxpHandle), replyType)
+	return d.string(), fxOK
+}
+
+func pathRequest(name string) func(e *encoder) {
+	return func(e *encoder) { e.string(name) }
+}
+
+func newTestConfig(t *testing.T) (*config.ShellCommandConfig, string) {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := config.NewDefaultConfig()
+	cfg.AllowedDirectories = []string{dir}
+	cfg.SFTP.Enabled = true
+	return cfg, dir
+}
+
+func TestServer_ReadWrite(t *testing.T) {
+	cfg, dir := newTestConfig(t)
+	c := startServer(t, cfg)
+
+	h, code := c.open("notes.txt", fxfWrite|fxfCreat|fxfTrunc)
+	assert.Equal(t, uint32(fxOK), code)
+	assert.Equal(t, uint32(fxOK), c.status(fxpWrite, func(e *encoder) {
+		e.string(h)
+		e.uint64(0)
+		e.string("hello sftp")
+	}))
+	assert.Equal(t, uint32(fxOK), c.status(fxpClose, pathRequest(h)))
+
+	data, err := os.ReadFile(filepath.Join(dir, "notes.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello sftp", string(data))
+
+	h, code = c.open(filepath.Join(dir, "notes.txt"), fxfRead)
+	assert.Equal(t, uint32(fxOK), code)
+	read := func(offset uint64) (byte, *decoder) {
+		return c.request(fxpRead, func(e *encoder) {
+			e.string(h)
+			e.uint64(offset)
+			e.uint32(1024)
+		})
+	}
+	replyType, d := read(0)
+	assert.Equal(t, byte(fxpData), replyType)
+	assert.Equal(t, "hello sftp", d.string())
+	replyType, d = read(10)
+	assert.Equal(t, byte(fxpStatus), replyType)
+	assert.Equal(t, uint32(fxEOF), d.uint32())
+
+	// A handle opened for reading cannot write
+	assert.Equal(t, uint32(fxPermissionDenied), c.status(fxpWrite, func(e *encoder) {
+		e.string(h)
+		e.uint64(0)
+		e.string("x")
+	}))
+	assert.Equal(t, uint32(fxOK), c.status(fxpClose, pathRequest(h)))
+	assert.Equal(t, uint32(fxFailure), c.status(fxpClose, pathRequest(h)))
+}
+
+func TestServer_OutsideAllowedDirectories(t *testing.T) {
+	cfg, _ := newTestConfig(t)
+	outside := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0o600))
+	c := startServer(t, cfg)
+
+	_, code := c.open(filepath.Join(outside, "secret"), fxfRead)
+	assert.Equal(t, uint32(fxPermissionDenied), code)
+	_, code = c.open("../"+filepath.Base(outside)+"/secret", fxfRead)
+	assert.Equal(t, uint32(fxPermissionDenied), code)
+	assert.Equal(t, uint32(fxPermissionDenied), c.status(fxpStat, pathRequest(outside)))
+	assert.Equal(t, uint32(fxPermissionDenied), c.status(fxpOpendir, pathRequest(outside)))
+	assert.Equal(t, uint32(fxPermissionDenied), c.status(fxpRemove, pathRequest(filepath.Join(outside, "secret"))))
+	assert.Equal(t, uint32(fxPermissionDenied), c.status(fxpMkdir, func(e *encoder) {
+		e.string(filepath.Join(outside, "new"))
+		e.uint32(0)
+	}))
+
+	_, err := os.Stat(filepath.Join(outside, "secret"))
+	assert.NoError(t, err)
+}
+
+func TestServer_SymlinkOutside(t *testing.T) {
+	cfg, dir := newTestConfig(t)
+	outside := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0o600))
+	assert.NoError(t, os.Symlink(outside, filepath.Join(dir, "escape")))
+	c := startServer(t, cfg)
+
+	_, code := c.open("escape/secret", fxfRead)
+	assert.Equal(t, uint32(fxPermissionDenied), code)
+	assert.Equal(t, uint32(fxOpUnsupported), c.status(fxpSymlink, func(e *encoder) {
+		e.string("/etc")
+		e.string("link")
+	}))
+}
+
+func TestServer_ReadOnly(t *testing.T) {
+	cfg, dir := newTestConfig(t)
+	cfg.SFTP.ReadOnly = true
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0o600))
+	c := startServer(t, cfg)
+
+	h, code := c.open("file", fxfRead)
+	assert.Equal(t, uint32(fxOK), code)
+	assert.Equal(t, uint32(fxOK), c.status(fxpClose, pathRequest(h)))
+
+	_, code = c.open("file", fxfWrite|fxfTrunc)
+	assert.Equal(t, uint32(fxPermissionDenied), code)
+	_, code = c.open("new", fxfWrite|fxfCreat)
+	assert.Equal(t, uint32(fxPermissionDenied), code)
+	assert.Equal(t, uint32(fxPermissionDenied), c.status(fxpRemove, pathRequest("file")))
+	assert.Equal(t, uint32(fxPermissionDenied), c.status(fxpRename, func(e *encoder) {
+		e.string("file")
+		e.string("moved")
+	}))
+	assert.Equal(t, uint32(fxPermissionDenied), c.status(fxpSetstat, func(e *encoder) {
+		e.string("file")
+		e.uint32(attrSize)
+		e.uint64(0)
+	}))
+
+	data, err := os.ReadFile(filepath.Join(dir, "file"))
+	assert.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+}
+
+func TestServer_ReadOnlyOnlyPolicy(t *testing.T) {
+	cfg, _ := newTestConfig(t)
+	cfg.ReadOnlyOnly = true
+	c := startServer(t, cfg)
+
+	assert.Equal(t, uint32(fxPermissionDenied), c.status(fxpMkdir, func(e *encoder) {
+		e.string("dir")
+		e.uint32(0)
+	}))
+}
+
+func TestServer_ReadOnlyDirectories(t *testing.T) {
+	cfg, dir := newTestConfig(t)
+	vendor := filepath.Join(dir, "vendor")
+	assert.NoError(t, os.Mkdir(vendor, 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(vendor, "lib"), []byte("lib"), 0o600))
+	cfg.ReadOnlyDirectories = []string{vendor}
+	c := startServer(t, cfg)
+
+	h, code := c.open("vendor/lib", fxfRead)
+	assert.Equal(t, uint32(fxOK), code)
+	assert.Equal(t, uint32(fxOK), c.status(fxpClose, pathRequest(h)))
+
+	_, code = c.open("vendor/lib", fxfWrite|fxfTrunc)
+	assert.Equal(t, uint32(fxPermissionDenied), code)
+	_, code = c.open("vendor/new", fxfWrite|fxfCreat)
+	assert.Equal(t, uint32(fxPermissionDenied), code)
+	assert.Equal(t, uint32(fxPermissionDenied), c.status(fxpSetstat, func(e *encoder) {
+		e.string("vendor/lib")
+		e.uint32(attrSize)
+		e.uint64(0)
+	}))
+	assert.Equal(t, uint32(fxPermissionDenied), c.status(fxpRemove, pathRequest("vendor/lib")))
+	assert.Equal(t, uint32(fxPermissionDenied), c.status(fxpMkdir, func(e *encoder) {
+		e.string("vendor/sub")
+		e.uint32(0)
+	}))
+	assert.Equal(t, uint32(fxPermissionDenied), c.status(fxpRmdir, pathRequest("vendor")))
+	assert.Equal(t, uint32(fxPermissionDenied), c.status(fxpRename, func(e *encoder) {
+		e.string("vendor/lib")
+		e.string("lib")
+	}))
+
+	data, err := os.ReadFile(filepath.Join(vendor, "lib"))
+	assert.NoError(t, err)
+	assert.Equal(t, "lib", string(data))
+
+	// Files beside the read-only directory stay writable
+	h, code = c.open("notes.txt", fxfWrite|fxfCreat)
+	assert.Equal(t, uint32(fxOK), code)
+	assert.Equal(t, uint32(fxOK), c.status(fxpClose, pathRequest(h)))
+}
+
+func TestServer_RenameRemove(t *testing.T) {
+	cfg, dir := newTestConfig(t)
+	outside := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "a"), []byte("a"), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "b"), []byte("b"), 0o600))
+	c := startServer(t, cfg)
+
+	rename := func(from, to string) uint32 {
+		return c.status(fxpRename, func(e *encoder) {
+			e.string(from)
+			e.string(to)
+		})
+	}
+	assert.Equal(t, uint32(fxPermissionDenied), rename("a", filepath.Join(outside, "a")))
+	assert.Equal(t, uint32(fxFailure), rename("a", "b"))
+	assert.Equal(t, uint32(fxOK), rename("a", "c"))
+	_, err := os.Stat(filepath.Join(dir, "c"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, uint32(fxOK), c.status(fxpMkdir, func(e *encoder) {
+		e.string("sub")
+		e.uint32(0)
+	}))
+	assert.Equal(t, uint32(fxFailure), c.status(fxpRemove, pathRequest("sub")))
+	assert.Equal(t, uint32(fxOK), c.status(fxpRmdir, pathRequest("sub")))
+	assert.Equal(t, uint32(fxOK), c.status(fxpRemove, pathRequest("c")))
+	assert.Equal(t, uint32(fxNoSuchFile), c.status(fxpRemove, pathRequest("c")))
+}
+
+func TestServer_Readdir(t *testing.T) {
+	cfg, dir := newTestConfig(t)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0o600))
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0o755))
+	c := startServer(t, cfg)
+
+	replyType, d := c.request(fxpRealpath, pathRequest("."))
+	assert.Equal(t, byte(fxpName), replyType)
+	assert.Equal(t, uint32(1), d.uint32())
+	assert.Equal(t, dir, d.string())
+
+	replyType, d = c.request(fxpOpendir, pathRequest("."))
+	assert.Equal(t, byte(fxpHandle), replyType)
+	h := d.string()
+
+	entries := map[string]fileAttrs{}
+	for {
+		replyType, d = c.request(fxpReaddir, pathRequest(h))
+		if replyType == fxpStatus {
+			assert.Equal(t, uint32(fxEOF), d.uint32())
+			break
+		}
+		assert.Equal(t, byte(fxpName), replyType)
+		for n := d.uint32(); n > 0; n-- {
+			name, long := d.string(), d.string()
+			entries[name] = d.attrs()
+			assert.Contains(t, long, name)
+		}
+		assert.NoError(t, d.err)
+	}
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, uint64(4), entries["file"].size)
+	assert.Equal(t, uint32(modeRegular|0o600), entries["file"].permissions)
+	assert.Equal(t, uint32(modeDir), entries["sub"].permissions&^0o7777)
+	assert.Equal(t, uint32(fxOK), c.status(fxpClose, pathRequest(h)))
+
+	replyType, d = c.request(fxpStat, pathRequest("file"))
+	assert.Equal(t, byte(fxpAttrs), replyType)
+	assert.Equal(t, uint64(4), d.attrs().size)
+}
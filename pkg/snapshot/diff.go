@@ -0,0 +1,274 @@
+package snapshot
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+)
+
+// Kind is the kind of a change.
+type Kind string
+
+const (
+	// Added is a file, directory, or symlink that exists only in the snapshot.
+	Added Kind = "added"
+	// Modified is a path whose type, permissions, content, or link target differ.
+	Modified Kind = "modified"
+	// Deleted is a path that exists only in the source. The contents of a deleted directory
+	// are not listed separately.
+	Deleted Kind = "deleted"
+)
+
+// Change is a difference between a snapshot and its source.
+type Change struct {
+	// Path is relative to the snapshotted directory, with slash separators.
+	Path string `json:"path"`
+	Kind Kind   `json:"kind"`
+}
+
+// compareChunk is the size of the blocks in which file contents are compared.
+const compareChunk = 32 * 1024
+
+// diff returns the changes that turn the tree src into the tree dst, ordered by path.
+func diff(src, dst string) ([]Change, error) {
+	var changes []Change
+	err := filepath.WalkDir(dst, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dst, path)
+		if rel == "." {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		srcInfo, err := os.Lstat(filepath.Join(src, rel))
+		switch {
+		case missing(err):
+			changes = append(changes, Change{Path: filepath.ToSlash(rel), Kind: Added})
+		case err != nil:
+			return err
+		default:
+			modified, err := differ(filepath.Join(src, rel), srcInfo, path, info)
+			if err != nil {
+				return err
+			}
+			if modified {
+				changes = append(changes, Change{Path: filepath.ToSlash(rel), Kind: Modified})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		if rel == "." {
+			return nil
+		}
+		if _, err := os.Lstat(filepath.Join(dst, rel)); !missing(err) {
+			return err
+		}
+		changes = append(changes, Change{Path: filepath.ToSlash(rel), Kind: Deleted})
+		if d.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// A directory sorts before its contents, so that applying changes in order creates it first
+	slices.SortFunc(changes, func(a, b Change) int { return strings.Compare(a.Path, b.Path) })
+	return changes, nil
+}
+
+// missing reports whether err means that a path does not exist, including because one of
+// its parents is not a directory.
+func missing(err error) bool {
+	return errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR)
+}
+
+// differ reports whether the file at dst differs from the file at src.
+func differ(src string, srcInfo fs.FileInfo, dst string, dstInfo fs.FileInfo) (bool, error) {
+	if srcInfo.Mode() != dstInfo.Mode() {
+		return true, nil
+	}
+	switch {
+	case srcInfo.Mode()&fs.ModeSymlink != 0:
+		srcTarget, err := os.Readlink(src)
+		if err != nil {
+			return false, err
+		}
+		dstTarget, err := os.Readlink(dst)
+		return srcTarget != dstTarget, err
+	case !srcInfo.Mode().IsRegular():
+		return false, nil
+	case srcInfo.Size() != dstInfo.Size():
+		return true, nil
+	case srcInfo.ModTime().Equal(dstInfo.ModTime()):
+		// Copies keep modification times, so a file written to changes its time or size
+		return false, nil
+	}
+	return contentsDiffer(src, dst)
+}
+
+// contentsDiffer reports whether two files of the same size have different contents.
+func contentsDiffer(a, b string) (bool, error) {
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	bufA, bufB := make([]byte, compareChunk), make([]byte, compareChunk)
+	for {
+		na, errA := io.ReadFull(fa, bufA)
+		nb, errB := io.ReadFull(fb, bufB)
+		if !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return true, nil
+		}
+		if errA != nil || errB != nil {
+			if errors.Is(errA, io.EOF) || errors.Is(errA, io.ErrUnexpectedEOF) {
+				return false, nil
+			}
+			return false, errors.Join(errA, errB)
+		}
+	}
+}
+
+// apply makes the tree dst match the tree src for the given changes from diff(dst, src).
+func apply(src, dst string, changes []Change) error {
+	// Deleted directories may be replaced by files of the same name, so remove them first
+	for _, c := range changes {
+		if c.Kind == Deleted {
+			if err := os.RemoveAll(filepath.Join(dst, filepath.FromSlash(c.Path))); err != nil {
+				return err
+			}
+		}
+	}
+	for _, c := range changes {
+		if c.Kind == Deleted {
+			continue
+		}
+		from, to := filepath.Join(src, filepath.FromSlash(c.Path)), filepath.Join(dst, filepath.FromSlash(c.Path))
+		info, err := os.Lstat(from)
+		if err != nil {
+			return err
+		}
+		if c.Kind == Modified {
+			if current, err := os.Lstat(to); err == nil && (current.IsDir() != info.IsDir() || !current.Mode().IsRegular()) {
+				if err := os.RemoveAll(to); err != nil {
+					return err
+				}
+			}
+		}
+		if err := copyEntry(from, to, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyTree copies the contents of the directory src into the existing directory dst.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		if rel == "." {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return copyEntry(path, filepath.Join(dst, rel), info)
+	})
+}
+
+// copyEntry copies a single directory, symlink, or regular file described by info from src
+// to dst, keeping its permissions and, for files, its modification time. Directories are
+// created without their contents, and other file types are skipped.
+func copyEntry(src, dst string, info fs.FileInfo) error {
+	switch {
+	case info.IsDir():
+		if err := os.Mkdir(dst, info.Mode().Perm()); err != nil && !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+		return os.Chmod(dst, info.Mode().Perm())
+	case info.Mode()&fs.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(dst); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return os.Symlink(target, dst)
+	case info.Mode().IsRegular():
+		if err := copyFile(src, dst, info.Mode().Perm()); err != nil {
+			return err
+		}
+		return os.Chtimes(dst, info.ModTime(), info.ModTime())
+	}
+	return nil
+}
+
+// copyFile writes the contents of src to dst with the permissions perm.
+func copyFile(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(dst, perm)
+}
+
+// treeSize returns the total size of the regular files under dir.
+func treeSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,26 @@
+package snapshot
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// mountOverlay mounts on merged an overlayfs whose lower layer is lower, so that writes to
+// merged go to upper and lower is left unchanged. It requires CAP_SYS_ADMIN.
+func mountOverlay(lower, upper, work, merged string) error {
+	for _, dir := range []string{lower, upper, work} {
+		// The mount options cannot express these characters without escaping
+		if strings.ContainsAny(dir, `,:\`) {
+			return errors.New("path cannot be used as an overlayfs layer: " + dir)
+		}
+	}
+	data := "lowerdir=" + lower + ",upperdir=" + upper + ",workdir=" + work
+	return unix.Mount("overlay", merged, "overlay", unix.MS_NOSUID|unix.MS_NODEV, data)
+}
+
+// unmountOverlay detaches the overlayfs mounted on merged.
+func unmountOverlay(merged string) error {
+	return unix.Unmount(merged, unix.MNT_DETACH)
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package snapshot
+
+import "errors"
+
+// errOverlayUnsupported is returned when overlayfs is requested on a platform without it.
+var errOverlayUnsupported = errors.New("overlayfs is only supported on Linux")
+
+// mountOverlay fails, since overlayfs is only available on Linux; snapshots are copies.
+func mountOverlay(_, _, _, _ string) error {
+	return errOverlayUnsupported
+}
+
+// unmountOverlay fails, since no overlayfs can have been mounted.
+func unmountOverlay(string) error {
+	return errOverlayUnsupported
+}
@@ -0,0 +1,275 @@
+// Package snapshot keeps copy-on-write snapshots of directories, so that commands can change
+// a snapshot instead of the directory and the caller can commit the changes to the directory
+// or discard them afterwards. On Linux a snapshot is an overlayfs mount when the server may
+// mount one; elsewhere, or when mounting fails, it is a copy of the directory.
+package snapshot
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+const (
+	// dirPattern is the name pattern of snapshot directories.
+	dirPattern = "secure-shell-snapshot-"
+	// idBytes is the number of random bytes in a snapshot ID.
+	idBytes          = 8
+	bytesPerMegabyte = 1024 * 1024
+)
+
+var (
+	// ErrNotFound is returned for a snapshot ID that is unknown, committed, discarded, or expired.
+	ErrNotFound = errors.New("snapshot not found")
+	// ErrTooLarge is returned when a directory that must be copied exceeds snapshot.maxSize.
+	ErrTooLarge = errors.New("directory is too large to snapshot")
+)
+
+// Store creates snapshots and finds them by ID until they are committed, discarded, or expire.
+type Store struct {
+	dir       string
+	maxBytes  int64
+	retention time.Duration
+
+	mu        sync.Mutex
+	snapshots map[string]*Snapshot
+}
+
+// New creates a Store configured by cfg.
+func New(cfg config.SnapshotConfig) *Store {
+	retention := cfg.Retention
+	if retention == 0 {
+		retention = config.DefaultSnapshotRetention
+	}
+	return &Store{
+		dir:       cfg.Dir,
+		maxBytes:  int64(cfg.MaxSize) * bytesPerMegabyte,
+		retention: time.Duration(retention) * time.Second,
+		snapshots: make(map[string]*Snapshot),
+	}
+}
+
+// Create snapshots the directory source, which must be an absolute path.
+func (s *Store) Create(source string) (*Snapshot, error) {
+	s.Prune()
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot %s: %w", source, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("failed to snapshot %s: not a directory", source)
+	}
+
+	root, err := os.MkdirTemp(s.dir, dirPattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	// Resolve symlinks, e.g. /tmp on macOS, so that the path matches what commands see
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+
+	b := make([]byte, idBytes)
+	_, _ = rand.Read(b)
+	snap := &Snapshot{ID: hex.EncodeToString(b), Source: source, root: root, store: s, used: time.Now()}
+	if err := snap.populate(info, s.maxBytes); err != nil {
+		_ = os.RemoveAll(root)
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.snapshots[snap.ID] = snap
+	s.mu.Unlock()
+	return snap, nil
+}
+
+// Get returns the snapshot with the given ID.
+func (s *Store) Get(id string) (*Snapshot, error) {
+	s.Prune()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap, ok := s.snapshots[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	snap.used = time.Now()
+	return snap, nil
+}
+
+// Prune discards snapshots that have not been used for the retention period.
+func (s *Store) Prune() {
+	s.mu.Lock()
+	var expired []*Snapshot
+	for id, snap := range s.snapshots {
+		if time.Since(snap.used) > s.retention {
+			expired = append(expired, snap)
+			delete(s.snapshots, id)
+		}
+	}
+	s.mu.Unlock()
+	for _, snap := range expired {
+		_ = snap.remove()
+	}
+}
+
+// Close discards every snapshot.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	snapshots := s.snapshots
+	s.snapshots = make(map[string]*Snapshot)
+	s.mu.Unlock()
+
+	var errs []error
+	for _, snap := range snapshots {
+		errs = append(errs, snap.remove())
+	}
+	return errors.Join(errs...)
+}
+
+// Snapshot is a copy-on-write view of a directory.
+type Snapshot struct {
+	// ID is the handle by which the snapshot is found with Store.Get.
+	ID string
+	// Source is the directory that was snapshotted.
+	Source string
+	// Dir is where the snapshot's view of Source is, in which commands are run.
+	Dir string
+	// Overlay is true when Dir is an overlayfs mount whose lower layer is Source. Changes made
+	// to Source afterwards then show through in Dir, unless Dir changed the same files.
+	Overlay bool
+
+	root  string
+	store *Store
+	// used is when the snapshot was created or last found; it is guarded by store.mu
+	used time.Time
+
+	mu      sync.Mutex
+	removed bool
+}
+
+// populate makes Dir a view of Source: an overlayfs mount if possible, or else a copy of
+// Source no larger than maxBytes (unlimited if zero).
+func (s *Snapshot) populate(info os.FileInfo, maxBytes int64) error {
+	upper, work, merged := filepath.Join(s.root, "upper"), filepath.Join(s.root, "work"), filepath.Join(s.root, "merged")
+	if err := mkdirs(upper, work, merged); err != nil {
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	if err := mountOverlay(s.Source, upper, work, merged); err == nil {
+		s.Dir, s.Overlay = merged, true
+		return nil
+	}
+	_ = os.Remove(upper)
+	_ = os.Remove(work)
+	_ = os.Remove(merged)
+
+	if maxBytes > 0 {
+		size, err := treeSize(s.Source)
+		if err != nil {
+			return fmt.Errorf("failed to snapshot %s: %w", s.Source, err)
+		}
+		if size > maxBytes {
+			return fmt.Errorf("%w: %s holds %d bytes, more than %d", ErrTooLarge, s.Source, size, maxBytes)
+		}
+	}
+	s.Dir = filepath.Join(s.root, "tree")
+	if err := os.Mkdir(s.Dir, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	if err := copyTree(s.Source, s.Dir); err != nil {
+		return fmt.Errorf("failed to snapshot %s: %w", s.Source, err)
+	}
+	return nil
+}
+
+// Path returns the path in the snapshot of path, a path in Source. It reports false when
+// path is outside Source.
+func (s *Snapshot) Path(path string) (string, bool) {
+	return rebase(path, s.Source, s.Dir)
+}
+
+// SourcePath returns the path in Source of path, a path in the snapshot. It reports false
+// when path is outside the snapshot.
+func (s *Snapshot) SourcePath(path string) (string, bool) {
+	return rebase(path, s.Dir, s.Source)
+}
+
+// rebase moves path from under the directory from to under the directory to.
+func rebase(path, from, to string) (string, bool) {
+	rel, err := filepath.Rel(from, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.Join(to, rel), true
+}
+
+// Changes compares the snapshot with Source and returns the differences, ordered by path.
+func (s *Snapshot) Changes() ([]Change, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.removed {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, s.ID)
+	}
+	return diff(s.Source, s.Dir)
+}
+
+// Commit applies the changes made in the snapshot to Source, returns them, and discards the
+// snapshot. When applying a change fails, the changes applied before it remain and the
+// snapshot is kept so that the commit can be retried.
+func (s *Snapshot) Commit() ([]Change, error) {
+	s.mu.Lock()
+	if s.removed {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, s.ID)
+	}
+	changes, err := diff(s.Source, s.Dir)
+	if err == nil {
+		err = apply(s.Dir, s.Source, changes)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit snapshot %s: %w", s.ID, err)
+	}
+	return changes, s.Discard()
+}
+
+// Discard removes the snapshot, leaving Source as it is.
+func (s *Snapshot) Discard() error {
+	s.store.mu.Lock()
+	delete(s.store.snapshots, s.ID)
+	s.store.mu.Unlock()
+	return s.remove()
+}
+
+// remove unmounts and deletes the snapshot.
+func (s *Snapshot) remove() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.removed {
+		return nil
+	}
+	if s.Overlay {
+		if err := unmountOverlay(s.Dir); err != nil {
+			return fmt.Errorf("failed to unmount snapshot %s: %w", s.ID, err)
+		}
+	}
+	s.removed = true
+	return os.RemoveAll(s.root)
+}
+
+// mkdirs creates each of dirs, which must not exist.
+func mkdirs(dirs ...string) error {
+	for _, dir := range dirs {
+		if err := os.Mkdir(dir, 0o700); err != nil {
+			return err
+		}
+	}
+	return nil
+}
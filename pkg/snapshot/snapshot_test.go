@@ -0,0 +1,170 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+// writeFiles creates the files in dir, mapping relative paths to contents.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+}
+
+func TestSnapshot_ChangesAndCommit(t *testing.T) {
+	// overlayfs cannot use paths with commas, so directories named like that are copied
+	for _, name := range []string{"overlay", "copy,fallback"} {
+		t.Run(name, func(t *testing.T) {
+			source := filepath.Join(t.TempDir(), name)
+			assert.NoError(t, os.Mkdir(source, 0o700))
+			testChangesAndCommit(t, source)
+		})
+	}
+}
+
+func testChangesAndCommit(t *testing.T, source string) {
+	t.Helper()
+	writeFiles(t, source, map[string]string{
+		"keep.txt":      "keep",
+		"edit.txt":      "before",
+		"gone.txt":      "gone",
+		"old/a.txt":     "a",
+		"old/b/c.txt":   "c",
+		"became-dir":    "file",
+		"same-size.txt": "aaaa",
+	})
+	assert.NoError(t, os.Symlink("keep.txt", filepath.Join(source, "link")))
+
+	s := New(config.SnapshotConfig{Enabled: true, Dir: t.TempDir()})
+	defer s.Close()
+	snap, err := s.Create(source)
+	assert.NoError(t, err)
+
+	changes, err := snap.Changes()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(changes))
+
+	writeFiles(t, snap.Dir, map[string]string{"edit.txt": "after", "new/d.txt": "d", "same-size.txt": "bbbb"})
+	assert.NoError(t, os.Remove(filepath.Join(snap.Dir, "gone.txt")))
+	assert.NoError(t, os.RemoveAll(filepath.Join(snap.Dir, "old")))
+	assert.NoError(t, os.Remove(filepath.Join(snap.Dir, "became-dir")))
+	writeFiles(t, snap.Dir, map[string]string{"became-dir/e.txt": "e"})
+	assert.NoError(t, os.Remove(filepath.Join(snap.Dir, "link")))
+	assert.NoError(t, os.Symlink("edit.txt", filepath.Join(snap.Dir, "link")))
+
+	// The source is unchanged until the snapshot is committed
+	data, err := os.ReadFile(filepath.Join(source, "edit.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "before", string(data))
+
+	want := []Change{
+		{Path: "became-dir", Kind: Modified},
+		{Path: "became-dir/e.txt", Kind: Added},
+		{Path: "edit.txt", Kind: Modified},
+		{Path: "gone.txt", Kind: Deleted},
+		{Path: "link", Kind: Modified},
+		{Path: "new", Kind: Added},
+		{Path: "new/d.txt", Kind: Added},
+		{Path: "old", Kind: Deleted},
+		{Path: "same-size.txt", Kind: Modified},
+	}
+	changes, err = snap.Changes()
+	assert.NoError(t, err)
+	assert.Equal(t, want, changes)
+
+	committed, err := snap.Commit()
+	assert.NoError(t, err)
+	assert.Equal(t, want, committed)
+	for name, content := range map[string]string{
+		"keep.txt": "keep", "edit.txt": "after", "new/d.txt": "d", "became-dir/e.txt": "e", "same-size.txt": "bbbb",
+	} {
+		data, err := os.ReadFile(filepath.Join(source, name))
+		assert.NoError(t, err)
+		assert.Equal(t, content, string(data))
+	}
+	for _, name := range []string{"gone.txt", "old"} {
+		_, err := os.Stat(filepath.Join(source, name))
+		assert.True(t, os.IsNotExist(err))
+	}
+	target, err := os.Readlink(filepath.Join(source, "link"))
+	assert.NoError(t, err)
+	assert.Equal(t, "edit.txt", target)
+
+	// A committed snapshot is removed
+	_, err = s.Get(snap.ID)
+	assert.IsError(t, err, ErrNotFound)
+	_, err = os.Stat(snap.root)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestSnapshot_Discard(t *testing.T) {
+	source := t.TempDir()
+	writeFiles(t, source, map[string]string{"file.txt": "before"})
+	s := New(config.SnapshotConfig{Enabled: true, Dir: t.TempDir()})
+	defer s.Close()
+	snap, err := s.Create(source)
+	assert.NoError(t, err)
+	writeFiles(t, snap.Dir, map[string]string{"file.txt": "after"})
+
+	got, err := s.Get(snap.ID)
+	assert.NoError(t, err)
+	assert.NoError(t, got.Discard())
+
+	data, err := os.ReadFile(filepath.Join(source, "file.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "before", string(data))
+	_, err = s.Get(snap.ID)
+	assert.IsError(t, err, ErrNotFound)
+	_, err = snap.Commit()
+	assert.IsError(t, err, ErrNotFound)
+}
+
+func TestSnapshot_Paths(t *testing.T) {
+	source := t.TempDir()
+	writeFiles(t, source, map[string]string{"sub/file.txt": ""})
+	s := New(config.SnapshotConfig{Enabled: true, Dir: t.TempDir()})
+	defer s.Close()
+	snap, err := s.Create(source)
+	assert.NoError(t, err)
+
+	path, ok := snap.Path(filepath.Join(source, "sub"))
+	assert.True(t, ok)
+	assert.Equal(t, filepath.Join(snap.Dir, "sub"), path)
+	back, ok := snap.SourcePath(path)
+	assert.True(t, ok)
+	assert.Equal(t, filepath.Join(source, "sub"), back)
+	_, ok = snap.Path(filepath.Dir(source))
+	assert.False(t, ok)
+}
+
+func TestStore_Limits(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "copy,fallback")
+	writeFiles(t, source, map[string]string{"big": string(make([]byte, 2*bytesPerMegabyte))})
+
+	s := New(config.SnapshotConfig{Enabled: true, Dir: t.TempDir(), MaxSize: 1})
+	defer s.Close()
+	_, err := s.Create(source)
+	assert.IsError(t, err, ErrTooLarge)
+
+	s = New(config.SnapshotConfig{Enabled: true, Dir: t.TempDir()})
+	defer s.Close()
+	snap, err := s.Create(source)
+	assert.NoError(t, err)
+	s.retention = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	s.Prune()
+	_, err = s.Get(snap.ID)
+	assert.IsError(t, err, ErrNotFound)
+	_, err = os.Stat(snap.root)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,255 @@
+// Package spool keeps the full output of commands whose output exceeded the configured
+// limit in temporary files, so that callers can page through it by handle.
+package spool
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+const (
+	// filePattern is the name pattern of spool files.
+	filePattern = "secure-shell-output-"
+	// idBytes is the number of random bytes in an output ID.
+	idBytes          = 8
+	bytesPerMegabyte = 1024 * 1024
+)
+
+// ErrNotFound is returned for an output ID that is unknown or has expired.
+var ErrNotFound = errors.New("spooled output not found")
+
+// Store creates spooled outputs and finds them by ID until they expire.
+type Store struct {
+	dir       string
+	maxBytes  int64
+	retention time.Duration
+
+	mu      sync.Mutex
+	outputs map[string]*Output
+}
+
+// New creates a Store configured by cfg.
+func New(cfg config.OutputSpoolConfig) *Store {
+	retention := cfg.Retention
+	if retention == 0 {
+		retention = config.DefaultSpoolRetention
+	}
+	return &Store{
+		dir:       cfg.Dir,
+		maxBytes:  int64(cfg.MaxSize) * bytesPerMegabyte,
+		retention: time.Duration(retention) * time.Second,
+		outputs:   make(map[string]*Output),
+	}
+}
+
+// Create starts a spooled output. Its file is created on the first write, so an output
+// that is never written to costs nothing.
+func (s *Store) Create() *Output {
+	s.Prune()
+	b := make([]byte, idBytes)
+	_, _ = rand.Read(b)
+	return &Output{ID: hex.EncodeToString(b), store: s, created: time.Now()}
+}
+
+// Get returns the output with the given ID.
+func (s *Store) Get(id string) (*Output, error) {
+	s.Prune()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.outputs[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	return o, nil
+}
+
+// Prune removes outputs older than the retention period.
+func (s *Store) Prune() {
+	s.mu.Lock()
+	var expired []*Output
+	for id, o := range s.outputs {
+		if time.Since(o.created) > s.retention {
+			expired = append(expired, o)
+			delete(s.outputs, id)
+		}
+	}
+	s.mu.Unlock()
+	for _, o := range expired {
+		_ = o.remove()
+	}
+}
+
+// Close removes every output.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	outputs := s.outputs
+	s.outputs = make(map[string]*Output)
+	s.mu.Unlock()
+
+	var errs []error
+	for _, o := range outputs {
+		errs = append(errs, o.remove())
+	}
+	return errors.Join(errs...)
+}
+
+// Output is the full content of one output stream of an execution.
+type Output struct {
+	// ID is the handle by which the output is found with Store.Get.
+	ID    string
+	store *Store
+
+	mu      sync.Mutex
+	file    *os.File
+	path    string
+	size    int64
+	created time.Time
+	// truncated is set when the output exceeded the store's size limit
+	truncated bool
+	err       error
+	closed    bool
+}
+
+// Write appends p to the spool file. It never fails, so that a spool problem does not
+// disturb the command; failures are reported by Keep.
+func (o *Output) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.err != nil || o.closed {
+		return len(p), nil
+	}
+	if o.file == nil {
+		file, err := os.CreateTemp(o.store.dir, filePattern)
+		if err != nil {
+			o.err = fmt.Errorf("failed to create spool file: %w", err)
+			return len(p), nil
+		}
+		o.file, o.path = file, file.Name()
+	}
+
+	data := p
+	if o.store.maxBytes > 0 && o.size+int64(len(data)) > o.store.maxBytes {
+		data = data[:max(0, o.store.maxBytes-o.size)]
+		o.truncated = true
+	}
+	n, err := o.file.Write(data)
+	o.size += int64(n)
+	if err != nil {
+		o.err = fmt.Errorf("failed to write spool file: %w", err)
+	}
+	return len(p), nil
+}
+
+// Keep finishes writing and registers the output with its store so that it can be found by ID.
+func (o *Output) Keep() error {
+	o.mu.Lock()
+	err := o.finish()
+	o.mu.Unlock()
+	if err != nil {
+		_ = o.remove()
+		return err
+	}
+
+	o.store.mu.Lock()
+	o.store.outputs[o.ID] = o
+	o.store.mu.Unlock()
+	return nil
+}
+
+// Discard finishes writing and removes the spool file.
+func (o *Output) Discard() {
+	o.mu.Lock()
+	_ = o.finish()
+	o.mu.Unlock()
+	_ = o.remove()
+}
+
+// finish closes the spool file for writing. It must be called with o.mu held.
+func (o *Output) finish() error {
+	if o.closed {
+		return o.err
+	}
+	o.closed = true
+	if o.file != nil {
+		if err := o.file.Close(); err != nil && o.err == nil {
+			o.err = fmt.Errorf("failed to close spool file: %w", err)
+		}
+	}
+	return o.err
+}
+
+// remove deletes the spool file.
+func (o *Output) remove() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.path == "" {
+		return nil
+	}
+	err := os.Remove(o.path)
+	o.path = ""
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Size returns the number of bytes spooled.
+func (o *Output) Size() int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.size
+}
+
+// Truncated reports whether the output exceeded the spool size limit, so that the spooled
+// content lacks its end.
+func (o *Output) Truncated() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.truncated
+}
+
+// ReadRange returns up to n bytes starting at offset. It returns io.EOF when offset is at
+// or beyond the end of the output.
+func (o *Output) ReadRange(offset int64, n int) ([]byte, error) {
+	o.mu.Lock()
+	path, size := o.path, o.size
+	o.mu.Unlock()
+	if offset < 0 || n < 0 {
+		return nil, fmt.Errorf("invalid range: offset %d, length %d", offset, n)
+	}
+	if offset >= size {
+		return nil, io.EOF
+	}
+	if path == "" {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, o.ID)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	buf := make([]byte, min(int64(n), size-offset))
+	read, err := file.ReadAt(buf, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return buf[:read], nil
+}
+
+// Tail returns the last n bytes of the output.
+func (o *Output) Tail(n int) ([]byte, error) {
+	size := o.Size()
+	if size == 0 {
+		return nil, nil
+	}
+	return o.ReadRange(max(0, size-int64(n)), n)
+}
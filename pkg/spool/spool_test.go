@@ -0,0 +1,95 @@
+package spool
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+func TestOutput_ReadRange(t *testing.T) {
+	dir := t.TempDir()
+	s := New(config.OutputSpoolConfig{Enabled: true, Dir: dir})
+
+	out := s.Create()
+	_, _ = out.Write([]byte("hello "))
+	_, _ = out.Write([]byte("world\n"))
+	assert.NoError(t, out.Keep())
+	assert.Equal(t, int64(12), out.Size())
+	assert.False(t, out.Truncated())
+
+	got, err := s.Get(out.ID)
+	assert.NoError(t, err)
+	data, err := got.ReadRange(6, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, "world\n", string(data))
+	data, err = got.ReadRange(0, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+	data, err = got.Tail(3)
+	assert.NoError(t, err)
+	assert.Equal(t, "ld\n", string(data))
+	_, err = got.ReadRange(12, 1)
+	assert.IsError(t, err, io.EOF)
+	_, err = got.ReadRange(-1, 1)
+	assert.Error(t, err)
+
+	// Closing the store removes its files
+	assert.NoError(t, s.Close())
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(entries))
+	_, err = s.Get(out.ID)
+	assert.IsError(t, err, ErrNotFound)
+}
+
+func TestOutput_Discard(t *testing.T) {
+	dir := t.TempDir()
+	s := New(config.OutputSpoolConfig{Enabled: true, Dir: dir})
+
+	out := s.Create()
+	_, _ = out.Write([]byte("data"))
+	out.Discard()
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(entries))
+	_, err = s.Get(out.ID)
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestOutput_MaxSize(t *testing.T) {
+	s := New(config.OutputSpoolConfig{Enabled: true, Dir: t.TempDir(), MaxSize: 1})
+
+	out := s.Create()
+	chunk := make([]byte, bytesPerMegabyte/2+1)
+	for range 3 {
+		n, err := out.Write(chunk)
+		assert.NoError(t, err)
+		assert.Equal(t, len(chunk), n)
+	}
+	assert.NoError(t, out.Keep())
+	assert.Equal(t, int64(bytesPerMegabyte), out.Size())
+	assert.True(t, out.Truncated())
+}
+
+func TestStore_Prune(t *testing.T) {
+	dir := t.TempDir()
+	s := New(config.OutputSpoolConfig{Enabled: true, Dir: dir})
+	s.retention = time.Millisecond
+
+	out := s.Create()
+	_, _ = out.Write([]byte("old"))
+	assert.NoError(t, out.Keep())
+	time.Sleep(5 * time.Millisecond)
+
+	_, err := s.Get(out.ID)
+	assert.IsError(t, err, ErrNotFound)
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(entries))
+}
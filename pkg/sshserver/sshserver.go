@@ -0,0 +1,638 @@
+// Package sshserver exposes the secure shell policy over the SSH protocol.
+// Every exec and shell request is run through the validator and SafeRunner
+// instead of a real shell.
+package sshserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+	"mvdan.cc/sh/v3/interp"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/alert"
+	"github.com/shimizu1995/secure-shell-server/pkg/approval"
+	"github.com/shimizu1995/secure-shell-server/pkg/audit"
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/history"
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/ratelimit"
+	"github.com/shimizu1995/secure-shell-server/pkg/recording"
+	"github.com/shimizu1995/secure-shell-server/pkg/runner"
+	"github.com/shimizu1995/secure-shell-server/pkg/session"
+	"github.com/shimizu1995/secure-shell-server/pkg/sftpserver"
+	"github.com/shimizu1995/secure-shell-server/pkg/snapshot"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+// shellPrompt is printed before each line in interactive shell sessions.
+const shellPrompt = "$ "
+
+// sessionIDBytes is the number of bytes of the SSH session ID that identify a connection.
+const sessionIDBytes = 8
+
+// Options configures a Server.
+type Options struct {
+	// Address is the TCP address to listen on, e.g. ":2222".
+	Address string
+	// HostKeyPath is the path to the PEM-encoded private host key.
+	HostKeyPath string
+	// AuthorizedKeysPath is the path to an OpenSSH authorized_keys file.
+	AuthorizedKeysPath string
+}
+
+// Server is an SSH server that executes commands under the configured policy.
+type Server struct {
+	config    *config.ShellCommandConfig
+	validator *validator.CommandValidator
+	logger    *logger.Logger
+	address   string
+	sshConfig *ssh.ServerConfig
+	limiter   *ratelimit.Limiter
+	// sessions limits the session channels of each authorized key
+	sessions *session.Manager
+	// tracerProvider, when set, receives the spans of every command
+	tracerProvider trace.TracerProvider
+	// approvals, when set, holds commands marked approvalRequired until an approver decides
+	approvals *approval.Queue
+	// history, when set, records every command under the caller's key fingerprint
+	history *history.History
+	// alerter raises alerts for honeypot deny rules and tracks frozen sessions by key fingerprint
+	alerter *alert.Alerter
+	// auditor sends every denied or executed command to the configured audit sinks
+	auditor *audit.Auditor
+
+	mu       sync.Mutex
+	listener net.Listener
+	wg       sync.WaitGroup
+}
+
+// New creates a Server, loading the host key and authorized keys from disk.
+func New(cfg *config.ShellCommandConfig, v *validator.CommandValidator, log *logger.Logger, opts Options) (*Server, error) {
+	hostKeyBytes, err := os.ReadFile(opts.HostKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read host key: %w", err)
+	}
+	hostKey, err := ssh.ParsePrivateKey(hostKeyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse host key: %w", err)
+	}
+
+	authorizedKeysBytes, err := os.ReadFile(opts.AuthorizedKeysPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read authorized keys: %w", err)
+	}
+	authorizedKeys, err := ParseAuthorizedKeys(authorizedKeysBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewWithKeys(cfg, v, log, opts.Address, hostKey, authorizedKeys), nil
+}
+
+// NewWithKeys creates a Server from an already loaded host key and set of authorized keys.
+func NewWithKeys(cfg *config.ShellCommandConfig, v *validator.CommandValidator, log *logger.Logger,
+	address string, hostKey ssh.Signer, authorizedKeys []ssh.PublicKey,
+) *Server {
+	allowed := make(map[string]bool, len(authorizedKeys))
+	for _, key := range authorizedKeys {
+		allowed[string(key.Marshal())] = true
+	}
+
+	sshConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if allowed[string(key.Marshal())] {
+				return &ssh.Permissions{
+					Extensions: map[string]string{"pubkey-fp": ssh.FingerprintSHA256(key)},
+				}, nil
+			}
+			log.LogErrorf("SSH authentication rejected for user %q from %s", meta.User(), meta.RemoteAddr())
+			return nil, fmt.Errorf("unknown public key for %q", meta.User())
+		},
+	}
+	sshConfig.AddHostKey(hostKey)
+
+	return &Server{
+		config:    cfg,
+		validator: v,
+		logger:    log,
+		address:   address,
+		sshConfig: sshConfig,
+		limiter:   ratelimit.NewForPolicy(cfg),
+		sessions:  session.New(cfg.Sessions),
+		alerter:   alert.New(cfg.Alerts),
+		auditor:   audit.New(cfg.Audit),
+	}
+}
+
+// ParseAuthorizedKeys parses the contents of an OpenSSH authorized_keys file.
+func ParseAuthorizedKeys(data []byte) ([]ssh.PublicKey, error) {
+	var keys []ssh.PublicKey
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse authorized keys line %d: %w", i+1, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no authorized keys found")
+	}
+	return keys, nil
+}
+
+// SetTracerProvider emits the spans of executed commands through tp instead of the global provider.
+// It must be called before the server starts.
+func (s *Server) SetTracerProvider(tp trace.TracerProvider) {
+	s.tracerProvider = tp
+}
+
+// SetApprovals holds commands marked approvalRequired in q. Without a queue, such commands are denied.
+// It must be called before the server starts.
+func (s *Server) SetApprovals(q *approval.Queue) {
+	s.approvals = q
+}
+
+// SetHistory records every executed or denied command in h. It must be called before the server starts.
+func (s *Server) SetHistory(h *history.History) {
+	s.history = h
+}
+
+// ListenAndServe listens on the configured address and serves connections.
+func (s *Server) ListenAndServe() error {
+	listener, err := net.Listen("tcp", s.address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.address, err)
+	}
+	return s.Serve(listener)
+}
+
+// Serve accepts connections on the listener until it is closed.
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	s.listener = listener
+	s.mu.Unlock()
+
+	s.logger.LogInfof("Starting SSH server on %s", listener.Addr())
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				s.wg.Wait()
+				return nil
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handleConn(conn)
+		}()
+	}
+}
+
+// Close stops accepting new connections.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+// handleConn performs the SSH handshake and serves session channels.
+func (s *Server) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	sshConn, chans, reqs, err := ssh.NewServerConn(conn, s.sshConfig)
+	if err != nil {
+		s.logger.LogErrorf("SSH handshake failed from %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	defer sshConn.Close()
+	s.logger.LogInfof("SSH connection from %s as %q (%s)",
+		sshConn.RemoteAddr(), sshConn.User(), sshConn.Permissions.Extensions["pubkey-fp"])
+
+	go ssh.DiscardRequests(reqs)
+
+	// Rate limits apply per authorized key rather than per connection
+	id := identity.Identity{
+		User:    sshConn.Permissions.Extensions["pubkey-fp"],
+		Agent:   string(sshConn.ClientVersion()),
+		Session: hex.EncodeToString(sshConn.SessionID()[:sessionIDBytes]),
+	}
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			s.logger.LogErrorf("Failed to accept SSH channel: %v", err)
+			continue
+		}
+		go s.handleSession(channel, requests, id)
+	}
+}
+
+// handleSession serves requests on a single session channel. The exec or shell request runs
+// in the background, so that the terminal can still be resized while it does.
+func (s *Server) handleSession(channel ssh.Channel, requests <-chan *ssh.Request, id identity.Identity) {
+	defer channel.Close()
+
+	// A session over its caller's limit still answers requests, so that the client sees why
+	// its command is refused
+	sess, sessErr := s.sessions.Open(id.Key())
+	defer sess.Close()
+	ctx, cancel := sess.Context(context.Background())
+	defer cancel()
+	// The session ends with its lifetime, stopping the commands running under ctx
+	stop := context.AfterFunc(ctx, func() {
+		_, _ = fmt.Fprintf(channel.Stderr(), "\r\nError: %v\r\n", context.Cause(ctx))
+		_ = channel.Close()
+	})
+	defer stop()
+
+	rec := s.startRecording(id.Key())
+	if rec != nil {
+		defer rec.Close()
+	}
+
+	var (
+		tty     *runner.Terminal
+		editor  *term.Terminal
+		running sync.WaitGroup
+		started bool
+	)
+	defer func() {
+		running.Wait()
+		if tty != nil {
+			tty.Close()
+		}
+	}()
+	finish := func(status uint32) {
+		sendExitStatus(channel, status)
+		_ = channel.Close()
+		running.Done()
+	}
+
+	for req := range requests {
+		switch req.Type {
+		case "exec":
+			command, ok := parseStringPayload(req.Payload)
+			ok = ok && !started
+			_ = req.Reply(ok, nil)
+			if !ok {
+				continue
+			}
+			started = true
+			running.Add(1)
+			go func() {
+				if sessErr != nil {
+					s.writeError(channel, tty, sessErr)
+					finish(1)
+					return
+				}
+				finish(s.runCommand(ctx, channel, rec, id, sess, tty, command, s.defaultWorkingDir()))
+			}()
+		case "shell":
+			_ = req.Reply(!started, nil)
+			if started {
+				continue
+			}
+			started = true
+			if tty != nil {
+				editor = newLineEditor(tty, channel)
+			}
+			running.Add(1)
+			go func() {
+				if sessErr != nil {
+					s.writeError(channel, tty, sessErr)
+					finish(1)
+					return
+				}
+				s.runShell(ctx, channel, rec, id, sess, tty, editor)
+				finish(0)
+			}()
+		case "subsystem":
+			name, ok := parseStringPayload(req.Payload)
+			ok = ok && name == "sftp" && s.config.SFTP.Enabled && !started && sessErr == nil
+			_ = req.Reply(ok, nil)
+			if !ok {
+				continue
+			}
+			started = true
+			running.Add(1)
+			go func() {
+				s.serveSFTP(channel, id)
+				finish(0)
+			}()
+		case "pty-req":
+			var ptyReq ptyRequest
+			ok := ssh.Unmarshal(req.Payload, &ptyReq) == nil && !started
+			_ = req.Reply(ok, nil)
+			if ok {
+				tty = runner.NewTerminal(channel, windowSize(ptyReq.Cols, ptyReq.Rows))
+				if ptyReq.Term != "" {
+					tty.Term = ptyReq.Term
+				}
+			}
+		case "window-change":
+			var change windowChange
+			if ssh.Unmarshal(req.Payload, &change) != nil || tty == nil {
+				continue
+			}
+			tty.Resize(windowSize(change.Cols, change.Rows))
+			if editor != nil {
+				_ = editor.SetSize(int(change.Cols), int(change.Rows))
+			}
+		default:
+			// Client environment variables are refused: commands always run with the
+			// server's environment
+			_ = req.Reply(false, nil)
+		}
+	}
+}
+
+// ptyRequest is the payload of a "pty-req" request (RFC 4254, section 6.2).
+type ptyRequest struct {
+	Term   string
+	Cols   uint32
+	Rows   uint32
+	Width  uint32
+	Height uint32
+	Modes  string
+}
+
+// windowChange is the payload of a "window-change" request (RFC 4254, section 6.7).
+type windowChange struct {
+	Cols   uint32
+	Rows   uint32
+	Width  uint32
+	Height uint32
+}
+
+// windowSize converts a size in an SSH request to a terminal size.
+func windowSize(cols, rows uint32) runner.WindowSize {
+	return runner.WindowSize{Rows: uint16(min(rows, math.MaxUint16)), Cols: uint16(min(cols, math.MaxUint16))} //nolint:gosec // clamped above
+}
+
+// newLineEditor returns an editor of the lines typed in tty, which echoes them to channel.
+func newLineEditor(tty *runner.Terminal, channel ssh.Channel) *term.Terminal {
+	editor := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{tty, channel}, shellPrompt)
+	size := tty.Size()
+	_ = editor.SetSize(int(size.Cols), int(size.Rows))
+	return editor
+}
+
+// runShell executes newline-separated commands read from the channel, tracking cd between lines.
+// With a terminal, lines are edited in editor and commands marked allowPty run in tty.
+// The shell ends once the session may run no more commands.
+func (s *Server) runShell(ctx context.Context, channel ssh.Channel, rec *recording.Recorder, id identity.Identity, sess *session.Session,
+	tty *runner.Terminal, editor *term.Terminal,
+) {
+	ctx = identity.WithIdentity(ctx, id)
+	workingDir := s.defaultWorkingDir()
+
+	readLine := newLineReader(channel)
+	if editor != nil {
+		readLine = editor.ReadLine
+	} else {
+		_, _ = io.WriteString(channel, shellPrompt)
+	}
+	for {
+		line, err := readLine()
+		if err != nil {
+			return
+		}
+		switch line = strings.TrimSpace(line); line {
+		case "":
+		case "exit", "logout":
+			return
+		default:
+			if err := sess.Begin(); err != nil {
+				s.writeError(channel, tty, err)
+				return
+			}
+			release, err := s.limiter.Acquire(id.Key())
+			if err != nil {
+				s.writeError(channel, tty, err)
+				break
+			}
+			r := s.newRunner(channel, rec, id, tty)
+			result := s.execute(ctx, r, tty, line, workingDir)
+			release()
+			if result.Err != nil {
+				s.writeError(channel, tty, result.Err)
+			}
+			s.discardSnapshot(channel, tty, result.Snapshot)
+			if result.NewWorkDir != "" {
+				workingDir = result.NewWorkDir
+			}
+		}
+		if editor == nil {
+			_, _ = io.WriteString(channel, shellPrompt)
+		}
+	}
+}
+
+// newLineReader returns a function reading the lines of r.
+func newLineReader(r io.Reader) func() (string, error) {
+	scanner := bufio.NewScanner(r)
+	return func() (string, error) {
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return "", err
+			}
+			return "", io.EOF
+		}
+		return scanner.Text(), nil
+	}
+}
+
+// execute runs command with r, in tty if the client requested a terminal.
+func (s *Server) execute(ctx context.Context, r *runner.SafeRunner, tty *runner.Terminal, command, workingDir string) runner.RunResult {
+	if tty == nil {
+		return r.RunCommand(ctx, command, workingDir)
+	}
+	return r.RunInteractive(ctx, command, tty, runner.WithWorkdir(workingDir))
+}
+
+// runCommand executes a single command and returns its exit status.
+func (s *Server) runCommand(ctx context.Context, channel ssh.Channel, rec *recording.Recorder, id identity.Identity, sess *session.Session,
+	tty *runner.Terminal, command, workingDir string,
+) uint32 {
+	s.logger.LogInfof("SSH exec: %s in directory: %s", command, workingDir)
+
+	ctx = identity.WithIdentity(ctx, id)
+	if err := sess.Begin(); err != nil {
+		s.writeError(channel, tty, err)
+		return 1
+	}
+	release, err := s.limiter.Acquire(id.Key())
+	if err != nil {
+		s.writeError(channel, tty, err)
+		return 1
+	}
+	defer release()
+
+	r := s.newRunner(channel, rec, id, tty)
+	result := s.execute(ctx, r, tty, command, workingDir)
+	if result.Err != nil {
+		s.writeError(channel, tty, result.Err)
+	}
+	s.discardSnapshot(channel, tty, result.Snapshot)
+	return uint32(runner.ExitCode(result.Err)) //nolint:gosec // exit codes are small
+}
+
+// discardSnapshot discards the changes a command made in its snapshot, listing them on the
+// channel's stderr. SSH clients have no way to commit snapshots, so with snapshot enabled
+// commands are dry runs.
+func (s *Server) discardSnapshot(channel ssh.Channel, tty *runner.Terminal, snap *snapshot.Snapshot) {
+	if snap == nil {
+		return
+	}
+	if changes, err := snap.Changes(); err == nil {
+		stderr := stderrOf(channel, tty)
+		_, _ = io.WriteString(stderr, "Changes discarded (snapshots cannot be committed over SSH):\n")
+		for _, c := range changes {
+			_, _ = fmt.Fprintf(stderr, "  %s %s\n", c.Kind, c.Path)
+		}
+	}
+	if err := snap.Discard(); err != nil {
+		s.logger.LogErrorf("Failed to discard snapshot %s: %v", snap.ID, err)
+	}
+}
+
+// serveSFTP serves the "sftp" subsystem on the channel, with the paths of every operation
+// checked against the policy of the caller id.
+func (s *Server) serveSFTP(channel ssh.Channel, id identity.Identity) {
+	s.logger.LogInfof("SSH sftp session of %s", id)
+	if err := sftpserver.New(s.config, s.validator, s.logger, id).Serve(channel); err != nil {
+		s.logger.LogErrorf("SFTP session of %s failed: %v", id, err)
+	}
+}
+
+// newRunner creates a SafeRunner for the caller id writing to the channel and, if recording, to rec.
+func (s *Server) newRunner(channel ssh.Channel, rec *recording.Recorder, id identity.Identity, tty *runner.Terminal) *runner.SafeRunner {
+	r := runner.New(s.config, s.validator, s.logger)
+	if s.tracerProvider != nil {
+		r.SetTracerProvider(s.tracerProvider)
+	}
+	r.SetApprovals(s.approvals)
+	r.SetHistory(s.history, id.Key())
+	r.SetAlerter(s.alerter)
+	r.SetAuditor(s.auditor)
+	if rec != nil {
+		r.SetRecorder(rec)
+	}
+	r.SetOutputs(stdoutOf(channel, tty), stderrOf(channel, tty))
+	return r
+}
+
+// startRecording starts recording a session if recordingDir is configured.
+func (s *Server) startRecording(caller string) *recording.Recorder {
+	if s.config.RecordingDir == "" {
+		return nil
+	}
+	rec, err := recording.Create(s.config.RecordingDir, "ssh-"+caller, "SSH session "+caller)
+	if err != nil {
+		s.logger.LogErrorf("Failed to start session recording: %v", err)
+		return nil
+	}
+	return rec
+}
+
+// writeError reports an execution error on the channel's stderr stream.
+func (s *Server) writeError(channel ssh.Channel, tty *runner.Terminal, err error) {
+	if _, ok := interp.IsExitStatus(err); ok {
+		return
+	}
+	_, _ = fmt.Fprintf(stderrOf(channel, tty), "Error: %v\n", err)
+}
+
+// stdoutOf returns the writer of standard output to the client. A client with a terminal
+// expects the line endings a terminal driver produces.
+func stdoutOf(channel ssh.Channel, tty *runner.Terminal) io.Writer {
+	if tty == nil {
+		return channel
+	}
+	return &crlfWriter{w: channel}
+}
+
+// stderrOf returns the writer of standard error to the client, like stdoutOf.
+func stderrOf(channel ssh.Channel, tty *runner.Terminal) io.Writer {
+	if tty == nil {
+		return channel.Stderr()
+	}
+	return &crlfWriter{w: channel.Stderr()}
+}
+
+// crlfWriter writes "\r\n" for every "\n" that does not already follow "\r", as output
+// written to a terminal in raw mode needs.
+type crlfWriter struct {
+	w  io.Writer
+	cr bool
+}
+
+// Write implements io.Writer.
+func (c *crlfWriter) Write(p []byte) (int, error) {
+	var out []byte
+	for _, b := range p {
+		if b == '\n' && !c.cr {
+			out = append(out, '\r')
+		}
+		out = append(out, b)
+		c.cr = b == '\r'
+	}
+	if _, err := c.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// defaultWorkingDir returns the directory sessions start in.
+func (s *Server) defaultWorkingDir() string {
+	return s.config.DefaultDirectory()
+}
+
+// parseStringPayload decodes an SSH string (uint32 length followed by bytes).
+func parseStringPayload(payload []byte) (string, bool) {
+	const lengthSize = 4
+	if len(payload) < lengthSize {
+		return "", false
+	}
+	length := binary.BigEndian.Uint32(payload)
+	if uint64(len(payload)-lengthSize) < uint64(length) {
+		return "", false
+	}
+	return string(payload[lengthSize : lengthSize+int(length)]), true
+}
+
+// sendExitStatus sends the exit-status request that tells the client how the command ended.
+func sendExitStatus(channel ssh.Channel, status uint32) {
+	payload := ssh.Marshal(struct{ Status uint32 }{status})
+	_, _ = channel.SendRequest("exit-status", false, payload)
+}
@@ -0,0 +1,247 @@
+package sshserver
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/validator"
+)
+
+func newSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	assert.NoError(t, err)
+	return signer
+}
+
+// startTestServer starts a server on a random port that trusts clientKey.
+func startTestServer(t *testing.T, clientKey ssh.PublicKey) string {
+	t.Helper()
+	cfg := config.NewDefaultConfig()
+	cfg.AllowedDirectories = []string{t.TempDir()}
+	return startTestServerWithConfig(t, clientKey, cfg)
+}
+
+// startTestServerWithConfig starts a server enforcing cfg on a random port that trusts clientKey.
+func startTestServerWithConfig(t *testing.T, clientKey ssh.PublicKey, cfg *config.ShellCommandConfig) string {
+	t.Helper()
+	log := logger.New()
+
+	srv := NewWithKeys(cfg, validator.New(cfg, log), log, "", newSigner(t), []ssh.PublicKey{clientKey})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go func() { _ = srv.Serve(listener) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	return listener.Addr().String()
+}
+
+func dial(t *testing.T, addr string, signer ssh.Signer) (*ssh.Client, error) {
+	t.Helper()
+	return ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "agent",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(), //nolint:gosec // test server uses a throwaway host key
+	})
+}
+
+// runExec executes a command over a new session and returns its stdout, stderr, and exit status.
+func runExec(t *testing.T, client *ssh.Client, command string) (string, string, int) {
+	t.Helper()
+	session, err := client.NewSession()
+	assert.NoError(t, err)
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+
+	err = session.Run(command)
+	if err == nil {
+		return stdout.String(), stderr.String(), 0
+	}
+	var exitErr *ssh.ExitError
+	assert.True(t, errors.As(err, &exitErr), "unexpected error: %v", err)
+	return stdout.String(), stderr.String(), exitErr.ExitStatus()
+}
+
+func TestServer_ExecAllowed(t *testing.T) {
+	clientKey := newSigner(t)
+	addr := startTestServer(t, clientKey.PublicKey())
+
+	client, err := dial(t, addr, clientKey)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	stdout, _, status := runExec(t, client, "echo hello")
+	assert.Equal(t, 0, status)
+	assert.Equal(t, "hello\n", stdout)
+}
+
+func TestServer_ExecDenied(t *testing.T) {
+	clientKey := newSigner(t)
+	addr := startTestServer(t, clientKey.PublicKey())
+
+	client, err := dial(t, addr, clientKey)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	_, stderr, status := runExec(t, client, "rm -rf /")
+	assert.NotEqual(t, 0, status)
+	assert.Contains(t, stderr, `command "rm" is denied`)
+}
+
+func TestServer_Shell(t *testing.T) {
+	clientKey := newSigner(t)
+	addr := startTestServer(t, clientKey.PublicKey())
+
+	client, err := dial(t, addr, clientKey)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	assert.NoError(t, err)
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	session.Stdin = bytes.NewBufferString("echo one\nrm x\necho two\nexit\n")
+
+	assert.NoError(t, session.Shell())
+	assert.NoError(t, session.Wait())
+	assert.Contains(t, stdout.String(), "one\n")
+	assert.Contains(t, stdout.String(), "two\n")
+	assert.Contains(t, stderr.String(), `command "rm" is denied`)
+}
+
+func TestServer_Pty(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("pseudo-terminals are not supported on Windows")
+	}
+	clientKey := newSigner(t)
+	cfg := config.NewDefaultConfig()
+	cfg.AllowedDirectories = []string{t.TempDir()}
+	for i, allowed := range cfg.AllowCommands {
+		if allowed.Command == "cat" {
+			cfg.AllowCommands[i].AllowPty = true
+		}
+	}
+	addr := startTestServerWithConfig(t, clientKey.PublicKey(), cfg)
+
+	client, err := dial(t, addr, clientKey)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	assert.NoError(t, err)
+	defer session.Close()
+
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+	session.Stdin = bytes.NewBufferString("typed\n\x04")
+	assert.NoError(t, session.RequestPty("vt100", 24, 80, ssh.TerminalModes{}))
+	assert.NoError(t, session.WindowChange(40, 120))
+	assert.NoError(t, session.Run("cat; echo done"))
+
+	// The input is echoed by the terminal and then printed by cat
+	assert.Equal(t, "typed\r\ntyped\r\ndone\r\n", stdout.String())
+}
+
+func TestServer_ShellPty(t *testing.T) {
+	clientKey := newSigner(t)
+	addr := startTestServer(t, clientKey.PublicKey())
+
+	client, err := dial(t, addr, clientKey)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	assert.NoError(t, err)
+	defer session.Close()
+
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+	session.Stdin = bytes.NewBufferString("echo one\rrm x\rexit\r")
+	assert.NoError(t, session.RequestPty("xterm", 24, 80, ssh.TerminalModes{}))
+	assert.NoError(t, session.Shell())
+	assert.NoError(t, session.Wait())
+
+	// Lines are echoed by the line editor; output uses terminal line endings
+	assert.Contains(t, stdout.String(), "$ echo one\r\none\r\n")
+}
+
+func TestServer_SFTPSubsystem(t *testing.T) {
+	clientKey := newSigner(t)
+	cfg := config.NewDefaultConfig()
+	cfg.AllowedDirectories = []string{t.TempDir()}
+	client, err := dial(t, startTestServerWithConfig(t, clientKey.PublicKey(), cfg), clientKey)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	// The subsystem is refused unless enabled
+	session, err := client.NewSession()
+	assert.NoError(t, err)
+	assert.Error(t, session.RequestSubsystem("sftp"))
+	session.Close()
+
+	cfg.SFTP.Enabled = true
+	client, err = dial(t, startTestServerWithConfig(t, clientKey.PublicKey(), cfg), clientKey)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	session, err = client.NewSession()
+	assert.NoError(t, err)
+	defer session.Close()
+	assert.Error(t, session.RequestSubsystem("scp"))
+	stdin, err := session.StdinPipe()
+	assert.NoError(t, err)
+	stdout, err := session.StdoutPipe()
+	assert.NoError(t, err)
+	assert.NoError(t, session.RequestSubsystem("sftp"))
+
+	// SSH_FXP_INIT of version 3 is answered by SSH_FXP_VERSION
+	_, err = stdin.Write([]byte{0, 0, 0, 5, 1, 0, 0, 0, 3})
+	assert.NoError(t, err)
+	reply := make([]byte, 9)
+	_, err = io.ReadFull(stdout, reply)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 5, 2, 0, 0, 0, 3}, reply)
+}
+
+func TestServer_RejectsUnknownKey(t *testing.T) {
+	addr := startTestServer(t, newSigner(t).PublicKey())
+
+	_, err := dial(t, addr, newSigner(t))
+	assert.Error(t, err)
+}
+
+func TestParseAuthorizedKeys(t *testing.T) {
+	key := newSigner(t).PublicKey()
+	data := "# comment\n\n" + string(ssh.MarshalAuthorizedKey(key))
+
+	keys, err := ParseAuthorizedKeys([]byte(data))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(keys))
+	assert.Equal(t, key.Marshal(), keys[0].Marshal())
+
+	_, err = ParseAuthorizedKeys([]byte("# only comments\n"))
+	assert.Error(t, err)
+
+	_, err = ParseAuthorizedKeys([]byte("not a key\n"))
+	assert.Error(t, err)
+}
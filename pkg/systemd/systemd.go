@@ -0,0 +1,106 @@
+// Package systemd lets a server run as a systemd service: it takes over the sockets systemd
+// passes with socket activation and tells the service manager when the server is ready,
+// reloading, and stopping.
+//
+// Both read their environment variables once and remove them, so that the commands the
+// server runs neither inherit the sockets nor can send notifications on its behalf.
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// listenFDsStart is the first file descriptor passed by socket activation.
+const listenFDsStart = 3
+
+// Listeners returns the listening sockets passed to the process by socket activation, in the
+// order of the ListenStream= lines of the socket unit, or none if the process was not
+// socket-activated.
+func Listeners() ([]net.Listener, error) {
+	pid, fds, names := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	// The variables are meant for another process when they name a different PID
+	if pid == "" || pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+
+	listeners := make([]net.Listener, 0, n)
+	for fd := listenFDsStart; fd < listenFDsStart+n; fd++ {
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		// FileListener duplicates the descriptor with close-on-exec set; the original is
+		// closed so that it is not inherited by commands
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("file descriptor %d (%s) is not a listening socket: %w", fd, names, err)
+		}
+		listeners = append(listeners, ln)
+	}
+	return listeners, nil
+}
+
+// Notification states sent with Notifier.Notify.
+const (
+	// Ready tells systemd that the service has started or finished reloading
+	Ready = "READY=1"
+	// Stopping tells systemd that the service is shutting down
+	Stopping = "STOPPING=1"
+)
+
+// Notifier sends state notifications to the service manager (sd_notify). A nil Notifier,
+// returned when the process was not started by systemd with Type=notify, sends nothing.
+type Notifier struct {
+	addr *net.UnixAddr
+}
+
+// NewNotifier returns a Notifier for the socket in NOTIFY_SOCKET, or nil if it is not set.
+func NewNotifier() *Notifier {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	os.Unsetenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// A leading @ names a socket in the abstract namespace
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	return &Notifier{addr: &net.UnixAddr{Name: socket, Net: "unixgram"}}
+}
+
+// Notify sends state, one or more newline-separated VARIABLE=value assignments such as Ready.
+func (n *Notifier) Notify(state string) error {
+	if n == nil {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, n.addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the notification socket: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("failed to notify systemd: %w", err)
+	}
+	return nil
+}
+
+// Reloading returns the state telling systemd that the service is reloading its
+// configuration, with the timestamp Type=notify-reload services must send along.
+func Reloading() string {
+	if usec := monotonicUsec(); usec > 0 {
+		return "RELOADING=1\nMONOTONIC_USEC=" + strconv.FormatInt(usec, 10)
+	}
+	return "RELOADING=1"
+}
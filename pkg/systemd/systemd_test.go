@@ -0,0 +1,64 @@
+package systemd_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/systemd"
+)
+
+func TestListenersForAnotherProcess(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+
+	listeners, err := systemd.Listeners()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(listeners))
+	_, ok := os.LookupEnv("LISTEN_FDS")
+	assert.False(t, ok)
+}
+
+func TestListenersInvalidCount(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "many")
+
+	_, err := systemd.Listeners()
+	assert.Error(t, err)
+}
+
+func TestNotifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	assert.NoError(t, err)
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	n := systemd.NewNotifier()
+	_, ok := os.LookupEnv("NOTIFY_SOCKET")
+	assert.False(t, ok)
+
+	assert.NoError(t, n.Notify(systemd.Ready))
+	buf := make([]byte, 256)
+	size, err := conn.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "READY=1", string(buf[:size]))
+
+	assert.NoError(t, n.Notify(systemd.Reloading()))
+	size, err = conn.Read(buf)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(buf[:size]), "RELOADING=1"))
+}
+
+func TestNotifierWithoutSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+
+	n := systemd.NewNotifier()
+	assert.Zero(t, n)
+	assert.NoError(t, n.Notify(systemd.Ready))
+}
@@ -0,0 +1,264 @@
+// Package tenant maps API tokens to the policies of the teams that share one server. Tenants are
+// loaded from a directory and reloaded when it changes, so that teams can be added, changed, and
+// removed without a restart.
+//
+// Every tenant has two files in the directory: NAME.json, its configuration, and NAME.tokens, the
+// hex-encoded SHA-256 hashes of its API tokens, one per line. Blank lines and lines starting
+// with "#" are ignored. A hash is printed by `printf %s "$TOKEN" | sha256sum`.
+package tenant
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+// File name extensions of the configuration and token files of a tenant.
+const (
+	configExt = ".json"
+	tokensExt = ".tokens"
+)
+
+// namePattern matches valid tenant names.
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Tenant is a team served under a policy of its own.
+type Tenant struct {
+	// Name is the base name of the tenant's files.
+	Name string
+	// Config is the tenant's policy.
+	Config *config.ShellCommandConfig
+
+	// digest identifies the contents of the tenant's files, so that a reload keeps unchanged tenants
+	digest [sha256.Size]byte
+	// tokens are the SHA-256 hashes of the tenant's API tokens
+	tokens [][sha256.Size]byte
+}
+
+// Registry holds the tenants of a directory and authenticates their API tokens.
+type Registry struct {
+	dir string
+
+	mu      sync.RWMutex
+	tenants map[string]*Tenant
+	tokens  map[[sha256.Size]byte]*Tenant
+	// lastErr is the error of the last reload
+	lastErr error
+}
+
+// Load reads the tenants in dir. It fails if any tenant cannot be loaded, so that mistakes are
+// reported at startup.
+func Load(dir string) (*Registry, error) {
+	r := &Registry{dir: dir}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Authenticate returns the tenant an API token belongs to.
+func (r *Registry) Authenticate(token string) (*Tenant, bool) {
+	if token == "" {
+		return nil, false
+	}
+	sum := sha256.Sum256([]byte(token))
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.tokens[sum]
+	return t, ok
+}
+
+// Tenants returns the tenants sorted by name.
+func (r *Registry) Tenants() []*Tenant {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	tenants := make([]*Tenant, 0, len(r.tenants))
+	for _, t := range r.tenants {
+		tenants = append(tenants, t)
+	}
+	slices.SortFunc(tenants, func(a, b *Tenant) int { return strings.Compare(a.Name, b.Name) })
+	return tenants
+}
+
+// Reload reads the directory again and reports whether any tenant was added, changed, or removed.
+// Tenants whose files are unchanged keep their *Tenant, so callers may key state by it. If any
+// tenant cannot be loaded, the previous tenants stay in effect.
+func (r *Registry) Reload() (bool, error) {
+	changed, err := r.reload()
+	r.mu.Lock()
+	r.lastErr = err
+	r.mu.Unlock()
+	return changed, err
+}
+
+// LastError returns the error of the last reload, or nil if it succeeded.
+func (r *Registry) LastError() error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.lastErr
+}
+
+// reload replaces the tenants with those read from the directory if any changed.
+func (r *Registry) reload() (bool, error) {
+	files, err := readDir(r.dir)
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.RLock()
+	previous := r.tenants
+	r.mu.RUnlock()
+
+	tenants := make(map[string]*Tenant, len(files))
+	tokens := make(map[[sha256.Size]byte]*Tenant)
+	changed := len(files) != len(previous)
+	for name, f := range files {
+		t, ok := previous[name]
+		if !ok || t.digest != f.digest {
+			if t, err = f.load(name); err != nil {
+				return false, err
+			}
+			changed = true
+		}
+		tenants[name] = t
+		for _, sum := range t.tokens {
+			if other, ok := tokens[sum]; ok {
+				return false, fmt.Errorf("tenants %q and %q share an API token", other.Name, name)
+			}
+			tokens[sum] = t
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+
+	r.mu.Lock()
+	r.tenants, r.tokens = tenants, tokens
+	r.mu.Unlock()
+	return true, nil
+}
+
+// Watch reloads the directory every interval until ctx is done, calling onChange with the tenants
+// whenever they changed. Errors are passed to onError and the previous tenants stay in effect.
+func (r *Registry) Watch(ctx context.Context, interval time.Duration, onChange func([]*Tenant), onError func(error)) error {
+	if interval <= 0 {
+		return errors.New("reload interval must be positive")
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			changed, err := r.Reload()
+			switch {
+			case err != nil:
+				if onError != nil {
+					onError(err)
+				}
+			case changed:
+				onChange(r.Tenants())
+			}
+		}
+	}
+}
+
+// tenantFiles holds the contents of a tenant's files.
+type tenantFiles struct {
+	configPath string
+	config     []byte
+	tokens     []byte
+	digest     [sha256.Size]byte
+}
+
+// readDir reads the files of every tenant in dir.
+func readDir(dir string) (map[string]*tenantFiles, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenants directory: %w", err)
+	}
+
+	files := make(map[string]*tenantFiles)
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), configExt)
+		if !ok || entry.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if !namePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid tenant name %q", name)
+		}
+
+		f := &tenantFiles{configPath: filepath.Join(dir, entry.Name())}
+		if f.config, err = os.ReadFile(f.configPath); err != nil {
+			return nil, fmt.Errorf("tenant %q: failed to read config file: %w", name, err)
+		}
+		if f.tokens, err = os.ReadFile(filepath.Join(dir, name+tokensExt)); err != nil {
+			return nil, fmt.Errorf("tenant %q: failed to read tokens file: %w", name, err)
+		}
+		h := sha256.New()
+		h.Write(f.config)
+		h.Write([]byte{0})
+		h.Write(f.tokens)
+		copy(f.digest[:], h.Sum(nil))
+		files[name] = f
+	}
+	return files, nil
+}
+
+// load parses the files of the tenant name.
+func (f *tenantFiles) load(name string) (*Tenant, error) {
+	cfg, err := config.Parse(f.config)
+	if err != nil {
+		return nil, fmt.Errorf("tenant %q: failed to decode config file: %w", name, err)
+	}
+	cfg.SetRuleSource(f.configPath)
+
+	tokens, err := parseTokens(f.tokens)
+	if err != nil {
+		return nil, fmt.Errorf("tenant %q: %w", name, err)
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("tenant %q has no API tokens", name)
+	}
+	return &Tenant{Name: name, Config: cfg, digest: f.digest, tokens: tokens}, nil
+}
+
+// parseTokens parses a tokens file.
+func parseTokens(data []byte) ([][sha256.Size]byte, error) {
+	var tokens [][sha256.Size]byte
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		// Accept the output of sha256sum, which follows the hash with the file name
+		hash, _, _ := strings.Cut(text, " ")
+		var sum [sha256.Size]byte
+		if len(hash) != hex.EncodedLen(sha256.Size) {
+			return nil, fmt.Errorf("tokens file line %d: not a hex-encoded SHA-256 hash", line)
+		}
+		if _, err := hex.Decode(sum[:], []byte(hash)); err != nil {
+			return nil, fmt.Errorf("tokens file line %d: not a hex-encoded SHA-256 hash", line)
+		}
+		tokens = append(tokens, sum)
+	}
+	return tokens, scanner.Err()
+}
@@ -0,0 +1,177 @@
+package tenant
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTenant writes the configuration and tokens files of a tenant allowing cmd in dir.
+func writeTenant(t *testing.T, dir, name, cmd string, tokens ...string) {
+	t.Helper()
+	cfg := `{"allowedDirectories": ["` + t.TempDir() + `"], "allowCommands": ["` + cmd + `"], "denyCommands": []}`
+	if err := os.WriteFile(filepath.Join(dir, name+configExt), []byte(cfg), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	lines := []string{"# API tokens of " + name}
+	for _, token := range tokens {
+		sum := sha256.Sum256([]byte(token))
+		lines = append(lines, hex.EncodeToString(sum[:])+"  -")
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+tokensExt), []byte(strings.Join(lines, "\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadAndAuthenticate(t *testing.T) {
+	dir := t.TempDir()
+	writeTenant(t, dir, "alpha", "ls", "token-a1", "token-a2")
+	writeTenant(t, dir, "beta", "git", "token-b")
+	// Files of other kinds are ignored
+	if err := os.WriteFile(filepath.Join(dir, "README"), []byte("tenants"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got := len(r.Tenants()); got != 2 {
+		t.Fatalf("got %d tenants, want 2", got)
+	}
+
+	for token, want := range map[string]string{"token-a1": "alpha", "token-a2": "alpha", "token-b": "beta"} {
+		ten, ok := r.Authenticate(token)
+		if !ok || ten.Name != want {
+			t.Errorf("Authenticate(%q) = %v, %v; want tenant %q", token, ten, ok, want)
+		}
+	}
+	if ten, _ := r.Authenticate("token-b"); ten.Config.AllowCommands[0].Command != "git" {
+		t.Errorf("tenant beta has the wrong policy: %+v", ten.Config.AllowCommands)
+	}
+	for _, token := range []string{"", "unknown"} {
+		if _, ok := r.Authenticate(token); ok {
+			t.Errorf("Authenticate(%q) succeeded", token)
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, dir string)
+		want  string
+	}{
+		{
+			name: "missing tokens file",
+			setup: func(t *testing.T, dir string) {
+				writeTenant(t, dir, "alpha", "ls", "token")
+				os.Remove(filepath.Join(dir, "alpha"+tokensExt))
+			},
+			want: "failed to read tokens file",
+		},
+		{
+			name: "no tokens",
+			setup: func(t *testing.T, dir string) {
+				writeTenant(t, dir, "alpha", "ls")
+			},
+			want: `tenant "alpha" has no API tokens`,
+		},
+		{
+			name: "plain token",
+			setup: func(t *testing.T, dir string) {
+				writeTenant(t, dir, "alpha", "ls", "token")
+				os.WriteFile(filepath.Join(dir, "alpha"+tokensExt), []byte("token\n"), 0o600)
+			},
+			want: "tokens file line 1: not a hex-encoded SHA-256 hash",
+		},
+		{
+			name: "shared token",
+			setup: func(t *testing.T, dir string) {
+				writeTenant(t, dir, "alpha", "ls", "token")
+				writeTenant(t, dir, "beta", "ls", "token")
+			},
+			want: "share an API token",
+		},
+		{
+			name: "invalid config",
+			setup: func(t *testing.T, dir string) {
+				writeTenant(t, dir, "alpha", "ls", "token")
+				os.WriteFile(filepath.Join(dir, "alpha"+configExt), []byte(`{"unknownField": true}`), 0o600)
+			},
+			want: `tenant "alpha": failed to decode config file`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tc.setup(t, dir)
+			_, err := Load(dir)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("Load() error = %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	writeTenant(t, dir, "alpha", "ls", "token-a")
+	writeTenant(t, dir, "beta", "ls", "token-b")
+	r, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	alpha, _ := r.Authenticate("token-a")
+	beta, _ := r.Authenticate("token-b")
+
+	changed, err := r.Reload()
+	if err != nil || changed {
+		t.Fatalf("Reload() without changes = %v, %v", changed, err)
+	}
+
+	// Change beta's policy and add gamma
+	writeTenant(t, dir, "beta", "git", "token-b")
+	writeTenant(t, dir, "gamma", "ls", "token-c")
+	changed, err = r.Reload()
+	if err != nil || !changed {
+		t.Fatalf("Reload() = %v, %v; want a change", changed, err)
+	}
+	if got, _ := r.Authenticate("token-a"); got != alpha {
+		t.Error("unchanged tenant alpha was replaced")
+	}
+	if got, _ := r.Authenticate("token-b"); got == beta || got.Config.AllowCommands[0].Command != "git" {
+		t.Error("changed tenant beta was not reloaded")
+	}
+	if _, ok := r.Authenticate("token-c"); !ok {
+		t.Error("new tenant gamma was not loaded")
+	}
+
+	// A broken tenant keeps the previous tenants in effect
+	os.WriteFile(filepath.Join(dir, "gamma"+tokensExt), []byte("not a hash\n"), 0o600)
+	if _, err := r.Reload(); err == nil {
+		t.Fatal("Reload() succeeded with a broken tokens file")
+	}
+	if r.LastError() == nil {
+		t.Error("LastError() = nil after a failed reload")
+	}
+	if _, ok := r.Authenticate("token-c"); !ok {
+		t.Error("previous tenants were dropped after a failed reload")
+	}
+
+	// Removing a tenant revokes its tokens
+	os.Remove(filepath.Join(dir, "gamma"+configExt))
+	if changed, err := r.Reload(); err != nil || !changed {
+		t.Fatalf("Reload() = %v, %v; want a change", changed, err)
+	}
+	if err := r.LastError(); err != nil {
+		t.Errorf("LastError() = %v after a successful reload", err)
+	}
+	if _, ok := r.Authenticate("token-c"); ok {
+		t.Error("removed tenant gamma still authenticates")
+	}
+}
@@ -0,0 +1,294 @@
+// Package trash keeps the files that rm and unlink delete in a quarantine directory per
+// session, from which they can be restored until they expire. Each deleted file or directory
+// is kept as <dir>/<session>/<id>/data, next to item.json describing where it came from, so
+// stores with the same directory share their items.
+package trash
+
+import (
+	"cmp"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+const (
+	// defaultDirName is the name of the trash in the system temporary directory.
+	defaultDirName = "secure-shell-trash"
+	// itemFile and dataName are the names of the description and the deleted file of an item.
+	itemFile = "item.json"
+	dataName = "data"
+	// idBytes is the number of random bytes in an item ID.
+	idBytes = 8
+	// sessionBytes is the number of bytes of the session hash naming its directory.
+	sessionBytes = 16
+)
+
+var (
+	// ErrNotFound is returned for an item ID that is unknown, restored, or purged.
+	ErrNotFound = errors.New("trash item not found")
+	// ErrExists is returned when restoring an item whose path has been taken by another file.
+	ErrExists = errors.New("restore target already exists")
+)
+
+// Item is a file or directory in the trash.
+type Item struct {
+	// ID is the handle by which the item is restored.
+	ID string `json:"id"`
+	// Session is the session that deleted the item.
+	Session string `json:"session"`
+	// Path is the absolute path the item was deleted from.
+	Path string `json:"path"`
+	// Dir is true when the item is a directory.
+	Dir bool `json:"dir,omitempty"`
+	// Deleted is when the item was moved to the trash.
+	Deleted time.Time `json:"deleted"`
+}
+
+// Store moves deleted files into the trash, restores them, and purges them once they are
+// older than the TTL.
+type Store struct {
+	dir string
+	ttl time.Duration
+	now func() time.Time
+
+	// mu serializes changes to the trash, so that an item is not restored while it is purged
+	mu sync.Mutex
+}
+
+// New creates a Store configured by cfg.
+func New(cfg config.TrashConfig) *Store {
+	dir := cfg.Dir
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), defaultDirName)
+	}
+	ttl := cfg.TTL
+	if ttl == 0 {
+		ttl = config.DefaultTrashTTL
+	}
+	return &Store{dir: dir, ttl: time.Duration(ttl) * time.Second, now: time.Now}
+}
+
+// Delete moves the file or directory at path, which must be absolute, into the trash of session.
+func (s *Store) Delete(session, path string) (Item, error) {
+	s.Purge()
+	info, err := os.Lstat(path)
+	if err != nil {
+		return Item{}, err
+	}
+
+	b := make([]byte, idBytes)
+	_, _ = rand.Read(b)
+	item := Item{ID: hex.EncodeToString(b), Session: session, Path: path, Dir: info.IsDir(), Deleted: s.now()}
+	itemDir := s.itemDir(session, item.ID)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(itemDir, 0o700); err != nil {
+		return Item{}, fmt.Errorf("failed to create trash: %w", err)
+	}
+	data, err := json.Marshal(item)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(itemDir, itemFile), data, 0o600)
+	}
+	if err == nil {
+		err = move(path, filepath.Join(itemDir, dataName))
+	}
+	if err != nil {
+		_ = os.RemoveAll(itemDir)
+		return Item{}, err
+	}
+	return item, nil
+}
+
+// List returns the items in the trash of session, oldest first.
+func (s *Store) List(session string) ([]Item, error) {
+	s.Purge()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := os.ReadDir(s.sessionDir(session))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var items []Item
+	for _, entry := range entries {
+		if item, err := s.readItem(session, entry.Name()); err == nil {
+			items = append(items, item)
+		}
+	}
+	slices.SortFunc(items, func(a, b Item) int {
+		return cmp.Or(a.Deleted.Compare(b.Deleted), cmp.Compare(a.ID, b.ID))
+	})
+	return items, nil
+}
+
+// Get returns the item with the given ID in the trash of session.
+func (s *Store) Get(session, id string) (Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readItem(session, id)
+}
+
+// Restore moves the item with the given ID in the trash of session back to its path,
+// creating the directories leading to it. It fails with ErrExists if the path is taken.
+func (s *Store) Restore(session, id string) (Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, err := s.readItem(session, id)
+	if err != nil {
+		return Item{}, err
+	}
+	if _, err := os.Lstat(item.Path); err == nil {
+		return Item{}, fmt.Errorf("%w: %s", ErrExists, item.Path)
+	}
+	if err := os.MkdirAll(filepath.Dir(item.Path), 0o755); err != nil { //nolint:mnd // like mkdir -p
+		return Item{}, fmt.Errorf("failed to restore %s: %w", item.Path, err)
+	}
+	itemDir := s.itemDir(session, id)
+	if err := move(filepath.Join(itemDir, dataName), item.Path); err != nil {
+		return Item{}, fmt.Errorf("failed to restore %s: %w", item.Path, err)
+	}
+	_ = os.RemoveAll(itemDir)
+	return item, nil
+}
+
+// Purge removes the items of every session that are older than the TTL.
+func (s *Store) Purge() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessions, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	expiry := s.now().Add(-s.ttl)
+	for _, session := range sessions {
+		sessionDir := filepath.Join(s.dir, session.Name())
+		entries, err := os.ReadDir(sessionDir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			itemDir := filepath.Join(sessionDir, entry.Name())
+			var item Item
+			data, err := os.ReadFile(filepath.Join(itemDir, itemFile))
+			if err == nil {
+				err = json.Unmarshal(data, &item)
+			}
+			// Items whose description is unreadable were never completed
+			if err != nil || item.Deleted.Before(expiry) {
+				_ = os.RemoveAll(itemDir)
+			}
+		}
+		// Fails unless the session has no items left
+		_ = os.Remove(sessionDir)
+	}
+}
+
+// readItem returns the description of the item with the given ID. s.mu must be held.
+func (s *Store) readItem(session, id string) (Item, error) {
+	if b, err := hex.DecodeString(id); err != nil || len(b) != idBytes {
+		return Item{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	data, err := os.ReadFile(filepath.Join(s.itemDir(session, id), itemFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Item{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if err != nil {
+		return Item{}, err
+	}
+	var item Item
+	if err := json.Unmarshal(data, &item); err != nil {
+		return Item{}, fmt.Errorf("failed to read trash item %s: %w", id, err)
+	}
+	return item, nil
+}
+
+// sessionDir returns the directory of the items of session. Sessions are named by a hash,
+// so that any session name makes a safe file name.
+func (s *Store) sessionDir(session string) string {
+	sum := sha256.Sum256([]byte(session))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:sessionBytes]))
+}
+
+// itemDir returns the directory of the item with the given ID.
+func (s *Store) itemDir(session, id string) string {
+	return filepath.Join(s.sessionDir(session), id)
+}
+
+// move renames src to dst, copying and removing it when they are on different file systems.
+func move(src, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	if err := copyTree(src, dst); err != nil {
+		_ = os.RemoveAll(dst)
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+// copyTree copies the file, symlink, or directory tree at src to dst, keeping permissions.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.Mkdir(target, info.Mode().Perm())
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		default:
+			return fmt.Errorf("cannot move %s across file systems: not a regular file", path)
+		}
+	})
+}
+
+// copyFile copies the regular file src to dst with the given permissions.
+func copyFile(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
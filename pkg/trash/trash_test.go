@@ -0,0 +1,93 @@
+package trash
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+func TestStore_DeleteAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.txt")
+	tree := filepath.Join(dir, "tree")
+	assert.NoError(t, os.WriteFile(file, []byte("a"), 0o600))
+	assert.NoError(t, os.MkdirAll(filepath.Join(tree, "sub"), 0o700))
+	assert.NoError(t, os.WriteFile(filepath.Join(tree, "sub", "b.txt"), []byte("b"), 0o600))
+
+	s := New(config.TrashConfig{Enabled: true, Dir: t.TempDir()})
+	fileItem, err := s.Delete("s1", file)
+	assert.NoError(t, err)
+	treeItem, err := s.Delete("s1", tree)
+	assert.NoError(t, err)
+	assert.True(t, treeItem.Dir)
+	_, err = os.Lstat(file)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+	_, err = os.Lstat(tree)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+
+	// Items are listed per session
+	items, err := s.List("s1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{file, tree}, []string{items[0].Path, items[1].Path})
+	items, err = s.List("s2")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(items))
+	_, err = s.Restore("s2", fileItem.ID)
+	assert.IsError(t, err, ErrNotFound)
+
+	// Restoring moves the item back, but not over a file that took its place
+	assert.NoError(t, os.WriteFile(file, []byte("new"), 0o600))
+	_, err = s.Restore("s1", fileItem.ID)
+	assert.IsError(t, err, ErrExists)
+	assert.NoError(t, os.Remove(file))
+	_, err = s.Restore("s1", fileItem.ID)
+	assert.NoError(t, err)
+	data, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "a", string(data))
+	_, err = s.Restore("s1", fileItem.ID)
+	assert.IsError(t, err, ErrNotFound)
+
+	_, err = s.Restore("s1", treeItem.ID)
+	assert.NoError(t, err)
+	data, err = os.ReadFile(filepath.Join(tree, "sub", "b.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "b", string(data))
+
+	_, err = s.Restore("s1", "../../etc")
+	assert.IsError(t, err, ErrNotFound)
+}
+
+func TestStore_Purge(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	s := New(config.TrashConfig{Enabled: true, Dir: t.TempDir(), TTL: 60})
+	s.now = func() time.Time { return now }
+
+	for _, name := range []string{"old.txt", "new.txt"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0o600))
+	}
+	_, err := s.Delete("s1", filepath.Join(dir, "old.txt"))
+	assert.NoError(t, err)
+	now = now.Add(45 * time.Second)
+	_, err = s.Delete("s1", filepath.Join(dir, "new.txt"))
+	assert.NoError(t, err)
+
+	now = now.Add(30 * time.Second)
+	items, err := s.List("s1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(items))
+	assert.Equal(t, filepath.Join(dir, "new.txt"), items[0].Path)
+
+	now = now.Add(time.Minute)
+	s.Purge()
+	entries, err := os.ReadDir(s.dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(entries))
+}
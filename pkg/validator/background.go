@@ -0,0 +1,117 @@
+package validator
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// CheckBackground finds background commands ("cmd &"), coprocesses, and process substitutions
+// ("<(cmd)", ">(cmd)") in a parsed script unless allowBackground or allowProcessSubstitution
+// permits them. Their commands run concurrently with the script and may outlive it, escaping the
+// execution timeout and the output size limit.
+func (v *CommandValidator) CheckBackground(prog *syntax.File) []Violation {
+	if v.config.AllowBackground && v.config.AllowProcessSubstitution {
+		return nil
+	}
+
+	var violations []Violation
+	syntax.Walk(prog, func(node syntax.Node) bool {
+		if message := v.backgroundMessage(node); message != "" {
+			cmd := commandOf(node)
+			v.logBlockedCommand(RuleBackground, cmd, nil, message)
+			violations = append(violations, Violation{
+				Command: cmd,
+				Line:    node.Pos().Line(),
+				Column:  node.Pos().Col(),
+				Rule:    RuleBackground,
+				Message: message,
+			})
+		}
+		return true
+	})
+	return violations
+}
+
+// backgroundMessage returns why node is denied by the background policy, or "" if it is not.
+func (v *CommandValidator) backgroundMessage(node syntax.Node) string {
+	switch n := node.(type) {
+	case *syntax.Stmt:
+		if v.config.AllowBackground {
+			return ""
+		}
+		if n.Background {
+			return fmt.Sprintf("background command %s is not allowed: set allowBackground to permit it", describeStmt(n))
+		}
+		if n.Coprocess {
+			return fmt.Sprintf("coprocess %s is not allowed: set allowBackground to permit it", describeStmt(n))
+		}
+	case *syntax.CoprocClause:
+		if !v.config.AllowBackground {
+			return fmt.Sprintf("coprocess %s is not allowed: set allowBackground to permit it", describeStmt(n.Stmt))
+		}
+	case *syntax.ProcSubst:
+		if !v.config.AllowProcessSubstitution {
+			return fmt.Sprintf("process substitution %s...) is not allowed: set allowProcessSubstitution to permit it", n.Op)
+		}
+	}
+	return ""
+}
+
+// checkWaitBuiltin decides on the wait builtin, which only waits for background commands:
+// allowBackground permits it unless builtins.deny lists it, and it is denied otherwise.
+func (v *CommandValidator) checkWaitBuiltin(cmd string, args []string) (Decision, bool) {
+	if cmd != "wait" {
+		return Decision{}, false
+	}
+	if v.config.AllowBackground {
+		if slices.Contains(v.config.Builtins.Deny, cmd) {
+			return Decision{}, false
+		}
+		return allowDecision, true
+	}
+	return v.deny(RuleBackground, cmd, args, `shell builtin "wait" is not allowed: set allowBackground to permit background commands`), true
+}
+
+// processSubstitutionPrefix starts the names of the named pipes that the interpreter creates in
+// the temporary directory for process substitutions.
+const processSubstitutionPrefix = "sh-interp-"
+
+// IsProcessSubstitutionPipe reports whether path is a named pipe that the interpreter created for
+// a process substitution, and which a command receives in place of "<(...)" or ">(...)", while
+// allowProcessSubstitution is set. Such pipes live in the temporary directory, outside the
+// allowed directories, but only connect the command to another validated command.
+func (v *CommandValidator) IsProcessSubstitutionPipe(path string) bool {
+	if !v.config.AllowProcessSubstitution || !strings.HasPrefix(filepath.Base(path), processSubstitutionPrefix) {
+		return false
+	}
+	info, err := os.Lstat(path)
+	return err == nil && info.Mode()&fs.ModeNamedPipe != 0
+}
+
+// commandOf returns the literal name of the command a statement runs, or "" if it has none.
+func commandOf(node syntax.Node) string {
+	stmt, ok := node.(*syntax.Stmt)
+	if !ok {
+		return ""
+	}
+	call, ok := stmt.Cmd.(*syntax.CallExpr)
+	if !ok || len(call.Args) == 0 {
+		return ""
+	}
+	name, _ := literalWord(call.Args[0])
+	return NormalizeCommandName(name)
+}
+
+// describeStmt names the command of a statement for messages.
+func describeStmt(stmt *syntax.Stmt) string {
+	if cmd := commandOf(stmt); cmd != "" {
+		return fmt.Sprintf("%q", cmd)
+	}
+	return "at " + stmt.Pos().String()
+}
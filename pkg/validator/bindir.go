@@ -0,0 +1,47 @@
+package validator
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// CheckInvocation checks how a command is invoked, before its name is normalized for
+// allowlist matching. When allowedBinDirs is set, commands must be found through PATH or
+// invoked by an absolute path in one of those directories: "./tool" and "bin/tool" would
+// run whatever executable happens to be at that path.
+func (v *CommandValidator) CheckInvocation(cmd string, args []string) (bool, string) {
+	d := v.localizeDecision(v.checkInvocation(cmd, args), cmd, args)
+	return d.Allowed, d.Message
+}
+
+// checkInvocation implements CheckInvocation.
+func (v *CommandValidator) checkInvocation(cmd string, args []string) Decision {
+	if len(v.config.AllowedBinDirs) == 0 || !strings.ContainsAny(cmd, `/\`) {
+		return allowDecision
+	}
+	if !filepath.IsAbs(cmd) {
+		message := fmt.Sprintf("command %q is invoked by a relative path: only commands in the allowed executable directories may run", cmd)
+		return v.deny(RuleBinDir, cmd, args, message)
+	}
+
+	// The executable itself may be a symlink, as it may be when found through PATH
+	dir := filepath.Dir(filepath.Clean(cmd))
+	resolvedDir := resolveSymlinksPath(dir)
+	for _, binDir := range v.config.AllowedBinDirs {
+		binDir = filepath.Clean(binDir)
+		if dir == binDir || resolvedDir == resolveSymlinksPath(binDir) {
+			return allowDecision
+		}
+	}
+	message := fmt.Sprintf("command %q is not in an allowed executable directory: %s", cmd, v.config.DefaultErrorMessage)
+	return v.deny(RuleBinDir, cmd, args, message)
+}
+
+// validateInvocation checks a command as it is invoked in a script, by path or by name.
+func (v *CommandValidator) validateInvocation(cmd string, args []string, workDir string) Decision {
+	if d := v.checkInvocation(cmd, args); !d.Allowed {
+		return d
+	}
+	return v.validate(NormalizeCommandName(cmd), args, workDir)
+}
@@ -0,0 +1,52 @@
+package validator
+
+import (
+	"fmt"
+	"slices"
+)
+
+// shellBuiltins lists the commands interpreted in-process by mvdan.cc/sh rather than executed.
+var shellBuiltins = map[string]bool{
+	"true": true, ":": true, "false": true, "exit": true, "set": true, "shift": true, "unset": true,
+	"echo": true, "printf": true, "break": true, "continue": true, "pwd": true, "cd": true,
+	"wait": true, "builtin": true, "trap": true, "type": true, "source": true, ".": true, "command": true,
+	"dirs": true, "pushd": true, "popd": true, "umask": true, "alias": true, "unalias": true,
+	"fg": true, "bg": true, "getopts": true, "eval": true, "test": true, "[": true, "exec": true,
+	"return": true, "read": true, "mapfile": true, "readarray": true, "shopt": true,
+}
+
+// declarationBuiltins are parsed as declaration clauses and never reach the interpreter's call handler,
+// so they must be validated by walking the script before it runs.
+var declarationBuiltins = map[string]bool{
+	"declare": true, "local": true, "export": true, "readonly": true, "typeset": true, "nameref": true,
+}
+
+// IsShellBuiltin reports whether name is a builtin interpreted by the shell rather than an executable.
+func IsShellBuiltin(name string) bool {
+	return shellBuiltins[name] || declarationBuiltins[name]
+}
+
+// IsDeclarationBuiltin reports whether name is a declaration builtin such as export or local.
+func IsDeclarationBuiltin(name string) bool {
+	return declarationBuiltins[name]
+}
+
+// checkBuiltinPolicy applies the builtins section of the configuration.
+// It returns decided=false when the policy has no opinion and the regular allowlist applies.
+func (v *CommandValidator) checkBuiltinPolicy(cmd string, args []string) (Decision, bool) {
+	policy := v.config.Builtins
+
+	if slices.Contains(policy.Deny, cmd) {
+		reason := v.config.DefaultErrorMessage
+		if policy.Message != "" {
+			reason = policy.Message
+		}
+		return v.deny(RuleBuiltin, cmd, args, fmt.Sprintf("shell builtin %q is denied: %s", cmd, reason)), true
+	}
+
+	if slices.Contains(policy.Allow, cmd) {
+		return allowDecision, true
+	}
+
+	return Decision{}, false
+}
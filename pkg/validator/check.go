@@ -0,0 +1,18 @@
+package validator
+
+// CheckCommand reports the decision the policy makes on running cmd with args in cwd, so that
+// editors and agent planners can show the expected outcome before running a command. Unlike
+// ValidateCommand it has no side effects: denials are not written to the block log. cmd may be
+// a path, as in a script, in which case it is checked against allowedBinDirs first.
+//
+// Only the static policy is applied. When the command runs it may still be held for approval,
+// denied by an OPA policy, or rejected by rate and session limits.
+func (v *CommandValidator) CheckCommand(cmd string, args []string, cwd string) Decision {
+	quiet := *v
+	quiet.blockLog = nil
+
+	if allowed, message := quiet.IsDirectoryAllowed(cwd); !allowed {
+		return v.localizeDecision(Decision{Allowed: false, Rule: RuleDirectory, Message: message}, cmd, args)
+	}
+	return v.localizeDecision(quiet.validateInvocation(cmd, args, cwd), cmd, args)
+}
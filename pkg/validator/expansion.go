@@ -0,0 +1,92 @@
+package validator
+
+import (
+	"fmt"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// CheckExpansions finds the commands in a parsed script whose name comes from an expansion when
+// denyDynamicCommands is set, and those marked literalArgs whose arguments contain an expansion.
+// Parameter expansions, command substitutions, and arithmetic are only resolved when the script
+// runs, so the allowlist cannot reason about which command or path they will name.
+func (v *CommandValidator) CheckExpansions(prog *syntax.File) []Violation {
+	if !v.config.DenyDynamicCommands && !v.anyLiteralArgs() {
+		return nil
+	}
+
+	var violations []Violation
+	syntax.Walk(prog, func(node syntax.Node) bool {
+		call, ok := node.(*syntax.CallExpr)
+		if !ok || len(call.Args) == 0 {
+			return true
+		}
+
+		name, ok := literalWord(call.Args[0])
+		if !ok {
+			if v.config.DenyDynamicCommands {
+				source := wordSource(call.Args[0])
+				message := fmt.Sprintf("command name %s comes from an expansion and cannot be validated before it runs", source)
+				violations = append(violations, v.expansionViolation(source, nil, call.Args[0], message))
+			}
+			return true
+		}
+
+		cmd := NormalizeCommandName(name)
+		if !v.requiresLiteralArgs(cmd) {
+			return true
+		}
+		for _, word := range call.Args[1:] {
+			if _, ok := literalWord(word); !ok {
+				source := wordSource(word)
+				message := fmt.Sprintf("argument %s of %q comes from an expansion, but the command only accepts literal arguments", source, cmd)
+				violations = append(violations, v.expansionViolation(cmd, []string{source}, word, message))
+			}
+		}
+		return true
+	})
+	return violations
+}
+
+// expansionViolation logs and returns the violation of an expanded word.
+func (v *CommandValidator) expansionViolation(cmd string, args []string, word *syntax.Word, message string) Violation {
+	v.logBlockedCommand(RuleExpansion, cmd, args, message)
+	return Violation{
+		Command: cmd,
+		Args:    args,
+		Line:    word.Pos().Line(),
+		Column:  word.Pos().Col(),
+		Rule:    RuleExpansion,
+		Message: message,
+	}
+}
+
+// requiresLiteralArgs reports whether the command's allowCommands entry is marked literalArgs.
+func (v *CommandValidator) requiresLiteralArgs(cmd string) bool {
+	for _, allowed := range v.config.AllowCommands {
+		if allowed.Command == cmd {
+			return allowed.LiteralArgs
+		}
+	}
+	return false
+}
+
+// anyLiteralArgs reports whether any allowCommands entry is marked literalArgs.
+func (v *CommandValidator) anyLiteralArgs() bool {
+	for _, allowed := range v.config.AllowCommands {
+		if allowed.LiteralArgs {
+			return true
+		}
+	}
+	return false
+}
+
+// wordSource returns a word as it is written in the script.
+func wordSource(word *syntax.Word) string {
+	var sb strings.Builder
+	if err := syntax.NewPrinter().Print(&sb, word); err != nil {
+		return word.Lit()
+	}
+	return sb.String()
+}
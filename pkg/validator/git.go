@@ -0,0 +1,618 @@
+package validator
+
+import (
+	"bufio"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+// gitGlobalValueOptions are git's global options that take the next argument as their value.
+var gitGlobalValueOptions = map[string]bool{
+	"-C": true, "-c": true, "--git-dir": true, "--work-tree": true, "--namespace": true,
+	"--config-env": true, "--super-prefix": true, "--list-cmds": true,
+}
+
+// gitValueOptions are the options of the subcommands that contact or configure remotes that
+// take the next argument as their value, so that it is not mistaken for a repository.
+var gitValueOptions = map[string]map[string]bool{
+	"clone": {
+		"-o": true, "--origin": true, "-b": true, "--branch": true, "-u": true, "--upload-pack": true,
+		"--reference": true, "--reference-if-able": true, "--separate-git-dir": true, "--depth": true,
+		"--shallow-since": true, "--shallow-exclude": true, "-c": true, "--config": true, "-j": true,
+		"--jobs": true, "--filter": true, "--template": true, "--server-option": true, "--bundle-uri": true,
+	},
+	"fetch": {
+		"--depth": true, "--deepen": true, "--shallow-since": true, "--shallow-exclude": true, "-o": true,
+		"--server-option": true, "-j": true, "--jobs": true, "--upload-pack": true, "--negotiation-tip": true,
+		"--refmap": true, "--recurse-submodules-default": true,
+	},
+	"pull": {
+		"--depth": true, "--deepen": true, "--shallow-since": true, "--shallow-exclude": true, "-o": true,
+		"--server-option": true, "-j": true, "--jobs": true, "--upload-pack": true, "--negotiation-tip": true,
+		"-s": true, "--strategy": true, "-X": true, "--strategy-option": true,
+	},
+	"push":      {"--repo": true, "-o": true, "--push-option": true, "--receive-pack": true, "--exec": true},
+	"ls-remote": {"--upload-pack": true, "--exec": true, "-o": true, "--server-option": true, "--sort": true},
+	"remote":    {"-t": true, "-m": true},
+	"submodule": {"-b": true, "--branch": true, "--name": true, "--reference": true, "--depth": true},
+}
+
+// gitProgramOptions are options that run a program of the caller's choosing, by subcommand.
+var gitProgramOptions = map[string][]string{
+	"clone":     {"-u", "--upload-pack"},
+	"fetch":     {"--upload-pack"},
+	"pull":      {"--upload-pack"},
+	"ls-remote": {"--upload-pack", "--exec"},
+	"push":      {"--receive-pack", "--exec"},
+	"archive":   {"--exec"},
+}
+
+// gitProgramKeys are the configuration keys whose values git runs as programs, as patterns
+// (see path.Match) of the lowercased key.
+var gitProgramKeys = []string{
+	"alias.*", "core.askpass", "core.editor", "core.fsmonitor", "core.gitproxy", "core.hookspath",
+	"core.pager", "core.sshcommand", "diff.external", "diff.*.command", "diff.*.textconv", "filter.*",
+	"gpg.program", "gpg.*.program", "init.templatedir", "merge.*.driver", "pager.*",
+	"remote.*.receivepack", "remote.*.uploadpack", "sequence.editor", "uploadpack.packobjectshook",
+}
+
+// gitSafetyKeys are the configuration keys that lift the protections of the git rules: forced
+// pushes and deletions through refspecs or mirroring configured for a push, and git clean
+// without -f.
+var gitSafetyKeys = []string{"clean.requireforce", "remote.*.push", "remote.*.mirror", "push.*"}
+
+// gitLongOptions are the long options of the subcommands whose options the git rules check, as
+// listed by "git <subcommand> --git-completion-helper-all" (git 2.39). Git accepts any unique
+// prefix of a long option, so arguments are expanded to these names before they are checked.
+// An option ending in "=" takes a value, which may also be given as the next argument.
+var gitLongOptions = map[string]string{
+	"push": "--verbose --quiet --repo= --all --mirror --delete --tags --dry-run --porcelain --force " +
+		"--force-with-lease --force-if-includes --recurse-submodules= --thin --receive-pack= --exec= " +
+		"--set-upstream --progress --prune --no-verify --follow-tags --signed --atomic --push-option= " +
+		"--ipv4 --ipv6 --verify --no-verbose --no-quiet --no-repo --no-all --no-mirror --no-delete " +
+		"--no-tags --no-dry-run --no-porcelain --no-force --no-force-with-lease --no-force-if-includes " +
+		"--no-recurse-submodules --no-thin --no-receive-pack --no-exec --no-set-upstream --no-progress " +
+		"--no-prune --no-follow-tags --no-signed --no-atomic --no-push-option --no-ipv4 --no-ipv6",
+	"clean": "--quiet --dry-run --force --interactive --exclude= --no-quiet --no-dry-run --no-force --no-interactive",
+	"fetch": "--verbose --quiet --all --set-upstream --append --atomic --upload-pack= --force --multiple " +
+		"--tags --jobs= --prefetch --prune --prune-tags --recurse-submodules --dry-run --write-fetch-head " +
+		"--keep --update-head-ok --progress --depth= --shallow-since= --shallow-exclude= --deepen= " +
+		"--unshallow --refetch --submodule-prefix= --recurse-submodules-default= --update-shallow " +
+		"--refmap= --server-option= --ipv4 --ipv6 --negotiation-tip= --negotiate-only --filter= " +
+		"--auto-maintenance --auto-gc --show-forced-updates --write-commit-graph --stdin --no-verbose " +
+		"--no-quiet --no-all --no-set-upstream --no-append --no-atomic --no-upload-pack --no-force " +
+		"--no-multiple --no-tags --no-jobs --no-prefetch --no-prune --no-prune-tags " +
+		"--no-recurse-submodules --no-dry-run --no-write-fetch-head --no-keep --no-update-head-ok " +
+		"--no-progress --no-depth --no-shallow-since --no-shallow-exclude --no-deepen " +
+		"--no-submodule-prefix --no-recurse-submodules-default --no-update-shallow --no-server-option " +
+		"--no-ipv4 --no-ipv6 --no-negotiation-tip --no-negotiate-only --no-filter --no-auto-maintenance " +
+		"--no-auto-gc --no-show-forced-updates --no-write-commit-graph --no-stdin",
+	"pull": "--verbose --quiet --progress --recurse-submodules --rebase --stat --summary --log --signoff " +
+		"--squash --commit --edit --cleanup= --ff --ff-only --verify --verify-signatures --autostash " +
+		"--strategy= --strategy-option= --gpg-sign --allow-unrelated-histories --all --append " +
+		"--upload-pack= --force --tags --prune --jobs --dry-run --keep --depth= --shallow-since= " +
+		"--shallow-exclude= --deepen= --unshallow --update-shallow --refmap= --server-option= --ipv4 " +
+		"--ipv6 --negotiation-tip= --show-forced-updates --set-upstream --no-verbose --no-quiet " +
+		"--no-progress --no-recurse-submodules --no-rebase --no-stat --no-summary --no-log --no-signoff " +
+		"--no-squash --no-commit --no-edit --no-cleanup --no-ff --no-verify --no-verify-signatures " +
+		"--no-autostash --no-strategy --no-strategy-option --no-gpg-sign --no-allow-unrelated-histories " +
+		"--no-all --no-append --no-upload-pack --no-force --no-tags --no-prune --no-jobs --no-dry-run " +
+		"--no-keep --no-depth --no-shallow-since --no-shallow-exclude --no-deepen --no-update-shallow " +
+		"--no-server-option --no-ipv4 --no-ipv6 --no-negotiation-tip --no-show-forced-updates " +
+		"--no-set-upstream",
+	"clone": "--verbose --quiet --progress --reject-shallow --no-checkout --bare --naked --mirror --local " +
+		"--no-hardlinks --shared --recurse-submodules --recursive --jobs= --template= --reference= " +
+		"--reference-if-able= --dissociate --origin= --branch= --upload-pack= --depth= --shallow-since= " +
+		"--shallow-exclude= --single-branch --no-tags --shallow-submodules --separate-git-dir= --config= " +
+		"--server-option= --ipv4 --ipv6 --filter= --also-filter-submodules --remote-submodules --sparse " +
+		"--bundle-uri= --checkout --hardlinks --tags --no-verbose --no-quiet --no-progress " +
+		"--no-reject-shallow --no-bare --no-naked --no-mirror --no-local --no-shared " +
+		"--no-recurse-submodules --no-recursive --no-jobs --no-template --no-reference " +
+		"--no-reference-if-able --no-dissociate --no-origin --no-branch --no-upload-pack --no-depth " +
+		"--no-shallow-since --no-shallow-exclude --no-single-branch --no-shallow-submodules " +
+		"--no-separate-git-dir --no-config --no-server-option --no-ipv4 --no-ipv6 --no-filter " +
+		"--no-also-filter-submodules --no-remote-submodules --no-sparse --no-bundle-uri",
+	"ls-remote": "--quiet --upload-pack= --exec= --tags --heads --refs --get-url --sort= --exit-code " +
+		"--symref --server-option= --no-quiet --no-upload-pack --no-exec --no-tags --no-heads --no-refs " +
+		"--no-get-url --no-sort --no-exit-code --no-symref --no-server-option",
+	// archive passes the options it does not know on to the archive format
+	"archive": "--output= --remote= --exec= --no-output --no-remote --no-exec",
+}
+
+// gitPassesUnknownOptions are the subcommands of gitLongOptions whose other options are not an
+// error.
+var gitPassesUnknownOptions = map[string]bool{"archive": true}
+
+// gitRemoteKeys are the configuration keys that change the URL of a remote, which only
+// "git remote" may do when remotes are restricted.
+var gitRemoteKeys = []string{"remote.*.url", "remote.*.pushurl", "url.*.insteadof", "url.*.pushinsteadof"}
+
+// gitInvocation is a git command line split into its global options and its subcommand.
+type gitInvocation struct {
+	// dir is the directory git runs in, after -C
+	dir string
+	// gitDir is the value of --git-dir, if any
+	gitDir string
+	// configs are the "key=value" settings of -c and the "key=envvar" settings of --config-env
+	configs []string
+	sub     string
+	args    []string
+}
+
+// parseGitInvocation splits the arguments of git at its subcommand.
+func parseGitInvocation(args []string, workDir string) gitInvocation {
+	inv := gitInvocation{dir: workDir}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			inv.sub, inv.args = arg, args[i+1:]
+			return inv
+		}
+		name, value, hasValue := strings.Cut(arg, "=")
+		if !hasValue && gitGlobalValueOptions[arg] && i+1 < len(args) {
+			i++
+			value, hasValue = args[i], true
+		}
+		if !hasValue {
+			continue
+		}
+		switch name {
+		case "-C":
+			if !filepath.IsAbs(value) {
+				value = filepath.Join(inv.dir, value)
+			}
+			inv.dir = value
+		case "--git-dir":
+			inv.gitDir = value
+		case "-c", "--config-env":
+			inv.configs = append(inv.configs, value)
+		}
+	}
+	return inv
+}
+
+// validateGitCommand applies the built-in git rules of git.enabled: the subcommand must be in
+// DefaultGitSubCommands or git.allowSubCommands, and forced pushes, cleaning without a dry run,
+// credential helpers, options that run other programs, and remotes outside git.allowedRemotes
+// are denied.
+func (v *CommandValidator) validateGitCommand(args []string, workDir string) Decision {
+	inv := parseGitInvocation(args, workDir)
+
+	for _, dir := range []string{globalGitOption(args, "--git-dir"), globalGitOption(args, "--work-tree")} {
+		if dir == "" {
+			continue
+		}
+		if allowed, message := v.IsPathInAllowedDirectory(dir, workDir); !allowed {
+			return v.deny(RulePath, "git", args, message)
+		}
+	}
+	if globalGitOption(args, "--exec-path") != "" {
+		return v.deny(RuleDenyFlag, "git", args, "git --exec-path is not allowed: it runs programs from another directory")
+	}
+	for _, setting := range inv.configs {
+		if message := v.checkGitConfigKey(strings.SplitN(setting, "=", 2)[0]); message != "" {
+			return v.deny(RuleDenyFlag, "git", args, message)
+		}
+	}
+
+	// git without a subcommand only prints its version or help
+	if inv.sub == "" {
+		return allowDecision
+	}
+	if inv.sub == "credential" || strings.HasPrefix(inv.sub, "credential-") {
+		return v.deny(RuleDenySubCommand, "git", args, fmt.Sprintf("git %s is not allowed: credential helpers are denied", inv.sub))
+	}
+	if !slices.Contains(config.DefaultGitSubCommands, inv.sub) && !slices.Contains(v.config.Git.AllowSubCommands, inv.sub) {
+		return v.deny(RuleSubCommandNotAllowed, "git", args, fmt.Sprintf("git %s is not allowed: allowed subcommands are %s",
+			inv.sub, strings.Join(slices.Concat(config.DefaultGitSubCommands, v.config.Git.AllowSubCommands), ", ")))
+	}
+
+	subArgs, message := expandGitOptions(inv.sub, inv.args)
+	if message != "" {
+		return v.deny(RuleDenyFlag, "git", args, message)
+	}
+	inv.args = subArgs
+
+	for _, option := range gitProgramOptions[inv.sub] {
+		if hasGitOption(inv.args, option) {
+			return v.deny(RuleDenyFlag, "git", args, fmt.Sprintf("git %s %s is not allowed: it runs a program on the remote side", inv.sub, option))
+		}
+	}
+	switch inv.sub {
+	case "push":
+		if message := gitForcePushMessage(inv.args); message != "" {
+			return v.deny(RuleDenyFlag, "git", args, message)
+		}
+	case "clean":
+		if hasGitOption(inv.args, "--force") || hasGitShortFlag(inv.args, 'f') {
+			return v.deny(RuleDenyFlag, "git", args, "git clean -f is not allowed: only dry runs (-n) of git clean may run")
+		}
+	case "config":
+		for _, arg := range inv.args {
+			if strings.HasPrefix(arg, "-") {
+				continue
+			}
+			if message := v.checkGitConfigKey(arg); message != "" {
+				return v.deny(RuleDenyFlag, "git", args, message)
+			}
+		}
+	case "clone":
+		for _, setting := range gitOptionValues(inv.args, "-c", "--config") {
+			if message := v.checkGitConfigKey(strings.SplitN(setting, "=", 2)[0]); message != "" {
+				return v.deny(RuleDenyFlag, "git", args, message)
+			}
+		}
+	}
+
+	if len(v.config.Git.AllowedRemotes) > 0 {
+		if d := v.checkGitRemotes(inv, args); !d.Allowed {
+			return d
+		}
+	}
+	return allowDecision
+}
+
+// checkGitConfigKey returns why a configuration key may not be set, or "" if it may.
+func (v *CommandValidator) checkGitConfigKey(key string) string {
+	key = strings.ToLower(key)
+	if strings.HasPrefix(key, "credential.") {
+		return fmt.Sprintf("setting git %s is not allowed: credential helpers are denied", key)
+	}
+	if matchesAnyPattern(gitProgramKeys, key) {
+		return fmt.Sprintf("setting git %s is not allowed: git runs its value as a program", key)
+	}
+	if matchesAnyPattern(gitSafetyKeys, key) {
+		return fmt.Sprintf("setting git %s is not allowed: it lifts the force push and clean rules", key)
+	}
+	if len(v.config.Git.AllowedRemotes) > 0 && matchesAnyPattern(gitRemoteKeys, key) {
+		return fmt.Sprintf("setting git %s is not allowed: remotes are restricted, use git remote to change them", key)
+	}
+	return ""
+}
+
+// gitForcePushMessage returns why a push is denied as forced or deleting, or "" if it is not.
+func gitForcePushMessage(args []string) string {
+	for _, option := range []string{"--force", "--force-with-lease", "--force-if-includes", "--mirror", "--delete", "--prune"} {
+		if hasGitOption(args, option) {
+			return fmt.Sprintf("git push %s is not allowed: force pushes and remote deletions are denied", option)
+		}
+	}
+	if hasGitShortFlag(args, 'f') || hasGitShortFlag(args, 'd') {
+		return "git push -f and -d are not allowed: force pushes and remote deletions are denied"
+	}
+	for _, arg := range gitPositionals(args, gitValueOptions["push"]) {
+		if strings.HasPrefix(arg, "+") || strings.HasPrefix(arg, ":") {
+			return fmt.Sprintf("git push %s is not allowed: force pushes and remote deletions are denied", arg)
+		}
+	}
+	return ""
+}
+
+// checkGitRemotes checks the URLs of the remotes a subcommand contacts or configures against
+// git.allowedRemotes.
+func (v *CommandValidator) checkGitRemotes(inv gitInvocation, args []string) Decision {
+	positionals := gitPositionals(inv.args, gitValueOptions[inv.sub])
+	var urls []string
+	switch inv.sub {
+	case "clone":
+		if len(positionals) > 0 {
+			urls = []string{positionals[0]}
+		}
+	case "fetch", "pull", "push", "ls-remote":
+		repo := ""
+		if len(positionals) > 0 {
+			repo = positionals[0]
+		}
+		if values := gitOptionValues(inv.args, "--repo"); inv.sub == "push" && len(values) > 0 {
+			repo = values[len(values)-1]
+		}
+		// Without a repository, git uses a remote of the repository's choosing; all are checked
+		if inv.sub == "fetch" && hasGitOption(inv.args, "--all") {
+			repo = ""
+		}
+		urls = v.gitRemoteURLs(inv, repo)
+	case "remote":
+		// git remote add <name> <url> and git remote set-url <name> <url> [<old>]
+		if len(positionals) >= 3 && (positionals[0] == "add" || positionals[0] == "set-url") {
+			urls = []string{positionals[2]}
+		}
+	case "submodule":
+		if len(positionals) >= 2 && positionals[0] == "add" {
+			urls = []string{positionals[1]}
+		}
+	}
+
+	for _, url := range urls {
+		if !matchesAnyPattern(v.config.Git.AllowedRemotes, url) {
+			message := fmt.Sprintf("git remote %q is not allowed: remotes must match one of %s", url, strings.Join(v.config.Git.AllowedRemotes, ", "))
+			return v.deny(RuleGitRemote, "git", args, message)
+		}
+	}
+	return allowDecision
+}
+
+// gitRemoteURLs returns the URLs git contacts for repo, the name of a configured remote or a
+// URL, or for every configured remote when repo is empty. URLs are rewritten by the
+// url.<base>.insteadOf settings of the repository, as git rewrites them; settings outside the
+// repository's own configuration are not read.
+func (v *CommandValidator) gitRemoteURLs(inv gitInvocation, repo string) []string {
+	remotes, rewrites := readGitRemotes(inv)
+	if repo != "" {
+		if urls, ok := remotes[repo]; ok {
+			return rewriteGitURLs(urls, rewrites)
+		}
+		return rewriteGitURLs([]string{repo}, rewrites)
+	}
+	var urls []string
+	for _, name := range slices.Sorted(maps.Keys(remotes)) {
+		urls = append(urls, remotes[name]...)
+	}
+	return rewriteGitURLs(urls, rewrites)
+}
+
+// readGitRemotes reads the url and pushurl of every remote and the insteadOf rewrites from
+// the configuration of the repository git runs in. An unreadable configuration has none.
+func readGitRemotes(inv gitInvocation) (map[string][]string, map[string]string) {
+	remotes := map[string][]string{}
+	rewrites := map[string]string{}
+	configPath := gitConfigPath(inv)
+	if configPath == "" {
+		return remotes, rewrites
+	}
+	f, err := os.Open(configPath)
+	if err != nil {
+		return remotes, rewrites
+	}
+	defer f.Close()
+
+	var section, subsection string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			header := strings.TrimSpace(line[1 : len(line)-1])
+			name, sub, _ := strings.Cut(header, " ")
+			section, subsection = strings.ToLower(name), strings.Trim(strings.TrimSpace(sub), `"`)
+			continue
+		}
+		key, value, _ := strings.Cut(line, "=")
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.Trim(strings.TrimSpace(value), `"`)
+		switch {
+		case section == "remote" && (key == "url" || key == "pushurl"):
+			remotes[subsection] = append(remotes[subsection], value)
+		case section == "url" && (key == "insteadof" || key == "pushinsteadof"):
+			rewrites[value] = subsection
+		}
+	}
+	return remotes, rewrites
+}
+
+// gitConfigPath returns the configuration file of the repository git runs in, or "" if it
+// is not in one: the config of --git-dir, or of the .git directory in its directory or the
+// nearest parent, following the gitdir and commondir files of worktrees and submodules.
+func gitConfigPath(inv gitInvocation) string {
+	gitDir := inv.gitDir
+	if gitDir != "" && !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(inv.dir, gitDir)
+	}
+	for dir := inv.dir; gitDir == ""; {
+		candidate := filepath.Join(dir, ".git")
+		if info, err := os.Stat(candidate); err == nil {
+			gitDir = candidate
+			if !info.IsDir() {
+				gitDir = readGitPointer(candidate, "gitdir:")
+			}
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+	if gitDir == "" {
+		return ""
+	}
+	if common := readGitPointer(filepath.Join(gitDir, "commondir"), ""); common != "" {
+		gitDir = common
+	}
+	return filepath.Join(gitDir, "config")
+}
+
+// readGitPointer returns the path in a .git or commondir file after prefix, resolved against
+// the file's directory, or "" if the file cannot be read.
+func readGitPointer(file, prefix string) string {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return ""
+	}
+	target := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(string(data)), prefix))
+	if target == "" {
+		return ""
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(file), target)
+	}
+	return target
+}
+
+// rewriteGitURLs applies the longest matching insteadOf rewrite to each URL.
+func rewriteGitURLs(urls []string, rewrites map[string]string) []string {
+	result := make([]string, 0, len(urls))
+	for _, url := range urls {
+		best := ""
+		for prefix := range rewrites {
+			if strings.HasPrefix(url, prefix) && len(prefix) > len(best) {
+				best = prefix
+			}
+		}
+		if best != "" {
+			url = rewrites[best] + strings.TrimPrefix(url, best)
+		}
+		result = append(result, url)
+	}
+	return result
+}
+
+// expandGitOptions replaces the abbreviated long options among the arguments of a subcommand
+// with their full names (see gitLongOptions), or returns why an option is denied when it is
+// ambiguous or unknown to the subcommand. The arguments of other subcommands are returned as
+// they are.
+func expandGitOptions(sub string, args []string) ([]string, string) {
+	known, ok := gitLongOptions[sub]
+	if !ok {
+		return args, ""
+	}
+	options := strings.Fields(known)
+	expanded := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return append(expanded, args[i:]...), ""
+		}
+		// The value of a short option such as -o is never an option itself
+		if gitValueOptions[sub][arg] && !strings.HasPrefix(arg, "--") && i+1 < len(args) {
+			expanded = append(expanded, arg, args[i+1])
+			i++
+			continue
+		}
+		if !strings.HasPrefix(arg, "--") {
+			expanded = append(expanded, arg)
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(arg, "=")
+		var matches []string
+		for _, option := range options {
+			option = strings.TrimSuffix(option, "=")
+			if option == name {
+				matches = []string{option}
+				break
+			}
+			if strings.HasPrefix(option, name) {
+				matches = append(matches, option)
+			}
+		}
+		switch {
+		case len(matches) == 0 && gitPassesUnknownOptions[sub]:
+			expanded = append(expanded, arg)
+			continue
+		case len(matches) == 0:
+			return nil, fmt.Sprintf("git %s %s is not allowed: it is not an option of git %s", sub, name, sub)
+		case len(matches) > 1:
+			return nil, fmt.Sprintf("git %s %s is not allowed: it is ambiguous between %s", sub, name, strings.Join(matches, ", "))
+		}
+
+		option := matches[0]
+		if hasValue {
+			expanded = append(expanded, option+"="+value)
+			continue
+		}
+		expanded = append(expanded, option)
+		if slices.Contains(options, option+"=") && i+1 < len(args) {
+			expanded = append(expanded, args[i+1])
+			i++
+		}
+	}
+	return expanded, ""
+}
+
+// globalGitOption returns the value of a global option of git given before the subcommand.
+func globalGitOption(args []string, option string) string {
+	value := ""
+	for i := 0; i < len(args) && strings.HasPrefix(args[i], "-"); i++ {
+		if v, ok := strings.CutPrefix(args[i], option+"="); ok {
+			value = v
+		} else if args[i] == option && gitGlobalValueOptions[option] && i+1 < len(args) {
+			value = args[i+1]
+		}
+		if gitGlobalValueOptions[args[i]] {
+			i++
+		}
+	}
+	return value
+}
+
+// gitPositionals returns the arguments of a subcommand that are not options or their values.
+func gitPositionals(args []string, valueOptions map[string]bool) []string {
+	var positionals []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return append(positionals, args[i+1:]...)
+		}
+		if strings.HasPrefix(arg, "-") && arg != "-" {
+			if valueOptions[arg] {
+				i++
+			}
+			continue
+		}
+		positionals = append(positionals, arg)
+	}
+	return positionals
+}
+
+// hasGitOption reports whether an option is among the options of args, with or without a value.
+// A single-letter option may have its value attached, as in -u/usr/bin/prog.
+func hasGitOption(args []string, option string) bool {
+	short := len(option) == 2
+	for _, arg := range args {
+		if arg == "--" {
+			return false
+		}
+		if arg == option || strings.HasPrefix(arg, option+"=") || (short && strings.HasPrefix(arg, option)) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasGitShortFlag reports whether a single-letter flag is given on its own or combined with
+// others, as in -fdx.
+func hasGitShortFlag(args []string, flag byte) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			return false
+		}
+		if len(arg) > 1 && arg[0] == '-' && arg[1] != '-' && strings.IndexByte(arg[1:], flag) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// gitOptionValues returns the values given to any of the named options.
+func gitOptionValues(args []string, names ...string) []string {
+	var values []string
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--" {
+			break
+		}
+		for _, name := range names {
+			if args[i] == name && i+1 < len(args) {
+				values = append(values, args[i+1])
+			} else if value, ok := strings.CutPrefix(args[i], name+"="); ok {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// matchesAnyPattern reports whether s matches one of the patterns (see path.Match).
+func matchesAnyPattern(patterns []string, s string) bool {
+	return slices.ContainsFunc(patterns, func(pattern string) bool {
+		matched, _ := path.Match(pattern, s)
+		return matched
+	})
+}
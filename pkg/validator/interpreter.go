@@ -0,0 +1,286 @@
+package validator
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"mvdan.cc/sh/v3/syntax"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/category"
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+// interpreter describes how a command that runs programs finds its program.
+type interpreter struct {
+	// inline are the flags that give the program in the arguments, e.g. "python3 -c"
+	inline []string
+	// valueFlags are the other flags that take a value
+	valueFlags map[string]bool
+}
+
+// interpreters lists the commands that read a program from standard input when they are given
+// neither a program file nor an inline program. Shells are not listed: they may not read
+// commands from standard input at all (see parseShellArgs).
+var interpreters = map[string]interpreter{
+	"python":    {inline: []string{"-c", "-m"}, valueFlags: map[string]bool{"-W": true, "-X": true}},
+	"python2":   {inline: []string{"-c", "-m"}, valueFlags: map[string]bool{"-W": true, "-X": true}},
+	"python3":   {inline: []string{"-c", "-m"}, valueFlags: map[string]bool{"-W": true, "-X": true}},
+	"node":      {inline: []string{"-e", "--eval", "-p", "--print"}, valueFlags: map[string]bool{"-r": true, "--require": true, "--import": true}},
+	"nodejs":    {inline: []string{"-e", "--eval", "-p", "--print"}, valueFlags: map[string]bool{"-r": true, "--require": true, "--import": true}},
+	"ruby":      {inline: []string{"-e"}, valueFlags: map[string]bool{"-r": true, "-I": true, "-C": true}},
+	"perl":      {inline: []string{"-e", "-E"}, valueFlags: map[string]bool{"-I": true}},
+	"php":       {inline: []string{"-r"}, valueFlags: map[string]bool{"-c": true, "-d": true, "-z": true}},
+	"lua":       {inline: []string{"-e"}, valueFlags: map[string]bool{"-l": true}},
+	"tclsh":     {},
+	"osascript": {inline: []string{"-e"}, valueFlags: map[string]bool{"-l": true}},
+}
+
+// readsProgramFromStdin reports whether an interpreter invoked with args reads its program
+// from standard input: it is given no program, or the program "-".
+func (in interpreter) readsProgramFromStdin(args []string) bool {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-":
+			return true
+		case arg == "--":
+			return i+1 >= len(args) || args[i+1] == "-"
+		case in.valueFlags[arg]:
+			i++
+		case strings.HasPrefix(arg, "-"):
+			// Inline programs may be attached to their flag, e.g. "-cprint(1)"
+			for _, flag := range in.inline {
+				if arg == flag || (!strings.HasPrefix(flag, "--") && strings.HasPrefix(arg, flag)) {
+					return false
+				}
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// CheckInterpreterInput finds interpreters, such as python or node, that read a program from a
+// here-document, here-string, input redirection, or pipe, e.g. "python3 - <<EOF". The runner
+// never sees the commands such a program runs. Under InterpreterInputDeny each is a violation;
+// under InterpreterInputScan, the default, programs whose text is known before the script runs
+// are scanned for commands the policy denies, and the others are violations.
+func (v *CommandValidator) CheckInterpreterInput(prog *syntax.File, workDir string) []Violation {
+	if v.config.InterpreterInput == config.InterpreterInputAllow {
+		return nil
+	}
+	v = v.WithWorkDir(workDir)
+
+	// The commands whose output each pipeline stage reads
+	producers := make(map[*syntax.Stmt]*syntax.Stmt)
+	syntax.Walk(prog, func(node syntax.Node) bool {
+		if bin, ok := node.(*syntax.BinaryCmd); ok && (bin.Op == syntax.Pipe || bin.Op == syntax.PipeAll) {
+			producers[firstStage(bin.Y)] = lastStage(bin.X)
+		}
+		return true
+	})
+
+	var violations []Violation
+	syntax.Walk(prog, func(node syntax.Node) bool {
+		stmt, ok := node.(*syntax.Stmt)
+		if !ok {
+			return true
+		}
+		call, ok := stmt.Cmd.(*syntax.CallExpr)
+		if !ok || len(call.Args) == 0 {
+			return true
+		}
+		name, ok := literalWord(call.Args[0])
+		if !ok {
+			return true
+		}
+		cmd := NormalizeCommandName(name)
+		in, ok := interpreters[cmd]
+		if !ok {
+			return true
+		}
+		var args []string
+		for _, word := range call.Args[1:] {
+			arg, ok := literalWord(word)
+			if !ok {
+				// An expanded argument may name the program; it is checked when the script runs
+				return true
+			}
+			args = append(args, arg)
+		}
+		if !in.readsProgramFromStdin(args) {
+			return true
+		}
+
+		program, source, known := v.stdinOf(stmt, producers[stmt], workDir)
+		if source == "" {
+			// Nothing is fed to the program, which then reads no input or the caller's terminal
+			return true
+		}
+		var message string
+		var ref *RuleRef
+		rule := RuleInterpreterInput
+		switch {
+		case v.config.InterpreterInput == config.InterpreterInputDeny:
+			message = fmt.Sprintf("%s reads a program from %s, which is not allowed", cmd, source)
+		case !known:
+			message = fmt.Sprintf("%s reads a program from %s, which cannot be validated", cmd, source)
+		default:
+			var d Decision
+			if d = v.scanProgram(program); d.Allowed {
+				return true
+			}
+			rule, ref = d.Rule, d.Ref
+			message = fmt.Sprintf("%s program from %s would run a denied command: %s", cmd, source, d.Message)
+		}
+		v.logBlockedCommand(rule, cmd, args, message)
+		violations = append(violations, Violation{
+			Command: cmd,
+			Args:    args,
+			Line:    stmt.Pos().Line(),
+			Column:  stmt.Pos().Col(),
+			Rule:    rule,
+			Message: message,
+			Ref:     ref,
+		})
+		return true
+	})
+	return violations
+}
+
+// stdinOf returns what stmt reads on standard input: from its last input redirection, or else
+// from producer, the command piped into it, if any. source describes where the input comes
+// from, and is empty when nothing is fed to stmt. known is false when the input is only known
+// when the script runs.
+func (v *CommandValidator) stdinOf(stmt, producer *syntax.Stmt, workDir string) (input, source string, known bool) {
+	for _, redirect := range stmt.Redirs {
+		if redirect.N != nil && redirect.N.Value != "0" {
+			continue
+		}
+		switch redirect.Op {
+		case syntax.Hdoc, syntax.DashHdoc:
+			source, input, known = "a here-document", "", true
+			if redirect.Hdoc != nil {
+				input, known = literalWord(redirect.Hdoc)
+			}
+		case syntax.WordHdoc:
+			source = "a here-string"
+			input, known = literalWord(redirect.Word)
+		case syntax.RdrIn, syntax.RdrInOut:
+			source, input, known = "a file", "", false
+			if path, ok := literalWord(redirect.Word); ok {
+				input, known = v.readInputFile(path, workDir)
+			}
+		case syntax.DplIn:
+			source, input, known = "another file descriptor", "", false
+		}
+	}
+	if source != "" || producer == nil {
+		return input, source, known
+	}
+
+	// The output of echo, printf, and cat of literal arguments is known in advance
+	source = "a pipe"
+	call, ok := producer.Cmd.(*syntax.CallExpr)
+	if !ok || len(call.Args) == 0 {
+		return "", source, false
+	}
+	name, _ := literalWord(call.Args[0])
+	var args []string
+	for _, word := range call.Args[1:] {
+		arg, ok := literalWord(word)
+		if !ok {
+			return "", source, false
+		}
+		args = append(args, arg)
+	}
+	switch NormalizeCommandName(name) {
+	case "echo", "printf":
+		return strings.Join(args, " "), source, true
+	case "cat":
+		if len(args) == 0 {
+			input, _, known = v.stdinOf(producer, nil, workDir)
+			return input, source, known
+		}
+		var sb strings.Builder
+		for _, arg := range args {
+			content, ok := v.readInputFile(arg, workDir)
+			if !ok {
+				return "", source, false
+			}
+			sb.WriteString(content)
+			sb.WriteString("\n")
+		}
+		return sb.String(), source, true
+	}
+	return "", source, false
+}
+
+// readInputFile reads a file given as input to an interpreter, which must be in an allowed
+// directory.
+func (v *CommandValidator) readInputFile(path string, workDir string) (string, bool) {
+	if strings.HasPrefix(path, "-") {
+		return "", false
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(workDir, path)
+	}
+	if allowed, _ := v.IsPathInAllowedDirectory(path, workDir); !allowed {
+		return "", false
+	}
+	content, err := readScriptFile(path)
+	if err != nil {
+		return "", false
+	}
+	return content, true
+}
+
+// scanProgram looks for the names of denied commands in the text of a program. Programs in
+// other languages cannot be validated like shell scripts, so any word naming a command listed
+// in denyCommands or in a denied category denies the program, including in strings and
+// comments. Deny rules limited to args match when any word of the program contains one.
+func (v *CommandValidator) scanProgram(program string) Decision {
+	words := strings.FieldsFunc(program, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("_-./~", r)
+	})
+	seen := make(map[string]bool, len(words))
+	for _, word := range words {
+		cmd := NormalizeCommandName(strings.TrimRight(word, "."))
+		if seen[cmd] {
+			continue
+		}
+		seen[cmd] = true
+		if denied, message, ref := v.isCommandExplicitlyDenied(cmd, words); denied {
+			return Decision{Rule: RuleDenyCommand, Message: message, Ref: ref}
+		}
+		if c, ok := category.Match(cmd, v.config.DenyCategories); ok {
+			return Decision{Rule: RuleDenyCategory, Message: fmt.Sprintf("command %q is denied: %s commands are not allowed", cmd, c)}
+		}
+	}
+	return allowDecision
+}
+
+// firstStage returns the first command of a pipeline.
+func firstStage(stmt *syntax.Stmt) *syntax.Stmt {
+	for {
+		bin, ok := stmt.Cmd.(*syntax.BinaryCmd)
+		if !ok || (bin.Op != syntax.Pipe && bin.Op != syntax.PipeAll) {
+			return stmt
+		}
+		stmt = bin.X
+	}
+}
+
+// lastStage returns the last command of a pipeline.
+func lastStage(stmt *syntax.Stmt) *syntax.Stmt {
+	for {
+		bin, ok := stmt.Cmd.(*syntax.BinaryCmd)
+		if !ok || (bin.Op != syntax.Pipe && bin.Op != syntax.PipeAll) {
+			return stmt
+		}
+		stmt = bin.Y
+	}
+}
@@ -0,0 +1,226 @@
+package validator
+
+import (
+	"fmt"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// CheckScriptSize denies a script larger than scriptLimits.maxSize. It is called before the
+// script is parsed, so that the parser never sees oversized input.
+func (v *CommandValidator) CheckScriptSize(script string) []Violation {
+	message := v.scriptSizeMessage(script)
+	if message == "" {
+		return nil
+	}
+	v.logBlockedCommand(RuleScriptLimit, "", nil, message)
+	return []Violation{{Line: 1, Column: 1, Rule: RuleScriptLimit, Message: message}}
+}
+
+// scriptSizeMessage returns why script is over the size limit, or "" if it is not.
+func (v *CommandValidator) scriptSizeMessage(script string) string {
+	limit := v.config.ScriptLimits.MaxSize
+	if limit <= 0 || len(script) <= limit {
+		return ""
+	}
+	return fmt.Sprintf("script is %d bytes, more than the limit of %d (scriptLimits.maxSize)", len(script), limit)
+}
+
+// CheckComplexity denies a parsed script whose syntax tree has more nodes, deeper nesting,
+// more loops, more commands, or longer pipelines or chains than scriptLimits permits. The walk stops at the first limit
+// exceeded, so that a pathological script costs no more than the limits allow.
+func (v *CommandValidator) CheckComplexity(prog *syntax.File) []Violation {
+	node, message := v.complexityMessage(prog)
+	if message == "" {
+		return nil
+	}
+	cmd := commandOf(node)
+	v.logBlockedCommand(RuleScriptLimit, cmd, nil, message)
+	return []Violation{{
+		Command: cmd,
+		Line:    node.Pos().Line(),
+		Column:  node.Pos().Col(),
+		Rule:    RuleScriptLimit,
+		Message: message,
+	}}
+}
+
+// complexityMessage returns the node at which prog exceeds a complexity limit and why, or a
+// nil node and "" if it does not.
+func (v *CommandValidator) complexityMessage(prog *syntax.File) (syntax.Node, string) {
+	limits := v.config.ScriptLimits
+	if limits.MaxNodes <= 0 && limits.MaxDepth <= 0 && limits.MaxLoops <= 0 && limits.MaxCommands <= 0 &&
+		limits.MaxPipeline <= 0 && limits.MaxChain <= 0 {
+		return nil, ""
+	}
+
+	var (
+		nodes, depth, loops, commands int
+		// pipeline and chain are the lengths of the pipeline and the lists that start at node
+		pipeline, chain int
+		// stack holds the nodes being walked, to find when a node's children are done
+		stack   []syntax.Node
+		nesting []bool
+		at      syntax.Node
+		message string
+	)
+	syntax.Walk(prog, func(node syntax.Node) bool {
+		if message != "" {
+			return false
+		}
+		if node == nil {
+			if nesting[len(nesting)-1] {
+				depth--
+			}
+			stack, nesting = stack[:len(stack)-1], nesting[:len(nesting)-1]
+			return true
+		}
+
+		nests := nestsDeeper(node, stack)
+		stack, nesting = append(stack, node), append(nesting, nests)
+		nodes++
+		if nests {
+			depth++
+		}
+		pipeline, chain = 0, 0
+		if limits.MaxPipeline > 0 && isPipeline(node) && !isPipeline(ancestor(stack, 2)) {
+			pipeline = pipelineLength(node.(*syntax.BinaryCmd))
+		}
+		if limits.MaxChain > 0 {
+			for _, list := range stmtLists(node) {
+				chain = max(chain, chainLength(list))
+			}
+		}
+		switch n := node.(type) {
+		case *syntax.ForClause, *syntax.WhileClause:
+			loops++
+		case *syntax.CallExpr:
+			if len(n.Args) > 0 {
+				commands++
+			}
+		case *syntax.DeclClause:
+			commands++
+		}
+
+		switch {
+		case limits.MaxNodes > 0 && nodes > limits.MaxNodes:
+			message = fmt.Sprintf("script has more than %d syntax nodes (scriptLimits.maxNodes)", limits.MaxNodes)
+		case limits.MaxDepth > 0 && depth > limits.MaxDepth:
+			message = fmt.Sprintf("script nests more than %d levels deep (scriptLimits.maxDepth)", limits.MaxDepth)
+		case limits.MaxLoops > 0 && loops > limits.MaxLoops:
+			message = fmt.Sprintf("script has more than %d loops (scriptLimits.maxLoops)", limits.MaxLoops)
+		case limits.MaxCommands > 0 && commands > limits.MaxCommands:
+			message = fmt.Sprintf("script has more than %d commands (scriptLimits.maxCommands)", limits.MaxCommands)
+		case limits.MaxPipeline > 0 && pipeline > limits.MaxPipeline:
+			message = fmt.Sprintf("pipeline of %d commands is longer than the limit of %d (scriptLimits.maxPipeline); split it into smaller scripts that can be reviewed one at a time",
+				pipeline, limits.MaxPipeline)
+		case limits.MaxChain > 0 && chain > limits.MaxChain:
+			message = fmt.Sprintf("chain of %d commands joined by &&, ||, ;, or newlines is longer than the limit of %d (scriptLimits.maxChain); split it into smaller scripts that can be reviewed one at a time",
+				chain, limits.MaxChain)
+		}
+		if message != "" {
+			at = node
+			return false
+		}
+		return true
+	})
+	return at, message
+}
+
+// nestsDeeper reports whether node, a child of the last node of stack, opens a level of
+// nesting: a compound command, a function, or a command or process substitution.
+func nestsDeeper(node syntax.Node, stack []syntax.Node) bool {
+	switch n := node.(type) {
+	case *syntax.IfClause:
+		// An elif or else is parsed as an IfClause within the one it continues
+		if len(stack) > 0 {
+			if parent, ok := stack[len(stack)-1].(*syntax.IfClause); ok && parent.Else == n {
+				return false
+			}
+		}
+		return true
+	case *syntax.Block:
+		// The body of a function is a level with the function itself
+		if len(stack) > 1 {
+			if _, ok := stack[len(stack)-2].(*syntax.FuncDecl); ok {
+				return false
+			}
+		}
+		return true
+	case *syntax.Subshell, *syntax.WhileClause, *syntax.ForClause, *syntax.CaseClause,
+		*syntax.FuncDecl, *syntax.CmdSubst, *syntax.ProcSubst:
+		return true
+	}
+	return false
+}
+
+// ancestor returns the node n levels above the last node of stack, or nil.
+func ancestor(stack []syntax.Node, n int) syntax.Node {
+	if len(stack) <= n {
+		return nil
+	}
+	return stack[len(stack)-1-n]
+}
+
+// isPipeline reports whether node joins two commands with | or |&.
+func isPipeline(node syntax.Node) bool {
+	bin, ok := node.(*syntax.BinaryCmd)
+	return ok && (bin.Op == syntax.Pipe || bin.Op == syntax.PipeAll)
+}
+
+// pipelineLength returns the number of commands in the pipeline bin.
+func pipelineLength(bin *syntax.BinaryCmd) int {
+	length := 0
+	for _, stmt := range []*syntax.Stmt{bin.X, bin.Y} {
+		if isPipeline(stmt.Cmd) {
+			length += pipelineLength(stmt.Cmd.(*syntax.BinaryCmd))
+		} else {
+			length++
+		}
+	}
+	return length
+}
+
+// stmtLists returns the lists of statements that node holds directly.
+func stmtLists(node syntax.Node) [][]*syntax.Stmt {
+	switch n := node.(type) {
+	case *syntax.File:
+		return [][]*syntax.Stmt{n.Stmts}
+	case *syntax.Block:
+		return [][]*syntax.Stmt{n.Stmts}
+	case *syntax.Subshell:
+		return [][]*syntax.Stmt{n.Stmts}
+	case *syntax.CmdSubst:
+		return [][]*syntax.Stmt{n.Stmts}
+	case *syntax.ProcSubst:
+		return [][]*syntax.Stmt{n.Stmts}
+	case *syntax.IfClause:
+		return [][]*syntax.Stmt{n.Cond, n.Then}
+	case *syntax.WhileClause:
+		return [][]*syntax.Stmt{n.Cond, n.Do}
+	case *syntax.ForClause:
+		return [][]*syntax.Stmt{n.Do}
+	case *syntax.CaseItem:
+		return [][]*syntax.Stmt{n.Stmts}
+	}
+	return nil
+}
+
+// chainLength returns the number of commands run one after another in a list: each of its
+// statements counts the commands it joins with && and ||.
+func chainLength(list []*syntax.Stmt) int {
+	length := 0
+	for _, stmt := range list {
+		length += andOrLength(stmt)
+	}
+	return length
+}
+
+// andOrLength returns the number of pipelines stmt joins with && and ||.
+func andOrLength(stmt *syntax.Stmt) int {
+	bin, ok := stmt.Cmd.(*syntax.BinaryCmd)
+	if !ok || (bin.Op != syntax.AndStmt && bin.Op != syntax.OrStmt) {
+		return 1
+	}
+	return andOrLength(bin.X) + andOrLength(bin.Y)
+}
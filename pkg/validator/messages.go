@@ -0,0 +1,103 @@
+package validator
+
+import (
+	"strings"
+	"text/template"
+)
+
+// defaultMessageKey is the catalogue entry for rules without one of their own.
+const defaultMessageKey = "default"
+
+// MessageData is what the templates of messages.catalog are executed with.
+type MessageData struct {
+	// Command is the denied command, or empty for a denial of the whole script.
+	Command string
+	// Args are the arguments of the command, separated by spaces.
+	Args string
+	// Rule is the rule that denied the command, such as "not-allowed".
+	Rule Rule
+	// Message is the built-in English message, including the configured messages of rules.
+	Message string
+}
+
+// builtinMessages are the catalogues of the locales supported out of the box. They keep the
+// built-in message as the detail, since it carries the messages configured on rules.
+var builtinMessages = map[string]map[string]string{
+	"ja": {
+		defaultMessageKey:                "コマンド「{{.Command}}」はセキュリティポリシーにより拒否されました（{{.Message}}）",
+		string(RuleDenyCommand):          "コマンド「{{.Command}}」は禁止されています（{{.Message}}）",
+		string(RuleDenyCategory):         "コマンド「{{.Command}}」は禁止されたカテゴリに属しています（{{.Message}}）",
+		string(RuleNotAllowed):           "コマンド「{{.Command}}」は許可されていません（{{.Message}}）",
+		string(RuleDenySubCommand):       "コマンド「{{.Command}}」のサブコマンドは禁止されています（{{.Message}}）",
+		string(RuleSubCommandNotAllowed): "コマンド「{{.Command}}」のサブコマンドは許可されていません（{{.Message}}）",
+		string(RuleDenyFlag):             "コマンド「{{.Command}}」のオプションは禁止されています（{{.Message}}）",
+		string(RulePath):                 "許可されたディレクトリの外のパスは使用できません（{{.Message}}）",
+		string(RuleBinDir):               "許可された実行ファイルのディレクトリの外のコマンドは実行できません（{{.Message}}）",
+		string(RuleBuiltin):              "シェル組み込みコマンド「{{.Command}}」は禁止されています（{{.Message}}）",
+		string(RuleDangerousPattern):     "コマンド「{{.Command}}」に危険な構文が含まれています（{{.Message}}）",
+		string(RuleNestedCommand):        "コマンド「{{.Command}}」が実行するコマンドを検証できません（{{.Message}}）",
+		string(RuleExpansion):            "展開される値は実行前に検証できません（{{.Message}}）",
+		string(RuleInterpreterInput):     "インタープリタへの標準入力からのプログラムは許可されていません（{{.Message}}）",
+		string(RuleObfuscation):          "難読化されたコードの実行は許可されていません（{{.Message}}）",
+		string(RuleReadOnly):             "読み取り専用モードではコマンド「{{.Command}}」は実行できません（{{.Message}}）",
+		string(RuleRisk):                 "スクリプトのリスクが高すぎます（{{.Message}}）",
+		string(RuleBackground):           "バックグラウンドでの実行は許可されていません（{{.Message}}）",
+		string(RuleDirectory):            "作業ディレクトリが許可されていません（{{.Message}}）",
+		string(RuleParse):                "スクリプトを解析できません（{{.Message}}）",
+		string(RuleScriptLimit):          "スクリプトが大きすぎるか複雑すぎます（{{.Message}}）",
+		string(RuleSource):               "sourceで読み込むファイルを検証できません（{{.Message}}）",
+		string(RuleWriteTarget):          "このファイルへの書き込みは許可されていません（{{.Message}}）",
+		string(RuleRedirect):             "このリダイレクトはサポートされていません（{{.Message}}）",
+		string(RuleGitRemote):            "このgitリモートは許可されていません（{{.Message}}）",
+		string(RuleCustom):               "コマンド「{{.Command}}」は組織のルールにより拒否されました（{{.Message}}）",
+	},
+}
+
+// Localize returns the message of a denial by rule in the locale of messages.locale: the entry
+// for rule or else the "default" entry of the configured catalogue of the locale, then of the
+// built-in one, and otherwise message itself. The validator's own decisions and the block
+// log keep the built-in message; the validator localizes what ValidateCommand, CheckCommand,
+// CheckInvocation, and ValidateScript return, and callers of the other checks localize their
+// violations with Localize.
+func (v *CommandValidator) Localize(rule Rule, cmd string, args []string, message string) string {
+	text, ok := v.messageTemplate(rule)
+	if !ok {
+		return message
+	}
+	tmpl, err := template.New(string(rule)).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return message
+	}
+	var sb strings.Builder
+	data := MessageData{Command: cmd, Args: strings.Join(args, " "), Rule: rule, Message: message}
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return message
+	}
+	return sb.String()
+}
+
+// messageTemplate returns the template for rule in the configured locale, if there is one.
+// A configured "default" entry takes precedence over the built-in catalogue, so that a
+// deployment can word every denial its own way.
+func (v *CommandValidator) messageTemplate(rule Rule) (string, bool) {
+	locale := v.config.Messages.Locale
+	if locale == "" {
+		return "", false
+	}
+	for _, catalog := range []map[string]string{v.config.Messages.Catalog[locale], builtinMessages[locale]} {
+		for _, key := range []string{string(rule), defaultMessageKey} {
+			if text, ok := catalog[key]; ok {
+				return text, true
+			}
+		}
+	}
+	return "", false
+}
+
+// localizeDecision returns d with its message localized for cmd and args.
+func (v *CommandValidator) localizeDecision(d Decision, cmd string, args []string) Decision {
+	if !d.Allowed {
+		d.Message = v.Localize(d.Rule, cmd, args, d.Message)
+	}
+	return d
+}
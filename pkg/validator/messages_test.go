@@ -0,0 +1,115 @@
+package validator
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+)
+
+// TestLocalize tests that denial messages are taken from the catalogue of the configured locale.
+func TestLocalize(t *testing.T) {
+	dir := t.TempDir()
+	newValidator := func(messages config.MessagesConfig) *CommandValidator {
+		cfg := &config.ShellCommandConfig{
+			AllowedDirectories:  []string{dir},
+			AllowCommands:       []config.AllowCommand{{Command: "ls"}},
+			DenyCommands:        []config.DenyCommand{{Command: "rm", Message: "use trash-put"}},
+			DefaultErrorMessage: "not allowed",
+			Messages:            messages,
+		}
+		return New(cfg, logger.NewWithWriter(io.Discard))
+	}
+
+	tests := []struct {
+		name     string
+		messages config.MessagesConfig
+		cmd      string
+		want     string
+	}{
+		{name: "NoLocale", cmd: "curl", want: `command "curl" is not permitted: not allowed`},
+		{name: "UnknownLocale", messages: config.MessagesConfig{Locale: "fr"}, cmd: "curl", want: `command "curl" is not permitted: not allowed`},
+		{
+			name:     "Builtin",
+			messages: config.MessagesConfig{Locale: "ja"},
+			cmd:      "curl",
+			want:     `コマンド「curl」は許可されていません（command "curl" is not permitted: not allowed）`,
+		},
+		{
+			name:     "BuiltinKeepsRuleMessage",
+			messages: config.MessagesConfig{Locale: "ja"},
+			cmd:      "rm",
+			want:     `コマンド「rm」は禁止されています（command "rm" is denied: use trash-put）`,
+		},
+		{
+			name: "ConfiguredRule",
+			messages: config.MessagesConfig{Locale: "ja", Catalog: map[string]map[string]string{
+				"ja": {"not-allowed": "{{.Command}} {{.Args}} は使えません [{{.Rule}}]"},
+			}},
+			cmd:  "curl",
+			want: "curl -s example.com は使えません [not-allowed]",
+		},
+		{
+			name: "ConfiguredDefault",
+			messages: config.MessagesConfig{Locale: "acme", Catalog: map[string]map[string]string{
+				"acme": {"default": "ACME policy blocks {{.Command}}. Ask #platform."},
+			}},
+			cmd:  "rm",
+			want: "ACME policy blocks rm. Ask #platform.",
+		},
+		{
+			name: "FailedTemplate",
+			messages: config.MessagesConfig{Locale: "acme", Catalog: map[string]map[string]string{
+				"acme": {"default": "{{.Missing}}"},
+			}},
+			cmd:  "curl",
+			want: `command "curl" is not permitted: not allowed`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v := newValidator(tc.messages)
+			allowed, message := v.ValidateCommand(tc.cmd, []string{"-s", "example.com"}, dir)
+			if allowed {
+				t.Fatal("ValidateCommand() allowed the command")
+			}
+			if message != tc.want {
+				t.Errorf("ValidateCommand() message = %q, want %q", message, tc.want)
+			}
+		})
+	}
+}
+
+// TestLocalizeScript tests that script reports are localized once, including the violations
+// found in sourced files.
+func TestLocalizeScript(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "env.sh"), []byte("curl example.com\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories:  []string{dir},
+		AllowCommands:       []config.AllowCommand{{Command: "ls"}, {Command: "source"}},
+		DefaultErrorMessage: "not allowed",
+		Messages: config.MessagesConfig{Locale: "en", Catalog: map[string]map[string]string{
+			"en": {"default": "Blocked: {{.Message}}"},
+		}},
+	}
+	v := New(cfg, logger.NewWithWriter(io.Discard))
+
+	report := v.ValidateScript("ls; wget x; source env.sh", dir)
+	if len(report.Violations) != 2 {
+		t.Fatalf("ValidateScript() = %+v, want 2 violations", report.Violations)
+	}
+	if got := report.Violations[0].Message; got != `Blocked: command "wget" is not permitted: not allowed` {
+		t.Errorf("violation message = %q", got)
+	}
+	if got := report.Violations[1].Message; strings.Count(got, "Blocked:") != 1 || !strings.Contains(got, `command "curl" is not permitted`) {
+		t.Errorf("sourced violation message = %q, want it localized once", got)
+	}
+}
@@ -0,0 +1,427 @@
+package validator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/category"
+)
+
+// maxNestedScriptSize is the largest script file a shell may be asked to run; larger files are denied.
+const maxNestedScriptSize = 1 << 20
+
+// shellLangs maps the shells whose scripts are validated to the dialect their scripts are parsed in.
+var shellLangs = map[string]syntax.LangVariant{
+	"sh":   syntax.LangPOSIX,
+	"ash":  syntax.LangPOSIX,
+	"dash": syntax.LangPOSIX,
+	"bash": syntax.LangBash,
+	"zsh":  syntax.LangBash,
+	"ksh":  syntax.LangMirBSDKorn,
+	"mksh": syntax.LangMirBSDKorn,
+}
+
+// shellValueFlags are shell options that take a value, e.g. "-o pipefail".
+var shellValueFlags = map[string]bool{"-o": true, "+o": true, "-O": true, "+O": true, "--rcfile": true, "--init-file": true}
+
+// wrapperValueFlags maps commands that run their arguments as a command to their flags that take a value.
+var wrapperValueFlags = map[string]map[string]bool{
+	"env":     {"-u": true, "--unset": true, "-C": true, "--chdir": true},
+	"nice":    {"-n": true, "--adjustment": true},
+	"nohup":   {},
+	"timeout": {"-s": true, "--signal": true, "-k": true, "--kill-after": true},
+	"time":    {"-f": true, "--format": true, "-o": true, "--output": true},
+	"stdbuf":  {"-i": true, "--input": true, "-o": true, "--output": true, "-e": true, "--error": true},
+	"setsid":  {},
+	"ionice":  {"-c": true, "--class": true, "-n": true, "--classdata": true},
+	"sudo": {
+		"-u": true, "--user": true, "-g": true, "--group": true, "-C": true, "--close-from": true,
+		"-D": true, "--chdir": true, "-h": true, "--host": true, "-p": true, "--prompt": true,
+		"-r": true, "--role": true, "-t": true, "--type": true, "-T": true, "--command-timeout": true, "-U": true, "--other-user": true,
+	},
+	"doas": {"-u": true, "-C": true},
+	// The shell's own wrappers: "command -p ls", "exec -a name ls", and "builtin echo"
+	"command": {},
+	"exec":    {"-a": true},
+	"builtin": {},
+}
+
+// wrapperPositionals is the number of arguments some wrappers take before the command, e.g. the duration of timeout.
+var wrapperPositionals = map[string]int{"timeout": 1}
+
+// sshValueFlags are the ssh options that take a value.
+var sshValueFlags = map[string]bool{
+	"-B": true, "-b": true, "-c": true, "-D": true, "-E": true, "-e": true, "-F": true, "-I": true, "-i": true, "-J": true,
+	"-L": true, "-l": true, "-m": true, "-O": true, "-o": true, "-p": true, "-Q": true, "-R": true, "-S": true, "-W": true, "-w": true,
+}
+
+// IsNestingCommand reports whether cmd runs a command or script given in its arguments, such as
+// "sh -c", "env", "timeout", or "ssh host". Such commands are validated by what they would run.
+func IsNestingCommand(cmd string) bool {
+	_, isShell := shellLangs[cmd]
+	_, isWrapper := wrapperValueFlags[cmd]
+	return isShell || isWrapper || cmd == "ssh" || cmd == "watch"
+}
+
+// validateNestedCommand checks a command that runs another command or script given in its arguments.
+func (v *CommandValidator) validateNestedCommand(cmd string, args []string, workDir string) Decision {
+	if d := v.checkOuterCommand(cmd, args); !d.Allowed {
+		return d
+	}
+	return v.validateNestedArgs(cmd, args, workDir)
+}
+
+// validateNestedArgs checks the command nested in the arguments of cmd, once cmd itself may run.
+func (v *CommandValidator) validateNestedArgs(cmd string, args []string, workDir string) Decision {
+	_, isShell := shellLangs[cmd]
+	switch {
+	case isShell:
+		return v.validateShellCommand(cmd, args, workDir)
+	case cmd == "ssh":
+		return v.validateSSHCommand(args, workDir)
+	case cmd == "watch":
+		return v.validateWatchCommand(args, workDir)
+	}
+
+	name, nestedArgs, i, errMsg := parseWrapperArgs(cmd, args)
+	if errMsg != "" {
+		return v.deny(RuleNestedCommand, cmd, args, errMsg)
+	}
+	if d := v.validatePathArguments(cmd, args[:i], workDir); !d.Allowed {
+		return d
+	}
+	if name == "" || (cmd == "command" && isCommandLookup(args[:i])) {
+		// env alone prints the environment, and nohup and the others fail without a command;
+		// exec alone only applies its redirections, and command -v only looks the name up
+		return allowDecision
+	}
+	if v.config.DenyNestedCommands {
+		return v.denyNested(cmd, args)
+	}
+	if d := v.validateInvocation(name, nestedArgs, workDir); !d.Allowed {
+		message := fmt.Sprintf("%s would execute disallowed command: %s", cmd, d.Message)
+		return v.denyBy(d.Rule, d.Ref, cmd, args, message)
+	}
+	return allowDecision
+}
+
+// checkOuterCommand checks the deny rules, denied categories, and allowlist for the command
+// that runs the nested one.
+func (v *CommandValidator) checkOuterCommand(cmd string, args []string) Decision {
+	if denied, message, ref := v.isCommandExplicitlyDenied(cmd, args); denied {
+		return v.denyBy(RuleDenyCommand, ref, cmd, args, message)
+	}
+	if c, ok := category.Match(cmd, v.config.DenyCategories); ok {
+		message := fmt.Sprintf("command %q is denied: %s commands are not allowed", cmd, c)
+		return v.deny(RuleDenyCategory, cmd, args, message)
+	}
+	if _, ok := category.Match(cmd, v.config.AllowCategories); !ok && !v.config.IsCommandAllowed(cmd) {
+		return v.denyNotPermitted(cmd, args)
+	}
+	return allowDecision
+}
+
+// denyNested denies a command because denyNestedCommands forbids running nested commands.
+func (v *CommandValidator) denyNested(cmd string, args []string) Decision {
+	return v.deny(RuleNestedCommand, cmd, args, fmt.Sprintf("%s runs a nested command, which is not allowed", cmd))
+}
+
+// validateShellCommand checks the script a shell runs, given with -c or as a script file.
+func (v *CommandValidator) validateShellCommand(cmd string, args []string, workDir string) Decision {
+	script, file, errMsg := parseShellArgs(cmd, args)
+	if errMsg != "" {
+		return v.deny(RuleNestedCommand, cmd, args, errMsg)
+	}
+	if v.config.DenyNestedCommands {
+		return v.denyNested(cmd, args)
+	}
+
+	if file != "" {
+		if d := v.validatePathArguments(cmd, []string{file}, workDir); !d.Allowed {
+			return d
+		}
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(workDir, file)
+		}
+		content, err := readScriptFile(file)
+		if err != nil {
+			return v.deny(RuleNestedCommand, cmd, args, fmt.Sprintf("%s: cannot validate script %s: %v", cmd, file, err))
+		}
+		script = content
+	}
+	return v.validateNestedScript(cmd, args, script, shellLangs[cmd], workDir)
+}
+
+// validateSSHCommand checks the command ssh runs on the remote host, which is parsed as a shell script.
+func (v *CommandValidator) validateSSHCommand(args []string, workDir string) Decision {
+	remote, i, errMsg := parseSSHArgs(args)
+	if errMsg != "" {
+		return v.deny(RuleNestedCommand, "ssh", args, errMsg)
+	}
+	// Options such as -i and -F name local files
+	if d := v.validatePathArguments("ssh", args[:i], workDir); !d.Allowed {
+		return d
+	}
+	if v.config.DenyNestedCommands {
+		return v.denyNested("ssh", args)
+	}
+	return v.validateNestedScript("ssh", args, remote, syntax.LangPOSIX, workDir)
+}
+
+// validateWatchCommand checks the command watch runs repeatedly. Unless -x is given,
+// watch runs its arguments joined by spaces with sh -c.
+func (v *CommandValidator) validateWatchCommand(args []string, workDir string) Decision {
+	execDirect := false
+	i := 0
+	for ; i < len(args) && strings.HasPrefix(args[i], "-"); i++ {
+		switch args[i] {
+		case "-x", "--exec":
+			execDirect = true
+		case "-n", "--interval", "-q", "--equexit":
+			i++
+		}
+	}
+	if i >= len(args) {
+		return v.deny(RuleNestedCommand, "watch", args, "watch: missing command")
+	}
+	if v.config.DenyNestedCommands {
+		return v.denyNested("watch", args)
+	}
+	if !execDirect {
+		return v.validateNestedScript("watch", args, strings.Join(args[i:], " "), syntax.LangPOSIX, workDir)
+	}
+	if d := v.validateInvocation(args[i], args[i+1:], workDir); !d.Allowed {
+		return v.denyBy(d.Rule, d.Ref, "watch", args, "watch would execute disallowed command: "+d.Message)
+	}
+	return allowDecision
+}
+
+// validateNestedScript checks every command and redirection in a script run by another
+// process. The runner cannot intercept such commands, so every command name, argument,
+// and redirection target must be known statically.
+func (v *CommandValidator) validateNestedScript(cmd string, args []string, script string, lang syntax.LangVariant, workDir string) Decision {
+	if message := v.scriptSizeMessage(script); message != "" {
+		return v.deny(RuleScriptLimit, cmd, args, fmt.Sprintf("%s: nested %s", cmd, message))
+	}
+	prog, err := ParseScript(script, lang)
+	if err != nil {
+		return v.deny(RuleNestedCommand, cmd, args, fmt.Sprintf("%s: cannot parse nested script: %v", cmd, err))
+	}
+	if _, message := v.complexityMessage(prog); message != "" {
+		return v.deny(RuleScriptLimit, cmd, args, fmt.Sprintf("%s: nested %s", cmd, message))
+	}
+
+	result := allowDecision
+	syntax.Walk(prog, func(node syntax.Node) bool {
+		if !result.Allowed {
+			return false
+		}
+		if message := v.backgroundMessage(node); message != "" {
+			result = v.deny(RuleBackground, cmd, args, fmt.Sprintf("%s: %s", cmd, message))
+			return false
+		}
+		var words []*syntax.Word
+		switch n := node.(type) {
+		case *syntax.CallExpr:
+			words = n.Args
+		case *syntax.DeclClause:
+			words = []*syntax.Word{{Parts: []syntax.WordPart{&syntax.Lit{Value: n.Variant.Value}}}}
+		case *syntax.Redirect:
+			result = v.checkNestedRedirect(cmd, args, n, workDir)
+			return true
+		default:
+			return true
+		}
+		if len(words) == 0 {
+			return true
+		}
+
+		nested := make([]string, 0, len(words))
+		for _, word := range words {
+			value, ok := literalWord(word)
+			if !ok {
+				message := fmt.Sprintf("%s: nested command cannot be validated because it uses expansions: %s", cmd, script)
+				result = v.deny(RuleNestedCommand, cmd, args, message)
+				return false
+			}
+			nested = append(nested, value)
+		}
+		if d := v.validateInvocation(nested[0], nested[1:], workDir); !d.Allowed {
+			result = v.denyBy(d.Rule, d.Ref, cmd, args, fmt.Sprintf("%s would execute disallowed command: %s", cmd, d.Message))
+		}
+		return true
+	})
+	if !result.Allowed {
+		return result
+	}
+	if violations := v.CheckObfuscation(prog); len(violations) > 0 {
+		return v.deny(violations[0].Rule, cmd, args, fmt.Sprintf("%s: nested script: %s", cmd, violations[0].Message))
+	}
+	if violations := v.CheckRules(prog, workDir); len(violations) > 0 {
+		return v.deny(violations[0].Rule, cmd, args, fmt.Sprintf("%s: nested script: %s", cmd, violations[0].Message))
+	}
+	return result
+}
+
+// checkNestedRedirect checks that a file redirection in a nested script stays within the allowed directories.
+func (v *CommandValidator) checkNestedRedirect(cmd string, args []string, redirect *syntax.Redirect, workDir string) Decision {
+	switch redirect.Op {
+	case syntax.DplIn, syntax.DplOut, syntax.Hdoc, syntax.DashHdoc, syntax.WordHdoc:
+		// Duplicating a descriptor and here-documents do not open files
+		return allowDecision
+	}
+	if redirect.Word == nil {
+		return allowDecision
+	}
+	target, ok := literalWord(redirect.Word)
+	if !ok {
+		message := fmt.Sprintf("%s: nested redirection cannot be validated because it uses expansions", cmd)
+		return v.deny(RuleNestedCommand, cmd, args, message)
+	}
+	if isWriteRedirect(redirect.Op) {
+		if allowed, message := v.CheckWriteTarget(target, workDir); !allowed {
+			return v.deny(RuleWriteTarget, cmd, args, message)
+		}
+		return allowDecision
+	}
+	if allowed, message := v.IsPathInAllowedDirectory(target, workDir); !allowed {
+		return v.deny(RulePath, cmd, args, message)
+	}
+	return allowDecision
+}
+
+// isWriteRedirect reports whether a redirection operator opens its file for writing.
+func isWriteRedirect(op syntax.RedirOperator) bool {
+	switch op {
+	case syntax.RdrOut, syntax.AppOut, syntax.ClbOut, syntax.RdrAll, syntax.AppAll, syntax.RdrInOut:
+		return true
+	}
+	return false
+}
+
+// parseShellArgs returns the script given to a shell with -c, or the script file it runs.
+func parseShellArgs(cmd string, args []string) (script string, file string, errMsg string) {
+	hasC := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			i++
+		case shellValueFlags[arg]:
+			i++
+			continue
+		case strings.HasPrefix(arg, "--"):
+			continue
+		case len(arg) > 1 && (arg[0] == '-' || arg[0] == '+'):
+			// Options may be combined, e.g. "-ec"
+			if arg[0] == '-' && strings.ContainsRune(arg[1:], 'c') {
+				hasC = true
+			}
+			if strings.ContainsRune(arg[1:], 's') {
+				return "", "", cmd + ": reading commands from standard input cannot be validated"
+			}
+			continue
+		}
+		if i >= len(args) {
+			break
+		}
+		if hasC {
+			return args[i], "", ""
+		}
+		return "", args[i], ""
+	}
+	if hasC {
+		return "", "", cmd + ": missing script after -c"
+	}
+	return "", "", cmd + ": reading commands from standard input cannot be validated"
+}
+
+// isCommandLookup reports whether the options of the command builtin include -v or -V, with
+// which it describes the command named instead of running it.
+func isCommandLookup(options []string) bool {
+	for _, opt := range options {
+		if strings.HasPrefix(opt, "-") && !strings.HasPrefix(opt, "--") && strings.ContainsAny(opt, "vV") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseWrapperArgs skips the options and positional arguments of a wrapper such as env or
+// timeout and returns the command it runs with its arguments, and the index of the command
+// in args. The name is empty when the wrapper is given no command.
+func parseWrapperArgs(cmd string, args []string) (string, []string, int, string) {
+	valueFlags := wrapperValueFlags[cmd]
+	positionals := wrapperPositionals[cmd]
+	i := 0
+	for i < len(args) {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			i++
+		case cmd == "env" && (arg == "-S" || arg == "--split-string" || strings.HasPrefix(arg, "--split-string=") ||
+			(strings.HasPrefix(arg, "-S") && !strings.HasPrefix(arg, "--"))):
+			return "", nil, 0, "env: -S cannot be validated"
+		case (cmd == "sudo" || cmd == "doas") && (arg == "-s" || arg == "-i" || arg == "--shell" || arg == "--login"):
+			return "", nil, 0, cmd + ": shell sessions cannot be validated"
+		case valueFlags[arg]:
+			i += 2
+			continue
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			i++
+			continue
+		case cmd == "env" && strings.Contains(arg, "="):
+			// NAME=value assignments precede the command
+			i++
+			continue
+		case positionals > 0:
+			positionals--
+			i++
+			continue
+		}
+		break
+	}
+	if i >= len(args) {
+		return "", nil, len(args), ""
+	}
+	return args[i], args[i+1:], i, ""
+}
+
+// parseSSHArgs returns the remote command of an ssh invocation, joined as ssh joins it,
+// and the index of the host in args.
+func parseSSHArgs(args []string) (string, int, string) {
+	i := 0
+	for i < len(args) && strings.HasPrefix(args[i], "-") {
+		if sshValueFlags[args[i]] {
+			i++
+		}
+		i++
+	}
+	if i >= len(args) {
+		return "", i, "ssh: missing host"
+	}
+	if i+1 >= len(args) {
+		return "", i, "ssh: interactive sessions cannot be validated; give a remote command"
+	}
+	return strings.Join(args[i+1:], " "), i, ""
+}
+
+// readScriptFile reads a script file a shell is asked to run.
+func readScriptFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.Size() > maxNestedScriptSize {
+		return "", fmt.Errorf("script is larger than %d bytes", maxNestedScriptSize)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
@@ -0,0 +1,92 @@
+package validator
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestParseShellArgs tests extraction of the script a shell runs.
+func TestParseShellArgs(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantScript string
+		wantFile   string
+		wantErr    bool
+	}{
+		{"DashC", []string{"-c", "echo hi", "name"}, "echo hi", "", false},
+		{"CombinedFlags", []string{"-xec", "ls"}, "ls", "", false},
+		{"OptionWithValue", []string{"-o", "pipefail", "-c", "ls"}, "ls", "", false},
+		{"LongOption", []string{"--norc", "-c", "ls"}, "ls", "", false},
+		{"ScriptFile", []string{"-e", "build.sh", "arg"}, "", "build.sh", false},
+		{"DoubleDash", []string{"--", "-script.sh"}, "", "-script.sh", false},
+		{"MissingScript", []string{"-c"}, "", "", true},
+		{"Stdin", []string{"-s"}, "", "", true},
+		{"Interactive", nil, "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script, file, errMsg := parseShellArgs("sh", tt.args)
+			if (errMsg != "") != tt.wantErr {
+				t.Fatalf("parseShellArgs() errMsg = %q, wantErr %v", errMsg, tt.wantErr)
+			}
+			if script != tt.wantScript {
+				t.Errorf("parseShellArgs() script = %q, want %q", script, tt.wantScript)
+			}
+			if file != tt.wantFile {
+				t.Errorf("parseShellArgs() file = %q, want %q", file, tt.wantFile)
+			}
+		})
+	}
+}
+
+// TestParseWrapperArgs tests extraction of the command a wrapper runs.
+func TestParseWrapperArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		cmd      string
+		args     []string
+		wantName string
+		wantArgs []string
+		wantErr  bool
+	}{
+		{"Env", "env", []string{"-u", "HOME", "A=1", "ls", "-l"}, "ls", []string{"-l"}, false},
+		{"EnvNoCommand", "env", []string{"A=1"}, "", nil, false},
+		{"EnvSplitString", "env", []string{"-Sls -l"}, "", nil, true},
+		{"Timeout", "timeout", []string{"-k", "5", "10s", "sleep", "20"}, "sleep", []string{"20"}, false},
+		{"Nice", "nice", []string{"-n", "10", "make"}, "make", []string{}, false},
+		{"DoubleDash", "nohup", []string{"--", "-weird"}, "-weird", []string{}, false},
+		{"SudoShell", "sudo", []string{"-s"}, "", nil, true},
+		{"CommandPortablePath", "command", []string{"-p", "rm", "x"}, "rm", []string{"x"}, false},
+		{"ExecArgv0", "exec", []string{"-a", "login", "-cl", "rm", "x"}, "rm", []string{"x"}, false},
+		{"ExecRedirectionsOnly", "exec", nil, "", nil, false},
+		{"Builtin", "builtin", []string{"echo", "hi"}, "echo", []string{"hi"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, args, _, errMsg := parseWrapperArgs(tt.cmd, tt.args)
+			if (errMsg != "") != tt.wantErr {
+				t.Fatalf("parseWrapperArgs() errMsg = %q, wantErr %v", errMsg, tt.wantErr)
+			}
+			if name != tt.wantName {
+				t.Errorf("parseWrapperArgs() name = %q, want %q", name, tt.wantName)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("parseWrapperArgs() args = %q, want %q", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+// TestParseSSHArgs tests extraction of the remote command of ssh.
+func TestParseSSHArgs(t *testing.T) {
+	remote, host, errMsg := parseSSHArgs([]string{"-i", "key", "-v", "user@host", "ls", "-l"})
+	if errMsg != "" || remote != "ls -l" || host != 3 {
+		t.Errorf("parseSSHArgs() = %q, %d, %q", remote, host, errMsg)
+	}
+	if _, _, errMsg := parseSSHArgs([]string{"host"}); errMsg == "" {
+		t.Error("parseSSHArgs() accepted an interactive session")
+	}
+}
@@ -0,0 +1,237 @@
+package validator
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+// decoders maps the commands that decode data to the options that make them decode, or to
+// nil for those that always do. Single-letter options also count combined, as in "base64 -di".
+var decoders = map[string][]string{
+	"base64":   {"-d", "--decode", "-D"},
+	"base32":   {"-d", "--decode"},
+	"basenc":   {"-d", "--decode"},
+	"xxd":      {"-r", "-revert"},
+	"openssl":  {"-d"},
+	"uudecode": nil,
+	"gzip":     {"-d", "--decompress"},
+	"bzip2":    {"-d", "--decompress"},
+	"xz":       {"-d", "--decompress"},
+	"gunzip":   nil,
+	"zcat":     nil,
+	"bzcat":    nil,
+	"xzcat":    nil,
+	"rev":      nil,
+}
+
+// codeRunners are the builtins that run their arguments as code, besides shells and interpreters.
+var codeRunners = map[string]bool{"eval": true, "source": true, ".": true}
+
+// CheckObfuscation finds code that is decoded, piped, or given inline to an interpreter before
+// it runs, as configured by obfuscation: decoded data run as code ("base64 -d | sh", "eval
+// "$(... | base64 -d)""), the output of a command piped into a shell or interpreter ("echo ...
+// | bash"), and inline programs ("python3 -c", "perl -e"). Under ObfuscationActionDeny each is a
+// violation; under ObfuscationActionFlag each is logged and the script may run.
+func (v *CommandValidator) CheckObfuscation(prog *syntax.File) []Violation {
+	o := v.config.Obfuscation
+	if o.Action == "" {
+		return nil
+	}
+
+	var violations []Violation
+	report := func(stmt syntax.Node, cmd string, args []string, message string) {
+		if o.Action == config.ObfuscationActionFlag {
+			v.logger.LogInfof("Flagged obfuscated execution at %s: %s", stmt.Pos(), message)
+			return
+		}
+		v.logBlockedCommand(RuleObfuscation, cmd, args, message)
+		violations = append(violations, Violation{
+			Command: cmd,
+			Args:    args,
+			Line:    stmt.Pos().Line(),
+			Column:  stmt.Pos().Col(),
+			Rule:    RuleObfuscation,
+			Message: message,
+		})
+	}
+
+	// The statements of pipelines already checked as a whole
+	inPipeline := make(map[*syntax.Stmt]bool)
+	syntax.Walk(prog, func(node syntax.Node) bool {
+		switch n := node.(type) {
+		case *syntax.Stmt:
+			if inPipeline[n] {
+				return true
+			}
+			stages := pipelineStages(n, inPipeline)
+			for i := 1; i < len(stages); i++ {
+				call, ok := stages[i].Cmd.(*syntax.CallExpr)
+				if !ok {
+					continue
+				}
+				cmd, args, ok := codeCommand(call)
+				if !ok || !readsCodeFromStdin(cmd, args) {
+					continue
+				}
+				switch {
+				case slices.ContainsFunc(stages[:i], containsDecoder[*syntax.Stmt]) && o.Detects(config.ObfuscationDecodeExec):
+					report(stages[i], cmd, args, fmt.Sprintf("%s runs decoded data piped into it as code", cmd))
+				case o.Detects(config.ObfuscationPipeExec):
+					report(stages[i], cmd, args, fmt.Sprintf("%s runs the output of %s piped into it as code", cmd, describeStmt(stages[i-1])))
+				}
+			}
+		case *syntax.CallExpr:
+			cmd, args, ok := codeCommand(n)
+			if !ok {
+				return true
+			}
+			if o.Detects(config.ObfuscationDecodeExec) && slices.ContainsFunc(n.Args[1:], containsDecoder[*syntax.Word]) {
+				report(n, cmd, args, fmt.Sprintf("%s runs decoded data from a substitution as code", cmd))
+			}
+			if flag := inlineFlag(cmd, args); flag != "" && o.Detects(config.ObfuscationInlineCode) && !slices.Contains(o.AllowInline, cmd) {
+				report(n, cmd, args, fmt.Sprintf("%s runs an inline program given with %s", cmd, flag))
+			}
+		}
+		return true
+	})
+	return violations
+}
+
+// pipelineStages returns the stages of the pipeline of stmt, or nothing if stmt is not a
+// pipeline, and marks the statements making it up in seen.
+func pipelineStages(stmt *syntax.Stmt, seen map[*syntax.Stmt]bool) []*syntax.Stmt {
+	bin, ok := stmt.Cmd.(*syntax.BinaryCmd)
+	if !ok || (bin.Op != syntax.Pipe && bin.Op != syntax.PipeAll) {
+		return nil
+	}
+	var stages []*syntax.Stmt
+	for _, side := range []*syntax.Stmt{bin.X, bin.Y} {
+		seen[side] = true
+		if nested := pipelineStages(side, seen); nested != nil {
+			stages = append(stages, nested...)
+		} else {
+			stages = append(stages, side)
+		}
+	}
+	return stages
+}
+
+// codeCommand returns the command that call runs, looking through wrappers such as timeout,
+// with the literal values of its arguments; expanded arguments are left empty. It is false
+// when the command is not a shell, an interpreter, or a builtin running its arguments as code.
+func codeCommand(call *syntax.CallExpr) (string, []string, bool) {
+	if len(call.Args) == 0 {
+		return "", nil, false
+	}
+	name, ok := literalWord(call.Args[0])
+	if !ok {
+		return "", nil, false
+	}
+	args := make([]string, 0, len(call.Args)-1)
+	for _, word := range call.Args[1:] {
+		arg, _ := literalWord(word)
+		args = append(args, arg)
+	}
+	cmd := NormalizeCommandName(name)
+	for {
+		if _, ok := wrapperValueFlags[cmd]; !ok {
+			break
+		}
+		inner, innerArgs, _, errMsg := parseWrapperArgs(cmd, args)
+		if inner == "" || errMsg != "" {
+			return "", nil, false
+		}
+		cmd, args = NormalizeCommandName(inner), innerArgs
+	}
+	_, shell := shellLangs[cmd]
+	_, interpreter := interpreters[cmd]
+	return cmd, args, shell || interpreter || codeRunners[cmd]
+}
+
+// readsCodeFromStdin reports whether cmd, a shell, interpreter, or code-running builtin, runs
+// the code it reads from standard input when given args.
+func readsCodeFromStdin(cmd string, args []string) bool {
+	if codeRunners[cmd] {
+		return cmd != "eval" && len(args) > 0 && (args[0] == "/dev/stdin" || args[0] == "-")
+	}
+	if in, ok := interpreters[cmd]; ok {
+		return in.readsProgramFromStdin(args)
+	}
+	script, file, _ := parseShellArgs(cmd, args)
+	return script == "" && (file == "" || file == "/dev/stdin" || file == "-")
+}
+
+// inlineFlag returns the option giving an interpreter its program in the arguments, or "".
+// python's -m runs an installed module rather than code in the arguments, and shells' -c
+// scripts are validated like any other script.
+func inlineFlag(cmd string, args []string) string {
+	in, ok := interpreters[cmd]
+	if !ok {
+		return ""
+	}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-" || arg == "--" || !strings.HasPrefix(arg, "-"):
+			return ""
+		case in.valueFlags[arg]:
+			i++
+		default:
+			for _, flag := range in.inline {
+				if arg == flag || (!strings.HasPrefix(flag, "--") && strings.HasPrefix(arg, flag)) {
+					if flag == "-m" {
+						return ""
+					}
+					return flag
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// containsDecoder reports whether node runs a command that decodes data, e.g. base64 -d.
+func containsDecoder[N syntax.Node](node N) bool {
+	found := false
+	syntax.Walk(node, func(n syntax.Node) bool {
+		if call, ok := n.(*syntax.CallExpr); ok && !found && len(call.Args) > 0 {
+			name, _ := literalWord(call.Args[0])
+			var args []string
+			for _, word := range call.Args[1:] {
+				arg, _ := literalWord(word)
+				args = append(args, arg)
+			}
+			found = decodes(NormalizeCommandName(name), args)
+		}
+		return !found
+	})
+	return found
+}
+
+// decodes reports whether cmd invoked with args decodes data.
+func decodes(cmd string, args []string) bool {
+	options, ok := decoders[cmd]
+	if !ok {
+		return false
+	}
+	if options == nil {
+		return true
+	}
+	for _, arg := range args {
+		for _, option := range options {
+			if arg == option {
+				return true
+			}
+			// Single-letter options may be combined, e.g. "-di"
+			if len(option) == 2 && len(arg) > 2 && arg[0] == '-' && arg[1] != '-' && strings.ContainsRune(arg[1:], rune(option[1])) {
+				return true
+			}
+		}
+	}
+	return false
+}
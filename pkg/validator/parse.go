@@ -0,0 +1,88 @@
+package validator
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"strings"
+	"sync"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+const (
+	// parseCacheSize is the number of parsed scripts kept for reuse.
+	parseCacheSize = 1024
+	// maxCachedScript is the size of the largest script that is cached; larger scripts are rare
+	// and would hold on to much memory.
+	maxCachedScript = 64 * 1024
+)
+
+// parserPools holds idle parsers of each dialect. A parser allocates sizeable buffers, which
+// dominate the cost of parsing the short commands the runner usually sees.
+var parserPools sync.Map // syntax.LangVariant -> *sync.Pool
+
+// parseCacheKey identifies a script and the dialect it is parsed in.
+type parseCacheKey [sha256.Size]byte
+
+// parseResult is a cached parse of a script.
+type parseResult struct {
+	key  parseCacheKey
+	prog *syntax.File
+	err  error
+}
+
+// parseCache keeps the results of the most recently parsed scripts. Callers that run the same
+// commands over and over, such as agents polling for status, parse each only once.
+var parseCache = struct {
+	mu      sync.Mutex
+	entries map[parseCacheKey]*list.Element
+	order   *list.List
+}{entries: make(map[parseCacheKey]*list.Element), order: list.New()}
+
+// ParseScript parses a script in lang. Results are cached by the script's hash and shared
+// between callers, which must not modify the returned program.
+func ParseScript(script string, lang syntax.LangVariant) (*syntax.File, error) {
+	if len(script) > maxCachedScript {
+		return parse(script, lang)
+	}
+
+	h := sha256.New()
+	h.Write([]byte(lang.String()))
+	h.Write([]byte{0})
+	h.Write([]byte(script))
+	var key parseCacheKey
+	h.Sum(key[:0])
+
+	parseCache.mu.Lock()
+	if elem, ok := parseCache.entries[key]; ok {
+		parseCache.order.MoveToFront(elem)
+		result := elem.Value.(*parseResult) //nolint:forcetypeassert // the cache only holds parseResults
+		parseCache.mu.Unlock()
+		return result.prog, result.err
+	}
+	parseCache.mu.Unlock()
+
+	prog, err := parse(script, lang)
+
+	parseCache.mu.Lock()
+	defer parseCache.mu.Unlock()
+	if _, ok := parseCache.entries[key]; !ok {
+		parseCache.entries[key] = parseCache.order.PushFront(&parseResult{key: key, prog: prog, err: err})
+		if parseCache.order.Len() > parseCacheSize {
+			oldest := parseCache.order.Back()
+			parseCache.order.Remove(oldest)
+			delete(parseCache.entries, oldest.Value.(*parseResult).key) //nolint:forcetypeassert // the cache only holds parseResults
+		}
+	}
+	return prog, err
+}
+
+// parse parses a script in lang with a pooled parser.
+func parse(script string, lang syntax.LangVariant) (*syntax.File, error) {
+	pool, _ := parserPools.LoadOrStore(lang, &sync.Pool{
+		New: func() any { return syntax.NewParser(syntax.Variant(lang)) },
+	})
+	parser := pool.(*sync.Pool).Get().(*syntax.Parser) //nolint:forcetypeassert // the pools only hold parsers
+	defer pool.(*sync.Pool).Put(parser)
+	return parser.Parse(strings.NewReader(script), "")
+}
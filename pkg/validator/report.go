@@ -0,0 +1,330 @@
+package validator
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/risk"
+)
+
+// Rule identifies the part of the policy that produced a decision.
+type Rule string
+
+const (
+	// RuleDenyCommand means the command is listed in denyCommands.
+	RuleDenyCommand Rule = "deny-command"
+	// RuleDenyCategory means the command belongs to a category listed in denyCategories.
+	RuleDenyCategory Rule = "deny-category"
+	// RuleNotAllowed means the command is not listed in allowCommands.
+	RuleNotAllowed Rule = "not-allowed"
+	// RuleDenySubCommand means the subcommand is listed in denySubCommands.
+	RuleDenySubCommand Rule = "deny-subcommand"
+	// RuleSubCommandNotAllowed means the subcommand is not listed in subCommands.
+	RuleSubCommandNotAllowed Rule = "subcommand-not-allowed"
+	// RuleDenyFlag means a flag is listed in denyFlags.
+	RuleDenyFlag Rule = "deny-flag"
+	// RulePath means a path argument is outside the allowed directories.
+	RulePath Rule = "path"
+	// RuleBinDir means a command is invoked by a path outside allowedBinDirs.
+	RuleBinDir Rule = "bin-dir"
+	// RuleBuiltin means a shell builtin is denied by the builtins policy.
+	RuleBuiltin Rule = "builtin"
+	// RuleDangerousPattern means an awk or sed script uses a dangerous construct.
+	RuleDangerousPattern Rule = "dangerous-pattern"
+	// RuleNestedCommand means a command run by a shell, wrapper, xargs, or find -exec could not be
+	// validated, or denyNestedCommands forbids running it.
+	RuleNestedCommand Rule = "nested-command"
+	// RuleExpansion means a command name, or an argument of a command marked literalArgs, comes from
+	// an expansion that cannot be validated before the script runs.
+	RuleExpansion Rule = "expansion"
+	// RuleInterpreterInput means an interpreter such as python reads a program from standard input
+	// that interpreterInput forbids or that cannot be validated.
+	RuleInterpreterInput Rule = "interpreter-input"
+	// RuleObfuscation means code is decoded, piped, or given inline to an interpreter in a way
+	// obfuscation is configured to deny.
+	RuleObfuscation Rule = "obfuscation"
+	// RuleReadOnly means read-only mode is enabled and the command is not marked readOnly.
+	RuleReadOnly Rule = "read-only"
+	// RuleRisk means the script's risk score exceeds the configured threshold.
+	RuleRisk Rule = "risk"
+	// RuleBackground means a command would run in the background, in a coprocess, or in a process
+	// substitution that allowBackground or allowProcessSubstitution does not permit.
+	RuleBackground Rule = "background"
+	// RuleDirectory means the working directory is outside the allowed directories.
+	RuleDirectory Rule = "directory"
+	// RuleParse means the script itself could not be parsed.
+	RuleParse Rule = "parse"
+	// RuleScriptLimit means the script is larger or more complex than scriptLimits permits.
+	RuleScriptLimit Rule = "script-limit"
+	// RuleSource means a file run with source or . cannot be read, or is sourced more than
+	// scriptLimits.maxSourceDepth levels deep, or sources a file named by an expansion.
+	RuleSource Rule = "source"
+	// RuleWriteTarget means a redirection or tee would write to a file outside the allowed
+	// directories, in readOnlyDirectories, /proc, or /sys, or to a device not in writableDevices.
+	RuleWriteTarget Rule = "write-target"
+	// RuleRedirect means a redirection uses a form the interpreter cannot perform, such as >|.
+	RuleRedirect Rule = "redirect"
+	// RuleGitRemote means git would contact or configure a remote whose URL does not match
+	// git.allowedRemotes.
+	RuleGitRemote Rule = "git-remote"
+	// RuleCustom means a rule registered with RegisterRuleChecker denied the script.
+	RuleCustom Rule = "custom"
+)
+
+// Decision is the outcome of validating a single command.
+type Decision struct {
+	Allowed bool
+	Rule    Rule
+	Message string
+	// Ref is the allowCommands or denyCommands entry behind a denial, if one is.
+	Ref *RuleRef
+}
+
+// RuleRef identifies the entry of the configuration that denied a command.
+type RuleRef struct {
+	// ID is the rule's id, or one derived from it (see config.DenyCommand.RuleID).
+	ID string `json:"id"`
+	// List is "denyCommands" or "allowCommands", and Index the position of the rule in it.
+	List  string `json:"list"`
+	Index int    `json:"index"`
+	// Source is the configuration file or URL the rule was loaded from, if known.
+	Source  string `json:"source,omitempty"`
+	DocsURL string `json:"docsUrl,omitempty"`
+	// explicit is set when the rule names itself, comes from a file, or links to documentation,
+	// which is when denials quote it
+	explicit bool
+}
+
+// denyRuleRef returns the reference to the index-th deny rule of the configuration.
+func denyRuleRef(rule config.DenyCommand, index int) *RuleRef {
+	return &RuleRef{
+		ID: rule.RuleID(), List: "denyCommands", Index: index, Source: rule.Source, DocsURL: rule.DocsURL,
+		explicit: rule.ID != "" || rule.Source != "" || rule.DocsURL != "",
+	}
+}
+
+// allowRuleRef returns the reference to the index-th allow rule of the configuration.
+func allowRuleRef(rule config.AllowCommand, index int) *RuleRef {
+	return &RuleRef{
+		ID: rule.RuleID(), List: "allowCommands", Index: index, Source: rule.Source, DocsURL: rule.DocsURL,
+		explicit: rule.ID != "" || rule.Source != "" || rule.DocsURL != "",
+	}
+}
+
+// String formats the reference as `rule "deny:rm" (denyCommands[0] in policy.json)`,
+// followed by the documentation URL if there is one.
+func (r RuleRef) String() string {
+	s := fmt.Sprintf("rule %q (%s[%d]", r.ID, r.List, r.Index)
+	if r.Source != "" {
+		s += " in " + r.Source
+	}
+	s += ")"
+	if r.DocsURL != "" {
+		s += ", see " + r.DocsURL
+	}
+	return s
+}
+
+// annotate appends the reference to a denial message when the rule was given an ID, a
+// source, or documentation, so that users can tell which rule blocked them.
+func (r *RuleRef) annotate(message string) string {
+	if r == nil || !r.explicit {
+		return message
+	}
+	return message + " [" + r.String() + "]"
+}
+
+// allowDecision is returned when a command passes validation.
+var allowDecision = Decision{Allowed: true}
+
+// Violation describes a single command in a script that the policy rejects.
+type Violation struct {
+	Command string
+	Args    []string
+	Line    uint
+	Column  uint
+	Rule    Rule
+	Message string
+	// Ref is the allowCommands or denyCommands entry behind the violation, if one is.
+	Ref *RuleRef
+}
+
+// String formats the violation as "line:column: message".
+func (v Violation) String() string {
+	return fmt.Sprintf("%d:%d: %s", v.Line, v.Column, v.Message)
+}
+
+// ValidationReport lists every violation found in a script and its risk assessment.
+type ValidationReport struct {
+	Violations []Violation
+	Risk       risk.Assessment
+}
+
+// Valid reports whether the script has no violations.
+func (r ValidationReport) Valid() bool {
+	return len(r.Violations) == 0
+}
+
+// Err returns an error describing all violations, or nil if the script is valid.
+func (r ValidationReport) Err() error {
+	if r.Valid() {
+		return nil
+	}
+	errs := make([]error, 0, len(r.Violations))
+	for _, violation := range r.Violations {
+		errs = append(errs, errors.New(violation.String()))
+	}
+	return errors.Join(errs...)
+}
+
+// ValidateScript parses a script in the configured dialect and validates every command in it,
+// collecting all violations instead of stopping at the first one. Words that depend on expansions
+// cannot be resolved statically and are left for validation when the script runs, unless the
+// expansion policy rejects them (see CheckExpansions). Like CheckCommand it has no side effects:
+// violations are not written to the block log. Callers that deny a run because of the report
+// record it with LogViolation.
+func (v *CommandValidator) ValidateScript(script string, workDir string) ValidationReport {
+	return v.ValidateScriptAs(script, workDir, v.config.Lang())
+}
+
+// ValidateScriptAs is ValidateScript with the script parsed in lang.
+func (v *CommandValidator) ValidateScriptAs(script string, workDir string, lang syntax.LangVariant) ValidationReport {
+	v = v.WithWorkDir(workDir)
+	v.blockLog = nil
+	report := v.validateScript(script, workDir, lang)
+	// Sourced files are reported within the message of the source command, localized once
+	if v.sourceDepth == 0 {
+		for i, violation := range report.Violations {
+			report.Violations[i].Message = v.Localize(violation.Rule, violation.Command, violation.Args, violation.Message)
+		}
+	}
+	return report
+}
+
+// LogViolation writes a violation reported by ValidateScript to the block log.
+func (v *CommandValidator) LogViolation(violation Violation) {
+	v.logBlockedCommand(violation.Rule, violation.Command, violation.Args, violation.Message)
+}
+
+// validateScript implements ValidateScriptAs, with the built-in messages.
+func (v *CommandValidator) validateScript(script string, workDir string, lang syntax.LangVariant) ValidationReport {
+	var report ValidationReport
+
+	if violations := v.CheckScriptSize(script); len(violations) > 0 {
+		report.Violations = violations
+		return report
+	}
+	prog, err := ParseScript(script, lang)
+	if err != nil {
+		violation := Violation{Rule: RuleParse, Message: fmt.Sprintf("failed to parse script: %v", err)}
+		var parseErr syntax.ParseError
+		if errors.As(err, &parseErr) {
+			violation.Line, violation.Column = parseErr.Pos.Line(), parseErr.Pos.Col()
+		}
+		report.Violations = append(report.Violations, violation)
+		return report
+	}
+	if violations := v.CheckComplexity(prog); len(violations) > 0 {
+		report.Violations = violations
+		return report
+	}
+
+	syntax.Walk(prog, func(node syntax.Node) bool {
+		var cmd string
+		var args []string
+		switch n := node.(type) {
+		case *syntax.CallExpr:
+			if len(n.Args) == 0 {
+				return true
+			}
+			name, ok := literalWord(n.Args[0])
+			if !ok {
+				return true
+			}
+			cmd = name
+			// Stop at the first dynamic word so that subcommand positions stay accurate
+			for _, word := range n.Args[1:] {
+				arg, ok := literalWord(word)
+				if !ok {
+					break
+				}
+				args = append(args, arg)
+			}
+		case *syntax.DeclClause:
+			cmd = n.Variant.Value
+			for _, assign := range n.Args {
+				if assign.Name != nil {
+					args = append(args, assign.Name.Value)
+				}
+			}
+		default:
+			return true
+		}
+
+		if d := v.validateInvocation(cmd, args, workDir); !d.Allowed {
+			report.Violations = append(report.Violations, Violation{
+				Command: cmd,
+				Args:    args,
+				Line:    node.Pos().Line(),
+				Column:  node.Pos().Col(),
+				Rule:    d.Rule,
+				Message: d.Message,
+				Ref:     d.Ref,
+			})
+		}
+		return true
+	})
+
+	report.Violations = append(report.Violations, v.CheckExpansions(prog)...)
+	report.Violations = append(report.Violations, v.CheckInterpreterInput(prog, workDir)...)
+	report.Violations = append(report.Violations, v.CheckObfuscation(prog)...)
+	report.Violations = append(report.Violations, v.CheckBackground(prog)...)
+	report.Violations = append(report.Violations, v.CheckRedirects(prog)...)
+	report.Violations = append(report.Violations, v.CheckRules(prog, workDir)...)
+	slices.SortStableFunc(report.Violations, func(a, b Violation) int {
+		return cmp.Or(cmp.Compare(a.Line, b.Line), cmp.Compare(a.Column, b.Column))
+	})
+
+	// A script held for approval is not a violation; only the deny action rejects it outright
+	var riskDecision Decision
+	report.Risk, riskDecision = v.CheckRisk(prog)
+	if !riskDecision.Allowed && v.config.Risk.Action == config.RiskActionDeny {
+		violation := Violation{Rule: riskDecision.Rule, Message: riskDecision.Message, Line: 1, Column: 1}
+		if len(report.Risk.Findings) > 0 {
+			violation.Line, violation.Column = report.Risk.Findings[0].Line, report.Risk.Findings[0].Column
+		}
+		report.Violations = append(report.Violations, violation)
+	}
+
+	return report
+}
+
+// literalWord returns the value of a word made only of literal and quoted literal parts.
+func literalWord(word *syntax.Word) (string, bool) {
+	var sb strings.Builder
+	for _, part := range word.Parts {
+		switch p := part.(type) {
+		case *syntax.Lit:
+			sb.WriteString(p.Value)
+		case *syntax.SglQuoted:
+			sb.WriteString(p.Value)
+		case *syntax.DblQuoted:
+			for _, inner := range p.Parts {
+				lit, ok := inner.(*syntax.Lit)
+				if !ok {
+					return "", false
+				}
+				sb.WriteString(lit.Value)
+			}
+		default:
+			return "", false
+		}
+	}
+	return sb.String(), true
+}
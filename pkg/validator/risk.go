@@ -0,0 +1,27 @@
+package validator
+
+import (
+	"fmt"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/risk"
+)
+
+// CheckRisk scores a parsed script. The decision is not allowed when the score
+// exceeds the configured threshold; the caller applies the configured action.
+func (v *CommandValidator) CheckRisk(prog *syntax.File) (risk.Assessment, Decision) {
+	a := risk.Assess(prog)
+	threshold := v.config.Risk.Threshold
+	if threshold <= 0 || a.Score <= threshold {
+		return a, allowDecision
+	}
+
+	categories := make([]string, 0, len(a.Findings))
+	for _, c := range a.Categories() {
+		categories = append(categories, string(c))
+	}
+	message := fmt.Sprintf("script risk score %d exceeds the threshold of %d (%s)", a.Score, threshold, strings.Join(categories, ", "))
+	return a, Decision{Allowed: false, Rule: RuleRisk, Message: message}
+}
@@ -0,0 +1,137 @@
+package validator
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+	"sync"
+
+	"mvdan.cc/sh/v3/syntax"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
+)
+
+// RuleContext is what a RuleChecker knows about the script it checks.
+type RuleContext struct {
+	// WorkDir is the directory the script runs in.
+	WorkDir string
+	// Config is the policy the script is validated against, including the overlays of the caller.
+	Config *config.ShellCommandConfig
+	// Identity is the caller the script runs for; it is zero when the caller is unknown.
+	Identity identity.Identity
+}
+
+// RuleChecker is a custom validation rule, such as "no kubectl against prod contexts", shipped
+// as Go code and registered with RegisterRuleChecker. Check is called for every node of the
+// syntax tree of a script, before any of it runs, and returns the violations it finds at node.
+// Words that depend on expansions are only known at run time, so checkers should treat them
+// conservatively.
+type RuleChecker interface {
+	Check(node syntax.Node, ctx RuleContext) []Violation
+}
+
+// RuleCheckerFunc adapts a function to the RuleChecker interface.
+type RuleCheckerFunc func(node syntax.Node, ctx RuleContext) []Violation
+
+// Check implements RuleChecker.
+func (f RuleCheckerFunc) Check(node syntax.Node, ctx RuleContext) []Violation {
+	return f(node, ctx)
+}
+
+// ruleCheckers holds the checkers registered with RegisterRuleChecker. It is process-wide, so
+// that the rules apply to every validator, whichever server or session created it.
+var ruleCheckers = struct {
+	mu       sync.RWMutex
+	checkers map[string]RuleChecker
+	// names are the names of checkers, sorted so that they run in a stable order
+	names []string
+}{checkers: make(map[string]RuleChecker)}
+
+// RegisterRuleChecker makes checker validate every script checked in the process under name,
+// typically from an init function. Violations without a rule are reported with RuleCustom.
+// It panics if checker is nil or the name is already registered.
+func RegisterRuleChecker(name string, checker RuleChecker) {
+	ruleCheckers.mu.Lock()
+	defer ruleCheckers.mu.Unlock()
+	if checker == nil {
+		panic("validator: RegisterRuleChecker of nil checker " + name)
+	}
+	if _, dup := ruleCheckers.checkers[name]; dup {
+		panic("validator: RegisterRuleChecker called twice for " + name)
+	}
+	ruleCheckers.checkers[name] = checker
+	ruleCheckers.names = slices.Sorted(maps.Keys(ruleCheckers.checkers))
+}
+
+// RuleCheckers returns the names of the registered checkers, sorted.
+func RuleCheckers() []string {
+	ruleCheckers.mu.RLock()
+	defer ruleCheckers.mu.RUnlock()
+	return slices.Clone(ruleCheckers.names)
+}
+
+// CheckRules runs the registered checkers on every node of prog and returns their violations,
+// ordered by position. Each violation is written to the block log.
+func (v *CommandValidator) CheckRules(prog *syntax.File, workDir string) []Violation {
+	ruleCheckers.mu.RLock()
+	names := ruleCheckers.names
+	checkers := make([]RuleChecker, len(names))
+	for i, name := range names {
+		checkers[i] = ruleCheckers.checkers[name]
+	}
+	ruleCheckers.mu.RUnlock()
+	if len(checkers) == 0 {
+		return nil
+	}
+
+	v = v.WithWorkDir(workDir)
+	ctx := RuleContext{WorkDir: workDir, Config: v.config, Identity: v.identity}
+	var violations []Violation
+	syntax.Walk(prog, func(node syntax.Node) bool {
+		if node == nil {
+			return true
+		}
+		for _, checker := range checkers {
+			for _, violation := range checker.Check(node, ctx) {
+				violations = append(violations, v.completeViolation(node, violation))
+			}
+		}
+		return true
+	})
+	slices.SortStableFunc(violations, func(a, b Violation) int {
+		return cmp.Or(cmp.Compare(a.Line, b.Line), cmp.Compare(a.Column, b.Column))
+	})
+	return violations
+}
+
+// completeViolation fills in what a checker left out of a violation at node: its rule, its
+// position, and its command. The violation is written to the block log.
+func (v *CommandValidator) completeViolation(node syntax.Node, violation Violation) Violation {
+	if violation.Rule == "" {
+		violation.Rule = RuleCustom
+	}
+	if violation.Line == 0 {
+		violation.Line, violation.Column = node.Pos().Line(), node.Pos().Col()
+	}
+	if violation.Command == "" {
+		if call, ok := node.(*syntax.CallExpr); ok && len(call.Args) > 0 {
+			name, _ := literalWord(call.Args[0])
+			violation.Command = NormalizeCommandName(name)
+		} else {
+			violation.Command = commandOf(node)
+		}
+	}
+	v.logBlockedCommand(violation.Rule, violation.Command, violation.Args, violation.Message)
+	return violation
+}
+
+// LiteralWord returns the value of a word made only of literal and quoted literal parts, and
+// false for a word that depends on expansions or is nil. Rule checkers use it to read command
+// arguments.
+func LiteralWord(word *syntax.Word) (string, bool) {
+	if word == nil {
+		return "", false
+	}
+	return literalWord(word)
+}
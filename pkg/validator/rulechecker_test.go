@@ -0,0 +1,89 @@
+package validator
+
+import (
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"mvdan.cc/sh/v3/syntax"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+)
+
+// noProdKubectl denies kubectl commands against the prod context, except for the release user.
+func noProdKubectl(node syntax.Node, ctx RuleContext) []Violation {
+	call, ok := node.(*syntax.CallExpr)
+	if !ok || len(call.Args) == 0 || ctx.Identity.User == "release" {
+		return nil
+	}
+	if name, _ := LiteralWord(call.Args[0]); name != "kubectl" {
+		return nil
+	}
+	for i, word := range call.Args[1:] {
+		arg, _ := LiteralWord(word)
+		if arg == "--context=prod" || arg == "--context" && i+2 < len(call.Args) && wordIs(call.Args[i+2], "prod") {
+			return []Violation{{Message: "kubectl must not run against the prod context in " + ctx.WorkDir}}
+		}
+	}
+	return nil
+}
+
+func wordIs(word *syntax.Word, value string) bool {
+	s, ok := LiteralWord(word)
+	return ok && s == value
+}
+
+// TestCheckRules tests that registered rule checkers deny scripts, and nested scripts, before
+// they run.
+func TestCheckRules(t *testing.T) {
+	RegisterRuleChecker("test-no-prod-kubectl", RuleCheckerFunc(noProdKubectl))
+	if !slices.Contains(RuleCheckers(), "test-no-prod-kubectl") {
+		t.Fatalf("RuleCheckers() = %v, want it to contain the registered checker", RuleCheckers())
+	}
+
+	dir := t.TempDir()
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories:  []string{dir},
+		AllowCommands:       []config.AllowCommand{{Command: "sh"}, {Command: "echo"}, {Command: "kubectl"}},
+		DefaultErrorMessage: "not allowed",
+	}
+	v := New(cfg, logger.NewWithWriter(io.Discard))
+
+	report := v.ValidateScript("echo hi\nkubectl --context dev get pods", dir)
+	if !report.Valid() {
+		t.Errorf("ValidateScript() of the dev context = %+v, want it valid", report.Violations)
+	}
+
+	report = v.ValidateScript("echo hi\nkubectl get pods --context prod", dir)
+	want := Violation{
+		Command: "kubectl",
+		Line:    2,
+		Column:  1,
+		Rule:    RuleCustom,
+		Message: "kubectl must not run against the prod context in " + dir,
+	}
+	if len(report.Violations) != 1 || !reflect.DeepEqual(report.Violations[0], want) {
+		t.Errorf("ValidateScript() = %+v, want %+v", report.Violations, want)
+	}
+
+	d := v.validateInvocation("sh", []string{"-c", "kubectl --context=prod delete pod x"}, dir)
+	if d.Allowed || d.Rule != RuleCustom || !strings.Contains(d.Message, "sh: nested script: kubectl must not run") {
+		t.Errorf("validateInvocation() of a nested script = %+v, want it denied by the checker", d)
+	}
+
+	release := v.WithIdentity(identity.Identity{User: "release"})
+	if report := release.ValidateScript("kubectl --context prod get pods", dir); !report.Valid() {
+		t.Errorf("ValidateScript() for the release user = %+v, want it valid", report.Violations)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("RegisterRuleChecker() of a duplicate name did not panic")
+		}
+	}()
+	RegisterRuleChecker("test-no-prod-kubectl", RuleCheckerFunc(noProdKubectl))
+}
@@ -0,0 +1,87 @@
+package validator
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// IsSourceCommand reports whether cmd is the source builtin, under either of its names.
+func IsSourceCommand(cmd string) bool {
+	return cmd == "source" || cmd == "."
+}
+
+// ResolveSourcePath returns the absolute path of the file that `source name` reads in dir.
+// Unlike bash, names without a slash are not looked up in PATH, so that the file validated
+// is the one the interpreter reads.
+func ResolveSourcePath(name string, dir string) string {
+	if filepath.IsAbs(name) {
+		return filepath.Clean(name)
+	}
+	return filepath.Join(dir, name)
+}
+
+// validateSourceCommand checks the file read by `source file [args...]`. Its commands run in
+// the current shell, so the file must be in an allowed directory, and its contents, parsed as
+// the interpreter parses them, must pass the same validation as a script. Files it sources are
+// checked in turn, up to scriptLimits.maxSourceDepth levels deep.
+func (v *CommandValidator) validateSourceCommand(cmd string, args []string, workDir string) Decision {
+	if len(args) == 0 {
+		// The interpreter fails without reading anything
+		return allowDecision
+	}
+	path := ResolveSourcePath(args[0], workDir)
+	if allowed, message := v.IsPathInAllowedDirectory(path, workDir); !allowed {
+		return v.deny(RulePath, cmd, args, message)
+	}
+	if limit := v.config.ScriptLimits.SourceDepth(); v.sourceDepth >= limit {
+		message := fmt.Sprintf("%s %s: files are sourced more than %d levels deep (scriptLimits.maxSourceDepth)", cmd, path, limit)
+		return v.deny(RuleSource, cmd, args, message)
+	}
+	script, err := readScriptFile(path)
+	if err != nil {
+		return v.deny(RuleSource, cmd, args, fmt.Sprintf("%s: cannot validate sourced file %s: %v", cmd, path, err))
+	}
+
+	// The interpreter parses sourced files as bash, whatever the dialect of the script
+	nested := *v
+	nested.sourceDepth++
+	report := nested.ValidateScriptAs(script, workDir, syntax.LangBash)
+	if !report.Valid() {
+		violation := report.Violations[0]
+		message := fmt.Sprintf("%s %s: line %d: %s", cmd, path, violation.Line, violation.Message)
+		return v.denyBy(violation.Rule, violation.Ref, cmd, args, message)
+	}
+	if prog, err := ParseScript(script, syntax.LangBash); err == nil {
+		if call := dynamicSource(prog); call != nil {
+			message := fmt.Sprintf("%s %s: line %d: the file to source comes from an expansion and cannot be validated", cmd, path, call.Pos().Line())
+			return v.deny(RuleSource, cmd, args, message)
+		}
+	}
+	return allowDecision
+}
+
+// dynamicSource returns the first source command in prog whose file is named by an
+// expansion, or nil if there is none. A sourced file may only source files known before it
+// runs, so that the include depth stays bounded.
+func dynamicSource(prog *syntax.File) *syntax.CallExpr {
+	var found *syntax.CallExpr
+	syntax.Walk(prog, func(node syntax.Node) bool {
+		if found != nil {
+			return false
+		}
+		call, ok := node.(*syntax.CallExpr)
+		if !ok || len(call.Args) < 2 { //nolint:mnd // the command and its file
+			return true
+		}
+		if name, ok := literalWord(call.Args[0]); !ok || !IsSourceCommand(name) {
+			return true
+		}
+		if _, ok := literalWord(call.Args[1]); !ok {
+			found = call
+		}
+		return true
+	})
+	return found
+}
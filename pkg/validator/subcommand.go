@@ -0,0 +1,107 @@
+package validator
+
+import (
+	"path"
+	"strings"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+// wildcard matches any single subcommand word.
+const wildcard = "*"
+
+// subCommandMatch is the result of matching a subcommand pattern against arguments.
+type subCommandMatch struct {
+	// consumed is the number of arguments the pattern matched.
+	consumed int
+	// words is the number of words in the pattern; longer patterns are more specific.
+	words int
+	// exact is the number of words matched literally rather than by a wildcard.
+	exact int
+}
+
+// moreSpecificThan reports whether m should take precedence over other.
+func (m subCommandMatch) moreSpecificThan(other subCommandMatch) bool {
+	if m.words != other.words {
+		return m.words > other.words
+	}
+	return m.exact > other.exact
+}
+
+// matchSubCommand matches a pattern such as "compose up" or "get *" against the start of args.
+// Each pattern word matches one argument. A trailing wildcard is optional, so "get *" matches
+// "get", "get pods", and "get -A pods"; in the last case the flag is left for denyFlags.
+// A pattern consisting only of a wildcard must still match one argument.
+func matchSubCommand(pattern string, args []string) (subCommandMatch, bool) {
+	words := strings.Fields(pattern)
+	if len(words) == 0 {
+		return subCommandMatch{}, false
+	}
+
+	m := subCommandMatch{words: len(words)}
+	for i, word := range words {
+		optional := i > 0 && i == len(words)-1 && word == wildcard
+
+		var exact, ok bool
+		if i < len(args) {
+			exact, ok = matchSubCommandWord(word, args[i])
+		}
+		if !ok {
+			if optional {
+				return m, true
+			}
+			return subCommandMatch{}, false
+		}
+		if exact {
+			m.exact++
+		}
+		m.consumed++
+	}
+	return m, true
+}
+
+// matchSubCommandWord matches a single argument against a pattern word.
+// Wildcards and glob patterns never match flags, so a flag cannot be mistaken for a subcommand
+// and slip past denyFlags.
+func matchSubCommandWord(pattern, arg string) (exact bool, ok bool) {
+	if pattern == arg {
+		return true, true
+	}
+	if !strings.ContainsAny(pattern, "*?[") {
+		return false, false
+	}
+	if strings.HasPrefix(arg, "-") && !strings.HasPrefix(pattern, "-") {
+		return false, false
+	}
+	matched, err := path.Match(pattern, arg)
+	return false, err == nil && matched
+}
+
+// findDeniedSubCommand returns the first deny entry matching args and the arguments it matched.
+func findDeniedSubCommand(denySubCommands []string, args []string) (string, bool) {
+	for _, denied := range denySubCommands {
+		if m, ok := matchSubCommand(denied, args); ok {
+			return strings.Join(args[:m.consumed], " "), true
+		}
+	}
+	return "", false
+}
+
+// findSubCommandRule selects the allow rule that applies to args. More words beat fewer,
+// literal words beat wildcards, and earlier rules win ties, so precedence is deterministic.
+func findSubCommandRule(rules []config.SubCommandRule, args []string) (config.SubCommandRule, subCommandMatch, bool) {
+	var best config.SubCommandRule
+	var bestMatch subCommandMatch
+	found := false
+
+	for _, rule := range rules {
+		m, ok := matchSubCommand(rule.Name, args)
+		if !ok {
+			continue
+		}
+		if !found || m.moreSpecificThan(bestMatch) {
+			best, bestMatch, found = rule, m, true
+		}
+	}
+	return best, bestMatch, found
+}
@@ -0,0 +1,68 @@
+package validator
+
+import (
+	"testing"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+// TestMatchSubCommand tests matching of multi-word and wildcard subcommand patterns.
+func TestMatchSubCommand(t *testing.T) {
+	tests := []struct {
+		pattern      string
+		args         []string
+		wantOK       bool
+		wantConsumed int
+	}{
+		{"status", []string{"status"}, true, 1},
+		{"status", []string{"log"}, false, 0},
+		{"compose up", []string{"compose", "up", "-d"}, true, 2},
+		{"compose up", []string{"compose", "down"}, false, 0},
+		{"compose up", []string{"compose"}, false, 0},
+		{"get *", []string{"get", "pods"}, true, 2},
+		{"get *", []string{"get"}, true, 1},
+		{"get *", []string{"get", "-A"}, true, 1},
+		{"* list", []string{"plugin", "list"}, true, 2},
+		{"log*", []string{"logs"}, true, 1},
+		{"*", []string{"--force"}, false, 0},
+		{"", []string{"status"}, false, 0},
+	}
+
+	for _, tt := range tests {
+		m, ok := matchSubCommand(tt.pattern, tt.args)
+		if ok != tt.wantOK || m.consumed != tt.wantConsumed {
+			t.Errorf("matchSubCommand(%q, %q) = (%d, %v), want (%d, %v)",
+				tt.pattern, tt.args, m.consumed, ok, tt.wantConsumed, tt.wantOK)
+		}
+	}
+}
+
+// TestFindSubCommandRule tests that the most specific rule wins, with ties going to the first rule.
+func TestFindSubCommandRule(t *testing.T) {
+	rules := []config.SubCommandRule{
+		{Name: "*", Message: "wildcard"},
+		{Name: "log", Message: "log"},
+		{Name: "remote *", Message: "remote wildcard"},
+		{Name: "remote add", Message: "remote add"},
+		{Name: "remote add", Message: "duplicate"},
+	}
+
+	tests := []struct {
+		args        []string
+		wantMessage string
+	}{
+		{[]string{"status"}, "wildcard"},
+		{[]string{"log", "-1"}, "log"},
+		{[]string{"remote"}, "remote wildcard"},
+		{[]string{"remote", "-v"}, "remote wildcard"},
+		{[]string{"remote", "remove", "origin"}, "remote wildcard"},
+		{[]string{"remote", "add", "origin"}, "remote add"},
+	}
+
+	for _, tt := range tests {
+		rule, _, ok := findSubCommandRule(rules, tt.args)
+		if !ok || rule.Message != tt.wantMessage {
+			t.Errorf("findSubCommandRule(%q) = %q, %v, want %q", tt.args, rule.Message, ok, tt.wantMessage)
+		}
+	}
+}
@@ -1,14 +1,21 @@
 package validator
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/shimizu1995/secure-shell-server/pkg/audit"
+	"github.com/shimizu1995/secure-shell-server/pkg/category"
 	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
 	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/logrotate"
+	"github.com/shimizu1995/secure-shell-server/pkg/redact"
 )
 
 const (
@@ -16,20 +23,81 @@ const (
 	DirPermissions = 0o755
 	// FilePermissions represents the permission bits for files.
 	FilePermissions = 0o644
+
+	bytesPerMegabyte = 1024 * 1024
+	hoursPerDay      = 24
 )
 
 // CommandValidator validates shell commands.
 type CommandValidator struct {
-	config *config.ShellCommandConfig
-	logger *logger.Logger
+	config   *config.ShellCommandConfig
+	logger   *logger.Logger
+	redactor *redact.Redactor
+	// blockLog is nil when BlockLogPath is not set
+	blockLog *logrotate.Writer
+	// identity is the caller whose commands are validated, recorded with blocked commands
+	identity identity.Identity
+	// workDir is the working directory recorded with blocked commands
+	workDir string
+	// sourceDepth is how many sourced files deep the script being validated is
+	sourceDepth int
 }
 
 // New creates a new CommandValidator.
 func New(config *config.ShellCommandConfig, logger *logger.Logger) *CommandValidator {
-	return &CommandValidator{
-		config: config,
-		logger: logger,
+	redactor, err := redact.New(config.Redaction)
+	if err != nil {
+		logger.LogErrorf("Failed to configure redaction: %v", err)
+	}
+
+	v := &CommandValidator{
+		config:   config,
+		logger:   logger,
+		redactor: redactor,
+	}
+	if config.BlockLogPath != "" {
+		v.blockLog = logrotate.New(config.BlockLogPath, logrotate.Options{
+			MaxSize:    int64(config.BlockLog.MaxSize) * bytesPerMegabyte,
+			MaxBackups: config.BlockLog.MaxBackups,
+			MaxAge:     time.Duration(config.BlockLog.MaxAge) * hoursPerDay * time.Hour,
+			Compress:   config.BlockLog.Compress,
+		})
 	}
+	return v
+}
+
+// WithAllowedDirectories returns a validator that also allows dirs. It shares the block log of v.
+func (v *CommandValidator) WithAllowedDirectories(dirs ...string) *CommandValidator {
+	cfg := *v.config
+	cfg.AllowedDirectories = append(slices.Clone(v.config.AllowedDirectories), dirs...)
+	clone := *v
+	clone.config = &cfg
+	return &clone
+}
+
+// WithConfig returns a validator that checks commands against cfg, such as the policy of a
+// user (see config.ShellCommandConfig.ForUser). It shares the block log and redaction of v.
+func (v *CommandValidator) WithConfig(cfg *config.ShellCommandConfig) *CommandValidator {
+	clone := *v
+	clone.config = cfg
+	return &clone
+}
+
+// WithIdentity returns a validator that attributes log entries and blocked commands to id.
+// It shares the block log of v.
+func (v *CommandValidator) WithIdentity(id identity.Identity) *CommandValidator {
+	clone := *v
+	clone.identity = id
+	clone.logger = v.logger.With(id.String())
+	return &clone
+}
+
+// WithWorkDir returns a validator that records dir as the working directory of the commands it
+// blocks, for checks of a whole script such as CheckExpansions. It shares the block log of v.
+func (v *CommandValidator) WithWorkDir(dir string) *CommandValidator {
+	clone := *v
+	clone.workDir = dir
+	return &clone
 }
 
 // IsDirectoryAllowed checks if a given directory is allowed to run commands in.
@@ -43,7 +111,7 @@ func (v *CommandValidator) IsDirectoryAllowed(dir string) (bool, string) {
 	resolvedDir := resolveSymlinksPath(dir)
 
 	// Check if the directory is in the allowed directories list or is a subdirectory of an allowed directory
-	for _, allowedDir := range v.config.AllowedDirectories {
+	for _, allowedDir := range v.config.Directories() {
 		resolvedAllowed := resolveSymlinksPath(allowedDir)
 		if strings.HasPrefix(resolvedDir, resolvedAllowed) {
 			return true, ""
@@ -83,7 +151,7 @@ func (v *CommandValidator) IsPathInAllowedDirectory(path string, baseDir string)
 	absPath = resolveSymlinksPath(absPath)
 
 	// Check if the resolved path is within any allowed directory
-	for _, allowedDir := range v.config.AllowedDirectories {
+	for _, allowedDir := range v.config.Directories() {
 		// Get absolute path of allowed directory for proper comparison
 		allowedAbsDir, err := filepath.Abs(allowedDir)
 		if err != nil {
@@ -136,6 +204,52 @@ func (v *CommandValidator) isPathLike(arg string) bool {
 
 // ValidateCommand checks if a command is allowed based on the configuration.
 func (v *CommandValidator) ValidateCommand(cmd string, args []string, workDir string) (bool, string) {
+	d := v.localizeDecision(v.validate(cmd, args, workDir), cmd, args)
+	return d.Allowed, d.Message
+}
+
+// validate checks a command against the configuration and reports which rule decided the outcome.
+func (v *CommandValidator) validate(cmd string, args []string, workDir string) Decision {
+	if v.workDir != workDir {
+		v = v.WithWorkDir(workDir)
+	}
+
+	// wait is only useful with background commands, which allowBackground controls
+	if d, decided := v.checkWaitBuiltin(cmd, args); decided {
+		return d
+	}
+
+	// Shell builtins are subject to the builtins policy before the regular allowlist
+	if IsShellBuiltin(cmd) {
+		if d, decided := v.checkBuiltinPolicy(cmd, args); decided {
+			if !d.Allowed {
+				return d
+			}
+			if IsSourceCommand(cmd) {
+				return v.validateSourceCommand(cmd, args, workDir)
+			}
+			// command, exec, and builtin run the command named in their arguments
+			if IsNestingCommand(cmd) {
+				return v.validateNestedArgs(cmd, args, workDir)
+			}
+			return v.validatePathArguments(cmd, args, workDir)
+		}
+	}
+
+	// Special handling for source, which runs the commands of a file in the current shell
+	if IsSourceCommand(cmd) {
+		if d := v.checkOuterCommand(cmd, args); !d.Allowed {
+			return d
+		}
+		return v.validateSourceCommand(cmd, args, workDir)
+	}
+
+	// In read-only mode, only commands marked readOnly may run. Builtins never write to disk
+	// themselves, and redirections are blocked by the runner
+	if v.config.ReadOnlyOnly && !IsShellBuiltin(cmd) && !v.isReadOnlyCommand(cmd) {
+		return v.deny(RuleReadOnly, cmd, args, fmt.Sprintf("command %q is not allowed in read-only mode", cmd))
+	}
+
 	// Special handling for xargs command
 	if cmd == "xargs" {
 		return v.validateXargsCommand(args, workDir)
@@ -156,120 +270,306 @@ func (v *CommandValidator) ValidateCommand(cmd string, args []string, workDir st
 		return v.validateSedCommand(cmd, args, workDir)
 	}
 
+	// Special handling for cmd.exe and PowerShell, whose scripts run further commands
+	if IsWindowsShell(cmd) {
+		return v.validateWindowsShellCommand(cmd, args, workDir)
+	}
+
+	// Special handling for shells, ssh, and wrappers such as env and timeout, which run
+	// a command or script given in their arguments
+	if IsNestingCommand(cmd) {
+		return v.validateNestedCommand(cmd, args, workDir)
+	}
+
 	// Check if the command is explicitly denied
-	if denied, message := v.isCommandExplicitlyDenied(cmd); denied {
-		v.logBlockedCommand(cmd, args, message)
-		return false, message
+	if denied, message, ref := v.isCommandExplicitlyDenied(cmd, args); denied {
+		return v.denyBy(RuleDenyCommand, ref, cmd, args, message)
+	}
+
+	// Denied categories take precedence over individual allow rules
+	if c, ok := category.Match(cmd, v.config.DenyCategories); ok {
+		message := fmt.Sprintf("command %q is denied: %s commands are not allowed", cmd, c)
+		return v.deny(RuleDenyCategory, cmd, args, message)
+	}
+
+	// The built-in git rules allow git without an allowCommands entry, which still applies if present
+	if cmd == "git" && v.config.Git.Enabled {
+		if d := v.validateGitCommand(args, workDir); !d.Allowed {
+			return d
+		}
+		if !v.config.IsCommandAllowed(cmd) {
+			return v.validateArguments(cmd, args, workDir)
+		}
 	}
 
 	// Check if the command is explicitly allowed
-	for _, allowed := range v.config.AllowCommands {
+	for i, allowed := range v.config.AllowCommands {
 		if allowed.Command == cmd {
 			// If there are no subcommands specified, the command is allowed without restrictions
 			if len(allowed.SubCommands) == 0 && len(allowed.DenySubCommands) == 0 {
 				// Check path-like arguments even for fully allowed commands
-				return v.validatePathArguments(cmd, args, workDir)
+				return v.validateArguments(cmd, args, workDir)
 			}
 
 			// Check subcommand permissions
-			if allowed, message := v.checkSubCommandPermissions(cmd, args, allowed); !allowed {
-				return false, message
+			if d := v.checkSubCommandPermissions(cmd, args, allowed, allowRuleRef(allowed, i)); !d.Allowed {
+				return d
 			}
 
 			// If subcommand is allowed, also validate any path-like arguments
-			return v.validatePathArguments(cmd, args, workDir)
+			return v.validateArguments(cmd, args, workDir)
 		}
 	}
 
+	// Commands in an allowed category are allowed without subcommand restrictions
+	if _, ok := category.Match(cmd, v.config.AllowCategories); ok {
+		return v.validateArguments(cmd, args, workDir)
+	}
+
 	// If command was not found in the allow list, it's denied
-	deniedMessage := fmt.Sprintf("command %q is not permitted: %s", cmd, v.config.DefaultErrorMessage)
-	v.logBlockedCommand(cmd, args, deniedMessage)
-	return false, deniedMessage
+	return v.denyNotPermitted(cmd, args)
+}
+
+// deny logs a blocked command and returns the corresponding decision.
+func (v *CommandValidator) deny(rule Rule, cmd string, args []string, message string) Decision {
+	return v.denyBy(rule, nil, cmd, args, message)
+}
+
+// denyBy is deny for a denial that ref, an entry of the configuration, is behind.
+func (v *CommandValidator) denyBy(rule Rule, ref *RuleRef, cmd string, args []string, message string) Decision {
+	v.logBlockedCommand(rule, cmd, args, message)
+	return Decision{Allowed: false, Rule: rule, Message: message, Ref: ref}
+}
+
+// denyNotPermitted denies a command that does not appear in the allowlist.
+func (v *CommandValidator) denyNotPermitted(cmd string, args []string) Decision {
+	message := fmt.Sprintf("command %q is not permitted: %s", cmd, v.config.DefaultErrorMessage)
+	return v.deny(RuleNotAllowed, cmd, args, message)
+}
+
+// validateArguments checks the arguments of an allowed command: path-like arguments must be
+// within allowed directories, and the files tee writes to must be writable (see CheckWriteTarget).
+func (v *CommandValidator) validateArguments(cmd string, args []string, workDir string) Decision {
+	if d := v.validatePathArguments(cmd, args, workDir); !d.Allowed {
+		return d
+	}
+	if cmd == "tee" {
+		return v.validateTeeTargets(cmd, args, workDir)
+	}
+	return allowDecision
 }
 
 // validatePathArguments checks if any path-like arguments are within allowed directories.
-func (v *CommandValidator) validatePathArguments(cmd string, args []string, workDir string) (bool, string) {
+func (v *CommandValidator) validatePathArguments(cmd string, args []string, workDir string) Decision {
 	for _, arg := range args {
 		// Skip arguments that don't look like paths or that start with a dash (flags)
 		if strings.HasPrefix(arg, "-") || !v.isPathLike(arg) {
 			continue
 		}
 
+		// Pipes of process substitutions are created outside the allowed directories
+		if v.IsProcessSubstitutionPipe(arg) {
+			continue
+		}
+
 		// Validate the path argument
 		allowed, message := v.IsPathInAllowedDirectory(arg, workDir)
 		if !allowed {
-			v.logBlockedCommand(cmd, args, message)
-			return false, message
+			return v.deny(RulePath, cmd, args, message)
 		}
 	}
 
-	return true, ""
+	return allowDecision
 }
 
-// isCommandExplicitlyDenied checks if a command is explicitly denied in the configuration.
-func (v *CommandValidator) isCommandExplicitlyDenied(cmd string) (bool, string) {
-	for _, denied := range v.config.DenyCommands {
-		if denied.Command == cmd {
+// isCommandExplicitlyDenied checks if a command invoked with args is explicitly denied in the
+// configuration, returning the message and the rule that denies it.
+func (v *CommandValidator) isCommandExplicitlyDenied(cmd string, args []string) (bool, string, *RuleRef) {
+	for i, denied := range v.config.DenyCommands {
+		if denied.Matches(cmd, args) {
 			message := v.config.DefaultErrorMessage
 			if denied.Message != "" {
 				message = denied.Message
 			}
-			return true, fmt.Sprintf("command %q is denied: %s", cmd, message)
+			ref := denyRuleRef(denied, i)
+			return true, ref.annotate(fmt.Sprintf("command %q is denied: %s", cmd, message)), ref
+		}
+	}
+	return false, "", nil
+}
+
+// MatchAlert returns the deny rule marked alert that applies to cmd invoked with args, if any.
+func (v *CommandValidator) MatchAlert(cmd string, args []string) (config.DenyCommand, bool) {
+	for _, denied := range v.config.DenyCommands {
+		if denied.Alert && denied.Matches(cmd, args) {
+			return denied, true
+		}
+	}
+	return config.DenyCommand{}, false
+}
+
+// isReadOnlyCommand checks if the command's allowCommands entry is marked readOnly.
+func (v *CommandValidator) isReadOnlyCommand(cmd string) bool {
+	for _, allowed := range v.config.AllowCommands {
+		if allowed.Command == cmd {
+			return allowed.ReadOnly
+		}
+	}
+	return false
+}
+
+// RequiresApproval reports whether the command's allowCommands entry is marked approvalRequired.
+func (v *CommandValidator) RequiresApproval(cmd string) bool {
+	for _, allowed := range v.config.AllowCommands {
+		if allowed.Command == cmd {
+			return allowed.ApprovalRequired
+		}
+	}
+	return false
+}
+
+// RequiresConfirmation reports whether the command's allowCommands entry is marked confirm.
+func (v *CommandValidator) RequiresConfirmation(cmd string) bool {
+	for _, allowed := range v.config.AllowCommands {
+		if allowed.Command == cmd {
+			return allowed.Confirm
+		}
+	}
+	return false
+}
+
+// AllowsPty reports whether the command's allowCommands entry is marked allowPty.
+func (v *CommandValidator) AllowsPty(cmd string) bool {
+	for _, allowed := range v.config.AllowCommands {
+		if allowed.Command == cmd {
+			return allowed.AllowPty
+		}
+	}
+	return false
+}
+
+// AllowsNetwork reports whether the command's allowCommands entry is marked allowNetwork.
+func (v *CommandValidator) AllowsNetwork(cmd string) bool {
+	for _, allowed := range v.config.AllowCommands {
+		if allowed.Command == cmd {
+			return allowed.AllowNetwork
+		}
+	}
+	return false
+}
+
+// SeccompProfile returns the seccomp profile selected by the command's allowCommands entry.
+func (v *CommandValidator) SeccompProfile(cmd string) string {
+	for _, allowed := range v.config.AllowCommands {
+		if allowed.Command == cmd {
+			return allowed.SeccompProfile
+		}
+	}
+	return ""
+}
+
+// AllowsSetuid reports whether the command's allowCommands entry is marked allowSetuid.
+func (v *CommandValidator) AllowsSetuid(cmd string) bool {
+	for _, allowed := range v.config.AllowCommands {
+		if allowed.Command == cmd {
+			return allowed.AllowSetuid
+		}
+	}
+	return false
+}
+
+// CommandEnv returns the variables set by the env of the command's allowCommands entry.
+func (v *CommandValidator) CommandEnv(cmd string) map[string]config.EnvVar {
+	for _, allowed := range v.config.AllowCommands {
+		if allowed.Command == cmd {
+			return allowed.Env
+		}
+	}
+	return nil
+}
+
+// Hooks returns the hooks of the command's allowCommands entry.
+func (v *CommandValidator) Hooks(cmd string) config.RuleHooks {
+	for _, allowed := range v.config.AllowCommands {
+		if allowed.Command == cmd {
+			return allowed.Hooks
+		}
+	}
+	return config.RuleHooks{}
+}
+
+// IsCacheable reports whether cmd invoked with args is marked cacheable, either by its
+// allowCommands entry or by the most specific subcommand rule matching args, which covers the
+// subcommands below it.
+func (v *CommandValidator) IsCacheable(cmd string, args []string) bool {
+	for _, allowed := range v.config.AllowCommands {
+		if allowed.Command != cmd {
+			continue
+		}
+		if allowed.Cacheable {
+			return true
+		}
+		rules := allowed.SubCommands
+		for len(args) > 0 {
+			rule, m, ok := findSubCommandRule(rules, args)
+			if !ok {
+				return false
+			}
+			if rule.Cacheable {
+				return true
+			}
+			rules, args = rule.SubCommands, args[m.consumed:]
 		}
+		return false
 	}
-	return false, ""
+	return false
 }
 
 // checkSubCommandPermissions checks if the subcommand is allowed for the specified command.
 // It delegates to the recursive checkSubCommandRule for the top-level AllowCommand.
-func (v *CommandValidator) checkSubCommandPermissions(cmd string, args []string, allowed config.AllowCommand) (bool, string) {
+func (v *CommandValidator) checkSubCommandPermissions(cmd string, args []string, allowed config.AllowCommand, ref *RuleRef) Decision {
 	// Convert top-level AllowCommand into a SubCommandRule-compatible check
-	return v.checkSubCommandRule(cmd, args, allowed.SubCommands, allowed.DenySubCommands, nil, "")
+	return v.checkSubCommandRule(ref, cmd, args, allowed.SubCommands, allowed.DenySubCommands, nil, "")
 }
 
 // checkSubCommandRule recursively validates args against a SubCommandRule tree.
+// ref is the allow rule the tree belongs to.
 // cmdPath is the command path so far (e.g. "git" or "docker compose") for error messages.
 // subCommands is the list of allowed sub-command rules at this level.
 // denySubCommands is the list of denied sub-commands at this level.
 // denyFlags is the list of denied flags at this level.
 // message is a custom error message for denied flags at this level.
-func (v *CommandValidator) checkSubCommandRule(cmdPath string, args []string, subCommands []config.SubCommandRule, denySubCommands []string, denyFlags []string, message string) (bool, string) {
+func (v *CommandValidator) checkSubCommandRule(ref *RuleRef, cmdPath string, args []string, subCommands []config.SubCommandRule, denySubCommands []string, denyFlags []string, message string) Decision {
 	// If no more args, nothing to deny
 	if len(args) == 0 {
-		return true, ""
+		return allowDecision
 	}
 
-	// Check denied subcommands at this level
-	for _, denied := range denySubCommands {
-		if args[0] == denied {
-			deniedMessage := fmt.Sprintf("subcommand %q is denied for command %q", args[0], cmdPath)
-			v.logBlockedCommand(cmdPath, args, deniedMessage)
-			return false, deniedMessage
-		}
+	// Check denied subcommands at this level; deny entries always take precedence over allow rules
+	if matched, denied := findDeniedSubCommand(denySubCommands, args); denied {
+		deniedMessage := ref.annotate(fmt.Sprintf("subcommand %q is denied for command %q", matched, cmdPath))
+		return v.denyBy(RuleDenySubCommand, ref, cmdPath, args, deniedMessage)
 	}
 
-	// If there are subcommand rules, try to match args[0] against them
+	// If there are subcommand rules, find the most specific one matching the leading args
 	if len(subCommands) > 0 {
-		for _, rule := range subCommands {
-			if rule.Name == args[0] {
-				// Found a matching rule — recurse into it
-				nextPath := cmdPath + " " + args[0]
-				return v.checkSubCommandRule(nextPath, args[1:], rule.SubCommands, rule.DenySubCommands, rule.DenyFlags, rule.Message)
-			}
+		if rule, m, ok := findSubCommandRule(subCommands, args); ok {
+			// Found a matching rule — recurse into it with the remaining args
+			nextPath := strings.Join(append([]string{cmdPath}, args[:m.consumed]...), " ")
+			return v.checkSubCommandRule(ref, nextPath, args[m.consumed:], rule.SubCommands, rule.DenySubCommands, rule.DenyFlags, rule.Message)
 		}
 
 		// args[0] not found in allowed subcommands (allowlist mode) — deny
-		deniedMessage := fmt.Sprintf("subcommand %q is not allowed for command %q", args[0], cmdPath)
-		v.logBlockedCommand(cmdPath, args, deniedMessage)
-		return false, deniedMessage
+		deniedMessage := ref.annotate(fmt.Sprintf("subcommand %q is not allowed for command %q", args[0], cmdPath))
+		return v.denyBy(RuleSubCommandNotAllowed, ref, cmdPath, args, deniedMessage)
 	}
 
 	// No subcommand rules at this level — check denyFlags against all remaining args
-	return v.checkDenyFlags(cmdPath, args, denyFlags, message)
+	return v.checkDenyFlags(ref, cmdPath, args, denyFlags, message)
 }
 
 // checkDenyFlags scans args for any flag in denyFlags.
-func (v *CommandValidator) checkDenyFlags(cmdPath string, args []string, denyFlags []string, message string) (bool, string) {
+func (v *CommandValidator) checkDenyFlags(ref *RuleRef, cmdPath string, args []string, denyFlags []string, message string) Decision {
 	for _, arg := range args {
 		for _, denied := range denyFlags {
 			if isDenyFlagMatch(arg, denied) {
@@ -277,12 +577,11 @@ func (v *CommandValidator) checkDenyFlags(cmdPath string, args []string, denyFla
 				if message != "" {
 					deniedMessage += ": " + message
 				}
-				v.logBlockedCommand(cmdPath, args, deniedMessage)
-				return false, deniedMessage
+				return v.denyBy(RuleDenyFlag, ref, cmdPath, args, ref.annotate(deniedMessage))
 			}
 		}
 	}
-	return true, ""
+	return allowDecision
 }
 
 // isDenyFlagMatch checks if an argument matches a denied flag.
@@ -318,18 +617,15 @@ func isDenyFlagMatch(arg, denied string) bool {
 }
 
 // validateXargsCommand checks if the command executed by xargs is allowed.
-func (v *CommandValidator) validateXargsCommand(args []string, workDir string) (bool, string) {
+func (v *CommandValidator) validateXargsCommand(args []string, workDir string) Decision {
 	// First check if xargs itself is allowed
-	if denied, message := v.isCommandExplicitlyDenied("xargs"); denied {
-		v.logBlockedCommand("xargs", args, message)
-		return false, message
+	if denied, message, ref := v.isCommandExplicitlyDenied("xargs", args); denied {
+		return v.denyBy(RuleDenyCommand, ref, "xargs", args, message)
 	}
 
 	// Check if xargs is explicitly allowed
 	if !v.config.IsCommandAllowed("xargs") {
-		deniedMessage := fmt.Sprintf("command %q is not permitted: %s", "xargs", v.config.DefaultErrorMessage)
-		v.logBlockedCommand("xargs", args, deniedMessage)
-		return false, deniedMessage
+		return v.denyNotPermitted("xargs", args)
 	}
 
 	// Parse the xargs command to extract the actual command
@@ -337,35 +633,32 @@ func (v *CommandValidator) validateXargsCommand(args []string, workDir string) (
 	xargsCmd, xargsArgs, valid, errMsg := parser.ParseXargsCommand(args)
 
 	if !valid {
-		v.logBlockedCommand("xargs", args, errMsg)
-		return false, errMsg
+		return v.deny(RuleNestedCommand, "xargs", args, errMsg)
+	}
+	if v.config.DenyNestedCommands {
+		return v.denyNested("xargs", args)
 	}
 
 	// Now validate the command that xargs will execute
-	allowed, message := v.ValidateCommand(xargsCmd, xargsArgs, workDir)
-	if !allowed {
+	if d := v.validateInvocation(xargsCmd, xargsArgs, workDir); !d.Allowed {
 		// Add context that this is from an xargs command
-		message = "xargs would execute disallowed command: " + message
-		v.logBlockedCommand("xargs", args, message)
-		return false, message
+		message := "xargs would execute disallowed command: " + d.Message
+		return v.denyBy(d.Rule, d.Ref, "xargs", args, message)
 	}
 
-	return true, ""
+	return allowDecision
 }
 
 // validateFindCommand checks if find command has -exec with allowed commands only.
-func (v *CommandValidator) validateFindCommand(args []string, workDir string) (bool, string) {
+func (v *CommandValidator) validateFindCommand(args []string, workDir string) Decision {
 	// First check if find itself is allowed
-	if denied, message := v.isCommandExplicitlyDenied("find"); denied {
-		v.logBlockedCommand("find", args, message)
-		return false, message
+	if denied, message, ref := v.isCommandExplicitlyDenied("find", args); denied {
+		return v.denyBy(RuleDenyCommand, ref, "find", args, message)
 	}
 
 	// Check if find is explicitly allowed
 	if !v.config.IsCommandAllowed("find") {
-		deniedMessage := fmt.Sprintf("command %q is not permitted: %s", "find", v.config.DefaultErrorMessage)
-		v.logBlockedCommand("find", args, deniedMessage)
-		return false, deniedMessage
+		return v.denyNotPermitted("find", args)
 	}
 
 	// Check for -exec commands in find args
@@ -373,8 +666,7 @@ func (v *CommandValidator) validateFindCommand(args []string, workDir string) (b
 	execCommands, hasExec, errMsg := parser.ParseFindExecArgs(args)
 
 	if errMsg != "" {
-		v.logBlockedCommand("find", args, errMsg)
-		return false, errMsg
+		return v.deny(RuleNestedCommand, "find", args, errMsg)
 	}
 
 	// If no -exec found, the find command is allowed (we still need to validate paths)
@@ -384,13 +676,15 @@ func (v *CommandValidator) validateFindCommand(args []string, workDir string) (b
 		return v.validatePathArguments("find", filteredArgs, workDir)
 	}
 
+	if v.config.DenyNestedCommands {
+		return v.denyNested("find", args)
+	}
+
 	// Validate each -exec command with its full arguments
 	for _, execCmd := range execCommands {
-		allowed, message := v.ValidateCommand(execCmd.Name, execCmd.Args, workDir)
-		if !allowed {
-			message = "find command contains disallowed -exec: " + message
-			v.logBlockedCommand("find", args, message)
-			return false, message
+		if d := v.validateInvocation(execCmd.Name, execCmd.Args, workDir); !d.Allowed {
+			message := "find command contains disallowed -exec: " + d.Message
+			return v.denyBy(d.Rule, d.Ref, "find", args, message)
 		}
 	}
 
@@ -401,26 +695,22 @@ func (v *CommandValidator) validateFindCommand(args []string, workDir string) (b
 }
 
 // validateAwkCommand checks if an awk command contains dangerous patterns.
-func (v *CommandValidator) validateAwkCommand(cmd string, args []string, workDir string) (bool, string) {
+func (v *CommandValidator) validateAwkCommand(cmd string, args []string, workDir string) Decision {
 	// Check if the command is explicitly denied
-	if denied, message := v.isCommandExplicitlyDenied(cmd); denied {
-		v.logBlockedCommand(cmd, args, message)
-		return false, message
+	if denied, message, ref := v.isCommandExplicitlyDenied(cmd, args); denied {
+		return v.denyBy(RuleDenyCommand, ref, cmd, args, message)
 	}
 
 	// Check if the command is explicitly allowed
 	if !v.config.IsCommandAllowed(cmd) {
-		deniedMessage := fmt.Sprintf("command %q is not permitted: %s", cmd, v.config.DefaultErrorMessage)
-		v.logBlockedCommand(cmd, args, deniedMessage)
-		return false, deniedMessage
+		return v.denyNotPermitted(cmd, args)
 	}
 
 	// Check for dangerous patterns in awk script
 	awkValidator := NewAwkValidator()
 	if hasDanger, description := awkValidator.ValidateAwkArgs(args); hasDanger {
 		message := fmt.Sprintf("%s command blocked: %s", cmd, description)
-		v.logBlockedCommand(cmd, args, message)
-		return false, message
+		return v.deny(RuleDangerousPattern, cmd, args, message)
 	}
 
 	// Validate path arguments, filtering out the awk script and flags
@@ -429,26 +719,22 @@ func (v *CommandValidator) validateAwkCommand(cmd string, args []string, workDir
 }
 
 // validateSedCommand checks if a sed command contains dangerous patterns.
-func (v *CommandValidator) validateSedCommand(cmd string, args []string, workDir string) (bool, string) {
+func (v *CommandValidator) validateSedCommand(cmd string, args []string, workDir string) Decision {
 	// Check if the command is explicitly denied
-	if denied, message := v.isCommandExplicitlyDenied(cmd); denied {
-		v.logBlockedCommand(cmd, args, message)
-		return false, message
+	if denied, message, ref := v.isCommandExplicitlyDenied(cmd, args); denied {
+		return v.denyBy(RuleDenyCommand, ref, cmd, args, message)
 	}
 
 	// Check if the command is explicitly allowed
 	if !v.config.IsCommandAllowed(cmd) {
-		deniedMessage := fmt.Sprintf("command %q is not permitted: %s", cmd, v.config.DefaultErrorMessage)
-		v.logBlockedCommand(cmd, args, deniedMessage)
-		return false, deniedMessage
+		return v.denyNotPermitted(cmd, args)
 	}
 
 	// Check for dangerous patterns in sed script
 	sedValidator := NewSedValidator()
 	if hasDanger, description := sedValidator.ValidateSedArgs(args); hasDanger {
 		message := fmt.Sprintf("%s command blocked: %s", cmd, description)
-		v.logBlockedCommand(cmd, args, message)
-		return false, message
+		return v.deny(RuleDangerousPattern, cmd, args, message)
 	}
 
 	// Validate path arguments, filtering out sed scripts and expressions
@@ -456,9 +742,10 @@ func (v *CommandValidator) validateSedCommand(cmd string, args []string, workDir
 	return v.validatePathArguments(cmd, filteredArgs, workDir)
 }
 
-// logBlockedCommand logs blocked commands to the specified file.
-func (v *CommandValidator) logBlockedCommand(cmd string, args []string, reason string) {
-	if v.config.BlockLogPath == "" {
+// logBlockedCommand appends a JSON record of a blocked command to the block log (see
+// audit.BlockRecord).
+func (v *CommandValidator) logBlockedCommand(rule Rule, cmd string, args []string, reason string) {
+	if v.blockLog == nil {
 		return
 	}
 
@@ -469,20 +756,20 @@ func (v *CommandValidator) logBlockedCommand(cmd string, args []string, reason s
 		return
 	}
 
-	// Open the log file in append mode
-	f, err := os.OpenFile(v.config.BlockLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, FilePermissions)
+	// Secrets are redacted from each field before it is encoded
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		redacted[i] = v.redactor.Redact(arg)
+	}
+	record := audit.NewBlockRecord(v.identity, string(rule), v.redactor.Redact(cmd), redacted, v.workDir, v.redactor.Redact(reason))
+	line, err := json.Marshal(record)
 	if err != nil {
-		v.logger.LogErrorf("Failed to open block log file: %v", err)
+		v.logger.LogErrorf("Failed to encode block log record: %v", err)
 		return
 	}
-	defer f.Close()
-
-	// Create log entry
-	timestamp := time.Now().Format(time.RFC3339)
-	logEntry := fmt.Sprintf("%s [BLOCKED] Command: %s %v, Reason: %s\n", timestamp, cmd, args, reason)
 
-	// Write to log file
-	if _, err := f.WriteString(logEntry); err != nil {
+	// Write to log file, rotating it if it has grown too large
+	if _, err := v.blockLog.Write(append(line, '\n')); err != nil {
 		v.logger.LogErrorf("Failed to write to block log file: %v", err)
 	}
 }
@@ -0,0 +1,91 @@
+package validator
+
+import (
+	"io"
+	"testing"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+)
+
+// TestCheckBackground tests that background commands and process substitutions are denied
+// unless the configuration allows them.
+func TestCheckBackground(t *testing.T) {
+	tests := []struct {
+		name       string
+		script     string
+		background bool
+		procSubst  bool
+		want       int
+	}{
+		{name: "Foreground", script: "sleep 1; echo done", want: 0},
+		{name: "Background", script: "sleep 10 &", want: 1},
+		{name: "BackgroundInSubshell", script: "(sleep 10 &); echo hi", want: 1},
+		{name: "Coprocess", script: "coproc cat", want: 1},
+		{name: "BackgroundAllowed", script: "sleep 10 & wait", background: true, want: 0},
+		{name: "ProcessSubstitution", script: "diff <(ls a) <(ls b)", want: 2},
+		{name: "OutputSubstitution", script: "ls > >(cat)", want: 1},
+		{name: "ProcessSubstitutionAllowed", script: "diff <(ls a) <(ls b)", procSubst: true, want: 0},
+		{name: "BackgroundAllowedOnly", script: "cat <(ls) &", background: true, want: 1},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &config.ShellCommandConfig{
+				AllowedDirectories:       []string{"/tmp"},
+				AllowBackground:          tc.background,
+				AllowProcessSubstitution: tc.procSubst,
+			}
+			v := New(cfg, logger.NewWithWriter(io.Discard))
+			prog, err := ParseScript(tc.script, cfg.Lang())
+			if err != nil {
+				t.Fatalf("ParseScript: %v", err)
+			}
+			violations := v.CheckBackground(prog)
+			if len(violations) != tc.want {
+				t.Fatalf("got %d violations, want %d: %v", len(violations), tc.want, violations)
+			}
+			for _, violation := range violations {
+				if violation.Rule != RuleBackground {
+					t.Errorf("Rule = %q, want %q", violation.Rule, RuleBackground)
+				}
+			}
+		})
+	}
+}
+
+// TestValidateBackgroundBuiltins tests the wait builtin and background commands in nested scripts.
+func TestValidateBackgroundBuiltins(t *testing.T) {
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories: []string{"/tmp"},
+		AllowCommands:      []config.AllowCommand{{Command: "sleep"}, {Command: "sh"}},
+	}
+	v := New(cfg, logger.NewWithWriter(io.Discard))
+
+	runValidationTestCases(t, v, []validationTestCase{
+		{
+			name:    "Wait",
+			cmd:     "wait",
+			allowed: false,
+			message: `shell builtin "wait" is not allowed: set allowBackground to permit background commands`,
+		},
+		{
+			name:    "NestedBackground",
+			cmd:     "sh",
+			args:    []string{"-c", "sleep 10 &"},
+			allowed: false,
+			message: `sh: background command "sleep" is not allowed: set allowBackground to permit it`,
+		},
+		{name: "NestedForeground", cmd: "sh", args: []string{"-c", "sleep 1"}, allowed: true},
+	})
+
+	allowing := v.WithConfig(&config.ShellCommandConfig{
+		AllowedDirectories: cfg.AllowedDirectories,
+		AllowCommands:      cfg.AllowCommands,
+		AllowBackground:    true,
+	})
+	runValidationTestCases(t, allowing, []validationTestCase{
+		{name: "WaitAllowed", cmd: "wait", allowed: true},
+		{name: "NestedBackgroundAllowed", cmd: "sh", args: []string{"-c", "sleep 10 &"}, allowed: true},
+	})
+}
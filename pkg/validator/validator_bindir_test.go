@@ -0,0 +1,77 @@
+package validator
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+)
+
+// TestCheckInvocation tests that commands invoked by a path must be in allowedBinDirs.
+func TestCheckInvocation(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Unix paths")
+	}
+	binDir := t.TempDir()
+	linkDir := filepath.Join(t.TempDir(), "bin")
+	if err := os.Symlink(binDir, linkDir); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories:  []string{t.TempDir()},
+		AllowCommands:       []config.AllowCommand{{Command: "tool"}},
+		AllowedBinDirs:      []string{binDir},
+		DefaultErrorMessage: "Command not allowed by security policy",
+	}
+	v := New(cfg, logger.NewWithWriter(io.Discard))
+
+	tests := []struct {
+		name    string
+		cmd     string
+		allowed bool
+		message string
+	}{
+		{"Name", "tool", true, ""},
+		{"AbsoluteInBinDir", filepath.Join(binDir, "tool"), true, ""},
+		{"ThroughSymlink", filepath.Join(linkDir, "tool"), true, ""},
+		{"DotSlash", "./tool", false, `command "./tool" is invoked by a relative path: only commands in the allowed executable directories may run`},
+		{"Relative", "bin/tool", false, `command "bin/tool" is invoked by a relative path: only commands in the allowed executable directories may run`},
+		{"Subdirectory", filepath.Join(binDir, "sub", "tool"), false, `command "` + filepath.Join(binDir, "sub", "tool") + `" is not in an allowed executable directory: Command not allowed by security policy`},
+		{"Outside", "/tmp/tool", false, `command "/tmp/tool" is not in an allowed executable directory: Command not allowed by security policy`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, message := v.CheckInvocation(tt.cmd, nil)
+			if allowed != tt.allowed || message != tt.message {
+				t.Errorf("CheckInvocation(%q) = %v, %q, want %v, %q", tt.cmd, allowed, message, tt.allowed, tt.message)
+			}
+		})
+	}
+
+	// Without allowedBinDirs any invocation is left to the allowlist
+	cfg.AllowedBinDirs = nil
+	if allowed, _ := v.CheckInvocation("./tool", nil); !allowed {
+		t.Error("CheckInvocation() denied a relative path without allowedBinDirs")
+	}
+}
+
+// TestValidateScript_BinDir tests that script reports and nested commands include relative invocations.
+func TestValidateScript_BinDir(t *testing.T) {
+	v, dir := newReportTestValidator(t)
+	v.config.AllowedBinDirs = []string{"/usr/bin"}
+	v.config.AllowCommands = append(v.config.AllowCommands, config.AllowCommand{Command: "xargs"})
+
+	report := v.ValidateScript("./echo hi\necho ok | xargs ./ls\n", dir)
+	if len(report.Violations) != 2 {
+		t.Fatalf("got %d violations, want 2: %+v", len(report.Violations), report.Violations)
+	}
+	for _, violation := range report.Violations {
+		if violation.Rule != RuleBinDir {
+			t.Errorf("violation %q has rule %q, want %q", violation.Command, violation.Rule, RuleBinDir)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package validator
+
+import (
+	"testing"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+)
+
+func TestValidateCommand_BuiltinPolicy(t *testing.T) {
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories: []string{"/"},
+		AllowCommands: []config.AllowCommand{
+			{Command: "echo"},
+			{Command: "trap"},
+		},
+		Builtins: config.BuiltinPolicy{
+			Allow:   []string{"export", "set"},
+			Deny:    []string{"trap", "source"},
+			Message: "state-changing builtins are disabled",
+		},
+		DefaultErrorMessage: "Command not allowed by security policy",
+	}
+	v := New(cfg, logger.New())
+
+	runValidationTestCases(t, v, []validationTestCase{
+		{
+			name:    "allowed builtin without allowCommands entry",
+			cmd:     "export",
+			args:    []string{"FOO"},
+			allowed: true,
+		},
+		{
+			name:    "deny overrides allowCommands",
+			cmd:     "trap",
+			args:    []string{"echo hi", "EXIT"},
+			allowed: false,
+			message: `shell builtin "trap" is denied: state-changing builtins are disabled`,
+		},
+		{
+			name:    "denied builtin",
+			cmd:     "source",
+			args:    []string{"env.sh"},
+			allowed: false,
+			message: `shell builtin "source" is denied: state-changing builtins are disabled`,
+		},
+		{
+			name:    "builtin without policy falls back to allowlist",
+			cmd:     "unset",
+			args:    []string{"FOO"},
+			allowed: false,
+			message: `command "unset" is not permitted: Command not allowed by security policy`,
+		},
+		{
+			name:    "builtin listed in allowCommands",
+			cmd:     "echo",
+			args:    []string{"hi"},
+			allowed: true,
+		},
+	})
+}
+
+func TestIsShellBuiltin(t *testing.T) {
+	for _, name := range []string{"cd", "export", "set", "unset", "trap", "source", ".", "local"} {
+		if !IsShellBuiltin(name) {
+			t.Errorf("IsShellBuiltin(%q) = false, want true", name)
+		}
+	}
+	for _, name := range []string{"ls", "git", ""} {
+		if IsShellBuiltin(name) {
+			t.Errorf("IsShellBuiltin(%q) = true, want false", name)
+		}
+	}
+	if !IsDeclarationBuiltin("export") || IsDeclarationBuiltin("set") {
+		t.Error("IsDeclarationBuiltin() misclassified export or set")
+	}
+}
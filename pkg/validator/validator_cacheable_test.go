@@ -0,0 +1,45 @@
+package validator
+
+import (
+	"io"
+	"testing"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+)
+
+// TestIsCacheable tests that cacheable subcommand rules cover the subcommands below them only.
+func TestIsCacheable(t *testing.T) {
+	cfg := &config.ShellCommandConfig{
+		AllowCommands: []config.AllowCommand{
+			{Command: "ls", Cacheable: true},
+			{
+				Command: "git",
+				SubCommands: []config.SubCommandRule{
+					{Name: "status", Cacheable: true},
+					{Name: "remote", Cacheable: true, SubCommands: []config.SubCommandRule{{Name: "show"}}},
+					{Name: "commit"},
+				},
+			},
+		},
+	}
+	v := New(cfg, logger.NewWithWriter(io.Discard))
+
+	tests := []struct {
+		cmd  string
+		args []string
+		want bool
+	}{
+		{"ls", []string{"-la"}, true},
+		{"git", []string{"status", "--short"}, true},
+		{"git", []string{"remote", "show", "origin"}, true},
+		{"git", []string{"commit", "-m", "x"}, false},
+		{"git", nil, false},
+		{"cat", nil, false},
+	}
+	for _, tc := range tests {
+		if got := v.IsCacheable(tc.cmd, tc.args); got != tc.want {
+			t.Errorf("IsCacheable(%q, %q) = %v, want %v", tc.cmd, tc.args, got, tc.want)
+		}
+	}
+}
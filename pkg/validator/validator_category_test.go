@@ -0,0 +1,80 @@
+package validator
+
+import (
+	"io"
+	"testing"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+)
+
+// TestValidateCategories tests that commands are allowed or denied by their built-in category.
+func TestValidateCategories(t *testing.T) {
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories: []string{"/tmp"},
+		AllowCommands: []config.AllowCommand{
+			{Command: "ls"},
+			{Command: "curl"},
+			{Command: "xargs"},
+		},
+		DenyCommands:        []config.DenyCommand{{Command: "svn", Message: "use git"}},
+		AllowCategories:     []string{"vcs"},
+		DenyCategories:      []string{"network"},
+		DefaultErrorMessage: "Command not allowed by security policy",
+	}
+	v := New(cfg, logger.NewWithWriter(io.Discard))
+
+	runValidationTestCases(t, v, []validationTestCase{
+		{
+			name:    "UncategorizedCommand",
+			cmd:     "ls",
+			allowed: true,
+		},
+		{
+			name:    "DeniedCategoryOverridesAllowCommand",
+			cmd:     "curl",
+			args:    []string{"https://example.com"},
+			allowed: false,
+			message: `command "curl" is denied: network commands are not allowed`,
+		},
+		{
+			name:    "DeniedCategory",
+			cmd:     "ssh",
+			args:    []string{"host"},
+			allowed: false,
+			message: `command "ssh" is denied: network commands are not allowed`,
+		},
+		{
+			name:    "AllowedCategory",
+			cmd:     "git",
+			args:    []string{"status"},
+			allowed: true,
+		},
+		{
+			name:    "DenyCommandOverridesAllowedCategory",
+			cmd:     "svn",
+			allowed: false,
+			message: `command "svn" is denied: use git`,
+		},
+		{
+			name:    "AllowedCategoryChecksPaths",
+			cmd:     "git",
+			args:    []string{"-C", "/etc", "status"},
+			allowed: false,
+			message: `path "/etc" is outside of allowed directories: Command not allowed by security policy`,
+		},
+		{
+			name:    "NestedXargsCommand",
+			cmd:     "xargs",
+			args:    []string{"wget"},
+			allowed: false,
+			message: `xargs would execute disallowed command: command "wget" is denied: network commands are not allowed`,
+		},
+		{
+			name:    "UnlistedCommand",
+			cmd:     "docker",
+			allowed: false,
+			message: `command "docker" is not permitted: Command not allowed by security policy`,
+		},
+	})
+}
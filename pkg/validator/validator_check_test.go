@@ -0,0 +1,60 @@
+package validator
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+)
+
+// TestCheckCommand tests that CheckCommand reports decisions without writing the block log.
+func TestCheckCommand(t *testing.T) {
+	workDir := t.TempDir()
+	logPath := filepath.Join(t.TempDir(), "blocked.log")
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories:  []string{workDir},
+		AllowCommands:       []config.AllowCommand{{Command: "ls"}},
+		DenyCommands:        []config.DenyCommand{{Command: "rm", Message: "use trash instead"}},
+		DefaultErrorMessage: "Command not allowed",
+		BlockLogPath:        logPath,
+	}
+	v := New(cfg, logger.NewWithWriter(io.Discard))
+
+	tests := []struct {
+		name    string
+		cmd     string
+		args    []string
+		cwd     string
+		allowed bool
+		rule    Rule
+	}{
+		{name: "Allowed", cmd: "ls", args: []string{"-la"}, cwd: workDir, allowed: true},
+		{name: "Denied", cmd: "rm", args: []string{"-rf", "x"}, cwd: workDir, rule: RuleDenyCommand},
+		{name: "NotAllowed", cmd: "curl", cwd: workDir, rule: RuleNotAllowed},
+		{name: "PathOutsideAllowed", cmd: "ls", args: []string{"/etc"}, cwd: workDir, rule: RulePath},
+		{name: "DirectoryOutsideAllowed", cmd: "ls", cwd: "/", rule: RuleDirectory},
+		{name: "EmptyDirectory", cmd: "ls", cwd: "", rule: RuleDirectory},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := v.CheckCommand(tc.cmd, tc.args, tc.cwd)
+			if d.Allowed != tc.allowed {
+				t.Fatalf("Allowed = %v, want %v (message: %s)", d.Allowed, tc.allowed, d.Message)
+			}
+			if d.Rule != tc.rule {
+				t.Errorf("Rule = %q, want %q", d.Rule, tc.rule)
+			}
+			if !tc.allowed && d.Message == "" {
+				t.Error("expected a message for a denied command")
+			}
+		})
+	}
+
+	if _, err := os.Stat(logPath); !os.IsNotExist(err) {
+		t.Errorf("CheckCommand wrote the block log: %v", err)
+	}
+}
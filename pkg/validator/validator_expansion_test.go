@@ -0,0 +1,62 @@
+package validator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+)
+
+func newExpansionTestValidator(t *testing.T, denyDynamic bool) (*CommandValidator, string) {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories: []string{dir},
+		AllowCommands: []config.AllowCommand{
+			{Command: "echo"},
+			{Command: "rm", LiteralArgs: true},
+		},
+		DenyDynamicCommands: denyDynamic,
+		DefaultErrorMessage: "Command not allowed by security policy",
+	}
+	return New(cfg, logger.New()), dir
+}
+
+func TestValidateScript_Expansions(t *testing.T) {
+	tests := []struct {
+		name        string
+		script      string
+		denyDynamic bool
+		want        []string
+	}{
+		{"literal command and arguments", "echo hello\nrm -f build.log", true, nil},
+		{"expanded argument of a command without literalArgs", `echo "$HOME" $(echo hi)`, true, nil},
+		{"dynamic command name allowed by default", "$CMD args", false, nil},
+		{"dynamic command name", "echo ok\n$CMD args", true, []string{"2:1: command name $CMD comes from an expansion"}},
+		{"command substitution as command name", `"$(echo rm)" -rf x`, true, []string{`1:1: command name "$(echo rm)" comes from an expansion`}},
+		{"expanded argument of a literalArgs command", `rm -rf "$TARGET"/ x`, false, []string{`1:8: argument "$TARGET"/ of "rm" comes from an expansion`}},
+		{"each expanded argument is reported", "rm $A $((1+1))", false, []string{"1:4: argument $A", "1:7: argument $((1 + 1))"}},
+		{"literalArgs applies to commands invoked by path", "/bin/rm $TARGET", false, []string{`1:9: argument $TARGET of "rm"`}},
+		{"nested in a command substitution", "echo $(rm $TARGET)", false, []string{"1:11: argument $TARGET"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, dir := newExpansionTestValidator(t, tt.denyDynamic)
+			var got []Violation
+			for _, violation := range v.ValidateScript(tt.script, dir).Violations {
+				if violation.Rule == RuleExpansion {
+					got = append(got, violation)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d expansion violations, want %d: %v", len(got), len(tt.want), got)
+			}
+			for i, want := range tt.want {
+				if !strings.HasPrefix(got[i].String(), want) {
+					t.Errorf("violation %d = %q, want prefix %q", i, got[i].String(), want)
+				}
+			}
+		})
+	}
+}
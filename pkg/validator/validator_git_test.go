@@ -0,0 +1,375 @@
+package validator
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+)
+
+// TestValidateGitRules tests the built-in git rules of git.enabled.
+func TestValidateGitRules(t *testing.T) {
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories:  []string{"/tmp"},
+		Git:                 config.GitConfig{Enabled: true, AllowSubCommands: []string{"push", "clean", "config", "clone"}},
+		DefaultErrorMessage: "Command not allowed by security policy",
+	}
+	v := New(cfg, logger.NewWithWriter(io.Discard))
+
+	runValidationTestCases(t, v, []validationTestCase{
+		{name: "Status", cmd: "git", args: []string{"status"}, allowed: true},
+		{name: "CommitWithGlobalOptions", cmd: "git", args: []string{"--no-pager", "-c", "user.name=bot", "commit", "-m", "msg"}, allowed: true},
+		{name: "Version", cmd: "git", args: []string{"--version"}, allowed: true},
+		{
+			name:    "SubCommandNotAllowed",
+			cmd:     "git",
+			args:    []string{"rebase", "-i"},
+			allowed: false,
+			message: "git rebase is not allowed: allowed subcommands are status, diff, log, add, commit, push, clean, config, clone",
+		},
+		{name: "Push", cmd: "git", args: []string{"push", "-u", "origin", "main"}, allowed: true},
+		{
+			name:    "ForcePush",
+			cmd:     "git",
+			args:    []string{"push", "--force", "origin", "main"},
+			allowed: false,
+			message: "git push --force is not allowed: force pushes and remote deletions are denied",
+		},
+		{
+			name:    "ForcePushCombinedFlags",
+			cmd:     "git",
+			args:    []string{"push", "-uf", "origin", "main"},
+			allowed: false,
+			message: "git push -f and -d are not allowed: force pushes and remote deletions are denied",
+		},
+		{
+			name:    "ForcePushRefspec",
+			cmd:     "git",
+			args:    []string{"push", "origin", "+main"},
+			allowed: false,
+			message: `git push +main is not allowed: force pushes and remote deletions are denied`,
+		},
+		{
+			name:    "DeleteRefspec",
+			cmd:     "git",
+			args:    []string{"push", "origin", ":main"},
+			allowed: false,
+			message: `git push :main is not allowed: force pushes and remote deletions are denied`,
+		},
+		{
+			name:    "PushReceivePack",
+			cmd:     "git",
+			args:    []string{"push", "--receive-pack=sh -c id", "origin"},
+			allowed: false,
+			message: "git push --receive-pack is not allowed: it runs a program on the remote side",
+		},
+		{name: "CleanDryRun", cmd: "git", args: []string{"clean", "-nd"}, allowed: true},
+		{
+			name:    "CleanForce",
+			cmd:     "git",
+			args:    []string{"clean", "-xdf"},
+			allowed: false,
+			message: "git clean -f is not allowed: only dry runs (-n) of git clean may run",
+		},
+		{
+			name:    "CredentialSubCommand",
+			cmd:     "git",
+			args:    []string{"credential-store", "get"},
+			allowed: false,
+			message: "git credential-store is not allowed: credential helpers are denied",
+		},
+		{
+			name:    "CredentialHelperOption",
+			cmd:     "git",
+			args:    []string{"-c", "credential.helper=!cat ~/.token", "status"},
+			allowed: false,
+			message: "setting git credential.helper is not allowed: credential helpers are denied",
+		},
+		{
+			name:    "CredentialHelperConfig",
+			cmd:     "git",
+			args:    []string{"config", "--global", "credential.helper", "store"},
+			allowed: false,
+			message: "setting git credential.helper is not allowed: credential helpers are denied",
+		},
+		{
+			name:    "ProgramConfigKey",
+			cmd:     "git",
+			args:    []string{"-c", "core.sshCommand=sh -c id", "status"},
+			allowed: false,
+			message: "setting git core.sshcommand is not allowed: git runs its value as a program",
+		},
+		{
+			name:    "CloneUploadPack",
+			cmd:     "git",
+			args:    []string{"clone", "-u", "sh -c id", "https://example.com/repo.git"},
+			allowed: false,
+			message: "git clone -u is not allowed: it runs a program on the remote side",
+		},
+		{
+			name:    "ExecPath",
+			cmd:     "git",
+			args:    []string{"--exec-path=/tmp/bin", "status"},
+			allowed: false,
+			message: "git --exec-path is not allowed: it runs programs from another directory",
+		},
+		{
+			name:    "DirectoryOutsideAllowed",
+			cmd:     "git",
+			args:    []string{"-C", "/etc", "status"},
+			allowed: false,
+			message: "path \"/etc\" is outside of allowed directories: Command not allowed by security policy",
+		},
+	})
+}
+
+// TestValidateGitAbbreviatedOptions tests that abbreviated long options are checked as the
+// options git expands them to, and that ambiguous and unknown ones are denied.
+func TestValidateGitAbbreviatedOptions(t *testing.T) {
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories:  []string{"/tmp"},
+		Git:                 config.GitConfig{Enabled: true, AllowSubCommands: []string{"push", "clean", "fetch", "ls-remote", "clone", "archive"}},
+		DefaultErrorMessage: "Command not allowed by security policy",
+	}
+	v := New(cfg, logger.NewWithWriter(io.Discard))
+
+	runValidationTestCases(t, v, []validationTestCase{
+		{
+			name:    "CleanForce",
+			cmd:     "git",
+			args:    []string{"clean", "--forc", "-dx"},
+			allowed: false,
+			message: "git clean -f is not allowed: only dry runs (-n) of git clean may run",
+		},
+		{
+			name:    "PushForceWithLease",
+			cmd:     "git",
+			args:    []string{"push", "--force-w", "origin", "main"},
+			allowed: false,
+			message: "git push --force-with-lease is not allowed: force pushes and remote deletions are denied",
+		},
+		{
+			name:    "PushMirror",
+			cmd:     "git",
+			args:    []string{"push", "--mirr"},
+			allowed: false,
+			message: "git push --mirror is not allowed: force pushes and remote deletions are denied",
+		},
+		{
+			name:    "PushDelete",
+			cmd:     "git",
+			args:    []string{"push", "--del", "origin", "br"},
+			allowed: false,
+			message: "git push --delete is not allowed: force pushes and remote deletions are denied",
+		},
+		{
+			name:    "PushReceivePack",
+			cmd:     "git",
+			args:    []string{"push", "--receive-p=/tmp/evil", "origin"},
+			allowed: false,
+			message: "git push --receive-pack is not allowed: it runs a program on the remote side",
+		},
+		{
+			name:    "FetchUploadPack",
+			cmd:     "git",
+			args:    []string{"fetch", "--upload-p=/tmp/evil", "origin"},
+			allowed: false,
+			message: "git fetch --upload-pack is not allowed: it runs a program on the remote side",
+		},
+		{
+			name:    "LsRemoteExec",
+			cmd:     "git",
+			args:    []string{"ls-remote", "--exe", "/tmp/evil", "origin"},
+			allowed: false,
+			message: "git ls-remote --exec is not allowed: it runs a program on the remote side",
+		},
+		{
+			name:    "ArchiveExec",
+			cmd:     "git",
+			args:    []string{"archive", "--format=tar", "--ex=/tmp/evil", "--remote=origin", "HEAD"},
+			allowed: false,
+			message: "git archive --exec is not allowed: it runs a program on the remote side",
+		},
+		{
+			name:    "CloneUploadPackAttached",
+			cmd:     "git",
+			args:    []string{"clone", "-u/tmp/evil", "https://example.com/repo.git"},
+			allowed: false,
+			message: "git clone -u is not allowed: it runs a program on the remote side",
+		},
+		{
+			name:    "Ambiguous",
+			cmd:     "git",
+			args:    []string{"push", "--forc", "origin"},
+			allowed: false,
+			message: "git push --forc is not allowed: it is ambiguous between --force, --force-with-lease, --force-if-includes",
+		},
+		{
+			name:    "Unknown",
+			cmd:     "git",
+			args:    []string{"push", "--frobnicate", "origin"},
+			allowed: false,
+			message: "git push --frobnicate is not allowed: it is not an option of git push",
+		},
+		{name: "AbbreviatedAllowed", cmd: "git", args: []string{"push", "--set-up", "--no-verif", "origin", "main"}, allowed: true},
+		{name: "OptionValueNotExpanded", cmd: "git", args: []string{"push", "-o", "--frobnicate", "origin"}, allowed: true},
+		{name: "AfterSeparator", cmd: "git", args: []string{"clean", "-n", "--", "--forc"}, allowed: true},
+		{name: "ArchiveFormatOption", cmd: "git", args: []string{"archive", "--format=tar", "HEAD"}, allowed: true},
+	})
+}
+
+// TestValidateGitSafetyKeys tests that configuration keys lifting the force push and clean
+// rules may not be set.
+func TestValidateGitSafetyKeys(t *testing.T) {
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories:  []string{"/tmp"},
+		Git:                 config.GitConfig{Enabled: true, AllowSubCommands: []string{"push", "clean", "config"}},
+		DefaultErrorMessage: "Command not allowed by security policy",
+	}
+	v := New(cfg, logger.NewWithWriter(io.Discard))
+
+	runValidationTestCases(t, v, []validationTestCase{
+		{
+			name:    "CleanRequireForce",
+			cmd:     "git",
+			args:    []string{"-c", "clean.requireForce=false", "clean", "-dx"},
+			allowed: false,
+			message: "setting git clean.requireforce is not allowed: it lifts the force push and clean rules",
+		},
+		{
+			name:    "CleanRequireForceFromEnv",
+			cmd:     "git",
+			args:    []string{"--config-env=clean.requireForce=NO", "clean", "-dx"},
+			allowed: false,
+			message: "setting git clean.requireforce is not allowed: it lifts the force push and clean rules",
+		},
+		{
+			name:    "RemotePush",
+			cmd:     "git",
+			args:    []string{"-c", "remote.origin.push=+HEAD:refs/heads/main", "push", "origin"},
+			allowed: false,
+			message: "setting git remote.origin.push is not allowed: it lifts the force push and clean rules",
+		},
+		{
+			name:    "RemotePushFromEnv",
+			cmd:     "git",
+			args:    []string{"--config-env", "remote.origin.push=REFSPEC", "push", "origin"},
+			allowed: false,
+			message: "setting git remote.origin.push is not allowed: it lifts the force push and clean rules",
+		},
+		{
+			name:    "RemoteMirror",
+			cmd:     "git",
+			args:    []string{"-c", "remote.origin.mirror=true", "push", "origin"},
+			allowed: false,
+			message: "setting git remote.origin.mirror is not allowed: it lifts the force push and clean rules",
+		},
+		{
+			name:    "RemoteMirrorFromEnv",
+			cmd:     "git",
+			args:    []string{"--config-env=remote.origin.mirror=MIRROR", "push", "origin"},
+			allowed: false,
+			message: "setting git remote.origin.mirror is not allowed: it lifts the force push and clean rules",
+		},
+		{
+			name:    "PushKey",
+			cmd:     "git",
+			args:    []string{"-c", "push.default=matching", "push"},
+			allowed: false,
+			message: "setting git push.default is not allowed: it lifts the force push and clean rules",
+		},
+		{
+			name:    "PushKeyFromEnv",
+			cmd:     "git",
+			args:    []string{"--config-env=push.default=MODE", "push"},
+			allowed: false,
+			message: "setting git push.default is not allowed: it lifts the force push and clean rules",
+		},
+		{
+			name:    "ConfigSubCommand",
+			cmd:     "git",
+			args:    []string{"config", "remote.origin.push", "+HEAD:refs/heads/main"},
+			allowed: false,
+			message: "setting git remote.origin.push is not allowed: it lifts the force push and clean rules",
+		},
+		{name: "OtherRemoteKey", cmd: "git", args: []string{"-c", "remote.origin.prune=true", "push", "origin"}, allowed: true},
+	})
+}
+
+// TestValidateGitAllowCommandStillApplies tests that an allowCommands entry for git restricts it further.
+func TestValidateGitAllowCommandStillApplies(t *testing.T) {
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories:  []string{"/tmp"},
+		AllowCommands:       []config.AllowCommand{{Command: "git", DenySubCommands: []string{"commit"}}},
+		Git:                 config.GitConfig{Enabled: true},
+		DefaultErrorMessage: "Command not allowed by security policy",
+	}
+	v := New(cfg, logger.NewWithWriter(io.Discard))
+
+	allowed, _ := v.ValidateCommand("git", []string{"status"}, "/tmp")
+	assert.True(t, allowed)
+	allowed, _ = v.ValidateCommand("git", []string{"commit", "-m", "msg"}, "/tmp")
+	assert.False(t, allowed)
+	allowed, _ = v.ValidateCommand("git", []string{"push", "origin"}, "/tmp")
+	assert.False(t, allowed)
+}
+
+// TestValidateGitRemotes tests that remotes are checked against git.allowedRemotes.
+func TestValidateGitRemotes(t *testing.T) {
+	repo := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(repo, ".git"), 0o700))
+	gitConfig := `[core]
+	bare = false
+[remote "origin"]
+	url = https://github.com/acme/app.git
+	fetch = +refs/heads/*:refs/remotes/origin/*
+[remote "fork"]
+	url = git@github.com:someone/app.git
+[url "https://evil.example.com/"]
+	insteadOf = https://github.com/acme/secret
+`
+	assert.NoError(t, os.WriteFile(filepath.Join(repo, ".git", "config"), []byte(gitConfig), 0o600))
+	sub := filepath.Join(repo, "src")
+	assert.NoError(t, os.Mkdir(sub, 0o700))
+
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories: []string{repo},
+		Git: config.GitConfig{
+			Enabled:          true,
+			AllowSubCommands: []string{"push", "fetch", "clone", "remote", "config"},
+			AllowedRemotes:   []string{"https://github.com/acme/*"},
+		},
+		DefaultErrorMessage: "Command not allowed by security policy",
+	}
+	v := New(cfg, logger.NewWithWriter(io.Discard))
+
+	tests := []struct {
+		name    string
+		args    []string
+		allowed bool
+	}{
+		{"NamedRemote", []string{"push", "origin", "main"}, true},
+		{"NamedRemoteNotAllowed", []string{"push", "fork", "main"}, false},
+		{"URL", []string{"fetch", "https://github.com/acme/lib.git"}, true},
+		{"URLNotAllowed", []string{"fetch", "https://gitlab.com/acme/lib.git"}, false},
+		{"ImplicitRemoteChecksAll", []string{"fetch"}, false},
+		{"RewrittenURL", []string{"fetch", "https://github.com/acme/secret.git"}, false},
+		{"Clone", []string{"clone", "--depth", "1", "https://github.com/acme/lib.git"}, true},
+		{"CloneNotAllowed", []string{"clone", "https://example.com/lib.git"}, false},
+		{"RemoteAdd", []string{"remote", "add", "upstream", "https://github.com/acme/upstream.git"}, true},
+		{"RemoteAddNotAllowed", []string{"remote", "add", "upstream", "https://example.com/x.git"}, false},
+		{"RemoteSetURLNotAllowed", []string{"remote", "set-url", "origin", "https://example.com/x.git"}, false},
+		{"ConfigRemoteURL", []string{"config", "remote.origin.url", "https://example.com/x.git"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, message := v.ValidateCommand("git", tt.args, sub)
+			assert.Equal(t, tt.allowed, allowed, message)
+		})
+	}
+}
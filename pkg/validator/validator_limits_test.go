@@ -0,0 +1,145 @@
+package validator
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+)
+
+// TestCheckComplexity tests that scripts over the node, depth, loop, command, pipeline, and chain limits are denied.
+func TestCheckComplexity(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		limits  config.ScriptLimitsConfig
+		message string
+	}{
+		{name: "NoLimits", script: "for i in 1 2; do while true; do echo $i; done; done"},
+		{name: "UnderLimits", script: "echo a; echo b", limits: config.ScriptLimitsConfig{MaxCommands: 2, MaxLoops: 1, MaxDepth: 1}},
+		{
+			name:    "Nodes",
+			script:  "echo a b c d e f g h",
+			limits:  config.ScriptLimitsConfig{MaxNodes: 10},
+			message: "script has more than 10 syntax nodes (scriptLimits.maxNodes)",
+		},
+		{
+			name:    "Depth",
+			script:  "if true; then (echo $(ls)); fi",
+			limits:  config.ScriptLimitsConfig{MaxDepth: 2},
+			message: "script nests more than 2 levels deep (scriptLimits.maxDepth)",
+		},
+		{
+			name:   "ElifIsNotNested",
+			script: "if a; then b; elif c; then d; elif e; then f; else g; fi",
+			limits: config.ScriptLimitsConfig{MaxDepth: 1},
+		},
+		{
+			name:   "FunctionBody",
+			script: "f() { echo hi; }",
+			limits: config.ScriptLimitsConfig{MaxDepth: 1},
+		},
+		{
+			name:    "Loops",
+			script:  "for i in 1; do :; done; while false; do :; done; until true; do :; done",
+			limits:  config.ScriptLimitsConfig{MaxLoops: 2},
+			message: "script has more than 2 loops (scriptLimits.maxLoops)",
+		},
+		{
+			name:    "Commands",
+			script:  "echo a | cat; export X=1; ls",
+			limits:  config.ScriptLimitsConfig{MaxCommands: 3},
+			message: "script has more than 3 commands (scriptLimits.maxCommands)",
+		},
+		{
+			name:   "PipelineUnderLimit",
+			script: "cat a | grep b | sort; ls | wc -l",
+			limits: config.ScriptLimitsConfig{MaxPipeline: 3},
+		},
+		{
+			name:    "Pipeline",
+			script:  "cat a | grep b |& sort | uniq",
+			limits:  config.ScriptLimitsConfig{MaxPipeline: 3},
+			message: "pipeline of 4 commands is longer than the limit of 3 (scriptLimits.maxPipeline); split it into smaller scripts that can be reviewed one at a time",
+		},
+		{
+			name:    "PipelineInSubstitution",
+			script:  "echo $(a | b | c)",
+			limits:  config.ScriptLimitsConfig{MaxPipeline: 2},
+			message: "pipeline of 3 commands is longer than the limit of 2 (scriptLimits.maxPipeline); split it into smaller scripts that can be reviewed one at a time",
+		},
+		{
+			name:   "ChainUnderLimit",
+			script: "a && b || c\n{ d; e; f; }",
+			limits: config.ScriptLimitsConfig{MaxChain: 4},
+		},
+		{
+			name:    "Chain",
+			script:  "a && b; c || d\ne",
+			limits:  config.ScriptLimitsConfig{MaxChain: 4},
+			message: "chain of 5 commands joined by &&, ||, ;, or newlines is longer than the limit of 4 (scriptLimits.maxChain); split it into smaller scripts that can be reviewed one at a time",
+		},
+		{
+			name:    "ChainInLoop",
+			script:  "for i in 1; do a; b; c; done",
+			limits:  config.ScriptLimitsConfig{MaxChain: 2},
+			message: "chain of 3 commands joined by &&, ||, ;, or newlines is longer than the limit of 2 (scriptLimits.maxChain); split it into smaller scripts that can be reviewed one at a time",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &config.ShellCommandConfig{AllowedDirectories: []string{"/tmp"}, ScriptLimits: tc.limits}
+			v := New(cfg, logger.NewWithWriter(io.Discard))
+			prog, err := ParseScript(tc.script, cfg.Lang())
+			if err != nil {
+				t.Fatalf("ParseScript: %v", err)
+			}
+			violations := v.CheckComplexity(prog)
+			if tc.message == "" {
+				if len(violations) != 0 {
+					t.Fatalf("got violations %v, want none", violations)
+				}
+				return
+			}
+			if len(violations) != 1 {
+				t.Fatalf("got %d violations, want 1: %v", len(violations), violations)
+			}
+			if violations[0].Rule != RuleScriptLimit || violations[0].Message != tc.message {
+				t.Errorf("got %q (%s), want %q", violations[0].Message, violations[0].Rule, tc.message)
+			}
+		})
+	}
+}
+
+// TestScriptLimitsReport tests that ValidateScript and nested scripts apply the limits.
+func TestScriptLimitsReport(t *testing.T) {
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories: []string{"/tmp"},
+		AllowCommands:      []config.AllowCommand{{Command: "echo"}, {Command: "sh"}},
+		ScriptLimits:       config.ScriptLimitsConfig{MaxSize: 64, MaxLoops: 1},
+	}
+	v := New(cfg, logger.NewWithWriter(io.Discard))
+
+	report := v.ValidateScript(strings.Repeat("echo hi; ", 10), "/tmp")
+	if len(report.Violations) != 1 || report.Violations[0].Rule != RuleScriptLimit {
+		t.Fatalf("got %v, want a single script-limit violation", report.Violations)
+	}
+	want := "script is 90 bytes, more than the limit of 64 (scriptLimits.maxSize)"
+	if report.Violations[0].Message != want {
+		t.Errorf("Message = %q, want %q", report.Violations[0].Message, want)
+	}
+
+	runValidationTestCases(t, v, []validationTestCase{
+		{
+			name:    "NestedLoops",
+			cmd:     "sh",
+			args:    []string{"-c", "for a in 1; do for b in 2; do echo; done; done"},
+			allowed: false,
+			message: "sh: nested script has more than 1 loops (scriptLimits.maxLoops)",
+		},
+		{name: "NestedLoop", cmd: "sh", args: []string{"-c", "for a in 1; do echo; done"}, allowed: true},
+	})
+}
@@ -0,0 +1,320 @@
+package validator
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+)
+
+// newNestedTestValidator returns a validator allowing shells, wrappers, and ssh in dir.
+func newNestedTestValidator(dir string, denyNested bool) *CommandValidator {
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories: []string{dir},
+		AllowCommands: []config.AllowCommand{
+			{Command: "sh"},
+			{Command: "bash"},
+			{Command: "env"},
+			{Command: "timeout"},
+			{Command: "sudo"},
+			{Command: "ssh"},
+			{Command: "watch"},
+			{Command: "command"},
+			{Command: "exec"},
+			{Command: "builtin"},
+			{Command: "xargs"},
+			{Command: "find"},
+			{Command: "ls"},
+			{Command: "echo"},
+			{Command: "cat"},
+			{Command: "git", SubCommands: []config.SubCommandRule{{Name: "status"}}},
+		},
+		DenyCommands: []config.DenyCommand{
+			{Command: "rm", Message: "Deleting files is not allowed"},
+			{Command: "zsh"},
+		},
+		DefaultErrorMessage: "Command not allowed by security policy",
+		DenyNestedCommands:  denyNested,
+	}
+	return New(cfg, logger.NewWithWriter(io.Discard))
+}
+
+// TestValidateNestedCommand tests validation of commands run by shells, wrappers, and ssh.
+func TestValidateNestedCommand(t *testing.T) {
+	dir := t.TempDir()
+	okScript := filepath.Join(dir, "ok.sh")
+	badScript := filepath.Join(dir, "bad.sh")
+	if err := os.WriteFile(okScript, []byte("echo hi\nls "+dir+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(badScript, []byte("echo hi\nrm -rf "+dir+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	outside := t.TempDir()
+
+	runValidationTestCases(t, newNestedTestValidator(dir, false), []validationTestCase{
+		{
+			name:    "ShellAllowedScript",
+			cmd:     "sh",
+			args:    []string{"-c", "echo hi && ls " + dir},
+			allowed: true,
+		},
+		{
+			name:    "ShellCombinedFlags",
+			cmd:     "bash",
+			args:    []string{"-ec", "git status | cat"},
+			allowed: true,
+		},
+		{
+			name:    "ShellDeniedCommand",
+			cmd:     "sh",
+			args:    []string{"-c", "echo hi; rm -rf " + dir},
+			allowed: false,
+			message: `sh would execute disallowed command: command "rm" is denied: Deleting files is not allowed`,
+		},
+		{
+			name:    "ShellDeniedSubCommand",
+			cmd:     "bash",
+			args:    []string{"-c", "git push"},
+			allowed: false,
+			message: `bash would execute disallowed command: subcommand "push" is not allowed for command "git"`,
+		},
+		{
+			name:    "ShellPathOutsideAllowedDirectories",
+			cmd:     "sh",
+			args:    []string{"-c", "ls " + outside},
+			allowed: false,
+			message: `sh would execute disallowed command: path "` + outside + `" is outside of allowed directories: Command not allowed by security policy`,
+		},
+		{
+			name:    "ShellRedirectOutsideAllowedDirectories",
+			cmd:     "sh",
+			args:    []string{"-c", "echo hi > " + filepath.Join(outside, "out.txt")},
+			allowed: false,
+			message: `path "` + filepath.Join(outside, "out.txt") + `" is outside of allowed directories: Command not allowed by security policy`,
+		},
+		{
+			name:    "ShellExpansion",
+			cmd:     "sh",
+			args:    []string{"-c", "$CMD /"},
+			allowed: false,
+			message: "sh: nested command cannot be validated because it uses expansions: $CMD /",
+		},
+		{
+			name:    "ShellNestedShell",
+			cmd:     "sh",
+			args:    []string{"-c", `bash -c "rm x"`},
+			allowed: false,
+			message: `sh would execute disallowed command: bash would execute disallowed command: command "rm" is denied: Deleting files is not allowed`,
+		},
+		{
+			name:    "ShellStdin",
+			cmd:     "sh",
+			allowed: false,
+			message: "sh: reading commands from standard input cannot be validated",
+		},
+		{
+			name:    "ShellScriptFile",
+			cmd:     "sh",
+			args:    []string{okScript},
+			allowed: true,
+		},
+		{
+			name:    "ShellScriptFileDeniedCommand",
+			cmd:     "bash",
+			args:    []string{badScript},
+			allowed: false,
+			message: `bash would execute disallowed command: command "rm" is denied: Deleting files is not allowed`,
+		},
+		{
+			name:    "DeniedShell",
+			cmd:     "zsh",
+			args:    []string{"-c", "echo hi"},
+			allowed: false,
+			message: `command "zsh" is denied: Command not allowed by security policy`,
+		},
+		{
+			name:    "EnvAssignments",
+			cmd:     "env",
+			args:    []string{"-i", "LANG=C", "ls", dir},
+			allowed: true,
+		},
+		{
+			name:    "EnvDeniedCommand",
+			cmd:     "env",
+			args:    []string{"FOO=1", "rm", "x"},
+			allowed: false,
+			message: `env would execute disallowed command: command "rm" is denied: Deleting files is not allowed`,
+		},
+		{
+			name:    "EnvSplitString",
+			cmd:     "env",
+			args:    []string{"-S", "rm x"},
+			allowed: false,
+			message: "env: -S cannot be validated",
+		},
+		{
+			name:    "EnvWithoutCommand",
+			cmd:     "env",
+			allowed: true,
+		},
+		{
+			name:    "TimeoutNotPermitted",
+			cmd:     "timeout",
+			args:    []string{"-s", "KILL", "5", "curl", "example.com"},
+			allowed: false,
+			message: `timeout would execute disallowed command: command "curl" is not permitted: Command not allowed by security policy`,
+		},
+		{
+			name:    "TimeoutShell",
+			cmd:     "timeout",
+			args:    []string{"5", "sh", "-c", "echo hi"},
+			allowed: true,
+		},
+		{
+			name:    "SudoShellSession",
+			cmd:     "sudo",
+			args:    []string{"-i"},
+			allowed: false,
+			message: "sudo: shell sessions cannot be validated",
+		},
+		{
+			name:    "SudoDeniedCommand",
+			cmd:     "sudo",
+			args:    []string{"-u", "root", "rm", "x"},
+			allowed: false,
+			message: `sudo would execute disallowed command: command "rm" is denied: Deleting files is not allowed`,
+		},
+		{
+			name:    "SSHRemoteCommand",
+			cmd:     "ssh",
+			args:    []string{"-p", "2222", "host", "ls", dir},
+			allowed: true,
+		},
+		{
+			name:    "SSHDeniedRemoteCommand",
+			cmd:     "ssh",
+			args:    []string{"host", "echo hi;", "rm", "-rf", "x"},
+			allowed: false,
+			message: `ssh would execute disallowed command: command "rm" is denied: Deleting files is not allowed`,
+		},
+		{
+			name:    "SSHInteractive",
+			cmd:     "ssh",
+			args:    []string{"-l", "admin", "host"},
+			allowed: false,
+			message: "ssh: interactive sessions cannot be validated; give a remote command",
+		},
+		{
+			name:    "CommandDeniedCommand",
+			cmd:     "command",
+			args:    []string{"-p", "rm", "x"},
+			allowed: false,
+			message: `command would execute disallowed command: command "rm" is denied: Deleting files is not allowed`,
+		},
+		{
+			name:    "CommandAllowedCommand",
+			cmd:     "command",
+			args:    []string{"ls", dir},
+			allowed: true,
+		},
+		{
+			name:    "CommandLookup",
+			cmd:     "command",
+			args:    []string{"-v", "rm"},
+			allowed: true,
+		},
+		{
+			name:    "ExecDeniedCommand",
+			cmd:     "exec",
+			args:    []string{"-a", "ls", "rm", "x"},
+			allowed: false,
+			message: `exec would execute disallowed command: command "rm" is denied: Deleting files is not allowed`,
+		},
+		{
+			name:    "ExecNotPermitted",
+			cmd:     "exec",
+			args:    []string{"-cl", "curl", "example.com"},
+			allowed: false,
+			message: `exec would execute disallowed command: command "curl" is not permitted: Command not allowed by security policy`,
+		},
+		{
+			name:    "BuiltinDeniedCommand",
+			cmd:     "builtin",
+			args:    []string{"rm", "x"},
+			allowed: false,
+			message: `builtin would execute disallowed command: command "rm" is denied: Deleting files is not allowed`,
+		},
+		{
+			name:    "WatchDeniedCommand",
+			cmd:     "watch",
+			args:    []string{"-n", "1", "rm x"},
+			allowed: false,
+			message: `watch would execute disallowed command: command "rm" is denied: Deleting files is not allowed`,
+		},
+	})
+}
+
+// TestValidateNestedCommand_DenyNestedCommands tests that denyNestedCommands denies every construct running a nested command.
+func TestValidateNestedCommand_DenyNestedCommands(t *testing.T) {
+	dir := t.TempDir()
+
+	runValidationTestCases(t, newNestedTestValidator(dir, true), []validationTestCase{
+		{
+			name:    "Shell",
+			cmd:     "sh",
+			args:    []string{"-c", "echo hi"},
+			allowed: false,
+			message: "sh runs a nested command, which is not allowed",
+		},
+		{
+			name:    "Env",
+			cmd:     "env",
+			args:    []string{"ls"},
+			allowed: false,
+			message: "env runs a nested command, which is not allowed",
+		},
+		{
+			name:    "EnvWithoutCommand",
+			cmd:     "env",
+			allowed: true,
+		},
+		{
+			name:    "Exec",
+			cmd:     "exec",
+			args:    []string{"ls"},
+			allowed: false,
+			message: "exec runs a nested command, which is not allowed",
+		},
+		{
+			name:    "SSH",
+			cmd:     "ssh",
+			args:    []string{"host", "ls"},
+			allowed: false,
+			message: "ssh runs a nested command, which is not allowed",
+		},
+		{
+			name:    "Xargs",
+			cmd:     "xargs",
+			args:    []string{"ls"},
+			allowed: false,
+			message: "xargs runs a nested command, which is not allowed",
+		},
+		{
+			name:    "FindExec",
+			cmd:     "find",
+			args:    []string{dir, "-exec", "cat", "{}", ";"},
+			allowed: false,
+			message: "find runs a nested command, which is not allowed",
+		},
+		{
+			name:    "FindWithoutExec",
+			cmd:     "find",
+			args:    []string{dir, "-name", "*.go"},
+			allowed: true,
+		},
+	})
+}
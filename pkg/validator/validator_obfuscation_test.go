@@ -0,0 +1,114 @@
+package validator
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+)
+
+func newObfuscationTestValidator(t *testing.T, obfuscation config.ObfuscationConfig) (*CommandValidator, string) {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories: []string{dir},
+		AllowCommands: []config.AllowCommand{
+			{Command: "echo"}, {Command: "cat"}, {Command: "base64"}, {Command: "xxd"}, {Command: "timeout"},
+			{Command: "python3"}, {Command: "perl"}, {Command: "node"}, {Command: "sh"}, {Command: "bash"}, {Command: "eval"},
+		},
+		InterpreterInput:    config.InterpreterInputAllow,
+		Obfuscation:         obfuscation,
+		DefaultErrorMessage: "Command not allowed by security policy",
+	}
+	return New(cfg, logger.NewWithWriter(io.Discard)), dir
+}
+
+func TestValidateScript_Obfuscation(t *testing.T) {
+	deny := config.ObfuscationConfig{Action: config.ObfuscationActionDeny}
+	tests := []struct {
+		name        string
+		script      string
+		obfuscation config.ObfuscationConfig
+		want        []string
+	}{
+		{"disabled", "echo cm0gLXJmIC8K | base64 -d | python3; perl -e 'print 1'", config.ObfuscationConfig{}, nil},
+		{
+			"decoded into a shell", "echo cm0gLXJmIC8K | base64 -d | sh", deny,
+			[]string{"1:33: sh runs decoded data piped into it as code"},
+		},
+		{
+			"combined decode options", "cat payload | base64 -di | python3 -", deny,
+			[]string{"1:28: python3 runs decoded data piped into it as code"},
+		},
+		{
+			"decoded in a subshell", "(cat x | xxd -r -p) | bash", deny,
+			[]string{"1:23: bash runs decoded data piped into it as code"},
+		},
+		{
+			"decoded in a substitution", `eval "$(echo ZWNobyBoaQ== | base64 --decode)"`, deny,
+			[]string{"1:1: eval runs decoded data from a substitution as code"},
+		},
+		{
+			"decoded program of a shell", `sh -c "$(base64 -d < payload)"`, deny,
+			[]string{"1:1: sh runs decoded data from a substitution as code"},
+		},
+		{
+			"echo into a shell", "echo 'rm -rf /' | bash", deny,
+			[]string{`1:19: bash runs the output of "echo" piped into it as code`},
+		},
+		{
+			"into an interpreter through a wrapper", "cat prog.py | timeout 5 python3", deny,
+			[]string{`1:15: python3 runs the output of "cat" piped into it as code`},
+		},
+		{"program file", "echo data | python3 build.py", deny, nil},
+		{"decoding without running", "echo aGk= | base64 -d | cat", deny, nil},
+		{
+			"inline programs", "python3 -c 'import os'; perl -e 'print 1'; node --eval 1", deny,
+			[]string{
+				"1:1: python3 runs an inline program given with -c",
+				"1:25: perl runs an inline program given with -e",
+				"1:44: node runs an inline program given with --eval",
+			},
+		},
+		{"python module", "python3 -m pytest", deny, nil},
+		{
+			"nested inline program", `sh -c "python3 -c 'import os'"`, deny,
+			[]string{`1:1: sh: nested script: python3 runs an inline program given with -c`},
+		},
+		{
+			"allowed inline interpreter", "python3 -c 1; perl -e 1",
+			config.ObfuscationConfig{Action: config.ObfuscationActionDeny, AllowInline: []string{"python3"}},
+			[]string{"1:15: perl runs an inline program given with -e"},
+		},
+		{
+			"selected patterns", "echo x | sh; python3 -c 1; echo aGk= | base64 -d | sh",
+			config.ObfuscationConfig{Action: config.ObfuscationActionDeny, Patterns: []string{config.ObfuscationDecodeExec}},
+			[]string{"1:52: sh runs decoded data piped into it as code"},
+		},
+		{
+			"flag", "echo cm0gLXJmIC8K | base64 -d | sh; python3 -c 1",
+			config.ObfuscationConfig{Action: config.ObfuscationActionFlag}, nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, dir := newObfuscationTestValidator(t, tt.obfuscation)
+			var got []Violation
+			for _, violation := range v.ValidateScript(tt.script, dir).Violations {
+				if violation.Rule == RuleObfuscation {
+					got = append(got, violation)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d violations, want %d: %v", len(got), len(tt.want), got)
+			}
+			for i, want := range tt.want {
+				if !strings.HasPrefix(got[i].String(), want) {
+					t.Errorf("violation %d = %q, want prefix %q", i, got[i].String(), want)
+				}
+			}
+		})
+	}
+}
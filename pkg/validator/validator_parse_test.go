@@ -0,0 +1,39 @@
+package validator
+
+import (
+	"testing"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+func TestParseScript(t *testing.T) {
+	first, err := ParseScript("echo hello | cat", syntax.LangBash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := ParseScript("echo hello | cat", syntax.LangBash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Error("a script parsed twice was not served from the cache")
+	}
+
+	// The dialect is part of the cache key
+	if _, err := ParseScript("a=(1 2)", syntax.LangBash); err != nil {
+		t.Errorf("bash array was rejected: %v", err)
+	}
+	if _, err := ParseScript("a=(1 2)", syntax.LangPOSIX); err == nil {
+		t.Error("bash array was accepted as POSIX shell")
+	}
+
+	// Parse errors are cached too, and the pooled parser recovers from them
+	for range 2 {
+		if _, err := ParseScript("echo 'unterminated", syntax.LangBash); err == nil {
+			t.Error("unterminated quote was accepted")
+		}
+	}
+	if prog, err := ParseScript("echo fine", syntax.LangBash); err != nil || len(prog.Stmts) != 1 {
+		t.Errorf("parser did not recover after an error: %v", err)
+	}
+}
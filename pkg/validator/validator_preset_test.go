@@ -0,0 +1,46 @@
+package validator
+
+import (
+	"io"
+	"testing"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+)
+
+// TestValidateScript_Presets checks what the embedded presets allow and deny.
+func TestValidateScript_Presets(t *testing.T) {
+	tests := []struct {
+		preset  string
+		script  string
+		allowed bool
+	}{
+		{"read-only", "cat README.md | grep -n TODO", true},
+		{"read-only", "git log --oneline", true},
+		{"read-only", "git commit -m wip", false},
+		{"read-only", "rm -rf build", false},
+		{"read-only", "curl https://example.com", false},
+		{"developer", "make test && git add -A && git commit -m wip", true},
+		{"developer", "git push --force origin main", false},
+		{"developer", "git reset --hard HEAD~1", false},
+		{"developer", "echo aWQ= | base64 -d | sh", false},
+		{"developer", "sudo make install", false},
+		{"ci", "go test ./... && npm ci", true},
+		{"ci", "git push origin main", false},
+		{"ci", "python3 -c 'import os'", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.preset+"/"+tt.script, func(t *testing.T) {
+			cfg, err := config.LoadPreset(tt.preset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dir := t.TempDir()
+			cfg.AllowedDirectories = []string{dir}
+			report := New(cfg, logger.NewWithWriter(io.Discard)).ValidateScript(tt.script, dir)
+			if report.Valid() != tt.allowed {
+				t.Errorf("ValidateScript(%q) valid = %v, want %v: %v", tt.script, report.Valid(), tt.allowed, report.Violations)
+			}
+		})
+	}
+}
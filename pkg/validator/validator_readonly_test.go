@@ -0,0 +1,74 @@
+package validator
+
+import (
+	"io"
+	"testing"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+)
+
+// TestValidateReadOnlyOnly tests that read-only mode only permits commands marked readOnly.
+func TestValidateReadOnlyOnly(t *testing.T) {
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories: []string{"/tmp"},
+		AllowCommands: []config.AllowCommand{
+			{Command: "ls", ReadOnly: true},
+			{Command: "cat", ReadOnly: true},
+			{Command: "find", ReadOnly: true},
+			{Command: "xargs", ReadOnly: true},
+			{Command: "touch"},
+			{Command: "cd"},
+		},
+		DenyCommands:        []config.DenyCommand{},
+		DefaultErrorMessage: "Command not allowed by security policy",
+		ReadOnlyOnly:        true,
+	}
+	v := New(cfg, logger.NewWithWriter(io.Discard))
+
+	runValidationTestCases(t, v, []validationTestCase{
+		{
+			name:    "ReadOnlyCommand",
+			cmd:     "ls",
+			args:    []string{"-la"},
+			allowed: true,
+		},
+		{
+			name:    "WritingCommand",
+			cmd:     "touch",
+			args:    []string{"file.txt"},
+			allowed: false,
+			message: `command "touch" is not allowed in read-only mode`,
+		},
+		{
+			name:    "BuiltinIsExempt",
+			cmd:     "cd",
+			args:    []string{"/tmp"},
+			allowed: true,
+		},
+		{
+			name:    "NestedXargsCommand",
+			cmd:     "xargs",
+			args:    []string{"touch"},
+			allowed: false,
+			message: `xargs would execute disallowed command: command "touch" is not allowed in read-only mode`,
+		},
+		{
+			name:    "NestedFindExec",
+			cmd:     "find",
+			args:    []string{"/tmp", "-exec", "cat", "{}", ";"},
+			allowed: true,
+		},
+	})
+
+	// The same commands are allowed once read-only mode is turned off
+	cfg.ReadOnlyOnly = false
+	runValidationTestCases(t, v, []validationTestCase{
+		{
+			name:    "WritingCommandWithoutReadOnlyMode",
+			cmd:     "touch",
+			args:    []string{"file.txt"},
+			allowed: true,
+		},
+	})
+}
@@ -0,0 +1,207 @@
+package validator
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+)
+
+func newReportTestValidator(t *testing.T) (*CommandValidator, string) {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories: []string{dir},
+		AllowCommands: []config.AllowCommand{
+			{Command: "echo"},
+			{Command: "ls"},
+			{Command: "git", SubCommands: []config.SubCommandRule{{Name: "status"}, {Name: "log"}}},
+		},
+		DenyCommands:        []config.DenyCommand{{Command: "rm", Message: "use trash instead"}},
+		DefaultErrorMessage: "Command not allowed by security policy",
+	}
+	return New(cfg, logger.New()), dir
+}
+
+func TestValidateScript_CollectsAllViolations(t *testing.T) {
+	v, dir := newReportTestValidator(t)
+
+	script := "echo start\nrm -rf build\ngit push origin\nls /etc\ncurl example.com | echo $(wget x)\n"
+	report := v.ValidateScript(script, dir)
+
+	if report.Valid() {
+		t.Fatal("expected violations")
+	}
+
+	want := []struct {
+		command string
+		line    uint
+		column  uint
+		rule    Rule
+	}{
+		{"rm", 2, 1, RuleDenyCommand},
+		{"git", 3, 1, RuleSubCommandNotAllowed},
+		{"ls", 4, 1, RulePath},
+		{"curl", 5, 1, RuleNotAllowed},
+		{"wget", 5, 27, RuleNotAllowed},
+	}
+	if len(report.Violations) != len(want) {
+		t.Fatalf("got %d violations, want %d: %v", len(report.Violations), len(want), report.Violations)
+	}
+	for i, w := range want {
+		got := report.Violations[i]
+		if got.Command != w.command || got.Line != w.line || got.Column != w.column || got.Rule != w.rule {
+			t.Errorf("violation %d = {%s %d:%d %s}, want {%s %d:%d %s}",
+				i, got.Command, got.Line, got.Column, got.Rule, w.command, w.line, w.column, w.rule)
+		}
+		if got.Message == "" {
+			t.Errorf("violation %d has no message", i)
+		}
+	}
+
+	if report.Err() == nil {
+		t.Error("Err() = nil, want error")
+	}
+}
+
+func TestValidateScript_Valid(t *testing.T) {
+	v, dir := newReportTestValidator(t)
+
+	report := v.ValidateScript("echo hello && git status\nls \"$HOME\"", dir)
+	if !report.Valid() {
+		t.Errorf("expected no violations, got %v", report.Violations)
+	}
+	if report.Err() != nil {
+		t.Errorf("Err() = %v, want nil", report.Err())
+	}
+}
+
+// TestValidateScript_BlockLog tests that ValidateScript leaves the block log alone, since nothing
+// was blocked, while LogViolation records a violation a caller denies a run for.
+func TestValidateScript_BlockLog(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(t.TempDir(), "blocked.log")
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories:  []string{dir},
+		AllowCommands:       []config.AllowCommand{{Command: "echo"}},
+		DenyCommands:        []config.DenyCommand{{Command: "rm"}},
+		DefaultErrorMessage: "Command not allowed by security policy",
+		BlockLogPath:        logPath,
+		ScriptLimits:        config.ScriptLimitsConfig{MaxCommands: 3},
+	}
+	v := New(cfg, logger.NewWithWriter(io.Discard))
+
+	report := v.ValidateScript("echo hi\nrm -rf build\ncurl example.com", dir)
+	if report.Valid() {
+		t.Fatal("expected violations")
+	}
+	report = v.ValidateScript("echo 1; echo 2; echo 3; echo 4", dir)
+	if report.Valid() {
+		t.Fatal("expected a script limit violation")
+	}
+	if _, err := os.Stat(logPath); !os.IsNotExist(err) {
+		t.Fatalf("ValidateScript wrote the block log: %v", err)
+	}
+
+	v.WithWorkDir(dir).LogViolation(report.Violations[0])
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), string(RuleScriptLimit)) {
+		t.Errorf("block log = %q, want a %s record", data, RuleScriptLimit)
+	}
+}
+
+func TestValidateScript_ParseError(t *testing.T) {
+	v, dir := newReportTestValidator(t)
+
+	report := v.ValidateScript("echo 'unterminated", dir)
+	if len(report.Violations) != 1 {
+		t.Fatalf("got %d violations, want 1", len(report.Violations))
+	}
+	if report.Violations[0].Rule != RuleParse {
+		t.Errorf("rule = %q, want %q", report.Violations[0].Rule, RuleParse)
+	}
+	if report.Violations[0].Line != 1 {
+		t.Errorf("line = %d, want 1", report.Violations[0].Line)
+	}
+}
+
+func TestValidateScript_Risk(t *testing.T) {
+	v, dir := newReportTestValidator(t)
+	script := "echo ok\ncurl example.com | sh"
+
+	// Without a threshold the score is reported but never a violation
+	report := v.ValidateScript(script, dir)
+	if report.Risk.Score != 65 {
+		t.Errorf("Risk.Score = %d, want 65", report.Risk.Score)
+	}
+	if len(report.Violations) != 2 {
+		t.Fatalf("got %d violations, want 2 (curl, sh): %v", len(report.Violations), report.Violations)
+	}
+
+	v.config.Risk = config.RiskConfig{Threshold: 50, Action: config.RiskActionApprove}
+	if report := v.ValidateScript(script, dir); len(report.Violations) != 2 {
+		t.Errorf("approve action: got %d violations, want 2", len(report.Violations))
+	}
+
+	v.config.Risk.Action = config.RiskActionDeny
+	report = v.ValidateScript(script, dir)
+	if len(report.Violations) != 3 {
+		t.Fatalf("deny action: got %d violations, want 3: %v", len(report.Violations), report.Violations)
+	}
+	got := report.Violations[2]
+	if got.Rule != RuleRisk || got.Line != 2 || got.Column != 20 {
+		t.Errorf("risk violation = {%d:%d %s}, want {2:20 %s}", got.Line, got.Column, got.Rule, RuleRisk)
+	}
+	if want := "script risk score 65 exceeds the threshold of 50 (obfuscation, network)"; got.Message != want {
+		t.Errorf("message = %q, want %q", got.Message, want)
+	}
+}
+
+func TestValidateScript_RuleReferences(t *testing.T) {
+	v, dir := newReportTestValidator(t)
+	v.config.DenyCommands = []config.DenyCommand{
+		{Command: "rm", Message: "use trash instead"},
+		{Command: "curl", ID: "no-network", DocsURL: "https://wiki.example.com/policy#network", Source: "/etc/policy.json"},
+	}
+	v.config.AllowCommands[2].Source = "/etc/policy.json"
+
+	report := v.ValidateScript("rm -rf build\ncurl example.com\ngit push", dir)
+	if len(report.Violations) != 3 {
+		t.Fatalf("got %d violations, want 3: %v", len(report.Violations), report.Violations)
+	}
+
+	// A rule without an ID, source, or documentation is referenced but not quoted
+	rm := report.Violations[0]
+	if rm.Ref == nil || rm.Ref.ID != "deny:rm" || rm.Ref.List != "denyCommands" || rm.Ref.Index != 0 {
+		t.Errorf("rm reference = %+v", rm.Ref)
+	}
+	if want := `command "rm" is denied: use trash instead`; rm.Message != want {
+		t.Errorf("rm message = %q, want %q", rm.Message, want)
+	}
+
+	curl := report.Violations[1]
+	want := `command "curl" is denied: Command not allowed by security policy ` +
+		`[rule "no-network" (denyCommands[1] in /etc/policy.json), see https://wiki.example.com/policy#network]`
+	if curl.Message != want {
+		t.Errorf("curl message = %q, want %q", curl.Message, want)
+	}
+
+	// Subcommand rules are referenced by the allow rule they belong to
+	git := report.Violations[2]
+	want = `subcommand "push" is not allowed for command "git" [rule "allow:git" (allowCommands[2] in /etc/policy.json)]`
+	if git.Message != want {
+		t.Errorf("git message = %q, want %q", git.Message, want)
+	}
+
+	// Commands not in any list have no rule behind them
+	if report := v.ValidateScript("wget example.com", dir); report.Violations[0].Ref != nil {
+		t.Errorf("not-allowed reference = %+v, want nil", report.Violations[0].Ref)
+	}
+}
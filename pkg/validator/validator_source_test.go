@@ -0,0 +1,132 @@
+package validator
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+)
+
+// TestValidateSourceCommand tests that files run with source or . are resolved against the
+// allowed directories and validated with their contents.
+func TestValidateSourceCommand(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	createScriptFiles(t, dir, []scriptFile{
+		{name: "ok.sh", content: "echo hi\nexport GREETING=hi\n"},
+		{name: "rm.sh", content: "echo hi\nrm -rf /\n"},
+		{name: "declare.sh", content: "readonly X=1\n"},
+		{name: "nested.sh", content: ". ./ok.sh\n"},
+		{name: "nested-rm.sh", content: "source ./rm.sh\n"},
+		{name: "loop.sh", content: "source ./loop.sh\n"},
+		{name: "dynamic.sh", content: `source "$FILE"` + "\n"},
+		{name: "bad.sh", content: "echo 'unterminated\n"},
+	})
+	createScriptFiles(t, outside, []scriptFile{{name: "ok.sh", content: "echo hi\n"}})
+
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories:  []string{dir},
+		AllowCommands:       []config.AllowCommand{{Command: "echo"}, {Command: "source"}, {Command: "."}, {Command: "export"}},
+		DenyCommands:        []config.DenyCommand{{Command: "rm", Message: "rm is denied"}},
+		DefaultErrorMessage: "not allowed",
+		ScriptLimits:        config.ScriptLimitsConfig{MaxSourceDepth: 3},
+	}
+	v := New(cfg, logger.New())
+
+	tests := []struct {
+		name    string
+		cmd     string
+		args    []string
+		rule    Rule
+		message string
+	}{
+		{name: "Allowed", cmd: "source", args: []string{"ok.sh"}},
+		{name: "DotAllowed", cmd: ".", args: []string{filepath.Join(dir, "ok.sh"), "arg"}},
+		{name: "NoFile", cmd: "source"},
+		{name: "Nested", cmd: "source", args: []string{"nested.sh"}},
+		{
+			name:    "OutsideAllowedDirectories",
+			cmd:     "source",
+			args:    []string{filepath.Join(outside, "ok.sh")},
+			rule:    RulePath,
+			message: "is outside of allowed directories",
+		},
+		{name: "DeniedCommand", cmd: "source", args: []string{"rm.sh"}, rule: RuleDenyCommand, message: "rm.sh: line 2: "},
+		{name: "NestedDeniedCommand", cmd: ".", args: []string{"nested-rm.sh"}, rule: RuleDenyCommand, message: "rm.sh: line 2: "},
+		{name: "Declaration", cmd: "source", args: []string{"declare.sh"}, rule: RuleNotAllowed, message: "declare.sh: line 1: "},
+		{
+			name:    "Depth",
+			cmd:     "source",
+			args:    []string{"loop.sh"},
+			rule:    RuleSource,
+			message: "files are sourced more than 3 levels deep (scriptLimits.maxSourceDepth)",
+		},
+		{
+			name:    "DynamicFile",
+			cmd:     "source",
+			args:    []string{"dynamic.sh"},
+			rule:    RuleSource,
+			message: "dynamic.sh: line 1: the file to source comes from an expansion and cannot be validated",
+		},
+		{name: "Missing", cmd: "source", args: []string{"missing.sh"}, rule: RuleSource, message: "cannot validate sourced file"},
+		{name: "ParseError", cmd: "source", args: []string{"bad.sh"}, rule: RuleParse, message: "failed to parse script"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := v.validateInvocation(tc.cmd, tc.args, dir)
+			if d.Allowed != (tc.rule == "") {
+				t.Fatalf("validateInvocation() allowed = %v, want %v (%s)", d.Allowed, tc.rule == "", d.Message)
+			}
+			if d.Rule != tc.rule {
+				t.Errorf("validateInvocation() rule = %q, want %q", d.Rule, tc.rule)
+			}
+			if !strings.Contains(d.Message, tc.message) {
+				t.Errorf("validateInvocation() message = %q, want it to contain %q", d.Message, tc.message)
+			}
+		})
+	}
+}
+
+// TestValidateSourceCommandPolicy tests that source itself remains subject to the builtins
+// policy and the allowlist.
+func TestValidateSourceCommandPolicy(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ok.sh")
+	if err := os.WriteFile(path, []byte("echo hi\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories:  []string{dir},
+		AllowCommands:       []config.AllowCommand{{Command: "echo"}},
+		DefaultErrorMessage: "not allowed",
+	}
+	v := New(cfg, logger.New())
+	if d := v.validateInvocation("source", []string{path}, dir); d.Allowed || d.Rule != RuleNotAllowed {
+		t.Errorf("source outside the allowlist: allowed = %v, rule = %q", d.Allowed, d.Rule)
+	}
+
+	cfg.Builtins.Allow = []string{"source"}
+	if d := v.validateInvocation("source", []string{path}, dir); !d.Allowed {
+		t.Errorf("source allowed by the builtins policy was denied: %s", d.Message)
+	}
+
+	cfg.Builtins = config.BuiltinPolicy{Deny: []string{"."}}
+	if d := v.validateInvocation(".", []string{path}, dir); d.Allowed || d.Rule != RuleBuiltin {
+		t.Errorf(". denied by the builtins policy: allowed = %v, rule = %q", d.Allowed, d.Rule)
+	}
+
+	// Validating a script follows the files it sources
+	cfg.Builtins = config.BuiltinPolicy{Allow: []string{"source"}}
+	if err := os.WriteFile(path, []byte("curl example.com\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	report := v.ValidateScript("echo start; source ok.sh", dir)
+	if report.Valid() || report.Violations[0].Line != 1 || !strings.Contains(report.Violations[0].Message, `command "curl" is not permitted`) {
+		t.Errorf("ValidateScript() = %+v, want the command of the sourced file denied", report.Violations)
+	}
+}
@@ -0,0 +1,87 @@
+package validator
+
+import (
+	"io"
+	"testing"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+)
+
+// TestSubCommandWildcardsAndHierarchy tests multi-word and wildcard subcommand rules through ValidateCommand.
+func TestSubCommandWildcardsAndHierarchy(t *testing.T) {
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories: []string{t.TempDir()},
+		AllowCommands: []config.AllowCommand{
+			{
+				Command: "kubectl",
+				SubCommands: []config.SubCommandRule{
+					{Name: "get *", DenyFlags: []string{"--all-namespaces", "-A"}},
+					{Name: "describe *"},
+				},
+				DenySubCommands: []string{"get secrets"},
+			},
+			{
+				Command:     "docker",
+				SubCommands: []config.SubCommandRule{{Name: "compose up"}, {Name: "compose ps"}, {Name: "ps"}},
+			},
+			{
+				Command:         "git",
+				SubCommands:     []config.SubCommandRule{{Name: "remote *"}, {Name: "status"}},
+				DenySubCommands: []string{"remote add", "remote set-url"},
+			},
+		},
+		DefaultErrorMessage: "Command not allowed by security policy",
+	}
+	v := New(cfg, logger.NewWithWriter(io.Discard))
+
+	runValidationTestCases(t, v, []validationTestCase{
+		{name: "WildcardResource", cmd: "kubectl", args: []string{"get", "pods", "-n", "default"}, allowed: true},
+		{name: "WildcardWithoutResource", cmd: "kubectl", args: []string{"get"}, allowed: true},
+		{
+			name:    "WildcardDenyFlag",
+			cmd:     "kubectl",
+			args:    []string{"get", "pods", "-A"},
+			allowed: false,
+			message: `flag "-A" is not allowed for command "kubectl get pods"`,
+		},
+		{
+			name:    "WildcardDoesNotSwallowFlags",
+			cmd:     "kubectl",
+			args:    []string{"get", "--all-namespaces", "pods"},
+			allowed: false,
+			message: `flag "--all-namespaces" is not allowed for command "kubectl get"`,
+		},
+		{
+			name:    "MultiWordDenyBeatsWildcardAllow",
+			cmd:     "kubectl",
+			args:    []string{"get", "secrets"},
+			allowed: false,
+			message: `subcommand "get secrets" is denied for command "kubectl"`,
+		},
+		{
+			name:    "UnlistedSubCommand",
+			cmd:     "kubectl",
+			args:    []string{"delete", "pod", "x"},
+			allowed: false,
+			message: `subcommand "delete" is not allowed for command "kubectl"`,
+		},
+		{name: "MultiWordAllow", cmd: "docker", args: []string{"compose", "up", "-d"}, allowed: true},
+		{
+			name:    "MultiWordNotAllowed",
+			cmd:     "docker",
+			args:    []string{"compose", "down"},
+			allowed: false,
+			message: `subcommand "compose" is not allowed for command "docker"`,
+		},
+		{name: "SingleWordAlongsideMultiWord", cmd: "docker", args: []string{"ps"}, allowed: true},
+		{name: "RemoteWildcardAllowed", cmd: "git", args: []string{"remote", "show", "origin"}, allowed: true},
+		{
+			name:    "RemoteAddDenied",
+			cmd:     "git",
+			args:    []string{"remote", "add", "evil", "https://example.com"},
+			allowed: false,
+			message: `subcommand "remote add" is denied for command "git"`,
+		},
+	})
+}
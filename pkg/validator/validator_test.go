@@ -2,12 +2,16 @@ package validator
 
 import (
 	"bytes"
+	"encoding/json"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 
+	"github.com/shimizu1995/secure-shell-server/pkg/audit"
 	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
 	"github.com/shimizu1995/secure-shell-server/pkg/logger"
 )
 
@@ -200,7 +204,7 @@ func TestValidatePathArguments(t *testing.T) {
 			// Reset log buffer for each test
 			logBuffer.Reset()
 
-			gotAllowed, _ := v.validatePathArguments(tt.cmd, tt.args, tt.workDir)
+			gotAllowed := v.validatePathArguments(tt.cmd, tt.args, tt.workDir).Allowed
 			if gotAllowed != tt.allowed {
 				t.Errorf("validatePathArguments() allowed = %v, want %v", gotAllowed, tt.allowed)
 			}
@@ -235,7 +239,8 @@ func TestValidateCommand(t *testing.T) {
 		},
 		DenyCommands: []config.DenyCommand{
 			{Command: "rm", Message: "Remove command is not allowed"},
-			{Command: "sudo", Message: "Sudo is not allowed for security reasons"}, // With custom error message
+			{Command: "sudo", Message: "Sudo is not allowed for security reasons"},                 // With custom error message
+			{Command: "cat", Args: []string{"secrets.txt"}, Message: "Honeypot file", Alert: true}, // Only with some arguments
 		},
 		DefaultErrorMessage: "Command not allowed by security policy",
 		BlockLogPath:        "", // Don't write to a log file in tests
@@ -266,6 +271,7 @@ func TestValidateCommand(t *testing.T) {
 		// Test denied commands
 		{name: "ExplicitlyDeniedCommand", cmd: "rm", args: []string{"-rf", tempWorkDir}, allowed: false, message: "command \"rm\" is denied: Remove command is not allowed"},
 		{name: "DeniedCommandWithCustomMessage", cmd: "sudo", args: []string{"apt-get", "update"}, allowed: false, message: "command \"sudo\" is denied: Sudo is not allowed for security reasons"},
+		{name: "DeniedCommandArgument", cmd: "cat", args: []string{filepath.Join(tempWorkDir, "secrets.txt")}, allowed: false, message: "command \"cat\" is denied: Honeypot file"},
 		{name: "UnlistedCommand", cmd: "wget", args: []string{"https://example.com"}, allowed: false, message: "command \"wget\" is not permitted: Command not allowed by security policy"},
 		{name: "ChmodNotInAllowList", cmd: "chmod", args: []string{"777", filepath.Join(tempWorkDir, "file.txt")}, allowed: false, message: "command \"chmod\" is not permitted: Command not allowed by security policy"},
 
@@ -530,9 +536,26 @@ func TestCommandLogging(t *testing.T) {
 		t.Fatalf("Failed to read log file: %v", err)
 	}
 
-	logStr := string(logContent)
-	if !strings.Contains(logStr, "[BLOCKED] Command: rm [-rf ") || !strings.Contains(logStr, filepath.Base(tempWorkDir)) {
-		t.Errorf("Expected blocked command log entry, got: %s", logStr)
+	// Each blocked command is one JSON record
+	var record audit.BlockRecord
+	if err := json.Unmarshal(logContent, &record); err != nil {
+		t.Fatalf("Expected a JSON record, got: %s", logContent)
+	}
+	want := audit.BlockRecord{Command: "rm", Argv: []string{"-rf", tempWorkDir}, Rule: string(RuleDenyCommand), Cwd: wd}
+	if record.Command != want.Command || !slices.Equal(record.Argv, want.Argv) || record.Rule != want.Rule || record.Cwd != want.Cwd || record.Timestamp.IsZero() {
+		t.Errorf("record = %+v, want %+v", record, want)
+	}
+
+	// The reader finds it again
+	v.WithIdentity(identity.Identity{User: "alice", Session: "s1"}).ValidateCommand("curl", nil, wd)
+	reader := audit.NewReader(logPath)
+	records, err := reader.Records(audit.Filter{Session: "s1"})
+	if err != nil || len(records) != 1 || records[0].Command != "curl" || records[0].Identity.User != "alice" {
+		t.Errorf("Records() = %+v, %v", records, err)
+	}
+	counts, err := reader.CountBy(audit.Filter{}, audit.ByRule)
+	if err != nil || counts[string(RuleDenyCommand)] != 1 || counts[string(RuleNotAllowed)] != 1 {
+		t.Errorf("CountBy() = %v, %v", counts, err)
 	}
 }
 
@@ -716,6 +739,53 @@ func TestIsDirectoryAllowedWithSymlink(t *testing.T) {
 	}
 }
 
+// TestAllowedDirectoryGlob tests glob patterns in the allowed directory list.
+func TestAllowedDirectoryGlob(t *testing.T) {
+	root := t.TempDir()
+	outsideDir := t.TempDir()
+	for _, dir := range []string{"alpha/workspace", "beta", "evil"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outsideDir, filepath.Join(root, "evil", "workspace")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories:  []string{filepath.Join(root, "*", "workspace")},
+		DefaultErrorMessage: "Not allowed",
+	}
+	v := New(cfg, logger.NewWithWriter(&bytes.Buffer{}))
+
+	tests := []struct {
+		dir     string
+		allowed bool
+	}{
+		{filepath.Join(root, "alpha", "workspace"), true},
+		{filepath.Join(root, "alpha", "workspace", "sub"), true},
+		{filepath.Join(root, "alpha"), false},
+		{filepath.Join(root, "beta"), false},
+		// The symlink matches the pattern, but its target does not
+		{filepath.Join(root, "evil", "workspace"), false},
+		{outsideDir, false},
+	}
+	for _, tt := range tests {
+		if allowed, _ := v.IsDirectoryAllowed(tt.dir); allowed != tt.allowed {
+			t.Errorf("IsDirectoryAllowed(%q) = %v, want %v", tt.dir, allowed, tt.allowed)
+		}
+	}
+
+	// A directory created after the validator is allowed once it matches
+	newDir := filepath.Join(root, "beta", "workspace")
+	if err := os.Mkdir(newDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if allowed, _ := v.IsPathInAllowedDirectory("file.txt", newDir); !allowed {
+		t.Errorf("IsPathInAllowedDirectory in %q should be allowed", newDir)
+	}
+}
+
 // TestMultiLevelSymlink tests that chained symlinks are fully resolved.
 func TestMultiLevelSymlink(t *testing.T) {
 	allowedDir := t.TempDir()
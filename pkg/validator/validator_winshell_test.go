@@ -0,0 +1,79 @@
+package validator
+
+import (
+	"io"
+	"testing"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+)
+
+// TestValidateWindowsShellCommand tests cmd and PowerShell validation through ValidateCommand.
+func TestValidateWindowsShellCommand(t *testing.T) {
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories: []string{t.TempDir()},
+		AllowCommands: []config.AllowCommand{
+			{Command: "cmd"},
+			{Command: "powershell"},
+			{Command: "dir"},
+			{Command: "echo"},
+			{Command: "git", SubCommands: []config.SubCommandRule{{Name: "status"}}},
+		},
+		DenyCommands: []config.DenyCommand{
+			{Command: "del", Message: "Deleting files is not allowed"},
+			{Command: "pwsh"},
+		},
+		DefaultErrorMessage: "Command not allowed by security policy",
+	}
+	v := New(cfg, logger.NewWithWriter(io.Discard))
+
+	runValidationTestCases(t, v, []validationTestCase{
+		{
+			name:    "CmdAllowedCommands",
+			cmd:     "cmd",
+			args:    []string{"/c", "dir && echo done"},
+			allowed: true,
+		},
+		{
+			name:    "CmdExecutableSuffix",
+			cmd:     "cmd",
+			args:    []string{"/c", "git.exe status"},
+			allowed: true,
+		},
+		{
+			name:    "CmdDeniedCommand",
+			cmd:     "cmd",
+			args:    []string{"/c", "dir & del important.txt"},
+			allowed: false,
+			message: `cmd would execute disallowed command: command "del" is denied: Deleting files is not allowed`,
+		},
+		{
+			name:    "CmdDeniedSubCommand",
+			cmd:     "cmd",
+			args:    []string{"/c", "git push"},
+			allowed: false,
+			message: `cmd would execute disallowed command: subcommand "push" is not allowed for command "git"`,
+		},
+		{
+			name:    "PowerShellNotAllowedCmdlet",
+			cmd:     "powershell",
+			args:    []string{"-Command", "Remove-Item x"},
+			allowed: false,
+			message: `powershell would execute disallowed command: command "remove-item" is not permitted: Command not allowed by security policy`,
+		},
+		{
+			name:    "PowerShellEncodedCommand",
+			cmd:     "powershell",
+			args:    []string{"-EncodedCommand", "ZQBjAGgAbwA="},
+			allowed: false,
+			message: "powershell: encoded commands cannot be validated",
+		},
+		{
+			name:    "DeniedShell",
+			cmd:     "pwsh",
+			args:    []string{"-Command", "echo hi"},
+			allowed: false,
+			message: `command "pwsh" is denied: Command not allowed by security policy`,
+		},
+	})
+}
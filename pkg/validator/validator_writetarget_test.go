@@ -0,0 +1,132 @@
+package validator
+
+import (
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"mvdan.cc/sh/v3/syntax"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+)
+
+func TestCheckWriteTarget(t *testing.T) {
+	dir := t.TempDir()
+	readOnly := filepath.Join(dir, "vendor")
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories:  []string{dir, "/dev", "/proc"},
+		ReadOnlyDirectories: []string{readOnly},
+		DefaultErrorMessage: "Command not allowed by security policy",
+	}
+	v := New(cfg, logger.NewWithWriter(io.Discard))
+
+	tests := []struct {
+		name    string
+		path    string
+		allowed bool
+		message string
+	}{
+		{name: "FileInAllowedDirectory", path: "out.txt", allowed: true},
+		{name: "ReadOnlyDirectory", path: "vendor/lib.go", message: "is read-only"},
+		{name: "ReadOnlyDirectoryItself", path: readOnly, message: "is read-only"},
+		{name: "SiblingOfReadOnlyDirectory", path: readOnly + "-new/lib.go", allowed: true},
+		{name: "OutsideAllowedDirectories", path: "/etc/passwd", message: "is outside of allowed directories"},
+		{name: "Proc", path: "/proc/sys/kernel/hostname", message: "/proc is never writable"},
+		{name: "DevNull", path: "/dev/null", allowed: true},
+		{name: "OtherDevice", path: "/dev/zero", message: "is not in writableDevices"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, message := v.CheckWriteTarget(tt.path, dir)
+			if allowed != tt.allowed {
+				t.Errorf("CheckWriteTarget(%q) allowed = %v, want %v (%s)", tt.path, allowed, tt.allowed, message)
+			}
+			if !strings.Contains(message, tt.message) {
+				t.Errorf("CheckWriteTarget(%q) message = %q, want it to contain %q", tt.path, message, tt.message)
+			}
+		})
+	}
+
+	// Configured devices replace the default list
+	cfg.WritableDevices = []string{"/dev/zero"}
+	if allowed, message := v.CheckWriteTarget("/dev/zero", dir); !allowed {
+		t.Errorf("CheckWriteTarget(/dev/zero) with writableDevices = %q, want allowed", message)
+	}
+	if allowed, _ := v.CheckWriteTarget("/dev/null", dir); allowed {
+		t.Error("CheckWriteTarget(/dev/null) is allowed although writableDevices does not list it")
+	}
+}
+
+func TestValidateWriteTargets(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories:  []string{dir},
+		ReadOnlyDirectories: []string{filepath.Join(dir, "vendor")},
+		AllowCommands:       []config.AllowCommand{{Command: "tee"}, {Command: "sh"}, {Command: "echo"}},
+		DefaultErrorMessage: "Command not allowed by security policy",
+	}
+	v := New(cfg, logger.NewWithWriter(io.Discard))
+
+	tests := []struct {
+		name    string
+		cmd     string
+		args    []string
+		allowed bool
+		rule    Rule
+	}{
+		{name: "TeeIntoAllowedDirectory", cmd: "tee", args: []string{"-a", "out.txt"}, allowed: true},
+		{name: "TeeIntoReadOnlyDirectory", cmd: "tee", args: []string{"out.txt", "vendor/lib.go"}, rule: RuleWriteTarget},
+		{name: "TeeOperandAfterDoubleDash", cmd: "tee", args: []string{"--", "-vendor"}, allowed: true},
+		{name: "TeeOutsideAllowedDirectories", cmd: "tee", args: []string{"/etc/motd"}, rule: RulePath},
+		{name: "NestedRedirectIntoReadOnlyDirectory", cmd: "sh", args: []string{"-c", "echo x > vendor/lib.go"}, rule: RuleWriteTarget},
+		{name: "NestedClobberIntoReadOnlyDirectory", cmd: "sh", args: []string{"-c", "echo x >| vendor/lib.go"}, rule: RuleWriteTarget},
+		{name: "NestedInputFromReadOnlyDirectory", cmd: "sh", args: []string{"-c", "tee out.txt < vendor/lib.go"}, allowed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := v.CheckCommand(tt.cmd, tt.args, dir)
+			if d.Allowed != tt.allowed {
+				t.Errorf("CheckCommand(%s %v) allowed = %v, want %v (%s)", tt.cmd, tt.args, d.Allowed, tt.allowed, d.Message)
+			}
+			if d.Rule != tt.rule {
+				t.Errorf("CheckCommand(%s %v) rule = %q, want %q", tt.cmd, tt.args, d.Rule, tt.rule)
+			}
+		})
+	}
+}
+
+func TestCheckRedirects(t *testing.T) {
+	v := New(&config.ShellCommandConfig{}, logger.NewWithWriter(io.Discard))
+
+	tests := []struct {
+		script  string
+		message string
+	}{
+		{script: "echo x > out.txt 2>&1 < in.txt"},
+		{script: "echo x >&2; echo y 2>&-"},
+		{script: "echo x >| out.txt", message: "the >| redirection is not supported"},
+		{script: "cat <> out.txt", message: "the <> redirection is not supported"},
+		{script: "echo x 3> out.txt", message: "redirection of file descriptor 3 is not supported"},
+		{script: "echo x >&3", message: "the >&3 redirection is not supported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.script, func(t *testing.T) {
+			prog, err := ParseScript(tt.script, syntax.LangBash)
+			if err != nil {
+				t.Fatalf("ParseScript() error = %v", err)
+			}
+			violations := v.CheckRedirects(prog)
+			if tt.message == "" {
+				if len(violations) > 0 {
+					t.Errorf("CheckRedirects() = %v, want none", violations)
+				}
+				return
+			}
+			if len(violations) != 1 || violations[0].Rule != RuleRedirect || !strings.Contains(violations[0].Message, tt.message) {
+				t.Errorf("CheckRedirects() = %v, want one %q violation", violations, tt.message)
+			}
+		})
+	}
+}
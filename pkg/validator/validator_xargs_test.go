@@ -173,7 +173,8 @@ func runXargsValidationTests(t *testing.T, v *CommandValidator, tests []struct {
 				t.Fatalf("Failed to get working directory: %v", err)
 			}
 
-			gotAllowed, gotMessage := v.validateXargsCommand(tt.args, wd)
+			d := v.validateXargsCommand(tt.args, wd)
+			gotAllowed, gotMessage := d.Allowed, d.Message
 			if gotAllowed != tt.allowed {
 				t.Errorf("validateXargsCommand() allowed = %v, want %v", gotAllowed, tt.allowed)
 			}
@@ -0,0 +1,190 @@
+package validator
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// windowsExecutableSuffixes are stripped from command names so that "git.exe" matches an allowlist entry for "git".
+var windowsExecutableSuffixes = []string{".exe", ".bat", ".cmd", ".com", ".ps1"}
+
+// NormalizeCommandName reduces a command as invoked to the name used for allowlist matching.
+// Absolute paths are reduced to their base name; on Windows, names are also lowercased and
+// executable suffixes such as .exe and .bat are removed.
+func NormalizeCommandName(cmd string) string {
+	if runtime.GOOS == "windows" {
+		return normalizeWindowsCommandName(cmd)
+	}
+	if filepath.IsAbs(cmd) {
+		return filepath.Base(cmd)
+	}
+	return cmd
+}
+
+// normalizeWindowsCommandName applies Windows matching rules regardless of the host OS.
+func normalizeWindowsCommandName(cmd string) string {
+	if i := strings.LastIndexAny(cmd, `\/`); i >= 0 {
+		cmd = cmd[i+1:]
+	}
+	cmd = strings.ToLower(cmd)
+	for _, suffix := range windowsExecutableSuffixes {
+		if trimmed, ok := strings.CutSuffix(cmd, suffix); ok && trimmed != "" {
+			return trimmed
+		}
+	}
+	return cmd
+}
+
+// IsWindowsShell checks if the command is cmd.exe or PowerShell.
+func IsWindowsShell(cmd string) bool {
+	switch normalizeWindowsCommandName(cmd) {
+	case "cmd", "powershell", "pwsh":
+		return true
+	default:
+		return false
+	}
+}
+
+// WindowsShellParser extracts the commands run by cmd /c and powershell -Command.
+type WindowsShellParser struct{}
+
+// NewWindowsShellParser creates a new WindowsShellParser.
+func NewWindowsShellParser() *WindowsShellParser {
+	return &WindowsShellParser{}
+}
+
+// ParseWindowsShellArgs returns the script passed to a Windows shell and any file it runs.
+// The returned error message is non-empty when the invocation cannot be validated.
+func (p *WindowsShellParser) ParseWindowsShellArgs(cmd string, args []string) (script string, file string, errMsg string) {
+	if normalizeWindowsCommandName(cmd) == "cmd" {
+		return parseCmdArgs(args)
+	}
+	return parsePowerShellArgs(args)
+}
+
+// parseCmdArgs extracts the command following /c or /k.
+func parseCmdArgs(args []string) (string, string, string) {
+	for i, arg := range args {
+		switch strings.ToLower(arg) {
+		case "/c", "/k", "/r":
+			if i+1 >= len(args) {
+				return "", "", "cmd: missing command after " + arg
+			}
+			return strings.Join(args[i+1:], " "), "", ""
+		}
+	}
+	return "", "", "cmd: interactive sessions are not allowed; use /c"
+}
+
+// parsePowerShellArgs extracts the -Command script or -File path.
+func parsePowerShellArgs(args []string) (string, string, string) {
+	for i, arg := range args {
+		flag := strings.ToLower(strings.TrimLeft(arg, "-/"))
+		switch flag {
+		case "encodedcommand", "enc", "e", "ec":
+			return "", "", "powershell: encoded commands cannot be validated"
+		case "command", "c":
+			if i+1 >= len(args) {
+				return "", "", "powershell: missing script after " + arg
+			}
+			return strings.Join(args[i+1:], " "), "", ""
+		case "file", "f":
+			if i+1 >= len(args) {
+				return "", "", "powershell: missing path after " + arg
+			}
+			return "", args[i+1], ""
+		}
+		// PowerShell treats the first positional argument as the command
+		if !strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "/") {
+			return strings.Join(args[i:], " "), "", ""
+		}
+	}
+	return "", "", "powershell: interactive sessions are not allowed; use -Command"
+}
+
+// SplitWindowsScript splits a cmd or PowerShell script into its commands, each as a list of words.
+// Commands are separated by &, &&, |, ||, and ; outside of quotes.
+func SplitWindowsScript(script string) [][]string {
+	var commands [][]string
+	var words []string
+	var word strings.Builder
+	var quote rune
+	inWord := false
+
+	endWord := func() {
+		if inWord {
+			words = append(words, word.String())
+			word.Reset()
+			inWord = false
+		}
+	}
+	endCommand := func() {
+		endWord()
+		if len(words) > 0 {
+			commands = append(commands, words)
+			words = nil
+		}
+	}
+
+	for _, c := range script {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			inWord = true
+		case c == '&' || c == '|' || c == ';':
+			endCommand()
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			endWord()
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	endCommand()
+
+	return commands
+}
+
+// validateWindowsShellCommand checks that every command run through cmd or PowerShell is allowed.
+func (v *CommandValidator) validateWindowsShellCommand(cmd string, args []string, workDir string) Decision {
+	// Check if the shell is explicitly denied
+	if denied, message, ref := v.isCommandExplicitlyDenied(cmd, args); denied {
+		return v.denyBy(RuleDenyCommand, ref, cmd, args, message)
+	}
+
+	// Check if the shell is explicitly allowed
+	if !v.config.IsCommandAllowed(cmd) {
+		return v.denyNotPermitted(cmd, args)
+	}
+
+	parser := NewWindowsShellParser()
+	script, file, errMsg := parser.ParseWindowsShellArgs(cmd, args)
+	if errMsg != "" {
+		return v.deny(RuleNestedCommand, cmd, args, errMsg)
+	}
+
+	if file != "" {
+		return v.validatePathArguments(cmd, []string{file}, workDir)
+	}
+	if v.config.DenyNestedCommands {
+		return v.denyNested(cmd, args)
+	}
+
+	for _, words := range SplitWindowsScript(script) {
+		name := normalizeWindowsCommandName(words[0])
+		if d := v.validate(name, words[1:], workDir); !d.Allowed {
+			message := fmt.Sprintf("%s would execute disallowed command: %s", cmd, d.Message)
+			return v.denyBy(d.Rule, d.Ref, cmd, args, message)
+		}
+	}
+
+	return allowDecision
+}
@@ -0,0 +1,90 @@
+package validator
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestNormalizeWindowsCommandName tests suffix and path normalization of Windows command names.
+func TestNormalizeWindowsCommandName(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"git", "git"},
+		{"git.exe", "git"},
+		{"GIT.EXE", "git"},
+		{`C:\Program Files\Git\bin\git.exe`, "git"},
+		{"C:/tools/build.bat", "build"},
+		{"script.cmd", "script"},
+		{"deploy.ps1", "deploy"},
+		{".exe", ".exe"},
+		{"notes.txt", "notes.txt"},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeWindowsCommandName(tt.input); got != tt.want {
+			t.Errorf("normalizeWindowsCommandName(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+// TestParseWindowsShellArgs tests extraction of scripts from cmd and PowerShell arguments.
+func TestParseWindowsShellArgs(t *testing.T) {
+	parser := NewWindowsShellParser()
+
+	tests := []struct {
+		name       string
+		cmd        string
+		args       []string
+		wantScript string
+		wantFile   string
+		wantErr    bool
+	}{
+		{"CmdSlashC", "cmd", []string{"/c", "dir", "src"}, "dir src", "", false},
+		{"CmdUpperCase", "CMD.EXE", []string{"/C", "echo hi"}, "echo hi", "", false},
+		{"CmdInteractive", "cmd", []string{"/q"}, "", "", true},
+		{"CmdMissingCommand", "cmd", []string{"/c"}, "", "", true},
+		{"PowerShellCommand", "powershell", []string{"-NoProfile", "-Command", "Get-ChildItem"}, "Get-ChildItem", "", false},
+		{"PowerShellPositional", "pwsh", []string{"-NoProfile", "Get-Date"}, "Get-Date", "", false},
+		{"PowerShellFile", "pwsh", []string{"-File", "build.ps1"}, "", "build.ps1", false},
+		{"PowerShellEncoded", "powershell", []string{"-EncodedCommand", "ZQBjAGgAbwA="}, "", "", true},
+		{"PowerShellEncodedShort", "powershell", []string{"-enc", "ZQBjAGgAbwA="}, "", "", true},
+		{"PowerShellInteractive", "pwsh", []string{"-NoProfile"}, "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script, file, errMsg := parser.ParseWindowsShellArgs(tt.cmd, tt.args)
+			if (errMsg != "") != tt.wantErr {
+				t.Fatalf("ParseWindowsShellArgs() errMsg = %q, wantErr %v", errMsg, tt.wantErr)
+			}
+			if script != tt.wantScript {
+				t.Errorf("ParseWindowsShellArgs() script = %q, want %q", script, tt.wantScript)
+			}
+			if file != tt.wantFile {
+				t.Errorf("ParseWindowsShellArgs() file = %q, want %q", file, tt.wantFile)
+			}
+		})
+	}
+}
+
+// TestSplitWindowsScript tests splitting cmd and PowerShell scripts into commands.
+func TestSplitWindowsScript(t *testing.T) {
+	tests := []struct {
+		script string
+		want   [][]string
+	}{
+		{"dir", [][]string{{"dir"}}},
+		{"dir src && type a.txt", [][]string{{"dir", "src"}, {"type", "a.txt"}}},
+		{`echo "a & b" | findstr a`, [][]string{{"echo", "a & b"}, {"findstr", "a"}}},
+		{"Get-Item x; Remove-Item 'y z'", [][]string{{"Get-Item", "x"}, {"Remove-Item", "y z"}}},
+		{"  ", nil},
+	}
+
+	for _, tt := range tests {
+		if got := SplitWindowsScript(tt.script); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SplitWindowsScript(%q) = %q, want %q", tt.script, got, tt.want)
+		}
+	}
+}
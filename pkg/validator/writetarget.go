@@ -0,0 +1,148 @@
+package validator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+)
+
+// protectedWritePaths are the kernel's pseudo-filesystems, which redirections and tee never
+// write to whatever the allowed directories are, since writing to them changes the system.
+var protectedWritePaths = []string{"/proc", "/sys"}
+
+// CheckWriteTarget checks a file that a redirection, a clobbering >|, or tee writes to. It must
+// be inside an allowed directory and outside the readOnlyDirectories, /proc, and /sys, and it
+// must not be a device file other than one of writableDevices. A relative path is resolved
+// against baseDir.
+func (v *CommandValidator) CheckWriteTarget(path string, baseDir string) (bool, string) {
+	if path == "" {
+		return false, "empty path is not allowed"
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(baseDir, path)
+	}
+	resolved := resolveSymlinksPath(filepath.Clean(path))
+
+	// Pipes of process substitutions are created outside the allowed directories
+	if v.IsProcessSubstitutionPipe(resolved) {
+		return true, ""
+	}
+	for _, dir := range protectedWritePaths {
+		if isWithinDir(resolved, dir) {
+			return false, fmt.Sprintf("writing to %q is not allowed: %s is never writable", path, dir)
+		}
+	}
+	if allowed, message := v.IsPathInAllowedDirectory(resolved, "/"); !allowed {
+		return false, message
+	}
+	if info, err := os.Stat(resolved); err == nil && info.Mode()&os.ModeDevice != 0 && !v.isWritableDevice(resolved) {
+		return false, fmt.Sprintf("writing to device %q is not allowed: it is not in writableDevices", path)
+	}
+	for _, dir := range v.config.ReadOnlyDirectories {
+		if isWithinDir(resolved, resolveSymlinksPath(dir)) {
+			return false, fmt.Sprintf("writing to %q is not allowed: %s is read-only", path, dir)
+		}
+	}
+	return true, ""
+}
+
+// CheckRedirects finds redirections in a parsed script that the interpreter cannot perform:
+// >|, <>, descriptors other than 0, 1, and 2, and duplications other than >&1, >&2, >&-, and
+// <&-. The files of the other redirections are checked by the runner when it opens them (see
+// CheckWriteTarget); shells running nested scripts perform every form, whose targets are
+// checked by validateNestedCommand.
+func (v *CommandValidator) CheckRedirects(prog *syntax.File) []Violation {
+	var violations []Violation
+	syntax.Walk(prog, func(node syntax.Node) bool {
+		stmt, ok := node.(*syntax.Stmt)
+		if !ok {
+			return true
+		}
+		cmd := commandOf(stmt)
+		for _, redirect := range stmt.Redirs {
+			message := redirectMessage(redirect)
+			if message == "" {
+				continue
+			}
+			v.logBlockedCommand(RuleRedirect, cmd, nil, message)
+			violations = append(violations, Violation{
+				Command: cmd,
+				Line:    redirect.Pos().Line(),
+				Column:  redirect.Pos().Col(),
+				Rule:    RuleRedirect,
+				Message: message,
+			})
+		}
+		return true
+	})
+	return violations
+}
+
+// redirectMessage returns why the interpreter cannot perform a redirection, or "" if it can.
+func redirectMessage(redirect *syntax.Redirect) string {
+	if redirect.N != nil && redirect.N.Value != "0" && redirect.N.Value != "1" && redirect.N.Value != "2" {
+		return fmt.Sprintf("redirection of file descriptor %s is not supported", redirect.N.Value)
+	}
+	target, literal := "", false
+	if redirect.Word != nil {
+		target, literal = literalWord(redirect.Word)
+	}
+	switch redirect.Op {
+	case syntax.ClbOut:
+		return "the >| redirection is not supported: use > instead, which always overwrites"
+	case syntax.RdrInOut:
+		return "the <> redirection is not supported"
+	case syntax.DplOut:
+		if !literal || (target != "1" && target != "2" && target != "-") {
+			return fmt.Sprintf("the >&%s redirection is not supported", target)
+		}
+	case syntax.DplIn:
+		if !literal || target != "-" {
+			return fmt.Sprintf("the <&%s redirection is not supported", target)
+		}
+	}
+	return ""
+}
+
+// isWritableDevice reports whether the device file at path is one of writableDevices.
+func (v *CommandValidator) isWritableDevice(path string) bool {
+	devices := v.config.WritableDevices
+	if devices == nil {
+		devices = config.DefaultWritableDevices
+	}
+	return slices.ContainsFunc(devices, func(device string) bool {
+		return resolveSymlinksPath(device) == path
+	})
+}
+
+// validateTeeTargets checks the files tee writes to with CheckWriteTarget.
+func (v *CommandValidator) validateTeeTargets(cmd string, args []string, workDir string) Decision {
+	options := true
+	for _, arg := range args {
+		if options && arg == "--" {
+			options = false
+			continue
+		}
+		if options && strings.HasPrefix(arg, "-") {
+			continue
+		}
+		if allowed, message := v.CheckWriteTarget(arg, workDir); !allowed {
+			return v.deny(RuleWriteTarget, cmd, args, message)
+		}
+	}
+	return allowDecision
+}
+
+// isWithinDir reports whether path is dir or inside it; both must be clean.
+func isWithinDir(path, dir string) bool {
+	if path == dir || dir == string(filepath.Separator) {
+		return true
+	}
+	return strings.HasPrefix(path, dir+string(filepath.Separator))
+}
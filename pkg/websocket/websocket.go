@@ -0,0 +1,474 @@
+// Package websocket implements as much of the WebSocket protocol (RFC 6455) as the server's
+// streaming endpoints need: upgrading HTTP requests, dialing servers, exchanging text and
+// binary messages, and answering pings and closes. Extensions are not supported, and
+// subprotocols are left to the headers of the handshake.
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec // mandated by RFC 6455 for the handshake
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Opcode is the type of a frame.
+type Opcode byte
+
+// Frame opcodes.
+const (
+	opContinuation Opcode = 0x0
+	OpText         Opcode = 0x1
+	OpBinary       Opcode = 0x2
+	OpClose        Opcode = 0x8
+	OpPing         Opcode = 0x9
+	OpPong         Opcode = 0xA
+)
+
+// Close status codes.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseInvalidData     = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+	// closeNoStatus is reported for close frames without a status code
+	closeNoStatus = 1005
+)
+
+// DefaultMaxMessageSize is the largest message a Conn reads unless SetMaxMessageSize changes it.
+const DefaultMaxMessageSize = 1 << 20
+
+const (
+	// acceptGUID is appended to the key of a handshake to compute its accept value.
+	acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	// keyBytes is the length of the decoded Sec-WebSocket-Key.
+	keyBytes = 16
+	// maxControlPayload is the largest payload of a control frame.
+	maxControlPayload = 125
+)
+
+var (
+	// ErrBadHandshake is returned when a request or response is not a valid WebSocket handshake.
+	ErrBadHandshake = errors.New("bad websocket handshake")
+	// ErrMessageTooBig is returned by ReadMessage for messages over the maximum message size.
+	ErrMessageTooBig = errors.New("websocket message too big")
+	// errProtocol is returned by ReadMessage for frames that break the protocol.
+	errProtocol = errors.New("websocket protocol error")
+)
+
+// CloseError is returned by ReadMessage once the peer has closed the connection.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket closed: %d", e.Code)
+	}
+	return fmt.Sprintf("websocket closed: %d %s", e.Code, e.Reason)
+}
+
+// Conn is a WebSocket connection. ReadMessage must be called from a single goroutine, while
+// WriteMessage and Close may be called concurrently with it and with each other.
+type Conn struct {
+	conn   net.Conn
+	rd     *bufio.Reader
+	client bool
+	// maxSize is the largest message ReadMessage accepts
+	maxSize int
+
+	// wmu serializes frames, so that those of concurrent writers do not interleave
+	wmu       sync.Mutex
+	closeSent bool
+}
+
+// newConn wraps conn, whose buffered input is rd. Clients mask the frames they send.
+func newConn(conn net.Conn, rd *bufio.Reader, client bool) *Conn {
+	return &Conn{conn: conn, rd: rd, client: client, maxSize: DefaultMaxMessageSize}
+}
+
+// Upgrade switches the HTTP connection of r to the WebSocket protocol. Requests from a
+// browser page must come from the server's own origin, so that other sites cannot use the
+// credentials of its users. Headers set on w, such as the chosen Sec-WebSocket-Protocol, are
+// sent with the handshake response. On failure, it answers the request with an HTTP error.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	key, status, err := checkRequest(r)
+	if err != nil {
+		if status == http.StatusUpgradeRequired {
+			w.Header().Set("Sec-WebSocket-Version", "13")
+		}
+		http.Error(w, err.Error(), status)
+		return nil, err
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, fmt.Errorf("failed to take over the connection: %w", err)
+	}
+	var response strings.Builder
+	response.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n")
+	_ = w.Header().Write(&response)
+	response.WriteString("\r\n")
+	if _, err := io.WriteString(conn, response.String()); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return newConn(conn, brw.Reader, false), nil
+}
+
+// checkRequest returns the key of a valid handshake request, or the status to answer it with.
+func checkRequest(r *http.Request) (string, int, error) {
+	switch {
+	case r.Method != http.MethodGet:
+		return "", http.StatusMethodNotAllowed, fmt.Errorf("%w: method %s", ErrBadHandshake, r.Method)
+	case !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket"):
+		return "", http.StatusBadRequest, fmt.Errorf("%w: not a websocket upgrade", ErrBadHandshake)
+	case r.Header.Get("Sec-WebSocket-Version") != "13":
+		return "", http.StatusUpgradeRequired, fmt.Errorf("%w: unsupported version", ErrBadHandshake)
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if b, err := base64.StdEncoding.DecodeString(key); err != nil || len(b) != keyBytes {
+		return "", http.StatusBadRequest, fmt.Errorf("%w: invalid key", ErrBadHandshake)
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || !strings.EqualFold(u.Host, r.Host) {
+			return "", http.StatusForbidden, fmt.Errorf("%w: cross-origin request from %s", ErrBadHandshake, origin)
+		}
+	}
+	return key, 0, nil
+}
+
+// headerContains reports whether a comma-separated header of h contains token.
+func headerContains(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// acceptKey returns the Sec-WebSocket-Accept value for key.
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID)) //nolint:gosec // mandated by RFC 6455
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Dial opens a WebSocket connection to rawURL, a ws:// or wss:// URL, sending header with
+// the handshake. When the server refuses the handshake, the error comes with its response.
+func Dial(ctx context.Context, rawURL string, header http.Header) (*Conn, *http.Response, error) {
+	var d Dialer
+	return d.Dial(ctx, rawURL, header)
+}
+
+// Dialer opens WebSocket connections with options. Its zero value is what Dial uses.
+type Dialer struct {
+	// TLSConfig configures wss:// connections, e.g. with a private CA or a client certificate.
+	// When nil, the system roots verify the server.
+	TLSConfig *tls.Config
+}
+
+// Dial is Dial with the options of d. A subprotocol may be requested with the
+// Sec-WebSocket-Protocol header; the one chosen is in the header of the response.
+func (d *Dialer) Dial(ctx context.Context, rawURL string, header http.Header) (*Conn, *http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	var dial func(ctx context.Context, network, addr string) (net.Conn, error)
+	switch u.Scheme {
+	case "ws":
+		dial = (&net.Dialer{}).DialContext
+	case "wss":
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		if d.TLSConfig != nil {
+			tlsConfig = d.TLSConfig.Clone()
+		}
+		dial = (&tls.Dialer{Config: tlsConfig}).DialContext
+	default:
+		return nil, nil, fmt.Errorf("unsupported websocket scheme %q", u.Scheme)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), map[string]string{"ws": "80", "wss": "443"}[u.Scheme])
+	}
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, resp, err := handshake(ctx, conn, u, header)
+	if err != nil {
+		_ = conn.Close()
+		return nil, resp, err
+	}
+	return c, resp, nil
+}
+
+// handshake sends the handshake request for u over conn and checks the server's response.
+func handshake(ctx context.Context, conn net.Conn, u *url.URL, header http.Header) (*Conn, *http.Response, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+		defer func() { _ = conn.SetDeadline(time.Time{}) }()
+	}
+	b := make([]byte, keyBytes)
+	_, _ = rand.Read(b)
+	key := base64.StdEncoding.EncodeToString(b)
+
+	httpURL := *u
+	httpURL.Scheme = map[string]string{"ws": "http", "wss": "https"}[u.Scheme]
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, httpURL.String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	if err := req.Write(conn); err != nil {
+		return nil, nil, err
+	}
+
+	rd := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(rd, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, resp, fmt.Errorf("%w: %s", ErrBadHandshake, resp.Status)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return nil, resp, fmt.Errorf("%w: invalid accept value", ErrBadHandshake)
+	}
+	return newConn(conn, rd, true), resp, nil
+}
+
+// SetMaxMessageSize changes the largest message ReadMessage accepts.
+func (c *Conn) SetMaxMessageSize(size int) {
+	c.maxSize = size
+}
+
+// ReadMessage returns the next text or binary message, answering the pings and pongs that
+// arrive before it. Once the peer closes the connection, it returns a *CloseError.
+func (c *Conn) ReadMessage() (Opcode, []byte, error) {
+	var (
+		op      Opcode
+		message []byte
+		started bool
+	)
+	for {
+		fin, frameOp, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+		switch frameOp {
+		case OpPing:
+			if err := c.writeFrame(OpPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			return 0, nil, c.closed(payload)
+		case opContinuation:
+			if !started {
+				return 0, nil, c.fail(fmt.Errorf("%w: unexpected continuation frame", errProtocol))
+			}
+		case OpText, OpBinary:
+			if started {
+				return 0, nil, c.fail(fmt.Errorf("%w: unfinished message", errProtocol))
+			}
+			op, started = frameOp, true
+		default:
+			return 0, nil, c.fail(fmt.Errorf("%w: unknown opcode %d", errProtocol, frameOp))
+		}
+
+		if len(message)+len(payload) > c.maxSize {
+			return 0, nil, c.fail(ErrMessageTooBig)
+		}
+		message = append(message, payload...)
+		if !fin {
+			continue
+		}
+		if op == OpText && !utf8.Valid(message) {
+			_ = c.Close(CloseInvalidData, "invalid UTF-8")
+			return 0, nil, fmt.Errorf("%w: invalid UTF-8 in text message", errProtocol)
+		}
+		return op, message, nil
+	}
+}
+
+// readFrame reads a single frame and returns its payload, unmasked.
+func (c *Conn) readFrame() (bool, Opcode, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.rd, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := head[0]&0x80 != 0
+	op := Opcode(head[0] & 0x0F)
+	masked := head[1]&0x80 != 0
+	if head[0]&0x70 != 0 {
+		return false, 0, nil, fmt.Errorf("%w: reserved bits set", errProtocol)
+	}
+	// Clients must mask their frames, and servers must not
+	if masked == c.client {
+		return false, 0, nil, fmt.Errorf("%w: wrong masking", errProtocol)
+	}
+
+	size := uint64(head[1] & 0x7F)
+	switch size {
+	case 126: //nolint:mnd // 16-bit length
+		var ext [2]byte
+		if _, err := io.ReadFull(c.rd, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127: //nolint:mnd // 64-bit length
+		var ext [8]byte
+		if _, err := io.ReadFull(c.rd, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+	if op >= OpClose && (size > maxControlPayload || !fin) {
+		return false, 0, nil, fmt.Errorf("%w: invalid control frame", errProtocol)
+	}
+	if size > uint64(c.maxSize) { //nolint:gosec // maxSize is positive
+		return false, 0, nil, ErrMessageTooBig
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.rd, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(c.rd, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, op, payload, nil
+}
+
+// fail closes the connection after a read error, telling the peer why if the error is its own.
+func (c *Conn) fail(err error) error {
+	switch {
+	case errors.Is(err, ErrMessageTooBig):
+		_ = c.Close(CloseMessageTooBig, "message too big")
+	case errors.Is(err, errProtocol):
+		_ = c.Close(CloseProtocolError, "protocol error")
+	}
+	return err
+}
+
+// closed answers the close frame with payload and returns the error reporting it.
+func (c *Conn) closed(payload []byte) error {
+	e := &CloseError{Code: closeNoStatus}
+	if len(payload) >= 2 { //nolint:mnd // the status code
+		e.Code = int(binary.BigEndian.Uint16(payload))
+		e.Reason = string(payload[2:])
+	}
+	code := e.Code
+	if code == closeNoStatus {
+		code = CloseNormal
+	}
+	_ = c.Close(code, "")
+	return e
+}
+
+// WriteMessage sends data as a single text or binary message.
+func (c *Conn) WriteMessage(op Opcode, data []byte) error {
+	if op != OpText && op != OpBinary {
+		return fmt.Errorf("websocket message must be text or binary, not opcode %d", op)
+	}
+	return c.writeFrame(op, data)
+}
+
+// writeFrame sends a final frame with the given opcode and payload.
+func (c *Conn) writeFrame(op Opcode, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return net.ErrClosed
+	}
+	return c.writeFrameLocked(op, payload)
+}
+
+// writeFrameLocked sends a frame with c.wmu held.
+func (c *Conn) writeFrameLocked(op Opcode, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+14) //nolint:mnd // the longest header
+	frame = append(frame, 0x80|byte(op))
+	maskBit := byte(0)
+	if c.client {
+		maskBit = 0x80
+	}
+	switch size := len(payload); {
+	case size <= maxControlPayload:
+		frame = append(frame, maskBit|byte(size))
+	case size <= 0xFFFF:
+		frame = append(frame, maskBit|126) //nolint:mnd // 16-bit length
+		frame = binary.BigEndian.AppendUint16(frame, uint16(size))
+	default:
+		frame = append(frame, maskBit|127) //nolint:mnd // 64-bit length
+		frame = binary.BigEndian.AppendUint64(frame, uint64(size))
+	}
+	if !c.client {
+		frame = append(frame, payload...)
+	} else {
+		var mask [4]byte
+		_, _ = rand.Read(mask[:])
+		frame = append(frame, mask[:]...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// Close sends a close frame with code and reason, unless one was sent already, and closes
+// the connection.
+func (c *Conn) Close(code int, reason string) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if !c.closeSent {
+		c.closeSent = true
+		payload := binary.BigEndian.AppendUint16(nil, uint16(code)) //nolint:gosec // close codes are small
+		if len(reason) > maxControlPayload-2 {
+			reason = reason[:maxControlPayload-2]
+		}
+		_ = c.writeFrameLocked(OpClose, append(payload, reason...))
+	}
+	return c.conn.Close()
+}
@@ -0,0 +1,120 @@
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+// newEchoServer returns the ws:// URL of a server echoing every message it reads.
+func newEchoServer(t *testing.T) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		conn.SetMaxMessageSize(128 * 1024)
+		for {
+			op, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if string(data) == "bye" {
+				_ = conn.Close(CloseNormal, "bye")
+				return
+			}
+			if err := conn.WriteMessage(op, data); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func TestConn_Echo(t *testing.T) {
+	url := newEchoServer(t)
+	conn, resp, err := Dial(t.Context(), url, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	// Messages with each of the three length encodings
+	for _, size := range []int{5, 300, 70000} {
+		data := []byte(strings.Repeat("x", size))
+		assert.NoError(t, conn.WriteMessage(OpBinary, data))
+		op, got, err := conn.ReadMessage()
+		assert.NoError(t, err)
+		assert.Equal(t, OpBinary, op)
+		assert.Equal(t, size, len(got))
+	}
+
+	// Pings are answered while waiting for messages
+	assert.NoError(t, conn.writeFrame(OpPing, []byte("ping")))
+	assert.NoError(t, conn.WriteMessage(OpText, []byte("hello")))
+	op, got, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, OpText, op)
+	assert.Equal(t, "hello", string(got))
+
+	assert.NoError(t, conn.WriteMessage(OpText, []byte("bye")))
+	_, _, err = conn.ReadMessage()
+	var closeErr *CloseError
+	assert.True(t, errors.As(err, &closeErr))
+	assert.Equal(t, CloseNormal, closeErr.Code)
+	assert.Equal(t, "bye", closeErr.Reason)
+}
+
+func TestConn_MessageTooBig(t *testing.T) {
+	conn, _, err := Dial(t.Context(), newEchoServer(t), nil)
+	assert.NoError(t, err)
+	assert.NoError(t, conn.WriteMessage(OpBinary, make([]byte, 200*1024)))
+	_, _, err = conn.ReadMessage()
+	var closeErr *CloseError
+	assert.True(t, errors.As(err, &closeErr))
+	assert.Equal(t, CloseMessageTooBig, closeErr.Code)
+}
+
+func TestUpgrade_Rejected(t *testing.T) {
+	url := newEchoServer(t)
+
+	_, resp, err := Dial(t.Context(), url, http.Header{"Origin": {"https://evil.example"}})
+	assert.IsError(t, err, ErrBadHandshake)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, err = http.Get("http" + strings.TrimPrefix(url, "ws"))
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestDialer_TLSAndSubprotocol(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Sec-WebSocket-Protocol", strings.Split(r.Header.Get("Sec-WebSocket-Protocol"), ",")[0])
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		_ = conn.WriteMessage(OpText, []byte("hi"))
+		_ = conn.Close(CloseNormal, "")
+	}))
+	t.Cleanup(srv.Close)
+	url := "wss" + strings.TrimPrefix(srv.URL, "https")
+
+	// The server's certificate is not trusted by the system roots
+	_, _, err := Dial(t.Context(), url, nil)
+	assert.Error(t, err)
+
+	d := Dialer{TLSConfig: srv.Client().Transport.(*http.Transport).TLSClientConfig}
+	conn, resp, err := d.Dial(t.Context(), url, http.Header{"Sec-WebSocket-Protocol": {"v2.example, v1.example"}})
+	assert.NoError(t, err)
+	defer conn.Close(CloseNormal, "")
+	assert.Equal(t, "v2.example", resp.Header.Get("Sec-WebSocket-Protocol"))
+	_, got, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "hi", string(got))
+}
@@ -0,0 +1,267 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
+	"github.com/shimizu1995/secure-shell-server/pkg/runner"
+	"github.com/shimizu1995/secure-shell-server/pkg/websocket"
+)
+
+// attachPath is the path of the WebSocket endpoint that runs a command attached to the caller's terminal.
+const attachPath = "/v1/attach"
+
+const (
+	// attachSessionBytes is the number of random bytes of the session of an attach connection.
+	attachSessionBytes = 8
+	// attachInputQueue is the number of stdin messages waiting for the command to read them.
+	attachInputQueue = 64
+)
+
+// Default size of the terminal of an attach connection that does not give one.
+const (
+	defaultAttachRows = 24
+	defaultAttachCols = 80
+)
+
+// Types of the messages of the attach protocol.
+const (
+	// Sent by the client
+	attachStdin  = "stdin"
+	attachResize = "resize"
+	attachCancel = "cancel"
+	// Sent by the server
+	attachStdout = "stdout"
+	attachStderr = "stderr"
+	attachExit   = "exit"
+)
+
+// attachMessage is a message of the attach protocol, sent as a JSON text message in either
+// direction.
+type attachMessage struct {
+	Type string `json:"type"`
+	// Data is the input or output of a stdin, stdout, or stderr message.
+	Data string `json:"data,omitempty"`
+	// Rows and Cols are the terminal size of a resize message.
+	Rows uint16 `json:"rows,omitempty"`
+	Cols uint16 `json:"cols,omitempty"`
+	// Code is the exit status of an exit message.
+	Code *int `json:"code,omitempty"`
+	// Error is the reason an exited command failed.
+	Error string `json:"error,omitempty"`
+	// Snapshot is the ID of the snapshot the command ran in, when snapshots are enabled.
+	Snapshot string `json:"snapshot,omitempty"`
+}
+
+// HandleAttach serves GET /v1/attach, which runs the command of its "command" query
+// parameter, in "workingDir" or the session's working directory, over a WebSocket. The
+// command's output is streamed as stdout and stderr messages and ends with an exit message,
+// while the client sends stdin, resize, and cancel messages. Commands marked allowPty run in
+// a pseudo-terminal of the size given by "rows" and "cols" that receives the stdin messages.
+// Each connection is a session of its caller of its own.
+func (s *Server) HandleAttach(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	command := query.Get("command")
+	if command == "" {
+		http.Error(w, "missing command", http.StatusBadRequest)
+		return
+	}
+	workingDir := query.Get("workingDir")
+	if workingDir == "" {
+		s.cmdMutex.Lock()
+		workingDir = s.workingDir
+		s.cmdMutex.Unlock()
+	}
+	if workingDir == "" {
+		workingDir = s.config.DefaultDirectory()
+	}
+	size := runner.WindowSize{
+		Rows: parseDimension(query.Get("rows"), defaultAttachRows),
+		Cols: parseDimension(query.Get("cols"), defaultAttachCols),
+	}
+
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		s.logger.LogErrorf("WebSocket attach rejected from %s: %v", r.RemoteAddr, err)
+		return
+	}
+	s.logger.LogInfof("WebSocket attach: %s in directory: %s", command, workingDir)
+
+	id, _ := identity.FromContext(r.Context())
+	if id.Session == "" {
+		b := make([]byte, attachSessionBytes)
+		_, _ = rand.Read(b)
+		id.Session = "attach-" + hex.EncodeToString(b)
+	}
+	result := s.attach(identity.WithIdentity(r.Context(), id), conn, id, command, workingDir, size)
+	exit := attachMessage{Type: attachExit, Code: new(int)}
+	*exit.Code = runner.ExitCode(result.Err)
+	if result.Err != nil {
+		exit.Error = result.Err.Error()
+		s.logger.LogErrorf("Command execution failed: %v", result.Err)
+	}
+	if result.Snapshot != nil {
+		exit.Snapshot = result.Snapshot.ID
+	}
+	if err := writeAttachMessage(conn, exit); err != nil {
+		s.logger.LogErrorf("Failed to send the exit status of %s: %v", command, err)
+	}
+	_ = conn.Close(websocket.CloseNormal, "")
+}
+
+// attach runs command for the caller id with its input and output connected to conn.
+func (s *Server) attach(ctx context.Context, conn *websocket.Conn, id identity.Identity,
+	command, workingDir string, size runner.WindowSize,
+) runner.RunResult {
+	sess, err := s.sessions.Open(id.Key())
+	if err == nil {
+		defer sess.Close()
+		err = sess.Begin()
+	}
+	if err != nil {
+		return runner.RunResult{Err: err}
+	}
+	release, err := s.rateLimiter.Acquire(id.Key())
+	if err != nil {
+		return runner.RunResult{Err: err}
+	}
+	defer release()
+
+	ctx, cancel := sess.Context(ctx)
+	defer cancel()
+	stdin, input := io.Pipe()
+	tty := runner.NewTerminal(stdin, size)
+	defer tty.Close()
+	go s.readAttachMessages(ctx, conn, tty, input, cancel)
+
+	r := s.newRunner(id, "")
+	stdout := &attachWriter{conn: conn, stream: attachStdout}
+	stderr := &attachWriter{conn: conn, stream: attachStderr}
+	r.SetOutputs(stdout, stderr)
+	result := r.RunInteractive(ctx, command, tty, runner.WithWorkdir(workingDir))
+	stdout.Flush()
+	stderr.Flush()
+	// The reader stops once the client closes the connection, or the server does after the exit message
+	_ = input.CloseWithError(io.EOF)
+	return result
+}
+
+// readAttachMessages handles the messages of the client until the connection closes: it
+// writes stdin messages to input, resizes tty, and calls cancel for cancel messages and once
+// the client is gone. Input is written from a queue, so that a command not reading its input
+// does not hold up resizes and cancellation.
+func (s *Server) readAttachMessages(ctx context.Context, conn *websocket.Conn, tty *runner.Terminal,
+	input *io.PipeWriter, cancel context.CancelFunc,
+) {
+	defer cancel()
+	queue := make(chan string, attachInputQueue)
+	defer close(queue)
+	go func() {
+		for data := range queue {
+			// Input written after the command finished is dropped
+			_, _ = io.WriteString(input, data)
+		}
+		_ = input.CloseWithError(io.EOF)
+	}()
+	for {
+		op, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg attachMessage
+		if op != websocket.OpText || json.Unmarshal(data, &msg) != nil {
+			s.logger.LogErrorf("Ignoring invalid attach message")
+			continue
+		}
+		switch msg.Type {
+		case attachStdin:
+			select {
+			case queue <- msg.Data:
+			case <-ctx.Done():
+			}
+		case attachResize:
+			if msg.Rows > 0 && msg.Cols > 0 {
+				tty.Resize(runner.WindowSize{Rows: msg.Rows, Cols: msg.Cols})
+			}
+		case attachCancel:
+			cancel()
+		default:
+			s.logger.LogErrorf("Ignoring attach message of unknown type %q", msg.Type)
+		}
+	}
+}
+
+// parseDimension parses a terminal dimension of the attach query, or returns def.
+func parseDimension(value string, def uint16) uint16 {
+	n, err := strconv.ParseUint(value, 10, 16)
+	if err != nil || n == 0 {
+		return def
+	}
+	return uint16(n)
+}
+
+// writeAttachMessage sends msg to the client of conn.
+func writeAttachMessage(conn *websocket.Conn, msg attachMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(websocket.OpText, data)
+}
+
+// attachWriter sends what is written to it as messages of a stream. Since messages are text,
+// a character split between two writes is held back until the write that completes it.
+type attachWriter struct {
+	conn   *websocket.Conn
+	stream string
+
+	mu      sync.Mutex
+	partial []byte
+}
+
+// Write implements the io.Writer interface.
+func (w *attachWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	data := append(w.partial, p...)
+	end := completeUTF8(data)
+	w.partial = append([]byte(nil), data[end:]...)
+	if end == 0 {
+		return len(p), nil
+	}
+	if err := writeAttachMessage(w.conn, attachMessage{Type: w.stream, Data: string(data[:end])}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush sends the bytes held back from the last write, if any.
+func (w *attachWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.partial) > 0 {
+		_ = writeAttachMessage(w.conn, attachMessage{Type: w.stream, Data: string(w.partial)})
+		w.partial = nil
+	}
+}
+
+// completeUTF8 returns the length of p without a trailing incomplete UTF-8 sequence.
+func completeUTF8(p []byte) int {
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(p[i]) {
+			if !utf8.FullRune(p[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(p)
+}
@@ -0,0 +1,146 @@
+//go:build !windows
+
+package service_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/websocket"
+	"github.com/shimizu1995/secure-shell-server/service"
+)
+
+// attachMessage is a message of the attach protocol.
+type attachMessage struct {
+	Type  string `json:"type"`
+	Data  string `json:"data,omitempty"`
+	Rows  uint16 `json:"rows,omitempty"`
+	Cols  uint16 `json:"cols,omitempty"`
+	Code  *int   `json:"code,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// attachServer returns the ws:// URL of the attach endpoint of a server allowing echo, and
+// cat and stty in a pseudo-terminal.
+func attachServer(t *testing.T) (string, string) {
+	t.Helper()
+	tmpDir := t.TempDir()
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories: []string{tmpDir},
+		AllowCommands: []config.AllowCommand{
+			{Command: "echo"},
+			{Command: "cat", AllowPty: true},
+			{Command: "stty", AllowPty: true},
+			{Command: "sleep"},
+		},
+		DefaultErrorMessage: "Command not allowed",
+		MaxExecutionTime:    10,
+	}
+	srv, err := service.NewServer(cfg, 0, "")
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	handler, err := srv.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(handler)
+	t.Cleanup(httpServer.Close)
+	return "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/v1/attach", tmpDir
+}
+
+// attach runs command over the attach endpoint at base, sending the messages in input once
+// the first output arrives, and returns the output of each stream and the exit message.
+func attach(t *testing.T, base, command string, input ...attachMessage) (map[string]string, attachMessage) {
+	t.Helper()
+	conn, _, err := websocket.Dial(t.Context(), base+"?rows=30&cols=100&command="+url.QueryEscape(command), nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer func() { _ = conn.Close(websocket.CloseNormal, "") }()
+	send := func(msg attachMessage) {
+		data, _ := json.Marshal(msg)
+		if err := conn.WriteMessage(websocket.OpText, data); err != nil {
+			t.Fatalf("WriteMessage() error = %v", err)
+		}
+	}
+	if len(input) > 0 && input[0].Type == "cancel" {
+		send(input[0])
+		input = input[1:]
+	}
+
+	output := map[string]string{}
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage() error = %v before the exit message", err)
+		}
+		var msg attachMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("invalid message %q: %v", data, err)
+		}
+		if msg.Type == "exit" {
+			return output, msg
+		}
+		output[msg.Type] += msg.Data
+		for _, m := range input {
+			send(m)
+		}
+		input = nil
+	}
+}
+
+func TestAttach(t *testing.T) {
+	base, _ := attachServer(t)
+
+	output, exit := attach(t, base, "echo out; echo err >&2")
+	if output["stdout"] != "out\n" || output["stderr"] != "err\n" {
+		t.Errorf("output = %q", output)
+	}
+	if exit.Code == nil || *exit.Code != 0 || exit.Error != "" {
+		t.Errorf("exit = %+v, want status 0", exit)
+	}
+
+	// Commands run in a pseudo-terminal receive stdin and resize messages
+	output, exit = attach(t, base, "echo ready; cat; stty size",
+		attachMessage{Type: "resize", Rows: 40, Cols: 120},
+		attachMessage{Type: "stdin", Data: "hello\n\x04"})
+	if strings.Count(output["stdout"], "hello\r\n") != 2 || !strings.HasSuffix(output["stdout"], "40 120\r\n") {
+		t.Errorf("stdout = %q, want the echoed input and the new size", output["stdout"])
+	}
+	if *exit.Code != 0 {
+		t.Errorf("exit = %+v, want status 0", exit)
+	}
+
+	// Denied commands exit with the denial
+	_, exit = attach(t, base, "rm -rf /")
+	if *exit.Code != 126 || !strings.Contains(exit.Error, "Command not allowed") {
+		t.Errorf("exit = %+v, want the denial", exit)
+	}
+
+	// Cancel stops the command
+	_, exit = attach(t, base, "sleep 30", attachMessage{Type: "cancel"})
+	if *exit.Code == 0 {
+		t.Errorf("exit = %+v, want the command canceled", exit)
+	}
+}
+
+func TestAttach_Rejected(t *testing.T) {
+	base, _ := attachServer(t)
+
+	_, resp, err := websocket.Dial(t.Context(), base, nil)
+	if err == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Dial() without a command = %v, want 400 Bad Request", err)
+	}
+
+	header := http.Header{"Origin": {"https://evil.example"}}
+	_, resp, err = websocket.Dial(t.Context(), base+"?command=echo", header)
+	if err == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Dial() from another origin = %v, want 403 Forbidden", err)
+	}
+}
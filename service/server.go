@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -13,20 +14,42 @@ import (
 
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/shimizu1995/secure-shell-server/pkg/alert"
+	"github.com/shimizu1995/secure-shell-server/pkg/approval"
+	"github.com/shimizu1995/secure-shell-server/pkg/audit"
+	"github.com/shimizu1995/secure-shell-server/pkg/auth"
 	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/fileaudit"
+	"github.com/shimizu1995/secure-shell-server/pkg/health"
 	"github.com/shimizu1995/secure-shell-server/pkg/hint"
+	"github.com/shimizu1995/secure-shell-server/pkg/history"
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
 	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/ratelimit"
+	"github.com/shimizu1995/secure-shell-server/pkg/recording"
+	"github.com/shimizu1995/secure-shell-server/pkg/redact"
+	"github.com/shimizu1995/secure-shell-server/pkg/resultcache"
 	"github.com/shimizu1995/secure-shell-server/pkg/runner"
+	"github.com/shimizu1995/secure-shell-server/pkg/session"
+	"github.com/shimizu1995/secure-shell-server/pkg/snapshot"
+	"github.com/shimizu1995/secure-shell-server/pkg/spool"
+	"github.com/shimizu1995/secure-shell-server/pkg/trash"
 	"github.com/shimizu1995/secure-shell-server/pkg/validator"
 )
 
-// createRunTool creates the run tool for executing shell commands.
-func createRunTool() mcp.Tool {
+// createRunTool creates the run tool for executing shell commands. With snapshots, it takes
+// the ID of a snapshot to continue in.
+func createRunTool(snapshots bool) mcp.Tool {
 	desc := "Run shell commands. Only allowlisted commands and directories are permitted. " +
 		"cd only persists in serial mode or with a single command."
+	if snapshots {
+		desc += " Commands run against a snapshot of the working directory; their changes are kept in a snapshot " +
+			"to commit with commit_snapshot or discard with discard_snapshot."
+	}
 
-	return mcp.NewTool("run",
+	opts := []mcp.ToolOption{
 		mcp.WithDescription(desc),
 		mcp.WithArray("commands",
 			mcp.Required(),
@@ -36,6 +59,61 @@ func createRunTool() mcp.Tool {
 		mcp.WithString("mode",
 			mcp.Description("\"parallel\" (default) or \"serial\" (stops on first error)."),
 		),
+	}
+	if snapshots {
+		opts = append(opts, mcp.WithString("snapshot",
+			mcp.Description("ID of a snapshot to continue in, so that the commands see its changes."),
+		))
+	}
+	return mcp.NewTool("run", opts...)
+}
+
+// createSnapshotTool creates the commit_snapshot or discard_snapshot tool.
+func createSnapshotTool(name, desc string) mcp.Tool {
+	return mcp.NewTool(name,
+		mcp.WithDescription(desc),
+		mcp.WithString("id",
+			mcp.Required(),
+			mcp.Description("Snapshot ID."),
+		),
+	)
+}
+
+// createListTrashTool creates the list_trash tool for listing the files deleted in the session.
+func createListTrashTool() mcp.Tool {
+	return mcp.NewTool("list_trash",
+		mcp.WithDescription("List the files and directories deleted by rm and unlink in this session that can still be restored."),
+	)
+}
+
+// createRestoreTrashTool creates the restore_trash tool for restoring a deleted file.
+func createRestoreTrashTool() mcp.Tool {
+	return mcp.NewTool("restore_trash",
+		mcp.WithDescription("Move a file or directory deleted by rm or unlink back to where it was deleted from."),
+		mcp.WithString("id",
+			mcp.Required(),
+			mcp.Description("Trash item ID, as listed by list_trash."),
+		),
+	)
+}
+
+// createReadOutputTool creates the read_output tool for paging through spooled output.
+func createReadOutputTool() mcp.Tool {
+	return mcp.NewTool("read_output",
+		mcp.WithDescription("Read part of the full output of a command whose output was truncated, by the output ID given in its result."),
+		mcp.WithString("id",
+			mcp.Required(),
+			mcp.Description("Output ID."),
+		),
+		mcp.WithNumber("offset",
+			mcp.Description("Byte offset to read from (default 0)."),
+		),
+		mcp.WithNumber("length",
+			mcp.Description("Number of bytes to read (default and maximum: the output size limit)."),
+		),
+		mcp.WithBoolean("tail",
+			mcp.Description("Read the last length bytes instead of reading from offset."),
+		),
 	)
 }
 
@@ -60,10 +138,38 @@ type Server struct {
 	logger    *logger.Logger
 	mcpServer *server.MCPServer
 	port      int
+	// rateLimiter throttles commands per MCP session; nil when no limits are configured
+	rateLimiter *ratelimit.Limiter
+	// sessions limits the MCP sessions of each caller; nil when no limits are configured
+	sessions *session.Manager
 	// Mutex to protect shared resources (config, runner, validator) during command execution
 	cmdMutex sync.Mutex
 	// workingDir holds the session's current working directory. Empty means not yet set.
 	workingDir string
+	// recorders holds one session recording per caller when recordingDir is configured
+	recordersMu sync.Mutex
+	recorders   map[string]*recording.Recorder
+	// tracerProvider, when set, receives the spans of every command
+	tracerProvider trace.TracerProvider
+	// approvals holds commands marked approvalRequired until an approver decides
+	approvals *approval.Queue
+	// history records every command when historyPath is configured
+	history *history.History
+	// alerter raises alerts for honeypot deny rules and tracks frozen sessions
+	alerter *alert.Alerter
+	// auditor sends every denied or executed command to the configured audit sinks
+	auditor *audit.Auditor
+	// spool keeps the full output of truncated commands when outputSpool is enabled
+	spool *spool.Store
+	// snapshots holds the snapshots commands ran in when snapshot is enabled
+	snapshots *snapshot.Store
+	// trash holds the files deleted by rm and unlink when trash is enabled
+	trash *trash.Store
+	// resultCache holds the results of commands marked cacheable
+	resultCache *resultcache.Cache
+	// tlsCertFile and tlsKeyFile, when set, serve HTTP over TLS
+	tlsCertFile string
+	tlsKeyFile  string
 }
 
 // NewServer creates a new MCP server instance.
@@ -73,10 +179,28 @@ func NewServer(cfg *config.ShellCommandConfig, port int, logPath string) (*Serve
 	if err != nil {
 		return nil, fmt.Errorf("failed to create logger: %w", err)
 	}
+	return newServer(cfg, port, loggerObj)
+}
+
+// newServer creates a server for cfg that logs to loggerObj.
+func newServer(cfg *config.ShellCommandConfig, port int, loggerObj *logger.Logger) (*Server, error) {
+	redactor, err := redact.New(cfg.Redaction)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure redaction: %w", err)
+	}
+	loggerObj.SetRedactor(redactor)
 
 	validatorObj := validator.New(cfg, loggerObj)
 	runnerObj := runner.New(cfg, validatorObj, loggerObj)
 
+	var historyObj *history.History
+	if cfg.HistoryPath != "" {
+		historyObj, err = history.Open(cfg.HistoryPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	mcpServer := server.NewMCPServer(
 		"Secure Shell Server",
 		"1.0.0",
@@ -85,12 +209,31 @@ func NewServer(cfg *config.ShellCommandConfig, port int, logPath string) (*Serve
 	)
 
 	s := &Server{
-		config:    cfg,
-		validator: validatorObj,
-		runner:    runnerObj,
-		logger:    loggerObj,
-		mcpServer: mcpServer,
-		port:      port,
+		config:      cfg,
+		validator:   validatorObj,
+		runner:      runnerObj,
+		logger:      loggerObj,
+		mcpServer:   mcpServer,
+		port:        port,
+		rateLimiter: ratelimit.NewForPolicy(cfg),
+		sessions:    session.New(cfg.Sessions),
+		recorders:   make(map[string]*recording.Recorder),
+		approvals:   approval.New(time.Duration(cfg.ApprovalTimeout) * time.Second),
+		history:     historyObj,
+		alerter:     alert.New(cfg.Alerts),
+		auditor:     audit.New(cfg.Audit),
+	}
+	if cfg.OutputSpool.Enabled {
+		s.spool = spool.New(cfg.OutputSpool)
+	}
+	if cfg.Snapshot.Enabled {
+		s.snapshots = snapshot.New(cfg.Snapshot)
+	}
+	if cfg.Trash.Enabled {
+		s.trash = trash.New(cfg.Trash)
+	}
+	if cfg.CachesResults() {
+		s.resultCache = resultcache.New(cfg.ResultCache)
 	}
 
 	// Initialize working directory from PWD environment variable if configured
@@ -112,41 +255,114 @@ func NewServer(cfg *config.ShellCommandConfig, port int, logPath string) (*Serve
 	return s, nil
 }
 
-// Start initializes and starts the MCP server.
-func (s *Server) Start() error {
-	// Register tools
-	s.mcpServer.AddTool(createRunTool(), s.HandleRunCommand)
+// SetTracerProvider emits the spans of executed commands through tp instead of the global provider.
+// It must be called before Start.
+func (s *Server) SetTracerProvider(tp trace.TracerProvider) {
+	s.tracerProvider = tp
+}
+
+// Approvals returns the queue of commands waiting for approval.
+func (s *Server) Approvals() *approval.Queue {
+	return s.approvals
+}
+
+// registerTools adds the server's tools to the MCP server.
+func (s *Server) registerTools() {
+	s.mcpServer.AddTool(createRunTool(s.snapshots != nil), s.HandleRunCommand)
 	s.mcpServer.AddTool(createPwdTool(), s.HandlePwd)
+	if s.spool != nil {
+		s.mcpServer.AddTool(createReadOutputTool(), s.HandleReadOutput)
+	}
+	if s.snapshots != nil {
+		s.mcpServer.AddTool(createSnapshotTool("commit_snapshot",
+			"Apply the changes kept in a snapshot to the working directory it was taken of."), s.HandleCommitSnapshot)
+		s.mcpServer.AddTool(createSnapshotTool("discard_snapshot",
+			"Throw away the changes kept in a snapshot, leaving the working directory unchanged."), s.HandleDiscardSnapshot)
+	}
+	if s.trash != nil {
+		s.mcpServer.AddTool(createListTrashTool(), s.HandleListTrash)
+		s.mcpServer.AddTool(createRestoreTrashTool(), s.HandleRestoreTrash)
+	}
+}
 
-	// Start the server
-	address := fmt.Sprintf(":%d", s.port)
-	s.logger.LogInfof("Starting MCP server on %s", address)
+// closeSpool removes the spooled outputs and uncommitted snapshots when the server stops.
+func (s *Server) closeSpool() {
+	if s.spool != nil {
+		if err := s.spool.Close(); err != nil {
+			s.logger.LogErrorf("Failed to remove spooled output: %v", err)
+		}
+	}
+	if s.snapshots != nil {
+		if err := s.snapshots.Close(); err != nil {
+			s.logger.LogErrorf("Failed to remove snapshots: %v", err)
+		}
+	}
+}
+
+// SetTLS serves HTTP over TLS with the PEM-encoded certificate and key in certFile and keyFile.
+// It must be called before Start.
+func (s *Server) SetTLS(certFile, keyFile string) {
+	s.tlsCertFile = certFile
+	s.tlsKeyFile = keyFile
+}
+
+// Handler returns the HTTP handler serving MCP over SSE and the attach endpoint (see
+// HandleAttach). With auth configured, every request but those of the health endpoints (see
+// health.Register) must be authenticated, and the commands it runs are attributed to the
+// user its credentials resolve to.
+func (s *Server) Handler() (http.Handler, error) {
+	authenticator, err := auth.New(s.config.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure authentication: %w", err)
+	}
+	s.registerTools()
+	var mcpHandler http.Handler = server.NewSSEServer(s.mcpServer)
+	if authenticator != nil {
+		mcpHandler = auth.Middleware(authenticator, s.logger, mcpHandler)
+	}
+
+	var attachHandler http.Handler = http.HandlerFunc(s.HandleAttach)
+	if authenticator != nil {
+		attachHandler = auth.Middleware(authenticator, s.logger, attachHandler)
+	}
 
-	// Create HTTP server to serve the MCP server
 	handler := http.NewServeMux()
-	handler.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
-		// TODO: Implement proper HTTP handler for MCP
-		_, err := w.Write([]byte("MCP server running"))
-		if err != nil {
-			s.logger.LogErrorf("Failed to write response: %v", err)
-		}
-	}))
+	health.Register(handler, health.Static(s.config))
+	handler.Handle("GET "+attachPath, attachHandler)
+	handler.Handle("/", mcpHandler)
+	return handler, nil
+}
 
-	// Timeout constants
-	const (
-		readTimeoutSeconds  = 10
-		writeTimeoutSeconds = 10
-	)
+// Start initializes and starts the MCP server, serving MCP over SSE on the configured port.
+func (s *Server) Start() error {
+	defer s.closeSpool()
 
-	// Create a server with timeouts
-	server := &http.Server{
-		Addr:         address,
-		Handler:      handler,
-		ReadTimeout:  readTimeoutSeconds * time.Second,
-		WriteTimeout: writeTimeoutSeconds * time.Second,
+	useTLS := s.tlsCertFile != ""
+	if s.config.Auth.MTLS.ClientCA != "" && !useTLS {
+		return errors.New("client certificate authentication requires TLS")
+	}
+	handler, err := s.Handler()
+	if err != nil {
+		return err
 	}
 
-	return server.ListenAndServe()
+	// Start the server
+	address := fmt.Sprintf(":%d", s.port)
+	s.logger.LogInfof("Starting MCP server on %s", address)
+
+	server := &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+	if !useTLS {
+		return server.ListenAndServe()
+	}
+	server.TLSConfig, err = auth.TLSConfig(s.config.Auth)
+	if err != nil {
+		return err
+	}
+	return server.ListenAndServeTLS(s.tlsCertFile, s.tlsKeyFile)
 }
 
 // HandlePwd handles the pwd tool execution.
@@ -169,6 +385,14 @@ type commandResult struct {
 	err        error
 	newWorkDir string // non-empty if cd changed the working directory
 	hints      []hint.Hint
+	// stdoutSpool and stderrSpool hold the full output of a truncated stream
+	stdoutSpool *spool.Output
+	stderrSpool *spool.Output
+	// snapshot holds the changes of the command when snapshots are enabled
+	snapshot *snapshot.Snapshot
+	// fileChanges lists the files the command changed when file auditing is enabled
+	fileChanges     []fileaudit.Change
+	filesIncomplete bool
 }
 
 // HandleRunCommand handles the run tool execution.
@@ -185,6 +409,7 @@ func (s *Server) HandleRunCommand(ctx context.Context, request mcp.CallToolReque
 		}
 		mode = m
 	}
+	snapshotID, _ := request.Params.Arguments["snapshot"].(string)
 
 	s.cmdMutex.Lock()
 	workingDir := s.workingDir
@@ -193,9 +418,7 @@ func (s *Server) HandleRunCommand(ctx context.Context, request mcp.CallToolReque
 	if workingDir == "" {
 		// Use the first allowed directory as default when no directory is set.
 		// This allows the initial cd command to work without a pre-set directory.
-		if len(s.config.AllowedDirectories) > 0 {
-			workingDir = s.config.AllowedDirectories[0]
-		} else {
+		if workingDir = s.config.DefaultDirectory(); workingDir == "" {
 			return mcp.NewToolResultError(
 				"No working directory set and no allowed directories configured. Use cd command to set a working directory."), nil
 		}
@@ -203,9 +426,9 @@ func (s *Server) HandleRunCommand(ctx context.Context, request mcp.CallToolReque
 
 	var results []commandResult
 	if mode == modeSerial {
-		results = s.runSerial(ctx, commands, workingDir)
+		results = s.runSerial(ctx, commands, workingDir, snapshotID)
 	} else {
-		results = s.runParallel(ctx, commands, workingDir)
+		results = s.runParallel(ctx, commands, workingDir, snapshotID)
 	}
 
 	// Persist cd directory changes from serial execution, or parallel with a single command.
@@ -257,16 +480,20 @@ func parseCommands(raw interface{}) ([]string, error) {
 }
 
 // runSerial executes commands one by one, stopping on first error.
-// Directory changes from cd are propagated to subsequent commands.
-func (s *Server) runSerial(ctx context.Context, commands []string, workingDir string) []commandResult {
+// Directory changes from cd are propagated to subsequent commands, and so is the snapshot
+// the first command that changed anything ran in.
+func (s *Server) runSerial(ctx context.Context, commands []string, workingDir, snapshotID string) []commandResult {
 	results := make([]commandResult, 0, len(commands))
 	currentDir := workingDir
 	for _, cmd := range commands {
-		r := s.executeOne(ctx, cmd, currentDir)
+		r := s.executeOne(ctx, cmd, currentDir, snapshotID)
 		results = append(results, r)
 		if r.newWorkDir != "" {
 			currentDir = r.newWorkDir
 		}
+		if r.snapshot != nil {
+			snapshotID = r.snapshot.ID
+		}
 		if r.err != nil {
 			break
 		}
@@ -275,25 +502,46 @@ func (s *Server) runSerial(ctx context.Context, commands []string, workingDir st
 }
 
 // runParallel executes all commands concurrently.
-func (s *Server) runParallel(ctx context.Context, commands []string, workingDir string) []commandResult {
+func (s *Server) runParallel(ctx context.Context, commands []string, workingDir, snapshotID string) []commandResult {
 	results := make([]commandResult, len(commands))
 	var wg sync.WaitGroup
 	for i, cmd := range commands {
 		wg.Add(1)
 		go func(idx int, c string) {
 			defer wg.Done()
-			results[idx] = s.executeOne(ctx, c, workingDir)
+			results[idx] = s.executeOne(ctx, c, workingDir, snapshotID)
 		}(i, cmd)
 	}
 	wg.Wait()
 	return results
 }
 
-// executeOne runs a single command and returns its result.
-func (s *Server) executeOne(ctx context.Context, command, workingDir string) commandResult {
+// executeOne runs a single command, in the snapshot with the given ID if any, and returns its result.
+func (s *Server) executeOne(ctx context.Context, command, workingDir, snapshotID string) commandResult {
 	s.logger.LogInfof("Command attempt: %s in directory: %s", command, workingDir)
 
-	r := runner.New(s.config, s.validator, s.logger)
+	id := callerIdentity(ctx)
+	ctx = identity.WithIdentity(ctx, id)
+	// MCP does not tell the server when a session ends, so sessions are only closed by their lifetime
+	sess, err := s.sessions.Lookup(id.Key(), id.Session)
+	if err == nil {
+		err = sess.Begin()
+	}
+	if err != nil {
+		s.logger.LogErrorf("Command rejected: %v", err)
+		return commandResult{command: command, err: err}
+	}
+	ctx, cancel := sess.Context(ctx)
+	defer cancel()
+
+	release, err := s.rateLimiter.Acquire(id.Key())
+	if err != nil {
+		s.logger.LogErrorf("Command rejected: %v", err)
+		return commandResult{command: command, err: err}
+	}
+	defer release()
+
+	r := s.newRunner(id, snapshotID)
 	buf := new(strings.Builder)
 	r.SetOutputs(buf, buf)
 
@@ -301,7 +549,74 @@ func (s *Server) executeOne(ctx context.Context, command, workingDir string) com
 	if result.Err != nil {
 		s.logger.LogErrorf("Command execution failed: %v", result.Err)
 	}
-	return commandResult{command: command, output: buf.String(), err: result.Err, newWorkDir: result.NewWorkDir, hints: result.Hints}
+	return commandResult{
+		command:     command,
+		output:      buf.String(),
+		err:         result.Err,
+		newWorkDir:  result.NewWorkDir,
+		hints:       result.Hints,
+		stdoutSpool: result.StdoutSpool,
+		stderrSpool: result.StderrSpool,
+		snapshot:    result.Snapshot,
+
+		fileChanges:     result.FileChanges,
+		filesIncomplete: result.FileChangesIncomplete,
+	}
+}
+
+// newRunner returns a runner for a command of the caller id, in the snapshot with the given ID if any.
+func (s *Server) newRunner(id identity.Identity, snapshotID string) *runner.SafeRunner {
+	r := runner.New(s.config, s.validator, s.logger)
+	if s.tracerProvider != nil {
+		r.SetTracerProvider(s.tracerProvider)
+	}
+	r.SetApprovals(s.approvals)
+	r.SetHistory(s.history, id.Key())
+	r.SetAlerter(s.alerter)
+	r.SetAuditor(s.auditor)
+	r.SetSpool(s.spool)
+	r.SetSnapshots(s.snapshots)
+	r.SetSnapshot(snapshotID)
+	r.SetResultCache(s.resultCache)
+	if rec := s.recorderFor(id.Key()); rec != nil {
+		r.SetRecorder(rec)
+	}
+	return r
+}
+
+// recorderFor returns the session recording for caller, starting it on first use.
+// It returns nil when recording is disabled or the recording cannot be created.
+func (s *Server) recorderFor(caller string) *recording.Recorder {
+	if s.config.RecordingDir == "" {
+		return nil
+	}
+
+	s.recordersMu.Lock()
+	defer s.recordersMu.Unlock()
+
+	if rec, ok := s.recorders[caller]; ok {
+		return rec
+	}
+	rec, err := recording.Create(s.config.RecordingDir, caller, "MCP session "+caller)
+	if err != nil {
+		s.logger.LogErrorf("Failed to start session recording: %v", err)
+		return nil
+	}
+	s.recorders[caller] = rec
+	return rec
+}
+
+// callerIdentity identifies the caller of a request: the identity attached to ctx by the
+// program embedding the server, if any, with the MCP session filled in.
+func callerIdentity(ctx context.Context) identity.Identity {
+	id, _ := identity.FromContext(ctx)
+	if id.Session == "" {
+		id.Session = "default"
+		if session := server.ClientSessionFromContext(ctx); session != nil {
+			id.Session = session.SessionID()
+		}
+	}
+	return id
 }
 
 // formatResultsWithHints builds a tool result from command results, appending any token-saving hints.
@@ -343,6 +658,10 @@ func formatResults(results []commandResult) *mcp.CallToolResult {
 			fmt.Fprintf(&sb, "Error: %v\n", r.err)
 		}
 		sb.WriteString(r.output)
+		writeSpoolNote(&sb, "stdout", r.stdoutSpool)
+		writeSpoolNote(&sb, "stderr", r.stderrSpool)
+		writeSnapshotNote(&sb, r.snapshot)
+		writeFileChangesNote(&sb, r.fileChanges, r.filesIncomplete)
 		if len(results) > 1 && i < len(results)-1 {
 			sb.WriteString("\n")
 		}
@@ -354,11 +673,168 @@ func formatResults(results []commandResult) *mcp.CallToolResult {
 	return mcp.NewToolResultText(sb.String())
 }
 
+// writeSpoolNote tells the client how to read the full output of a truncated stream.
+func writeSpoolNote(sb *strings.Builder, stream string, out *spool.Output) {
+	if out == nil {
+		return
+	}
+	fmt.Fprintf(sb, "\n[The full %s (%d bytes) is saved as output %q; page through it with the read_output tool]\n", stream, out.Size(), out.ID)
+}
+
+// writeSnapshotNote lists the changes kept in a snapshot and tells the client how to keep them.
+func writeSnapshotNote(sb *strings.Builder, snap *snapshot.Snapshot) {
+	if snap == nil {
+		return
+	}
+	changes, err := snap.Changes()
+	if err != nil {
+		fmt.Fprintf(sb, "\n[Changes are kept in snapshot %q, but could not be listed: %v]\n", snap.ID, err)
+		return
+	}
+	fmt.Fprintf(sb, "\n[Changes are kept in snapshot %q; commit them with commit_snapshot, discard them with discard_snapshot, "+
+		"or pass the snapshot to run to continue in it]\n", snap.ID)
+	for _, c := range changes {
+		fmt.Fprintf(sb, "%s %s\n", c.Kind, c.Path)
+	}
+}
+
+// writeFileChangesNote lists the files a command changed.
+func writeFileChangesNote(sb *strings.Builder, changes []fileaudit.Change, incomplete bool) {
+	if len(changes) > 0 {
+		sb.WriteString("\n[Files changed]\n")
+		for _, c := range changes {
+			fmt.Fprintf(sb, "%s %s\n", c.Kind, c.Path)
+		}
+	}
+	if incomplete {
+		sb.WriteString("\n[Some files were not checked for changes: the allowed directories hold more than fileAudit.maxFiles]\n")
+	}
+}
+
+// HandleCommitSnapshot handles the commit_snapshot tool execution.
+func (s *Server) HandleCommitSnapshot(_ context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	snap, errResult := s.findSnapshot(request)
+	if errResult != nil {
+		return errResult, nil
+	}
+	changes, err := snap.Commit()
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	s.logger.LogInfof("Snapshot %s committed to %s", snap.ID, snap.Source)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Committed %d changes to %s\n", len(changes), snap.Source)
+	for _, c := range changes {
+		fmt.Fprintf(&sb, "%s %s\n", c.Kind, c.Path)
+	}
+	return mcp.NewToolResultText(sb.String()), nil
+}
+
+// HandleDiscardSnapshot handles the discard_snapshot tool execution.
+func (s *Server) HandleDiscardSnapshot(_ context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	snap, errResult := s.findSnapshot(request)
+	if errResult != nil {
+		return errResult, nil
+	}
+	if err := snap.Discard(); err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	s.logger.LogInfof("Snapshot %s of %s discarded", snap.ID, snap.Source)
+	return mcp.NewToolResultText("Discarded snapshot " + snap.ID), nil
+}
+
+// findSnapshot returns the snapshot named by the id argument, or the tool result of the
+// error when there is none.
+func (s *Server) findSnapshot(request mcp.CallToolRequest) (*snapshot.Snapshot, *mcp.CallToolResult) {
+	id, _ := request.Params.Arguments["id"].(string)
+	if id == "" {
+		return nil, mcp.NewToolResultError("id must be a non-empty string")
+	}
+	snap, err := s.snapshots.Get(id)
+	if err != nil {
+		return nil, mcp.NewToolResultError(err.Error())
+	}
+	return snap, nil
+}
+
+// HandleListTrash handles the list_trash tool execution.
+func (s *Server) HandleListTrash(ctx context.Context, _ mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	items, err := s.trash.List(callerIdentity(ctx).Session)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	if len(items) == 0 {
+		return mcp.NewToolResultText("The trash is empty"), nil
+	}
+	var sb strings.Builder
+	for _, item := range items {
+		kind := "file"
+		if item.Dir {
+			kind = "dir"
+		}
+		fmt.Fprintf(&sb, "%s %s %s (deleted %s)\n", item.ID, kind, item.Path, item.Deleted.Format(time.RFC3339))
+	}
+	return mcp.NewToolResultText(sb.String()), nil
+}
+
+// HandleRestoreTrash handles the restore_trash tool execution. Items are only restored into
+// directories the policy still allows.
+func (s *Server) HandleRestoreTrash(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	id, _ := request.Params.Arguments["id"].(string)
+	if id == "" {
+		return mcp.NewToolResultError("id must be a non-empty string"), nil
+	}
+	session := callerIdentity(ctx).Session
+	item, err := s.trash.Get(session, id)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	if allowed, message := s.validator.IsPathInAllowedDirectory(item.Path, "/"); !allowed {
+		return mcp.NewToolResultError("cannot restore " + item.Path + ": " + message), nil
+	}
+	if _, err := s.trash.Restore(session, id); err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	s.logger.LogInfof("Trash item %s of session %s restored to %s", id, session, item.Path)
+	return mcp.NewToolResultText("Restored " + item.Path), nil
+}
+
+// HandleReadOutput handles the read_output tool execution.
+func (s *Server) HandleReadOutput(_ context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	id, _ := request.Params.Arguments["id"].(string)
+	if id == "" {
+		return mcp.NewToolResultError("id must be a non-empty string"), nil
+	}
+	out, err := s.spool.Get(id)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	length := s.config.MaxOutputSize
+	if n, ok := request.Params.Arguments["length"].(float64); ok && n > 0 && (length == 0 || int(n) < length) {
+		length = int(n)
+	}
+	offset := int64(0)
+	if n, ok := request.Params.Arguments["offset"].(float64); ok {
+		offset = int64(n)
+	}
+	if tail, _ := request.Params.Arguments["tail"].(bool); tail {
+		offset = max(0, out.Size()-int64(length))
+	}
+
+	data, err := out.ReadRange(offset, length)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	end := offset + int64(len(data))
+	return mcp.NewToolResultText(fmt.Sprintf("%s\n[bytes %d-%d of %d]", data, offset, end, out.Size())), nil
+}
+
 // ServeStdio starts an MCP server using stdin/stdout for communication.
 func (s *Server) ServeStdio() error {
-	// Register tools
-	s.mcpServer.AddTool(createRunTool(), s.HandleRunCommand)
-	s.mcpServer.AddTool(createPwdTool(), s.HandlePwd)
+	s.registerTools()
+	defer s.closeSpool()
 
 	// Start the server using stdio
 	s.logger.LogInfof("Starting MCP server using stdin/stdout")
@@ -1,7 +1,13 @@
 package service_test
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"testing"
 
@@ -556,6 +562,87 @@ func TestTokenSavingHints(t *testing.T) {
 	})
 }
 
+func TestRateLimit(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories:  []string{tmpDir},
+		AllowCommands:       []config.AllowCommand{{Command: "echo"}},
+		DefaultErrorMessage: "Command not allowed",
+		RateLimit:           config.RateLimitConfig{CommandsPerMinute: 1},
+	}
+	srv, err := service.NewServer(cfg, 0, "")
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	ctx := t.Context()
+
+	result, err := srv.HandleRunCommand(ctx, makeToolRequest(map[string]interface{}{
+		"commands": []interface{}{"echo first"},
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertToolSuccess(t, result, "first")
+
+	result, err = srv.HandleRunCommand(ctx, makeToolRequest(map[string]interface{}{
+		"commands": []interface{}{"echo second"},
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertToolError(t, result, "rate limit exceeded")
+}
+
+func TestReadOutput(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories:  []string{tmpDir},
+		AllowCommands:       []config.AllowCommand{{Command: "echo"}},
+		DefaultErrorMessage: "Command not allowed",
+		MaxOutputSize:       8,
+		OutputSpool:         config.OutputSpoolConfig{Enabled: true, Dir: t.TempDir()},
+	}
+	srv, err := service.NewServer(cfg, 0, "")
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	ctx := t.Context()
+
+	result, err := srv.HandleRunCommand(ctx, makeToolRequest(map[string]interface{}{
+		"commands": []interface{}{"echo 0123456789abcdef"},
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	text := extractText(result)
+	match := regexp.MustCompile(`saved as output "([0-9a-f]+)"`).FindStringSubmatch(text)
+	if match == nil {
+		t.Fatalf("expected a spooled output note, got: %s", text)
+	}
+
+	result, err = srv.HandleReadOutput(ctx, makeToolRequest(map[string]interface{}{
+		"id": match[1], "offset": float64(4), "length": float64(4),
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertToolSuccess(t, result, "4567\n[bytes 4-8 of 17]")
+
+	result, err = srv.HandleReadOutput(ctx, makeToolRequest(map[string]interface{}{
+		"id": match[1], "tail": true,
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertToolSuccess(t, result, "9abcdef\n\n[bytes 9-17 of 17]")
+
+	result, err = srv.HandleReadOutput(ctx, makeToolRequest(map[string]interface{}{"id": "unknown"}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertToolError(t, result, "not found")
+}
+
 func assertToolError(t *testing.T, result *mcp.CallToolResult, contains string) {
 	t.Helper()
 	if !result.IsError {
@@ -591,3 +678,198 @@ func extractText(result *mcp.CallToolResult) string {
 func makeDir(path string) error {
 	return os.MkdirAll(path, 0o755)
 }
+
+func TestSnapshotTools(t *testing.T) {
+	tmpDir := t.TempDir()
+	notes := filepath.Join(tmpDir, "notes.txt")
+	if err := os.WriteFile(notes, []byte("original\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories:  []string{tmpDir},
+		AllowCommands:       []config.AllowCommand{{Command: "echo"}, {Command: "cat"}},
+		DefaultErrorMessage: "Command not allowed",
+		Snapshot:            config.SnapshotConfig{Enabled: true, Dir: t.TempDir()},
+	}
+	srv, err := service.NewServer(cfg, 0, "")
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	ctx := t.Context()
+	snapshotID := regexp.MustCompile(`kept in snapshot "([0-9a-f]+)"`)
+	readNotes := func() string {
+		data, err := os.ReadFile(notes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	// Serial commands continue in the snapshot of the first one that changed anything
+	result, err := srv.HandleRunCommand(ctx, makeToolRequest(map[string]interface{}{
+		"commands": []interface{}{"echo changed > notes.txt", "cat notes.txt"},
+		"mode":     "serial",
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	text := extractText(result)
+	match := snapshotID.FindStringSubmatch(text)
+	if match == nil || !strings.Contains(text, "modified notes.txt") || !strings.Contains(text, "changed\n") {
+		t.Fatalf("expected the changes to be kept in a snapshot, got: %s", text)
+	}
+	if got := readNotes(); got != "original\n" {
+		t.Fatalf("notes.txt = %q before the commit", got)
+	}
+
+	result, err = srv.HandleCommitSnapshot(ctx, makeToolRequest(map[string]interface{}{"id": match[1]}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertToolSuccess(t, result, "Committed 1 changes")
+	if got := readNotes(); got != "changed\n" {
+		t.Fatalf("notes.txt = %q after the commit", got)
+	}
+
+	result, err = srv.HandleRunCommand(ctx, makeToolRequest(map[string]interface{}{"commands": []interface{}{"echo again > notes.txt"}}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	match = snapshotID.FindStringSubmatch(extractText(result))
+	if match == nil {
+		t.Fatalf("expected a snapshot, got: %s", extractText(result))
+	}
+	result, err = srv.HandleDiscardSnapshot(ctx, makeToolRequest(map[string]interface{}{"id": match[1]}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertToolSuccess(t, result, "Discarded snapshot")
+	if got := readNotes(); got != "changed\n" {
+		t.Fatalf("notes.txt = %q after discarding", got)
+	}
+
+	result, err = srv.HandleCommitSnapshot(ctx, makeToolRequest(map[string]interface{}{"id": match[1]}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertToolError(t, result, "not found")
+}
+
+func TestTrashTools(t *testing.T) {
+	tmpDir := t.TempDir()
+	notes := filepath.Join(tmpDir, "notes.txt")
+	if err := os.WriteFile(notes, []byte("keep me\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories:  []string{tmpDir},
+		AllowCommands:       []config.AllowCommand{{Command: "rm"}},
+		DefaultErrorMessage: "Command not allowed",
+		Trash:               config.TrashConfig{Enabled: true, Dir: t.TempDir()},
+	}
+	srv, err := service.NewServer(cfg, 0, "")
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	ctx := t.Context()
+
+	result, err := srv.HandleListTrash(ctx, makeToolRequest(nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertToolSuccess(t, result, "The trash is empty")
+
+	result, err = srv.HandleRunCommand(ctx, makeToolRequest(map[string]interface{}{"commands": []interface{}{"rm notes.txt"}}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertToolSuccess(t, result, "")
+	if _, err := os.Stat(notes); !os.IsNotExist(err) {
+		t.Fatalf("notes.txt still exists after rm: %v", err)
+	}
+
+	result, err = srv.HandleListTrash(ctx, makeToolRequest(nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	match := regexp.MustCompile(`(?m)^([0-9a-f]+) file ` + regexp.QuoteMeta(notes)).FindStringSubmatch(extractText(result))
+	if match == nil {
+		t.Fatalf("expected notes.txt in the trash, got: %s", extractText(result))
+	}
+
+	result, err = srv.HandleRestoreTrash(ctx, makeToolRequest(map[string]interface{}{"id": match[1]}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertToolSuccess(t, result, "Restored "+notes)
+	if data, err := os.ReadFile(notes); err != nil || string(data) != "keep me\n" {
+		t.Fatalf("notes.txt = %q, %v after restoring", data, err)
+	}
+
+	result, err = srv.HandleRestoreTrash(ctx, makeToolRequest(map[string]interface{}{"id": match[1]}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertToolError(t, result, "not found")
+}
+
+func TestServer_HandlerAuth(t *testing.T) {
+	workDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(workDir, "file.txt"), []byte("hello"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	hash := func(key string) string {
+		sum := sha256.Sum256([]byte(key))
+		return hex.EncodeToString(sum[:])
+	}
+	cfg := &config.ShellCommandConfig{
+		AllowedDirectories: []string{workDir},
+		AllowCommands:      []config.AllowCommand{{Command: "echo"}},
+		Users:              map[string]config.PolicyOverlay{"alice": {AllowCommands: []config.AllowCommand{{Command: "ls"}}}},
+		Auth: config.AuthConfig{APIKeys: []config.APIKeyConfig{
+			{User: "alice", SHA256: hash("key-alice")},
+			{User: "bob", SHA256: hash("key-bob")},
+		}},
+	}
+	srv, err := service.NewServer(cfg, 0, "")
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	handler, err := srv.Handler()
+	if err != nil {
+		t.Fatalf("Handler: %v", err)
+	}
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	t.Run("unauthorized", func(t *testing.T) {
+		for _, key := range []string{"", "wrong"} {
+			if status, _ := post(t, ts.URL, "/message", key, "{}"); status != http.StatusUnauthorized {
+				t.Errorf("key %q: status %d, want %d", key, status, http.StatusUnauthorized)
+			}
+		}
+	})
+
+	t.Run("health endpoints need no key", func(t *testing.T) {
+		resp, err := http.Get(ts.URL + "/healthz")
+		if err != nil {
+			t.Fatalf("GET /healthz: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET /healthz: status %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("policy of the authenticated user", func(t *testing.T) {
+		const runLs = `{"jsonrpc": "2.0", "id": 1, "method": "tools/call", "params": {"name": "run", "arguments": {"commands": ["ls"]}}}`
+		_, body := post(t, ts.URL, openSession(t, ts.URL, "key-alice"), "key-alice", runLs)
+		if !strings.Contains(body, "file.txt") {
+			t.Errorf("alice may run ls, got %s", body)
+		}
+		_, body = post(t, ts.URL, openSession(t, ts.URL, "key-bob"), "key-bob", runLs)
+		if strings.Contains(body, "file.txt") {
+			t.Errorf("bob may not run ls, got %s", body)
+		}
+	})
+}
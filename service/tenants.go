@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/config"
+	"github.com/shimizu1995/secure-shell-server/pkg/health"
+	"github.com/shimizu1995/secure-shell-server/pkg/identity"
+	"github.com/shimizu1995/secure-shell-server/pkg/logger"
+	"github.com/shimizu1995/secure-shell-server/pkg/tenant"
+)
+
+// readHeaderTimeout bounds how long the HTTP servers wait for request headers. Requests have no
+// overall timeout because SSE streams stay open for the whole MCP session.
+const readHeaderTimeout = 10 * time.Second
+
+// TenantServer serves MCP over HTTP (SSE) to the tenants of a registry. Every request carries an
+// API token as "Authorization: Bearer <token>" and is handled by a Server with the policy of the
+// tenant the token belongs to, so that teams sharing a deployment never share sessions, working
+// directories, approvals, or limits.
+type TenantServer struct {
+	registry *tenant.Registry
+	port     int
+	logger   *logger.Logger
+
+	// health serves the health, readiness, and version endpoints without authentication
+	health *http.ServeMux
+
+	mu sync.Mutex
+	// servers holds the server of every current tenant
+	servers map[*tenant.Tenant]*tenantHandler
+	// retired holds the servers of tenants that were changed or removed; their MCP sessions may
+	// still be running, so their spooled output and snapshots are only removed on shutdown
+	retired []*Server
+}
+
+// tenantHandler is the MCP server of a tenant with its SSE transport.
+type tenantHandler struct {
+	server *Server
+	sse    *server.SSEServer
+}
+
+// NewTenantServer creates a server for the tenants of registry listening on port.
+func NewTenantServer(registry *tenant.Registry, port int, logPath string) (*TenantServer, error) {
+	loggerObj, err := logger.NewWithPath(logPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create logger: %w", err)
+	}
+
+	t := &TenantServer{
+		registry: registry,
+		port:     port,
+		logger:   loggerObj,
+		servers:  make(map[*tenant.Tenant]*tenantHandler),
+		health:   http.NewServeMux(),
+	}
+	health.Register(t.health, t.policies)
+	if err := t.Update(registry.Tenants()); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Update creates servers for new and changed tenants and retires those of tenants that are
+// gone. Servers of unchanged tenants, and so their sessions, are kept.
+func (t *TenantServer) Update(tenants []*tenant.Tenant) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	servers := make(map[*tenant.Tenant]*tenantHandler, len(tenants))
+	for _, ten := range tenants {
+		if h, ok := t.servers[ten]; ok {
+			servers[ten] = h
+			continue
+		}
+		h, err := t.newTenantHandler(ten)
+		if err != nil {
+			return fmt.Errorf("tenant %q: %w", ten.Name, err)
+		}
+		servers[ten] = h
+		t.logger.LogInfof("Serving tenant %s", ten.Name)
+	}
+	for ten, h := range t.servers {
+		if _, ok := servers[ten]; !ok {
+			t.retired = append(t.retired, h.server)
+		}
+	}
+	t.servers = servers
+	return nil
+}
+
+// newTenantHandler creates the MCP server of ten. Its commands are attributed to the user
+// "tenant:NAME".
+func (t *TenantServer) newTenantHandler(ten *tenant.Tenant) (*tenantHandler, error) {
+	s, err := newServer(ten.Config, t.port, t.logger.With("tenant="+ten.Name))
+	if err != nil {
+		return nil, err
+	}
+	s.registerTools()
+
+	user := "tenant:" + ten.Name
+	sse := server.NewSSEServer(s.mcpServer, server.WithSSEContextFunc(func(ctx context.Context, _ *http.Request) context.Context {
+		id, _ := identity.FromContext(ctx)
+		id.User = user
+		return identity.WithIdentity(ctx, id)
+	}))
+	return &tenantHandler{server: s, sse: sse}, nil
+}
+
+// policies returns the policies of the tenants by name and the error of the last reload.
+func (t *TenantServer) policies() (map[string]*config.ShellCommandConfig, error) {
+	policies := make(map[string]*config.ShellCommandConfig)
+	for _, ten := range t.registry.Tenants() {
+		policies[ten.Name] = ten.Config
+	}
+	return policies, t.registry.LastError()
+}
+
+// ServeHTTP authenticates the request's API token and passes the request to its tenant's server.
+// The health endpoints (see health.Register) need no token.
+func (t *TenantServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h, pattern := t.health.Handler(r); pattern != "" {
+		h.ServeHTTP(w, r)
+		return
+	}
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	ten, ok := t.registry.Authenticate(token)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	t.mu.Lock()
+	h, ok := t.servers[ten]
+	t.mu.Unlock()
+	if !ok {
+		// The registry was reloaded, but the server has not caught up yet
+		http.Error(w, "tenant is being reloaded", http.StatusServiceUnavailable)
+		return
+	}
+	h.sse.ServeHTTP(w, r)
+}
+
+// Start serves HTTP until ctx is done, reloading the tenants every reloadInterval. A reload that
+// fails is logged and leaves the previous tenants in effect.
+func (t *TenantServer) Start(ctx context.Context, reloadInterval time.Duration) error {
+	defer t.close()
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		err := t.registry.Watch(watchCtx, reloadInterval, func(tenants []*tenant.Tenant) {
+			if err := t.Update(tenants); err != nil {
+				t.logger.LogErrorf("Failed to apply reloaded tenants: %v", err)
+				return
+			}
+			t.logger.LogInfof("Reloaded %d tenants", len(tenants))
+		}, func(err error) {
+			t.logger.LogErrorf("Failed to reload tenants: %v", err)
+		})
+		if err != nil && !errors.Is(err, context.Canceled) {
+			t.logger.LogErrorf("Tenant reloading stopped: %v", err)
+		}
+	}()
+
+	address := fmt.Sprintf(":%d", t.port)
+	t.logger.LogInfof("Starting multi-tenant MCP server on %s", address)
+	srv := &http.Server{
+		Addr:              address,
+		Handler:           t,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+	go func() {
+		<-watchCtx.Done()
+		_ = srv.Close()
+	}()
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// close removes the spooled output and snapshots of every tenant's server.
+func (t *TenantServer) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, h := range t.servers {
+		h.server.closeSpool()
+	}
+	for _, s := range t.retired {
+		s.closeSpool()
+	}
+	_ = t.logger.Close()
+}
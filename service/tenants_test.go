@@ -0,0 +1,150 @@
+package service_test
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shimizu1995/secure-shell-server/pkg/tenant"
+	"github.com/shimizu1995/secure-shell-server/service"
+)
+
+// writeTenantFiles writes the files of a tenant allowing cmd in workDir with a single API token.
+func writeTenantFiles(t *testing.T, dir, name, cmd, workDir, token string) {
+	t.Helper()
+	cfg := `{"allowedDirectories": ["` + workDir + `"], "allowCommands": ["` + cmd + `"], "denyCommands": []}`
+	sum := sha256.Sum256([]byte(token))
+	if err := os.WriteFile(filepath.Join(dir, name+".json"), []byte(cfg), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".tokens"), []byte(hex.EncodeToString(sum[:])+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// openSession connects to the SSE endpoint with token and returns the message endpoint of the session.
+func openSession(t *testing.T, baseURL, token string) string {
+	t.Helper()
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, baseURL+"/sse", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /sse: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /sse: status %d", resp.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if endpoint, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			return endpoint
+		}
+	}
+	t.Fatal("no endpoint event")
+	return ""
+}
+
+// post sends a JSON-RPC message to endpoint with token and returns the status and body.
+func post(t *testing.T, baseURL, endpoint, token, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodPost, baseURL+endpoint, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST %s: %v", endpoint, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+func TestTenantServer(t *testing.T) {
+	dir := t.TempDir()
+	workDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(workDir, "file.txt"), []byte("hello"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	writeTenantFiles(t, dir, "alpha", "ls", workDir, "token-a")
+	writeTenantFiles(t, dir, "beta", "git", workDir, "token-b")
+
+	registry, err := tenant.Load(dir)
+	if err != nil {
+		t.Fatalf("tenant.Load: %v", err)
+	}
+	tenantServer, err := service.NewTenantServer(registry, 0, "")
+	if err != nil {
+		t.Fatalf("NewTenantServer: %v", err)
+	}
+	ts := httptest.NewServer(tenantServer)
+	defer ts.Close()
+
+	t.Run("unauthorized", func(t *testing.T) {
+		for _, token := range []string{"", "wrong"} {
+			if status, _ := post(t, ts.URL, "/message", token, "{}"); status != http.StatusUnauthorized {
+				t.Errorf("token %q: status %d, want %d", token, status, http.StatusUnauthorized)
+			}
+		}
+	})
+
+	t.Run("health endpoints need no token", func(t *testing.T) {
+		resp, err := http.Get(ts.URL + "/readyz")
+		if err != nil {
+			t.Fatalf("GET /readyz: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `"name":"alpha"`) || !strings.Contains(string(body), `"name":"beta"`) {
+			t.Errorf("GET /readyz: status %d, body %s", resp.StatusCode, body)
+		}
+	})
+
+	const runLs = `{"jsonrpc": "2.0", "id": 1, "method": "tools/call", "params": {"name": "run", "arguments": {"commands": ["ls"]}}}`
+
+	t.Run("tenant policies", func(t *testing.T) {
+		_, body := post(t, ts.URL, openSession(t, ts.URL, "token-a"), "token-a", runLs)
+		if !strings.Contains(body, "file.txt") {
+			t.Errorf("alpha may run ls, got %s", body)
+		}
+		_, body = post(t, ts.URL, openSession(t, ts.URL, "token-b"), "token-b", runLs)
+		if strings.Contains(body, "file.txt") || !strings.Contains(body, "not permitted") {
+			t.Errorf("beta may not run ls, got %s", body)
+		}
+	})
+
+	t.Run("sessions are isolated", func(t *testing.T) {
+		endpoint := openSession(t, ts.URL, "token-a")
+		_, body := post(t, ts.URL, endpoint, "token-b", runLs)
+		if !strings.Contains(body, "Invalid session ID") {
+			t.Errorf("beta used a session of alpha, got %s", body)
+		}
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		writeTenantFiles(t, dir, "beta", "ls", workDir, "token-b")
+		if _, err := registry.Reload(); err != nil {
+			t.Fatalf("Reload: %v", err)
+		}
+		if err := tenantServer.Update(registry.Tenants()); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		_, body := post(t, ts.URL, openSession(t, ts.URL, "token-b"), "token-b", runLs)
+		if !strings.Contains(body, "file.txt") {
+			t.Errorf("beta may run ls after the reload, got %s", body)
+		}
+	})
+}